@@ -0,0 +1,312 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"mime"
+	"net/http"
+	"net/mail"
+	"net/smtp"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	eventSearchAlert = "search.alert"
+
+	defaultAlertInterval = 60
+	alertCheckInterval   = time.Minute
+	alertWebhookTimeout  = 10 * time.Second
+)
+
+// SearchAlert runs a saved search on a schedule and notifies about pages
+// that newly match it: always as search.alert events on /events, and
+// optionally by webhook and email.
+type SearchAlert struct {
+	// IntervalMinutes is how often the search runs, hourly by default.
+	IntervalMinutes int    `json:"intervalMinutes"`
+	Webhook         string `json:"webhook,omitempty"`
+	Email           string `json:"email,omitempty"`
+
+	// LastRun is when the search last ran. Seen holds the IDs of the
+	// newest matches then, and Since the oldest of their times if there
+	// were more matches than a search returns, so pages that move back
+	// into the newest results aren't mistaken for new ones.
+	LastRun time.Time `json:"lastRun"`
+	Seen    []string  `json:"seen,omitempty"`
+	Since   time.Time `json:"since"`
+}
+
+// validate checks an alert's settings, filling in the default interval and
+// clearing its state so the next run only records the current matches.
+func (a *SearchAlert) validate() error {
+	if a.IntervalMinutes == 0 {
+		a.IntervalMinutes = defaultAlertInterval
+	}
+	if a.IntervalMinutes < 1 {
+		return fmt.Errorf("alert intervalMinutes must be positive")
+	}
+	if a.Webhook != "" {
+		if u, err := url.Parse(a.Webhook); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("alert webhook must be an absolute http(s) URL")
+		}
+	}
+	if a.Email != "" {
+		if config.SMTP.Host == "" {
+			return fmt.Errorf("alert emails need smtp to be configured")
+		}
+		if _, err := mail.ParseAddress(a.Email); err != nil {
+			return fmt.Errorf("alert email is not a valid address")
+		}
+	}
+	a.LastRun, a.Seen, a.Since = time.Time{}, nil, time.Time{}
+	return nil
+}
+
+// due reports whether the alert should run at now.
+func (a *SearchAlert) due(now time.Time) bool {
+	return now.Sub(a.LastRun) >= time.Duration(a.IntervalMinutes)*time.Minute
+}
+
+// webhookClient delivers alert webhooks. Webhook URLs are set through the
+// API, so like captures they may only reach public addresses unless
+// alerts.allowPrivateWebhooks is set.
+func webhookClient() *http.Client {
+	if config.Alerts.AllowPrivateWebhooks {
+		return &http.Client{Timeout: alertWebhookTimeout}
+	}
+	return archiveClient
+}
+
+// watchSearchAlerts runs due alerts every minute.
+func watchSearchAlerts() {
+	for {
+		time.Sleep(alertCheckInterval)
+		runDueAlerts(time.Now())
+	}
+}
+
+// runDueAlerts runs every due alert and sends its notifications. The
+// searches run without holding savedSearchesMu; afterwards only the state of
+// alerts that still exist is updated, so concurrent edits aren't lost.
+func runDueAlerts(now time.Time) {
+	savedSearchesMu.Lock()
+	searches, err := readSavedSearches()
+	savedSearchesMu.Unlock()
+	if err != nil {
+		log.Printf("Error reading saved searches: %v", err)
+		return
+	}
+
+	updated := make(map[string]SearchAlert)
+	for _, search := range searches {
+		if search.Alert == nil || !search.Alert.due(now) {
+			continue
+		}
+		alert, fresh, err := checkAlert(search, now)
+		if err != nil {
+			log.Printf("Error running alert for saved search %q: %v", search.Name, err)
+			continue
+		}
+		updated[search.ID] = alert
+		if len(fresh) > 0 {
+			notifyAlert(search, fresh)
+		}
+	}
+	if len(updated) == 0 {
+		return
+	}
+
+	savedSearchesMu.Lock()
+	defer savedSearchesMu.Unlock()
+	searches, err = readSavedSearches()
+	if err != nil {
+		log.Printf("Error reading saved searches: %v", err)
+		return
+	}
+	for i, search := range searches {
+		if state, ok := updated[search.ID]; ok && search.Alert != nil {
+			searches[i].Alert.LastRun, searches[i].Alert.Seen, searches[i].Alert.Since = state.LastRun, state.Seen, state.Since
+		}
+	}
+	if err := writeSavedSearches(searches); err != nil {
+		log.Printf("Error saving alert state: %v", err)
+	}
+}
+
+// checkAlert runs a saved search newest first, returning the alert's new
+// state and the matches that weren't there last time. The first run only
+// records the current matches.
+func checkAlert(search SavedSearch, now time.Time) (SearchAlert, []SearchResult, error) {
+	alert := *search.Alert
+	options := search.options()
+	options.Sort, options.Highlight = "-time", "none"
+	ctx, span := startSpan(context.Background(), "search.alert")
+	defer span.End()
+	span.SetAttribute("saved_search.id", search.ID)
+
+	results, err := searchPages(ctx, search.Query, options, Localizer{Lang: config.Locale})
+	if err != nil {
+		span.RecordError(err)
+		return alert, nil, err
+	}
+
+	seen := make(map[string]bool)
+	for _, id := range alert.Seen {
+		seen[id] = true
+	}
+	var fresh []SearchResult
+	if !alert.LastRun.IsZero() {
+		for _, result := range results {
+			if !seen[result.ID] && result.Time.After(alert.Since) {
+				fresh = append(fresh, result)
+			}
+		}
+	}
+
+	alert.LastRun, alert.Seen, alert.Since = now, nil, time.Time{}
+	for _, result := range results {
+		alert.Seen = append(alert.Seen, result.ID)
+	}
+	if len(results) >= searchResultLimit {
+		alert.Since = results[len(results)-1].Time
+	}
+	return alert, fresh, nil
+}
+
+// notifyAlert announces new matches of a saved search on every channel the
+// alert uses, logging failures.
+func notifyAlert(search SavedSearch, fresh []SearchResult) {
+	log.Printf("Saved search %q has %d new matches", search.Name, len(fresh))
+	for _, result := range fresh {
+		events.publish(Event{Type: eventSearchAlert, PageID: result.ID, URL: result.URL, Title: result.Title, Message: search.Name})
+	}
+	if search.Alert.Webhook != "" {
+		if err := sendAlertWebhook(search, fresh); err != nil {
+			log.Printf("Error sending alert webhook for %q: %v", search.Name, err)
+			publishError("", fmt.Errorf("alert webhook for %q: %v", search.Name, err))
+		}
+	}
+	if search.Alert.Email != "" {
+		if err := sendAlertEmail(search, fresh); err != nil {
+			log.Printf("Error sending alert email for %q: %v", search.Name, err)
+			publishError("", fmt.Errorf("alert email for %q: %v", search.Name, err))
+		}
+	}
+}
+
+// sendAlertWebhook posts the saved search and its new matches as JSON.
+func sendAlertWebhook(search SavedSearch, fresh []SearchResult) error {
+	body, err := json.Marshal(map[string]interface{}{
+		"savedSearch": map[string]string{"id": search.ID, "name": search.Name, "query": search.Query},
+		"results":     fresh,
+	})
+	if err != nil {
+		return err
+	}
+	resp, err := webhookClient().Post(search.Alert.Webhook, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
+
+// sendAlertEmail mails a plain text list of the new matches.
+func sendAlertEmail(search SavedSearch, fresh []SearchResult) error {
+	subject := fmt.Sprintf("Memento: %d new pages for %q", len(fresh), search.Name)
+	var body strings.Builder
+	for _, result := range fresh {
+		fmt.Fprintf(&body, "%s\r\n%s\r\n\r\n", strings.TrimSpace(result.Title), result.URL)
+	}
+	return sendMail([]string{search.Alert.Email}, subject, body.String())
+}
+
+// sendMail sends a plain text email through the configured SMTP server.
+func sendMail(to []string, subject, body string) error {
+	smtpConfig := config.SMTP
+	from := smtpConfig.From
+	if from == "" {
+		from = smtpConfig.Username
+	}
+
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\nTo: %s\r\nSubject: %s\r\n", from, strings.Join(to, ", "), mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&msg, "MIME-Version: 1.0\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n")
+	msg.WriteString(body)
+
+	var auth smtp.Auth
+	if smtpConfig.Username != "" {
+		password, err := smtpConfig.Password.Value()
+		if err != nil {
+			return fmt.Errorf("reading smtp password: %v", err)
+		}
+		auth = smtp.PlainAuth("", smtpConfig.Username, password, smtpConfig.Host)
+	}
+	addr := fmt.Sprintf("%s:%d", smtpConfig.Host, smtpConfig.Port)
+	return smtp.SendMail(addr, auth, from, to, msg.Bytes())
+}
+
+// handleSavedSearchAlert sets up or replaces a saved search's alert with
+// PUT, or removes it with DELETE.
+func handleSavedSearchAlert(w http.ResponseWriter, r *http.Request) {
+	var alert *SearchAlert
+	switch r.Method {
+	case http.MethodPut:
+		alert = &SearchAlert{}
+		if err := json.NewDecoder(r.Body).Decode(alert); err != nil {
+			writeError(w, http.StatusBadRequest, ErrInvalidRequest, "Invalid request body")
+			return
+		}
+		if err := alert.validate(); err != nil {
+			writeError(w, http.StatusBadRequest, ErrInvalidRequest, err.Error())
+			return
+		}
+	case http.MethodDelete:
+	default:
+		writeError(w, http.StatusMethodNotAllowed, ErrMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	search, ok, err := setSavedSearchAlert(r.PathValue("id"), alert)
+	if err != nil {
+		log.Printf("Error saving alert: %v", err)
+		writeError(w, http.StatusInternalServerError, ErrInternal, "Failed to save alert")
+		return
+	}
+	if !ok {
+		writeError(w, http.StatusNotFound, ErrNotFound, "Saved search not found")
+		return
+	}
+	if alert == nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(search)
+}
+
+// setSavedSearchAlert replaces a saved search's alert, or removes it when
+// alert is nil.
+func setSavedSearchAlert(id string, alert *SearchAlert) (SavedSearch, bool, error) {
+	savedSearchesMu.Lock()
+	defer savedSearchesMu.Unlock()
+	searches, err := readSavedSearches()
+	if err != nil {
+		return SavedSearch{}, false, err
+	}
+	for i := range searches {
+		if searches[i].ID == id {
+			searches[i].Alert = alert
+			return searches[i], true, writeSavedSearches(searches)
+		}
+	}
+	return SavedSearch{}, false, nil
+}
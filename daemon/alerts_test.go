@@ -0,0 +1,189 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSearchAlertValidate(t *testing.T) {
+	savedSMTP := config.SMTP
+	defer func() { config.SMTP = savedSMTP }()
+
+	tests := []struct {
+		name    string
+		alert   SearchAlert
+		smtp    string
+		wantErr bool
+	}{
+		{"defaults", SearchAlert{}, "", false},
+		{"negative interval", SearchAlert{IntervalMinutes: -5}, "", true},
+		{"webhook", SearchAlert{Webhook: "https://hooks.example.com/memento"}, "", false},
+		{"relative webhook", SearchAlert{Webhook: "/hook"}, "", true},
+		{"ftp webhook", SearchAlert{Webhook: "ftp://example.com/"}, "", true},
+		{"email without smtp", SearchAlert{Email: "me@example.com"}, "", true},
+		{"email", SearchAlert{Email: "me@example.com"}, "smtp.example.com", false},
+		{"bad email", SearchAlert{Email: "not an address"}, "smtp.example.com", true},
+	}
+	for _, tt := range tests {
+		config.SMTP.Host = tt.smtp
+		alert := tt.alert
+		alert.LastRun, alert.Seen = time.Now(), []string{"old"}
+		err := alert.validate()
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: validate() error = %v, wantErr %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if err == nil && (alert.IntervalMinutes <= 0 || !alert.LastRun.IsZero() || alert.Seen != nil) {
+			t.Errorf("%s: validate() left %+v, want defaults and cleared state", tt.name, alert)
+		}
+	}
+}
+
+func TestCheckAlert(t *testing.T) {
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	docs := map[string]PageDocument{}
+	for i := 0; i < searchResultLimit+5; i++ {
+		docs[fmt.Sprintf("old%02d", i)] = PageDocument{URL: fmt.Sprintf("https://example.com/%d", i), Title: "Go", Content: "golang", Time: base.Add(time.Duration(i) * time.Hour)}
+	}
+	withIndex(t, docs)
+	search := SavedSearch{ID: "s", Name: "Go", Query: "golang", Alert: &SearchAlert{IntervalMinutes: 60}}
+
+	now := base.AddDate(0, 1, 0)
+	alert, fresh, err := checkAlert(search, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fresh) != 0 || len(alert.Seen) != searchResultLimit || !alert.LastRun.Equal(now) {
+		t.Fatalf("first run = %+v with %d fresh, want a baseline only", alert, len(fresh))
+	}
+	if alert.Since.IsZero() {
+		t.Error("a full page of matches should record Since")
+	}
+
+	// A new match is reported; an old one that moves back into the newest
+	// results after a delete isn't
+	index.Index("new", PageDocument{URL: "https://example.com/new", Title: "New", Content: "golang", Time: now})
+	index.Delete(alert.Seen[0])
+	search.Alert = &alert
+	alert, fresh, err = checkAlert(search, now.Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fresh) != 1 || fresh[0].ID != "new" {
+		t.Errorf("second run fresh = %+v, want only the new page", fresh)
+	}
+
+	search.Alert = &alert
+	if _, fresh, _ = checkAlert(search, now.Add(2*time.Hour)); len(fresh) != 0 {
+		t.Errorf("third run fresh = %+v, want none", fresh)
+	}
+}
+
+func TestRunDueAlerts(t *testing.T) {
+	withPagesDir(t)
+	withIndex(t, map[string]PageDocument{
+		"old": {URL: "https://example.com/old", Title: "Old", Content: "golang", Time: time.Now().Add(-time.Hour)},
+	})
+	savedAlerts := config.Alerts
+	config.Alerts.AllowPrivateWebhooks = true
+	defer func() { config.Alerts = savedAlerts }()
+
+	hooks := make(chan string, 10)
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		hooks <- string(body)
+	}))
+	defer hook.Close()
+
+	search := SavedSearch{Name: "Go", Query: "golang", Alert: &SearchAlert{Webhook: hook.URL}}
+	if err := search.validate(); err != nil {
+		t.Fatal(err)
+	}
+	search, err := createSavedSearch(search)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ch := events.subscribe()
+	defer events.unsubscribe(ch)
+
+	now := time.Now()
+	runDueAlerts(now)
+	if stored, _, _ := findSavedSearch(search.ID); !stored.Alert.LastRun.Equal(now) || len(stored.Alert.Seen) != 1 {
+		t.Fatalf("alert state after baseline = %+v", stored.Alert)
+	}
+
+	index.Index("new", PageDocument{URL: "https://example.com/new", Title: "New", Content: "golang", Time: now})
+	// Not due yet
+	runDueAlerts(now.Add(time.Minute))
+	if len(hooks) != 0 {
+		t.Fatal("alert ran before its interval")
+	}
+
+	runDueAlerts(now.Add(time.Hour))
+	select {
+	case body := <-hooks:
+		if !strings.Contains(body, `"name":"Go"`) || !strings.Contains(body, "https://example.com/new") || strings.Contains(body, "https://example.com/old") {
+			t.Errorf("webhook body = %s", body)
+		}
+	default:
+		t.Fatal("webhook wasn't called")
+	}
+	select {
+	case event := <-ch:
+		if event.Type != eventSearchAlert || event.PageID != "new" || event.Message != "Go" {
+			t.Errorf("event = %+v", event)
+		}
+	default:
+		t.Error("no search.alert event")
+	}
+}
+
+func TestHandleSavedSearchAlert(t *testing.T) {
+	withPagesDir(t)
+	mux := savedSearchMux()
+	mux.HandleFunc("/saved-searches/{id}/alert", handleSavedSearchAlert)
+	search, err := createSavedSearch(SavedSearch{Name: "Go", Query: "golang"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		method string
+		id     string
+		body   string
+		status int
+		alert  bool
+	}{
+		{http.MethodPut, search.ID, `{"intervalMinutes": 15}`, http.StatusOK, true},
+		{http.MethodPut, search.ID, `{"intervalMinutes": -1}`, http.StatusBadRequest, true},
+		{http.MethodPut, search.ID, `{`, http.StatusBadRequest, true},
+		{http.MethodPut, "missing", `{}`, http.StatusNotFound, true},
+		{http.MethodGet, search.ID, ``, http.StatusMethodNotAllowed, true},
+		{http.MethodDelete, search.ID, ``, http.StatusNoContent, false},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(tt.method, "/saved-searches/"+tt.id+"/alert", strings.NewReader(tt.body)))
+		if rec.Code != tt.status {
+			t.Errorf("%s %s alert = %d %s, want %d", tt.method, tt.id, rec.Code, rec.Body, tt.status)
+		}
+		stored, _, _ := findSavedSearch(search.ID)
+		if (stored.Alert != nil) != tt.alert {
+			t.Errorf("after %s %s alert = %+v, want set %v", tt.method, tt.id, stored.Alert, tt.alert)
+		}
+	}
+
+	var stored SavedSearch
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodPut, "/saved-searches/"+search.ID+"/alert", strings.NewReader(`{}`)))
+	json.Unmarshal(rec.Body.Bytes(), &stored)
+	if stored.Alert == nil || stored.Alert.IntervalMinutes != defaultAlertInterval {
+		t.Errorf("PUT {} alert = %+v, want the default interval", stored.Alert)
+	}
+}
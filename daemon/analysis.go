@@ -0,0 +1,251 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/blevesearch/bleve"
+	"github.com/blevesearch/bleve/analysis"
+	"github.com/blevesearch/bleve/analysis/analyzer/custom"
+	"github.com/blevesearch/bleve/analysis/lang/cjk"
+	"github.com/blevesearch/bleve/analysis/lang/en"
+	"github.com/blevesearch/bleve/analysis/token/lowercase"
+	"github.com/blevesearch/bleve/analysis/token/porter"
+	"github.com/blevesearch/bleve/analysis/token/stop"
+	"github.com/blevesearch/bleve/analysis/tokenizer/unicode"
+	"github.com/blevesearch/bleve/analysis/tokenmap"
+	"github.com/blevesearch/bleve/mapping"
+	"github.com/blevesearch/bleve/registry"
+)
+
+// AnalysisConfig tunes how text is broken into the terms pages are indexed
+// and searched by. The index is rebuilt when the daemon starts with
+// different settings than it was built with.
+type AnalysisConfig struct {
+	// Stemming indexes English words by their stems, so that "running"
+	// also finds "runs".
+	Stemming bool `json:"stemming"`
+	// StopWords are left out of the index and of queries, in place of
+	// bleve's English list. An empty list keeps every word.
+	StopWords []string `json:"stopWords"`
+	// Synonyms maps a word to others meaning the same, such as
+	// {"kubernetes": ["k8s", "kube"]}, so that each finds pages with any of
+	// them. Each is a single word.
+	Synonyms map[string][]string `json:"synonyms"`
+}
+
+// Names of the analysis components defined in the index mapping.
+const (
+	// contentAnalyzerName is the analyzer the index's text fields use.
+	contentAnalyzerName = "memento"
+	// exactAnalyzerName analyzes text like contentAnalyzerName without
+	// stemming, for exact searches.
+	exactAnalyzerName = "memento_exact"
+	stopWordsName     = "memento_stop_words"
+	stopFilterName    = "memento_stop"
+	synonymFilterName = "memento_synonyms"
+)
+
+// analysisFile, in the index directory, records the analysis the index was
+// built with, so that it is built again when the analysis changes.
+var analysisFile = filepath.Join(indexDir, "analysis.json")
+
+// textFields are the fields searches match the words of, indexed with their
+// terms' positions so that phrases can be found. When stemming is on, each
+// is also indexed without it as exactField.
+var textFields = []string{"title", "content"}
+
+// exactField names the field text is indexed in without stemming.
+func exactField(field string) string {
+	return "exact_" + field
+}
+
+// indexAnalysis is the custom analysis an index is built with, in the form
+// bleve keeps it in the index mapping.
+type indexAnalysis struct {
+	Analyzer     map[string]interface{}            `json:"analyzer"`
+	Exact        map[string]interface{}            `json:"exact,omitempty"`
+	TokenMaps    map[string]map[string]interface{} `json:"tokenMaps,omitempty"`
+	TokenFilters map[string]map[string]interface{} `json:"tokenFilters,omitempty"`
+}
+
+// currentAnalysis defines contentAnalyzerName from the config. Words are
+// split and lowercased as by bleve's standard analyzer, then replaced by
+// their synonyms' shared term, stop words dropped and, with stemming, words
+// stemmed. Runs of Chinese, Japanese and Korean characters, which aren't
+// separated by spaces, are indexed as overlapping pairs of characters, so
+// that words within them can be found.
+func currentAnalysis() indexAnalysis {
+	c := config.Search.Analysis
+	a := indexAnalysis{TokenMaps: map[string]map[string]interface{}{}, TokenFilters: map[string]map[string]interface{}{}}
+	filters := []string{cjk.WidthName, lowercase.Name}
+	if synonyms := synonymTerms(c.Synonyms); len(synonyms) > 0 {
+		a.TokenFilters[synonymFilterName] = map[string]interface{}{"type": synonymFilterName, "synonyms": synonyms}
+		filters = append(filters, synonymFilterName)
+	}
+	switch {
+	case c.StopWords == nil:
+		filters = append(filters, en.StopName)
+	case len(c.StopWords) > 0:
+		tokens := make([]interface{}, len(c.StopWords))
+		for i, word := range c.StopWords {
+			tokens[i] = strings.ToLower(word)
+		}
+		a.TokenMaps[stopWordsName] = map[string]interface{}{"type": tokenmap.Name, "tokens": tokens}
+		a.TokenFilters[stopFilterName] = map[string]interface{}{"type": stop.Name, "stop_token_map": stopWordsName}
+		filters = append(filters, stopFilterName)
+	}
+	analyzer := func(filters ...string) map[string]interface{} {
+		return map[string]interface{}{
+			"type":          custom.Name,
+			"tokenizer":     unicode.Name,
+			"token_filters": filters,
+		}
+	}
+	if c.Stemming {
+		a.Exact = analyzer(append(filters[:len(filters):len(filters)], cjk.BigramName)...)
+		filters = append(filters, porter.Name)
+	}
+	a.Analyzer = analyzer(append(filters, cjk.BigramName)...)
+	return a
+}
+
+// synonymTerms maps every word of each synonym group, lowercased, to the
+// group's first word, the term they are all indexed and searched as.
+func synonymTerms(synonyms map[string][]string) map[string]interface{} {
+	terms := make(map[string]interface{})
+	for word, others := range synonyms {
+		term := strings.ToLower(word)
+		for _, other := range others {
+			terms[strings.ToLower(other)] = term
+		}
+	}
+	return terms
+}
+
+// validateSynonyms checks that synonyms are single words, each in one
+// group only.
+func validateSynonyms(synonyms map[string][]string) error {
+	group := make(map[string]string)
+	for word, others := range synonyms {
+		for _, w := range append([]string{word}, others...) {
+			if len(strings.Fields(w)) != 1 || w != strings.TrimSpace(w) {
+				return fmt.Errorf("search.analysis.synonyms: %q is not a single word", w)
+			}
+			if g, ok := group[strings.ToLower(w)]; ok && g != word {
+				return fmt.Errorf("search.analysis.synonyms: %q is a synonym of both %q and %q", w, g, word)
+			}
+			group[strings.ToLower(w)] = word
+		}
+	}
+	return nil
+}
+
+// synonymFilter replaces the terms of words with synonyms by their group's
+// term.
+type synonymFilter struct {
+	terms map[string]string
+}
+
+func (f *synonymFilter) Filter(input analysis.TokenStream) analysis.TokenStream {
+	for _, token := range input {
+		if term, ok := f.terms[string(token.Term)]; ok {
+			token.Term = []byte(term)
+		}
+	}
+	return input
+}
+
+func init() {
+	registry.RegisterTokenFilter(synonymFilterName, func(config map[string]interface{}, cache *registry.Cache) (analysis.TokenFilter, error) {
+		synonyms, ok := config["synonyms"].(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("must specify synonyms")
+		}
+		f := &synonymFilter{terms: make(map[string]string, len(synonyms))}
+		for word, term := range synonyms {
+			if f.terms[word], ok = term.(string); !ok {
+				return nil, fmt.Errorf("synonym of %q must be a string", word)
+			}
+		}
+		return f, nil
+	})
+}
+
+// newIndexMapping returns the mapping new indexes are created with.
+func newIndexMapping() (*mapping.IndexMappingImpl, error) {
+	m := bleve.NewIndexMapping()
+	a := currentAnalysis()
+	for name, c := range a.TokenMaps {
+		if err := m.AddCustomTokenMap(name, c); err != nil {
+			return nil, err
+		}
+	}
+	for name, c := range a.TokenFilters {
+		if err := m.AddCustomTokenFilter(name, c); err != nil {
+			return nil, err
+		}
+	}
+	if err := m.AddCustomAnalyzer(contentAnalyzerName, a.Analyzer); err != nil {
+		return nil, err
+	}
+	m.DefaultAnalyzer = contentAnalyzerName
+	if a.Exact != nil {
+		if err := m.AddCustomAnalyzer(exactAnalyzerName, a.Exact); err != nil {
+			return nil, err
+		}
+	}
+	for _, field := range textFields {
+		text := bleve.NewTextFieldMapping()
+		text.IncludeTermVectors = true
+		fields := []*mapping.FieldMapping{text}
+		if a.Exact != nil {
+			exact := bleve.NewTextFieldMapping()
+			exact.Name = exactField(field)
+			exact.Analyzer = exactAnalyzerName
+			exact.Store = false
+			exact.IncludeInAll = false
+			exact.DocValues = false
+			fields = append(fields, exact)
+		}
+		m.DefaultMapping.AddFieldMappingsAt(field, fields...)
+	}
+	return m, nil
+}
+
+// analysisCurrent reports whether the index was built with the analysis
+// currentAnalysis defines. Indexes from before it was recorded used bleve's
+// standard analyzer.
+func analysisCurrent() bool {
+	data, err := ioutil.ReadFile(analysisFile)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("Error reading %s: %v", analysisFile, err)
+		}
+		return false
+	}
+	current, err := json.Marshal(currentAnalysis())
+	if err != nil {
+		return false
+	}
+	var recorded indexAnalysis
+	if err := json.Unmarshal(data, &recorded); err != nil {
+		return false
+	}
+	data, err = json.Marshal(recorded)
+	return err == nil && string(data) == string(current)
+}
+
+// writeAnalysis records the analysis a new index is built with.
+func writeAnalysis() error {
+	data, err := json.MarshalIndent(currentAnalysis(), "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(analysisFile, data, 0644)
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestSearchCJK(t *testing.T) {
+	withIndex(t, map[string]PageDocument{
+		"ja": {URL: "https://example.jp/", Title: "東京タワー", Content: "東京タワーは港区にある電波塔です。"},
+		"zh": {URL: "https://example.cn/", Title: "秋天", Content: "我喜欢北京的秋天。"},
+		"en": {URL: "https://example.com/", Title: "Towers", Content: "The radio towers of the world"},
+	})
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{"港区", "ja"},
+		{"電波塔", "ja"},
+		{"北京", "zh"},
+		{"ｔｏｗｅｒｓ", "en"},
+	}
+	for _, tt := range tests {
+		results, err := searchPages(context.Background(), tt.query, SearchOptions{Sort: "score", Highlight: "none"}, Localizer{Lang: defaultLocale})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(results) != 1 || results[0].ID != tt.want {
+			t.Errorf("search for %q = %+v, want %s", tt.query, results, tt.want)
+		}
+	}
+}
+
+func TestAnalysisCurrent(t *testing.T) {
+	withPagesDir(t)
+	if err := os.MkdirAll(indexDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if analysisCurrent() {
+		t.Error("an index without a recorded analyzer is current")
+	}
+	if err := writeAnalysis(); err != nil {
+		t.Fatal(err)
+	}
+	if !analysisCurrent() {
+		t.Error("the recorded analyzer is not current")
+	}
+	if err := os.WriteFile(analysisFile, []byte(`{"type": "standard"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if analysisCurrent() {
+		t.Error("another analyzer is current")
+	}
+
+	// Changing the analysis config makes the index out of date
+	if err := writeAnalysis(); err != nil {
+		t.Fatal(err)
+	}
+	saved := config.Search.Analysis
+	defer func() { config.Search.Analysis = saved }()
+	config.Search.Analysis.Stemming = true
+	if analysisCurrent() {
+		t.Error("the analyzer is current after stemming was turned on")
+	}
+}
+
+func TestSearchAnalysisConfig(t *testing.T) {
+	saved := config.Search.Analysis
+	defer func() { config.Search.Analysis = saved }()
+	config.Search.Analysis = AnalysisConfig{
+		Stemming:  true,
+		StopWords: []string{"Memento"},
+		Synonyms:  map[string][]string{"Kubernetes": {"k8s", "kube"}},
+	}
+	withIndex(t, map[string]PageDocument{
+		"k8s":     {URL: "https://example.com/k8s", Title: "Clusters", Content: "Running workloads on K8s"},
+		"memento": {URL: "https://example.com/memento", Title: "Memento", Content: "The archive is at the library"},
+	})
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"kubernetes", []string{"k8s"}},
+		{"kube", []string{"k8s"}},
+		{"runs", []string{"k8s"}},
+		{"memento", nil},
+		{"the archive", []string{"memento"}},
+	}
+	for _, tt := range tests {
+		results, err := searchPages(context.Background(), tt.query, SearchOptions{Sort: "score", Highlight: "none"}, Localizer{Lang: defaultLocale})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, result := range results {
+			got = append(got, result.ID)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("search for %q = %v, want %v", tt.query, got, tt.want)
+		}
+	}
+
+	if err := validateSynonyms(map[string][]string{"kubernetes": {"k8s"}, "k8s cluster": {"cluster"}}); err == nil {
+		t.Error("a synonym of two words is valid")
+	}
+	if err := validateSynonyms(map[string][]string{"kubernetes": {"k8s"}, "k3s": {"K8s"}}); err == nil {
+		t.Error("a word in two synonym groups is valid")
+	}
+}
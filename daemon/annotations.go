@@ -0,0 +1,123 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// maxAnnotationLength bounds the text and quote of an annotation.
+const maxAnnotationLength = 10000
+
+var errAnnotationNotFound = errors.New("annotation not found")
+
+// Annotation is a note on a page, optionally about a passage of it.
+type Annotation struct {
+	ID   string `json:"id"`
+	Text string `json:"text"`
+	// Quote is the passage the note is about, and Selector locates it in
+	// whatever form the client uses, such as a W3C text quote selector.
+	Quote    string          `json:"quote,omitempty"`
+	Selector json.RawMessage `json:"selector,omitempty"`
+	Created  time.Time       `json:"created"`
+}
+
+// annotationText joins the notes of a page for indexing, so that searches
+// for what was written about a page find it.
+func annotationText(annotations []Annotation) string {
+	texts := make([]string, len(annotations))
+	for i, annotation := range annotations {
+		texts[i] = annotation.Text
+	}
+	return strings.Join(texts, "\n")
+}
+
+// handlePageAnnotations lists a page's annotations on GET, and on POST adds
+// one given as {"text": "...", "quote": "...", "selector": {...}}.
+func handlePageAnnotations(w http.ResponseWriter, r *http.Request) {
+	page, err := loadPage(r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusNotFound, ErrNotFound, "Page not found")
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		annotations := page.Annotations
+		if annotations == nil {
+			annotations = []Annotation{}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(annotations)
+	case http.MethodPost:
+		var annotation Annotation
+		if err := json.NewDecoder(r.Body).Decode(&annotation); err != nil {
+			writeError(w, http.StatusBadRequest, ErrInvalidRequest, "Invalid request body")
+			return
+		}
+		annotation.Text = strings.TrimSpace(annotation.Text)
+		if annotation.Text == "" {
+			writeError(w, http.StatusBadRequest, ErrInvalidRequest, "An annotation needs text")
+			return
+		}
+		if len(annotation.Text) > maxAnnotationLength || len(annotation.Quote) > maxAnnotationLength {
+			writeError(w, http.StatusBadRequest, ErrInvalidRequest, "Annotation text and quote must be at most 10000 bytes")
+			return
+		}
+		id := make([]byte, 8)
+		rand.Read(id)
+		annotation.ID = hex.EncodeToString(id)
+		annotation.Created = time.Now()
+		err := reindexWith(r.Context(), page.ID, func(metadata *PageMetadata) error {
+			metadata.Annotations = append(metadata.Annotations, annotation)
+			return nil
+		})
+		if err != nil {
+			log.Printf("Error annotating page %s: %v", page.ID, err)
+			writeError(w, http.StatusInternalServerError, ErrInternal, "Failed to save annotation")
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(annotation)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, ErrMethodNotAllowed, "Method not allowed")
+	}
+}
+
+// handlePageAnnotation deletes one of a page's annotations.
+func handlePageAnnotation(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		writeError(w, http.StatusMethodNotAllowed, ErrMethodNotAllowed, "Method not allowed")
+		return
+	}
+	page, err := loadPage(r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusNotFound, ErrNotFound, "Page not found")
+		return
+	}
+	annotationID := r.PathValue("annotation")
+	err = reindexWith(r.Context(), page.ID, func(metadata *PageMetadata) error {
+		for i, annotation := range metadata.Annotations {
+			if annotation.ID == annotationID {
+				metadata.Annotations = append(metadata.Annotations[:i], metadata.Annotations[i+1:]...)
+				return nil
+			}
+		}
+		return errAnnotationNotFound
+	})
+	if errors.Is(err, errAnnotationNotFound) {
+		writeError(w, http.StatusNotFound, ErrNotFound, "Annotation not found")
+		return
+	}
+	if err != nil {
+		log.Printf("Error deleting annotation of page %s: %v", page.ID, err)
+		writeError(w, http.StatusInternalServerError, ErrInternal, "Failed to delete annotation")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestPageAnnotations(t *testing.T) {
+	withPagesDir(t)
+	withIndex(t, nil)
+	id, err := storePage(PageMetadata{URL: "https://ci.example/runs/42", Title: "Run 42"}, "<p>Build passed on retry</p>", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	indexExistingFiles(context.Background())
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/pages/{id}", handlePage)
+	mux.HandleFunc("/pages/{id}/annotations", handlePageAnnotations)
+	mux.HandleFunc("/pages/{id}/annotations/{annotation}", handlePageAnnotation)
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(method, path, strings.NewReader(body)))
+		return rec
+	}
+
+	tests := []struct {
+		name string
+		body string
+		want int
+	}{
+		{"note", `{"text": "Flaky integration tests again"}`, http.StatusCreated},
+		{"quote", `{"text": "See the retry", "quote": "passed on retry", "selector": {"type": "TextQuoteSelector", "exact": "passed on retry"}}`, http.StatusCreated},
+		{"no text", `{"text": "  ", "quote": "Build"}`, http.StatusBadRequest},
+		{"too long", `{"text": "` + strings.Repeat("a", maxAnnotationLength+1) + `"}`, http.StatusBadRequest},
+		{"invalid body", `{`, http.StatusBadRequest},
+	}
+	var created []Annotation
+	for _, tt := range tests {
+		rec := do(http.MethodPost, "/pages/"+id+"/annotations", tt.body)
+		if rec.Code != tt.want {
+			t.Errorf("%s: status = %d, want %d: %s", tt.name, rec.Code, tt.want, rec.Body)
+			continue
+		}
+		if rec.Code == http.StatusCreated {
+			var annotation Annotation
+			json.Unmarshal(rec.Body.Bytes(), &annotation)
+			created = append(created, annotation)
+		}
+	}
+	if rec := do(http.MethodPost, "/pages/missing/annotations", `{"text": "x"}`); rec.Code != http.StatusNotFound {
+		t.Errorf("annotating a missing page: status = %d", rec.Code)
+	}
+
+	var page Page
+	json.Unmarshal(do(http.MethodGet, "/pages/"+id, "").Body.Bytes(), &page)
+	if len(page.Annotations) != 2 || page.Annotations[1].Quote != "passed on retry" || len(page.Annotations[1].Selector) == 0 {
+		t.Fatalf("annotations = %+v", page.Annotations)
+	}
+	if got := searchURLs(t, "flaky tests", SearchOptions{}); len(got) != 1 {
+		t.Errorf("search for the note = %v", got)
+	}
+
+	if rec := do(http.MethodDelete, "/pages/"+id+"/annotations/"+created[0].ID, ""); rec.Code != http.StatusNoContent {
+		t.Errorf("delete: status = %d", rec.Code)
+	}
+	if rec := do(http.MethodDelete, "/pages/"+id+"/annotations/"+created[0].ID, ""); rec.Code != http.StatusNotFound {
+		t.Errorf("deleting again: status = %d", rec.Code)
+	}
+	var annotations []Annotation
+	json.Unmarshal(do(http.MethodGet, "/pages/"+id+"/annotations", "").Body.Bytes(), &annotations)
+	if len(annotations) != 1 || annotations[0].ID != created[1].ID {
+		t.Errorf("annotations after delete = %+v", annotations)
+	}
+	if got := searchURLs(t, "flaky", SearchOptions{}); len(got) != 0 {
+		t.Errorf("search for the deleted note = %v", got)
+	}
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"encoding/xml"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// archiveFeedSize is how many of the most recently archived pages the
+	// archive's feed lists, and archiveFeedSummary how many characters of
+	// each page's text it quotes.
+	archiveFeedSize    = 50
+	archiveFeedSummary = 300
+	atomMediaType      = "application/atom+xml"
+	rssMediaType       = "application/rss+xml"
+)
+
+// rssOutput is an RSS 2.0 document of the archive's feed.
+type rssOutput struct {
+	XMLName xml.Name         `xml:"rss"`
+	Version string           `xml:"version,attr"`
+	Channel rssOutputChannel `xml:"channel"`
+}
+
+type rssOutputChannel struct {
+	Title         string          `xml:"title"`
+	Link          string          `xml:"link"`
+	Description   string          `xml:"description"`
+	LastBuildDate string          `xml:"lastBuildDate,omitempty"`
+	Items         []rssOutputItem `xml:"item"`
+}
+
+type rssOutputItem struct {
+	Title       string   `xml:"title"`
+	Link        string   `xml:"link"`
+	GUID        rssGUID  `xml:"guid"`
+	PubDate     string   `xml:"pubDate"`
+	Description string   `xml:"description,omitempty"`
+	Categories  []string `xml:"category,omitempty"`
+}
+
+type rssGUID struct {
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+	Value       string `xml:",chardata"`
+}
+
+// recentlyArchived returns the newest pages visible to the request, those
+// tagged with tag if one is given, leaving out captures replaced by a
+// re-capture and private pages unless privateListed.
+func recentlyArchived(r *http.Request, tag string, limit int) ([]Page, error) {
+	pages, err := listPages()
+	if err != nil {
+		return nil, err
+	}
+	private := privateListed(r)
+	var recent []Page
+	for _, page := range pages {
+		if page.SupersededBy != "" || (page.Private && !private) || !pageVisible(r, page.PageMetadata) {
+			continue
+		}
+		if tag != "" && !hasTag(page.Tags, tag) {
+			continue
+		}
+		recent = append(recent, page)
+	}
+	sort.Slice(recent, func(i, j int) bool {
+		return recent[i].Timestamp.After(recent[j].Timestamp)
+	})
+	if len(recent) > limit {
+		recent = recent[:limit]
+	}
+	return recent, nil
+}
+
+// hasTag reports whether tags holds tag, ignoring case.
+func hasTag(tags []string, tag string) bool {
+	for _, t := range tags {
+		if strings.EqualFold(t, tag) {
+			return true
+		}
+	}
+	return false
+}
+
+// pageSummary is the start of a page's text on one line.
+func pageSummary(page Page) string {
+	text, err := readPageText(page.PageMetadata)
+	if err != nil {
+		return ""
+	}
+	return truncateRunes(strings.Join(strings.Fields(text), " "), archiveFeedSummary)
+}
+
+// handleArchiveFeed serves the most recently archived pages as an Atom
+// feed, or as RSS 2.0 with format=rss, optionally only those with a tag.
+// Atom entries link to the original page and to its archived copy, RSS
+// items to the original.
+func handleArchiveFeed(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+	format := params.Get("format")
+	if format != "" && format != "atom" && format != "rss" {
+		writeError(w, http.StatusBadRequest, ErrInvalidRequest, "format must be atom or rss")
+		return
+	}
+	tag := params.Get("tag")
+	pages, err := recentlyArchived(r, tag, archiveFeedSize)
+	if err != nil {
+		log.Printf("Error listing pages: %v", err)
+		writeError(w, http.StatusInternalServerError, ErrInternal, "Failed to list pages")
+		return
+	}
+
+	localizer := localizerFor(r)
+	title := localizer.T("feed.title")
+	self := "/feed"
+	if tag != "" {
+		title = localizer.T("feed.title.tag", tag)
+		self += "?" + url.Values{"tag": {tag}}.Encode()
+	}
+	base := baseURL(r)
+	// The feed is as new as its newest page
+	updated := time.Now()
+	if len(pages) > 0 {
+		updated = pages[0].Timestamp
+	}
+
+	var doc interface{}
+	if format == "rss" {
+		channel := rssOutputChannel{Title: title, Link: base + "/", Description: title, LastBuildDate: updated.UTC().Format(time.RFC1123Z)}
+		for _, page := range pages {
+			channel.Items = append(channel.Items, rssOutputItem{
+				Title:       page.Title,
+				Link:        page.URL,
+				GUID:        rssGUID{Value: "urn:memento:page:" + page.ID},
+				PubDate:     page.Timestamp.UTC().Format(time.RFC1123Z),
+				Description: pageSummary(page),
+				Categories:  page.Tags,
+			})
+		}
+		w.Header().Set("Content-Type", rssMediaType+"; charset=utf-8")
+		doc = rssOutput{Version: "2.0", Channel: channel}
+	} else {
+		feed := opdsFeed{
+			ID:      "urn:memento:feed",
+			Title:   title,
+			Updated: updated.UTC().Format(time.RFC3339),
+			Links: []opdsLink{
+				{Rel: "self", Href: base + self, Type: atomMediaType},
+				{Rel: "alternate", Href: base + "/", Type: "text/html"},
+			},
+		}
+		if tag != "" {
+			feed.ID += ":tag:" + url.PathEscape(tag)
+		}
+		for _, page := range pages {
+			entry := opdsEntry{
+				ID:      "urn:memento:page:" + page.ID,
+				Title:   page.Title,
+				Updated: page.Timestamp.UTC().Format(time.RFC3339),
+				Summary: pageSummary(page),
+				Links: []opdsLink{
+					{Rel: "alternate", Href: page.URL, Type: "text/html"},
+					{Rel: "related", Href: base + "/pages/" + page.ID + "/read", Type: "text/html"},
+				},
+			}
+			if page.Citation != nil {
+				for _, author := range page.Citation.Authors {
+					entry.Authors = append(entry.Authors, opdsAuthor{Name: author})
+				}
+			}
+			for _, t := range page.Tags {
+				entry.Categories = append(entry.Categories, opdsCategory{Term: t})
+			}
+			feed.Entries = append(feed.Entries, entry)
+		}
+		w.Header().Set("Content-Type", atomMediaType+"; charset=utf-8")
+		doc = feed
+	}
+
+	w.Write([]byte(xml.Header))
+	if err := xml.NewEncoder(w).Encode(doc); err != nil {
+		log.Printf("Error encoding archive feed: %v", err)
+	}
+}
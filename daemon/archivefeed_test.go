@@ -0,0 +1,64 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestArchiveFeed(t *testing.T) {
+	withPagesDir(t)
+	now := time.Now()
+	for _, page := range []PageMetadata{
+		{URL: "https://go.dev/blog/generics", Title: "Generics", Tags: []string{"golang"}, Timestamp: now.Add(-3 * time.Hour)},
+		{URL: "https://example.com/coffee", Title: "Coffee", Timestamp: now.Add(-2 * time.Hour)},
+		{URL: "https://go.dev/blog/loops", Title: "Loops", Tags: []string{"GoLang", "news"}, Timestamp: now.Add(-time.Hour)},
+		{URL: "https://go.dev/blog/old", Title: "Old", Tags: []string{"golang"}, Timestamp: now, SupersededBy: "newer"},
+	} {
+		if _, err := storePage(page, "<p>"+page.Title+" text</p>", ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		query       string
+		contentType string
+		want        []string
+	}{
+		{"", atomMediaType, []string{"Loops", "Coffee", "Generics"}},
+		{"?tag=golang", atomMediaType, []string{"Loops", "Generics"}},
+		{"?tag=golang&format=rss", rssMediaType, []string{"Loops", "Generics"}},
+		{"?tag=recipes", atomMediaType, nil},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		newRouter().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/feed"+tt.query, nil))
+		if !strings.HasPrefix(rec.Header().Get("Content-Type"), tt.contentType) {
+			t.Errorf("%s: Content-Type = %q", tt.query, rec.Header().Get("Content-Type"))
+		}
+		// The feed parses as the feeds the daemon subscribes to do
+		_, entries, err := parseFeed(rec.Body.Bytes(), "http://localhost/feed")
+		if err != nil {
+			t.Fatalf("%s: %v\n%s", tt.query, err, rec.Body)
+		}
+		var titles []string
+		for _, entry := range entries {
+			titles = append(titles, entry.Title)
+			if !strings.HasPrefix(entry.URL, "https://") || !strings.HasPrefix(entry.Key, "urn:memento:page:") {
+				t.Errorf("%s: entry %+v", tt.query, entry)
+			}
+		}
+		if !reflect.DeepEqual(titles, tt.want) {
+			t.Errorf("%s: entries %v, want %v", tt.query, titles, tt.want)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	newRouter().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/feed?format=json", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("format=json = %d, want 400", rec.Code)
+	}
+}
@@ -0,0 +1,363 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const archiveTimeout = 30 * time.Second
+
+var (
+	errUnsupportedContent = errors.New("unsupported content type")
+	errCaptureTooLarge    = errors.New("page exceeds the maximum capture size")
+	errDuplicate          = errors.New("page is already archived")
+	errPrivateAddress     = errors.New("URL resolves to a private or local address")
+)
+
+// archiveDialer only connects to public addresses, so captures can't be used
+// to reach the daemon's host, its network or cloud metadata services. The
+// check runs on every connection, after DNS resolution, so redirects and
+// rebinding hostnames are covered too.
+var archiveDialer = &net.Dialer{
+	Timeout: archiveTimeout,
+	Control: refusePrivateAddress,
+}
+
+var archiveClient = &http.Client{
+	Timeout: archiveTimeout,
+	Transport: &http.Transport{
+		DialContext:         archiveDialer.DialContext,
+		TLSHandshakeTimeout: 10 * time.Second,
+	},
+}
+
+// sharedAddressSpace is the carrier-grade NAT range, which net.IP doesn't
+// count as private.
+var sharedAddressSpace = &net.IPNet{IP: net.IP{100, 64, 0, 0}, Mask: net.CIDRMask(10, 32)}
+
+// publicIP reports whether ip is routable on the public internet.
+func publicIP(ip net.IP) bool {
+	return !(ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() ||
+		ip.IsMulticast() || ip.IsUnspecified() || sharedAddressSpace.Contains(ip))
+}
+
+func refusePrivateAddress(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); ip == nil || !publicIP(ip) {
+		return fmt.Errorf("%w: %s", errPrivateAddress, host)
+	}
+	return nil
+}
+
+// fetchPage downloads a page for archiving, returning its HTML or PDF,
+// media type and response headers.
+func fetchPage(ctx context.Context, pageURL string) (string, string, http.Header, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, pageURL, nil)
+	if err != nil {
+		return "", "", nil, err
+	}
+	req.Header.Set("User-Agent", "memento/1.0 (+https://github.com/nascarsayan/memento)")
+	req.Header.Set("Accept", "text/html,application/xhtml+xml,application/pdf")
+
+	resp, err := archiveClient.Do(req)
+	if err != nil {
+		return "", "", nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", "", nil, fmt.Errorf("fetching %s: %s", pageURL, resp.Status)
+	}
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if mediaType != "text/html" && mediaType != "application/xhtml+xml" && mediaType != pdfMediaType {
+		return "", "", nil, fmt.Errorf("%w: %s", errUnsupportedContent, mediaType)
+	}
+
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxCaptureBytes+1))
+	if err != nil {
+		return "", "", nil, err
+	}
+	if len(body) > maxCaptureBytes {
+		return "", "", nil, errCaptureTooLarge
+	}
+	return string(body), mediaType, resp.Header, nil
+}
+
+// captureHeaders returns the response headers kept with a capture, leaving
+// out cookies.
+func captureHeaders(header http.Header) map[string]string {
+	kept := make(map[string]string)
+	for name, values := range header {
+		if name == "Set-Cookie" {
+			continue
+		}
+		kept[name] = strings.Join(values, ", ")
+	}
+	return kept
+}
+
+// archiveURL fetches a page server-side and stores it like an extension
+// capture, returning the new page ID. Owner is the user the capture belongs
+// to and counts against, if any. With render set, the page is also loaded
+// in the headless browser and its DOM stored once scripts have run, for
+// pages that are empty shells without JavaScript.
+func archiveURL(ctx context.Context, pageURL, owner string, render bool) (string, error) {
+	if doNotIndex(pageURL) {
+		return "", errDoNotIndex
+	}
+	fetchCtx, fetchSpan := startSpan(ctx, "capture.fetch")
+	fetchSpan.SetAttribute("url.full", pageURL)
+	html, mediaType, header, err := fetchPage(fetchCtx, pageURL)
+	fetchSpan.RecordError(err)
+	fetchSpan.SetAttribute("page.bytes", len(html))
+	fetchSpan.End()
+	if err != nil {
+		return "", err
+	}
+	return storeFetched(ctx, pageURL, html, mediaType, header, owner, render)
+}
+
+// storeFetched archives a page fetched by fetchPage, rendering it in the
+// browser first when render is set.
+func storeFetched(ctx context.Context, pageURL, html, mediaType string, header http.Header, owner string, render bool) (string, error) {
+	if mediaType == pdfMediaType {
+		id, err := archivePDF(ctx, []byte(html), pageURL, "", owner)
+		if err == nil {
+			err = recordCaptureHeaders(id, header)
+		}
+		return id, err
+	}
+	if render {
+		var err error
+		renderCtx, renderSpan := startSpan(ctx, "capture.render")
+		html, err = renderPage(renderCtx, pageURL)
+		renderSpan.RecordError(err)
+		renderSpan.SetAttribute("page.bytes", len(html))
+		renderSpan.End()
+		if err != nil {
+			return "", fmt.Errorf("rendering: %w", err)
+		}
+	}
+
+	_, span := startSpan(ctx, "capture.extract")
+	defer span.End()
+
+	knownHashes, err := knownContentHashes()
+	if err != nil {
+		return "", err
+	}
+	// Compare what would be stored with what is
+	html = storedHTML(html)
+	if knownHashes[contentHash([]byte(html))] {
+		span.SetAttribute("capture.duplicate", true)
+		return "", errDuplicate
+	}
+
+	now := time.Now()
+	freshness := httpFreshness(header, now)
+	metadata := PageMetadata{
+		URL:       pageURL,
+		Title:     extractTitle(html),
+		Timestamp: now,
+		Source:    "archiver",
+		Owner:     owner,
+		// Kept as evidence of what the server sent
+		CaptureHeaders: captureHeaders(header),
+		Freshness:      &freshness,
+	}
+	if metadata.Title == "" {
+		metadata.Title = pageURL
+	}
+	id, err := storePage(metadata, html, "")
+	span.RecordError(err)
+	span.SetAttribute("page.id", id)
+	return id, err
+}
+
+// recordCaptureHeaders adds the response headers, and the freshness they
+// give, to a page stored without them.
+func recordCaptureHeaders(id string, header http.Header) error {
+	indexMu.Lock()
+	defer indexMu.Unlock()
+	metadata, err := readMetadata(id)
+	if err != nil {
+		return err
+	}
+	freshness := httpFreshness(header, metadata.Timestamp)
+	metadata.CaptureHeaders = captureHeaders(header)
+	metadata.Freshness = &freshness
+	return writeMetadata(id, metadata)
+}
+
+// writeArchiveError maps archiver failures to API error codes.
+func writeArchiveError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, errUnsupportedContent):
+		writeError(w, http.StatusUnsupportedMediaType, ErrUnsupportedContent, err.Error())
+	case errors.Is(err, errCaptureTooLarge):
+		writeError(w, http.StatusRequestEntityTooLarge, ErrCaptureTooLarge, err.Error())
+	case errors.Is(err, errDuplicate):
+		writeError(w, http.StatusConflict, ErrDuplicate, err.Error())
+	case errors.Is(err, errPrivateAddress):
+		writeError(w, http.StatusForbidden, ErrForbidden, errPrivateAddress.Error())
+	case errors.Is(err, errDoNotIndex):
+		writeError(w, http.StatusForbidden, ErrForbidden, errDoNotIndex.Error())
+	default:
+		writeError(w, http.StatusBadGateway, ErrInternal, fmt.Sprintf("Archiving failed: %v", err))
+	}
+}
+
+// archiveRequest archives a page through POST /archive.
+type archiveRequest struct {
+	URL string `json:"url"`
+	// Screenshot also stores a full-page PNG taken by the headless
+	// browser.
+	Screenshot bool `json:"screenshot"`
+	// Render stores the page as the headless browser shows it, once
+	// its scripts have run. It can also be given as render=1.
+	Render bool `json:"render"`
+	// Assets stores the page's images, stylesheets and fonts with it.
+	Assets bool `json:"assets"`
+	// KeepDays removes the page that many days from now unless it is
+	// starred by then. Zero keeps it for good.
+	KeepDays int `json:"keepDays"`
+	// Async archives the page as a job, responding with the job at once.
+	Async bool `json:"async,omitempty"`
+}
+
+// handleArchive fetches and stores the page at the URL given as
+// {"url": "...", "screenshot": true, "render": true, "assets": true},
+// indexing it before responding, or with "async": true as a job. A PDF can
+// be archived by posting it as the body instead.
+func handleArchive(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, ErrMethodNotAllowed, "Method not allowed")
+		return
+	}
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType == pdfMediaType {
+		handlePDFUpload(w, r)
+		return
+	}
+	var request archiveRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		writeError(w, http.StatusBadRequest, ErrInvalidRequest, "Invalid request body")
+		return
+	}
+	if u, err := url.Parse(request.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		writeError(w, http.StatusBadRequest, ErrInvalidRequest, "URL must be an absolute http(s) URL")
+		return
+	}
+	if r.URL.Query().Get("render") == "1" {
+		request.Render = true
+	}
+	if !validKeepDays(request.KeepDays) {
+		writeError(w, http.StatusBadRequest, ErrInvalidRequest, "keepDays must be between 0 and 3650")
+		return
+	}
+	if (request.Screenshot || request.Render) && config.Browser.Path == "" {
+		writeError(w, http.StatusBadRequest, ErrInvalidRequest, "Screenshots and rendering need browser.path in the config")
+		return
+	}
+
+	if !allowCapture(w, r) {
+		return
+	}
+	if request.Async {
+		job, err := startJob(jobArchive, requestUser(r), request)
+		if err != nil {
+			log.Printf("Error starting archive of %s: %v", request.URL, err)
+			releaseCapture(r)
+			writeError(w, http.StatusInternalServerError, ErrInternal, "Failed to start archiving")
+			return
+		}
+		writeJob(w, job)
+		return
+	}
+	ctx, span := startRequestSpan(r, "capture")
+	defer span.End()
+
+	id, err := captureURL(ctx, request, requestUser(r))
+	if err != nil {
+		span.RecordError(err)
+		log.Printf("Error archiving %s: %v", request.URL, err)
+		publishError("", fmt.Errorf("archiving %s: %v", request.URL, err))
+		releaseCapture(r)
+		writeArchiveError(w, err)
+		return
+	}
+	writeArchived(ctx, w, id)
+}
+
+// captureURL archives a page for owner as request asks, with its expiry,
+// assets and screenshot.
+func captureURL(ctx context.Context, request archiveRequest, owner string) (string, error) {
+	id, err := archiveURL(ctx, request.URL, owner, request.Render)
+	if err != nil {
+		return "", err
+	}
+	if err := setExpiry(id, request.KeepDays); err != nil {
+		log.Printf("Error setting the expiry of %s: %v", id, err)
+	}
+	if request.Assets || config.Capture.Assets {
+		// As with screenshots, missing assets don't fail the capture
+		if _, err := captureAssets(ctx, id); err != nil {
+			log.Printf("Error storing assets of %s: %v", request.URL, err)
+			publishError(id, fmt.Errorf("assets of %s: %v", request.URL, err))
+		}
+	}
+	if page, err := loadPage(id); request.Screenshot && err == nil && page.PDFFilename == "" {
+		// The page is kept without one rather than failing the capture
+		if err := captureScreenshot(ctx, id, request.URL); err != nil {
+			log.Printf("Error taking screenshot of %s: %v", request.URL, err)
+			publishError(id, fmt.Errorf("screenshot of %s: %v", request.URL, err))
+		}
+	}
+	return id, nil
+}
+
+// runArchiveJob archives a page as a job asks and indexes it, returning
+// its ID.
+func runArchiveJob(ctx context.Context, job *Job) (interface{}, error) {
+	var request archiveRequest
+	if err := json.Unmarshal(job.Params, &request); err != nil {
+		return nil, err
+	}
+	reportProgress(ctx, 0, 1)
+	id, err := captureURL(ctx, request, job.Owner)
+	if err != nil {
+		return nil, err
+	}
+	indexExistingFiles(ctx)
+	reportProgress(ctx, 1, 1)
+	return map[string]string{"id": id}, nil
+}
+
+// writeArchived indexes a newly archived page and responds with it.
+func writeArchived(ctx context.Context, w http.ResponseWriter, id string) {
+	indexExistingFiles(ctx)
+
+	page, err := loadPage(id)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, ErrInternal, "Failed to read archived page")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(page)
+}
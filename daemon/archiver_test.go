@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+)
+
+func TestPublicIP(t *testing.T) {
+	tests := []struct {
+		ip     string
+		public bool
+	}{
+		{"93.184.216.34", true},
+		{"2606:4700:4700::1111", true},
+		{"127.0.0.1", false},
+		{"::1", false},
+		{"10.1.2.3", false},
+		{"172.16.0.1", false},
+		{"192.168.1.1", false},
+		{"169.254.169.254", false},
+		{"fe80::1", false},
+		{"fd00::1", false},
+		{"100.64.0.1", false},
+		{"0.0.0.0", false},
+		{"224.0.0.1", false},
+		{"::ffff:127.0.0.1", false},
+	}
+	for _, tt := range tests {
+		if got := publicIP(net.ParseIP(tt.ip)); got != tt.public {
+			t.Errorf("publicIP(%s) = %v, want %v", tt.ip, got, tt.public)
+		}
+	}
+}
+
+func TestRefusePrivateAddress(t *testing.T) {
+	if err := refusePrivateAddress("tcp", "169.254.169.254:80", nil); !errors.Is(err, errPrivateAddress) {
+		t.Errorf("metadata address: got %v, want errPrivateAddress", err)
+	}
+	if err := refusePrivateAddress("tcp", "[::1]:443", nil); !errors.Is(err, errPrivateAddress) {
+		t.Errorf("IPv6 loopback: got %v, want errPrivateAddress", err)
+	}
+	if err := refusePrivateAddress("tcp", "93.184.216.34:443", nil); err != nil {
+		t.Errorf("public address: got %v, want nil", err)
+	}
+}
+
+func TestFetchPageRefusesLoopback(t *testing.T) {
+	if _, _, _, err := fetchPage(context.Background(), "http://127.0.0.1:1/"); !errors.Is(err, errPrivateAddress) {
+		t.Errorf("got %v, want errPrivateAddress", err)
+	}
+}
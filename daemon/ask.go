@@ -0,0 +1,288 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/blevesearch/bleve"
+)
+
+const (
+	defaultChatURL   = "http://localhost:11434/api/chat"
+	defaultChatModel = "llama3.2"
+	chatTimeout      = 5 * time.Minute
+	// maxAskPassages bounds how many passages a question may be answered
+	// from.
+	maxAskPassages = 20
+)
+
+// AskConfig turns on answering questions from the archive: the passages
+// most relevant to a question are sent with it to the chat model at URL,
+// such as a local Ollama's /api/chat or any endpoint taking OpenAI's
+// /v1/chat/completions requests. Passages are found by meaning when
+// semantic search is on, and by keywords otherwise.
+type AskConfig struct {
+	Enabled bool   `json:"enabled"`
+	URL     string `json:"url"`
+	Model   string `json:"model"`
+	APIKey  Secret `json:"apiKey"`
+	// Passages is how many passages a question is answered from by
+	// default.
+	Passages int `json:"passages"`
+}
+
+// askPrompt tells the model how to answer from the passages.
+const askPrompt = `You answer questions from passages of web pages the user has archived.
+Use only the numbered passages below. Cite the passages each statement comes from as [1], [2] and so on.
+If the passages don't answer the question, say so instead of guessing.`
+
+var chatClient = &http.Client{Timeout: chatTimeout}
+
+// citationPattern finds the passage numbers an answer cites.
+var citationPattern = regexp.MustCompile(`\[(\d+)\]`)
+
+// Passage is a part of an archived page an answer may cite as [N].
+type Passage struct {
+	N     int       `json:"n"`
+	ID    string    `json:"id"`
+	URL   string    `json:"url"`
+	Title string    `json:"title"`
+	Time  time.Time `json:"time"`
+	Text  string    `json:"text"`
+	// Cited is whether the answer cites the passage.
+	Cited bool `json:"cited"`
+}
+
+// Answer is the model's answer to a question with the passages it was
+// given.
+type Answer struct {
+	Question string    `json:"question"`
+	Answer   string    `json:"answer"`
+	Passages []Passage `json:"passages"`
+}
+
+// validateAsk checks the settings for answering questions.
+func validateAsk(c AskConfig) error {
+	if c.Passages < 1 || c.Passages > maxAskPassages {
+		return fmt.Errorf("ask.passages must be between 1 and %d", maxAskPassages)
+	}
+	if c.Enabled && (c.URL == "" || c.Model == "") {
+		return fmt.Errorf("ask.url and ask.model are required")
+	}
+	return nil
+}
+
+// findPassages returns the passages of up to k pages most relevant to a
+// question, one per page, among those a search with options may return.
+func findPassages(ctx context.Context, question string, options SearchOptions, k int) ([]Passage, error) {
+	var passages []Passage
+	if config.Semantic.Enabled {
+		hits, matches, err := nearestPages(ctx, question, options, k)
+		if err != nil {
+			return nil, err
+		}
+		for i, hit := range hits {
+			passages = append(passages, passageOf(hitResult(matches[i], options, Localizer{Lang: defaultLocale}, time.Now()), hitChunk(hit)))
+		}
+		return passages, nil
+	}
+
+	// Questions are plain words, any of which may match
+	results, err := runSearch(ctx, bleve.NewMatchQuery(question), options, Localizer{Lang: defaultLocale})
+	if err != nil {
+		return nil, err
+	}
+	words := questionWords(question)
+	for _, result := range results {
+		if len(passages) == k {
+			break
+		}
+		page, err := loadPage(result.ID)
+		if err != nil {
+			continue
+		}
+		chunks, err := pageChunks(page)
+		if err != nil {
+			log.Printf("Error reading page %s to answer from: %v", page.ID, err)
+			continue
+		}
+		if text := bestChunk(chunks, words); text != "" {
+			passages = append(passages, passageOf(result, text))
+		}
+	}
+	return passages, nil
+}
+
+// passageOf makes a passage of a search result's page.
+func passageOf(result SearchResult, text string) Passage {
+	return Passage{ID: result.ID, URL: result.URL, Title: result.Title, Time: result.Time, Text: text}
+}
+
+// questionWords returns the distinct lowercased words of a question.
+func questionWords(question string) map[string]bool {
+	words := make(map[string]bool)
+	for _, word := range strings.FieldsFunc(strings.ToLower(question), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}) {
+		words[word] = true
+	}
+	return words
+}
+
+// bestChunk returns the chunk sharing the most distinct words with the
+// question, the first on a tie.
+func bestChunk(chunks []string, words map[string]bool) string {
+	best, bestCount := "", -1
+	for _, chunk := range chunks {
+		count := 0
+		for word := range questionWords(chunk) {
+			if words[word] {
+				count++
+			}
+		}
+		if count > bestCount {
+			best, bestCount = chunk, count
+		}
+	}
+	return best
+}
+
+// chatMessage is a message of a chat with the model.
+type chatMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+// chatResponse is the answer of either an Ollama or an OpenAI-compatible
+// chat endpoint.
+type chatResponse struct {
+	Message *chatMessage `json:"message"`
+	Choices []struct {
+		Message chatMessage `json:"message"`
+	} `json:"choices"`
+}
+
+// chat sends messages to the configured chat model and returns its reply.
+func chat(ctx context.Context, messages []chatMessage) (string, error) {
+	c := config.Ask
+	body, err := json.Marshal(map[string]interface{}{"model": c.Model, "messages": messages, "stream": false})
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.URL, bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if !c.APIKey.IsZero() {
+		key, err := c.APIKey.Value()
+		if err != nil {
+			return "", err
+		}
+		req.Header.Set("Authorization", "Bearer "+key)
+	}
+	resp, err := chatClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", errModelUnavailable, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%w: %s answered %s", errModelUnavailable, c.URL, resp.Status)
+	}
+	var answer chatResponse
+	if err := json.NewDecoder(resp.Body).Decode(&answer); err != nil {
+		return "", fmt.Errorf("%w: %v", errModelUnavailable, err)
+	}
+	switch {
+	case answer.Message != nil:
+		return strings.TrimSpace(answer.Message.Content), nil
+	case len(answer.Choices) > 0:
+		return strings.TrimSpace(answer.Choices[0].Message.Content), nil
+	}
+	return "", fmt.Errorf("%w: %s returned no message", errModelUnavailable, c.URL)
+}
+
+// askArchive answers a question from the passages of up to k pages. With
+// no passage to answer from, the model isn't asked and the answer is
+// empty.
+func askArchive(ctx context.Context, question string, options SearchOptions, k int) (Answer, error) {
+	answer := Answer{Question: question, Passages: []Passage{}}
+	passages, err := findPassages(ctx, question, options, k)
+	if err != nil || len(passages) == 0 {
+		return answer, err
+	}
+	var prompt strings.Builder
+	for i := range passages {
+		passages[i].N = i + 1
+		p := passages[i]
+		fmt.Fprintf(&prompt, "[%d] %s (%s), saved %s:\n%s\n\n", p.N, reportTitle(p.Title, p.URL), p.URL, p.Time.Format("2006-01-02"), p.Text)
+	}
+	fmt.Fprintf(&prompt, "Question: %s", question)
+	reply, err := chat(ctx, []chatMessage{{Role: "system", Content: askPrompt}, {Role: "user", Content: prompt.String()}})
+	if err != nil {
+		return answer, err
+	}
+	answer.Answer = reply
+	for _, match := range citationPattern.FindAllStringSubmatch(reply, -1) {
+		if n, err := strconv.Atoi(match[1]); err == nil && n >= 1 && n <= len(passages) {
+			passages[n-1].Cited = true
+		}
+	}
+	answer.Passages = passages
+	return answer, nil
+}
+
+// handleAsk answers the question in the request body,
+// {"question": "...", "passages": k}, from the asker's archive.
+func handleAsk(w http.ResponseWriter, r *http.Request) {
+	if !config.Ask.Enabled {
+		writeError(w, http.StatusNotFound, ErrNotFound, "Asking the archive is not enabled")
+		return
+	}
+	ctx, span := startRequestSpan(r, "ask")
+	defer span.End()
+	var request struct {
+		Question string `json:"question"`
+		Passages int    `json:"passages"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || strings.TrimSpace(request.Question) == "" {
+		writeError(w, http.StatusBadRequest, ErrInvalidRequest, "Request body must give a question")
+		return
+	}
+	if request.Passages == 0 {
+		request.Passages = config.Ask.Passages
+	}
+	if request.Passages < 1 || request.Passages > maxAskPassages {
+		writeError(w, http.StatusBadRequest, ErrInvalidRequest, fmt.Sprintf("passages must be between 1 and %d", maxAskPassages))
+		return
+	}
+	if !allowSearch(w, r) {
+		return
+	}
+
+	options := SearchOptions{Highlight: "none", Sort: "score", Owner: requestUser(r)}
+	answer, err := askArchive(ctx, strings.TrimSpace(request.Question), options, request.Passages)
+	span.RecordError(err)
+	switch {
+	case errors.Is(err, errModelUnavailable):
+		log.Printf("Error asking the archive: %v", err)
+		writeError(w, http.StatusBadGateway, ErrModelUnavailable, "The model could not be reached")
+		return
+	case err != nil:
+		log.Printf("Error asking the archive: %v", err)
+		writeError(w, http.StatusServiceUnavailable, ErrIndexUnavailable, "Search failed")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(answer)
+}
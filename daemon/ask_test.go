@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAsk(t *testing.T) {
+	withPagesDir(t)
+	var prompt string
+	model := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var request struct {
+			Model    string        `json:"model"`
+			Messages []chatMessage `json:"messages"`
+		}
+		json.NewDecoder(r.Body).Decode(&request)
+		prompt = request.Messages[len(request.Messages)-1].Content
+		// OpenAI's format
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"choices": []map[string]interface{}{{"message": chatMessage{Role: "assistant", Content: "Sourdough needs a starter [2] fed daily [9]."}}},
+		})
+	}))
+	defer model.Close()
+	saved := config
+	defer func() { config = saved }()
+	config.Ask = AskConfig{Enabled: true, URL: model.URL, Model: "test", Passages: 5}
+	config.Semantic.ChunkWords = 8
+
+	docs := map[string]PageDocument{}
+	for i, content := range []string{
+		"Bread recipes for the weekend. Rye loaves rise slowly. Keep the sourdough starter in the fridge and feed it daily.",
+		"Sourdough starter keeps for weeks. Baking tips: preheat the oven.",
+		"Car maintenance and oil changes.",
+	} {
+		metadata := PageMetadata{URL: fmt.Sprintf("https://example.com/%d", i), Title: content[:12]}
+		id, err := storePage(metadata, "", content)
+		if err != nil {
+			t.Fatal(err)
+		}
+		docs[id] = PageDocument{URL: metadata.URL, Title: metadata.Title, Content: content}
+	}
+	withIndex(t, docs)
+
+	body, _ := json.Marshal(map[string]interface{}{"question": "How do I keep a sourdough starter?"})
+	w := httptest.NewRecorder()
+	handleAsk(w, httptest.NewRequest("POST", "/ask", bytes.NewReader(body)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", w.Code, w.Body)
+	}
+	var answer Answer
+	json.NewDecoder(w.Body).Decode(&answer)
+	if answer.Answer == "" || len(answer.Passages) != 2 {
+		t.Fatalf("answer = %+v", answer)
+	}
+	if answer.Passages[0].Cited || !answer.Passages[1].Cited {
+		t.Errorf("citations = %+v", answer.Passages)
+	}
+	// Each page's passage is the chunk closest to the question
+	for _, p := range answer.Passages {
+		if !strings.Contains(p.Text, "starter") || !strings.Contains(prompt, "["+string(rune('0'+p.N))+"] ") {
+			t.Errorf("passage %+v missing from prompt:\n%s", p, prompt)
+		}
+	}
+
+	// Nothing matching leaves the model alone
+	prompt = ""
+	answer, err := askArchive(httptest.NewRequest("GET", "/", nil).Context(), "quantum chromodynamics", SearchOptions{Highlight: "none", Sort: "score"}, 5)
+	if err != nil || answer.Answer != "" || len(answer.Passages) != 0 || prompt != "" {
+		t.Errorf("answer without passages = %+v, %v", answer, err)
+	}
+
+	config.Ask.URL = "http://127.0.0.1:1/"
+	w = httptest.NewRecorder()
+	handleAsk(w, httptest.NewRequest("POST", "/ask", bytes.NewReader(body)))
+	if w.Code != http.StatusBadGateway {
+		t.Errorf("status without a model = %d", w.Code)
+	}
+}
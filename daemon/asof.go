@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/blevesearch/bleve"
+	"github.com/blevesearch/bleve/search/query"
+)
+
+// parseAsOf reads the as_of search parameter: an RFC 3339 time, or a date
+// standing for the end of that day in the daemon's time zone.
+func parseAsOf(value string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	day, err := time.ParseInLocation("2006-01-02", value, time.Local)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("as_of must be a date (2006-01-02) or an RFC 3339 time")
+	}
+	return day.AddDate(0, 0, 1).Add(-time.Nanosecond), nil
+}
+
+// snapshotQuery limits q to the captures that were current at options.AsOf:
+// those saved by then and not yet replaced by a re-capture. A zero AsOf
+// means now, leaving out every replaced version. When users are enabled, q
+// is also limited to options.Owner's pages. Private pages are left out
+// unless options include them, and only starred, unread, changed or stale
+// pages are kept when options ask for them.
+func snapshotQuery(q query.Query, options SearchOptions) query.Query {
+	boolean := bleve.NewBooleanQuery()
+	boolean.AddMust(q)
+	if usersEnabled() {
+		if key := ownerKey(options.Owner); key != "" {
+			owned := bleve.NewTermQuery(key)
+			owned.SetField("ownerKey")
+			boolean.AddMust(owned)
+		} else {
+			boolean.AddMust(bleve.NewMatchNoneQuery())
+		}
+	}
+	if !options.IncludePrivate {
+		private := bleve.NewBoolFieldQuery(true)
+		private.SetField("private")
+		boolean.AddMustNot(private)
+	}
+	if options.Starred {
+		starred := bleve.NewBoolFieldQuery(true)
+		starred.SetField("starred")
+		boolean.AddMust(starred)
+	}
+	if options.Unread {
+		read := bleve.NewBoolFieldQuery(true)
+		read.SetField("read")
+		boolean.AddMustNot(read)
+	}
+	if options.Changed {
+		min := materialDrift
+		changed := bleve.NewNumericRangeQuery(&min, nil)
+		changed.SetField("drift")
+		boolean.AddMust(changed)
+	}
+	if options.Stale {
+		stale := bleve.NewDateRangeQuery(time.Time{}, time.Now())
+		stale.SetField("staleAt")
+		boolean.AddMust(stale)
+	}
+	asOf := options.AsOf
+	end := asOf
+	if asOf.IsZero() {
+		end = time.Now()
+	} else {
+		saved := bleve.NewDateRangeQuery(time.Time{}, asOf)
+		saved.SetField("time")
+		boolean.AddMust(saved)
+	}
+	replaced := bleve.NewDateRangeQuery(time.Time{}, end)
+	replaced.SetField("supersededAt")
+	boolean.AddMustNot(replaced)
+	return boolean
+}
+
+// supersededAt returns when a replaced page's newer capture was saved, or
+// nil for a current page. If the newer capture is gone, the page counts as
+// replaced from the moment it was saved.
+func supersededAt(metadata PageMetadata) *time.Time {
+	if metadata.SupersededBy == "" {
+		return nil
+	}
+	if newer, err := readMetadata(metadata.SupersededBy); err == nil {
+		return &newer.Timestamp
+	}
+	return &metadata.Timestamp
+}
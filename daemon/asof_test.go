@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestParseAsOf(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    time.Time
+		wantErr bool
+	}{
+		{"2024-03-01T12:00:00Z", time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC), false},
+		{"2024-03-01", time.Date(2024, 3, 2, 0, 0, 0, 0, time.Local).Add(-time.Nanosecond), false},
+		{"March 1st", time.Time{}, true},
+	}
+	for _, tt := range tests {
+		got, err := parseAsOf(tt.value)
+		if (err != nil) != tt.wantErr || !got.Equal(tt.want) {
+			t.Errorf("parseAsOf(%q) = %v, %v, want %v", tt.value, got, err, tt.want)
+		}
+	}
+}
+
+func TestSearchAsOf(t *testing.T) {
+	day := func(d int) time.Time { return time.Date(2024, 1, d, 12, 0, 0, 0, time.UTC) }
+	replaced := day(10)
+	withIndex(t, map[string]PageDocument{
+		"draft":  {URL: "https://example.com/post", Content: "rust notes draft", Time: day(1), SupersededAt: &replaced},
+		"final":  {URL: "https://example.com/post", Content: "rust notes final", Time: day(10)},
+		"later":  {URL: "https://example.com/later", Content: "rust notes later", Time: day(20)},
+		"always": {URL: "https://example.com/always", Content: "rust notes", Time: day(1)},
+	})
+
+	tests := []struct {
+		name string
+		asOf time.Time
+		want []string
+	}{
+		{"now", time.Time{}, []string{"always", "final", "later"}},
+		{"before anything", day(1).Add(-time.Hour), nil},
+		{"before the re-capture", day(5), []string{"always", "draft"}},
+		{"after the re-capture", day(15), []string{"always", "final"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			results, err := searchPages(context.Background(), "rust", SearchOptions{Sort: "score", Highlight: "none", AsOf: tt.asOf}, Localizer{Lang: defaultLocale})
+			if err != nil {
+				t.Fatal(err)
+			}
+			var ids []string
+			for _, result := range results {
+				ids = append(ids, result.ID)
+			}
+			sort.Strings(ids)
+			if len(ids) != len(tt.want) {
+				t.Fatalf("results = %v, want %v", ids, tt.want)
+			}
+			for i := range ids {
+				if ids[i] != tt.want[i] {
+					t.Errorf("results = %v, want %v", ids, tt.want)
+					break
+				}
+			}
+		})
+	}
+}
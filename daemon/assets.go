@@ -0,0 +1,395 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"io/ioutil"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+const (
+	// maxAssetBytes bounds a single stored asset.
+	maxAssetBytes = 10 << 20
+	// maxPageAssets bounds how many assets are stored with a page.
+	maxPageAssets = 300
+	// assetFetchers is how many assets of a page are downloaded at once.
+	assetFetchers = 6
+)
+
+var (
+	cssURLRe       = regexp.MustCompile(`(?i)url\(\s*(?:"([^"]*)"|'([^']*)'|([^'")\s]*))\s*\)`)
+	cssImportURLRe = regexp.MustCompile(`(?i)@import\s+(?:url\(\s*(?:"([^"]*)"|'([^']*)'|([^'")\s]*))\s*\)|"([^"]*)"|'([^']*)')`)
+	assetExtRe     = regexp.MustCompile(`^\.[a-z0-9]{1,5}$`)
+)
+
+// assetCapture downloads the resources of one page into its assets
+// directory. Names are derived from URLs, so a resource referenced twice
+// is stored once.
+type assetCapture struct {
+	ctx context.Context
+	id  string
+
+	mu      sync.Mutex
+	started map[string]bool
+	stored  map[string]bool
+	total   int64
+}
+
+// captureAssets downloads the images, stylesheets and fonts a stored page
+// references and rewrites its HTML to load them from its assets directory,
+// returning how many were stored. References that can't be fetched are
+// left pointing at the live web.
+func captureAssets(ctx context.Context, id string) (int, error) {
+	ctx, span := startSpan(ctx, "capture.assets")
+	defer span.End()
+	page, err := loadPage(id)
+	if err != nil {
+		return 0, err
+	}
+	if page.HTMLFilename == "" {
+		return 0, nil
+	}
+	htmlPath := filepath.Join(pagesDir, page.HTMLFilename)
+	data, err := readContentFile(htmlPath)
+	if err != nil {
+		return 0, err
+	}
+	if err := os.MkdirAll(assetsDir(id), 0755); err != nil {
+		return 0, err
+	}
+
+	c := &assetCapture{ctx: ctx, id: id, started: make(map[string]bool), stored: make(map[string]bool)}
+	base := documentBase(string(data), page.URL)
+
+	// Fetch everything the page references, then point the references at
+	// whatever was stored
+	refs := make(map[string]string)
+	rewriteHTMLAssets(string(data), webAssets(base), func(ref, ext string) string {
+		refs[ref] = ext
+		return ""
+	})
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, assetFetchers)
+	for ref, ext := range refs {
+		wg.Add(1)
+		go func(ref, ext string) {
+			defer wg.Done()
+			sem <- struct{}{}
+			c.fetch(ref, ext, func() { <-sem })
+		}(ref, ext)
+	}
+	wg.Wait()
+
+	rewritten := rewriteHTMLAssets(string(data), webAssets(base), func(ref, ext string) string {
+		if name := assetName(ref, ext); c.stored[name] {
+			return assetsPrefix + name
+		}
+		return ""
+	})
+	span.SetAttribute("assets.count", len(c.stored))
+	span.SetAttribute("assets.bytes", c.total)
+	if len(c.stored) == 0 {
+		os.Remove(assetsDir(id))
+		return 0, nil
+	}
+	// The content hash stays that of the page as captured, so captures of
+	// it are still recognized as duplicates
+	return len(c.stored), writeContentFile(htmlPath, []byte(rewritten))
+}
+
+// fetch stores the resource at ref once, calling release when it is done
+// with the network so stylesheets can fetch what they reference in turn.
+func (c *assetCapture) fetch(ref, ext string, release func()) {
+	name := assetName(ref, ext)
+	c.mu.Lock()
+	if c.started[name] || len(c.started) >= maxPageAssets {
+		c.mu.Unlock()
+		release()
+		return
+	}
+	c.started[name] = true
+	c.mu.Unlock()
+
+	data, contentType, err := c.download(ref)
+	release()
+	if err != nil {
+		log.Printf("Error fetching asset %s of %s: %v", ref, c.id, err)
+		return
+	}
+	if ext == ".css" || strings.HasPrefix(contentType, "text/css") {
+		base, _ := url.Parse(ref)
+		data = []byte(c.rewriteStylesheet(string(data), base))
+	}
+	if err := ioutil.WriteFile(filepath.Join(assetsDir(c.id), name), data, 0644); err != nil {
+		log.Printf("Error storing asset %s of %s: %v", ref, c.id, err)
+		return
+	}
+	c.mu.Lock()
+	c.stored[name] = true
+	c.mu.Unlock()
+}
+
+// download fetches an asset, only from public addresses and within the
+// page's share of maxCaptureBytes.
+func (c *assetCapture) download(ref string) ([]byte, string, error) {
+	req, err := http.NewRequestWithContext(c.ctx, http.MethodGet, ref, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	req.Header.Set("User-Agent", "memento/1.0 (+https://github.com/nascarsayan/memento)")
+	resp, err := archiveClient.Do(req)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", fmt.Errorf("HTTP %s", resp.Status)
+	}
+	contentType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	// An HTML error page isn't the asset
+	if contentType == "text/html" {
+		return nil, "", errUnsupportedContent
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxAssetBytes+1))
+	if err != nil {
+		return nil, "", err
+	}
+	if len(data) > maxAssetBytes {
+		return nil, "", errCaptureTooLarge
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.total+int64(len(data)) > maxCaptureBytes {
+		return nil, "", errCaptureTooLarge
+	}
+	c.total += int64(len(data))
+	return data, contentType, nil
+}
+
+// rewriteStylesheet stores what a stylesheet imports and references,
+// which lands next to it in the assets directory.
+func (c *assetCapture) rewriteStylesheet(css string, base *url.URL) string {
+	return rewriteCSSAssets(css, webAssets(base), func(ref, ext string) string {
+		c.fetch(ref, ext, func() {})
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		// A stylesheet importing itself, directly or not, is still being
+		// stored, as may be one another stylesheet is storing
+		if name := assetName(ref, ext); c.started[name] {
+			return name
+		}
+		return ""
+	})
+}
+
+// assetName is the file an asset URL is stored as: a hash of the URL, with
+// the extension of its path or else ext.
+func assetName(ref, ext string) string {
+	sum := sha256.Sum256([]byte(ref))
+	if u, err := url.Parse(ref); err == nil {
+		if e := strings.ToLower(path.Ext(u.Path)); assetExtRe.MatchString(e) {
+			ext = e
+		}
+	}
+	return hex.EncodeToString(sum[:8]) + ext
+}
+
+// documentBase is the URL a page's relative references resolve against:
+// that of its first base element with an href, or else its own.
+func documentBase(doc, pageURL string) *url.URL {
+	base, err := url.Parse(pageURL)
+	if err != nil {
+		base = &url.URL{}
+	}
+	for _, tag := range htmlTagRe.FindAllStringSubmatch(doc, -1) {
+		if !strings.EqualFold(tag[1], "base") {
+			continue
+		}
+		for _, a := range htmlAttrRe.FindAllStringSubmatch(tag[2], -1) {
+			if strings.EqualFold(a[1], "href") {
+				if href, err := url.Parse(html.UnescapeString(strings.Trim(a[2], `"'`))); err == nil {
+					return base.ResolveReference(href)
+				}
+			}
+		}
+	}
+	return base
+}
+
+// resolveAsset returns the absolute http(s) URL of a reference, or "" for
+// inline data, stored assets and trackers.
+func resolveAsset(base *url.URL, ref string) string {
+	ref = strings.TrimSpace(ref)
+	if ref == "" || strings.HasPrefix(ref, assetsPrefix) || strings.HasPrefix(ref, "#") {
+		return ""
+	}
+	u, err := url.Parse(ref)
+	if err != nil {
+		return ""
+	}
+	u = base.ResolveReference(u)
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return ""
+	}
+	u.Fragment = ""
+	if trackerURL(u.String()) {
+		return ""
+	}
+	return u.String()
+}
+
+// webAssets resolves references against base for rewriteHTMLAssets.
+func webAssets(base *url.URL) func(string) string {
+	return func(ref string) string { return resolveAsset(base, ref) }
+}
+
+// rewriteHTMLAssets replaces the resource references in a page with what
+// replace returns for them as resolved by resolve, keeping those either
+// returns "" for. Ext is the extension the resource is stored with if its
+// URL has none.
+func rewriteHTMLAssets(doc string, resolve func(string) string, replace func(ref, ext string) string) string {
+	doc = styleBlockRe.ReplaceAllStringFunc(doc, func(block string) string {
+		parts := styleBlockRe.FindStringSubmatch(block)
+		return parts[1] + rewriteCSSAssets(parts[2], resolve, replace) + parts[3]
+	})
+	return htmlTagRe.ReplaceAllStringFunc(doc, func(tag string) string {
+		parts := htmlTagRe.FindStringSubmatch(tag)
+		name := strings.ToLower(parts[1])
+		attrs := make(map[string]string)
+		for _, a := range htmlAttrRe.FindAllStringSubmatch(parts[2], -1) {
+			attrs[strings.ToLower(a[1])] = html.UnescapeString(strings.Trim(a[2], `"'`))
+		}
+		ext := ""
+		if name == "link" {
+			rel := strings.ToLower(attrs["rel"])
+			switch {
+			case strings.Contains(rel, "stylesheet"):
+				ext = ".css"
+			case !strings.Contains(rel, "icon"):
+				return tag
+			}
+		}
+
+		changed := false
+		rest := htmlAttrRe.ReplaceAllStringFunc(parts[2], func(attr string) string {
+			m := htmlAttrRe.FindStringSubmatch(attr)
+			key := strings.ToLower(m[1])
+			value := attrs[key]
+			var rewritten string
+			switch {
+			case key == "style":
+				rewritten = rewriteCSSAssets(value, resolve, replace)
+			case key == "srcset" && (name == "img" || name == "source"):
+				rewritten = rewriteSrcset(value, resolve, replace)
+			case assetAttribute(name, key):
+				if ref := resolve(value); ref != "" {
+					if local := replace(ref, ext); local != "" {
+						rewritten = local
+					}
+				}
+			}
+			if rewritten == "" || rewritten == value {
+				return attr
+			}
+			changed = true
+			return " " + m[1] + `="` + html.EscapeString(rewritten) + `"`
+		})
+		if !changed {
+			return tag
+		}
+		return "<" + parts[1] + rest + parts[3] + ">"
+	})
+}
+
+// assetAttribute reports whether a tag's attribute loads something the
+// page shows, as opposed to a link or a script.
+func assetAttribute(tag, attr string) bool {
+	switch attr {
+	case "src":
+		return tag == "img" || tag == "source" || tag == "video" || tag == "audio" || tag == "track" || tag == "input"
+	case "poster":
+		return tag == "video"
+	case "href":
+		return tag == "link"
+	case "background":
+		return true
+	}
+	return false
+}
+
+func rewriteSrcset(srcset string, resolve func(string) string, replace func(ref, ext string) string) string {
+	candidates := strings.Split(srcset, ",")
+	for i, candidate := range candidates {
+		fields := strings.Fields(candidate)
+		if len(fields) == 0 {
+			continue
+		}
+		if ref := resolve(fields[0]); ref != "" {
+			if local := replace(ref, ""); local != "" {
+				fields[0] = local
+			}
+		}
+		candidates[i] = strings.Join(fields, " ")
+	}
+	return strings.Join(candidates, ", ")
+}
+
+// rewriteCSSAssets replaces the url() and @import references in CSS like
+// rewriteHTMLAssets.
+func rewriteCSSAssets(css string, resolve func(string) string, replace func(ref, ext string) string) string {
+	rewrite := func(re *regexp.Regexp, ext string, format func(string) string) {
+		css = re.ReplaceAllStringFunc(css, func(match string) string {
+			m := re.FindStringSubmatch(match)
+			value := strings.Join(m[1:], "")
+			if ref := resolve(value); ref != "" {
+				if local := replace(ref, ext); local != "" {
+					return format(local)
+				}
+			}
+			return match
+		})
+	}
+	rewrite(cssImportURLRe, ".css", func(local string) string { return `@import "` + local + `"` })
+	rewrite(cssURLRe, "", func(local string) string { return `url("` + local + `")` })
+	return css
+}
+
+// handlePageAssets stores the assets of a page already in the archive,
+// such as one the extension captured.
+func handlePageAssets(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, ErrMethodNotAllowed, "Method not allowed")
+		return
+	}
+	page, err := loadPage(r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusNotFound, ErrNotFound, "Page not found")
+		return
+	}
+	if page.HTMLFilename == "" {
+		writeError(w, http.StatusBadRequest, ErrInvalidRequest, "Page has no stored HTML")
+		return
+	}
+	count, err := captureAssets(r.Context(), page.ID)
+	if err != nil {
+		log.Printf("Error storing assets of %s: %v", page.ID, err)
+		writeError(w, http.StatusInternalServerError, ErrInternal, "Failed to store assets")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]int{"assets": count})
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCaptureAssets(t *testing.T) {
+	withPagesDir(t)
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/img/logo.png", "/img/logo@2x.png", "/bg.png", "/icon":
+			w.Header().Set("Content-Type", "image/png")
+			w.Write(fakePNG)
+		case "/css/site.css":
+			w.Header().Set("Content-Type", "text/css")
+			fmt.Fprint(w, `@import "more.css"; body { background: url(../bg.png) } @font-face { src: url("/font.woff2") }`)
+		case "/css/more.css":
+			// Imports what imports it
+			w.Header().Set("Content-Type", "text/css")
+			fmt.Fprint(w, `@import url(site.css); p { color: red }`)
+		case "/font.woff2":
+			w.Write([]byte("wOF2"))
+		case "/error.png":
+			w.Header().Set("Content-Type", "text/html")
+			fmt.Fprint(w, "<p>Not here</p>")
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+	// The test server is on a loopback address the archive client refuses
+	saved := archiveClient
+	archiveClient = server.Client()
+	defer func() { archiveClient = saved }()
+
+	page := `<html><head>
+<link rel="stylesheet" href="/css/site.css"><link rel="icon" href="/icon"><link rel="canonical" href="/post">
+<style>h1 { background: url('bg.png') }</style>
+</head><body>
+<img src="img/logo.png" srcset="img/logo.png 1x, img/logo@2x.png 2x" alt="Logo">
+<img src="/missing.png"><img src="/error.png"><img src="data:image/png;base64,AAAA">
+<div style="background-image: url(&quot;/bg.png&quot;)">x</div>
+<a href="/img/logo.png">link</a>
+</body></html>`
+	id, err := storePage(PageMetadata{URL: server.URL + "/post"}, page, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	before, _ := loadPage(id)
+	count, err := captureAssets(context.Background(), id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 7 {
+		t.Errorf("stored %d assets, want 7", count)
+	}
+
+	abs := func(path string) string { return server.URL + path }
+	local := func(path, ext string) string { return assetsPrefix + assetName(abs(path), ext) }
+	stored, _ := loadPage(id)
+	data, _ := ioutil.ReadFile(filepath.Join(pagesDir, stored.HTMLFilename))
+	html := string(data)
+	for _, want := range []string{
+		`href="` + local("/css/site.css", ".css") + `"`,
+		`href="` + local("/icon", "") + `"`,
+		`href="/post"`,
+		`url("` + local("/bg.png", "") + `")`,
+		`src="` + local("/img/logo.png", "") + `"`,
+		`srcset="` + local("/img/logo.png", "") + ` 1x, ` + local("/img/logo@2x.png", "") + ` 2x"`,
+		`src="/missing.png"`,
+		`src="/error.png"`,
+		`src="data:image/png;base64,AAAA"`,
+		`style="background-image: url(&#34;` + local("/bg.png", "") + `&#34;)"`,
+		`<a href="/img/logo.png">`,
+	} {
+		if !strings.Contains(html, want) {
+			t.Errorf("stored HTML is missing %s:\n%s", want, html)
+		}
+	}
+	withIndex(t, nil)
+	indexExistingFiles(context.Background())
+	if stored, _ := loadPage(id); stored.ContentHash != before.ContentHash {
+		t.Error("content hash changed; recaptures would no longer be duplicates")
+	}
+
+	css, _ := ioutil.ReadFile(filepath.Join(assetsDir(id), assetName(abs("/css/site.css"), ".css")))
+	for _, want := range []string{
+		`@import "` + assetName(abs("/css/more.css"), ".css") + `"`,
+		`url("` + assetName(abs("/bg.png"), "") + `")`,
+		`url("` + assetName(abs("/font.woff2"), "") + `")`,
+	} {
+		if !strings.Contains(string(css), want) {
+			t.Errorf("stored stylesheet is missing %s: %s", want, css)
+		}
+	}
+	more, _ := ioutil.ReadFile(filepath.Join(assetsDir(id), assetName(abs("/css/more.css"), ".css")))
+	if !strings.Contains(string(more), `@import "`+assetName(abs("/css/site.css"), ".css")+`"`) {
+		t.Errorf("imported stylesheet = %s", more)
+	}
+
+	// The stored assets replay with the page
+	mux := http.NewServeMux()
+	mux.HandleFunc("/pages/{id}/files/{path...}", handlePageAsset)
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/pages/"+id+"/"+local("/img/logo.png", ""), nil))
+	if rec.Code != http.StatusOK || rec.Body.String() != string(fakePNG) {
+		t.Errorf("GET stored image = %d %q", rec.Code, rec.Body)
+	}
+}
+
+func TestCaptureAssetsWithoutAny(t *testing.T) {
+	withPagesDir(t)
+	id, _ := storePage(PageMetadata{URL: "https://example.com/"}, "<p>Just text</p>", "")
+	if count, err := captureAssets(context.Background(), id); count != 0 || err != nil {
+		t.Errorf("captureAssets() = %d, %v", count, err)
+	}
+	if _, err := os.Stat(assetsDir(id)); !os.IsNotExist(err) {
+		t.Errorf("empty assets directory left behind: %v", err)
+	}
+}
+
+func TestResolveAsset(t *testing.T) {
+	base, _ := url.Parse("https://example.com/blog/post")
+	tests := []struct {
+		ref  string
+		want string
+	}{
+		{"img.png", "https://example.com/blog/img.png"},
+		{"//cdn.example.com/a.css#x", "https://cdn.example.com/a.css"},
+		{"data:image/png;base64,AAAA", ""},
+		{assetsPrefix + "abc.png", ""},
+		{"#section", ""},
+		{"javascript:alert(1)", ""},
+		{"https://www.google-analytics.com/collect", ""},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := resolveAsset(base, tt.ref); got != tt.want {
+			t.Errorf("resolveAsset(%q) = %q, want %q", tt.ref, got, tt.want)
+		}
+	}
+}
+
+func TestDocumentBase(t *testing.T) {
+	tests := []struct {
+		doc  string
+		want string
+	}{
+		{`<p>no base</p>`, "https://example.com/a/b"},
+		{`<base href="/static/">`, "https://example.com/static/"},
+		{`<base target="_blank"><base href="https://cdn.example.com/">`, "https://cdn.example.com/"},
+	}
+	for _, tt := range tests {
+		if got := documentBase(tt.doc, "https://example.com/a/b").String(); got != tt.want {
+			t.Errorf("documentBase(%q) = %q, want %q", tt.doc, got, tt.want)
+		}
+	}
+}
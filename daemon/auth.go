@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+type tokenContextKey struct{}
+
+// lookupToken finds the configured token presented as a bearer token or, for
+// clients that can't set headers such as feed readers, a token query
+// parameter.
+func lookupToken(r *http.Request) *TokenConfig {
+	presented := r.URL.Query().Get("token")
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		presented = strings.TrimPrefix(auth, "Bearer ")
+	}
+	if presented == "" {
+		return nil
+	}
+	for i := range config.Tokens {
+		token, err := config.Tokens[i].Token.Value()
+		if err != nil {
+			log.Printf("Error reading token %s: %v", config.Tokens[i].Name, err)
+			continue
+		}
+		if token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(presented)) == 1 {
+			return &config.Tokens[i]
+		}
+	}
+	return nil
+}
+
+// requestToken returns the token a request was authenticated with, or nil
+// when the API is open.
+func requestToken(r *http.Request) *TokenConfig {
+	token, _ := r.Context().Value(tokenContextKey{}).(*TokenConfig)
+	return token
+}
+
+// originAllowed reports whether a browser origin may call the API: the
+// daemon's own pages and the allowed origins. Requests without an Origin
+// header come from non-browser clients or same-origin navigations.
+func originAllowed(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" || ownOrigin(origin) {
+		return true
+	}
+	for _, allowed := range config.AllowedOrigins {
+		if allowed == origin {
+			return true
+		}
+		if scheme := strings.TrimSuffix(allowed, "*"); strings.HasSuffix(scheme, "://") && strings.HasPrefix(origin, scheme) {
+			return true
+		}
+	}
+	return false
+}
+
+// ownOrigin reports whether origin is the daemon's own, as served on its
+// port to this machine. The Host header can't tell, as a site rebinding its
+// name to this machine sends that name as both Host and Origin; the daemon
+// reached by another name needs it in config.AllowedOrigins.
+func ownOrigin(origin string) bool {
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	scheme := "http"
+	if tlsEnabled() {
+		scheme = "https"
+	}
+	if u.Scheme != scheme || u.Port() != strconv.Itoa(port) {
+		return false
+	}
+	switch u.Hostname() {
+	case "localhost", "127.0.0.1", "::1":
+		return true
+	}
+	return false
+}
+
+// safeMethod reports whether a request can't change anything.
+func safeMethod(r *http.Request) bool {
+	return r.Method == http.MethodGet || r.Method == http.MethodHead
+}
+
+// requireAuth wraps the API so that, once tokens, OIDC login or client
+// certificates are configured, every request must present a token, a
+// session cookie or a client certificate. Browsers navigating to a page are
+// sent to log in instead. Cross-origin requests are only let through, and
+// only given CORS headers, for allowed origins, so other websites can
+// neither read the API nor change anything through it. CORS preflight
+// requests are answered directly since browsers send them without
+// credentials, and so is the OpenSearch description.
+func requireAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		allowed := originAllowed(r)
+		w.Header().Add("Vary", "Origin")
+		if origin := r.Header.Get("Origin"); allowed && origin != "" {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+		}
+		if r.Method == http.MethodOptions {
+			if !allowed {
+				writeError(w, http.StatusForbidden, ErrForbidden, "Origin is not allowed")
+				return
+			}
+			w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type")
+			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE")
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		if !allowed && !safeMethod(r) {
+			writeError(w, http.StatusForbidden, ErrForbidden, "Origin is not allowed")
+			return
+		}
+		if (len(config.Tokens) == 0 && !oidcEnabled() && !clientCertsEnabled()) || strings.HasPrefix(r.URL.Path, "/auth/") || r.URL.Path == "/opensearch.xml" ||
+			strings.HasPrefix(r.URL.Path, "/pdfjs/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if user, ok := clientCertUser(r); !ok {
+			writeError(w, http.StatusForbidden, ErrForbidden, "Client certificate is not authorized")
+			return
+		} else if user != "" {
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), tokenContextKey{}, userToken(user))))
+			return
+		}
+		if token := lookupToken(r); token != nil {
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), tokenContextKey{}, token)))
+			return
+		}
+		if session := requestSession(r); session != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if oidcEnabled() && wantsHTML(r) {
+			http.Redirect(w, r, "/auth/login?"+url.Values{"next": {r.URL.RequestURI()}}.Encode(), http.StatusFound)
+			return
+		}
+		w.Header().Set("WWW-Authenticate", `Bearer realm="memento"`)
+		writeError(w, http.StatusUnauthorized, ErrUnauthorized, "A valid API token or login is required")
+	})
+}
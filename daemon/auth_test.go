@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// withTokens configures API tokens for the duration of a test.
+func withTokens(t *testing.T, tokens ...TokenConfig) {
+	t.Helper()
+	saved := config
+	t.Cleanup(func() { config = saved })
+	config.Tokens = tokens
+}
+
+func TestRequireAuth(t *testing.T) {
+	withTokens(t, TokenConfig{Name: "alex", Token: NewSecret("secret")})
+	var seen *TokenConfig
+	handler := requireAuth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = requestToken(r)
+	}))
+
+	tests := []struct {
+		name   string
+		target string
+		header string
+		want   int
+		user   string
+	}{
+		{"no token", "/search?q=go", "", http.StatusUnauthorized, ""},
+		{"wrong token", "/search?q=go", "Bearer nope", http.StatusUnauthorized, ""},
+		{"bearer token", "/search?q=go", "Bearer secret", http.StatusOK, "alex"},
+		{"query token", "/feed?token=secret", "", http.StatusOK, "alex"},
+	}
+	for _, tt := range tests {
+		seen = nil
+		r := httptest.NewRequest(http.MethodGet, tt.target, nil)
+		if tt.header != "" {
+			r.Header.Set("Authorization", tt.header)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, r)
+		if rec.Code != tt.want {
+			t.Errorf("%s: status = %d, want %d", tt.name, rec.Code, tt.want)
+		}
+		if tt.want == http.StatusUnauthorized {
+			if rec.Header().Get("WWW-Authenticate") == "" || decodeError(t, rec).Code != ErrUnauthorized {
+				t.Errorf("%s: missing challenge or UNAUTHORIZED code", tt.name)
+			}
+		}
+		if (seen == nil && tt.user != "") || (seen != nil && seen.Name != tt.user) {
+			t.Errorf("%s: handler saw token %+v, want %q", tt.name, seen, tt.user)
+		}
+	}
+}
+
+func TestRequireAuthOpenAPI(t *testing.T) {
+	withTokens(t)
+	called := false
+	handler := requireAuth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { called = true }))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/archive", nil))
+	if !called {
+		t.Error("request refused without configured tokens")
+	}
+}
+
+func TestRequireAuthOrigins(t *testing.T) {
+	withTokens(t)
+	handler := requireAuth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	tests := []struct {
+		method string
+		origin string
+		want   int
+		cors   bool
+	}{
+		{http.MethodPost, "", http.StatusOK, false},
+		{http.MethodPost, "chrome-extension://abcdef", http.StatusOK, true},
+		{http.MethodPost, "moz-extension://1234", http.StatusOK, true},
+		{http.MethodPost, "https://evil.example", http.StatusForbidden, false},
+		{http.MethodPost, fmt.Sprintf("http://localhost:%d", port), http.StatusOK, true},
+		{http.MethodPost, fmt.Sprintf("http://[::1]:%d", port), http.StatusOK, true},
+		{http.MethodPost, "http://localhost:9", http.StatusForbidden, false},
+		// A site rebinding its name to this machine sends it as the Host too
+		{http.MethodPost, "http://example.com", http.StatusForbidden, false},
+		{http.MethodDelete, "https://evil.example", http.StatusForbidden, false},
+		// Other websites may send simple requests but can't read the answer
+		{http.MethodGet, "https://evil.example", http.StatusOK, false},
+		{http.MethodOptions, "https://evil.example", http.StatusForbidden, false},
+		{http.MethodOptions, "chrome-extension://abcdef", http.StatusNoContent, true},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(tt.method, "/pages/x", nil)
+		if tt.origin != "" {
+			r.Header.Set("Origin", tt.origin)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, r)
+		if rec.Code != tt.want {
+			t.Errorf("%s from %q: status = %d, want %d", tt.method, tt.origin, rec.Code, tt.want)
+		}
+		if got := rec.Header().Get("Access-Control-Allow-Origin"); (got != "") != tt.cors || (tt.cors && got != tt.origin) {
+			t.Errorf("%s from %q: Access-Control-Allow-Origin = %q", tt.method, tt.origin, got)
+		}
+	}
+}
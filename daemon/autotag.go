@@ -0,0 +1,278 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/blevesearch/bleve"
+	"github.com/blevesearch/bleve/analysis"
+	"github.com/blevesearch/bleve/analysis/lang/en"
+)
+
+const (
+	// maxAutoTags bounds how many tags the tagger may give a page.
+	maxAutoTags = 10
+	// autoTagCandidates is how many of a page's most frequent words are
+	// weighed against the rest of the archive.
+	autoTagCandidates = 20
+	// autoTagMinCount is how often a word or an existing tag must be
+	// mentioned for the page to be tagged with it.
+	autoTagMinCount = 2
+)
+
+// AutoTagsConfig turns on tagging captured pages by topic. Tags are picked
+// from the archive's own tags the page mentions and the words that set the
+// page apart from the rest of the archive, or suggested by the chat model
+// set under ask when UseChatModel is set. They are kept apart from the
+// tags given by hand.
+type AutoTagsConfig struct {
+	Enabled      bool `json:"enabled"`
+	UseChatModel bool `json:"useChatModel"`
+	// MaxTags is how many tags a page is given at most.
+	MaxTags int `json:"maxTags"`
+}
+
+// autoTagPrompt asks the chat model for tags; its arguments are how many
+// and the archive's existing tags.
+const autoTagPrompt = `Give up to %d topic tags for the web page the user sends, as a comma-separated list of short lowercase tags and nothing else.
+Prefer these existing tags where they fit: %s`
+
+// tagWordPattern finds the words of a page a tag may be made of.
+var tagWordPattern = regexp.MustCompile(`\p{L}[\p{L}\p{N}]+`)
+
+// validateAutoTags checks the settings for tagging pages.
+func validateAutoTags(c AutoTagsConfig) error {
+	if c.MaxTags < 1 || c.MaxTags > maxAutoTags {
+		return fmt.Errorf("autoTags.maxTags must be between 1 and %d", maxAutoTags)
+	}
+	return nil
+}
+
+// allTags is a page's tags followed by those the tagger gave it.
+func (m PageMetadata) allTags() []string {
+	tags := append([]string(nil), m.Tags...)
+	for _, tag := range m.AutoTags {
+		if !hasTag(tags, tag) {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+// tagStopWords returns the words never made into tags: the index's stop
+// words.
+func tagStopWords() map[string]bool {
+	words := make(map[string]bool)
+	if list := config.Search.Analysis.StopWords; list != nil {
+		for _, word := range list {
+			words[strings.ToLower(word)] = true
+		}
+		return words
+	}
+	english := analysis.NewTokenMap()
+	if err := english.LoadBytes(en.EnglishStopWords); err == nil {
+		for word := range english {
+			words[word] = true
+		}
+	}
+	return words
+}
+
+// archiveTags returns the tags given by hand across pages, sorted, in the
+// first spelling found.
+func archiveTags(pages []Page) []string {
+	var tags []string
+	for _, page := range pages {
+		for _, tag := range page.Tags {
+			if !hasTag(tags, tag) {
+				tags = append(tags, tag)
+			}
+		}
+	}
+	sort.Strings(tags)
+	return tags
+}
+
+// autoTag returns up to n tags for a page's text that it hasn't been given
+// by hand, suggested by the chat model when autoTags.useChatModel is set.
+// Otherwise the existing tags the text mentions most come first, then the
+// words it uses often that few other pages do, weighed by TF-IDF against
+// the index.
+func autoTag(ctx context.Context, page PageMetadata, text string, existing []string, n int) ([]string, error) {
+	if config.AutoTags.UseChatModel {
+		words := strings.Fields(text)
+		if len(words) > summaryInputWords {
+			words = words[:summaryInputWords]
+		}
+		reply, err := chat(ctx, []chatMessage{
+			{Role: "system", Content: fmt.Sprintf(autoTagPrompt, n, strings.Join(existing, ", "))},
+			{Role: "user", Content: page.Title + "\n\n" + strings.Join(words, " ")},
+		})
+		if err != nil {
+			return nil, err
+		}
+		var tags []string
+		for _, tag := range splitTags(reply, ",") {
+			tag = strings.ToLower(strings.Trim(tag, "#\"'`. "))
+			if tag != "" && len(tags) < n && !hasTag(tags, tag) && !hasTag(page.Tags, tag) {
+				tags = append(tags, tag)
+			}
+		}
+		return tags, nil
+	}
+
+	lower := strings.ToLower(page.Title + "\n" + text)
+	var tags []string
+	add := func(tag string) {
+		if len(tags) < n && !hasTag(tags, tag) && !hasTag(page.Tags, tag) {
+			tags = append(tags, tag)
+		}
+	}
+
+	// The archive's own tags say best what its pages are about
+	type mention struct {
+		tag   string
+		count int
+	}
+	var mentions []mention
+	for _, tag := range existing {
+		pattern, err := regexp.Compile(`(^|[^\p{L}\p{N}])` + regexp.QuoteMeta(strings.ToLower(tag)) + `($|[^\p{L}\p{N}])`)
+		if err != nil {
+			continue
+		}
+		if count := len(pattern.FindAllStringIndex(lower, -1)); count >= autoTagMinCount {
+			mentions = append(mentions, mention{tag, count})
+		}
+	}
+	sort.SliceStable(mentions, func(i, j int) bool { return mentions[i].count > mentions[j].count })
+	for _, m := range mentions {
+		add(m.tag)
+	}
+	if len(tags) == n {
+		return tags, nil
+	}
+
+	terms, err := significantTerms(ctx, lower, autoTagMinCount)
+	if err != nil {
+		return nil, err
+	}
+	for _, term := range terms {
+		add(term.Term)
+	}
+	return tags, nil
+}
+
+// weightedTerm is a word of a page with how much it sets the page apart.
+type weightedTerm struct {
+	Term  string
+	Score float64
+}
+
+// significantTerms returns the words that set text apart from the rest of
+// the archive, most significant first: of its autoTagCandidates most
+// frequent words used at least min times, leaving out stop words, those
+// with the highest TF-IDF against the index.
+func significantTerms(ctx context.Context, text string, min int) ([]weightedTerm, error) {
+	counts := make(map[string]int)
+	for _, word := range tagWordPattern.FindAllString(strings.ToLower(text), -1) {
+		counts[word]++
+	}
+	stop := tagStopWords()
+	var candidates []string
+	for word, count := range counts {
+		if count >= min && len([]rune(word)) > 3 && !stop[word] {
+			candidates = append(candidates, word)
+		}
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		if counts[candidates[i]] != counts[candidates[j]] {
+			return counts[candidates[i]] > counts[candidates[j]]
+		}
+		return candidates[i] < candidates[j]
+	})
+	if len(candidates) > autoTagCandidates {
+		candidates = candidates[:autoTagCandidates]
+	}
+	total, err := index.DocCount()
+	if err != nil {
+		return nil, err
+	}
+	terms := make([]weightedTerm, 0, len(candidates))
+	for _, word := range candidates {
+		q := bleve.NewMatchQuery(word)
+		q.SetField("content")
+		result, err := index.SearchInContext(ctx, bleve.NewSearchRequestOptions(q, 0, 0, false))
+		if err != nil {
+			return nil, err
+		}
+		terms = append(terms, weightedTerm{word, float64(counts[word]) * math.Log(1+float64(total)/float64(1+result.Total))})
+	}
+	sort.SliceStable(terms, func(i, j int) bool { return terms[i].Score > terms[j].Score })
+	return terms, nil
+}
+
+// autoTagPending tags the pages the tagger hasn't looked at, indexing each
+// again with its tags. Bookmarks, which have no text of their own, are
+// left out. It stops at the first failure to reach the chat model.
+func autoTagPending(ctx context.Context) (int, error) {
+	pages, err := listPages()
+	if err != nil {
+		return 0, err
+	}
+	existing := archiveTags(pages)
+	count := 0
+	for _, page := range pages {
+		if page.AutoTagged != nil || page.Bookmark || !page.Indexed {
+			continue
+		}
+		text, err := readPageText(page.PageMetadata)
+		if err != nil {
+			log.Printf("Error reading page %s to tag: %v", page.ID, err)
+			continue
+		}
+		tags, err := autoTag(ctx, page.PageMetadata, text, existing, config.AutoTags.MaxTags)
+		if err != nil {
+			if errors.Is(err, errModelUnavailable) || ctx.Err() != nil {
+				return count, err
+			}
+			log.Printf("Error tagging page %s: %v", page.ID, err)
+			continue
+		}
+		now := time.Now().UTC()
+		err = reindexWith(ctx, page.ID, func(m *PageMetadata) error {
+			m.AutoTags = tags
+			m.AutoTagged = &now
+			return nil
+		})
+		if err != nil {
+			log.Printf("Error storing the tags of %s: %v", page.ID, err)
+			continue
+		}
+		count++
+	}
+	return count, nil
+}
+
+// watchAutoTags tags pages once they are indexed, looking them over
+// whenever a page is indexed and every summarySweepInterval.
+func watchAutoTags() {
+	if !config.AutoTags.Enabled {
+		return
+	}
+	sweepOnIndex(summarySweepInterval, func() {
+		count, err := autoTagPending(context.Background())
+		if err != nil {
+			log.Printf("Error tagging pages: %v", err)
+		}
+		if count > 0 {
+			log.Printf("Tagged %d pages", count)
+		}
+	})
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestAutoTagPending(t *testing.T) {
+	withPagesDir(t)
+	withIndex(t, nil)
+	saved := config
+	defer func() { config = saved }()
+	config.AutoTags = AutoTagsConfig{Enabled: true, MaxTags: 3}
+
+	tagged, err := storePage(PageMetadata{URL: "https://k8s.example/", Title: "K8s", Tags: []string{"Kubernetes"}}, "", "Kubernetes notes. Pods and nodes.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := storePage(PageMetadata{URL: "https://helm.example/", Title: "Helm"}, "", "Deploying Kubernetes with Helm charts. Helm templates the manifests and Kubernetes applies them. Helm releases can be rolled back.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	indexExistingFiles(context.Background())
+
+	if count, err := autoTagPending(context.Background()); err != nil || count != 2 {
+		t.Fatalf("autoTagPending = %d, %v", count, err)
+	}
+	// The archive's own tag comes first, in its spelling
+	page, _ := loadPage(id)
+	if want := []string{"Kubernetes", "helm"}; !reflect.DeepEqual(page.AutoTags, want) || len(page.Tags) != 0 {
+		t.Errorf("tags = %q, auto tags = %q, want %q", page.Tags, page.AutoTags, want)
+	}
+	// Tags given by hand aren't given again
+	if page, _ := loadPage(tagged); len(page.AutoTags) != 0 || page.AutoTagged == nil {
+		t.Errorf("auto tags of a tagged page = %q", page.AutoTags)
+	}
+	if count, _ := autoTagPending(context.Background()); count != 0 {
+		t.Errorf("tagged %d pages again", count)
+	}
+
+	search := func(s StructuredQuery) []SearchResult {
+		t.Helper()
+		q, err := s.bleveQuery()
+		if err != nil {
+			t.Fatal(err)
+		}
+		results, err := runSearch(context.Background(), q, SearchOptions{Highlight: "none", Sort: "score"}, Localizer{Lang: defaultLocale})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return results
+	}
+	if results := search(StructuredQuery{Tags: []string{"helm"}}); len(results) != 1 || results[0].ID != id {
+		t.Errorf("pages tagged helm = %+v", results)
+	}
+	if results := search(StructuredQuery{AutoTags: []string{"kubernetes"}}); len(results) != 1 || results[0].ID != id {
+		t.Errorf("pages auto-tagged kubernetes = %+v", results)
+	}
+
+	// Giving a tag by hand takes it from the tagger, and correcting the
+	// tagger drops its tags from searches
+	mux := http.NewServeMux()
+	mux.HandleFunc("/pages/{id}", handlePage)
+	patch := func(body string) {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodPatch, "/pages/"+id, strings.NewReader(body)))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("PATCH %s = %d: %s", body, rec.Code, rec.Body)
+		}
+	}
+	patch(`{"tags": ["kubernetes"]}`)
+	if page, _ := loadPage(id); !reflect.DeepEqual(page.AutoTags, []string{"helm"}) {
+		t.Errorf("auto tags after tagging by hand = %q", page.AutoTags)
+	}
+	patch(`{"autoTags": []}`)
+	if results := search(StructuredQuery{Tags: []string{"helm"}}); len(results) != 0 {
+		t.Errorf("pages tagged helm after correcting = %+v", results)
+	}
+	if results := search(StructuredQuery{Tags: []string{"kubernetes"}}); len(results) != 2 {
+		t.Errorf("pages tagged kubernetes = %+v", results)
+	}
+}
+
+func TestAutoTagChatModel(t *testing.T) {
+	withPagesDir(t)
+	withIndex(t, nil)
+	model := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"message": {"role": "assistant", "content": "#Cooking, bread, \"Cooking\", baking."}}`))
+	}))
+	defer model.Close()
+	saved := config
+	defer func() { config = saved }()
+	config.Ask.URL, config.Ask.Model = model.URL, "test"
+	config.AutoTags = AutoTagsConfig{Enabled: true, UseChatModel: true, MaxTags: 3}
+
+	tags, err := autoTag(context.Background(), PageMetadata{Title: "Rye", Tags: []string{"Bread"}}, "A rye loaf.", nil, 3)
+	if want := []string{"cooking", "baking"}; err != nil || !reflect.DeepEqual(tags, want) {
+		t.Errorf("tags = %q, %v, want %q", tags, err, want)
+	}
+}
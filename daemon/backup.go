@@ -0,0 +1,683 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// A backup repository holds each file as a list of content-addressed
+// chunks, so a chunk shared by several files or backups is stored once:
+//
+//	config.json             the repository version
+//	chunks/ab/ab12...       gzipped chunks, named by the SHA-256 of their content
+//	snapshots/<id>.json     the files of one backup and their chunks
+//
+// Repositories are directories, or S3 buckets given as s3://bucket/prefix.
+const backupVersion = 1
+
+const (
+	// Chunk boundaries are cut where a rolling hash of the content matches
+	// chunkMask, so an edit only changes the chunks around it, giving
+	// chunks of about 64 KiB past the minimum.
+	minChunkSize = 16 << 10
+	maxChunkSize = 1 << 20
+	chunkMask    = uint64(1<<16-1) << 48
+)
+
+// backupStateFiles are the files besides the pages that a backup holds. The
+// search index is left out unless asked for, as it can be rebuilt from the
+// pages.
+var backupStateFiles = []string{
+	configFile, boilerplateFile, clicksFile, boostsFile, changesFile, signingKeyFile,
+	recaptureQueueFile, savedSearchesFile, watchStateFile, feedsFile,
+}
+
+// gearTable holds a fixed pseudo-random value for each byte, which the
+// rolling hash adds up.
+var gearTable = func() [256]uint64 {
+	var table [256]uint64
+	for i := range table {
+		sum := sha256.Sum256([]byte{byte(i)})
+		table[i] = binary.LittleEndian.Uint64(sum[:8])
+	}
+	return table
+}()
+
+// nextChunk returns the length of the chunk data starts with.
+func nextChunk(data []byte) int {
+	if len(data) <= minChunkSize {
+		return len(data)
+	}
+	n := len(data)
+	if n > maxChunkSize {
+		n = maxChunkSize
+	}
+	var hash uint64
+	for i := minChunkSize; i < n; i++ {
+		hash = hash<<1 + gearTable[data[i]]
+		if hash&chunkMask == 0 {
+			return i + 1
+		}
+	}
+	return n
+}
+
+// splitChunks cuts data into content-defined chunks.
+func splitChunks(data []byte) [][]byte {
+	var chunks [][]byte
+	for len(data) > 0 {
+		n := nextChunk(data)
+		chunks = append(chunks, data[:n])
+		data = data[n:]
+	}
+	return chunks
+}
+
+type backupFile struct {
+	// Path is relative to the daemon's directory, with forward slashes.
+	Path    string      `json:"path"`
+	Size    int64       `json:"size"`
+	Mode    os.FileMode `json:"mode"`
+	ModTime time.Time   `json:"modTime"`
+	Chunks  []string    `json:"chunks"`
+}
+
+type backupSnapshot struct {
+	ID    string       `json:"-"`
+	Time  time.Time    `json:"time"`
+	Host  string       `json:"host,omitempty"`
+	Files []backupFile `json:"files"`
+}
+
+// backupStats counts what a backup stored, Added being the compressed size
+// of its new chunks.
+type backupStats struct {
+	Files     int
+	Unchanged int
+	NewChunks int
+	Added     int64
+}
+
+// backupObject is a file in a backup repository, named by its path in the
+// repository with forward slashes.
+type backupObject struct {
+	Name string
+	Size int64
+}
+
+// backupStore holds the files of a backup repository. Missing files are
+// reported as os.ErrNotExist.
+type backupStore interface {
+	read(name string) ([]byte, error)
+	// write replaces a file whole, never leaving part of it behind.
+	write(name string, data []byte) error
+	// create writes a file that must not exist yet, failing with
+	// os.ErrExist if it does.
+	create(name string, data []byte) error
+	exists(name string) (bool, error)
+	remove(name string) error
+	// list returns the files under the directory dir.
+	list(dir string) ([]backupObject, error)
+}
+
+// openBackupStore returns the store of a repository: an S3 bucket for
+// s3:// URLs, reached with the backup.s3 config, or else a directory.
+func openBackupStore(repo string) (backupStore, error) {
+	if strings.HasPrefix(repo, "s3://") {
+		return newS3Store(repo, config.Backup.S3)
+	}
+	return dirStore(repo), nil
+}
+
+// dirStore is a backup repository in a local directory.
+type dirStore string
+
+func (d dirStore) path(name string) string {
+	return filepath.Join(string(d), filepath.FromSlash(name))
+}
+
+func (d dirStore) read(name string) ([]byte, error) {
+	return ioutil.ReadFile(d.path(name))
+}
+
+func (d dirStore) write(name string, data []byte) error {
+	path := d.path(name)
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	// Written aside first, so an interrupted backup leaves no partial file
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func (d dirStore) create(name string, data []byte) error {
+	path := d.path(name)
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+func (d dirStore) exists(name string) (bool, error) {
+	_, err := os.Stat(d.path(name))
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (d dirStore) remove(name string) error {
+	return os.Remove(d.path(name))
+}
+
+func (d dirStore) list(dir string) ([]backupObject, error) {
+	var objects []backupObject
+	err := filepath.Walk(d.path(dir), func(path string, info os.FileInfo, err error) error {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil || !info.Mode().IsRegular() || strings.HasSuffix(path, ".tmp") {
+			return err
+		}
+		name, err := filepath.Rel(string(d), path)
+		if err != nil {
+			return err
+		}
+		objects = append(objects, backupObject{Name: filepath.ToSlash(name), Size: info.Size()})
+		return nil
+	})
+	return objects, err
+}
+
+// chunkName is where a chunk is stored in a repository.
+func chunkName(id string) string {
+	return "chunks/" + id[:2] + "/" + id
+}
+
+func chunkID(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// writeChunk stores a chunk unless the repository has it already, returning
+// the bytes added.
+func writeChunk(store backupStore, id string, data []byte) (int64, error) {
+	if exists, err := store.exists(chunkName(id)); err != nil || exists {
+		return 0, err
+	}
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	zw.Write(data)
+	if err := zw.Close(); err != nil {
+		return 0, err
+	}
+	return int64(buf.Len()), store.write(chunkName(id), buf.Bytes())
+}
+
+// readChunk reads a chunk, checking that its content matches its ID.
+func readChunk(store backupStore, id string) ([]byte, error) {
+	stored, err := store.read(chunkName(id))
+	if err != nil {
+		return nil, err
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(stored))
+	if err != nil {
+		return nil, fmt.Errorf("chunk %s: %v", id, err)
+	}
+	data, err := ioutil.ReadAll(zr)
+	if err != nil {
+		return nil, fmt.Errorf("chunk %s: %v", id, err)
+	}
+	if chunkID(data) != id {
+		return nil, fmt.Errorf("chunk %s: content doesn't match its hash", id)
+	}
+	return data, nil
+}
+
+// openBackupRepo checks that repo is a backup repository, creating one
+// there when create is set and it doesn't exist, and returns its store.
+func openBackupRepo(repo string, create bool) (backupStore, error) {
+	store, err := openBackupStore(repo)
+	if err != nil {
+		return nil, err
+	}
+	data, err := store.read("config.json")
+	if errors.Is(err, os.ErrNotExist) && create {
+		data, _ = json.Marshal(map[string]int{"version": backupVersion})
+		return store, store.write("config.json", data)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s is not a backup repository: %v", repo, err)
+	}
+	var repoConfig struct {
+		Version int `json:"version"`
+	}
+	if err := json.Unmarshal(data, &repoConfig); err != nil {
+		return nil, fmt.Errorf("reading repository config: %v", err)
+	}
+	if repoConfig.Version != backupVersion {
+		return nil, fmt.Errorf("unsupported repository version %d", repoConfig.Version)
+	}
+	return store, nil
+}
+
+// lockBackupRepo keeps backups and prunes of a repository from running at
+// the same time. The returned function releases the lock.
+func lockBackupRepo(repo string, store backupStore) (func(), error) {
+	err := store.create("lock", []byte(fmt.Sprintf("%d\n", os.Getpid())))
+	if errors.Is(err, os.ErrExist) {
+		return nil, fmt.Errorf("repository %s is locked; remove its lock file if no backup is running", repo)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return func() {
+		if err := store.remove("lock"); err != nil {
+			log.Printf("Error unlocking backup repository %s: %v", repo, err)
+		}
+	}, nil
+}
+
+// listSnapshots returns the repository's snapshots, oldest first.
+func listSnapshots(store backupStore) ([]backupSnapshot, error) {
+	objects, err := store.list("snapshots")
+	if err != nil {
+		return nil, err
+	}
+	var snapshots []backupSnapshot
+	for _, object := range objects {
+		if !strings.HasSuffix(object.Name, ".json") {
+			continue
+		}
+		data, err := store.read(object.Name)
+		if err != nil {
+			return nil, err
+		}
+		snapshot := backupSnapshot{ID: strings.TrimSuffix(strings.TrimPrefix(object.Name, "snapshots/"), ".json")}
+		if err := json.Unmarshal(data, &snapshot); err != nil {
+			return nil, fmt.Errorf("snapshot %s: %v", snapshot.ID, err)
+		}
+		snapshots = append(snapshots, snapshot)
+	}
+	sort.Slice(snapshots, func(i, j int) bool { return snapshots[i].Time.Before(snapshots[j].Time) })
+	return snapshots, nil
+}
+
+// walkFiles lists the regular files under dir.
+func walkFiles(dir string) ([]string, error) {
+	var paths []string
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.Mode().IsRegular() {
+			paths = append(paths, path)
+		}
+		return nil
+	})
+	return paths, err
+}
+
+// backupSources lists the files a backup holds besides the index.
+func backupSources() ([]string, error) {
+	paths, err := walkFiles(pagesDir)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range backupStateFiles {
+		if info, err := os.Stat(name); err == nil && info.Mode().IsRegular() {
+			paths = append(paths, name)
+		}
+	}
+	return paths, nil
+}
+
+// backupArchive stores the pages and state files, and the search index when
+// withIndex is set, in a new snapshot. Files with the size and modification
+// time they had in the latest snapshot aren't read again, and only chunks
+// the repository lacks are written. The index is copied between indexing
+// passes, so that it isn't caught halfway through one.
+func backupArchive(repo string, withIndex bool) (backupSnapshot, backupStats, error) {
+	var stats backupStats
+	store, err := openBackupRepo(repo, true)
+	if err != nil {
+		return backupSnapshot{}, stats, err
+	}
+	unlock, err := lockBackupRepo(repo, store)
+	if err != nil {
+		return backupSnapshot{}, stats, err
+	}
+	defer unlock()
+
+	snapshots, err := listSnapshots(store)
+	if err != nil {
+		return backupSnapshot{}, stats, err
+	}
+	parent := make(map[string]backupFile)
+	if len(snapshots) > 0 {
+		for _, file := range snapshots[len(snapshots)-1].Files {
+			parent[file.Path] = file
+		}
+	}
+
+	host, _ := os.Hostname()
+	snapshot := backupSnapshot{Time: time.Now().UTC(), Host: host}
+	storeFiles := func(paths []string) error {
+		for _, path := range paths {
+			info, err := os.Stat(path)
+			if err != nil {
+				return err
+			}
+			file := backupFile{Path: filepath.ToSlash(path), Size: info.Size(), Mode: info.Mode().Perm(), ModTime: info.ModTime().UTC()}
+			stats.Files++
+			if previous, ok := parent[file.Path]; ok && previous.Size == file.Size && previous.ModTime.Equal(file.ModTime) {
+				file.Chunks = previous.Chunks
+				snapshot.Files = append(snapshot.Files, file)
+				stats.Unchanged++
+				continue
+			}
+			data, err := ioutil.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			file.Chunks = []string{}
+			for _, chunk := range splitChunks(data) {
+				id := chunkID(chunk)
+				added, err := writeChunk(store, id, chunk)
+				if err != nil {
+					return err
+				}
+				if added > 0 {
+					stats.NewChunks++
+					stats.Added += added
+				}
+				file.Chunks = append(file.Chunks, id)
+			}
+			snapshot.Files = append(snapshot.Files, file)
+		}
+		return nil
+	}
+
+	paths, err := backupSources()
+	if err == nil {
+		err = storeFiles(paths)
+	}
+	if err == nil && withIndex {
+		indexMu.Lock()
+		if paths, err = walkFiles(indexDir); err == nil {
+			err = storeFiles(paths)
+		}
+		indexMu.Unlock()
+	}
+	if err != nil {
+		return backupSnapshot{}, stats, err
+	}
+
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		return backupSnapshot{}, stats, err
+	}
+	snapshot.ID = chunkID(data)[:12]
+	return snapshot, stats, store.write("snapshots/"+snapshot.ID+".json", data)
+}
+
+// findSnapshot returns the snapshot with the given ID, or the latest when
+// id is empty.
+func findSnapshot(store backupStore, id string) (backupSnapshot, error) {
+	snapshots, err := listSnapshots(store)
+	if err != nil {
+		return backupSnapshot{}, err
+	}
+	if len(snapshots) == 0 {
+		return backupSnapshot{}, errors.New("repository has no snapshots")
+	}
+	if id == "" {
+		return snapshots[len(snapshots)-1], nil
+	}
+	for _, snapshot := range snapshots {
+		if snapshot.ID == id {
+			return snapshot, nil
+		}
+	}
+	return backupSnapshot{}, fmt.Errorf("no snapshot %s", id)
+}
+
+// restoreSnapshot writes a snapshot's files under dir, which must be empty
+// or not exist yet.
+func restoreSnapshot(repo, id, dir string) (backupSnapshot, error) {
+	store, err := openBackupRepo(repo, false)
+	if err != nil {
+		return backupSnapshot{}, err
+	}
+	snapshot, err := findSnapshot(store, id)
+	if err != nil {
+		return snapshot, err
+	}
+	if existing, err := ioutil.ReadDir(dir); err == nil && len(existing) > 0 {
+		return snapshot, fmt.Errorf("%s is not empty", dir)
+	}
+	for _, file := range snapshot.Files {
+		path := filepath.Join(dir, filepath.FromSlash(file.Path))
+		if !strings.HasPrefix(path, filepath.Clean(dir)+string(filepath.Separator)) {
+			return snapshot, fmt.Errorf("snapshot has invalid path %q", file.Path)
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return snapshot, err
+		}
+		var data bytes.Buffer
+		for _, chunk := range file.Chunks {
+			content, err := readChunk(store, chunk)
+			if err != nil {
+				return snapshot, fmt.Errorf("restoring %s: %v", file.Path, err)
+			}
+			data.Write(content)
+		}
+		if int64(data.Len()) != file.Size {
+			return snapshot, fmt.Errorf("restoring %s: got %d bytes, want %d", file.Path, data.Len(), file.Size)
+		}
+		if err := ioutil.WriteFile(path, data.Bytes(), file.Mode); err != nil {
+			return snapshot, err
+		}
+		if err := os.Chtimes(path, file.ModTime, file.ModTime); err != nil {
+			return snapshot, err
+		}
+	}
+	return snapshot, nil
+}
+
+// pruneBackups removes all but the latest keep snapshots and the chunks
+// no remaining snapshot refers to, returning how many of each it removed
+// and the bytes freed.
+func pruneBackups(repo string, keep int) (snapshots, chunks int, freed int64, err error) {
+	store, err := openBackupRepo(repo, false)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	unlock, err := lockBackupRepo(repo, store)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	defer unlock()
+
+	all, err := listSnapshots(store)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	if len(all) > keep {
+		for _, snapshot := range all[:len(all)-keep] {
+			if err := store.remove("snapshots/" + snapshot.ID + ".json"); err != nil {
+				return snapshots, 0, 0, err
+			}
+			snapshots++
+		}
+		all = all[len(all)-keep:]
+	}
+	used := make(map[string]bool)
+	for _, snapshot := range all {
+		for _, file := range snapshot.Files {
+			for _, chunk := range file.Chunks {
+				used[chunk] = true
+			}
+		}
+	}
+	objects, err := store.list("chunks")
+	if err != nil {
+		return snapshots, 0, 0, err
+	}
+	for _, object := range objects {
+		if used[path.Base(object.Name)] {
+			continue
+		}
+		if err := store.remove(object.Name); err != nil {
+			return snapshots, chunks, freed, err
+		}
+		chunks++
+		freed += object.Size
+	}
+	return snapshots, chunks, freed, nil
+}
+
+// verifyBackups reads every chunk the snapshots refer to, returning the
+// problems found: chunks missing, unreadable or not matching their hash.
+func verifyBackups(repo string) (snapshots, chunks int, problems []string, err error) {
+	store, err := openBackupRepo(repo, false)
+	if err != nil {
+		return 0, 0, nil, err
+	}
+	all, err := listSnapshots(store)
+	if err != nil {
+		return 0, 0, nil, err
+	}
+	checked := make(map[string]error)
+	for _, snapshot := range all {
+		for _, file := range snapshot.Files {
+			for _, chunk := range file.Chunks {
+				chunkErr, seen := checked[chunk]
+				if !seen {
+					_, chunkErr = readChunk(store, chunk)
+					checked[chunk] = chunkErr
+				}
+				if chunkErr != nil {
+					problems = append(problems, fmt.Sprintf("snapshot %s, %s: %v", snapshot.ID, file.Path, chunkErr))
+				}
+			}
+		}
+	}
+	return len(all), len(checked), problems, nil
+}
+
+func runBackupCommand(args []string) {
+	flags := flag.NewFlagSet("backup", flag.ExitOnError)
+	withIndex := flags.Bool("index", config.Backup.IncludeIndex, "also back up the search index")
+	flags.Parse(args)
+	repo := config.Backup.Repo
+	if flags.NArg() == 1 {
+		repo = flags.Arg(0)
+	}
+	if flags.NArg() > 1 || repo == "" {
+		fmt.Fprintln(os.Stderr, "Usage: daemon backup [-index] [<repo>]")
+		os.Exit(2)
+	}
+	snapshot, stats, err := backupArchive(repo, *withIndex)
+	if err != nil {
+		log.Fatalf("Backup failed: %v", err)
+	}
+	log.Printf("Stored snapshot %s: %d files (%d unchanged), %d new chunks, %d bytes added",
+		snapshot.ID, stats.Files, stats.Unchanged, stats.NewChunks, stats.Added)
+}
+
+func runBackupSnapshotsCommand(args []string) {
+	if len(args) != 1 {
+		fmt.Fprintln(os.Stderr, "Usage: daemon backup-snapshots <repo>")
+		os.Exit(2)
+	}
+	store, err := openBackupRepo(args[0], false)
+	if err != nil {
+		log.Fatalf("Error opening repository: %v", err)
+	}
+	snapshots, err := listSnapshots(store)
+	if err != nil {
+		log.Fatalf("Error listing snapshots: %v", err)
+	}
+	for _, snapshot := range snapshots {
+		var size int64
+		for _, file := range snapshot.Files {
+			size += file.Size
+		}
+		fmt.Printf("%s  %s  %s  %d files, %d bytes\n", snapshot.ID, snapshot.Time.Local().Format("2006-01-02 15:04:05"), snapshot.Host, len(snapshot.Files), size)
+	}
+}
+
+func runBackupRestoreCommand(args []string) {
+	flags := flag.NewFlagSet("backup-restore", flag.ExitOnError)
+	id := flags.String("snapshot", "", "ID of the snapshot to restore; the latest by default")
+	flags.Parse(args)
+	if flags.NArg() != 2 {
+		fmt.Fprintln(os.Stderr, "Usage: daemon backup-restore [-snapshot ID] <repo> <dir>")
+		os.Exit(2)
+	}
+	snapshot, err := restoreSnapshot(flags.Arg(0), *id, flags.Arg(1))
+	if err != nil {
+		log.Fatalf("Restore failed: %v", err)
+	}
+	log.Printf("Restored %d files of snapshot %s to %s", len(snapshot.Files), snapshot.ID, flags.Arg(1))
+}
+
+func runBackupPruneCommand(args []string) {
+	flags := flag.NewFlagSet("backup-prune", flag.ExitOnError)
+	keep := flags.Int("keep", 7, "number of latest snapshots to keep")
+	flags.Parse(args)
+	if flags.NArg() != 1 || *keep < 1 {
+		fmt.Fprintln(os.Stderr, "Usage: daemon backup-prune [-keep N] <repo>")
+		os.Exit(2)
+	}
+	snapshots, chunks, freed, err := pruneBackups(flags.Arg(0), *keep)
+	if err != nil {
+		log.Fatalf("Prune failed: %v", err)
+	}
+	log.Printf("Removed %d snapshots and %d chunks, freeing %d bytes", snapshots, chunks, freed)
+}
+
+func runBackupVerifyCommand(args []string) {
+	if len(args) != 1 {
+		fmt.Fprintln(os.Stderr, "Usage: daemon backup-verify <repo>")
+		os.Exit(2)
+	}
+	snapshots, chunks, problems, err := verifyBackups(args[0])
+	if err != nil {
+		log.Fatalf("Verification failed: %v", err)
+	}
+	for _, problem := range problems {
+		fmt.Println(problem)
+	}
+	if len(problems) > 0 {
+		log.Fatalf("Found %d problems in %d snapshots", len(problems), snapshots)
+	}
+	fmt.Printf("Checked %d chunks of %d snapshots; no problems found\n", chunks, snapshots)
+}
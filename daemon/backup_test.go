@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSplitChunks(t *testing.T) {
+	data := make([]byte, 2<<20)
+	rand.New(rand.NewSource(1)).Read(data)
+	chunks := splitChunks(data)
+	if len(chunks) < 4 {
+		t.Fatalf("split 2 MiB into %d chunks", len(chunks))
+	}
+	if !bytes.Equal(bytes.Join(chunks, nil), data) {
+		t.Fatal("chunks don't add up to the data")
+	}
+	for i, chunk := range chunks[:len(chunks)-1] {
+		if len(chunk) < minChunkSize || len(chunk) > maxChunkSize {
+			t.Errorf("chunk %d has %d bytes", i, len(chunk))
+		}
+	}
+
+	// An insertion only changes the chunks around it
+	edited := append(append(append([]byte{}, data[:1<<20]...), "inserted"...), data[1<<20:]...)
+	ids := make(map[string]bool)
+	for _, chunk := range chunks {
+		ids[chunkID(chunk)] = true
+	}
+	changed := 0
+	for _, chunk := range splitChunks(edited) {
+		if !ids[chunkID(chunk)] {
+			changed++
+		}
+	}
+	if changed > 2 {
+		t.Errorf("%d chunks changed after an insertion, want at most 2", changed)
+	}
+}
+
+func TestBackupRestore(t *testing.T) {
+	withPagesDir(t)
+	repo := filepath.Join(t.TempDir(), "repo")
+	big := make([]byte, 300<<10)
+	rand.New(rand.NewSource(2)).Read(big)
+	files := map[string][]byte{
+		filepath.Join(pagesDir, "a.json"):       []byte(`{"id": "a"}`),
+		filepath.Join(pagesDir, "a.pdf"):        big,
+		filepath.Join(pagesDir, "copy", "b.md"): big,
+		savedSearchesFile:                       []byte(`[]`),
+	}
+	for path, data := range files {
+		os.MkdirAll(filepath.Dir(path), 0755)
+		if err := ioutil.WriteFile(path, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	first, stats, err := backupArchive(repo, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bigChunks := len(splitChunks(big))
+	if stats.Files != 4 || stats.NewChunks != bigChunks+2 {
+		t.Errorf("first backup = %+v, want 4 files and the copied file's chunks stored once", stats)
+	}
+	if _, stats, err = backupArchive(repo, false); err != nil || stats.Unchanged != 4 || stats.NewChunks != 0 {
+		t.Errorf("second backup = %+v, %v, want nothing new", stats, err)
+	}
+	ioutil.WriteFile(filepath.Join(pagesDir, "a.json"), []byte(`{"id": "a", "tags": ["x"]}`), 0644)
+	files[filepath.Join(pagesDir, "a.json")] = []byte(`{"id": "a", "tags": ["x"]}`)
+	latest, stats, err := backupArchive(repo, false)
+	if err != nil || stats.NewChunks != 1 {
+		t.Errorf("backup after an edit = %+v, %v, want one new chunk", stats, err)
+	}
+
+	dir := filepath.Join(t.TempDir(), "restored")
+	if _, err := restoreSnapshot(repo, "", dir); err != nil {
+		t.Fatal(err)
+	}
+	for path, want := range files {
+		if got, err := ioutil.ReadFile(filepath.Join(dir, path)); err != nil || !bytes.Equal(got, want) {
+			t.Errorf("restored %s = %d bytes, %v", path, len(got), err)
+		}
+	}
+	if _, err := restoreSnapshot(repo, first.ID, dir); err == nil {
+		t.Error("restored over a non-empty directory")
+	}
+
+	snapshots, chunks, _, err := pruneBackups(repo, 1)
+	if err != nil || snapshots != 2 || chunks != 1 {
+		t.Errorf("prune = %d snapshots, %d chunks, %v, want 2 and the old page's chunk", snapshots, chunks, err)
+	}
+	if _, err := findSnapshot(dirStore(repo), latest.ID); err != nil {
+		t.Errorf("prune removed the latest snapshot: %v", err)
+	}
+	if _, _, problems, err := verifyBackups(repo); err != nil || len(problems) != 0 {
+		t.Errorf("verify = %v, %v, want no problems", problems, err)
+	}
+
+	// Corrupt one chunk and remove another
+	ids := latest.Files[0].Chunks
+	ioutil.WriteFile(dirStore(repo).path(chunkName(ids[0])), []byte("garbage"), 0600)
+	var other string
+	for _, file := range latest.Files {
+		if file.Chunks[0] != ids[0] {
+			other = file.Chunks[0]
+			break
+		}
+	}
+	os.Remove(dirStore(repo).path(chunkName(other)))
+	if _, _, problems, err := verifyBackups(repo); err != nil || len(problems) < 2 {
+		t.Errorf("verify after damage = %v, %v, want both chunks reported", problems, err)
+	}
+}
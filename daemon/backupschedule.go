@@ -0,0 +1,204 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// BackupConfig backs the archive up to Repo, a directory or an
+// s3://bucket/prefix URL, on Schedule, a cron expression in local time such
+// as "0 3 * * *". After each backup only the latest Keep snapshots are
+// kept. IncludeIndex also backs up the search index, which saves rebuilding
+// it after a restore. Scheduled backups are off when Repo is empty.
+type BackupConfig struct {
+	Repo         string   `json:"repo"`
+	Schedule     string   `json:"schedule"`
+	Keep         int      `json:"keep"`
+	IncludeIndex bool     `json:"includeIndex"`
+	S3           S3Config `json:"s3"`
+}
+
+func validateBackup(c BackupConfig) error {
+	schedule, err := parseCron(c.Schedule)
+	if err != nil {
+		return fmt.Errorf("backup.schedule: %v", err)
+	}
+	if schedule.next(time.Now()).IsZero() {
+		return fmt.Errorf("backup.schedule %q never runs", c.Schedule)
+	}
+	if c.Keep < 1 {
+		return fmt.Errorf("backup.keep must be at least 1")
+	}
+	if strings.HasPrefix(c.Repo, "s3://") {
+		return validateS3(c.S3)
+	}
+	return nil
+}
+
+// cronSchedule is a parsed cron expression: the minutes, hours, days of the
+// month, months and days of the week it allows, as bit sets.
+type cronSchedule struct {
+	minute, hour, day, month, weekday uint64
+	// anyDay and anyWeekday are set for fields given as *. As in cron, a
+	// day matching either day field is allowed when both are restricted.
+	anyDay, anyWeekday bool
+}
+
+// cronFields are the ranges of the fields of a cron expression. Sunday is
+// 0 or 7.
+var cronFields = []struct {
+	name     string
+	min, max int
+}{
+	{"minute", 0, 59},
+	{"hour", 0, 23},
+	{"day of month", 1, 31},
+	{"month", 1, 12},
+	{"day of week", 0, 7},
+}
+
+// parseCron parses a cron expression of five fields: minute, hour, day of
+// month, month and day of week. Each field is * or a comma-separated list
+// of values and ranges such as 1-5, either of which can take a step such
+// as */15.
+func parseCron(expr string) (cronSchedule, error) {
+	fields := strings.Fields(expr)
+	if len(fields) != len(cronFields) {
+		return cronSchedule{}, fmt.Errorf("%q must have %d fields: minute, hour, day of month, month and day of week", expr, len(cronFields))
+	}
+	var sets [5]uint64
+	for i, field := range fields {
+		set, err := parseCronField(field, cronFields[i].min, cronFields[i].max)
+		if err != nil {
+			return cronSchedule{}, fmt.Errorf("%s field %q: %v", cronFields[i].name, field, err)
+		}
+		sets[i] = set
+	}
+	// Sunday is bit 0 either way
+	if sets[4]&(1<<7) != 0 {
+		sets[4] = sets[4]&^(1<<7) | 1
+	}
+	return cronSchedule{
+		minute: sets[0], hour: sets[1], day: sets[2], month: sets[3], weekday: sets[4],
+		anyDay: fields[2] == "*", anyWeekday: fields[4] == "*",
+	}, nil
+}
+
+func parseCronField(field string, min, max int) (uint64, error) {
+	var set uint64
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			n, err := strconv.Atoi(part[i+1:])
+			if err != nil || n < 1 {
+				return 0, fmt.Errorf("invalid step %q", part[i+1:])
+			}
+			step, part = n, part[:i]
+		}
+		low, high := min, max
+		if part != "*" {
+			bounds := strings.SplitN(part, "-", 2)
+			var err error
+			if low, err = strconv.Atoi(bounds[0]); err != nil {
+				return 0, fmt.Errorf("invalid value %q", bounds[0])
+			}
+			high = low
+			if len(bounds) == 2 {
+				if high, err = strconv.Atoi(bounds[1]); err != nil {
+					return 0, fmt.Errorf("invalid value %q", bounds[1])
+				}
+			} else if step > 1 {
+				high = max
+			}
+		}
+		if low < min || high > max || low > high {
+			return 0, fmt.Errorf("values must be between %d and %d", min, max)
+		}
+		for v := low; v <= high; v += step {
+			set |= 1 << uint(v)
+		}
+	}
+	return set, nil
+}
+
+// dayMatches reports whether the schedule allows the day of t.
+func (s cronSchedule) dayMatches(t time.Time) bool {
+	day := s.day&(1<<uint(t.Day())) != 0
+	weekday := s.weekday&(1<<uint(t.Weekday())) != 0
+	switch {
+	case s.anyDay && s.anyWeekday:
+		return true
+	case s.anyDay:
+		return weekday
+	case s.anyWeekday:
+		return day
+	}
+	return day || weekday
+}
+
+// next returns the first time the schedule allows after t, or the zero time
+// if it allows none within five years, as for February 30.
+func (s cronSchedule) next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		switch {
+		case s.month&(1<<uint(t.Month())) == 0:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+		case !s.dayMatches(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+		case s.hour&(1<<uint(t.Hour())) == 0:
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+		case s.minute&(1<<uint(t.Minute())) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// watchBackups backs the archive up on the configured schedule, pruning
+// the snapshots past backup.keep after each backup.
+func watchBackups() {
+	c := config.Backup
+	if c.Repo == "" {
+		return
+	}
+	// The schedule was checked when the config was loaded
+	schedule, _ := parseCron(c.Schedule)
+	for {
+		next := schedule.next(time.Now())
+		if next.IsZero() {
+			log.Printf("Backup schedule %q never runs", c.Schedule)
+			return
+		}
+		time.Sleep(time.Until(next))
+		if err := runScheduledBackup(c); err != nil {
+			log.Printf("Scheduled backup failed: %v", err)
+			publishError("", fmt.Errorf("backup: %v", err))
+		}
+	}
+}
+
+// runScheduledBackup stores a snapshot in the configured repository and
+// removes those past backup.keep.
+func runScheduledBackup(c BackupConfig) error {
+	snapshot, stats, err := backupArchive(c.Repo, c.IncludeIndex)
+	if err != nil {
+		return err
+	}
+	log.Printf("Stored snapshot %s: %d files (%d unchanged), %d new chunks, %d bytes added",
+		snapshot.ID, stats.Files, stats.Unchanged, stats.NewChunks, stats.Added)
+	snapshots, chunks, freed, err := pruneBackups(c.Repo, c.Keep)
+	if err != nil {
+		return fmt.Errorf("pruning: %v", err)
+	}
+	if snapshots > 0 {
+		log.Printf("Removed %d snapshots and %d chunks, freeing %d bytes", snapshots, chunks, freed)
+	}
+	return nil
+}
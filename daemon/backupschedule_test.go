@@ -0,0 +1,56 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCronNext(t *testing.T) {
+	// Friday
+	from := time.Date(2026, 10, 16, 3, 15, 0, 0, time.UTC)
+	tests := []struct {
+		expr string
+		want time.Time
+	}{
+		{"0 3 * * *", time.Date(2026, 10, 17, 3, 0, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2026, 10, 16, 3, 30, 0, 0, time.UTC)},
+		{"0 4 * * 1-5", time.Date(2026, 10, 16, 4, 0, 0, 0, time.UTC)},
+		{"30 2 1 * *", time.Date(2026, 11, 1, 2, 30, 0, 0, time.UTC)},
+		{"0 0 * * 7", time.Date(2026, 10, 18, 0, 0, 0, 0, time.UTC)},
+		// Either day field matches when both are given
+		{"0 0 13 * 5", time.Date(2026, 10, 23, 0, 0, 0, 0, time.UTC)},
+		{"0 0 30 2 *", time.Time{}},
+	}
+	for _, test := range tests {
+		schedule, err := parseCron(test.expr)
+		if err != nil {
+			t.Errorf("parseCron(%q): %v", test.expr, err)
+			continue
+		}
+		if got := schedule.next(from); !got.Equal(test.want) {
+			t.Errorf("next of %q = %v, want %v", test.expr, got, test.want)
+		}
+	}
+
+	for _, expr := range []string{"61 * * * *", "* * *", "*/0 * * * *", "5-1 * * * *", "a * * * *"} {
+		if _, err := parseCron(expr); err == nil {
+			t.Errorf("parseCron(%q) succeeded", expr)
+		}
+	}
+}
+
+func TestValidateBackup(t *testing.T) {
+	if err := validateBackup(BackupConfig{Schedule: "0 3 * * *", Keep: 7}); err != nil {
+		t.Error(err)
+	}
+	for _, c := range []BackupConfig{
+		{Schedule: "0 0 30 2 *", Keep: 7},
+		{Schedule: "0 3 * * *"},
+		{Repo: "s3://bucket/memento", Schedule: "0 3 * * *", Keep: 7},
+		{Repo: "s3://bucket", Schedule: "0 3 * * *", Keep: 7, S3: S3Config{Endpoint: "minio:9000", AccessKeyID: "key"}},
+	} {
+		if err := validateBackup(c); err == nil {
+			t.Errorf("validateBackup(%+v) succeeded", c)
+		}
+	}
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const boilerplateFile = "memento_boilerplate.json"
+
+// boilerplateShare is the share of a site's pages a block must appear on,
+// besides boilerplate.minPages of them, to count as boilerplate.
+const boilerplateShare = 0.5
+
+// learnedBoilerplate is the blocks of text each site repeats across its
+// pages, by host, as hashes of the blocks.
+type learnedBoilerplate struct {
+	Updated time.Time           `json:"updated"`
+	Hosts   map[string][]string `json:"hosts"`
+}
+
+var (
+	// boilerplateMu guards the boilerplate file.
+	boilerplateMu      sync.Mutex
+	currentBoilerplate struct {
+		sync.RWMutex
+		byHost map[string]map[string]bool
+	}
+)
+
+// pageHost returns the lowercased host of a page's URL, or "" for URLs
+// without one, such as local files.
+func pageHost(pageURL string) string {
+	u, err := url.Parse(pageURL)
+	if err != nil {
+		return ""
+	}
+	return strings.ToLower(u.Hostname())
+}
+
+// blockHash identifies a block of text, a line of a page's extracted text.
+func blockHash(block string) string {
+	return contentHash([]byte(block))[:16]
+}
+
+// learnBoilerplate finds the blocks that at least minPages of a site's
+// pages, and half of them, share. Each URL counts once, whatever its
+// number of captures, and replaced versions don't count.
+func learnBoilerplate(pages []Page, minPages int) map[string][]string {
+	urlsByHost := make(map[string]map[string]bool)
+	counts := make(map[string]map[string]int)
+	for _, page := range pages {
+		host := pageHost(page.URL)
+		if host == "" || page.SupersededBy != "" || urlsByHost[host][page.URL] {
+			continue
+		}
+		text, err := readFullText(page.PageMetadata)
+		if err != nil {
+			continue // deleted since listing
+		}
+		if urlsByHost[host] == nil {
+			urlsByHost[host] = make(map[string]bool)
+			counts[host] = make(map[string]int)
+		}
+		urlsByHost[host][page.URL] = true
+		seen := make(map[string]bool)
+		for _, block := range strings.Split(text, "\n") {
+			if block == "" {
+				continue
+			}
+			hash := blockHash(block)
+			if !seen[hash] {
+				seen[hash] = true
+				counts[host][hash]++
+			}
+		}
+	}
+
+	hosts := make(map[string][]string)
+	for host, blocks := range counts {
+		pages := len(urlsByHost[host])
+		for hash, count := range blocks {
+			if count >= minPages && float64(count) >= boilerplateShare*float64(pages) {
+				hosts[host] = append(hosts[host], hash)
+			}
+		}
+		sort.Strings(hosts[host])
+	}
+	return hosts
+}
+
+// loadBoilerplate reads the boilerplate last learned.
+func loadBoilerplate() (learnedBoilerplate, error) {
+	boilerplateMu.Lock()
+	defer boilerplateMu.Unlock()
+	var learned learnedBoilerplate
+	data, err := ioutil.ReadFile(boilerplateFile)
+	if os.IsNotExist(err) {
+		return learned, nil
+	}
+	if err != nil {
+		return learned, err
+	}
+	if err := json.Unmarshal(data, &learned); err != nil {
+		return learned, fmt.Errorf("parsing %s: %v", boilerplateFile, err)
+	}
+	return learned, nil
+}
+
+// saveBoilerplate writes learned boilerplate to the boilerplate file.
+func saveBoilerplate(learned learnedBoilerplate) error {
+	boilerplateMu.Lock()
+	defer boilerplateMu.Unlock()
+	data, err := json.MarshalIndent(learned, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := boilerplateFile + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, boilerplateFile)
+}
+
+func setBoilerplate(hosts map[string][]string) {
+	byHost := make(map[string]map[string]bool, len(hosts))
+	for host, hashes := range hosts {
+		byHost[host] = make(map[string]bool, len(hashes))
+		for _, hash := range hashes {
+			byHost[host][hash] = true
+		}
+	}
+	currentBoilerplate.Lock()
+	defer currentBoilerplate.Unlock()
+	currentBoilerplate.byHost = byHost
+}
+
+// siteBoilerplate returns the boilerplate blocks of a page's site.
+func siteBoilerplate(pageURL string) map[string]bool {
+	currentBoilerplate.RLock()
+	defer currentBoilerplate.RUnlock()
+	return currentBoilerplate.byHost[pageHost(pageURL)]
+}
+
+// withoutBoilerplate returns the text of content without the blocks of its
+// site's boilerplate, or content as it is when the site has none.
+func withoutBoilerplate(pageURL, content string, isHTML bool) string {
+	blocks := siteBoilerplate(pageURL)
+	if len(blocks) == 0 {
+		return content
+	}
+	return stripBlocks(extractText(content, isHTML), blocks)
+}
+
+// stripBlocks removes the lines of text whose hashes are in blocks.
+func stripBlocks(text string, blocks map[string]bool) string {
+	if len(blocks) == 0 {
+		return text
+	}
+	var kept []string
+	for _, line := range strings.Split(text, "\n") {
+		if line == "" || !blocks[blockHash(line)] {
+			kept = append(kept, line)
+		}
+	}
+	return strings.TrimSpace(blankLinesRe.ReplaceAllString(strings.Join(kept, "\n"), "\n\n"))
+}
+
+// updateBoilerplate learns the boilerplate of every site again and saves
+// it. Pages of sites whose boilerplate changed are indexed again without
+// it.
+func updateBoilerplate(now time.Time) (learnedBoilerplate, error) {
+	pages, err := listPages()
+	if err != nil {
+		return learnedBoilerplate{}, err
+	}
+	hosts := learnBoilerplate(pages, config.Boilerplate.MinPages)
+	previous, _ := loadBoilerplate()
+	learned := learnedBoilerplate{Updated: now, Hosts: hosts}
+	if err := saveBoilerplate(learned); err != nil {
+		return learned, err
+	}
+	setBoilerplate(hosts)
+
+	changed := make(map[string]bool)
+	for host, hashes := range hosts {
+		if strings.Join(hashes, " ") != strings.Join(previous.Hosts[host], " ") {
+			changed[host] = true
+		}
+	}
+	for host := range previous.Hosts {
+		if _, ok := hosts[host]; !ok {
+			changed[host] = true
+		}
+	}
+	if len(changed) == 0 {
+		return learned, nil
+	}
+	indexMu.Lock()
+	defer indexMu.Unlock()
+	for _, page := range pages {
+		if !changed[pageHost(page.URL)] {
+			continue
+		}
+		metadata, err := readMetadata(page.ID)
+		if err != nil {
+			continue // deleted since listing
+		}
+		metadata.Indexed = false
+		if err := writeMetadata(page.ID, metadata); err != nil {
+			return learned, err
+		}
+	}
+	return learned, nil
+}
+
+// watchBoilerplate learns the sites' boilerplate every
+// boilerplate.intervalHours while it is enabled.
+func watchBoilerplate() {
+	if !config.Boilerplate.Enabled {
+		return
+	}
+	interval := time.Duration(config.Boilerplate.IntervalHours) * time.Hour
+	learned, err := loadBoilerplate()
+	if err != nil {
+		log.Printf("Error loading boilerplate: %v", err)
+	}
+	setBoilerplate(learned.Hosts)
+	for {
+		if wait := time.Until(learned.Updated.Add(interval)); wait > 0 {
+			time.Sleep(wait)
+		}
+		if learned, err = updateBoilerplate(time.Now()); err != nil {
+			log.Printf("Error learning boilerplate: %v", err)
+			learned.Updated = time.Now()
+			continue
+		}
+		blocks := 0
+		for _, hashes := range learned.Hosts {
+			blocks += len(hashes)
+		}
+		log.Printf("Learned %d boilerplate blocks across %d sites", blocks, len(learned.Hosts))
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestBoilerplate(t *testing.T) {
+	withPagesDir(t)
+	withIndex(t, nil)
+	t.Cleanup(func() { setBoilerplate(nil) })
+	const footer = "<footer><p>Subscribe to the Gazette newsletter</p><p>Copyright Gazette Media</p></footer>"
+	var ids []string
+	for i := 0; i < 6; i++ {
+		body := fmt.Sprintf("<p>Story number %d about zeppelin%d</p>", i, i)
+		if i < 5 {
+			body += footer
+		}
+		// A second capture of the same URL doesn't count twice
+		for capture := 0; capture < 2 && i == 0; capture++ {
+			storePage(PageMetadata{URL: "https://gazette.example/0", Title: "0"}, body+fmt.Sprint(capture), "")
+		}
+		id, err := storePage(PageMetadata{URL: fmt.Sprintf("https://Gazette.example/%d", i+1), Title: fmt.Sprint(i)}, body, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+	}
+	storePage(PageMetadata{URL: "https://other.example/a", Title: "a"}, "<p>Subscribe to the Gazette newsletter</p>", "")
+	pages, err := listPages()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		minPages int
+		want     int
+	}{
+		{6, 2}, // the two footer lines, on 6 of 7 URLs
+		{7, 0},
+		{2, 2}, // the story lines are each on one page
+	}
+	for _, tt := range tests {
+		hosts := learnBoilerplate(pages, tt.minPages)
+		if len(hosts["gazette.example"]) != tt.want || len(hosts["other.example"]) != 0 {
+			t.Errorf("minPages %d: learned %v, want %d blocks", tt.minPages, hosts, tt.want)
+		}
+	}
+
+	indexExistingFiles(context.Background())
+	if got := searchURLs(t, "newsletter", SearchOptions{}); len(got) != 8 {
+		t.Fatalf("before learning, search = %v", got)
+	}
+	learned, err := updateBoilerplate(time.Now())
+	if err != nil || len(learned.Hosts["gazette.example"]) != 2 {
+		t.Fatalf("updateBoilerplate() = %v, %v", learned, err)
+	}
+	if page, _ := loadPage(ids[0]); page.Indexed {
+		t.Errorf("page of a site with new boilerplate is still marked indexed")
+	}
+	indexExistingFiles(context.Background())
+	if got := searchURLs(t, "newsletter", SearchOptions{}); len(got) != 1 || got[0] != "https://other.example/a" {
+		t.Errorf("after learning, search = %v", got)
+	}
+	if got := searchURLs(t, "zeppelin3", SearchOptions{}); len(got) != 1 {
+		t.Errorf("search for a page's own text = %v", got)
+	}
+
+	saved := config.Boilerplate
+	defer func() { config.Boilerplate = saved }()
+	page, _ := loadPage(ids[1])
+	for _, strip := range []bool{false, true} {
+		config.Boilerplate.StripText = strip
+		text, err := readPageText(page.PageMetadata)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := "Story number 1 about zeppelin1"; (text == want) != strip {
+			t.Errorf("stripText %v: text = %q", strip, text)
+		}
+	}
+}
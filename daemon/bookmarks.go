@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+var errDuplicateBookmark = errors.New("URL is already bookmarked")
+
+// bookmarkRequest is the body of POST /bookmarks.
+type bookmarkRequest struct {
+	URL   string   `json:"url"`
+	Title string   `json:"title"`
+	Tags  []string `json:"tags"`
+	Note  string   `json:"note"`
+}
+
+// handleBookmarks saves a URL to come back to without capturing it: only
+// its metadata is stored, and it is searched by its title, tags and note.
+// Re-capturing the bookmark through /pages/{id}/recapture upgrades it to a
+// full capture that keeps its tags and note.
+func handleBookmarks(w http.ResponseWriter, r *http.Request) {
+	var request bookmarkRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		writeError(w, http.StatusBadRequest, ErrInvalidRequest, "Invalid request body")
+		return
+	}
+	if u, err := url.Parse(request.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		writeError(w, http.StatusBadRequest, ErrInvalidRequest, "URL must be an absolute http(s) URL")
+		return
+	}
+	if len(request.Note) > maxAnnotationLength {
+		writeError(w, http.StatusBadRequest, ErrInvalidRequest, "Note is too long")
+		return
+	}
+
+	owner := requestUser(r)
+	indexMu.Lock()
+	id, err := storeBookmark(request, owner, time.Now())
+	indexMu.Unlock()
+	if err == errDuplicateBookmark {
+		writeError(w, http.StatusConflict, ErrDuplicate, "URL is already bookmarked")
+		return
+	}
+	if err == errDoNotIndex {
+		writeError(w, http.StatusForbidden, ErrForbidden, err.Error())
+		return
+	}
+	if err != nil {
+		log.Printf("Error bookmarking %s: %v", request.URL, err)
+		writeError(w, http.StatusInternalServerError, ErrInternal, "Failed to save bookmark")
+		return
+	}
+	writeArchived(r.Context(), w, id)
+}
+
+// storeBookmark writes a bookmark's metadata, unless owner already has a
+// current bookmark of the URL. indexMu must be held.
+func storeBookmark(request bookmarkRequest, owner string, now time.Time) (string, error) {
+	if doNotIndex(request.URL) {
+		return "", errDoNotIndex
+	}
+	pages, err := listPages()
+	if err != nil {
+		return "", err
+	}
+	for _, page := range pages {
+		if page.Bookmark && page.SupersededBy == "" && page.URL == request.URL && page.Owner == owner {
+			return "", errDuplicateBookmark
+		}
+	}
+	title := strings.TrimSpace(request.Title)
+	if title == "" {
+		title = request.URL
+	}
+	metadata := PageMetadata{
+		URL:              request.URL,
+		Title:            title,
+		Timestamp:        now,
+		Tags:             splitTags(strings.Join(request.Tags, ","), ","),
+		Source:           "bookmark",
+		Owner:            owner,
+		Bookmark:         true,
+		Note:             strings.TrimSpace(request.Note),
+		ExtractorVersion: extractorVersion,
+	}
+	id := newPageID(metadata.URL, metadata.Timestamp)
+	return id, writeMetadata(id, metadata)
+}
+
+// handleBookmarkCapture fetches and archives a bookmarked page on the
+// server, responding with the capture that replaces the bookmark. Like any
+// re-capture of a bookmark, it keeps the bookmark's tags and note and
+// records when it was bookmarked.
+func handleBookmarkCapture(w http.ResponseWriter, r *http.Request) {
+	page, err := loadPage(r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusNotFound, ErrNotFound, "Page not found")
+		return
+	}
+	switch {
+	case !page.Bookmark:
+		writeError(w, http.StatusConflict, ErrInvalidRequest, "Page is not a bookmark")
+		return
+	case page.SupersededBy != "":
+		writeError(w, http.StatusConflict, ErrInvalidRequest, "Bookmark has been captured as "+page.SupersededBy)
+		return
+	}
+	writeRecapture(w, r, page)
+}
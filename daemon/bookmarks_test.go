@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestBookmarks(t *testing.T) {
+	withPagesDir(t)
+	withIndex(t, nil)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprint(w, "<html><head><title>Later</title></head><body><p>The full article text</p></body></html>")
+	}))
+	defer server.Close()
+	saved := archiveClient
+	archiveClient = server.Client()
+	defer func() { archiveClient = saved }()
+
+	request := func(method, path, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		newRouter().ServeHTTP(rec, req)
+		return rec
+	}
+	bookmark := `{"url": "` + server.URL + `/later", "tags": ["reading", " "], "note": "recommended by Ann"}`
+	tests := []struct {
+		name   string
+		body   string
+		status int
+	}{
+		{"not json", `{`, http.StatusBadRequest},
+		{"relative url", `{"url": "/later"}`, http.StatusBadRequest},
+		{"note too long", `{"url": "https://example.com", "note": "` + strings.Repeat("a", maxAnnotationLength+1) + `"}`, http.StatusBadRequest},
+		{"bookmark", bookmark, http.StatusCreated},
+		{"again", bookmark, http.StatusConflict},
+	}
+	var page Page
+	for _, tt := range tests {
+		rec := request(http.MethodPost, "/api/v1/bookmarks", tt.body)
+		if rec.Code != tt.status {
+			t.Errorf("%s: POST /bookmarks = %d %s, want %d", tt.name, rec.Code, rec.Body, tt.status)
+		}
+		if rec.Code == http.StatusCreated {
+			json.Unmarshal(rec.Body.Bytes(), &page)
+		}
+	}
+	if !page.Bookmark || !page.Indexed || page.Title != server.URL+"/later" || len(page.Tags) != 1 || page.ContentHash != "" || len(page.files()) != 0 {
+		t.Fatalf("bookmark = %+v", page)
+	}
+
+	var list pageList
+	json.Unmarshal(request(http.MethodGet, "/api/v1/pages?bookmarks=1", "").Body.Bytes(), &list)
+	if list.Total != 1 || list.Pages[0].ID != page.ID {
+		t.Errorf("GET /pages?bookmarks=1 = %+v", list)
+	}
+	results, err := searchPages(context.Background(), "Ann", SearchOptions{}, Localizer{})
+	if err != nil || len(results) != 1 || results[0].ID != page.ID {
+		t.Errorf("search for the note = %+v, %v", results, err)
+	}
+
+	// Capturing the bookmark keeps its tags and note
+	rec := request(http.MethodPost, "/api/v1/pages/"+page.ID+"/recapture", "")
+	var captured Page
+	json.Unmarshal(rec.Body.Bytes(), &captured)
+	if rec.Code != http.StatusCreated || captured.Bookmark || captured.Supersedes != page.ID || captured.Note != "recommended by Ann" || !hasTag(captured.Tags, "reading") {
+		t.Fatalf("recapture of a bookmark = %d %s", rec.Code, rec.Body)
+	}
+	results, _ = searchPages(context.Background(), "Ann article", SearchOptions{}, Localizer{})
+	if len(results) != 1 || results[0].ID != captured.ID {
+		t.Errorf("search after capture = %+v", results)
+	}
+	if rec := request(http.MethodPost, "/api/v1/bookmarks", bookmark); rec.Code != http.StatusCreated {
+		t.Errorf("bookmarking a captured bookmark again = %d", rec.Code)
+	}
+}
+
+func TestBookmarkCapture(t *testing.T) {
+	withPagesDir(t)
+	withIndex(t, nil)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprint(w, "<html><head><title>Later</title></head><body><p>The full article text</p></body></html>")
+	}))
+	defer server.Close()
+	saved := archiveClient
+	archiveClient = server.Client()
+	defer func() { archiveClient = saved }()
+
+	request := func(method, path, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		newRouter().ServeHTTP(rec, req)
+		return rec
+	}
+	var bookmark Page
+	json.Unmarshal(request(http.MethodPost, "/api/v1/bookmarks", `{"url": "`+server.URL+`/later", "tags": ["reading"]}`).Body.Bytes(), &bookmark)
+
+	rec := request(http.MethodPost, "/api/v1/bookmarks/"+bookmark.ID+"/capture", "")
+	var captured Page
+	json.Unmarshal(rec.Body.Bytes(), &captured)
+	if rec.Code != http.StatusCreated || captured.Bookmark || !hasTag(captured.Tags, "reading") || captured.Bookmarked == nil || !captured.Bookmarked.Equal(bookmark.Timestamp) {
+		t.Fatalf("capture of a bookmark = %d %s", rec.Code, rec.Body)
+	}
+	if rec := request(http.MethodPost, "/api/v1/bookmarks/"+bookmark.ID+"/capture", ""); rec.Code != http.StatusConflict {
+		t.Errorf("capturing a captured bookmark = %d, want 409", rec.Code)
+	}
+	if rec := request(http.MethodPost, "/api/v1/bookmarks/"+captured.ID+"/capture", ""); rec.Code != http.StatusConflict {
+		t.Errorf("capturing a page that isn't a bookmark = %d, want 409", rec.Code)
+	}
+	if rec := request(http.MethodPost, "/api/v1/bookmarks/missing/capture", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("capturing a missing bookmark = %d, want 404", rec.Code)
+	}
+}
@@ -0,0 +1,566 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	defaultBrowserWidth    = 1280
+	defaultBrowserTimeout  = 30
+	defaultBrowserContexts = 4
+	// maxScreenshotHeight keeps endless pages within what Chrome can
+	// capture in one image.
+	maxScreenshotHeight = 16384
+	// pageSettle gives pages a moment after their load event for late
+	// scripts, layout and web fonts.
+	pageSettle        = 500 * time.Millisecond
+	browserEventQueue = 256
+)
+
+var (
+	errNoBrowser     = errors.New("no headless browser is configured")
+	errBrowserExited = errors.New("headless browser exited")
+	browserMu        sync.Mutex
+	runningBrowser   *browser
+)
+
+// browser is a headless Chrome or Chromium process driven over the DevTools
+// protocol. It talks over the pipes Chrome opens for
+// --remote-debugging-pipe, which carry NUL-terminated JSON messages, so it
+// needs neither a debugging port nor a websocket client. All of the
+// browser's traffic goes through a local proxy that only connects to public
+// addresses, like archiveClient.
+type browser struct {
+	cmd     *exec.Cmd
+	proxy   net.Listener
+	dataDir string
+
+	writeMu sync.Mutex
+	w       io.WriteCloser
+
+	mu       sync.Mutex
+	nextID   int64
+	pending  map[int64]chan cdpMessage
+	sessions map[string]chan cdpMessage
+	done     chan struct{}
+
+	// slots bounds how many tabs are open at once
+	slots chan struct{}
+}
+
+type cdpRequest struct {
+	ID        int64       `json:"id"`
+	SessionID string      `json:"sessionId,omitempty"`
+	Method    string      `json:"method"`
+	Params    interface{} `json:"params,omitempty"`
+}
+
+// cdpMessage is a command response, which has an ID, or an event, which
+// has a method.
+type cdpMessage struct {
+	ID        int64           `json:"id"`
+	SessionID string          `json:"sessionId"`
+	Method    string          `json:"method"`
+	Params    json.RawMessage `json:"params"`
+	Result    json.RawMessage `json:"result"`
+	Error     *struct {
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// getBrowser returns the running browser, starting it on first use and
+// again if it has exited.
+func getBrowser() (*browser, error) {
+	browserMu.Lock()
+	defer browserMu.Unlock()
+	if config.Browser.Path == "" {
+		return nil, errNoBrowser
+	}
+	if runningBrowser != nil && !runningBrowser.exited() {
+		return runningBrowser, nil
+	}
+	b, err := startBrowser(config.Browser)
+	if err != nil {
+		return nil, err
+	}
+	runningBrowser = b
+	return b, nil
+}
+
+// stopBrowser kills the running browser, if any.
+func stopBrowser() {
+	browserMu.Lock()
+	defer browserMu.Unlock()
+	if runningBrowser != nil {
+		runningBrowser.cmd.Process.Kill()
+		<-runningBrowser.done
+		runningBrowser = nil
+	}
+}
+
+func startBrowser(c BrowserConfig) (*browser, error) {
+	proxy, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	go http.Serve(proxy, browserProxy{dial: archiveDialer.DialContext})
+
+	dataDir, err := ioutil.TempDir("", "memento-browser")
+	if err != nil {
+		proxy.Close()
+		return nil, err
+	}
+	// Chrome reads commands from fd 3 and writes to fd 4
+	commandsR, commandsW, err := os.Pipe()
+	if err != nil {
+		proxy.Close()
+		os.RemoveAll(dataDir)
+		return nil, err
+	}
+	outputR, outputW, err := os.Pipe()
+	if err != nil {
+		commandsR.Close()
+		commandsW.Close()
+		proxy.Close()
+		os.RemoveAll(dataDir)
+		return nil, err
+	}
+
+	width := c.Width
+	if width <= 0 {
+		width = defaultBrowserWidth
+	}
+	contexts := c.Contexts
+	if contexts <= 0 {
+		contexts = defaultBrowserContexts
+	}
+	args := []string{
+		"--headless=new",
+		"--remote-debugging-pipe",
+		"--user-data-dir=" + dataDir,
+		"--proxy-server=http://" + proxy.Addr().String(),
+		// Without this Chrome skips the proxy for localhost
+		"--proxy-bypass-list=<-loopback>",
+		"--window-size=" + strconv.Itoa(width) + ",800",
+		"--no-first-run",
+		"--no-default-browser-check",
+		"--disable-background-networking",
+		"--disable-extensions",
+		"--disable-sync",
+		"--disable-gpu",
+		"--hide-scrollbars",
+		"--mute-audio",
+	}
+	args = append(args, c.Args...)
+	cmd := exec.Command(c.Path, append(args, "about:blank")...)
+	cmd.ExtraFiles = []*os.File{commandsR, outputW}
+	err = cmd.Start()
+	commandsR.Close()
+	outputW.Close()
+	if err != nil {
+		commandsW.Close()
+		outputR.Close()
+		proxy.Close()
+		os.RemoveAll(dataDir)
+		return nil, fmt.Errorf("starting browser: %v", err)
+	}
+
+	b := &browser{
+		cmd:      cmd,
+		proxy:    proxy,
+		dataDir:  dataDir,
+		w:        commandsW,
+		pending:  make(map[int64]chan cdpMessage),
+		sessions: make(map[string]chan cdpMessage),
+		done:     make(chan struct{}),
+		slots:    make(chan struct{}, contexts),
+	}
+	go b.read(outputR)
+	return b, nil
+}
+
+// read dispatches the browser's messages until it exits.
+func (b *browser) read(r io.ReadCloser) {
+	reader := bufio.NewReader(r)
+	for {
+		data, err := reader.ReadBytes(0)
+		if err != nil {
+			break
+		}
+		var msg cdpMessage
+		if err := json.Unmarshal(data[:len(data)-1], &msg); err != nil {
+			log.Printf("Error decoding browser message: %v", err)
+			continue
+		}
+		b.mu.Lock()
+		if msg.ID != 0 {
+			if ch, ok := b.pending[msg.ID]; ok {
+				delete(b.pending, msg.ID)
+				ch <- msg
+			}
+		} else if ch, ok := b.sessions[msg.SessionID]; ok && msg.SessionID != "" {
+			select {
+			case ch <- msg:
+			default: // nobody is waiting for this many events
+			}
+		}
+		b.mu.Unlock()
+	}
+
+	r.Close()
+	b.w.Close()
+	b.cmd.Wait()
+	b.proxy.Close()
+	os.RemoveAll(b.dataDir)
+	b.mu.Lock()
+	close(b.done)
+	b.mu.Unlock()
+}
+
+func (b *browser) exited() bool {
+	select {
+	case <-b.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// call sends a command, to the browser itself or a page session, and
+// decodes its result into result if it isn't nil.
+func (b *browser) call(ctx context.Context, sessionID, method string, params, result interface{}) error {
+	b.mu.Lock()
+	if b.exited() {
+		b.mu.Unlock()
+		return errBrowserExited
+	}
+	b.nextID++
+	id := b.nextID
+	ch := make(chan cdpMessage, 1)
+	b.pending[id] = ch
+	b.mu.Unlock()
+
+	data, err := json.Marshal(cdpRequest{ID: id, SessionID: sessionID, Method: method, Params: params})
+	if err == nil {
+		b.writeMu.Lock()
+		_, err = b.w.Write(append(data, 0))
+		b.writeMu.Unlock()
+	}
+	if err != nil {
+		b.forget(id)
+		return err
+	}
+
+	select {
+	case msg := <-ch:
+		if msg.Error != nil {
+			return fmt.Errorf("%s: %s", method, msg.Error.Message)
+		}
+		if result != nil {
+			return json.Unmarshal(msg.Result, result)
+		}
+		return nil
+	case <-b.done:
+		return errBrowserExited
+	case <-ctx.Done():
+		b.forget(id)
+		return ctx.Err()
+	}
+}
+
+func (b *browser) forget(id int64) {
+	b.mu.Lock()
+	delete(b.pending, id)
+	b.mu.Unlock()
+}
+
+// tab is a page in a browser context of its own, so captures share no
+// cookies, storage or cache.
+type tab struct {
+	b         *browser
+	contextID string
+	sessionID string
+	events    chan cdpMessage
+}
+
+// newTab opens a tab once fewer than the configured number are open.
+func (b *browser) newTab(ctx context.Context) (*tab, error) {
+	select {
+	case b.slots <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	var created struct {
+		BrowserContextID string `json:"browserContextId"`
+	}
+	if err := b.call(ctx, "", "Target.createBrowserContext", nil, &created); err != nil {
+		<-b.slots
+		return nil, err
+	}
+	t := &tab{b: b, contextID: created.BrowserContextID, events: make(chan cdpMessage, browserEventQueue)}
+
+	var target struct {
+		TargetID string `json:"targetId"`
+	}
+	params := map[string]interface{}{"url": "about:blank", "browserContextId": t.contextID}
+	if err := b.call(ctx, "", "Target.createTarget", params, &target); err != nil {
+		t.close()
+		return nil, err
+	}
+	var attached struct {
+		SessionID string `json:"sessionId"`
+	}
+	params = map[string]interface{}{"targetId": target.TargetID, "flatten": true}
+	if err := b.call(ctx, "", "Target.attachToTarget", params, &attached); err != nil {
+		t.close()
+		return nil, err
+	}
+	t.sessionID = attached.SessionID
+	b.mu.Lock()
+	b.sessions[t.sessionID] = t.events
+	b.mu.Unlock()
+	return t, nil
+}
+
+// close disposes of the tab's browser context, closing the tab with it.
+func (t *tab) close() {
+	defer func() { <-t.b.slots }()
+	t.b.mu.Lock()
+	delete(t.b.sessions, t.sessionID)
+	t.b.mu.Unlock()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	params := map[string]interface{}{"browserContextId": t.contextID}
+	if err := t.b.call(ctx, "", "Target.disposeBrowserContext", params, nil); err != nil && err != errBrowserExited {
+		log.Printf("Error closing browser tab: %v", err)
+	}
+}
+
+func (t *tab) call(ctx context.Context, method string, params, result interface{}) error {
+	return t.b.call(ctx, t.sessionID, method, params, result)
+}
+
+// navigate loads a URL and waits for its load event.
+func (t *tab) navigate(ctx context.Context, pageURL string) error {
+	if err := t.call(ctx, "Page.enable", nil, nil); err != nil {
+		return err
+	}
+	var nav struct {
+		ErrorText string `json:"errorText"`
+	}
+	if err := t.call(ctx, "Page.navigate", map[string]interface{}{"url": pageURL}, &nav); err != nil {
+		return err
+	}
+	if nav.ErrorText != "" {
+		return fmt.Errorf("loading %s: %s", pageURL, nav.ErrorText)
+	}
+	for {
+		select {
+		case msg := <-t.events:
+			if msg.Method == "Page.loadEventFired" {
+				return nil
+			}
+		case <-t.b.done:
+			return errBrowserExited
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// screenshot captures the whole length of the loaded page as a PNG.
+func (t *tab) screenshot(ctx context.Context) ([]byte, error) {
+	type size struct {
+		Width  float64 `json:"width"`
+		Height float64 `json:"height"`
+	}
+	var metrics struct {
+		CSSContentSize *size `json:"cssContentSize"`
+		ContentSize    size  `json:"contentSize"`
+	}
+	if err := t.call(ctx, "Page.getLayoutMetrics", nil, &metrics); err != nil {
+		return nil, err
+	}
+	content := metrics.ContentSize
+	if metrics.CSSContentSize != nil {
+		content = *metrics.CSSContentSize
+	}
+	width := math.Max(1, math.Ceil(content.Width))
+	height := math.Min(math.Max(1, math.Ceil(content.Height)), maxScreenshotHeight)
+
+	var shot struct {
+		Data []byte `json:"data"`
+	}
+	params := map[string]interface{}{
+		"format":                "png",
+		"captureBeyondViewport": true,
+		"clip":                  map[string]float64{"x": 0, "y": 0, "width": width, "height": height, "scale": 1},
+	}
+	if err := t.call(ctx, "Page.captureScreenshot", params, &shot); err != nil {
+		return nil, err
+	}
+	return shot.Data, nil
+}
+
+// html serializes the page's current DOM, with its doctype.
+func (t *tab) html(ctx context.Context) (string, error) {
+	var evaluated struct {
+		Result struct {
+			Value string `json:"value"`
+		} `json:"result"`
+		ExceptionDetails *struct {
+			Text string `json:"text"`
+		} `json:"exceptionDetails"`
+	}
+	params := map[string]interface{}{
+		"expression":    `(document.doctype ? new XMLSerializer().serializeToString(document.doctype) : "") + document.documentElement.outerHTML`,
+		"returnByValue": true,
+	}
+	if err := t.call(ctx, "Runtime.evaluate", params, &evaluated); err != nil {
+		return "", err
+	}
+	if evaluated.ExceptionDetails != nil {
+		return "", fmt.Errorf("reading the DOM: %s", evaluated.ExceptionDetails.Text)
+	}
+	return evaluated.Result.Value, nil
+}
+
+// browserTimeout bounds loading and capturing one page.
+func browserTimeout() time.Duration {
+	seconds := config.Browser.TimeoutSeconds
+	if seconds <= 0 {
+		seconds = defaultBrowserTimeout
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// withLoadedPage loads a URL in a new tab of the headless browser and
+// calls fn with it, within the configured timeout.
+func withLoadedPage(ctx context.Context, pageURL string, fn func(context.Context, *tab) error) error {
+	b, err := getBrowser()
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, browserTimeout())
+	defer cancel()
+
+	t, err := b.newTab(ctx)
+	if err != nil {
+		return err
+	}
+	defer t.close()
+	if err := t.navigate(ctx, pageURL); err != nil {
+		return err
+	}
+	select {
+	case <-time.After(pageSettle):
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	return fn(ctx, t)
+}
+
+// takeScreenshot loads a page in the headless browser and returns a PNG of
+// its full length.
+func takeScreenshot(ctx context.Context, pageURL string) ([]byte, error) {
+	var png []byte
+	err := withLoadedPage(ctx, pageURL, func(ctx context.Context, t *tab) error {
+		var err error
+		png, err = t.screenshot(ctx)
+		return err
+	})
+	return png, err
+}
+
+// renderPage loads a page in the headless browser, running its scripts, and
+// returns the resulting DOM as HTML.
+func renderPage(ctx context.Context, pageURL string) (string, error) {
+	var html string
+	err := withLoadedPage(ctx, pageURL, func(ctx context.Context, t *tab) error {
+		var err error
+		html, err = t.html(ctx)
+		return err
+	})
+	if err == nil && len(html) > maxCaptureBytes {
+		return "", errCaptureTooLarge
+	}
+	return html, err
+}
+
+// browserProxy is the HTTP proxy the browser loads pages through. It
+// forwards plain requests and tunnels CONNECT requests, dialing with dial,
+// which refuses private addresses.
+type browserProxy struct {
+	dial func(ctx context.Context, network, address string) (net.Conn, error)
+}
+
+func (p browserProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodConnect {
+		p.tunnel(w, r)
+		return
+	}
+	if !r.URL.IsAbs() {
+		http.Error(w, "Not a proxy request", http.StatusBadRequest)
+		return
+	}
+	transport := &http.Transport{DialContext: p.dial, TLSHandshakeTimeout: 10 * time.Second}
+	defer transport.CloseIdleConnections()
+	out := r.Clone(r.Context())
+	out.RequestURI = ""
+	out.Header.Del("Proxy-Connection")
+	out.Header.Del("Proxy-Authorization")
+	resp, err := transport.RoundTrip(out)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+	for key, values := range resp.Header {
+		for _, value := range values {
+			w.Header().Add(key, value)
+		}
+	}
+	w.WriteHeader(resp.StatusCode)
+	io.Copy(w, resp.Body)
+}
+
+func (p browserProxy) tunnel(w http.ResponseWriter, r *http.Request) {
+	upstream, err := p.dial(r.Context(), "tcp", r.Host)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		upstream.Close()
+		http.Error(w, "Tunneling not supported", http.StatusInternalServerError)
+		return
+	}
+	client, buffered, err := hijacker.Hijack()
+	if err != nil {
+		upstream.Close()
+		return
+	}
+	client.Write([]byte("HTTP/1.1 200 Connection Established\r\n\r\n"))
+	go func() {
+		io.Copy(upstream, buffered)
+		upstream.Close()
+	}()
+	io.Copy(client, upstream)
+	client.Close()
+}
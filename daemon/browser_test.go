@@ -0,0 +1,293 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+var fakePNG = []byte("\x89PNG\r\n\x1a\nfake")
+
+const fakeRenderedHTML = "<!DOCTYPE html><html><head><title>Rendered</title></head><body><p>from script</p></body></html>"
+
+// TestMain lets the test binary stand in for Chrome: with
+// MEMENTO_FAKE_BROWSER set it answers DevTools commands on the debugging
+// pipe instead of running the tests.
+func TestMain(m *testing.M) {
+	if os.Getenv("MEMENTO_FAKE_BROWSER") != "" {
+		fakeBrowser()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// fakeBrowser answers the commands takeScreenshot and renderPage send.
+// Pages with "fail" in their URL don't load, and pages with "hang" never
+// finish loading.
+func fakeBrowser() {
+	in := bufio.NewReader(os.NewFile(3, "commands"))
+	out := os.NewFile(4, "output")
+	send := func(msg interface{}) {
+		data, _ := json.Marshal(msg)
+		out.Write(append(data, 0))
+	}
+	for {
+		data, err := in.ReadBytes(0)
+		if err != nil {
+			return
+		}
+		var req struct {
+			ID        int64                  `json:"id"`
+			SessionID string                 `json:"sessionId"`
+			Method    string                 `json:"method"`
+			Params    map[string]interface{} `json:"params"`
+		}
+		json.Unmarshal(data[:len(data)-1], &req)
+
+		result := map[string]interface{}{}
+		var event string
+		switch req.Method {
+		case "Target.createBrowserContext":
+			result["browserContextId"] = "ctx1"
+		case "Target.createTarget":
+			result["targetId"] = "target1"
+		case "Target.attachToTarget":
+			result["sessionId"] = "session1"
+		case "Page.enable", "Target.disposeBrowserContext":
+		case "Page.navigate":
+			pageURL := req.Params["url"].(string)
+			if strings.Contains(pageURL, "fail") {
+				result["errorText"] = "net::ERR_NAME_NOT_RESOLVED"
+			} else if !strings.Contains(pageURL, "hang") {
+				event = "Page.loadEventFired"
+			}
+		case "Page.getLayoutMetrics":
+			result["cssContentSize"] = map[string]float64{"width": 1280, "height": 50000.5}
+		case "Page.captureScreenshot":
+			clip := req.Params["clip"].(map[string]interface{})
+			if req.Params["captureBeyondViewport"] != true || clip["height"] != float64(maxScreenshotHeight) {
+				send(map[string]interface{}{"id": req.ID, "error": map[string]string{"message": fmt.Sprintf("unexpected params %v", req.Params)}})
+				continue
+			}
+			result["data"] = fakePNG
+		case "Runtime.evaluate":
+			result["result"] = map[string]string{"type": "string", "value": fakeRenderedHTML}
+		default:
+			send(map[string]interface{}{"id": req.ID, "error": map[string]string{"message": "unknown method " + req.Method}})
+			continue
+		}
+		send(map[string]interface{}{"id": req.ID, "sessionId": req.SessionID, "result": result})
+		if event != "" {
+			send(map[string]interface{}{"method": "Page.frameNavigated", "sessionId": req.SessionID, "params": map[string]string{}})
+			send(map[string]interface{}{"method": event, "sessionId": req.SessionID, "params": map[string]string{}})
+		}
+	}
+}
+
+// withFakeBrowser configures the fake browser for a test.
+func withFakeBrowser(t *testing.T) {
+	t.Helper()
+	os.Setenv("MEMENTO_FAKE_BROWSER", "1")
+	saved := config.Browser
+	config.Browser = BrowserConfig{Path: os.Args[0], TimeoutSeconds: 5}
+	t.Cleanup(func() {
+		stopBrowser()
+		config.Browser = saved
+		os.Unsetenv("MEMENTO_FAKE_BROWSER")
+	})
+}
+
+func TestTakeScreenshot(t *testing.T) {
+	withFakeBrowser(t)
+	config.Browser.TimeoutSeconds = 2
+
+	tests := []struct {
+		url     string
+		wantErr string
+	}{
+		{"https://example.com/", ""},
+		{"https://fail.example/", "ERR_NAME_NOT_RESOLVED"},
+		{"https://hang.example/", "deadline exceeded"},
+		// The browser is reused after a failed capture
+		{"https://example.com/again", ""},
+	}
+	for _, tt := range tests {
+		png, err := takeScreenshot(context.Background(), tt.url)
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("%s: error = %v, want %q", tt.url, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.url, err)
+		} else if !bytes.Equal(png, fakePNG) {
+			t.Errorf("%s: screenshot = %q", tt.url, png)
+		}
+	}
+}
+
+func TestTakeScreenshotRestartsBrowser(t *testing.T) {
+	withFakeBrowser(t)
+	if _, err := takeScreenshot(context.Background(), "https://example.com/"); err != nil {
+		t.Fatal(err)
+	}
+	first := runningBrowser
+	first.cmd.Process.Kill()
+	<-first.done
+	if _, err := takeScreenshot(context.Background(), "https://example.com/"); err != nil {
+		t.Fatal(err)
+	}
+	if runningBrowser == first {
+		t.Error("an exited browser was reused")
+	}
+}
+
+func TestTakeScreenshotWithoutBrowser(t *testing.T) {
+	saved := config.Browser
+	config.Browser = BrowserConfig{}
+	defer func() { config.Browser = saved }()
+	if _, err := takeScreenshot(context.Background(), "https://example.com/"); !errors.Is(err, errNoBrowser) {
+		t.Errorf("got %v, want errNoBrowser", err)
+	}
+}
+
+func TestRenderPage(t *testing.T) {
+	withFakeBrowser(t)
+	html, err := renderPage(context.Background(), "https://example.com/app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if html != fakeRenderedHTML {
+		t.Errorf("renderPage() = %q", html)
+	}
+	if _, err := renderPage(context.Background(), "https://fail.example/"); err == nil {
+		t.Error("rendering a page that doesn't load succeeded")
+	}
+}
+
+func TestBrowserContextPool(t *testing.T) {
+	withFakeBrowser(t)
+	config.Browser.Contexts = 1
+	b, err := getBrowser()
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, err := b.newTab(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if _, err := b.newTab(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("second tab: got %v, want to wait for the first", err)
+	}
+	first.close()
+	second, err := b.newTab(context.Background())
+	if err != nil {
+		t.Fatalf("tab after closing the first: %v", err)
+	}
+	second.close()
+}
+
+func TestPageScreenshot(t *testing.T) {
+	withPagesDir(t)
+	withFakeBrowser(t)
+	id, err := storePage(PageMetadata{URL: "https://example.com/", Title: "Example"}, "<p>x</p>", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bare, _ := storePage(PageMetadata{URL: "https://example.com/bare", Title: "Bare"}, "<p>y</p>", "")
+	if err := captureScreenshot(context.Background(), id, "https://example.com/"); err != nil {
+		t.Fatal(err)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/pages/{id}/screenshot", handlePageScreenshot)
+
+	tests := []struct {
+		id     string
+		status int
+	}{
+		{id, http.StatusOK},
+		{bare, http.StatusNotFound},
+		{"missing", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/pages/"+tt.id+"/screenshot", nil))
+		if rec.Code != tt.status {
+			t.Errorf("%s: status = %d, want %d", tt.id, rec.Code, tt.status)
+		}
+		if tt.status == http.StatusOK && (rec.Header().Get("Content-Type") != "image/png" || !bytes.Equal(rec.Body.Bytes(), fakePNG)) {
+			t.Errorf("%s: served %s %q", tt.id, rec.Header().Get("Content-Type"), rec.Body)
+		}
+	}
+
+	// Deleting the page removes its screenshot
+	withIndex(t, nil)
+	page, _ := loadPage(id)
+	if err := deletePage(page); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(pagesDir, id+".png")); !os.IsNotExist(err) {
+		t.Errorf("screenshot left behind after delete: %v", err)
+	}
+}
+
+func TestBrowserProxy(t *testing.T) {
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Target", "yes")
+		fmt.Fprint(w, "hello")
+	}))
+	defer target.Close()
+	tlsTarget := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "secure")
+	}))
+	defer tlsTarget.Close()
+
+	tests := []struct {
+		name   string
+		dial   func(context.Context, string, string) (net.Conn, error)
+		target *httptest.Server
+		want   string
+	}{
+		{"forwards", (&net.Dialer{}).DialContext, target, "hello"},
+		{"tunnels", (&net.Dialer{}).DialContext, tlsTarget, "secure"},
+		{"refuses private addresses", archiveDialer.DialContext, target, ""},
+		{"refuses private tunnels", archiveDialer.DialContext, tlsTarget, ""},
+	}
+	for _, tt := range tests {
+		proxy := httptest.NewServer(browserProxy{dial: tt.dial})
+		proxyURL, _ := url.Parse(proxy.URL)
+		transport := tt.target.Client().Transport.(*http.Transport).Clone()
+		transport.Proxy = http.ProxyURL(proxyURL)
+		client := &http.Client{Transport: transport}
+
+		resp, err := client.Get(tt.target.URL)
+		var body []byte
+		if err == nil {
+			body, _ = ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				body = nil
+			}
+		}
+		if string(body) != tt.want {
+			t.Errorf("%s: got %q (err %v), want %q", tt.name, body, err, tt.want)
+		}
+		proxy.Close()
+	}
+}
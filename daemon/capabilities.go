@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// pageAction describes something a client can do with a page. Href is a URL
+// template in which {id} and {url} stand for the page's ID and original URL;
+// actions without a method happen in the client, such as sharing the URL.
+type pageAction struct {
+	ID       string `json:"id"`
+	Label    string `json:"label"`
+	Method   string `json:"method,omitempty"`
+	Href     string `json:"href,omitempty"`
+	Shortcut string `json:"shortcut,omitempty"`
+	Enabled  bool   `json:"enabled"`
+	Reason   string `json:"reason,omitempty"`
+}
+
+// pageActions lists the actions on a page in the order a UI should offer
+// them. Supported is false for actions this daemon doesn't implement yet, so
+// clients can show them as unavailable rather than guessing.
+var pageActions = []struct {
+	pageAction
+	Supported bool
+}{
+	{pageAction{ID: "read", Method: http.MethodGet, Href: "/pages/{id}/read", Shortcut: "o"}, true},
+	{pageAction{ID: "share", Href: "{url}", Shortcut: "s"}, true},
+	{pageAction{ID: "epub", Method: http.MethodGet, Href: "/opds/pages/{id}", Shortcut: "e"}, true},
+	{pageAction{ID: "star", Shortcut: "*"}, false},
+	{pageAction{ID: "tag", Shortcut: "t"}, false},
+	{pageAction{ID: "delete", Method: http.MethodDelete, Href: "/pages/{id}", Shortcut: "#"}, true},
+}
+
+// actionEnabled reports whether an action hasn't been turned off in config.
+func actionEnabled(id string) bool {
+	for _, disabled := range config.DisabledActions {
+		if disabled == id {
+			return false
+		}
+	}
+	return true
+}
+
+// requireAction writes an ACTION_DISABLED error if the action is turned off,
+// returning false.
+func requireAction(w http.ResponseWriter, id string) bool {
+	if actionEnabled(id) {
+		return true
+	}
+	writeError(w, http.StatusForbidden, ErrActionDisabled, "The "+id+" action is disabled")
+	return false
+}
+
+// handleCapabilities describes the page actions available from this daemon,
+// with localized labels and suggested keyboard shortcuts, and lists the
+// features that are on.
+func handleCapabilities(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, ErrMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	localizer := localizerFor(r)
+	actions := make([]pageAction, 0, len(pageActions))
+	for _, entry := range pageActions {
+		action := entry.pageAction
+		action.Label = localizer.T("action." + action.ID)
+		switch {
+		case !entry.Supported:
+			action.Reason = "unsupported"
+		case !actionEnabled(action.ID):
+			action.Reason = "disabled"
+		default:
+			action.Enabled = true
+		}
+		actions = append(actions, action)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Add("Vary", "Accept-Language")
+	json.NewEncoder(w).Encode(map[string]interface{}{"actions": actions, "features": enabledFeatures()})
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHandleCapabilities(t *testing.T) {
+	saved := config
+	defer func() { config = saved }()
+	config.DisabledActions = []string{"epub"}
+
+	r := httptest.NewRequest(http.MethodGet, "/capabilities", nil)
+	r.Header.Set("Accept-Language", "de")
+	rec := httptest.NewRecorder()
+	handleCapabilities(rec, r)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", rec.Code)
+	}
+	var body struct {
+		Actions []pageAction `json:"actions"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if len(body.Actions) != len(pageActions) {
+		t.Fatalf("got %d actions, want %d", len(body.Actions), len(pageActions))
+	}
+	actions := map[string]pageAction{}
+	for _, action := range body.Actions {
+		actions[action.ID] = action
+	}
+	if action := actions["read"]; !action.Enabled || action.Label != "Lesen" || action.Shortcut != "o" {
+		t.Errorf("read = %+v", action)
+	}
+	if action := actions["epub"]; action.Enabled || action.Reason != "disabled" {
+		t.Errorf("epub = %+v, want disabled", action)
+	}
+	for _, entry := range pageActions {
+		if action := actions[entry.ID]; !entry.Supported && (action.Enabled || action.Reason != "unsupported") {
+			t.Errorf("%s = %+v, want unsupported", entry.ID, action)
+		}
+	}
+}
+
+func TestRequireAction(t *testing.T) {
+	saved := config
+	defer func() { config = saved }()
+	config.DisabledActions = []string{"delete"}
+
+	rec := httptest.NewRecorder()
+	if !requireAction(rec, "read") || rec.Body.Len() != 0 {
+		t.Error("enabled action refused")
+	}
+	rec = httptest.NewRecorder()
+	if requireAction(rec, "delete") {
+		t.Error("disabled action allowed")
+	}
+	if rec.Code != http.StatusForbidden || decodeError(t, rec).Code != ErrActionDisabled {
+		t.Errorf("status = %d, want 403 ACTION_DISABLED", rec.Code)
+	}
+}
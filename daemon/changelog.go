@@ -0,0 +1,301 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	changesFile = "memento_changes.jsonl"
+
+	changeAdd    = "add"
+	changeEdit   = "edit"
+	changeDelete = "delete"
+
+	// changesRetention is how long changes are kept, and the page sizes
+	// how many a request returns by default and at most.
+	changesRetention   = 90 * 24 * time.Hour
+	defaultChangesPage = 100
+	maxChangesPage     = 1000
+)
+
+// Change is an entry of the archive's changelog: a page added, deleted, or
+// with metadata edited. Seq orders changes and never repeats, so a mirror
+// can ask for those after the last it saw.
+type Change struct {
+	Seq    int64     `json:"seq"`
+	Type   string    `json:"type"`
+	PageID string    `json:"pageId"`
+	URL    string    `json:"url"`
+	Title  string    `json:"title"`
+	Owner  string    `json:"owner,omitempty"`
+	Time   time.Time `json:"time"`
+	// Fields are the metadata fields an edit changed.
+	Fields []string `json:"fields,omitempty"`
+}
+
+// trackedFields are the metadata fields, by JSON name, whose edits are
+// recorded. Bookkeeping such as indexing state and link checks is left
+// out.
+var trackedFields = []struct {
+	name  string
+	value func(PageMetadata) interface{}
+}{
+	{"url", func(m PageMetadata) interface{} { return m.URL }},
+	{"title", func(m PageMetadata) interface{} { return m.Title }},
+	{"tags", func(m PageMetadata) interface{} { return m.Tags }},
+	{"autoTags", func(m PageMetadata) interface{} { return m.AutoTags }},
+	{"citation", func(m PageMetadata) interface{} { return m.Citation }},
+	{"contentHash", func(m PageMetadata) interface{} { return m.ContentHash }},
+	{"owner", func(m PageMetadata) interface{} { return m.Owner }},
+	{"starred", func(m PageMetadata) interface{} { return m.Starred }},
+	{"read", func(m PageMetadata) interface{} { return m.Read }},
+	{"private", func(m PageMetadata) interface{} { return m.Private }},
+	{"annotations", func(m PageMetadata) interface{} { return m.Annotations }},
+	{"highlights", func(m PageMetadata) interface{} { return m.Highlights }},
+	{"expiresAt", func(m PageMetadata) interface{} { return m.ExpiresAt }},
+	{"supersededBy", func(m PageMetadata) interface{} { return m.SupersededBy }},
+}
+
+var changes struct {
+	sync.Mutex
+	// seq is the last sequence number used, 0 until read from the log.
+	seq int64
+}
+
+// changedFields lists the tracked fields that differ between two versions
+// of a page's metadata. A nil list is the same as an empty one.
+func changedFields(before, after PageMetadata) []string {
+	var fields []string
+	for _, field := range trackedFields {
+		a, b := reflect.ValueOf(field.value(before)), reflect.ValueOf(field.value(after))
+		if a.Kind() == reflect.Slice && a.Len() == 0 && b.Len() == 0 {
+			continue
+		}
+		if !reflect.DeepEqual(a.Interface(), b.Interface()) {
+			fields = append(fields, field.name)
+		}
+	}
+	return fields
+}
+
+// readChanges loads the changelog, skipping lines that don't parse, such
+// as one cut short by a crash. changes must be locked.
+func readChanges() ([]Change, error) {
+	data, err := ioutil.ReadFile(changesFile)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var logged []Change
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		var change Change
+		if json.Unmarshal(scanner.Bytes(), &change) == nil && change.Seq > 0 {
+			logged = append(logged, change)
+		}
+	}
+	return logged, scanner.Err()
+}
+
+// recordChange appends a change to the changelog. Failures are logged
+// rather than failing the change itself.
+func recordChange(changeType, id string, metadata PageMetadata, fields []string) {
+	changes.Lock()
+	defer changes.Unlock()
+	if changes.seq == 0 {
+		logged, err := readChanges()
+		if err != nil {
+			log.Printf("Error reading changelog: %v", err)
+			return
+		}
+		if len(logged) > 0 {
+			changes.seq = logged[len(logged)-1].Seq
+		}
+	}
+	change := Change{Seq: changes.seq + 1, Type: changeType, PageID: id, URL: metadata.URL, Title: metadata.Title,
+		Owner: metadata.Owner, Time: time.Now(), Fields: fields}
+	data, err := json.Marshal(change)
+	if err != nil {
+		log.Printf("Error encoding change: %v", err)
+		return
+	}
+	f, err := os.OpenFile(changesFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err == nil {
+		_, err = f.Write(append(data, '\n'))
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if err != nil {
+		log.Printf("Error recording change to page %s: %v", id, err)
+		return
+	}
+	changes.seq = change.Seq
+}
+
+// trimChanges drops changes older than changesRetention from the log,
+// always keeping the last so that sequence numbers carry on from it.
+func trimChanges(now time.Time) (int, error) {
+	changes.Lock()
+	defer changes.Unlock()
+	logged, err := readChanges()
+	if err != nil || len(logged) == 0 {
+		return 0, err
+	}
+	var kept bytes.Buffer
+	dropped := 0
+	for i, change := range logged {
+		if now.Sub(change.Time) > changesRetention && i < len(logged)-1 {
+			dropped++
+			continue
+		}
+		data, _ := json.Marshal(change)
+		kept.Write(append(data, '\n'))
+	}
+	if dropped == 0 {
+		return 0, nil
+	}
+	tmp := changesFile + ".tmp"
+	if err := ioutil.WriteFile(tmp, kept.Bytes(), 0600); err != nil {
+		return 0, err
+	}
+	return dropped, os.Rename(tmp, changesFile)
+}
+
+// watchChanges trims the changelog daily.
+func watchChanges() {
+	for {
+		if dropped, err := trimChanges(time.Now()); err != nil {
+			log.Printf("Error trimming changelog: %v", err)
+		} else if dropped > 0 {
+			log.Printf("Dropped %d changes older than %d days from the changelog", dropped, int(changesRetention.Hours()/24))
+		}
+		time.Sleep(24 * time.Hour)
+	}
+}
+
+// handleChanges lists the changes to the pages visible to the caller. As
+// JSON, it returns those after the sequence number in since, oldest first,
+// with next to pass as since for the following ones, and truncated when
+// changes after since were already trimmed, so a mirror knows to resync.
+// With format=atom it returns the latest changes, newest first, for feed
+// readers.
+func handleChanges(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+	format := params.Get("format")
+	if format != "" && format != "json" && format != "atom" {
+		writeError(w, http.StatusBadRequest, ErrInvalidRequest, "format must be json or atom")
+		return
+	}
+	var since int64
+	if s := params.Get("since"); s != "" {
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil || n < 0 {
+			writeError(w, http.StatusBadRequest, ErrInvalidRequest, "since must be a sequence number")
+			return
+		}
+		since = n
+	}
+	limit := defaultChangesPage
+	if l := params.Get("limit"); l != "" {
+		n, err := strconv.Atoi(l)
+		if err != nil || n < 1 || n > maxChangesPage {
+			writeError(w, http.StatusBadRequest, ErrInvalidRequest, fmt.Sprintf("limit must be between 1 and %d", maxChangesPage))
+			return
+		}
+		limit = n
+	}
+
+	changes.Lock()
+	logged, err := readChanges()
+	changes.Unlock()
+	if err != nil {
+		log.Printf("Error reading changelog: %v", err)
+		writeError(w, http.StatusInternalServerError, ErrInternal, "Failed to read changelog")
+		return
+	}
+	var visible []Change
+	for _, change := range logged {
+		if pageVisible(r, PageMetadata{Owner: change.Owner}) {
+			visible = append(visible, change)
+		}
+	}
+
+	if format == "atom" {
+		writeChangesFeed(w, r, visible, limit)
+		return
+	}
+	response := struct {
+		Changes   []Change `json:"changes"`
+		Next      int64    `json:"next"`
+		Truncated bool     `json:"truncated,omitempty"`
+	}{Changes: []Change{}, Next: since}
+	// Sequence numbers are consecutive across everyone's changes
+	response.Truncated = len(logged) > 0 && logged[0].Seq > since+1
+	for _, change := range visible {
+		if change.Seq <= since {
+			continue
+		}
+		if len(response.Changes) == limit {
+			break
+		}
+		response.Changes = append(response.Changes, change)
+		response.Next = change.Seq
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// writeChangesFeed writes the latest changes as an Atom feed.
+func writeChangesFeed(w http.ResponseWriter, r *http.Request, logged []Change, limit int) {
+	base := baseURL(r)
+	feed := opdsFeed{
+		ID:      "urn:memento:changes",
+		Title:   localizerFor(r).T("feed.changes"),
+		Updated: time.Now().UTC().Format(time.RFC3339),
+		Links:   []opdsLink{{Rel: "self", Href: base + "/changes?format=atom", Type: atomMediaType}},
+	}
+	if len(logged) > 0 {
+		feed.Updated = logged[len(logged)-1].Time.UTC().Format(time.RFC3339)
+	}
+	for i := len(logged) - 1; i >= 0 && len(feed.Entries) < limit; i-- {
+		change := logged[i]
+		entry := opdsEntry{
+			ID:         "urn:memento:change:" + strconv.FormatInt(change.Seq, 10),
+			Title:      change.Title,
+			Updated:    change.Time.UTC().Format(time.RFC3339),
+			Summary:    change.Type,
+			Categories: []opdsCategory{{Term: change.Type}},
+		}
+		if len(change.Fields) > 0 {
+			entry.Summary += ": " + strings.Join(change.Fields, ", ")
+		}
+		if change.URL != "" {
+			entry.Links = append(entry.Links, opdsLink{Rel: "alternate", Href: change.URL, Type: "text/html"})
+		}
+		if change.Type != changeDelete {
+			entry.Links = append(entry.Links, opdsLink{Rel: "related", Href: base + "/pages/" + change.PageID + "/read", Type: "text/html"})
+		}
+		feed.Entries = append(feed.Entries, entry)
+	}
+	w.Header().Set("Content-Type", atomMediaType+"; charset=utf-8")
+	w.Write([]byte(xml.Header))
+	if err := xml.NewEncoder(w).Encode(feed); err != nil {
+		log.Printf("Error encoding changes feed: %v", err)
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestChangelog(t *testing.T) {
+	withPagesDir(t)
+	withIndex(t, nil)
+	changes.seq = 0
+	t.Cleanup(func() { changes.seq = 0 })
+
+	id, err := storePage(PageMetadata{URL: "https://example.com/a", Title: "A"}, "<p>Text</p>", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	indexExistingFiles(context.Background())
+	reindexWith(context.Background(), id, func(metadata *PageMetadata) error {
+		metadata.Tags = []string{"news"}
+		metadata.Starred = true
+		return nil
+	})
+	// Bookkeeping isn't an edit, nor is an empty list replacing none
+	reindexWith(context.Background(), id, func(metadata *PageMetadata) error {
+		metadata.LiveCheck = &LiveCheck{Status: http.StatusOK}
+		metadata.Annotations = []Annotation{}
+		return nil
+	})
+	page, _ := loadPage(id)
+	if err := deletePage(page); err != nil {
+		t.Fatal(err)
+	}
+
+	type response struct {
+		Changes   []Change `json:"changes"`
+		Next      int64    `json:"next"`
+		Truncated bool     `json:"truncated"`
+	}
+	get := func(query string) response {
+		rec := httptest.NewRecorder()
+		newRouter().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/changes"+query, nil))
+		var got response
+		if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+			t.Fatalf("%s = %d %s", query, rec.Code, rec.Body)
+		}
+		return got
+	}
+	got := get("")
+	var types []string
+	for _, change := range got.Changes {
+		types = append(types, change.Type)
+	}
+	if want := []string{changeAdd, changeEdit, changeDelete}; !reflect.DeepEqual(types, want) || got.Next != 3 || got.Truncated {
+		t.Fatalf("changes = %+v, want %v", got, want)
+	}
+	if edit := got.Changes[1]; edit.PageID != id || !reflect.DeepEqual(edit.Fields, []string{"tags", "starred"}) {
+		t.Errorf("edit = %+v", edit)
+	}
+	if got := get("?since=1&limit=1"); len(got.Changes) != 1 || got.Changes[0].Seq != 2 || got.Next != 2 {
+		t.Errorf("since=1&limit=1 = %+v", got)
+	}
+	if got := get("?since=3"); len(got.Changes) != 0 || got.Next != 3 {
+		t.Errorf("since=3 = %+v", got)
+	}
+
+	rec := httptest.NewRecorder()
+	newRouter().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/changes?format=atom", nil))
+	if _, entries, err := parseFeed(rec.Body.Bytes(), "http://localhost/"); err != nil || len(entries) != 3 || entries[0].Key != "urn:memento:change:3" {
+		t.Errorf("atom feed = %+v, %v", entries, err)
+	}
+
+	// Old changes are trimmed, but numbering carries on from the last
+	if dropped, err := trimChanges(time.Now().Add(changesRetention + time.Hour)); err != nil || dropped != 2 {
+		t.Fatalf("trimChanges() = %d, %v", dropped, err)
+	}
+	if got := get("?since=1"); !got.Truncated || len(got.Changes) != 1 {
+		t.Errorf("after trimming, since=1 = %+v", got)
+	}
+	changes.seq = 0
+	recordChange(changeAdd, "b", PageMetadata{URL: "https://example.com/b"}, nil)
+	if got := get("?since=3"); len(got.Changes) != 1 || got.Changes[0].Seq != 4 || got.Truncated {
+		t.Errorf("after trimming, new change = %+v", got)
+	}
+}
@@ -0,0 +1,287 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	clicksFile = "memento_clicks.jsonl"
+	boostsFile = "memento_boosts.json"
+)
+
+// Clicks older than clickWindow are forgotten, and a click's weight halves
+// every clickHalfLife, so pages that were useful once fade back.
+const (
+	clickWindow   = 180 * 24 * time.Hour
+	clickHalfLife = 30 * 24 * time.Hour
+)
+
+// A page's boost grows with the log of its weighted clicks, up to
+// maxClickBoost times its relevance score.
+const (
+	clickBoostWeight = 0.25
+	maxClickBoost    = 2.0
+)
+
+// rerankDepth is how many times searchResultLimit hits are fetched when
+// boosts may reorder them.
+const rerankDepth = 3
+
+// searchClick is a search result the user opened.
+type searchClick struct {
+	PageID   string    `json:"id"`
+	Query    string    `json:"query,omitempty"`
+	Position int       `json:"position"`
+	Time     time.Time `json:"time"`
+}
+
+// clickBoosts are the per-page factors relevance scores are multiplied by,
+// as last computed from the click log.
+type clickBoosts struct {
+	Updated time.Time          `json:"updated"`
+	Boosts  map[string]float64 `json:"boosts"`
+}
+
+var (
+	// clicksMu guards the click log and boosts files.
+	clicksMu      sync.Mutex
+	currentBoosts struct {
+		sync.RWMutex
+		byID map[string]float64
+	}
+)
+
+// recordClick appends a click to the log. Clicks are only kept while
+// search.learnFromClicks is on, and never leave this machine.
+func recordClick(click searchClick) error {
+	if !config.Search.LearnFromClicks {
+		return nil
+	}
+	data, err := json.Marshal(click)
+	if err != nil {
+		return err
+	}
+	clicksMu.Lock()
+	defer clicksMu.Unlock()
+	f, err := os.OpenFile(clicksFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// readClicks loads the click log, skipping lines that don't parse, such as
+// one cut short by a crash. clicksMu must be held.
+func readClicks() ([]searchClick, error) {
+	data, err := ioutil.ReadFile(clicksFile)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var clicks []searchClick
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		var click searchClick
+		if json.Unmarshal(scanner.Bytes(), &click) == nil && click.PageID != "" {
+			clicks = append(clicks, click)
+		}
+	}
+	return clicks, scanner.Err()
+}
+
+// computeBoosts turns clicks into boost factors as of now.
+func computeBoosts(clicks []searchClick, now time.Time) map[string]float64 {
+	weights := make(map[string]float64)
+	for _, click := range clicks {
+		age := now.Sub(click.Time)
+		if age > clickWindow {
+			continue
+		}
+		if age < 0 {
+			age = 0
+		}
+		weights[click.PageID] += math.Pow(0.5, float64(age)/float64(clickHalfLife))
+	}
+	boosts := make(map[string]float64, len(weights))
+	for id, weight := range weights {
+		boosts[id] = math.Min(1+clickBoostWeight*math.Log1p(weight), maxClickBoost)
+	}
+	return boosts
+}
+
+// updateBoosts recomputes the boosts from the click log, dropping clicks
+// too old to count from it, and saves them.
+func updateBoosts(now time.Time) (clickBoosts, error) {
+	clicksMu.Lock()
+	defer clicksMu.Unlock()
+	clicks, err := readClicks()
+	if err != nil {
+		return clickBoosts{}, err
+	}
+	var kept bytes.Buffer
+	for _, click := range clicks {
+		if now.Sub(click.Time) <= clickWindow {
+			data, _ := json.Marshal(click)
+			kept.Write(append(data, '\n'))
+		}
+	}
+	tmp := clicksFile + ".tmp"
+	if err := ioutil.WriteFile(tmp, kept.Bytes(), 0600); err != nil {
+		return clickBoosts{}, err
+	}
+	if err := os.Rename(tmp, clicksFile); err != nil {
+		return clickBoosts{}, err
+	}
+
+	boosts := clickBoosts{Updated: now, Boosts: computeBoosts(clicks, now)}
+	data, err := json.MarshalIndent(boosts, "", "  ")
+	if err != nil {
+		return boosts, err
+	}
+	tmp = boostsFile + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+		return boosts, err
+	}
+	if err := os.Rename(tmp, boostsFile); err != nil {
+		return boosts, err
+	}
+	setBoosts(boosts.Boosts)
+	return boosts, nil
+}
+
+// loadBoosts reads the boosts last saved.
+func loadBoosts() (clickBoosts, error) {
+	clicksMu.Lock()
+	defer clicksMu.Unlock()
+	var boosts clickBoosts
+	data, err := ioutil.ReadFile(boostsFile)
+	if os.IsNotExist(err) {
+		return boosts, nil
+	}
+	if err != nil {
+		return boosts, err
+	}
+	if err := json.Unmarshal(data, &boosts); err != nil {
+		return boosts, fmt.Errorf("parsing %s: %v", boostsFile, err)
+	}
+	return boosts, nil
+}
+
+func setBoosts(byID map[string]float64) {
+	currentBoosts.Lock()
+	defer currentBoosts.Unlock()
+	currentBoosts.byID = byID
+}
+
+// pageBoost returns the factor a page's relevance is multiplied by.
+func pageBoost(id string) float64 {
+	currentBoosts.RLock()
+	defer currentBoosts.RUnlock()
+	if boost, ok := currentBoosts.byID[id]; ok {
+		return boost
+	}
+	return 1
+}
+
+func haveBoosts() bool {
+	currentBoosts.RLock()
+	defer currentBoosts.RUnlock()
+	return len(currentBoosts.byID) > 0
+}
+
+// watchClickBoosts recomputes the boosts every
+// search.rerankIntervalHours while learning from clicks is on.
+func watchClickBoosts() {
+	if !config.Search.LearnFromClicks {
+		return
+	}
+	interval := time.Duration(config.Search.RerankIntervalHours) * time.Hour
+	boosts, err := loadBoosts()
+	if err != nil {
+		log.Printf("Error loading click boosts: %v", err)
+	}
+	setBoosts(boosts.Boosts)
+	for {
+		if wait := time.Until(boosts.Updated.Add(interval)); wait > 0 {
+			time.Sleep(wait)
+		}
+		if boosts, err = updateBoosts(time.Now()); err != nil {
+			log.Printf("Error updating click boosts: %v", err)
+			boosts.Updated = time.Now()
+			continue
+		}
+		log.Printf("Updated search boosts for %d pages from clicks", len(boosts.Boosts))
+	}
+}
+
+// rerankResults multiplies the scores of results by their pages' boosts and
+// sorts them again, keeping the first limit.
+func rerankResults(results []SearchResult, limit int) []SearchResult {
+	for i := range results {
+		results[i].Score *= pageBoost(results[i].ID)
+	}
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].Score > results[j].Score
+	})
+	if len(results) > limit {
+		results = results[:limit]
+	}
+	return results
+}
+
+// handleSearchClick records a search result opened from a UI, given as
+// {"id": "...", "query": "...", "position": 0}.
+func handleSearchClick(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, ErrMethodNotAllowed, "Method not allowed")
+		return
+	}
+	var click searchClick
+	if err := json.NewDecoder(r.Body).Decode(&click); err != nil || click.PageID == "" || click.Position < 0 {
+		writeError(w, http.StatusBadRequest, ErrInvalidRequest, "Request body must give the id and position of a result")
+		return
+	}
+	if page, err := loadPage(click.PageID); err != nil || !pageVisible(r, page.PageMetadata) {
+		writeError(w, http.StatusNotFound, ErrNotFound, "Page not found")
+		return
+	}
+	click.Time = time.Now()
+	if err := recordClick(click); err != nil {
+		log.Printf("Error recording search click: %v", err)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleTextOpen records a result opened from the text search page, then
+// sends the browser on to the page's URL.
+func handleTextOpen(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+	page, err := loadPage(params.Get("id"))
+	if err != nil || !pageVisible(r, page.PageMetadata) {
+		writeError(w, http.StatusNotFound, ErrNotFound, "Page not found")
+		return
+	}
+	position, _ := strconv.Atoi(params.Get("position"))
+	if err := recordClick(searchClick{PageID: page.ID, Query: params.Get("q"), Position: position, Time: time.Now()}); err != nil {
+		log.Printf("Error recording search click: %v", err)
+	}
+	http.Redirect(w, r, page.URL, http.StatusSeeOther)
+}
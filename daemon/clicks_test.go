@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestComputeBoosts(t *testing.T) {
+	now := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	clicksAt := func(id string, n int, age time.Duration) []searchClick {
+		var clicks []searchClick
+		for i := 0; i < n; i++ {
+			clicks = append(clicks, searchClick{PageID: id, Time: now.Add(-age)})
+		}
+		return clicks
+	}
+	tests := []struct {
+		name   string
+		clicks []searchClick
+		min    float64
+		max    float64
+	}{
+		{"none", nil, 1, 1},
+		{"one recent click", clicksAt("a", 1, 0), 1.17, 1.18},
+		{"older clicks count less", clicksAt("a", 1, clickHalfLife), 1.10, 1.11},
+		{"too old", clicksAt("a", 5, clickWindow+time.Hour), 1, 1},
+		{"capped", clicksAt("a", 1000, 0), maxClickBoost, maxClickBoost},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			boosts := computeBoosts(tt.clicks, now)
+			got, ok := boosts["a"]
+			if !ok {
+				got = 1
+			}
+			if got < tt.min || got > tt.max {
+				t.Errorf("boost = %v, want between %v and %v", got, tt.min, tt.max)
+			}
+		})
+	}
+}
+
+func TestRerankedSearch(t *testing.T) {
+	now := time.Now()
+	withIndex(t, map[string]PageDocument{
+		"often":  {URL: "https://often.example", Title: "Often", Content: "gardening notes and other things about the garden shed", Time: now},
+		"seldom": {URL: "https://seldom.example", Title: "Seldom", Content: "gardening gardening gardening", Time: now},
+	})
+	defer setBoosts(nil)
+
+	first := func(sort string) string {
+		results, err := searchPages(context.Background(), "gardening", SearchOptions{Sort: sort, Highlight: "none"}, Localizer{Lang: defaultLocale})
+		if err != nil || len(results) != 2 {
+			t.Fatalf("search = %v, %v", results, err)
+		}
+		return results[0].ID
+	}
+	if got := first("score"); got != "seldom" {
+		t.Fatalf("without boosts %s ranks first", got)
+	}
+	setBoosts(map[string]float64{"often": maxClickBoost})
+	if got := first("score"); got != "often" {
+		t.Errorf("with boosts %s ranks first", got)
+	}
+	if got := first("-time"); got == "" {
+		t.Errorf("time-sorted search returned nothing")
+	}
+}
+
+func TestSearchClicks(t *testing.T) {
+	withPagesDir(t)
+	withIndex(t, nil)
+	saved := config.Search.LearnFromClicks
+	defer func() { config.Search.LearnFromClicks = saved }()
+	defer setBoosts(nil)
+	id, err := storePage(PageMetadata{URL: "https://example.com/clicked", Title: "Clicked"}, "<p>Clicked</p>", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		learn  bool
+		method string
+		target string
+		body   string
+		want   int
+		logged bool
+	}{
+		{"disabled", false, http.MethodPost, "/search/clicks", `{"id":"` + id + `","position":0}`, http.StatusNoContent, false},
+		{"post", true, http.MethodPost, "/search/clicks", `{"id":"` + id + `","query":"clicked","position":1}`, http.StatusNoContent, true},
+		{"unknown page", true, http.MethodPost, "/search/clicks", `{"id":"missing","position":0}`, http.StatusNotFound, false},
+		{"no id", true, http.MethodPost, "/search/clicks", `{"position":0}`, http.StatusBadRequest, false},
+		{"get", true, http.MethodGet, "/search/clicks", "", http.StatusMethodNotAllowed, false},
+		{"text open", true, http.MethodGet, "/text/open?id=" + id + "&q=clicked&position=0", "", http.StatusSeeOther, true},
+		{"text open unknown", true, http.MethodGet, "/text/open?id=missing", "", http.StatusNotFound, false},
+	}
+	countClicks := func() int {
+		clicksMu.Lock()
+		defer clicksMu.Unlock()
+		clicks, err := readClicks()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(clicks)
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := countClicks()
+			config.Search.LearnFromClicks = tt.learn
+			req := httptest.NewRequest(tt.method, tt.target, strings.NewReader(tt.body))
+			w := httptest.NewRecorder()
+			if strings.HasPrefix(tt.target, "/text/open") {
+				handleTextOpen(w, req)
+			} else {
+				handleSearchClick(w, req)
+			}
+			if w.Code != tt.want {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.want, w.Body)
+			}
+			if tt.want == http.StatusSeeOther && w.Header().Get("Location") != "https://example.com/clicked" {
+				t.Errorf("Location = %q", w.Header().Get("Location"))
+			}
+			if logged := countClicks() > before; logged != tt.logged {
+				t.Errorf("click logged = %v, want %v", logged, tt.logged)
+			}
+		})
+	}
+
+	boosts, err := updateBoosts(time.Now())
+	if err != nil || boosts.Boosts[id] <= 1 || pageBoost(id) != boosts.Boosts[id] {
+		t.Errorf("updateBoosts() = %+v, %v", boosts, err)
+	}
+	if loaded, err := loadBoosts(); err != nil || loaded.Boosts[id] != boosts.Boosts[id] {
+		t.Errorf("loadBoosts() = %+v, %v", loaded, err)
+	}
+}
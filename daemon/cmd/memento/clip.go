@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"regexp"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// clipURLRe finds http(s) URLs in copied text.
+var clipURLRe = regexp.MustCompile(`https?://[^\s<>"'` + "`" + `]+`)
+
+// clipboardCommands are the commands tried in order to read the clipboard
+// as text on each platform.
+var clipboardCommands = map[string][][]string{
+	"darwin":  {{"pbpaste"}},
+	"windows": {{"powershell", "-NoProfile", "-Command", "Get-Clipboard"}},
+	"linux":   {{"wl-paste", "--no-newline"}, {"xclip", "-selection", "clipboard", "-o"}, {"xsel", "--clipboard", "--output"}},
+}
+
+var errNoClipboard = errors.New("no clipboard command found; install wl-clipboard, xclip or xsel")
+
+// readClipboard returns the text on the clipboard.
+func readClipboard() (string, error) {
+	commands := clipboardCommands[runtime.GOOS]
+	if len(commands) == 0 {
+		commands = clipboardCommands["linux"]
+	}
+	for _, command := range commands {
+		// wl-paste only works under Wayland
+		if command[0] == "wl-paste" && os.Getenv("WAYLAND_DISPLAY") == "" {
+			continue
+		}
+		if _, err := exec.LookPath(command[0]); err != nil {
+			continue
+		}
+		out, err := exec.Command(command[0], command[1:]...).Output()
+		if err != nil {
+			// An empty or non-text clipboard is an error for some tools
+			return "", nil
+		}
+		return string(out), nil
+	}
+	return "", errNoClipboard
+}
+
+// clipboardURLs returns the http(s) URLs in copied text, without the
+// punctuation that tends to follow a link in prose.
+func clipboardURLs(text string) []string {
+	var urls []string
+	for _, match := range clipURLRe.FindAllString(text, -1) {
+		match = strings.TrimRight(match, ".,;:!?)]}")
+		if u, err := url.Parse(match); err == nil && u.Host != "" {
+			urls = append(urls, match)
+		}
+	}
+	return urls
+}
+
+// clip watches the clipboard and archives the URLs copied to it, asking
+// first with -ask. What is on the clipboard when it starts is left alone.
+func (c *client) clip(args []string) error {
+	flags := flag.NewFlagSet("clip", flag.ExitOnError)
+	interval := flags.Duration("interval", time.Second, "how often to check the clipboard")
+	ask := flags.Bool("ask", false, "ask before archiving each URL")
+	render := flags.Bool("render", false, "store pages after their JavaScript has run")
+	screenshot := flags.Bool("screenshot", false, "also store full-page screenshots")
+	assets := flags.Bool("assets", false, "also store pages' images, stylesheets and fonts")
+	flags.Parse(args)
+	if flags.NArg() != 0 || *interval <= 0 {
+		return fmt.Errorf("clip takes no arguments and a positive -interval")
+	}
+
+	last, err := readClipboard()
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(os.Stderr, "Watching the clipboard for URLs; press Ctrl-C to stop")
+
+	// Captures run one at a time while the clipboard is still watched
+	queue := make(chan string, 100)
+	go func() {
+		for pageURL := range queue {
+			request := map[string]interface{}{"url": pageURL, "render": *render, "screenshot": *screenshot, "assets": *assets}
+			var p page
+			if err := c.getJSON(http.MethodPost, "/archive", request, &p); unreachable(err) {
+				if err := c.spoolCapture("/archive", request, "", pageURL, err); err != nil {
+					fmt.Fprintf(os.Stderr, "%s: %v\n", pageURL, err)
+				}
+			} else if err != nil {
+				fmt.Fprintf(os.Stderr, "%s: %v\n", pageURL, err)
+			} else if !jsonOutput {
+				fmt.Printf("Archived %s as %s\n", p.URL, p.ID)
+			}
+		}
+	}()
+
+	stdin := bufio.NewReader(os.Stdin)
+	seen := make(map[string]bool)
+	for {
+		time.Sleep(*interval)
+		text, err := readClipboard()
+		if err != nil {
+			return err
+		}
+		if text == last {
+			continue
+		}
+		last = text
+		for _, pageURL := range clipboardURLs(text) {
+			if seen[pageURL] {
+				continue
+			}
+			seen[pageURL] = true
+			if *ask {
+				fmt.Fprintf(os.Stderr, "Archive %s? [Y/n] ", pageURL)
+				answer, _ := stdin.ReadString('\n')
+				if answer = strings.ToLower(strings.TrimSpace(answer)); answer != "" && answer != "y" && answer != "yes" {
+					continue
+				}
+			}
+			select {
+			case queue <- pageURL:
+				fmt.Fprintf(os.Stderr, "Queued %s\n", pageURL)
+			default:
+				fmt.Fprintf(os.Stderr, "%s: too many captures waiting; copy it again later\n", pageURL)
+				delete(seen, pageURL)
+			}
+		}
+	}
+}
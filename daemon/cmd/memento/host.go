@@ -0,0 +1,226 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// nativeHostName is the name the extension reaches the host by, registered
+// with the browser by the host's manifest.
+const nativeHostName = "com.memento.host"
+
+const (
+	// maxNativeReplyBytes is the largest message browsers accept from a
+	// native messaging host.
+	maxNativeReplyBytes = 1 << 20
+	// maxNativeRequestBytes bounds the messages read from the browser,
+	// which carry captures.
+	maxNativeRequestBytes = 64 << 20
+)
+
+// hostPaths are the API endpoints the extension may reach through the host,
+// for capturing and searching pages.
+var hostPaths = map[string]bool{
+	"/archive":         true,
+	"/search":          true,
+	"/search/clicks":   true,
+	"/suggest":         true,
+	"/highlights":      true,
+	"/recapture/queue": true,
+}
+
+// hostPagePath matches the page endpoints the extension may reach through
+// the host, for its related reads.
+var hostPagePath = regexp.MustCompile(`^/pages/[^/]+/related$`)
+
+// nativeRequest is an API request sent by the extension. Token is the
+// extension's API token, used in place of the host's own when set.
+type nativeRequest struct {
+	ID     json.RawMessage `json:"id,omitempty"`
+	Method string          `json:"method"`
+	Path   string          `json:"path"`
+	Token  string          `json:"token,omitempty"`
+	Body   json.RawMessage `json:"body,omitempty"`
+}
+
+// nativeReply carries the daemon's status and JSON response back to the
+// extension. Failures of the host itself use the daemon's error envelope.
+type nativeReply struct {
+	ID     json.RawMessage `json:"id,omitempty"`
+	Status int             `json:"status"`
+	Body   json.RawMessage `json:"body,omitempty"`
+}
+
+// launchedByBrowser reports whether the arguments are those browsers start
+// native messaging hosts with: Chrome passes the extension's origin, and
+// Firefox the host manifest's path and the extension's ID.
+func launchedByBrowser(args []string) bool {
+	if len(args) > 0 && strings.HasPrefix(args[0], "chrome-extension://") {
+		return true
+	}
+	return len(args) == 2 && strings.HasSuffix(args[0], ".json") && !strings.HasPrefix(args[1], "-")
+}
+
+// readNativeMessage reads one message, its length in native byte order
+// followed by that much JSON. It returns io.EOF when the browser closes the
+// connection.
+func readNativeMessage(r io.Reader) ([]byte, error) {
+	var length uint32
+	if err := binary.Read(r, binary.NativeEndian, &length); err != nil {
+		return nil, err
+	}
+	if length > maxNativeRequestBytes {
+		return nil, fmt.Errorf("message of %d bytes exceeds the maximum of %d", length, maxNativeRequestBytes)
+	}
+	message := make([]byte, length)
+	if _, err := io.ReadFull(r, message); err != nil {
+		return nil, err
+	}
+	return message, nil
+}
+
+func writeNativeMessage(w io.Writer, v interface{}) error {
+	message, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if err := binary.Write(w, binary.NativeEndian, uint32(len(message))); err != nil {
+		return err
+	}
+	_, err = w.Write(message)
+	return err
+}
+
+// errorReply answers a request the host couldn't forward.
+func errorReply(id json.RawMessage, status int, code, message string) nativeReply {
+	var envelope apiError
+	envelope.Error.Code, envelope.Error.Message = code, message
+	body, _ := json.Marshal(envelope)
+	return nativeReply{ID: id, Status: status, Body: body}
+}
+
+// forward sends an extension's request to the daemon and returns its
+// response, whatever its status.
+func (c *client) forward(request nativeRequest) nativeReply {
+	target, err := url.Parse(request.Path)
+	if err != nil || !strings.HasPrefix(request.Path, "/") || target.Scheme != "" || target.Host != "" {
+		return errorReply(request.ID, http.StatusBadRequest, "INVALID_REQUEST", "path must be an API path")
+	}
+	if !hostPaths[target.Path] && !hostPagePath.MatchString(target.Path) {
+		return errorReply(request.ID, http.StatusForbidden, "FORBIDDEN", fmt.Sprintf("%s is not available through the native host", target.Path))
+	}
+	method := request.Method
+	if method == "" {
+		method = http.MethodGet
+	}
+	var body io.Reader
+	if len(request.Body) > 0 {
+		body = bytes.NewReader(request.Body)
+	}
+	req, err := http.NewRequest(method, c.server+apiPrefix+request.Path, body)
+	if err != nil {
+		return errorReply(request.ID, http.StatusBadRequest, "INVALID_REQUEST", err.Error())
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if token := request.Token; token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	} else if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return errorReply(request.ID, http.StatusBadGateway, "INTERNAL", fmt.Sprintf("Daemon unreachable: %v", err))
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxNativeReplyBytes+1))
+	if err != nil {
+		return errorReply(request.ID, http.StatusBadGateway, "INTERNAL", err.Error())
+	}
+	// Leave room for the reply around the body
+	if len(data) > maxNativeReplyBytes-1024 {
+		return errorReply(request.ID, http.StatusBadGateway, "INTERNAL", "Response is too large for native messaging")
+	}
+	reply := nativeReply{ID: request.ID, Status: resp.StatusCode}
+	if json.Valid(data) {
+		reply.Body = data
+	}
+	return reply
+}
+
+// serveNativeMessages forwards each request read from r to the daemon and
+// writes its reply to w, until the browser closes the connection.
+func (c *client) serveNativeMessages(r io.Reader, w io.Writer) error {
+	for {
+		message, err := readNativeMessage(r)
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		var request nativeRequest
+		reply := errorReply(nil, http.StatusBadRequest, "INVALID_REQUEST", "Invalid request message")
+		if json.Unmarshal(message, &request) == nil {
+			reply = c.forward(request)
+		}
+		if err := writeNativeMessage(w, reply); err != nil {
+			return err
+		}
+	}
+}
+
+// hostManifest is the file registering the host with a browser, allowing
+// only the given extension to start it.
+func hostManifest(browser, extensionID, path string) (map[string]interface{}, error) {
+	manifest := map[string]interface{}{
+		"name":        nativeHostName,
+		"description": "Memento daemon access for the browser extension",
+		"path":        path,
+		"type":        "stdio",
+	}
+	switch browser {
+	case "chrome":
+		manifest["allowed_origins"] = []string{"chrome-extension://" + extensionID + "/"}
+	case "firefox":
+		manifest["allowed_extensions"] = []string{extensionID}
+	default:
+		return nil, fmt.Errorf("unknown browser %q; use chrome or firefox", browser)
+	}
+	return manifest, nil
+}
+
+func (c *client) host(args []string) error {
+	flags := flag.NewFlagSet("host", flag.ExitOnError)
+	browser := flags.String("manifest", "", "print the host manifest for this browser, chrome or firefox, instead of serving")
+	extensionID := flags.String("extension", "", "ID of the extension allowed to use the host, for -manifest")
+	flags.Parse(args)
+	if *browser == "" {
+		return c.serveNativeMessages(os.Stdin, os.Stdout)
+	}
+	if *extensionID == "" {
+		return fmt.Errorf("-manifest needs the extension's ID with -extension")
+	}
+	path, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	manifest, err := hostManifest(*browser, *extensionID, path)
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(manifest)
+}
@@ -0,0 +1,1233 @@
+// Command memento is a command-line client for the memento daemon's HTTP API.
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+const defaultServer = "http://localhost:8080"
+
+// apiPrefix is the version of the daemon API the client speaks.
+const apiPrefix = "/api/v1"
+
+type page struct {
+	ID        string    `json:"id"`
+	URL       string    `json:"url"`
+	Title     string    `json:"title"`
+	Timestamp time.Time `json:"timestamp"`
+	Tags      []string  `json:"tags,omitempty"`
+	Source    string    `json:"source,omitempty"`
+	WordCount int       `json:"wordCount,omitempty"`
+}
+
+type pageList struct {
+	Total int    `json:"total"`
+	Pages []page `json:"pages"`
+}
+
+type searchResult struct {
+	URL     string    `json:"url"`
+	Title   string    `json:"title"`
+	Snippet string    `json:"snippet"`
+	Score   float64   `json:"score"`
+	Time    time.Time `json:"time"`
+	// Instance is set by federated searches.
+	Instance string `json:"instance"`
+}
+
+type apiError struct {
+	Error struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+type client struct {
+	server string
+	token  string
+	http   *http.Client
+	stream *http.Client
+}
+
+var jsonOutput bool
+
+func usage() {
+	fmt.Fprintf(os.Stderr, `Usage: memento [-server URL] [-token TOKEN] [-cert FILE -key FILE] [-cacert FILE] [-json] <command> [args]
+
+Commands:
+  search [-sort score|time|-time|title] [-fuzziness N] [-prefix] [-exact] [-semantic|-hybrid] [-as-of DATE] [-starred] [-unread] [-changed] [-stale] [-private] [-federated] [-collapse] [-group-by collection|domain|tag] <query>
+                        Search archived pages, optionally showing the best
+                        of them from each source, domain or tag
+  ask [-passages N] <question>
+                        Answer a question from the archived pages, citing
+                        the passages the answer comes from
+  history [-limit N] [query] | -stats [-days N] | -clear
+                        List your recent searches, those containing query,
+                        or the queries searched most and those that found
+                        nothing, or forget them
+  related [-limit N] <id>
+                        List the archived pages most like a page, to read
+                        next
+  add|capture [-render] [-screenshot] [-assets] [-keep-days N] <url|file.pdf>
+                        Fetch and archive a page, optionally as rendered by
+                        the daemon's headless browser, with a screenshot and
+                        its images, stylesheets and fonts; or upload a local
+                        PDF. With -keep-days, the page is removed after N
+                        days unless starred. When the daemon is unreachable,
+                        the capture is spooled and sent by a later command
+  bookmark [-title T] [-tags a,b] [-note TEXT] <url>
+                        Save a URL without capturing it, to be found by its
+                        title, tags and note and captured later
+  bookmark -capture <id>
+                        Capture a bookmarked page, keeping its tags and note
+  flush [-watch D] [-list]
+                        Send spooled captures now, or every D until the
+                        daemon is back, or list them
+  clip [-interval D] [-ask] [-render] [-screenshot] [-assets]
+                        Watch the clipboard and archive the URLs copied to
+                        it, for links from apps that don't take extensions
+  ls [-limit N] [-offset N] [-starred] [-unread] [-changed] [-bookmarks]
+                        List archived pages, newest first
+  rm <id>...            Delete pages
+  trash [restore <id>...]
+                        List deleted pages, or restore them from the trash
+  export [-format tar.gz|zip|karakeep|warc] <file|->
+                        Download an export bundle
+  pull [-at DATE] <url|->...
+                        Copy the best snapshots of URLs, or of those read
+                        from stdin, from the daemon's Memento web archives:
+                        the closest to DATE, or else the newest
+  host [-manifest chrome|firefox -extension ID]
+                        Serve the extension over native messaging, forwarding
+                        its capture and search requests to the daemon, or
+                        print the manifest registering the host
+  checklinks [-limit N] [-report]
+                        Check archived URLs for link rot, up to N of those
+                        checked least recently, or list the pages whose URLs
+                        were gone when last checked
+  feeds [add [-tags a,b] [-interval MINUTES] <url> | rm <id> | poll <id>]
+                        List the RSS and Atom feeds whose new entries are
+                        archived, subscribe to or drop one, or poll one now
+  site [-links] [-tags a,b] [-max-pages N] [-depth N] [-include RE] [-exclude RE]
+       [-concurrency N] [-delay SECONDS] [-wait] <domain|sitemap-url|seed-url>
+                        Archive every page a site's sitemap lists, or with
+                        -links the pages linked from a seed URL, in the
+                        background, or list the crawls, or cancel one with
+                        site rm <id>
+  reindex [-wait]       Rebuild the search index from stored pages
+  reextract [-since VERSION|DATE] [-wait]
+                        Extract the text and titles of pages again from their
+                        stored HTML and PDFs, those extracted before an
+                        extractor version or date, or all of them
+  jobs [-kind KIND] [-status STATUS] | <id> | rm <id>
+                        List the daemon's background jobs, show one, or
+                        cancel one
+
+The server defaults to $MEMENTO_URL or %s, and the API token
+to $MEMENTO_TOKEN. A client certificate can authenticate in place of a token.
+`, defaultServer)
+}
+
+func main() {
+	server := os.Getenv("MEMENTO_URL")
+	if server == "" {
+		server = defaultServer
+	}
+	flag.StringVar(&server, "server", server, "daemon base URL")
+	token := flag.String("token", os.Getenv("MEMENTO_TOKEN"), "API token")
+	certFile := flag.String("cert", os.Getenv("MEMENTO_CERT"), "client certificate file")
+	keyFile := flag.String("key", os.Getenv("MEMENTO_KEY"), "client certificate key file")
+	caFile := flag.String("cacert", os.Getenv("MEMENTO_CACERT"), "CA file for verifying the daemon")
+	flag.BoolVar(&jsonOutput, "json", false, "print raw JSON responses")
+	flag.Usage = usage
+	// Browsers start the native messaging host with their own arguments
+	if launchedByBrowser(os.Args[1:]) {
+		os.Args = []string{os.Args[0], "host"}
+	}
+	flag.Parse()
+	if flag.NArg() == 0 {
+		usage()
+		os.Exit(2)
+	}
+
+	transport, err := newTransport(*certFile, *keyFile, *caFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "memento: %v\n", err)
+		os.Exit(1)
+	}
+	c := &client{
+		server: strings.TrimRight(server, "/"),
+		token:  *token,
+		http:   &http.Client{Timeout: 2 * time.Minute, Transport: transport},
+		// Exports stream for as long as the archive takes to write
+		stream: &http.Client{Transport: transport},
+	}
+	args := flag.Args()[1:]
+	// Captures spooled while the daemon was unreachable are sent first
+	if command := flag.Arg(0); command != "host" && command != "flush" && command != "help" {
+		if _, err := c.flushSpool(); err != nil {
+			fmt.Fprintf(os.Stderr, "memento: sending spooled captures: %v\n", err)
+		}
+	}
+	switch flag.Arg(0) {
+	case "search":
+		err = c.search(args)
+	case "ask":
+		err = c.ask(args)
+	case "related":
+		err = c.related(args)
+	case "history":
+		err = c.history(args)
+	case "add", "capture":
+		err = c.add(args)
+	case "bookmark":
+		err = c.bookmark(args)
+	case "clip":
+		err = c.clip(args)
+	case "ls":
+		err = c.list(args)
+	case "rm":
+		err = c.remove(args)
+	case "trash":
+		err = c.trash(args)
+	case "export":
+		err = c.export(args)
+	case "pull":
+		err = c.pull(args)
+	case "host":
+		err = c.host(args)
+	case "checklinks":
+		err = c.checkLinks(args)
+	case "feeds":
+		err = c.feeds(args)
+	case "site":
+		err = c.site(args)
+	case "reindex":
+		err = c.reindex(args)
+	case "reextract":
+		err = c.reextract(args)
+	case "jobs":
+		err = c.jobs(args)
+	case "flush":
+		err = c.flush(args)
+	case "help":
+		usage()
+	default:
+		usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "memento: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// newTransport configures TLS for a daemon served over HTTPS, presenting a
+// client certificate if one is given.
+func newTransport(certFile, keyFile, caFile string) (*http.Transport, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if certFile == "" && caFile == "" {
+		return transport, nil
+	}
+	tlsConfig := &tls.Config{}
+	if certFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("loading client certificate: %v", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	if caFile != "" {
+		pem, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", caFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	transport.TLSClientConfig = tlsConfig
+	return transport, nil
+}
+
+// do sends a request to the daemon, turning error responses into errors
+// carrying the daemon's error code and message.
+func (c *client) do(method, path string, body interface{}) (*http.Response, error) {
+	return c.send(c.http, method, path, body)
+}
+
+// upload is a request body sent as is rather than as JSON.
+type upload struct {
+	contentType string
+	data        []byte
+}
+
+func (c *client) send(httpClient *http.Client, method, path string, body interface{}) (*http.Response, error) {
+	var reader io.Reader
+	contentType := "application/json"
+	if u, ok := body.(upload); ok {
+		reader, contentType = bytes.NewReader(u.data), u.contentType
+	} else if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, c.server+apiPrefix+path, reader)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 400 {
+		defer resp.Body.Close()
+		var apiErr apiError
+		if json.NewDecoder(resp.Body).Decode(&apiErr) == nil && apiErr.Error.Code != "" {
+			return nil, fmt.Errorf("%s: %s", apiErr.Error.Code, apiErr.Error.Message)
+		}
+		return nil, fmt.Errorf("%s %s: %s", method, path, resp.Status)
+	}
+	return resp, nil
+}
+
+// getJSON decodes a response into v, or copies it verbatim with -json.
+func (c *client) getJSON(method, path string, body, v interface{}) error {
+	_, err := c.getJSONHeader(method, path, body, v)
+	return err
+}
+
+// getJSONHeader is getJSON, also returning the response's headers.
+func (c *client) getJSONHeader(method, path string, body, v interface{}) (http.Header, error) {
+	resp, err := c.do(method, path, body)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if jsonOutput {
+		_, err := io.Copy(os.Stdout, resp.Body)
+		return resp.Header, err
+	}
+	return resp.Header, json.NewDecoder(resp.Body).Decode(v)
+}
+
+func (c *client) search(args []string) error {
+	flags := flag.NewFlagSet("search", flag.ExitOnError)
+	sort := flags.String("sort", "score", "result order: score, time, -time or title")
+	fuzziness := flags.Int("fuzziness", -1, "edits a word may be from its match, 0 to 2 (default: the daemon's)")
+	prefix := flags.Bool("prefix", false, "match words that start with the query's words")
+	exact := flags.Bool("exact", false, "match words and quoted phrases only as written, without stemming or fuzziness")
+	semantic := flags.Bool("semantic", false, "find pages by meaning rather than keywords")
+	hybrid := flags.Bool("hybrid", false, "rank pages by keywords and meaning together")
+	asOf := flags.String("as-of", "", "search the captures current on this date (2006-01-02) or RFC 3339 time")
+	starred := flags.Bool("starred", false, "only find starred pages")
+	unread := flags.Bool("unread", false, "only find unread pages")
+	changed := flags.Bool("changed", false, "only find pages whose live version has changed")
+	stale := flags.Bool("stale", false, "only find pages their server says may have changed")
+	private := flags.Bool("private", false, "also find pages marked private")
+	federated := flags.Bool("federated", false, "also search the daemon's peer instances")
+	collapse := flags.Bool("collapse", false, "fold near-duplicates of a result into it")
+	groupBy := flags.String("group-by", "", "group results by collection, domain or tag")
+	flags.Parse(args)
+	if flags.NArg() == 0 {
+		return fmt.Errorf("search needs a query")
+	}
+	var results []searchResult
+	query := url.Values{"q": {strings.Join(flags.Args(), " ")}, "sort": {*sort}}
+	if *fuzziness >= 0 {
+		query.Set("fuzziness", fmt.Sprint(*fuzziness))
+	}
+	if *prefix {
+		query.Set("prefix", "1")
+	}
+	if *exact {
+		query.Set("exact", "1")
+	}
+	if *asOf != "" {
+		query.Set("as_of", *asOf)
+	}
+	if *starred {
+		query.Set("starred", "1")
+	}
+	if *unread {
+		query.Set("unread", "1")
+	}
+	if *changed {
+		query.Set("changed", "1")
+	}
+	if *stale {
+		query.Set("stale", "1")
+	}
+	if *private {
+		query.Set("include_private", "1")
+	}
+	if *federated {
+		query.Set("federated", "1")
+	}
+	if *collapse {
+		query.Set("collapse", "1")
+	}
+	// The tables only show these, so the daemon needn't build snippets
+	if !jsonOutput {
+		query.Set("fields", "score,time,title,url,instance")
+	}
+	path := "/search"
+	if *semantic || *hybrid {
+		path = "/search/semantic"
+	}
+	if *hybrid {
+		query.Set("hybrid", "1")
+	}
+	if *groupBy != "" {
+		query.Set("group_by", *groupBy)
+		return c.groupedSearch(path, query)
+	}
+	header, err := c.getJSONHeader(http.MethodGet, path+"?"+query.Encode(), nil, &results)
+	if err != nil || jsonOutput {
+		return err
+	}
+	if corrected := header.Get("X-Corrected-Query"); corrected != "" {
+		fmt.Fprintf(os.Stderr, "Nothing found; showing results for %q\n", corrected)
+	}
+	if suggestions := header.Values("X-Did-You-Mean"); len(suggestions) > 0 {
+		fmt.Fprintf(os.Stderr, "Did you mean: %s\n", strings.Join(suggestions, ", "))
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	if *federated {
+		fmt.Fprintln(tw, "INSTANCE\tSAVED\tTITLE\tURL")
+		for _, result := range results {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", result.Instance, formatTime(result.Time), truncate(result.Title, 60), result.URL)
+		}
+		return tw.Flush()
+	}
+	fmt.Fprintln(tw, "SCORE\tSAVED\tTITLE\tURL")
+	for _, result := range results {
+		fmt.Fprintf(tw, "%.2f\t%s\t%s\t%s\n", result.Score, formatTime(result.Time), truncate(result.Title, 60), result.URL)
+	}
+	return tw.Flush()
+}
+
+// searchGroup is a bucket of a grouped search's results.
+type searchGroup struct {
+	Key   string         `json:"key"`
+	Count int            `json:"count"`
+	Hits  []searchResult `json:"hits"`
+}
+
+func (c *client) groupedSearch(path string, query url.Values) error {
+	var groups []searchGroup
+	if err := c.getJSON(http.MethodGet, path+"?"+query.Encode(), nil, &groups); err != nil || jsonOutput {
+		return err
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	for i, group := range groups {
+		if i > 0 {
+			fmt.Fprintln(tw)
+		}
+		key := group.Key
+		if key == "" {
+			key = "(none)"
+		}
+		fmt.Fprintf(tw, "%s (%d)\n", key, group.Count)
+		for _, result := range group.Hits {
+			fmt.Fprintf(tw, "  %.2f\t%s\t%s\t%s\n", result.Score, formatTime(result.Time), truncate(result.Title, 60), result.URL)
+		}
+	}
+	return tw.Flush()
+}
+
+func (c *client) add(args []string) error {
+	flags := flag.NewFlagSet("add", flag.ExitOnError)
+	render := flags.Bool("render", false, "store the page after its JavaScript has run")
+	screenshot := flags.Bool("screenshot", false, "also store a full-page screenshot")
+	assets := flags.Bool("assets", false, "also store the page's images, stylesheets and fonts")
+	keepDays := flags.Int("keep-days", 0, "remove the page after this many days unless starred")
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		return fmt.Errorf("add needs exactly one URL or PDF file")
+	}
+	var request interface{} = map[string]interface{}{"url": flags.Arg(0), "render": *render, "screenshot": *screenshot, "assets": *assets, "keepDays": *keepDays}
+	path := "/archive"
+	file := ""
+	if strings.EqualFold(filepath.Ext(flags.Arg(0)), ".pdf") {
+		if data, err := ioutil.ReadFile(flags.Arg(0)); err == nil {
+			request = upload{contentType: "application/pdf", data: data}
+			path += "?filename=" + url.QueryEscape(filepath.Base(flags.Arg(0))) + "&keepDays=" + strconv.Itoa(*keepDays)
+			file, _ = filepath.Abs(flags.Arg(0))
+		}
+	}
+	var p page
+	err := c.getJSON(http.MethodPost, path, request, &p)
+	if unreachable(err) {
+		return c.spoolCapture(path, request, file, flags.Arg(0), err)
+	}
+	if err != nil || jsonOutput {
+		return err
+	}
+	fmt.Printf("Archived %s as %s\n", p.URL, p.ID)
+	return nil
+}
+
+func (c *client) ask(args []string) error {
+	flags := flag.NewFlagSet("ask", flag.ExitOnError)
+	passages := flags.Int("passages", 0, "how many pages to answer from (default: the daemon's)")
+	flags.Parse(args)
+	if flags.NArg() == 0 {
+		return fmt.Errorf("ask needs a question")
+	}
+	request := map[string]interface{}{"question": strings.Join(flags.Args(), " "), "passages": *passages}
+	var answer struct {
+		Answer   string `json:"answer"`
+		Passages []struct {
+			N     int       `json:"n"`
+			URL   string    `json:"url"`
+			Title string    `json:"title"`
+			Time  time.Time `json:"time"`
+			Cited bool      `json:"cited"`
+		} `json:"passages"`
+	}
+	if err := c.getJSON(http.MethodPost, "/ask", request, &answer); err != nil || jsonOutput {
+		return err
+	}
+	if answer.Answer == "" {
+		fmt.Println("No archived pages match the question.")
+		return nil
+	}
+	fmt.Printf("%s\n\n", answer.Answer)
+	for _, p := range answer.Passages {
+		if p.Cited {
+			fmt.Printf("[%d] %s, %s\n    %s\n", p.N, truncate(p.Title, 60), formatTime(p.Time), p.URL)
+		}
+	}
+	return nil
+}
+
+func (c *client) related(args []string) error {
+	flags := flag.NewFlagSet("related", flag.ExitOnError)
+	limit := flags.Int("limit", 10, "how many pages to list, at most 20")
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		return fmt.Errorf("related needs exactly one page ID")
+	}
+	var results []searchResult
+	path := fmt.Sprintf("/pages/%s/related?limit=%d", url.PathEscape(flags.Arg(0)), *limit)
+	if err := c.getJSON(http.MethodGet, path, nil, &results); err != nil || jsonOutput {
+		return err
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "SCORE\tSAVED\tTITLE\tURL")
+	for _, result := range results {
+		fmt.Fprintf(tw, "%.2f\t%s\t%s\t%s\n", result.Score, formatTime(result.Time), truncate(result.Title, 60), result.URL)
+	}
+	return tw.Flush()
+}
+
+func (c *client) history(args []string) error {
+	flags := flag.NewFlagSet("history", flag.ExitOnError)
+	limit := flags.Int("limit", 50, "how many searches to list, at most 1000")
+	stats := flags.Bool("stats", false, "show the queries searched most and those that found nothing")
+	days := flags.Int("days", 30, "how many days back -stats looks")
+	clear := flags.Bool("clear", false, "forget your searches")
+	flags.Parse(args)
+	if *clear {
+		resp, err := c.do(http.MethodDelete, "/history/searches", nil)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if !jsonOutput {
+			fmt.Println("Search history cleared")
+		}
+		return nil
+	}
+
+	type queryStats struct {
+		Query string    `json:"query"`
+		Count int       `json:"count"`
+		Hits  int       `json:"hits"`
+		Last  time.Time `json:"last"`
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	if *stats {
+		var summary struct {
+			Searches    int          `json:"searches"`
+			TopQueries  []queryStats `json:"topQueries"`
+			ZeroResults []queryStats `json:"zeroResults"`
+		}
+		if err := c.getJSON(http.MethodGet, fmt.Sprintf("/history/searches/stats?days=%d", *days), nil, &summary); err != nil || jsonOutput {
+			return err
+		}
+		fmt.Fprintf(tw, "%d searches in the last %d days\n\nMost searched:\nSEARCHES\tHITS\tLAST\tQUERY\n", summary.Searches, *days)
+		for _, q := range summary.TopQueries {
+			fmt.Fprintf(tw, "%d\t%d\t%s\t%s\n", q.Count, q.Hits, formatTime(q.Last), q.Query)
+		}
+		if len(summary.ZeroResults) > 0 {
+			fmt.Fprintln(tw, "\nFound nothing:\nSEARCHES\tLAST\tQUERY")
+			for _, q := range summary.ZeroResults {
+				fmt.Fprintf(tw, "%d\t%s\t%s\n", q.Count, formatTime(q.Last), q.Query)
+			}
+		}
+		return tw.Flush()
+	}
+
+	query := url.Values{"limit": {fmt.Sprint(*limit)}}
+	if flags.NArg() > 0 {
+		query.Set("q", strings.Join(flags.Args(), " "))
+	}
+	var history struct {
+		Searches []struct {
+			Query string    `json:"query"`
+			Time  time.Time `json:"time"`
+			Hits  int       `json:"hits"`
+		} `json:"searches"`
+	}
+	if err := c.getJSON(http.MethodGet, "/history/searches?"+query.Encode(), nil, &history); err != nil || jsonOutput {
+		return err
+	}
+	fmt.Fprintln(tw, "SEARCHED\tHITS\tQUERY")
+	for _, s := range history.Searches {
+		fmt.Fprintf(tw, "%s\t%d\t%s\n", formatTime(s.Time), s.Hits, s.Query)
+	}
+	return tw.Flush()
+}
+
+func (c *client) bookmark(args []string) error {
+	flags := flag.NewFlagSet("bookmark", flag.ExitOnError)
+	title := flags.String("title", "", "title to save the URL under, by default the URL")
+	tags := flags.String("tags", "", "comma-separated tags for the bookmark")
+	note := flags.String("note", "", "note to save with the URL")
+	capture := flags.Bool("capture", false, "capture the bookmark with the given ID instead")
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		return fmt.Errorf("bookmark needs exactly one URL, or ID with -capture")
+	}
+	if *capture {
+		var p page
+		if err := c.getJSON(http.MethodPost, "/bookmarks/"+url.PathEscape(flags.Arg(0))+"/capture", nil, &p); err != nil || jsonOutput {
+			return err
+		}
+		fmt.Printf("Archived %s as %s\n", p.URL, p.ID)
+		return nil
+	}
+	request := map[string]interface{}{"url": flags.Arg(0), "title": *title, "note": *note}
+	if *tags != "" {
+		request["tags"] = strings.Split(*tags, ",")
+	}
+	var p page
+	if err := c.getJSON(http.MethodPost, "/bookmarks", request, &p); err != nil || jsonOutput {
+		return err
+	}
+	fmt.Printf("Bookmarked %s as %s\n", p.URL, p.ID)
+	return nil
+}
+
+// spoolCapture keeps a capture the daemon couldn't be reached for, to be
+// sent by a later command.
+func (c *client) spoolCapture(path string, request interface{}, file, what string, cause error) error {
+	if file != "" {
+		request = nil
+	}
+	if err := c.spool(path, request, file); err != nil {
+		return fmt.Errorf("%v; spooling failed too: %v", cause, err)
+	}
+	fmt.Fprintf(os.Stderr, "Daemon unreachable; spooled %s to send later\n", what)
+	return nil
+}
+
+func (c *client) list(args []string) error {
+	flags := flag.NewFlagSet("ls", flag.ExitOnError)
+	limit := flags.Int("limit", 50, "maximum number of pages")
+	offset := flags.Int("offset", 0, "number of pages to skip")
+	starred := flags.Bool("starred", false, "only list starred pages")
+	unread := flags.Bool("unread", false, "only list unread pages")
+	changed := flags.Bool("changed", false, "only list pages whose live version has changed")
+	bookmarks := flags.Bool("bookmarks", false, "only list bookmarks not yet captured")
+	flags.Parse(args)
+
+	var list pageList
+	query := url.Values{"limit": {fmt.Sprint(*limit)}, "offset": {fmt.Sprint(*offset)}}
+	if *starred {
+		query.Set("starred", "1")
+	}
+	if *unread {
+		query.Set("unread", "1")
+	}
+	if *changed {
+		query.Set("changed", "1")
+	}
+	if *bookmarks {
+		query.Set("bookmarks", "1")
+	}
+	if err := c.getJSON(http.MethodGet, "/pages?"+query.Encode(), nil, &list); err != nil || jsonOutput {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tSAVED\tWORDS\tTITLE")
+	for _, p := range list.Pages {
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%s\n", p.ID, formatTime(p.Timestamp), p.WordCount, truncate(p.Title, 60))
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	if shown := *offset + len(list.Pages); shown < list.Total {
+		fmt.Fprintf(os.Stderr, "%d of %d pages shown\n", shown, list.Total)
+	}
+	return nil
+}
+
+func (c *client) remove(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("rm needs at least one page ID")
+	}
+	for _, id := range args {
+		resp, err := c.do(http.MethodDelete, "/pages/"+url.PathEscape(id), nil)
+		if err != nil {
+			return fmt.Errorf("%s: %v", id, err)
+		}
+		resp.Body.Close()
+		if !jsonOutput {
+			fmt.Printf("Deleted %s\n", id)
+		}
+	}
+	return nil
+}
+
+type trashedPage struct {
+	page
+	DeletedAt time.Time `json:"deletedAt"`
+	PurgeAt   time.Time `json:"purgeAt"`
+}
+
+func (c *client) trash(args []string) error {
+	if len(args) == 0 {
+		var pages []trashedPage
+		if err := c.getJSON(http.MethodGet, "/trash", nil, &pages); err != nil || jsonOutput {
+			return err
+		}
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "ID\tDELETED\tPURGED\tTITLE")
+		for _, p := range pages {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", p.ID, formatTime(p.DeletedAt), formatTime(p.PurgeAt), truncate(p.Title, 60))
+		}
+		return tw.Flush()
+	}
+	if args[0] != "restore" || len(args) < 2 {
+		return fmt.Errorf("trash restore needs at least one page ID")
+	}
+	for _, id := range args[1:] {
+		var restored page
+		if err := c.getJSON(http.MethodPost, "/trash/"+url.PathEscape(id)+"/restore", nil, &restored); err != nil {
+			return fmt.Errorf("%s: %v", id, err)
+		}
+		if !jsonOutput {
+			fmt.Printf("Restored %s\n", id)
+		}
+	}
+	return nil
+}
+
+func (c *client) export(args []string) error {
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	format := flags.String("format", "", "bundle format: tar.gz, zip, karakeep or warc")
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		return fmt.Errorf("export needs an output file, or - for stdout")
+	}
+
+	resp, err := c.send(c.stream, http.MethodGet, "/export?"+url.Values{"format": {*format}}.Encode(), nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	name := flags.Arg(0)
+	if name == "-" {
+		_, err = io.Copy(os.Stdout, resp.Body)
+		return err
+	}
+	// Write next to the destination and rename once complete, so an
+	// interrupted download never leaves a truncated bundle in its place
+	f, err := ioutil.TempFile(filepath.Dir(name), "."+filepath.Base(name)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if err := f.Chmod(0644); err != nil {
+		f.Close()
+		return err
+	}
+	if _, err := io.Copy(f, resp.Body); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), name)
+}
+
+// deadLink is a page in the daemon's link rot report.
+type deadLink struct {
+	ID        string     `json:"id"`
+	URL       string     `json:"url"`
+	Status    int        `json:"status"`
+	Error     string     `json:"error"`
+	DeadSince *time.Time `json:"deadSince"`
+}
+
+func (c *client) checkLinks(args []string) error {
+	flags := flag.NewFlagSet("checklinks", flag.ExitOnError)
+	limit := flags.Int("limit", 0, "most URLs to check or list (default: the daemon's)")
+	report := flags.Bool("report", false, "list the pages whose URLs are gone instead of checking")
+	flags.Parse(args)
+	path := "/deadlinks"
+	if *limit > 0 {
+		path += "?limit=" + strconv.Itoa(*limit)
+	}
+	if !*report {
+		var started struct {
+			Pages int `json:"pages"`
+		}
+		if err := c.getJSON(http.MethodPost, path, nil, &started); err != nil || jsonOutput {
+			return err
+		}
+		fmt.Printf("Checking %d URLs\n", started.Pages)
+		return nil
+	}
+
+	var dead []deadLink
+	if err := c.getJSON(http.MethodGet, path, nil, &dead); err != nil || jsonOutput {
+		return err
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tDEAD SINCE\tSTATUS\tURL")
+	for _, link := range dead {
+		status := strconv.Itoa(link.Status)
+		if link.Error != "" {
+			status = "unreachable"
+		}
+		since := ""
+		if link.DeadSince != nil {
+			since = formatTime(*link.DeadSince)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", link.ID, since, status, link.URL)
+	}
+	return tw.Flush()
+}
+
+// feed is a feed the daemon archives the new entries of.
+type feed struct {
+	ID              string    `json:"id"`
+	URL             string    `json:"url"`
+	Title           string    `json:"title"`
+	IntervalMinutes int       `json:"intervalMinutes"`
+	LastPolled      time.Time `json:"lastPolled"`
+	LastError       string    `json:"lastError"`
+	Archived        int       `json:"archived"`
+}
+
+func (c *client) feeds(args []string) error {
+	if len(args) == 0 {
+		var feeds []feed
+		if err := c.getJSON(http.MethodGet, "/feeds", nil, &feeds); err != nil || jsonOutput {
+			return err
+		}
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "ID\tPOLLED\tARCHIVED\tTITLE\tURL")
+		for _, f := range feeds {
+			polled := "never"
+			if !f.LastPolled.IsZero() {
+				polled = formatTime(f.LastPolled)
+			}
+			if f.LastError != "" {
+				polled += " (failed)"
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%d\t%s\t%s\n", f.ID, polled, f.Archived, truncate(f.Title, 40), f.URL)
+		}
+		return tw.Flush()
+	}
+
+	switch args[0] {
+	case "add":
+		flags := flag.NewFlagSet("feeds add", flag.ExitOnError)
+		tags := flags.String("tags", "", "comma-separated tags for the archived entries")
+		interval := flags.Int("interval", 0, "minutes between polls (default: the daemon's)")
+		flags.Parse(args[1:])
+		if flags.NArg() != 1 {
+			return fmt.Errorf("feeds add needs a feed URL")
+		}
+		request := map[string]interface{}{"url": flags.Arg(0)}
+		if *tags != "" {
+			request["tags"] = strings.Split(*tags, ",")
+		}
+		if *interval > 0 {
+			request["intervalMinutes"] = *interval
+		}
+		var added feed
+		if err := c.getJSON(http.MethodPost, "/feeds", request, &added); err != nil || jsonOutput {
+			return err
+		}
+		fmt.Printf("Added %s (%s), polled every %d minutes\n", added.Title, added.ID, added.IntervalMinutes)
+		return nil
+	case "rm":
+		if len(args) != 2 {
+			return fmt.Errorf("feeds rm needs a feed ID")
+		}
+		resp, err := c.do(http.MethodDelete, "/feeds/"+url.PathEscape(args[1]), nil)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if !jsonOutput {
+			fmt.Printf("Deleted %s\n", args[1])
+		}
+		return nil
+	case "poll":
+		if len(args) != 2 {
+			return fmt.Errorf("feeds poll needs a feed ID")
+		}
+		var result struct {
+			Archived int  `json:"archived"`
+			Feed     feed `json:"feed"`
+		}
+		if err := c.getJSON(http.MethodPost, "/feeds/"+url.PathEscape(args[1])+"/poll", nil, &result); err != nil || jsonOutput {
+			return err
+		}
+		fmt.Printf("Archived %d new entries\n", result.Archived)
+		if result.Feed.LastError != "" {
+			fmt.Printf("Last error: %s\n", result.Feed.LastError)
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown feeds command %q", args[0])
+}
+
+type siteCrawl struct {
+	ID         string `json:"id"`
+	Site       string `json:"site"`
+	Status     string `json:"status"`
+	Found      int    `json:"found"`
+	Archived   int    `json:"archived"`
+	Duplicates int    `json:"duplicates"`
+	Blocked    int    `json:"blocked"`
+	Skipped    int    `json:"skipped"`
+	Failed     int    `json:"failed"`
+	LastError  string `json:"lastError"`
+}
+
+func (crawl siteCrawl) summary() string {
+	return fmt.Sprintf("%s: %d of %d pages archived, %d already archived, %d blocked by robots.txt, %d skipped, %d failed",
+		crawl.Status, crawl.Archived, crawl.Found, crawl.Duplicates, crawl.Blocked, crawl.Skipped, crawl.Failed)
+}
+
+func (c *client) site(args []string) error {
+	if len(args) == 0 {
+		var crawls []siteCrawl
+		if err := c.getJSON(http.MethodGet, "/archive/site", nil, &crawls); err != nil || jsonOutput {
+			return err
+		}
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "ID\tSTATUS\tARCHIVED\tFOUND\tSITE")
+		for _, crawl := range crawls {
+			fmt.Fprintf(tw, "%s\t%s\t%d\t%d\t%s\n", crawl.ID, crawl.Status, crawl.Archived, crawl.Found, crawl.Site)
+		}
+		return tw.Flush()
+	}
+	if args[0] == "rm" {
+		if len(args) != 2 {
+			return fmt.Errorf("site rm needs a crawl ID")
+		}
+		resp, err := c.do(http.MethodDelete, "/archive/site/"+url.PathEscape(args[1]), nil)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if !jsonOutput {
+			fmt.Printf("Cancelled %s\n", args[1])
+		}
+		return nil
+	}
+
+	flags := flag.NewFlagSet("site", flag.ExitOnError)
+	links := flags.Bool("links", false, "follow the site's links from the given page instead of reading its sitemaps")
+	tags := flags.String("tags", "", "comma-separated tags for the archived pages")
+	include := flags.String("include", "", "only archive URLs matching this regular expression")
+	exclude := flags.String("exclude", "", "never archive URLs matching this regular expression")
+	concurrency := flags.Int("concurrency", 0, "pages fetched at once (default: the daemon's)")
+	maxPages := flags.Int("max-pages", 0, "most pages to archive (default: the daemon's)")
+	depth := flags.Int("depth", -1, "how deeply sitemap indexes or links are followed (default: the daemon's)")
+	delay := flags.Float64("delay", -1, "seconds between requests to the site (default: the daemon's)")
+	wait := flags.Bool("wait", false, "wait for the crawl to finish, showing its progress")
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		return fmt.Errorf("site needs a domain or sitemap URL")
+	}
+	request := map[string]interface{}{"site": flags.Arg(0)}
+	if *links {
+		request["mode"] = "links"
+	}
+	if *include != "" {
+		request["include"] = []string{*include}
+	}
+	if *exclude != "" {
+		request["exclude"] = []string{*exclude}
+	}
+	if *concurrency > 0 {
+		request["concurrency"] = *concurrency
+	}
+	if *tags != "" {
+		request["tags"] = strings.Split(*tags, ",")
+	}
+	if *maxPages > 0 {
+		request["maxPages"] = *maxPages
+	}
+	if *depth >= 0 {
+		request["maxDepth"] = *depth
+	}
+	if *delay >= 0 {
+		request["delaySeconds"] = *delay
+	}
+	var crawl siteCrawl
+	if err := c.getJSON(http.MethodPost, "/archive/site", request, &crawl); err != nil || jsonOutput {
+		return err
+	}
+	if !*wait {
+		fmt.Printf("Crawling %s as %s\n", crawl.Site, crawl.ID)
+		return nil
+	}
+	for crawl.Status == "running" {
+		time.Sleep(2 * time.Second)
+		if err := c.getJSON(http.MethodGet, "/archive/site/"+url.PathEscape(crawl.ID), nil, &crawl); err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "\r%s", crawl.summary())
+	}
+	fmt.Fprintln(os.Stderr)
+	if crawl.LastError != "" {
+		fmt.Printf("Last error: %s\n", crawl.LastError)
+	}
+	if crawl.Status == "failed" {
+		return fmt.Errorf("crawling %s failed", crawl.Site)
+	}
+	return nil
+}
+
+func (c *client) reindex(args []string) error {
+	flags := flag.NewFlagSet("reindex", flag.ExitOnError)
+	wait := flags.Bool("wait", false, "wait for the reindex to finish, showing its progress")
+	flags.Parse(args)
+	return c.startJob("/reindex", "Reindex", *wait)
+}
+
+func (c *client) reextract(args []string) error {
+	flags := flag.NewFlagSet("reextract", flag.ExitOnError)
+	since := flags.String("since", "", "only pages extracted before this extractor version or date")
+	wait := flags.Bool("wait", false, "wait for the re-extraction to finish, showing its progress")
+	flags.Parse(args)
+	path := "/reextract"
+	if *since != "" {
+		path += "?" + url.Values{"since": {*since}}.Encode()
+	}
+	return c.startJob(path, "Re-extraction", *wait)
+}
+
+type job struct {
+	ID       string          `json:"id"`
+	Kind     string          `json:"kind"`
+	Status   string          `json:"status"`
+	Created  time.Time       `json:"created"`
+	Finished *time.Time      `json:"finished"`
+	Done     int             `json:"done"`
+	Total    int             `json:"total"`
+	Runs     int             `json:"runs"`
+	Error    string          `json:"error"`
+	Result   json.RawMessage `json:"result"`
+}
+
+func (j job) progress() string {
+	switch {
+	case j.Total > 0:
+		return fmt.Sprintf("%d/%d", j.Done, j.Total)
+	case j.Done > 0:
+		return strconv.Itoa(j.Done)
+	}
+	return "-"
+}
+
+// startJob posts to an endpoint that starts a job, and waits for the job
+// to finish if asked to.
+func (c *client) startJob(path, name string, wait bool) error {
+	var started job
+	if err := c.getJSON(http.MethodPost, path, nil, &started); err != nil || jsonOutput {
+		return err
+	}
+	if !wait {
+		fmt.Printf("%s started as job %s\n", name, started.ID)
+		return nil
+	}
+	return c.waitJob(started.ID)
+}
+
+// waitJob follows a job until it finishes, showing its progress.
+func (c *client) waitJob(id string) error {
+	var j job
+	for j.Status == "" || j.Status == "running" {
+		if j.Status != "" {
+			time.Sleep(2 * time.Second)
+		}
+		if err := c.getJSON(http.MethodGet, "/jobs/"+url.PathEscape(id), nil, &j); err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "\r%s: %s", j.Status, j.progress())
+	}
+	fmt.Fprintln(os.Stderr)
+	if j.Status == "failed" {
+		return fmt.Errorf("%s job %s failed: %s", j.Kind, j.ID, j.Error)
+	}
+	return nil
+}
+
+func (c *client) jobs(args []string) error {
+	if len(args) > 0 && args[0] == "rm" {
+		if len(args) != 2 {
+			return fmt.Errorf("jobs rm needs a job ID")
+		}
+		resp, err := c.do(http.MethodDelete, "/jobs/"+url.PathEscape(args[1]), nil)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if !jsonOutput {
+			fmt.Printf("Cancelled %s\n", args[1])
+		}
+		return nil
+	}
+
+	flags := flag.NewFlagSet("jobs", flag.ExitOnError)
+	kind := flags.String("kind", "", "only list jobs of this kind: archive, import, reindex, reextract or site")
+	status := flags.String("status", "", "only list jobs in this state: running, done, failed or cancelled")
+	flags.Parse(args)
+	if flags.NArg() == 1 {
+		var j job
+		if err := c.getJSON(http.MethodGet, "/jobs/"+url.PathEscape(flags.Arg(0)), nil, &j); err != nil || jsonOutput {
+			return err
+		}
+		fmt.Printf("%s job %s: %s, %s done, started %s", j.Kind, j.ID, j.Status, j.progress(), formatTime(j.Created))
+		if j.Runs > 1 {
+			fmt.Printf(", run %d times", j.Runs)
+		}
+		fmt.Println()
+		if j.Error != "" {
+			fmt.Printf("Error: %s\n", j.Error)
+		}
+		if len(j.Result) > 0 {
+			fmt.Printf("Result: %s\n", j.Result)
+		}
+		return nil
+	}
+	query := url.Values{}
+	if *kind != "" {
+		query.Set("kind", *kind)
+	}
+	if *status != "" {
+		query.Set("status", *status)
+	}
+	var jobs []job
+	if err := c.getJSON(http.MethodGet, "/jobs?"+query.Encode(), nil, &jobs); err != nil || jsonOutput {
+		return err
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tKIND\tSTATUS\tPROGRESS\tCREATED")
+	for _, j := range jobs {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", j.ID, j.Kind, j.Status, j.progress(), formatTime(j.Created))
+	}
+	return tw.Flush()
+}
+
+func (c *client) pull(args []string) error {
+	flags := flag.NewFlagSet("pull", flag.ExitOnError)
+	at := flags.String("at", "", "prefer snapshots closest to this date (2006-01-02) or RFC 3339 time")
+	flags.Parse(args)
+	var urls []string
+	for _, arg := range flags.Args() {
+		if arg != "-" {
+			urls = append(urls, arg)
+			continue
+		}
+		scanner := bufio.NewScanner(os.Stdin)
+		for scanner.Scan() {
+			if line := strings.TrimSpace(scanner.Text()); line != "" && !strings.HasPrefix(line, "#") {
+				urls = append(urls, line)
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			return err
+		}
+	}
+	if len(urls) == 0 {
+		return fmt.Errorf("pull needs URLs, or - to read them from stdin")
+	}
+	// Each URL takes several requests to the archives, so don't time out
+	resp, err := c.send(c.stream, http.MethodPost, "/import/memento", map[string]interface{}{"urls": urls, "datetime": *at})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if jsonOutput {
+		_, err := io.Copy(os.Stdout, resp.Body)
+		return err
+	}
+	var summary struct {
+		Imported   int `json:"imported"`
+		Duplicates int `json:"duplicates"`
+		Failed     int `json:"failed"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&summary); err != nil {
+		return err
+	}
+	fmt.Printf("Imported %d pages (%d already archived, %d not found or failed)\n", summary.Imported, summary.Duplicates, summary.Failed)
+	return nil
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.Local().Format("2006-01-02 15:04")
+}
+
+func truncate(s string, n int) string {
+	runes := []rune(strings.Join(strings.Fields(s), " "))
+	if len(runes) <= n {
+		return string(runes)
+	}
+	return string(runes[:n-1]) + "…"
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// spooledCapture is a capture made while the daemon was unreachable,
+// waiting to be sent to Server.
+type spooledCapture struct {
+	Server string          `json:"server"`
+	Path   string          `json:"path"`
+	Body   json.RawMessage `json:"body,omitempty"`
+	// File is a local PDF, uploaded as the body when the capture is sent.
+	File      string    `json:"file,omitempty"`
+	SpooledAt time.Time `json:"spooledAt"`
+}
+
+// spoolPath is the file captures wait in, one JSON object per line:
+// $MEMENTO_SPOOL, or spool.jsonl in the user's memento config directory.
+func spoolPath() (string, error) {
+	if path := os.Getenv("MEMENTO_SPOOL"); path != "" {
+		return path, nil
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "memento", "spool.jsonl"), nil
+}
+
+// unreachable reports whether a request failed without reaching the
+// daemon, as opposed to the daemon refusing it.
+func unreachable(err error) bool {
+	var urlErr *url.Error
+	return errors.As(err, &urlErr)
+}
+
+// spool appends a capture to the spool.
+func (c *client) spool(path string, body interface{}, file string) error {
+	entry := spooledCapture{Server: c.server, Path: path, File: file, SpooledAt: time.Now()}
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		entry.Body = data
+	}
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	spoolFile, err := spoolPath()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(spoolFile), 0700); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(spoolFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func readSpool(path string) ([]spooledCapture, error) {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var entries []spooledCapture
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		var entry spooledCapture
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return nil, fmt.Errorf("reading %s: %v", path, err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, scanner.Err()
+}
+
+// sendSpooled sends a spooled capture, returning the archived page.
+func (c *client) sendSpooled(entry spooledCapture) (page, error) {
+	var body interface{}
+	if entry.File != "" {
+		data, err := ioutil.ReadFile(entry.File)
+		if err != nil {
+			return page{}, err
+		}
+		body = upload{contentType: "application/pdf", data: data}
+	} else if entry.Body != nil {
+		body = entry.Body
+	}
+	var p page
+	resp, err := c.do(http.MethodPost, entry.Path, body)
+	if err != nil {
+		return p, err
+	}
+	defer resp.Body.Close()
+	return p, json.NewDecoder(resp.Body).Decode(&p)
+}
+
+// flushSpool sends the spooled captures meant for this daemon, stopping at
+// the first it can't reach, and returns how many are left. The spool is
+// moved aside while it is flushed, so captures spooled meanwhile by other
+// commands are kept.
+func (c *client) flushSpool() (int, error) {
+	spoolFile, err := spoolPath()
+	if err != nil {
+		return 0, err
+	}
+	if _, err := os.Stat(spoolFile); os.IsNotExist(err) {
+		return 0, nil
+	}
+	flushing := spoolFile + "." + strconv.Itoa(os.Getpid())
+	if err := os.Rename(spoolFile, flushing); err != nil {
+		return 0, err
+	}
+	entries, err := readSpool(flushing)
+	if err != nil {
+		os.Rename(flushing, spoolFile)
+		return 0, err
+	}
+
+	var kept []spooledCapture
+	down := false
+	for _, entry := range entries {
+		if entry.Server != c.server || down {
+			kept = append(kept, entry)
+			continue
+		}
+		p, err := c.sendSpooled(entry)
+		switch {
+		case unreachable(err):
+			down = true
+			kept = append(kept, entry)
+		case err != nil:
+			// The daemon refused it, so sending it again won't help
+			fmt.Fprintf(os.Stderr, "memento: dropping capture spooled at %s: %v\n", entry.SpooledAt.Format(time.RFC3339), err)
+		default:
+			fmt.Fprintf(os.Stderr, "Archived %s as %s (spooled at %s)\n", p.URL, p.ID, entry.SpooledAt.Format(time.RFC3339))
+		}
+	}
+	left := 0
+	if len(kept) > 0 {
+		var lines bytes.Buffer
+		for _, entry := range kept {
+			line, _ := json.Marshal(entry)
+			lines.Write(append(line, '\n'))
+			if entry.Server == c.server {
+				left++
+			}
+		}
+		f, err := os.OpenFile(spoolFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+		if err != nil {
+			return left, err
+		}
+		_, err = f.Write(lines.Bytes())
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return left, err
+		}
+	}
+	return left, os.Remove(flushing)
+}
+
+// flush sends spooled captures, waiting for the daemon with -watch, or
+// lists them with -list.
+func (c *client) flush(args []string) error {
+	flags := flag.NewFlagSet("flush", flag.ExitOnError)
+	watch := flags.Duration("watch", 0, "keep retrying at this interval until every capture is sent")
+	list := flags.Bool("list", false, "list the spooled captures instead of sending them")
+	flags.Parse(args)
+	if *list {
+		spoolFile, err := spoolPath()
+		if err != nil {
+			return err
+		}
+		entries, err := readSpool(spoolFile)
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			what := string(entry.Body)
+			if entry.File != "" {
+				what = entry.File
+			}
+			fmt.Printf("%s\t%s\t%s\n", entry.SpooledAt.Local().Format("2006-01-02 15:04"), entry.Server, what)
+		}
+		return nil
+	}
+	for {
+		left, err := c.flushSpool()
+		if err != nil {
+			return err
+		}
+		if left == 0 {
+			return nil
+		}
+		if *watch <= 0 {
+			return fmt.Errorf("daemon unreachable; %d captures still spooled", left)
+		}
+		time.Sleep(*watch)
+	}
+}
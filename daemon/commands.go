@@ -0,0 +1,233 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"time"
+)
+
+func usage() {
+	fmt.Fprintf(os.Stderr, `Usage: daemon [command] [args]
+
+Without a command the daemon indexes pages and serves the HTTP API.
+
+Commands:
+  export [-format tar.gz|zip|karakeep|warc] <file|->
+                        Write all pages and a manifest to an export bundle
+  hold [-note TEXT] <file> <id...>
+                        Write the given pages to a zip with a signed manifest
+                        of their hashes, as evidence of what they said
+  verify-hold <file>    Check the signature and hashes of a hold bundle
+  import <bundle|dir>   Import pages from an export bundle, WARC file or pages
+                        directory
+  import-karakeep <file>
+                        Import bookmarks from a Karakeep/Hoarder JSON export
+  import-readlater <pocket|instapaper|raindrop> <file>
+                        Import links from a read-later service export
+  import-zotero <dir>   Import webpage snapshots from a Zotero RDF export
+  sanitize              Apply capture.contentPolicy to pages already stored
+  compress [-d]         Gzip the HTML and markdown of pages stored
+                        uncompressed; -d decompresses them instead
+  assign-owner <user> [id...]
+                        Give the given pages, or every page without an
+                        owner, to a user
+  gc [-n]               Remove files in the pages directory that no page
+                        refers to; -n only lists them
+  git-export            Commit page markdown to the gitExport.dir repository
+  capture-assets [id...]
+                        Store the images, stylesheets and fonts of the given
+                        pages, or of every page without them
+  reextract [-since VERSION|DATE]
+                        Extract the text and titles of pages again from their
+                        stored HTML and PDFs, those extracted before an
+                        extractor version or date, or all of them
+  backup [-index] [<repo>]
+                        Store the pages and state files, and the search index
+                        with -index, in a deduplicating backup repository,
+                        backup.repo by default, creating it if needed.
+                        Repositories are directories or s3://bucket/prefix
+  backup-snapshots <repo>
+                        List the snapshots in a backup repository
+  backup-restore [-snapshot ID] <repo> <dir>
+                        Write a snapshot, the latest by default, to an empty
+                        directory
+  backup-prune [-keep N] <repo>
+                        Remove all but the latest N snapshots and the chunks
+                        only they used
+  backup-verify <repo>  Check that every chunk the snapshots use is intact
+`)
+}
+
+func runCommand(name string, args []string) {
+	if err := os.MkdirAll(pagesDir, 0755); err != nil {
+		log.Fatalf("Error creating pages directory: %v", err)
+	}
+
+	switch name {
+	case "export":
+		runExportCommand(args)
+	case "hold":
+		runHoldCommand(args)
+	case "verify-hold":
+		runVerifyHoldCommand(args)
+	case "import":
+		if len(args) != 1 {
+			usage()
+			os.Exit(2)
+		}
+		summary, err := importPath(context.Background(), args[0])
+		if err != nil {
+			log.Fatalf("Import failed: %v", err)
+		}
+		log.Printf("Imported %d pages (%d duplicates, %d renamed, %d failed)", summary.Imported, summary.Duplicates, summary.Renamed, summary.Failed)
+	case "import-karakeep":
+		if len(args) != 1 {
+			usage()
+			os.Exit(2)
+		}
+		data, err := ioutil.ReadFile(args[0])
+		if err != nil {
+			log.Fatalf("Error reading Karakeep export: %v", err)
+		}
+		var export karakeepExport
+		if err := json.Unmarshal(data, &export); err != nil {
+			log.Fatalf("Error parsing Karakeep export: %v", err)
+		}
+		summary, err := importKarakeepBookmarks(context.Background(), export.Bookmarks)
+		if err != nil {
+			log.Fatalf("Karakeep import failed: %v", err)
+		}
+		log.Printf("Imported %d bookmarks (%d duplicates, %d failed)", summary.Imported, summary.Duplicates, summary.Failed)
+	case "import-readlater":
+		if len(args) != 2 {
+			usage()
+			os.Exit(2)
+		}
+		f, err := os.Open(args[1])
+		if err != nil {
+			log.Fatalf("Error opening export: %v", err)
+		}
+		defer f.Close()
+		summary, err := importReadLater(context.Background(), args[0], f)
+		if err != nil {
+			log.Fatalf("Import failed: %v", err)
+		}
+		log.Printf("Imported %d links (%d duplicates, %d failed)", summary.Imported, summary.Duplicates, summary.Failed)
+	case "import-zotero":
+		if len(args) != 1 {
+			usage()
+			os.Exit(2)
+		}
+		count, err := importZoteroExport(args[0])
+		if err != nil {
+			log.Fatalf("Zotero import failed: %v", err)
+		}
+		log.Printf("Imported %d Zotero snapshots", count)
+	case "sanitize":
+		count, err := sanitizeStoredPages()
+		if err != nil {
+			log.Fatalf("Sanitizing pages failed: %v", err)
+		}
+		log.Printf("Sanitized %d pages; they will be reindexed when the daemon next runs", count)
+	case "compress":
+		decompress := len(args) == 1 && args[0] == "-d"
+		if len(args) > 1 || (len(args) == 1 && !decompress) {
+			usage()
+			os.Exit(2)
+		}
+		encoding := encodingGzip
+		if decompress {
+			encoding = ""
+		}
+		count, err := recompressPages(encoding)
+		if err != nil {
+			log.Fatalf("Compressing pages failed after %d pages: %v", count, err)
+		}
+		verb := "Compressed"
+		if decompress {
+			verb = "Decompressed"
+		}
+		log.Printf("%s %d pages", verb, count)
+	case "assign-owner":
+		if len(args) < 1 {
+			usage()
+			os.Exit(2)
+		}
+		count, err := assignOwner(args[0], args[1:])
+		if err != nil {
+			log.Fatalf("Assigning pages failed after %d pages: %v", count, err)
+		}
+		log.Printf("Gave %d pages to %s; they will be reindexed when the daemon next runs", count, args[0])
+	case "gc":
+		dryRun := len(args) == 1 && args[0] == "-n"
+		if len(args) > 1 || (len(args) == 1 && !dryRun) {
+			usage()
+			os.Exit(2)
+		}
+		report, err := collectGarbage(time.Now(), dryRun)
+		if err != nil {
+			log.Fatalf("Garbage collection failed: %v", err)
+		}
+		for _, name := range report.Removed {
+			fmt.Println(name)
+		}
+		verb := "Removed"
+		if dryRun {
+			verb = "Would remove"
+		}
+		log.Printf("%s %d orphaned files, reclaiming %s", verb, len(report.Removed), formatBytes(report.ReclaimedBytes))
+	case "git-export":
+		if config.GitExport.Dir == "" {
+			log.Fatalf("Set gitExport.dir in %s first", configFile)
+		}
+		changes, err := exportToGit(config.GitExport)
+		if err != nil {
+			log.Fatalf("Git export failed: %v", err)
+		}
+		log.Printf("Committed %d changed pages", len(changes))
+	case "capture-assets":
+		ids := args
+		if len(ids) == 0 {
+			pages, err := listPages()
+			if err != nil {
+				log.Fatalf("Error listing pages: %v", err)
+			}
+			for _, page := range pages {
+				if _, err := os.Stat(assetsDir(page.ID)); page.HTMLFilename != "" && os.IsNotExist(err) {
+					ids = append(ids, page.ID)
+				}
+			}
+		}
+		var total int
+		for _, id := range ids {
+			count, err := captureAssets(context.Background(), id)
+			if err != nil {
+				log.Printf("Error storing assets of %s: %v", id, err)
+				continue
+			}
+			total += count
+		}
+		log.Printf("Stored %d assets for %d pages", total, len(ids))
+	case "reextract":
+		runReextractCommand(args)
+	case "backup":
+		runBackupCommand(args)
+	case "backup-snapshots":
+		runBackupSnapshotsCommand(args)
+	case "backup-restore":
+		runBackupRestoreCommand(args)
+	case "backup-prune":
+		runBackupPruneCommand(args)
+	case "backup-verify":
+		runBackupVerifyCommand(args)
+	case "help", "-h", "--help":
+		usage()
+	default:
+		usage()
+		os.Exit(2)
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRunImportCommands(t *testing.T) {
+	withPagesDir(t)
+	dir := writeZoteroExport(t, zoteroRDFSample, map[string]string{
+		"files/2/snapshot.html": "<html><body>engines</body></html>",
+		"files/4/snapshot.html": "<html><body>standalone</body></html>",
+	})
+	runCommand("import-zotero", []string{dir})
+	pages, err := listPages()
+	if err != nil || len(pages) != 2 {
+		t.Fatalf("import-zotero stored %d pages, %v; want 2", len(pages), err)
+	}
+
+	var bundle bytes.Buffer
+	if err := writeExport(&bundle, "zip"); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "export.zip")
+	if err := ioutil.WriteFile(path, bundle.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	withPagesDir(t)
+	runCommand("import", []string{path})
+	if pages, err := listPages(); err != nil || len(pages) != 2 {
+		t.Errorf("import stored %d pages, %v; want 2", len(pages), err)
+	}
+}
+
+func TestRunPageCommands(t *testing.T) {
+	withPagesDir(t)
+	id, err := storePage(PageMetadata{URL: "https://example.com/", Title: "Example"}, "<p>Example</p>", "# Example")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	runCommand("compress", nil)
+	page, err := loadPage(id)
+	if err != nil || !strings.HasSuffix(page.HTMLFilename, gzipExt) || !strings.HasSuffix(page.MDFilename, gzipExt) {
+		t.Fatalf("compressed page = %+v, %v", page, err)
+	}
+	runCommand("compress", []string{"-d"})
+	if page, err = loadPage(id); err != nil || strings.HasSuffix(page.HTMLFilename, gzipExt) {
+		t.Fatalf("decompressed page = %+v, %v", page, err)
+	}
+	if html, err := ioutil.ReadFile(filepath.Join(pagesDir, page.HTMLFilename)); !strings.Contains(string(html), "<p>Example</p>") {
+		t.Errorf("decompressed HTML = %q, %v", html, err)
+	}
+
+	runCommand("assign-owner", []string{"alex"})
+	if page, err = loadPage(id); err != nil || page.Owner != "alex" || page.Indexed {
+		t.Errorf("assigned page = %+v, %v", page, err)
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// encodingGzip is the content encoding of pages whose HTML and markdown are
+// stored gzipped, in files named with gzipExt.
+const (
+	encodingGzip = "gzip"
+	gzipExt      = ".gz"
+)
+
+// contentFileName is the name a new page's HTML or markdown is stored
+// under, compressed if capture.compression asks for it.
+func contentFileName(id, ext string) string {
+	if config.Capture.Compression == encodingGzip {
+		return id + ext + gzipExt
+	}
+	return id + ext
+}
+
+// contentEncoding returns the encoding of a page's content files named by
+// a content file name.
+func contentEncoding(name string) string {
+	if strings.HasSuffix(name, gzipExt) {
+		return encodingGzip
+	}
+	return ""
+}
+
+// readContentFile reads a page's HTML or markdown, decompressing it if it is
+// stored compressed. As when they are written, no content file may be
+// larger than a capture.
+func readContentFile(path string) ([]byte, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil || contentEncoding(path) != encodingGzip {
+		return data, err
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("decompressing %s: %v", path, err)
+	}
+	defer zr.Close()
+	content, err := ioutil.ReadAll(io.LimitReader(zr, maxCaptureBytes+1))
+	if err != nil {
+		return nil, fmt.Errorf("decompressing %s: %v", path, err)
+	}
+	if len(content) > maxCaptureBytes {
+		return nil, errCaptureTooLarge
+	}
+	return content, nil
+}
+
+// writeContentFile writes a page's HTML or markdown, compressed if its name
+// says so.
+func writeContentFile(path string, content []byte) error {
+	if contentEncoding(path) != encodingGzip {
+		return ioutil.WriteFile(path, content, 0644)
+	}
+	var b bytes.Buffer
+	zw := gzip.NewWriter(&b)
+	if _, err := zw.Write(content); err != nil {
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, b.Bytes(), 0644)
+}
+
+// recompressPages rewrites the HTML and markdown of every page stored with
+// another encoding in the given one, "" to decompress, returning how many
+// pages changed. Content hashes and index entries stay as they are, since
+// the content doesn't change.
+func recompressPages(encoding string) (int, error) {
+	indexMu.Lock()
+	defer indexMu.Unlock()
+	pages, err := listPages()
+	if err != nil {
+		return 0, err
+	}
+	count := 0
+	for _, page := range pages {
+		metadata, err := readMetadata(page.ID)
+		if err != nil {
+			continue // deleted since listing
+		}
+		var removed []string
+		changed := false
+		for _, name := range []*string{&metadata.HTMLFilename, &metadata.MDFilename} {
+			if *name == "" || contentEncoding(*name) == encoding {
+				continue
+			}
+			content, err := readContentFile(filepath.Join(pagesDir, *name))
+			if os.IsNotExist(err) {
+				continue
+			}
+			if err != nil {
+				return count, err
+			}
+			renamed := strings.TrimSuffix(*name, gzipExt)
+			if encoding == encodingGzip {
+				renamed += gzipExt
+			}
+			if err := writeContentFile(filepath.Join(pagesDir, renamed), content); err != nil {
+				return count, err
+			}
+			removed = append(removed, *name)
+			*name = renamed
+			changed = true
+		}
+		if !changed {
+			continue
+		}
+		metadata.ContentEncoding = encoding
+		// The old files go once nothing refers to them
+		if err := writeMetadata(page.ID, metadata); err != nil {
+			return count, err
+		}
+		for _, name := range removed {
+			os.Remove(filepath.Join(pagesDir, name))
+		}
+		count++
+	}
+	return count, nil
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func gzipped(t *testing.T, data []byte) []byte {
+	t.Helper()
+	var b bytes.Buffer
+	zw := gzip.NewWriter(&b)
+	zw.Write(data)
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes()
+}
+
+func TestReadContentFile(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		name    string
+		file    string
+		data    []byte
+		want    string
+		wantErr bool
+	}{
+		{"plain", "a.html", []byte("<p>plain</p>"), "<p>plain</p>", false},
+		{"gzip", "a.html.gz", gzipped(t, []byte("<p>gzip</p>")), "<p>gzip</p>", false},
+		{"not gzip", "b.md.gz", []byte("# plain"), "", true},
+		{"too large", "c.md.gz", gzipped(t, make([]byte, maxCaptureBytes+1)), "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(dir, tt.file)
+			if err := ioutil.WriteFile(path, tt.data, 0644); err != nil {
+				t.Fatal(err)
+			}
+			got, err := readContentFile(path)
+			if (err != nil) != tt.wantErr || string(got) != tt.want {
+				t.Errorf("readContentFile() = %q, %v, want %q (error %v)", got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestStoreCompressedPage(t *testing.T) {
+	withPagesDir(t)
+	withIndex(t, nil)
+	saved := config.Capture.Compression
+	config.Capture.Compression = encodingGzip
+	defer func() { config.Capture.Compression = saved }()
+
+	html, markdown := "<html><body><p>Compressed page</p></body></html>", "# Compressed\n\nSome compressed words"
+	id, err := storePage(PageMetadata{URL: "https://example.com/c", Title: "Compressed"}, html, markdown)
+	if err != nil {
+		t.Fatal(err)
+	}
+	page, err := loadPage(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if page.ContentEncoding != encodingGzip || page.HTMLFilename != id+".html.gz" || page.MDFilename != id+".md.gz" {
+		t.Fatalf("metadata = %+v", page.PageMetadata)
+	}
+	if page.ContentHash != contentHash([]byte(markdown)) {
+		t.Errorf("content hash is of the compressed file")
+	}
+	raw, err := ioutil.ReadFile(filepath.Join(pagesDir, page.MDFilename))
+	if err != nil || !bytes.HasPrefix(raw, []byte{0x1f, 0x8b}) {
+		t.Errorf("markdown isn't gzipped: %q, %v", raw, err)
+	}
+
+	if text, err := readPageText(page.PageMetadata); err != nil || !strings.Contains(text, "Some compressed words") {
+		t.Errorf("readPageText() = %q, %v", text, err)
+	}
+	if err := indexPage(context.Background(), id, page.PageMetadata); err != nil {
+		t.Fatal(err)
+	}
+	indexed, _ := loadPage(id)
+	if indexed.WordCount == 0 || indexed.ContentHash != page.ContentHash {
+		t.Errorf("indexed metadata = %+v", indexed.PageMetadata)
+	}
+}
+
+func TestRecompressPages(t *testing.T) {
+	withPagesDir(t)
+	html, markdown := "<p>Stored plainly</p>", "# Plain"
+	id, err := storePage(PageMetadata{URL: "https://example.com/p"}, html, markdown)
+	if err != nil {
+		t.Fatal(err)
+	}
+	htmlOnly, err := storePage(PageMetadata{URL: "https://example.com/h"}, "<p>Only HTML</p>", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		encoding string
+		count    int
+		htmlName string
+	}{
+		{"compress", encodingGzip, 2, id + ".html.gz"},
+		{"again", encodingGzip, 0, id + ".html.gz"},
+		{"decompress", "", 2, id + ".html"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			count, err := recompressPages(tt.encoding)
+			if err != nil || count != tt.count {
+				t.Fatalf("recompressPages() = %d, %v, want %d", count, err, tt.count)
+			}
+			page, err := loadPage(id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if page.HTMLFilename != tt.htmlName || page.ContentEncoding != tt.encoding {
+				t.Errorf("metadata = %+v", page.PageMetadata)
+			}
+			for name, want := range map[string]string{page.HTMLFilename: html, page.MDFilename: markdown} {
+				if got, err := readContentFile(filepath.Join(pagesDir, name)); err != nil || string(got) != want {
+					t.Errorf("%s = %q, %v, want %q", name, got, err, want)
+				}
+			}
+			entries, _ := ioutil.ReadDir(pagesDir)
+			if len(entries) != 5 {
+				var names []string
+				for _, entry := range entries {
+					names = append(names, entry.Name())
+				}
+				t.Errorf("pages directory holds %v, want each page's files once", names)
+			}
+			if other, err := loadPage(htmlOnly); err != nil || other.ContentEncoding != tt.encoding {
+				t.Errorf("HTML-only page = %+v, %v", other.PageMetadata, err)
+			}
+		})
+	}
+}
+
+func TestImportCompressedPage(t *testing.T) {
+	withPagesDir(t)
+	src := t.TempDir()
+	id := "2024-01-01T00-00-00-000Z_https___example_com_gz"
+	metadata := PageMetadata{URL: "https://example.com/gz", Title: "Gz", HTMLFilename: id + ".html.gz", MDFilename: id + ".md.gz",
+		HasMarkdown: true, ContentEncoding: encodingGzip}
+	data, _ := json.Marshal(metadata)
+	files := map[string][]byte{
+		id + ".json":    data,
+		id + ".html.gz": gzipped(t, []byte("<p>Gz</p>")),
+		id + ".md.gz":   gzipped(t, []byte("# Gz")),
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(src, name), content, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	summary, err := importDirectory(context.Background(), src)
+	if err != nil || summary.Imported != 1 {
+		t.Fatalf("importDirectory() = %+v, %v", summary, err)
+	}
+	page, err := loadPage(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Stored as this archive's config says
+	if page.MDFilename != id+".md" || page.ContentEncoding != "" || page.ContentHash != contentHash([]byte("# Gz")) {
+		t.Errorf("imported metadata = %+v", page.PageMetadata)
+	}
+	if got, err := os.ReadFile(filepath.Join(pagesDir, page.MDFilename)); err != nil || string(got) != "# Gz" {
+		t.Errorf("markdown = %q, %v", got, err)
+	}
+}
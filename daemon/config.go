@@ -0,0 +1,528 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"os"
+)
+
+const configFile = "memento_config.json"
+
+type Config struct {
+	// Locale is the default language for display strings when a request
+	// doesn't ask for a supported one.
+	Locale string       `json:"locale"`
+	Zotero ZoteroConfig `json:"zotero"`
+	OPDS   OPDSConfig   `json:"opds"`
+	Search SearchConfig `json:"search"`
+	// Semantic finds pages by meaning with an embedding model.
+	Semantic SemanticConfig `json:"semantic"`
+	// Ask answers questions from the archive with a chat model.
+	Ask AskConfig `json:"ask"`
+	// Summaries summarizes every captured page.
+	Summaries SummariesConfig `json:"summaries"`
+	// AutoTags tags every captured page by topic.
+	AutoTags AutoTagsConfig `json:"autoTags"`
+	Stats    StatsConfig    `json:"stats"`
+	// Capture sets what is stripped from HTML before it is stored.
+	Capture CaptureConfig `json:"capture"`
+	// DisabledActions turns off page actions by ID, such as "delete". They
+	// are reported as disabled by /capabilities and refused by the API.
+	DisabledActions []string `json:"disabledActions"`
+	// Features turns whole subsystems off by ID, such as {"feeds": false},
+	// with their endpoints and background work. Features not listed are on.
+	Features map[string]bool `json:"features"`
+	// Tokens lists the API tokens accepted by the HTTP API. When it is
+	// empty the API is open to anyone who can reach it.
+	Tokens []TokenConfig `json:"tokens"`
+	// RateLimit throttles searches and captures from each client.
+	RateLimit RateLimitConfig `json:"rateLimit"`
+	// AllowedOrigins lists the browser origins, besides the daemon's own,
+	// that may call the API. An entry ending in "://*" allows every origin
+	// with that scheme, such as any browser extension.
+	AllowedOrigins []string `json:"allowedOrigins"`
+	// Users gives everyone who signs in an archive of their own.
+	Users   UsersConfig   `json:"users"`
+	OIDC    OIDCConfig    `json:"oidc"`
+	TLS     TLSConfig     `json:"tls"`
+	Tracing TracingConfig `json:"tracing"`
+	Alerts  AlertsConfig  `json:"alerts"`
+	// Webhooks are notified of page and error events.
+	Webhooks []WebhookConfig `json:"webhooks"`
+	// Peers are other memento instances searched by federated searches.
+	Peers []PeerConfig `json:"peers"`
+	// MementoArchives are the TimeMap endpoints of the web archives pages
+	// are imported from, each followed by a page's URL to list its
+	// snapshots.
+	MementoArchives []string `json:"mementoArchives"`
+	// SMTP sends saved search alerts by email.
+	SMTP      SMTPConfig      `json:"smtp"`
+	Browser   BrowserConfig   `json:"browser"`
+	Viewer    ViewerConfig    `json:"viewer"`
+	GitExport GitExportConfig `json:"gitExport"`
+	Watch     WatchConfig     `json:"watch"`
+	Retention RetentionConfig `json:"retention"`
+	Trash     TrashConfig     `json:"trash"`
+	// Backup backs the archive up on a schedule.
+	Backup BackupConfig `json:"backup"`
+	// Boilerplate leaves text repeated across a site's pages out of the
+	// index.
+	Boilerplate BoilerplateConfig `json:"boilerplate"`
+	Recrawl     RecrawlConfig     `json:"recrawl"`
+	LinkCheck   LinkCheckConfig   `json:"linkCheck"`
+	Reports     ReportsConfig     `json:"reports"`
+	// DoNotIndex lists the domains and URLs never to archive.
+	DoNotIndex DoNotIndexConfig `json:"doNotIndex"`
+}
+
+// BoilerplateConfig learns the blocks of text, such as footers and
+// sidebars, that at least MinPages of a site's pages, and half of them,
+// share, every IntervalHours. They are left out of the index, and with
+// StripText also out of the text read from pages for e-books, exports and
+// fidelity checks.
+type BoilerplateConfig struct {
+	Enabled       bool `json:"enabled"`
+	MinPages      int  `json:"minPages"`
+	IntervalHours int  `json:"intervalHours"`
+	StripText     bool `json:"stripText"`
+}
+
+// RetentionConfig prunes old captures every IntervalMinutes. Each limit is
+// off when zero, and starred pages or those tagged with any of KeepTags are
+// never pruned. Expired captures are removed on the same schedule.
+type RetentionConfig struct {
+	// MaxAgeDays removes pages captured longer ago.
+	MaxAgeDays int `json:"maxAgeDays"`
+	// MaxTotalBytes removes the oldest pages until the rest fit.
+	MaxTotalBytes int64 `json:"maxTotalBytes"`
+	// MaxPagesPerDomain keeps only the newest pages of each host.
+	MaxPagesPerDomain int      `json:"maxPagesPerDomain"`
+	KeepTags          []string `json:"keepTags"`
+	IntervalMinutes   int      `json:"intervalMinutes"`
+}
+
+// WatchConfig imports what is saved into Dirs, such as a browser's
+// downloads directory or a drop folder, checking them every
+// IntervalSeconds. It is off when Dirs is empty.
+type WatchConfig struct {
+	Dirs            []string `json:"dirs"`
+	IntervalSeconds int      `json:"intervalSeconds"`
+}
+
+// GitExportConfig commits the markdown of archived pages to a git
+// repository in Dir on a schedule. It is off when Dir is empty.
+type GitExportConfig struct {
+	Dir             string `json:"dir"`
+	IntervalMinutes int    `json:"intervalMinutes"`
+	// Push pushes each commit to the repository's upstream branch.
+	Push        bool   `json:"push"`
+	AuthorName  string `json:"authorName"`
+	AuthorEmail string `json:"authorEmail"`
+}
+
+// ViewerConfig sets up the PDF viewer. PDFJSDir is the build directory of
+// a pdfjs-dist release, served at /pdfjs/ for the viewer to render PDFs
+// with; without it the viewer shows their text.
+type ViewerConfig struct {
+	PDFJSDir string `json:"pdfjsDir"`
+}
+
+// BrowserConfig points at a Chrome or Chromium binary, run headless to take
+// screenshots and render pages that need JavaScript. Both are unavailable
+// when Path is empty.
+type BrowserConfig struct {
+	Path string `json:"path"`
+	// Args are extra command-line flags, such as --no-sandbox when the
+	// daemon runs as root in a container.
+	Args []string `json:"args"`
+	// Width is the viewport width in CSS pixels, 1280 by default.
+	Width int `json:"width"`
+	// TimeoutSeconds bounds loading and capturing one page.
+	TimeoutSeconds int `json:"timeoutSeconds"`
+	// Contexts is how many pages the browser loads at once, each in a
+	// context of its own; further captures wait for one to finish.
+	Contexts int `json:"contexts"`
+}
+
+// WebhookConfig posts events as JSON to URL. Deliveries are signed with
+// Secret when it is set.
+type WebhookConfig struct {
+	URL    string `json:"url"`
+	Secret Secret `json:"secret"`
+	// Events lists the event types to send: page.indexed, page.deleted
+	// and error. All of them are sent when it is empty.
+	Events []string `json:"events"`
+}
+
+type AlertsConfig struct {
+	// AllowPrivateWebhooks lets alert webhooks reach local and private
+	// addresses, such as an automation server on the same network.
+	AllowPrivateWebhooks bool `json:"allowPrivateWebhooks"`
+}
+
+type SMTPConfig struct {
+	Host     string `json:"host"`
+	Port     int    `json:"port"`
+	Username string `json:"username"`
+	Password Secret `json:"password"`
+	// From defaults to Username.
+	From string `json:"from"`
+}
+
+// TracingConfig exports spans for captures, indexing and searches to an
+// OpenTelemetry collector's OTLP/HTTP endpoint, such as
+// http://localhost:4318. Tracing is off when Endpoint is empty.
+type TracingConfig struct {
+	Endpoint    string `json:"endpoint"`
+	ServiceName string `json:"serviceName"`
+	// Headers are sent with every export, for collectors that need an API
+	// key.
+	Headers map[string]Secret `json:"headers"`
+}
+
+// TLSConfig serves the API over HTTPS when CertFile and KeyFile are set.
+type TLSConfig struct {
+	CertFile string `json:"certFile"`
+	KeyFile  string `json:"keyFile"`
+	// ClientCAFile verifies client certificates against these CAs, which
+	// then authenticate requests like API tokens.
+	ClientCAFile string `json:"clientCaFile"`
+	// RequireClientCert refuses connections without a valid client
+	// certificate.
+	RequireClientCert bool `json:"requireClientCert"`
+	// ClientCerts maps certificates to users. Without entries, any
+	// certificate signed by the client CA is accepted under its common name.
+	ClientCerts []ClientCertConfig `json:"clientCerts"`
+}
+
+// ClientCertConfig maps a client certificate, matched by SHA-256
+// fingerprint or subject common name, to a user. A user with the same name
+// as a token shares its quotas.
+type ClientCertConfig struct {
+	Fingerprint string `json:"fingerprint"`
+	CommonName  string `json:"commonName"`
+	User        string `json:"user"`
+}
+
+// UsersConfig shares one daemon between several people. A user is a token
+// or client certificate user's name, or a login's email, and owns the pages
+// they capture.
+type UsersConfig struct {
+	// Enabled limits each user's searches and pages to their own.
+	Enabled bool `json:"enabled"`
+	// Admins may act on the whole archive, with exports, imports and
+	// maintenance, and open any page.
+	Admins []string `json:"admins"`
+	// DefaultOwner owns pages that arrive without an owner, such as those
+	// the extension saves into the pages directory. Without it they are
+	// hidden from users until given one with daemon assign-owner.
+	DefaultOwner string `json:"defaultOwner"`
+}
+
+// OIDCConfig signs browser users in through an OpenID Connect provider.
+// Login is enabled when Issuer is set.
+type OIDCConfig struct {
+	Issuer       string `json:"issuer"`
+	ClientID     string `json:"clientId"`
+	ClientSecret Secret `json:"clientSecret"`
+	// RedirectURL is this daemon's /auth/callback URL as registered with
+	// the provider.
+	RedirectURL string   `json:"redirectUrl"`
+	Scopes      []string `json:"scopes"`
+	// AllowedEmails and AllowedDomains list the verified addresses, or
+	// their domains, that may log in. One of them must be set.
+	AllowedEmails  []string `json:"allowedEmails"`
+	AllowedDomains []string `json:"allowedDomains"`
+	SessionHours   int      `json:"sessionHours"`
+}
+
+type TokenConfig struct {
+	// Name identifies the token's holder in usage reports and page owners.
+	Name  string      `json:"name"`
+	Token Secret      `json:"token"`
+	Quota QuotaConfig `json:"quota"`
+	// Scopes grant more than the API's defaults; see tokenScopes.
+	Scopes []string `json:"scopes"`
+}
+
+// QuotaConfig limits what a token may do. Zero values are unlimited.
+type QuotaConfig struct {
+	CapturesPerDay    int   `json:"capturesPerDay"`
+	StorageBytes      int64 `json:"storageBytes"`
+	SearchesPerMinute int   `json:"searchesPerMinute"`
+}
+
+// limited reports whether any quota is set.
+func (q QuotaConfig) limited() bool {
+	return q.CapturesPerDay > 0 || q.StorageBytes > 0 || q.SearchesPerMinute > 0
+}
+
+// RateLimitConfig throttles each client, known by its token or login or
+// else its IP address, so that no one can hammer the index. Search covers
+// searches and suggestions, Ingest captures, bookmarks and imports.
+type RateLimitConfig struct {
+	Search RateConfig `json:"search"`
+	Ingest RateConfig `json:"ingest"`
+}
+
+// RateConfig lets a client make Burst requests at once, refilled at
+// PerMinute a minute. Burst defaults to a minute's worth, and the limit is
+// off when PerMinute is zero.
+type RateConfig struct {
+	PerMinute float64 `json:"perMinute"`
+	Burst     int     `json:"burst"`
+}
+
+type ZoteroConfig struct {
+	// ConnectorEnabled starts a listener that the Zotero Connector browser
+	// extension can save items and snapshots to.
+	ConnectorEnabled bool `json:"connectorEnabled"`
+	ConnectorPort    int  `json:"connectorPort"`
+}
+
+type SearchConfig struct {
+	// Fuzziness is the edit distance, up to 2, that query terms may be from
+	// the words they match when a search doesn't set one.
+	Fuzziness int `json:"fuzziness"`
+	// LearnFromClicks logs which results are opened from the UIs, locally,
+	// and every RerankIntervalHours turns the clicks into boosts that float
+	// often revisited pages up results sorted by relevance.
+	LearnFromClicks     bool `json:"learnFromClicks"`
+	RerankIntervalHours int  `json:"rerankIntervalHours"`
+	// RecordHistory keeps the searches run, locally, for HistoryDays, to
+	// be looked back over and summed up in /history.
+	RecordHistory bool `json:"recordHistory"`
+	HistoryDays   int  `json:"historyDays"`
+	// Templates are named structured searches served at /search/{name},
+	// so that common filters don't have to be rebuilt by every client.
+	Templates map[string]StructuredQuery `json:"templates"`
+	// Analysis sets stemming, stop words and synonyms for the index.
+	Analysis AnalysisConfig `json:"analysis"`
+}
+
+type CaptureConfig struct {
+	// ContentPolicy is "standard" to remove scripts, event handlers and
+	// trackers, "strict" to also remove every external resource reference,
+	// or "off".
+	ContentPolicy string `json:"contentPolicy"`
+	// BlockedHosts are removed like the built-in list of trackers, with
+	// their subdomains.
+	BlockedHosts []string `json:"blockedHosts"`
+	// Assets stores the images, stylesheets and fonts of every page
+	// archived by URL, as if each request asked for them.
+	Assets bool `json:"assets"`
+	// Compression is "gzip" to store the HTML and markdown of new pages
+	// compressed, or "" to store them as they are.
+	Compression string `json:"compression"`
+}
+
+type StatsConfig struct {
+	// GrowthWindowDays is how far back /stats looks to estimate how fast
+	// disk usage grows.
+	GrowthWindowDays int `json:"growthWindowDays"`
+	// WarnBytes are disk usage levels, such as the size of the disk, that
+	// /stats warns about once they are reached or forecast to be within a
+	// year.
+	WarnBytes []int64 `json:"warnBytes"`
+}
+
+type OPDSConfig struct {
+	// MinWords is the length a page needs to be listed as a long read.
+	MinWords int `json:"minWords"`
+}
+
+var config = defaultConfig()
+
+func defaultConfig() Config {
+	return Config{
+		Locale:          defaultLocale,
+		MementoArchives: []string{"https://web.archive.org/web/timemap/link/"},
+		AllowedOrigins:  []string{"chrome-extension://*", "moz-extension://*"},
+		Zotero: ZoteroConfig{
+			ConnectorPort: 23119,
+		},
+		OPDS: OPDSConfig{
+			MinWords: 1500,
+		},
+		Search: SearchConfig{
+			RerankIntervalHours: 24 * 7,
+			HistoryDays:         90,
+		},
+		Semantic: SemanticConfig{
+			URL:          defaultEmbeddingURL,
+			Model:        defaultEmbeddingModel,
+			ChunkWords:   200,
+			HybridWeight: 0.5,
+		},
+		Ask: AskConfig{
+			URL:      defaultChatURL,
+			Model:    defaultChatModel,
+			Passages: 5,
+		},
+		AutoTags: AutoTagsConfig{
+			MaxTags: 3,
+		},
+		Stats: StatsConfig{
+			GrowthWindowDays: 90,
+		},
+		Capture: CaptureConfig{
+			ContentPolicy: contentPolicyStandard,
+		},
+		SMTP: SMTPConfig{
+			Port: 587,
+		},
+		GitExport: GitExportConfig{
+			IntervalMinutes: 60,
+			AuthorName:      "Memento",
+			AuthorEmail:     "memento@localhost",
+		},
+		Watch: WatchConfig{
+			IntervalSeconds: 30,
+		},
+		Retention: RetentionConfig{
+			IntervalMinutes: 60,
+		},
+		Trash: TrashConfig{
+			Days: 30,
+		},
+		Backup: BackupConfig{
+			Schedule: "0 3 * * *",
+			Keep:     7,
+			S3:       S3Config{Region: "us-east-1"},
+		},
+		Boilerplate: BoilerplateConfig{
+			MinPages:      5,
+			IntervalHours: 24,
+		},
+		Recrawl: RecrawlConfig{
+			IntervalHours:    24,
+			MinIntervalHours: 1,
+			MaxIntervalHours: 24 * 7,
+			Threshold:        materialDrift,
+		},
+		LinkCheck: LinkCheckConfig{
+			Limit: 100,
+		},
+		OIDC: OIDCConfig{
+			Scopes:       []string{"openid", "email", "profile"},
+			SessionHours: 24 * 7,
+		},
+	}
+}
+
+// loadConfig overlays the optional config file on top of the defaults.
+func loadConfig() {
+	data, err := ioutil.ReadFile(configFile)
+	if os.IsNotExist(err) {
+		return
+	}
+	if err != nil {
+		log.Fatalf("Error reading config file %s: %v", configFile, err)
+	}
+	if err := json.Unmarshal(data, &config); err != nil {
+		log.Fatalf("Error parsing config file %s: %v", configFile, err)
+	}
+	if config.Search.Fuzziness < 0 || config.Search.Fuzziness > maxFuzziness {
+		log.Fatalf("Error in config file %s: search.fuzziness must be between 0 and %d", configFile, maxFuzziness)
+	}
+	if config.Search.RerankIntervalHours < 1 {
+		log.Fatalf("Error in config file %s: search.rerankIntervalHours must be at least 1", configFile)
+	}
+	if config.Search.HistoryDays < 1 {
+		log.Fatalf("Error in config file %s: search.historyDays must be at least 1", configFile)
+	}
+	if err := validateSearchTemplates(config.Search.Templates); err != nil {
+		log.Fatalf("Error in config file %s: %v", configFile, err)
+	}
+	if err := validateSynonyms(config.Search.Analysis.Synonyms); err != nil {
+		log.Fatalf("Error in config file %s: %v", configFile, err)
+	}
+	if err := validateSemantic(config.Semantic); err != nil {
+		log.Fatalf("Error in config file %s: %v", configFile, err)
+	}
+	if err := validateAsk(config.Ask); err != nil {
+		log.Fatalf("Error in config file %s: %v", configFile, err)
+	}
+	if err := validateAutoTags(config.AutoTags); err != nil {
+		log.Fatalf("Error in config file %s: %v", configFile, err)
+	}
+	if err := config.DoNotIndex.compile(); err != nil {
+		log.Fatalf("Error in config file %s: %v", configFile, err)
+	}
+	if !contentPolicies[config.Capture.ContentPolicy] {
+		log.Fatalf("Error in config file %s: capture.contentPolicy must be off, standard or strict", configFile)
+	}
+	if c := config.Capture.Compression; c != "" && c != encodingGzip {
+		log.Fatalf("Error in config file %s: capture.compression must be gzip or empty", configFile)
+	}
+	if err := validateFeatures(config.Features); err != nil {
+		log.Fatalf("Error in config file %s: %v", configFile, err)
+	}
+	if err := validateWebhooks(config.Webhooks); err != nil {
+		log.Fatalf("Error in config file %s: %v", configFile, err)
+	}
+	if err := validatePeers(config.Peers); err != nil {
+		log.Fatalf("Error in config file %s: %v", configFile, err)
+	}
+	if err := validateMementoArchives(config.MementoArchives); err != nil {
+		log.Fatalf("Error in config file %s: %v", configFile, err)
+	}
+	if config.GitExport.IntervalMinutes < 1 {
+		log.Fatalf("Error in config file %s: gitExport.intervalMinutes must be at least 1", configFile)
+	}
+	if config.Watch.IntervalSeconds < 1 {
+		log.Fatalf("Error in config file %s: watch.intervalSeconds must be at least 1", configFile)
+	}
+	if config.Boilerplate.MinPages < 2 {
+		log.Fatalf("Error in config file %s: boilerplate.minPages must be at least 2", configFile)
+	}
+	if config.Recrawl.IntervalHours < 1 {
+		log.Fatalf("Error in config file %s: recrawl.intervalHours must be at least 1", configFile)
+	}
+	if r := config.Recrawl; r.MinIntervalHours < 1 || r.MaxIntervalHours < r.MinIntervalHours {
+		log.Fatalf("Error in config file %s: recrawl.minIntervalHours must be at least 1 and at most recrawl.maxIntervalHours", configFile)
+	}
+	if t := config.Recrawl.Threshold; t <= 0 || t > 1 {
+		log.Fatalf("Error in config file %s: recrawl.threshold must be above 0 and at most 1", configFile)
+	}
+	if config.LinkCheck.IntervalHours < 0 {
+		log.Fatalf("Error in config file %s: linkCheck.intervalHours can't be negative", configFile)
+	}
+	if config.LinkCheck.Limit < 1 {
+		log.Fatalf("Error in config file %s: linkCheck.limit must be at least 1", configFile)
+	}
+	if config.Boilerplate.IntervalHours < 1 {
+		log.Fatalf("Error in config file %s: boilerplate.intervalHours must be at least 1", configFile)
+	}
+	if config.Retention.IntervalMinutes < 1 {
+		log.Fatalf("Error in config file %s: retention.intervalMinutes must be at least 1", configFile)
+	}
+	if config.Retention.MaxAgeDays < 0 || config.Retention.MaxTotalBytes < 0 || config.Retention.MaxPagesPerDomain < 0 {
+		log.Fatalf("Error in config file %s: retention limits can't be negative", configFile)
+	}
+	if config.Trash.Days < 1 {
+		log.Fatalf("Error in config file %s: trash.days must be at least 1", configFile)
+	}
+	if err := validateBackup(config.Backup); err != nil {
+		log.Fatalf("Error in config file %s: %v", configFile, err)
+	}
+	if config.Stats.GrowthWindowDays < 1 {
+		log.Fatalf("Error in config file %s: stats.growthWindowDays must be at least 1", configFile)
+	}
+	if r := config.RateLimit; r.Search.PerMinute < 0 || r.Search.Burst < 0 || r.Ingest.PerMinute < 0 || r.Ingest.Burst < 0 {
+		log.Fatalf("Error in config file %s: rateLimit values can't be negative", configFile)
+	}
+	if len(config.Reports.Email) > 0 && config.SMTP.Host == "" {
+		log.Fatalf("Error in config file %s: reports.email needs smtp.host", configFile)
+	}
+	if config.Users.Enabled && len(config.Tokens) == 0 && !oidcEnabled() && !clientCertsEnabled() {
+		log.Fatalf("Error in config file %s: users need tokens, oidc or client certificates to tell them apart", configFile)
+	}
+	if err := validateTokenScopes(config.Tokens); err != nil {
+		log.Fatalf("Error in config file %s: %v", configFile, err)
+	}
+	if oidcEnabled() && len(config.OIDC.AllowedEmails) == 0 && len(config.OIDC.AllowedDomains) == 0 {
+		log.Fatalf("Error in config file %s: oidc needs allowedEmails or allowedDomains", configFile)
+	}
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html"
+	"log"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// pacer spaces a crawl's requests to a site delay apart, however many are
+// made at once.
+type pacer struct {
+	mu    sync.Mutex
+	delay time.Duration
+	next  time.Time
+}
+
+// wait holds a request until its turn, returning false if ctx is done
+// first.
+func (p *pacer) wait(ctx context.Context) bool {
+	p.mu.Lock()
+	now := time.Now()
+	at := p.next
+	if at.Before(now) {
+		at = now
+	}
+	p.next = at.Add(p.delay)
+	p.mu.Unlock()
+	if ctx.Err() != nil {
+		return false
+	}
+	return sleepContext(ctx, at.Sub(now))
+}
+
+// pageLinks returns the http(s) links of an HTML page, resolved against its
+// URL or <base>, without fragments. Links marked nofollow are left out.
+func pageLinks(page, pageURL string) []string {
+	base, err := url.Parse(pageURL)
+	if err != nil {
+		return nil
+	}
+	var links []string
+	for _, tag := range htmlTagRe.FindAllStringSubmatch(page, -1) {
+		name := strings.ToLower(tag[1])
+		if name != "a" && name != "base" {
+			continue
+		}
+		attrs := make(map[string]string)
+		for _, attr := range htmlAttrRe.FindAllStringSubmatch(tag[2], -1) {
+			attrs[strings.ToLower(attr[1])] = html.UnescapeString(strings.Trim(attr[2], `"'`))
+		}
+		href, ok := attrs["href"]
+		if !ok {
+			continue
+		}
+		u, err := base.Parse(strings.TrimSpace(href))
+		if err != nil {
+			continue
+		}
+		if name == "base" {
+			base = u
+			continue
+		}
+		if strings.Contains(strings.ToLower(attrs["rel"]), "nofollow") || (u.Scheme != "http" && u.Scheme != "https") {
+			continue
+		}
+		u.Fragment, u.RawFragment = "", ""
+		links = append(links, u.String())
+	}
+	return links
+}
+
+// crawlPages archives pages for a crawl, fetching up to its concurrency at
+// once. With follow set, the links of each page on the crawl's site and in
+// its scope are crawled in turn, a level deeper, down to the crawl's depth
+// and until it has found its limit of pages.
+func crawlPages(ctx context.Context, crawl *SiteCrawl, robots robotsRules, pace *pacer, pages []string, follow bool) error {
+	seen := make(map[string]bool)
+	for _, page := range pages {
+		seen[page] = true
+	}
+	var site string
+	if len(pages) > 0 {
+		site = domainOf(pages[0])
+	}
+	level := pages
+	for depth := 0; len(level) > 0; depth++ {
+		var mu sync.Mutex
+		var next []string
+		work := make(chan string)
+		var wg sync.WaitGroup
+		for i := 0; i < crawl.Concurrency; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for page := range work {
+					links := crawlPage(ctx, crawl, robots, pace, page, follow && depth < crawl.MaxDepth)
+					mu.Lock()
+					for _, link := range links {
+						if len(seen) == crawl.MaxPages {
+							break
+						}
+						if seen[link] || domainOf(link) != site || !crawl.inScope(link) {
+							continue
+						}
+						seen[link] = true
+						next = append(next, link)
+						crawl.update(func(c *SiteCrawl) { c.Found++ })
+					}
+					mu.Unlock()
+				}
+			}()
+		}
+	send:
+		for _, page := range level {
+			select {
+			case work <- page:
+			case <-ctx.Done():
+				break send
+			}
+		}
+		close(work)
+		wg.Wait()
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		level = next
+	}
+	return nil
+}
+
+// crawlPage archives one page of a crawl, tagged with the crawl's tags, and
+// returns its links when they are to be followed. Pages already archived
+// are still followed.
+func crawlPage(ctx context.Context, crawl *SiteCrawl, robots robotsRules, pace *pacer, pageURL string, follow bool) []string {
+	u, err := url.Parse(pageURL)
+	if err != nil || !robots.allowed(u) {
+		crawl.update(func(c *SiteCrawl) { c.Blocked++ })
+		return nil
+	}
+	if !pace.wait(ctx) {
+		return nil
+	}
+	page, mediaType, header, err := fetchPage(ctx, pageURL)
+	var id string
+	if err == nil {
+		id, err = storeFetched(ctx, pageURL, page, mediaType, header, crawl.Owner, false)
+	}
+	if err == nil {
+		if config.Capture.Assets {
+			if _, err := captureAssets(ctx, id); err != nil {
+				log.Printf("Error storing assets of %s: %v", pageURL, err)
+			}
+		}
+		err = reindexWith(ctx, id, func(metadata *PageMetadata) error {
+			metadata.Source = "sitemap"
+			if crawl.Mode == siteCrawlLinks {
+				metadata.Source = "crawl"
+			}
+			metadata.Tags = crawl.Tags
+			return nil
+		})
+	}
+	if ctx.Err() != nil {
+		return nil
+	}
+	crawl.update(func(c *SiteCrawl) {
+		switch {
+		case err == nil:
+			c.Archived++
+		case errors.Is(err, errDuplicate):
+			c.Duplicates++
+		case errors.Is(err, errUnsupportedContent) || errors.Is(err, errCaptureTooLarge):
+			c.Skipped++
+		default:
+			c.Failed++
+			c.LastError = fmt.Sprintf("archiving %s: %v", pageURL, err)
+		}
+	})
+	switch {
+	case errors.Is(err, errUnsupportedContent) || errors.Is(err, errCaptureTooLarge):
+		return nil
+	case err != nil && !errors.Is(err, errDuplicate):
+		log.Printf("Error archiving %s while crawling %s: %v", pageURL, crawl.Site, err)
+		// A page stored but failing to be tagged can still be followed
+		if id == "" {
+			return nil
+		}
+	}
+	if !follow || mediaType == pdfMediaType {
+		return nil
+	}
+	return pageLinks(page, pageURL)
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPageLinks(t *testing.T) {
+	tests := []struct {
+		name string
+		page string
+		want []string
+	}{
+		{"relative", `<a href="/a">A</a> <A HREF='b?x=1&amp;y=2#top'>B</A> <a href=c>C</a>`,
+			[]string{"https://example.com/a", "https://example.com/docs/b?x=1&y=2", "https://example.com/docs/c"}},
+		{"base", `<base href="https://cdn.example.com/root/"><a href="page">P</a>`, []string{"https://cdn.example.com/root/page"}},
+		{"skipped", `<a href="mailto:me@example.com">M</a><a href="javascript:void(0)">J</a><a rel="nofollow" href="/login">L</a><a name="anchor">N</a><link href="/style.css">`, nil},
+	}
+	for _, tt := range tests {
+		if got := pageLinks(tt.page, "https://example.com/docs/index.html"); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: pageLinks() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestPacer(t *testing.T) {
+	pace := &pacer{delay: 20 * time.Millisecond}
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		pace.wait(context.Background())
+	}
+	if elapsed := time.Since(start); elapsed < 40*time.Millisecond {
+		t.Errorf("3 requests took %v, want them 20ms apart", elapsed)
+	}
+}
+
+func TestLinkCrawl(t *testing.T) {
+	withPagesDir(t)
+	withIndex(t, nil)
+	withJobs(t)
+	links := map[string]string{
+		"/":       `<a href="/a">A</a> <a href="/b#part">B</a> <a href="/b">B</a> <a rel="nofollow" href="/out">Out</a> <a href="https://other.example/x">X</a> <a href="/skip/me">Skip</a> <a href="/img.png">Image</a>`,
+		"/a":      `<a href="/a/deep">Deep</a> <a href="/private/p">Private</a>`,
+		"/a/deep": `<a href="/a/deeper">Deeper</a>`,
+		"/b":      `<a href="/">Home</a>`,
+	}
+	var mu sync.Mutex
+	var fetched []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/robots.txt" {
+			fmt.Fprint(w, "User-agent: *\nDisallow: /private\n")
+			return
+		}
+		mu.Lock()
+		fetched = append(fetched, r.URL.Path)
+		mu.Unlock()
+		if r.URL.Path == "/img.png" {
+			w.Header().Set("Content-Type", "image/png")
+			return
+		}
+		body, ok := links[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprintf(w, "<title>Page %s</title><p>Text of %s</p>%s", r.URL.Path, r.URL.Path, body)
+	}))
+	defer server.Close()
+	saved := archiveClient
+	archiveClient = server.Client()
+	defer func() { archiveClient = saved }()
+	defer func() {
+		siteCrawls.Lock()
+		siteCrawls.byID = make(map[string]*SiteCrawl)
+		siteCrawls.Unlock()
+	}()
+
+	request := func(method, path, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(method, path, bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		newRouter().ServeHTTP(rec, req)
+		return rec
+	}
+	crawl := func(body string) SiteCrawl {
+		rec := request(http.MethodPost, "/api/v1/archive/site", body)
+		var crawl SiteCrawl
+		json.Unmarshal(rec.Body.Bytes(), &crawl)
+		if rec.Code != http.StatusAccepted {
+			t.Fatalf("POST %s = %d %s", body, rec.Code, rec.Body)
+		}
+		deadline := time.Now().Add(5 * time.Second)
+		for crawl.Status == siteCrawlRunning && time.Now().Before(deadline) {
+			time.Sleep(10 * time.Millisecond)
+			json.Unmarshal(request(http.MethodGet, "/api/v1/archive/site/"+crawl.ID, "").Body.Bytes(), &crawl)
+		}
+		return crawl
+	}
+
+	for _, body := range []string{
+		`{"site": "example.com", "mode": "spider"}`,
+		`{"site": "example.com/sitemap.xml", "mode": "links"}`,
+		`{"site": "example.com", "mode": "links", "include": ["("]}`,
+		`{"site": "example.com", "mode": "links", "concurrency": 100}`,
+	} {
+		if rec := request(http.MethodPost, "/api/v1/archive/site", body); rec.Code != http.StatusBadRequest {
+			t.Errorf("POST %s = %d, want 400", body, rec.Code)
+		}
+	}
+
+	got := crawl(`{"site": "` + server.URL + `/", "mode": "links", "exclude": ["/skip/"], "delaySeconds": 0, "concurrency": 3}`)
+	if got.Status != siteCrawlDone || got.Found != 6 || got.Archived != 4 || got.Skipped != 1 || got.Blocked != 1 || got.Failed != 0 {
+		t.Fatalf("finished crawl = %+v", got)
+	}
+	sort.Strings(fetched)
+	if want := []string{"/", "/a", "/a/deep", "/b", "/img.png"}; !reflect.DeepEqual(fetched, want) {
+		t.Errorf("fetched %v, want %v", fetched, want)
+	}
+	pages, _ := listPages()
+	if len(pages) != 4 || pages[0].Source != "crawl" {
+		t.Errorf("archived pages = %+v", pages)
+	}
+
+	// Pages already archived are still followed, up to the page limit,
+	// and only pages in the included part of the site
+	fetched = nil
+	got = crawl(`{"site": "` + server.URL + `/", "mode": "links", "include": ["/a"], "maxPages": 2, "delaySeconds": 0}`)
+	if got.Status != siteCrawlDone || got.Found != 2 || got.Duplicates != 2 {
+		t.Errorf("limited crawl = %+v", got)
+	}
+	if strings.Join(fetched, " ") != "/ /a" {
+		t.Errorf("limited crawl fetched %v", fetched)
+	}
+}
@@ -0,0 +1,298 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// maxDiffEdits bounds the work of diffing two versions. Past it, the rest
+// of the versions are shown as removed and added wholesale.
+const maxDiffEdits = 1000
+
+// diffLine is a line of text in both versions ("="), only in the newer one
+// ("+"), or only in the older one ("-").
+type diffLine struct {
+	Op   string `json:"op"`
+	Text string `json:"text"`
+}
+
+type diffVersion struct {
+	ID        string    `json:"id"`
+	Title     string    `json:"title"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// PageDiff compares the extracted text of two captures of a URL.
+type PageDiff struct {
+	URL     string      `json:"url"`
+	From    diffVersion `json:"from"`
+	To      diffVersion `json:"to"`
+	Added   int         `json:"added"`
+	Removed int         `json:"removed"`
+	Lines   []diffLine  `json:"lines"`
+}
+
+// diffLines returns the shortest edit turning a into b, using Myers'
+// algorithm on what's left once their common start and end are set aside.
+func diffLines(a, b []string) []diffLine {
+	var prefix, suffix []diffLine
+	for len(a) > 0 && len(b) > 0 && a[0] == b[0] {
+		prefix = append(prefix, diffLine{"=", a[0]})
+		a, b = a[1:], b[1:]
+	}
+	for len(a) > 0 && len(b) > 0 && a[len(a)-1] == b[len(b)-1] {
+		suffix = append([]diffLine{{"=", a[len(a)-1]}}, suffix...)
+		a, b = a[:len(a)-1], b[:len(b)-1]
+	}
+	return append(append(prefix, myersDiff(a, b)...), suffix...)
+}
+
+func myersDiff(a, b []string) []diffLine {
+	n, m := len(a), len(b)
+	max := n + m
+	offset := max + 1
+	v := make([]int, 2*max+3)
+	// trace[d] holds v for diagonals -d to d after d edits
+	var trace [][]int
+	done := false
+	for d := 0; d <= max && !done; d++ {
+		if d > maxDiffEdits {
+			return replaceLines(a, b)
+		}
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1]
+			} else {
+				x = v[offset+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x, y = x+1, y+1
+			}
+			v[offset+k] = x
+			if x >= n && y >= m {
+				done = true
+				break
+			}
+		}
+		trace = append(trace, append([]int(nil), v[offset-d:offset+d+1]...))
+	}
+
+	var reversed []diffLine
+	x, y := n, m
+	for d := len(trace) - 1; d > 0; d-- {
+		prev := trace[d-1]
+		at := func(k int) int { return prev[k+d-1] }
+		k := x - y
+		prevK := k - 1
+		if k == -d || (k != d && at(k-1) < at(k+1)) {
+			prevK = k + 1
+		}
+		prevX := at(prevK)
+		prevY := prevX - prevK
+		for x > prevX && y > prevY {
+			x, y = x-1, y-1
+			reversed = append(reversed, diffLine{"=", a[x]})
+		}
+		if x == prevX {
+			reversed = append(reversed, diffLine{"+", b[prevY]})
+		} else {
+			reversed = append(reversed, diffLine{"-", a[prevX]})
+		}
+		x, y = prevX, prevY
+	}
+	for x > 0 && y > 0 {
+		x, y = x-1, y-1
+		reversed = append(reversed, diffLine{"=", a[x]})
+	}
+	lines := make([]diffLine, len(reversed))
+	for i, line := range reversed {
+		lines[len(reversed)-1-i] = line
+	}
+	return lines
+}
+
+func replaceLines(a, b []string) []diffLine {
+	var lines []diffLine
+	for _, line := range a {
+		lines = append(lines, diffLine{"-", line})
+	}
+	for _, line := range b {
+		lines = append(lines, diffLine{"+", line})
+	}
+	return lines
+}
+
+// textLines splits extracted text into its non-blank lines.
+func textLines(text string) []string {
+	var lines []string
+	for _, line := range strings.Split(text, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// diffPages compares the text of two captures.
+func diffPages(from, to Page) (PageDiff, error) {
+	diff := PageDiff{
+		URL:  to.URL,
+		From: diffVersion{ID: from.ID, Title: from.Title, Timestamp: from.Timestamp},
+		To:   diffVersion{ID: to.ID, Title: to.Title, Timestamp: to.Timestamp},
+	}
+	before, err := readFullText(from.PageMetadata)
+	if err != nil {
+		return diff, err
+	}
+	after, err := readFullText(to.PageMetadata)
+	if err != nil {
+		return diff, err
+	}
+	diff.Lines = diffLines(textLines(before), textLines(after))
+	for _, line := range diff.Lines {
+		switch line.Op {
+		case "+":
+			diff.Added++
+		case "-":
+			diff.Removed++
+		}
+	}
+	return diff, nil
+}
+
+// previousVersion returns the latest capture of a page's URL saved before
+// it that the request may see.
+func previousVersion(r *http.Request, page Page) (Page, bool, error) {
+	pages, err := listPages()
+	if err != nil {
+		return Page{}, false, err
+	}
+	var previous Page
+	found := false
+	for _, p := range pages {
+		if p.URL == page.URL && p.Timestamp.Before(page.Timestamp) && pageVisible(r, p.PageMetadata) &&
+			(!found || p.Timestamp.After(previous.Timestamp)) {
+			previous, found = p, true
+		}
+	}
+	return previous, found, nil
+}
+
+var diffTemplate = template.Must(template.New("diff").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.To.Title}} - Memento</title>
+<style>
+body { margin: 2em auto; max-width: 50em; padding: 0 1em; font: 16px/1.5 sans-serif; }
+p { margin: 0.2em 0; padding: 0 0.4em; white-space: pre-wrap; }
+del { display: block; background: #fbe9eb; text-decoration: none; }
+ins { display: block; background: #e6f6ea; text-decoration: none; }
+del::before { content: "− "; }
+ins::before { content: "+ "; }
+header p { color: #666; }
+</style>
+</head>
+<body>
+<header>
+<h1>{{.To.Title}}</h1>
+<p><a href="{{.URL}}">{{.URL}}</a></p>
+<p>{{.From.ID}} ({{.From.Timestamp.Format "2006-01-02 15:04"}}) → {{.To.ID}} ({{.To.Timestamp.Format "2006-01-02 15:04"}}): +{{.Added}} −{{.Removed}}</p>
+</header>
+<main>
+{{range .Lines}}{{if eq .Op "+"}}<ins><p>{{.Text}}</p></ins>{{else if eq .Op "-"}}<del><p>{{.Text}}</p></del>{{else}}<p>{{.Text}}</p>{{end}}
+{{end}}</main>
+</body>
+</html>
+`))
+
+// handlePageDiff compares two captures of a page's URL: to, the page by
+// default, and from, by default the capture before to. The diff is JSON,
+// or with format=text or format=html a listing of the lines.
+func handlePageDiff(w http.ResponseWriter, r *http.Request) {
+	page, err := loadPage(r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusNotFound, ErrNotFound, "Page not found")
+		return
+	}
+	version := func(id string) (Page, bool) {
+		if id == "" || id == page.ID {
+			return page, true
+		}
+		p, err := loadPage(id)
+		if err != nil || !pageVisible(r, p.PageMetadata) {
+			writeError(w, http.StatusNotFound, ErrNotFound, "Page "+id+" not found")
+			return p, false
+		}
+		if p.URL != page.URL {
+			writeError(w, http.StatusBadRequest, ErrInvalidRequest, id+" is not a capture of "+page.URL)
+			return p, false
+		}
+		return p, true
+	}
+	query := r.URL.Query()
+	format := query.Get("format")
+	if format != "" && format != "json" && format != "text" && format != "html" {
+		writeError(w, http.StatusBadRequest, ErrInvalidRequest, "format must be json, text or html")
+		return
+	}
+	to, ok := version(query.Get("to"))
+	if !ok {
+		return
+	}
+	var from Page
+	if id := query.Get("from"); id != "" {
+		if from, ok = version(id); !ok {
+			return
+		}
+	} else {
+		from, ok, err = previousVersion(r, to)
+		if err != nil {
+			log.Printf("Error listing pages: %v", err)
+			writeError(w, http.StatusInternalServerError, ErrInternal, "Failed to list pages")
+			return
+		}
+		if !ok {
+			writeError(w, http.StatusNotFound, ErrNotFound, "Page has no earlier capture")
+			return
+		}
+	}
+
+	diff, err := diffPages(from, to)
+	if err != nil {
+		log.Printf("Error diffing %s and %s: %v", from.ID, to.ID, err)
+		writeError(w, http.StatusInternalServerError, ErrInternal, "Failed to read page")
+		return
+	}
+	switch format {
+	case "text":
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		fmt.Fprintf(w, "--- %s %s\n+++ %s %s\n", from.ID, from.Timestamp.Format(time.RFC3339), to.ID, to.Timestamp.Format(time.RFC3339))
+		for _, line := range diff.Lines {
+			op := line.Op
+			if op == "=" {
+				op = " "
+			}
+			fmt.Fprintf(w, "%s %s\n", op, line.Text)
+		}
+	case "html":
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if err := diffTemplate.Execute(w, diff); err != nil {
+			log.Printf("Error rendering diff: %v", err)
+		}
+	default:
+		if diff.Lines == nil {
+			diff.Lines = []diffLine{}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(diff)
+	}
+}
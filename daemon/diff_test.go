@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDiffLines(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want string
+	}{
+		{"", "", ""},
+		{"a b c", "a b c", "=a =b =c"},
+		{"a b c", "a x c", "=a -b +x =c"},
+		{"a b c d", "b c e", "-a =b =c -d +e"},
+		{"", "x y", "+x +y"},
+		{"a b c a b b a", "c b a b a c", "-a -b =c +b =a =b -b =a +c"},
+	}
+	for _, tt := range tests {
+		var got []string
+		for _, line := range diffLines(strings.Fields(tt.a), strings.Fields(tt.b)) {
+			got = append(got, line.Op+line.Text)
+		}
+		if strings.Join(got, " ") != tt.want {
+			t.Errorf("diffLines(%q, %q) = %v, want %s", tt.a, tt.b, got, tt.want)
+		}
+	}
+
+	// Past maxDiffEdits the lines are replaced wholesale
+	var a, b []string
+	for i := 0; i <= maxDiffEdits; i++ {
+		a, b = append(a, fmt.Sprint("a", i)), append(b, fmt.Sprint("b", i))
+	}
+	if lines := diffLines(a, b); len(lines) != len(a)+len(b) || lines[0].Op != "-" || lines[len(lines)-1].Op != "+" {
+		t.Errorf("large diff has %d lines", len(lines))
+	}
+}
+
+func TestPageDiff(t *testing.T) {
+	withPagesDir(t)
+	withIndex(t, nil)
+	store := func(url, html string, age time.Duration) string {
+		id, err := storePage(PageMetadata{URL: url, Title: "Pricing", Timestamp: time.Now().Add(-age)}, html, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return id
+	}
+	first := store("https://example.com/pricing", "<p>Pricing</p><p>Ten dollars</p><p>Contact</p>", 2*time.Hour)
+	second := store("https://example.com/pricing", "<p>Pricing</p><p>Twenty dollars</p><p>Contact</p>", time.Hour)
+	other := store("https://example.com/other", "<p>Other</p>", time.Hour)
+
+	get := func(path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		newRouter().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec
+	}
+	rec := get("/api/v1/pages/" + second + "/diff")
+	var diff PageDiff
+	json.Unmarshal(rec.Body.Bytes(), &diff)
+	want := []diffLine{{"=", "Pricing"}, {"-", "Ten dollars"}, {"+", "Twenty dollars"}, {"=", "Contact"}}
+	if rec.Code != http.StatusOK || diff.From.ID != first || diff.To.ID != second || diff.Added != 1 || diff.Removed != 1 || !reflect.DeepEqual(diff.Lines, want) {
+		t.Fatalf("diff = %d %s", rec.Code, rec.Body)
+	}
+
+	rec = get("/api/v1/pages/" + first + "/diff?to=" + second + "&format=text")
+	if !strings.Contains(rec.Body.String(), "- Ten dollars\n+ Twenty dollars\n") {
+		t.Errorf("text diff = %s", rec.Body)
+	}
+	rec = get("/api/v1/pages/" + second + "/diff?from=" + first + "&format=html")
+	if !strings.Contains(rec.Body.String(), "<ins><p>Twenty dollars</p></ins>") {
+		t.Errorf("HTML diff = %s", rec.Body)
+	}
+
+	for path, code := range map[string]int{
+		"/api/v1/pages/" + first + "/diff":                 http.StatusNotFound,
+		"/api/v1/pages/" + second + "/diff?from=" + other:  http.StatusBadRequest,
+		"/api/v1/pages/" + second + "/diff?from=missing":   http.StatusNotFound,
+		"/api/v1/pages/" + second + "/diff?format=unified": http.StatusBadRequest,
+		"/api/v1/pages/missing/diff":                       http.StatusNotFound,
+	} {
+		if rec := get(path); rec.Code != code {
+			t.Errorf("GET %s = %d, want %d", path, rec.Code, code)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// DoNotIndexConfig keeps pages out of the archive, such as banking and
+// webmail: those on Domains or their subdomains, and those whose URL
+// matches one of Patterns, regular expressions.
+type DoNotIndexConfig struct {
+	Domains  []string `json:"domains"`
+	Patterns []string `json:"patterns"`
+
+	// patterns are the compiled Patterns, set by compile.
+	patterns []*regexp.Regexp
+}
+
+var errDoNotIndex = errors.New("URL is on the do-not-index list")
+
+// compile compiles the patterns once, when the config is loaded, rather
+// than for every URL checked.
+func (c *DoNotIndexConfig) compile() error {
+	patterns, err := compilePatterns("doNotIndex", c.Patterns)
+	if err != nil {
+		return err
+	}
+	c.patterns = patterns
+	return nil
+}
+
+// doNotIndex reports whether pages of pageURL are kept out of the archive.
+func doNotIndex(pageURL string) bool {
+	rules := config.DoNotIndex
+	if len(rules.Domains) == 0 && len(rules.patterns) == 0 {
+		return false
+	}
+	if u, err := url.Parse(pageURL); err == nil {
+		host := strings.TrimSuffix(strings.ToLower(u.Hostname()), ".")
+		for _, domain := range rules.Domains {
+			domain = strings.TrimPrefix(strings.ToLower(domain), ".")
+			if host == domain || strings.HasSuffix(host, "."+domain) {
+				return true
+			}
+		}
+	}
+	for _, re := range rules.patterns {
+		if re.MatchString(pageURL) {
+			return true
+		}
+	}
+	return false
+}
+
+// dropUnwantedCapture deletes a new capture, made in a private window or of
+// a URL on the do-not-index list, before it is first indexed, reporting
+// whether it did. The extension and other tools write pages straight into
+// the pages directory, so the daemon can't refuse them. Nothing of the page
+// is kept, not even in the changelog. Pages already admitted to the archive
+// are kept, so reindexing or editing them never deletes them. indexMu must
+// be held.
+func dropUnwantedCapture(id string, metadata PageMetadata) bool {
+	if metadata.Admitted || (!metadata.Incognito && !doNotIndex(metadata.URL)) {
+		return false
+	}
+	if err := removePage(Page{ID: id, PageMetadata: metadata}); err != nil {
+		log.Printf("Error dropping capture %s: %v", id, err)
+		return true
+	}
+	log.Printf("Dropped capture %s, which is not to be archived", id)
+	return true
+}
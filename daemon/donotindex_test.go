@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func withDoNotIndex(t *testing.T, rules DoNotIndexConfig) {
+	t.Helper()
+	if err := rules.compile(); err != nil {
+		t.Fatal(err)
+	}
+	saved := config.DoNotIndex
+	config.DoNotIndex = rules
+	t.Cleanup(func() { config.DoNotIndex = saved })
+}
+
+func TestDoNotIndex(t *testing.T) {
+	withDoNotIndex(t, DoNotIndexConfig{Domains: []string{"bank.example"}, Patterns: []string{`^https://mail\.[^/]+/`}})
+	for url, want := range map[string]bool{
+		"https://bank.example/accounts":        true,
+		"https://online.BANK.example./login":   true,
+		"https://notbank.example/":             false,
+		"https://mail.example.com/inbox":       true,
+		"https://example.com/mail.example.com": false,
+		"https://news.example/":                false,
+	} {
+		if got := doNotIndex(url); got != want {
+			t.Errorf("doNotIndex(%q) = %v, want %v", url, got, want)
+		}
+	}
+	rules := DoNotIndexConfig{Patterns: []string{"("}}
+	if err := rules.compile(); err == nil {
+		t.Error("invalid pattern accepted")
+	}
+}
+
+func TestDropUnwantedCaptures(t *testing.T) {
+	withPagesDir(t)
+	withIndex(t, nil)
+	kept, err := storePage(PageMetadata{URL: "https://news.example/", Title: "News"}, "", "Headlines of the day")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Archived before the rule was added
+	admitted, err := storePage(PageMetadata{URL: "https://bank.example/rates", Title: "Rates"}, "", "Interest rates")
+	if err != nil {
+		t.Fatal(err)
+	}
+	indexExistingFiles(context.Background())
+	incognito, err := storePage(PageMetadata{URL: "https://news.example/private", Title: "Private", Incognito: true}, "", "Read in a private window")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// As the extension writes pages, before the daemon sees them
+	banking, err := storePage(PageMetadata{URL: "https://bank.example/accounts", Title: "Accounts"}, "", "Balance")
+	if err != nil {
+		t.Fatal(err)
+	}
+	withDoNotIndex(t, DoNotIndexConfig{Domains: []string{"bank.example"}})
+
+	if err := reindexAllPages(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{kept, admitted} {
+		if page, err := loadPage(id); err != nil || !page.Indexed {
+			t.Errorf("kept page = %+v, %v", page, err)
+		}
+	}
+	for _, id := range []string{incognito, banking} {
+		if _, err := loadPage(id); err == nil {
+			t.Errorf("page %s was not dropped", id)
+		}
+	}
+
+	if _, err := storePage(PageMetadata{URL: "https://bank.example/statements"}, "", "Statement"); !errors.Is(err, errDoNotIndex) {
+		t.Errorf("storePage of a blocked URL = %v", err)
+	}
+	if _, err := archiveURL(context.Background(), "https://www.bank.example/", "", false); !errors.Is(err, errDoNotIndex) {
+		t.Errorf("archiveURL of a blocked URL = %v", err)
+	}
+}
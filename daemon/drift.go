@@ -0,0 +1,233 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// materialDrift is the drift from which a live page counts as having
+// changed from its capture, rather than only in a date or a counter.
+const materialDrift = 0.2
+
+// DriftCheck records how far a page's live version had moved from its
+// capture when last fetched again.
+type DriftCheck struct {
+	// TextHash is the hash of the live page's text, and Drift the share of
+	// its blocks of text, and the capture's, that only one of them has.
+	TextHash  string    `json:"textHash,omitempty"`
+	Drift     float64   `json:"drift"`
+	Error     string    `json:"error,omitempty"`
+	CheckedAt time.Time `json:"checkedAt"`
+}
+
+// changed reports whether the check found the page materially changed.
+func (c DriftCheck) changed() bool {
+	return c.Error == "" && c.Drift >= materialDrift
+}
+
+// textBlocks returns the hashes of the lines of text, leaving out the
+// site's boilerplate so that a changed menu or footer doesn't count.
+func textBlocks(text string, boilerplate map[string]bool) map[string]bool {
+	blocks := make(map[string]bool)
+	for _, line := range strings.Split(text, "\n") {
+		if line == "" {
+			continue
+		}
+		if hash := blockHash(line); !boilerplate[hash] {
+			blocks[hash] = true
+		}
+	}
+	return blocks
+}
+
+// textDrift compares the text of a capture with that of the live page, from
+// 0 when they have the same blocks to 1 when they share none.
+func textDrift(captured, live string, boilerplate map[string]bool) float64 {
+	before := textBlocks(captured, boilerplate)
+	after := textBlocks(live, boilerplate)
+	shared := 0
+	for hash := range before {
+		if after[hash] {
+			shared++
+		}
+	}
+	all := len(before) + len(after) - shared
+	if all == 0 {
+		return 0
+	}
+	return math.Round(1000*float64(all-shared)/float64(all)) / 1000
+}
+
+// checkDrift fetches a page's URL again and records how much its text
+// differs from the capture's. The page is indexed again so that searches
+// can filter on the result.
+func checkDrift(ctx context.Context, page Page) (DriftCheck, error) {
+	check := DriftCheck{CheckedAt: time.Now()}
+	captured, err := readFullText(page.PageMetadata)
+	if err != nil {
+		return check, err
+	}
+	content, mediaType, _, err := fetchPage(ctx, page.URL)
+	if err == nil {
+		live := ""
+		if mediaType == pdfMediaType {
+			pdf, pdfErr := extractPDF([]byte(content))
+			live, err = extractText(pdf.Text, false), pdfErr
+		} else {
+			live = extractText(content, true)
+		}
+		check.TextHash = contentHash([]byte(live))
+		if check.TextHash != contentHash([]byte(captured)) {
+			check.Drift = textDrift(captured, live, siteBoilerplate(page.URL))
+		}
+	}
+	if err != nil {
+		check.Error = err.Error()
+	}
+	return check, reindexWith(ctx, page.ID, func(metadata *PageMetadata) error {
+		metadata.DriftCheck = &check
+		return nil
+	})
+}
+
+// PageDrift is a page in the list of pages whose live versions changed.
+type PageDrift struct {
+	ID    string `json:"id"`
+	URL   string `json:"url"`
+	Title string `json:"title"`
+	DriftCheck
+}
+
+// handlePageDrift returns the last drift check of a page, first fetching
+// its URL again when check=1.
+func handlePageDrift(w http.ResponseWriter, r *http.Request) {
+	page, err := loadPage(r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusNotFound, ErrNotFound, "Page not found")
+		return
+	}
+	if r.URL.Query().Get("check") == "1" {
+		if !webURL(page.URL) {
+			writeError(w, http.StatusBadRequest, ErrInvalidRequest, "Only pages from the web can be checked")
+			return
+		}
+		check, err := checkDrift(r.Context(), page)
+		if err != nil {
+			log.Printf("Error recording drift check of %s: %v", page.ID, err)
+		}
+		page.DriftCheck = &check
+	}
+	if page.DriftCheck == nil {
+		writeError(w, http.StatusNotFound, ErrNotFound, "Page has not been checked")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(PageDrift{ID: page.ID, URL: page.URL, Title: page.Title, DriftCheck: *page.DriftCheck})
+}
+
+// driftCandidates returns the current versions of the web pages the
+// request may see, those never checked first and then the longest
+// unchecked.
+func driftCandidates(r *http.Request, limit int) ([]Page, error) {
+	pages, err := listPages()
+	if err != nil {
+		return nil, err
+	}
+	candidates := []Page{}
+	for _, page := range pages {
+		if page.SupersededBy == "" && webURL(page.URL) && pageVisible(r, page.PageMetadata) {
+			candidates = append(candidates, page)
+		}
+	}
+	checkedAt := func(page Page) time.Time {
+		if page.DriftCheck == nil {
+			return time.Time{}
+		}
+		return page.DriftCheck.CheckedAt
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return checkedAt(candidates[i]).Before(checkedAt(candidates[j]))
+	})
+	if limit > 0 && len(candidates) > limit {
+		candidates = candidates[:limit]
+	}
+	return candidates, nil
+}
+
+// handleDrift lists the pages whose live versions had drifted by at least
+// min, materialDrift by default, when last checked, most changed first. On
+// POST it checks up to limit pages again in the background, those never
+// or least recently checked first.
+func handleDrift(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+	limit, err := strconv.Atoi(params.Get("limit"))
+	if err != nil || limit <= 0 {
+		limit = defaultPageListLimit
+	}
+
+	if r.Method == http.MethodPost {
+		pages, err := driftCandidates(r, limit)
+		if err != nil {
+			log.Printf("Error listing pages: %v", err)
+			writeError(w, http.StatusInternalServerError, ErrInternal, "Failed to list pages")
+			return
+		}
+		go func() {
+			for _, page := range pages {
+				if _, err := checkDrift(context.Background(), page); err != nil {
+					log.Printf("Error checking %s for changes: %v", page.URL, err)
+				}
+			}
+		}()
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(map[string]int{"pages": len(pages)})
+		return
+	}
+
+	min := materialDrift
+	if v := params.Get("min"); v != "" {
+		if min, err = strconv.ParseFloat(v, 64); err != nil || min < 0 || min > 1 {
+			writeError(w, http.StatusBadRequest, ErrInvalidRequest, "min must be a number between 0 and 1")
+			return
+		}
+	}
+	pages, err := listPages()
+	if err != nil {
+		log.Printf("Error listing pages: %v", err)
+		writeError(w, http.StatusInternalServerError, ErrInternal, "Failed to list pages")
+		return
+	}
+	drifted := []PageDrift{}
+	for _, page := range pages {
+		c := page.DriftCheck
+		if c == nil || c.Error != "" || c.Drift < min || page.SupersededBy != "" || !pageVisible(r, page.PageMetadata) {
+			continue
+		}
+		drifted = append(drifted, PageDrift{ID: page.ID, URL: page.URL, Title: page.Title, DriftCheck: *c})
+	}
+	sort.SliceStable(drifted, func(i, j int) bool {
+		return drifted[i].Drift > drifted[j].Drift
+	})
+	if len(drifted) > limit {
+		drifted = drifted[:limit]
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(drifted)
+}
+
+// driftValue is what a page's drift is indexed as: 0 until it has been
+// checked successfully.
+func driftValue(check *DriftCheck) float64 {
+	if check == nil || check.Error != "" {
+		return 0
+	}
+	return check.Drift
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestTextDrift(t *testing.T) {
+	boilerplate := map[string]bool{blockHash("Home | About"): true}
+	tests := []struct {
+		name     string
+		captured string
+		live     string
+		want     float64
+	}{
+		{"same", "Title\nFirst paragraph", "Title\nFirst paragraph", 0},
+		{"reordered", "Title\nFirst paragraph", "First paragraph\nTitle", 0},
+		{"one of four blocks changed", "A\nB\nC\nD", "A\nB\nC\nE", 0.4},
+		{"rewritten", "A\nB", "C\nD", 1},
+		{"boilerplate only", "Home | About\nA", "A", 0},
+		{"both empty", "", "", 0},
+	}
+	for _, tt := range tests {
+		if got := textDrift(tt.captured, tt.live, boilerplate); got != tt.want {
+			t.Errorf("%s: textDrift = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestCheckDrift(t *testing.T) {
+	withPagesDir(t)
+	withIndex(t, nil)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		switch r.URL.Path {
+		case "/same":
+			w.Write([]byte("<p>Pricing</p><p>Ten dollars a month</p>"))
+		case "/changed":
+			w.Write([]byte("<p>Pricing</p><p>Twenty dollars a month</p>"))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+	// The test server is on a loopback address the archive client refuses
+	saved := archiveClient
+	archiveClient = server.Client()
+	defer func() { archiveClient = saved }()
+
+	ids := make(map[string]string)
+	for _, path := range []string{"/same", "/changed", "/gone"} {
+		id, err := storePage(PageMetadata{URL: server.URL + path, Title: path},
+			"<p>Pricing</p><p>Ten dollars a month</p>", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids[path] = id
+	}
+	indexExistingFiles(context.Background())
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/pages/{id}/drift", handlePageDrift)
+	mux.HandleFunc("/drift", handleDrift)
+	for path, want := range map[string]float64{"/same": 0, "/changed": 2.0 / 3} {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/pages/"+ids[path]+"/drift?check=1", nil))
+		var drift PageDrift
+		json.Unmarshal(rec.Body.Bytes(), &drift)
+		if rec.Code != http.StatusOK || drift.Drift < want-0.001 || drift.Drift > want+0.001 {
+			t.Errorf("%s: drift check = %d %s, want drift %.3f", path, rec.Code, rec.Body, want)
+		}
+	}
+	gone, _ := loadPage(ids["/gone"])
+	if check, _ := checkDrift(context.Background(), gone); check.Error == "" || check.changed() {
+		t.Errorf("unreachable page: check = %+v, want an error and no change", check)
+	}
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/drift", nil))
+	var drifted []PageDrift
+	json.Unmarshal(rec.Body.Bytes(), &drifted)
+	if len(drifted) != 1 || drifted[0].ID != ids["/changed"] {
+		t.Errorf("GET /drift = %s, want only the changed page", rec.Body)
+	}
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/drift?min=2", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("GET /drift?min=2 = %d, want 400", rec.Code)
+	}
+
+	if got, want := searchURLs(t, "pricing", SearchOptions{Changed: true}), []string{server.URL + "/changed"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("changed search = %v, want %v", got, want)
+	}
+	if got := searchURLs(t, "pricing", SearchOptions{}); len(got) != 3 {
+		t.Errorf("search = %v, want all three pages", got)
+	}
+}
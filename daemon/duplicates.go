@@ -0,0 +1,156 @@
+package main
+
+import (
+	"encoding/json"
+	"hash/fnv"
+	"log"
+	"math/bits"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+const (
+	// minSimHashWords is how long a text must be for its SimHash to say
+	// anything about it.
+	minSimHashWords = 20
+	// nearDuplicateDistance is how many of their 64 bits the SimHashes of
+	// two near-duplicate texts may differ in.
+	nearDuplicateDistance = 3
+)
+
+// SimilarPage is a capture whose text is a near-duplicate of another's,
+// Distance bits of SimHash away.
+type SimilarPage struct {
+	ID       string    `json:"id"`
+	URL      string    `json:"url"`
+	Title    string    `json:"title"`
+	Time     time.Time `json:"time"`
+	Distance int       `json:"distance"`
+}
+
+// simHash returns the SimHash of text: each bit is set when more of the
+// text's words have it set in their hash than not, so that texts using
+// mostly the same words get hashes differing in few bits, while a few
+// words added, such as a syndicated copy's byline, hardly change it. It is
+// "" for texts too short to compare.
+func simHash(text string) string {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	if len(words) < minSimHashWords {
+		return ""
+	}
+	var weights [64]int
+	for _, word := range words {
+		h := fnv.New64a()
+		h.Write([]byte(word))
+		sum := h.Sum64()
+		for bit := 0; bit < 64; bit++ {
+			if sum&(1<<uint(bit)) != 0 {
+				weights[bit]++
+			} else {
+				weights[bit]--
+			}
+		}
+	}
+	var hash uint64
+	for bit, weight := range weights {
+		if weight > 0 {
+			hash |= 1 << uint(bit)
+		}
+	}
+	return strconv.FormatUint(hash, 16)
+}
+
+// simHashDistance returns how many bits two SimHashes differ in, or -1 if
+// either is missing.
+func simHashDistance(a, b string) int {
+	x, err := strconv.ParseUint(a, 16, 64)
+	if err != nil {
+		return -1
+	}
+	y, err := strconv.ParseUint(b, 16, 64)
+	if err != nil {
+		return -1
+	}
+	return bits.OnesCount64(x ^ y)
+}
+
+// nearDuplicate reports whether two SimHashes are of near-duplicate texts.
+func nearDuplicate(a, b string) bool {
+	d := simHashDistance(a, b)
+	return d >= 0 && d <= nearDuplicateDistance
+}
+
+// collapseDuplicates drops the results that are near-duplicates of a
+// higher one, listing them in its Duplicates instead.
+func collapseDuplicates(results []SearchResult) []SearchResult {
+	collapsed := results[:0]
+	for _, result := range results {
+		duplicate := false
+		for i := range collapsed {
+			if nearDuplicate(result.simHash, collapsed[i].simHash) {
+				collapsed[i].Duplicates = append(collapsed[i].Duplicates, result.ID)
+				duplicate = true
+				break
+			}
+		}
+		if !duplicate {
+			collapsed = append(collapsed, result)
+		}
+	}
+	return collapsed
+}
+
+// similarPagesTo returns the other captures the user can see whose text is
+// a near-duplicate of page's, closest first, leaving out private pages
+// unless privateListed. Pages indexed before
+// SimHashes were recorded have none until they are indexed again.
+func similarPagesTo(r *http.Request, page Page) ([]SimilarPage, error) {
+	similar := []SimilarPage{}
+	if page.SimHash == "" {
+		return similar, nil
+	}
+	pages, err := listPages()
+	if err != nil {
+		return nil, err
+	}
+	private := privateListed(r)
+	for _, other := range pages {
+		if other.ID == page.ID || (other.Private && !private) || !pageVisible(r, other.PageMetadata) {
+			continue
+		}
+		if d := simHashDistance(page.SimHash, other.SimHash); d >= 0 && d <= nearDuplicateDistance {
+			similar = append(similar, SimilarPage{ID: other.ID, URL: other.URL, Title: other.Title, Time: other.Timestamp, Distance: d})
+		}
+	}
+	sort.Slice(similar, func(i, j int) bool {
+		if similar[i].Distance != similar[j].Distance {
+			return similar[i].Distance < similar[j].Distance
+		}
+		return similar[i].Time.Before(similar[j].Time)
+	})
+	return similar, nil
+}
+
+// handlePageSimilar lists the near-duplicates of a page, such as the same
+// article syndicated on other sites or captured again.
+func handlePageSimilar(w http.ResponseWriter, r *http.Request) {
+	page, err := loadPage(r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusNotFound, ErrNotFound, "Page not found")
+		return
+	}
+	similar, err := similarPagesTo(r, page)
+	if err != nil {
+		log.Printf("Error finding pages similar to %s: %v", page.ID, err)
+		writeError(w, http.StatusInternalServerError, ErrInternal, "Failed to list pages")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"pages": similar})
+}
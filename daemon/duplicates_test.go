@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+const (
+	bikeLanes = `The city council voted on Tuesday to expand the bike lane network across the downtown core, adding twelve miles of protected lanes over the next three years. Supporters said the plan would make cycling safer for commuters and reduce traffic on the busiest streets, while some business owners worried about losing parking spaces in front of their shops. The first phase will begin in the spring along Main Street and Harbor Avenue, and the council asked the transportation department to report back on progress every six months.`
+	sourdough = `Sourdough bread is leavened by a starter of wild yeast and lactic acid bacteria rather than commercial yeast. Bakers feed the starter with flour and water every day to keep it active, and a long, slow fermentation gives the loaf its tangy flavour and open crumb. Many home bakers keep their starter in the fridge between bakes and take it out the night before to wake it up.`
+)
+
+func TestSimHash(t *testing.T) {
+	original := simHash(bikeLanes)
+	if d := simHashDistance(original, simHash("Reprinted with permission from the Ledger.\n"+bikeLanes)); d < 0 || d > nearDuplicateDistance {
+		t.Errorf("distance to a syndicated copy = %d", d)
+	}
+	if d := simHashDistance(original, simHash(sourdough)); d <= nearDuplicateDistance {
+		t.Errorf("distance to another article = %d", d)
+	}
+	if hash := simHash("Too short"); hash != "" || nearDuplicate(hash, hash) {
+		t.Errorf("hash of a short text = %q", hash)
+	}
+}
+
+func TestNearDuplicates(t *testing.T) {
+	withPagesDir(t)
+	withIndex(t, nil)
+	ids := map[string]string{}
+	for name, page := range map[string]struct{ url, text string }{
+		"original":   {"https://news.example/bike-lanes", bikeLanes},
+		"syndicated": {"https://ledger.example/2024/bike-lanes", "Reprinted with permission from the Ledger.\n" + bikeLanes},
+		"other":      {"https://bread.example/", sourdough},
+	} {
+		id, err := storePage(PageMetadata{URL: page.url, Title: name}, "", page.text)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids[name] = id
+	}
+	indexExistingFiles(context.Background())
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/pages/{id}/similar", handlePageSimilar)
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/pages/"+ids["original"]+"/similar", nil))
+	var similar struct {
+		Pages []SimilarPage `json:"pages"`
+	}
+	json.NewDecoder(rec.Body).Decode(&similar)
+	if rec.Code != http.StatusOK || len(similar.Pages) != 1 || similar.Pages[0].ID != ids["syndicated"] {
+		t.Errorf("similar pages = %d %+v", rec.Code, similar.Pages)
+	}
+
+	search := func(collapse bool) []SearchResult {
+		t.Helper()
+		results, err := searchPages(context.Background(), "council", SearchOptions{Highlight: "none", Sort: "score", Collapse: collapse}, Localizer{Lang: defaultLocale})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return results
+	}
+	if results := search(false); len(results) != 2 {
+		t.Errorf("results = %+v", results)
+	}
+	if results := search(true); len(results) != 1 || len(results[0].Duplicates) != 1 || results[0].Duplicates[0] == results[0].ID {
+		t.Errorf("collapsed results = %+v", results)
+	}
+}
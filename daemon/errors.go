@@ -0,0 +1,55 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+)
+
+// ErrorCode identifies a class of failure so clients can react to it without
+// parsing messages.
+type ErrorCode string
+
+const (
+	ErrCaptureTooLarge    ErrorCode = "CAPTURE_TOO_LARGE"
+	ErrUnsupportedContent ErrorCode = "UNSUPPORTED_CONTENT"
+	ErrIndexUnavailable   ErrorCode = "INDEX_UNAVAILABLE"
+	ErrDuplicate          ErrorCode = "DUPLICATE"
+	ErrInvalidRequest     ErrorCode = "INVALID_REQUEST"
+	ErrNotFound           ErrorCode = "NOT_FOUND"
+	ErrMethodNotAllowed   ErrorCode = "METHOD_NOT_ALLOWED"
+	ErrActionDisabled     ErrorCode = "ACTION_DISABLED"
+	ErrUnauthorized       ErrorCode = "UNAUTHORIZED"
+	ErrForbidden          ErrorCode = "FORBIDDEN"
+	ErrQuotaExceeded      ErrorCode = "QUOTA_EXCEEDED"
+	ErrRateLimited        ErrorCode = "RATE_LIMITED"
+	ErrModelUnavailable   ErrorCode = "MODEL_UNAVAILABLE"
+	ErrInternal           ErrorCode = "INTERNAL"
+)
+
+// maxCaptureBytes bounds the size of a single page submitted to the daemon.
+const maxCaptureBytes = 50 << 20
+
+type APIError struct {
+	Code    ErrorCode `json:"code"`
+	Message string    `json:"message"`
+}
+
+type errorResponse struct {
+	Error APIError `json:"error"`
+}
+
+// writeError sends a JSON error envelope of the form
+// {"error": {"code": "...", "message": "..."}}.
+func writeError(w http.ResponseWriter, status int, code ErrorCode, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(errorResponse{Error: APIError{Code: code, Message: message}})
+}
+
+// isTooLarge reports whether err came from exceeding an http.MaxBytesReader
+// limit.
+func isTooLarge(err error) bool {
+	var maxBytesErr *http.MaxBytesError
+	return errors.As(err, &maxBytesErr)
+}
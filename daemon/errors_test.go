@@ -0,0 +1,73 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// decodeError reads the error envelope of a response.
+func decodeError(t *testing.T, rec *httptest.ResponseRecorder) APIError {
+	t.Helper()
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("Content-Type = %q, want application/json", ct)
+	}
+	var body errorResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("error body %q: %v", rec.Body, err)
+	}
+	return body.Error
+}
+
+func TestWriteError(t *testing.T) {
+	rec := httptest.NewRecorder()
+	writeError(rec, http.StatusNotFound, ErrNotFound, "Page not found")
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("status = %d, want 404", rec.Code)
+	}
+	if got := decodeError(t, rec); got.Code != ErrNotFound || got.Message != "Page not found" {
+		t.Errorf("error = %+v", got)
+	}
+	if !strings.Contains(rec.Body.String(), `"code":"NOT_FOUND"`) {
+		t.Errorf("body = %s", rec.Body)
+	}
+}
+
+func TestIsTooLarge(t *testing.T) {
+	r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader("0123456789"))
+	body := http.MaxBytesReader(httptest.NewRecorder(), r.Body, 4)
+	_, err := ioutil.ReadAll(body)
+	if !isTooLarge(err) {
+		t.Errorf("isTooLarge(%v) = false", err)
+	}
+	if !isTooLarge(fmt.Errorf("reading: %w", err)) {
+		t.Error("wrapped MaxBytesError not recognized")
+	}
+	if isTooLarge(fmt.Errorf("other")) {
+		t.Error("unrelated error reported as too large")
+	}
+}
+
+func TestSearchErrorCodes(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+	}{
+		{"missing query", ""},
+		{"unparseable query", `"unterminated`},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		handleSearch(rec, httptest.NewRequest(http.MethodGet, "/search?q="+tt.query, nil))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: status = %d, want 400", tt.name, rec.Code)
+		}
+		if got := decodeError(t, rec); got.Code != ErrInvalidRequest {
+			t.Errorf("%s: code = %s, want %s", tt.name, got.Code, ErrInvalidRequest)
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	eventPageCaptured = "page.captured"
+	eventPageIndexed  = "page.indexed"
+	eventPageDeleted  = "page.deleted"
+	eventError        = "error"
+
+	eventBufferSize   = 64
+	eventPingInterval = 30 * time.Second
+)
+
+// Event is a notification about a page streamed to /events subscribers.
+type Event struct {
+	Type    string    `json:"type"`
+	PageID  string    `json:"pageId,omitempty"`
+	URL     string    `json:"url,omitempty"`
+	Title   string    `json:"title,omitempty"`
+	Message string    `json:"message,omitempty"`
+	Time    time.Time `json:"time"`
+	seq     uint64
+}
+
+// eventBroker fans events out to subscribers. Slow subscribers miss events
+// rather than holding up capture and indexing.
+type eventBroker struct {
+	mu          sync.Mutex
+	seq         uint64
+	subscribers map[chan Event]struct{}
+}
+
+var events = &eventBroker{subscribers: make(map[chan Event]struct{})}
+
+func (b *eventBroker) subscribe() chan Event {
+	ch := make(chan Event, eventBufferSize)
+	b.mu.Lock()
+	b.subscribers[ch] = struct{}{}
+	b.mu.Unlock()
+	return ch
+}
+
+func (b *eventBroker) unsubscribe(ch chan Event) {
+	b.mu.Lock()
+	delete(b.subscribers, ch)
+	b.mu.Unlock()
+}
+
+func (b *eventBroker) publish(event Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.seq++
+	event.seq = b.seq
+	event.Time = time.Now()
+	for ch := range b.subscribers {
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+}
+
+// publishPageEvent announces a change to a stored page.
+func publishPageEvent(eventType, id string, metadata PageMetadata) {
+	events.publish(Event{Type: eventType, PageID: id, URL: metadata.URL, Title: metadata.Title})
+}
+
+// publishError announces a failure, optionally tied to a page.
+func publishError(id string, err error) {
+	events.publish(Event{Type: eventError, PageID: id, Message: err.Error()})
+}
+
+// handleEvents streams events to the client as Server-Sent Events until it
+// disconnects. A comment line is sent periodically to keep proxies from
+// closing the idle connection.
+func handleEvents(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, ErrMethodNotAllowed, "Method not allowed")
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, ErrInternal, "Streaming unsupported")
+		return
+	}
+
+	ch := events.subscribe()
+	defer events.unsubscribe(ch)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, ": connected\n\n")
+	flusher.Flush()
+
+	ping := time.NewTicker(eventPingInterval)
+	defer ping.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-ping.C:
+			fmt.Fprint(w, ": ping\n\n")
+		case event := <-ch:
+			data, err := json.Marshal(event)
+			if err != nil {
+				log.Printf("Error encoding event: %v", err)
+				continue
+			}
+			fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.seq, event.Type, data)
+		}
+		flusher.Flush()
+	}
+}
+
+// sweepOnIndex runs sweep, then again whenever a page is indexed and at
+// least every interval. Pages the sweep itself indexes again don't set it
+// off another time.
+func sweepOnIndex(interval time.Duration, sweep func()) {
+	ch := events.subscribe()
+	for {
+		sweep()
+		for len(ch) > 0 {
+			<-ch
+		}
+		timeout := time.After(interval)
+	wait:
+		for {
+			select {
+			case event := <-ch:
+				if event.Type == eventPageIndexed {
+					break wait
+				}
+			case <-timeout:
+				break wait
+			}
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestEventBrokerDropsForSlowSubscribers(t *testing.T) {
+	broker := &eventBroker{subscribers: make(map[chan Event]struct{})}
+	ch := broker.subscribe()
+	for i := 0; i < eventBufferSize+10; i++ {
+		broker.publish(Event{Type: eventPageIndexed})
+	}
+	if len(ch) != eventBufferSize {
+		t.Errorf("buffered %d events, want %d", len(ch), eventBufferSize)
+	}
+	if event := <-ch; event.seq != 1 || event.Time.IsZero() {
+		t.Errorf("first event = %+v", event)
+	}
+
+	broker.unsubscribe(ch)
+	for len(ch) > 0 {
+		<-ch
+	}
+	broker.publish(Event{Type: eventPageDeleted})
+	if len(ch) != 0 {
+		t.Error("unsubscribed channel still receives events")
+	}
+}
+
+func TestHandleEvents(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(handleEvents))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("Content-Type = %q", ct)
+	}
+	reader := bufio.NewReader(resp.Body)
+	// The connected comment is flushed once the handler has subscribed
+	if line, err := reader.ReadString('\n'); err != nil || line != ": connected\n" {
+		t.Fatalf("first line = %q, %v", line, err)
+	}
+	reader.ReadString('\n')
+
+	publishPageEvent(eventPageCaptured, "page_1", PageMetadata{URL: "https://example.com/", Title: "Example"})
+	publishError("page_2", errors.New("disk full"))
+
+	for _, want := range []Event{
+		{Type: eventPageCaptured, PageID: "page_1", URL: "https://example.com/", Title: "Example"},
+		{Type: eventError, PageID: "page_2", Message: "disk full"},
+	} {
+		var lines []string
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				t.Fatal(err)
+			}
+			if line == "\n" {
+				break
+			}
+			lines = append(lines, strings.TrimSuffix(line, "\n"))
+		}
+		if len(lines) != 3 || !strings.HasPrefix(lines[0], "id: ") || lines[1] != "event: "+want.Type {
+			t.Fatalf("event lines = %q", lines)
+		}
+		var got Event
+		if err := json.Unmarshal([]byte(strings.TrimPrefix(lines[2], "data: ")), &got); err != nil {
+			t.Fatal(err)
+		}
+		got.Time = want.Time
+		if got != want {
+			t.Errorf("event = %+v, want %+v", got, want)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	handleEvents(rec, httptest.NewRequest(http.MethodPost, "/events", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("POST: status = %d, want 405", rec.Code)
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// maxKeepDays bounds how long an expiring capture can be kept; longer than
+// that, it may as well be kept for good.
+const maxKeepDays = 3650
+
+// expiryAfter returns when a capture kept for days from now expires, or nil
+// for zero days, meaning it is kept for good.
+func expiryAfter(days int, now time.Time) *time.Time {
+	if days <= 0 {
+		return nil
+	}
+	expiresAt := now.AddDate(0, 0, days)
+	return &expiresAt
+}
+
+// validKeepDays reports whether days is a usable keepDays value.
+func validKeepDays(days int) bool {
+	return days >= 0 && days <= maxKeepDays
+}
+
+// expired reports whether a page is past its expiry and not starred.
+func expired(page Page, now time.Time) bool {
+	return page.ExpiresAt != nil && !page.ExpiresAt.After(now) && !page.Starred
+}
+
+// setExpiry marks a newly captured page to be removed after days, if any.
+func setExpiry(id string, days int) error {
+	if days == 0 {
+		return nil
+	}
+	indexMu.Lock()
+	defer indexMu.Unlock()
+	metadata, err := readMetadata(id)
+	if err != nil {
+		return err
+	}
+	metadata.ExpiresAt = expiryAfter(days, time.Now())
+	return writeMetadata(id, metadata)
+}
+
+// keepDaysParam reads the keepDays query parameter of a PDF upload.
+func keepDaysParam(r *http.Request) (int, bool) {
+	value := r.URL.Query().Get("keepDays")
+	if value == "" {
+		return 0, true
+	}
+	days, err := strconv.Atoi(value)
+	return days, err == nil && validKeepDays(days)
+}
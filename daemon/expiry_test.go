@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExpiredCaptures(t *testing.T) {
+	withPagesDir(t)
+	withIndex(t, nil)
+	now := time.Now()
+	store := func(url string, expiresAt *time.Time, starred bool) string {
+		t.Helper()
+		id, err := storePage(PageMetadata{URL: url, Title: url, ExpiresAt: expiresAt, Starred: starred}, "<p>"+url+"</p>", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return id
+	}
+	lapsed := store("https://shop.example/price", &now, false)
+	starred := store("https://events.example/gig", &now, true)
+	future := store("https://events.example/fair", expiryAfter(30, now), false)
+	kept := store("https://example.com/essay", nil, false)
+
+	report, err := applyRetention(RetentionConfig{}, now, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Removed) != 1 || report.Removed[0].ID != lapsed || report.Removed[0].Reason != "expired" {
+		t.Errorf("removed = %+v, want only %s", report.Removed, lapsed)
+	}
+	for _, id := range []string{starred, future, kept} {
+		if _, err := loadPage(id); err != nil {
+			t.Errorf("%s was removed: %v", id, err)
+		}
+	}
+
+	// The expired page went to the trash and can be brought back
+	router := newRouter()
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/trash", nil))
+	var trashed []TrashedPage
+	json.Unmarshal(rec.Body.Bytes(), &trashed)
+	if len(trashed) != 1 || trashed[0].ID != lapsed {
+		t.Fatalf("trash = %s", rec.Body)
+	}
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/trash/"+lapsed+"/restore", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("restore = %d: %s", rec.Code, rec.Body)
+	}
+	if _, err := loadPage(lapsed); err != nil {
+		t.Errorf("restored page: %v", err)
+	}
+}
+
+func TestUpdatePage(t *testing.T) {
+	withPagesDir(t)
+	withIndex(t, nil)
+	id, err := storePage(PageMetadata{URL: "https://example.com/a", Title: "A"}, "<p>A</p>", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name        string
+		body        string
+		want        int
+		wantStarred bool
+		wantRead    bool
+		wantExpiry  bool
+	}{
+		{"keep a week", `{"keepDays": 7}`, http.StatusOK, false, false, true},
+		{"star", `{"starred": true}`, http.StatusOK, true, false, true},
+		{"keep for good", `{"keepDays": 0}`, http.StatusOK, true, false, false},
+		{"mark read", `{"read": true}`, http.StatusOK, true, true, false},
+		{"unstar and unread", `{"starred": false, "read": false}`, http.StatusOK, false, false, false},
+		{"negative days", `{"keepDays": -1}`, http.StatusBadRequest, false, false, false},
+		{"too many days", `{"keepDays": 100000}`, http.StatusBadRequest, false, false, false},
+		{"invalid body", `{`, http.StatusBadRequest, false, false, false},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodPatch, "/pages/"+id, strings.NewReader(tt.body))
+		req.SetPathValue("id", id)
+		w := httptest.NewRecorder()
+		handlePage(w, req)
+		if w.Code != tt.want {
+			t.Errorf("%s: status = %d, want %d: %s", tt.name, w.Code, tt.want, w.Body)
+			continue
+		}
+		page, _ := loadPage(id)
+		if page.Starred != tt.wantStarred || page.Read != tt.wantRead || (page.ExpiresAt != nil) != tt.wantExpiry || !page.Indexed {
+			t.Errorf("%s: got %+v", tt.name, page.PageMetadata)
+		}
+	}
+}
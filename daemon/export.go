@@ -0,0 +1,283 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	exportManifestName = "manifest.json"
+	exportPagesPrefix  = "pages/"
+	exportVersion      = 1
+)
+
+// exportManifest describes the contents of an export bundle. It is written as
+// the last entry so that file hashes can be computed while streaming.
+type exportManifest struct {
+	Version   int                   `json:"version"`
+	CreatedAt time.Time             `json:"createdAt"`
+	Pages     []exportManifestEntry `json:"pages"`
+}
+
+type exportManifestEntry struct {
+	ID        string       `json:"id"`
+	URL       string       `json:"url"`
+	Title     string       `json:"title"`
+	Timestamp time.Time    `json:"timestamp"`
+	Files     []exportFile `json:"files"`
+}
+
+type exportFile struct {
+	Name   string `json:"name"`
+	Size   int    `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// bundleWriter hides the difference between the tar.gz and zip formats.
+// Create starts a file of size bytes, whose contents are written to the
+// returned writer before the next file is started.
+type bundleWriter interface {
+	Create(name string, size int64, modTime time.Time) (io.Writer, error)
+	Close() error
+}
+
+type tarGzBundleWriter struct {
+	gz *gzip.Writer
+	tw *tar.Writer
+}
+
+func (b *tarGzBundleWriter) Create(name string, size int64, modTime time.Time) (io.Writer, error) {
+	header := &tar.Header{Name: name, Mode: 0644, Size: size, ModTime: modTime}
+	if err := b.tw.WriteHeader(header); err != nil {
+		return nil, err
+	}
+	return b.tw, nil
+}
+
+func (b *tarGzBundleWriter) Close() error {
+	if err := b.tw.Close(); err != nil {
+		return err
+	}
+	return b.gz.Close()
+}
+
+type zipBundleWriter struct {
+	zw *zip.Writer
+}
+
+func (b *zipBundleWriter) Create(name string, size int64, modTime time.Time) (io.Writer, error) {
+	return b.zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: modTime})
+}
+
+func (b *zipBundleWriter) Close() error {
+	return b.zw.Close()
+}
+
+// addBundleData adds a file holding data to a bundle.
+func addBundleData(bundle bundleWriter, name string, data []byte, modTime time.Time) error {
+	w, err := bundle.Create(name, int64(len(data)), modTime)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+// addPageFile streams a file of the pages directory into a bundle, hashing
+// it on the way, and returns its manifest entry. A missing file is skipped,
+// reporting false.
+func addPageFile(bundle bundleWriter, name string, modTime time.Time) (exportFile, bool, error) {
+	f, err := os.Open(filepath.Join(pagesDir, filepath.FromSlash(name)))
+	if os.IsNotExist(err) {
+		return exportFile{}, false, nil
+	}
+	if err != nil {
+		return exportFile{}, false, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return exportFile{}, false, err
+	}
+	w, err := bundle.Create(exportPagesPrefix+name, info.Size(), modTime)
+	if err != nil {
+		return exportFile{}, false, err
+	}
+	hash := sha256.New()
+	n, err := io.Copy(io.MultiWriter(w, hash), f)
+	if err != nil {
+		return exportFile{}, false, err
+	}
+	return exportFile{Name: name, Size: int(n), SHA256: hex.EncodeToString(hash.Sum(nil))}, true, nil
+}
+
+func newBundleWriter(w io.Writer, format string) (bundleWriter, error) {
+	switch format {
+	case "tar.gz", "tgz", "":
+		gz := gzip.NewWriter(w)
+		return &tarGzBundleWriter{gz: gz, tw: tar.NewWriter(gz)}, nil
+	case "zip":
+		return &zipBundleWriter{zw: zip.NewWriter(w)}, nil
+	default:
+		return nil, fmt.Errorf("unsupported export format %q", format)
+	}
+}
+
+func validateExportFormat(format string) error {
+	switch format {
+	case "", "tar.gz", "tgz", "zip", "karakeep", "warc":
+		return nil
+	}
+	return fmt.Errorf("unsupported export format %q", format)
+}
+
+func exportExtension(format string) string {
+	switch format {
+	case "zip":
+		return "zip"
+	case "karakeep":
+		return "json"
+	case "warc":
+		return "warc.gz"
+	}
+	return "tar.gz"
+}
+
+// writeExport streams every stored page (metadata, HTML and markdown files)
+// followed by a manifest into an export bundle.
+func writeExport(w io.Writer, format string) error {
+	switch format {
+	case "karakeep":
+		return writeKarakeepExport(w)
+	case "warc":
+		return writeWARCExport(w)
+	}
+	bundle, err := newBundleWriter(w, format)
+	if err != nil {
+		return err
+	}
+
+	pages, err := listPages()
+	if err != nil {
+		return err
+	}
+
+	manifest := exportManifest{Version: exportVersion, CreatedAt: time.Now().UTC()}
+	for _, page := range pages {
+		entry := exportManifestEntry{
+			ID:        page.ID,
+			URL:       page.URL,
+			Title:     page.Title,
+			Timestamp: page.Timestamp,
+		}
+
+		for _, name := range exportFileNames(page) {
+			file, ok, err := addPageFile(bundle, name, page.Timestamp)
+			if err != nil {
+				return err
+			}
+			if ok {
+				entry.Files = append(entry.Files, file)
+			}
+		}
+		manifest.Pages = append(manifest.Pages, entry)
+	}
+
+	manifestBytes, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := addBundleData(bundle, exportManifestName, manifestBytes, manifest.CreatedAt); err != nil {
+		return err
+	}
+	return bundle.Close()
+}
+
+// exportFileNames lists the files of a page that go into a bundle, relative
+// to the pages directory: its metadata, content, screenshot, PDF and assets.
+func exportFileNames(page Page) []string {
+	names := []string{page.ID + ".json"}
+	if page.HTMLFilename != "" {
+		names = append(names, page.HTMLFilename)
+	}
+	if page.HasMarkdown && page.MDFilename != "" {
+		names = append(names, page.MDFilename)
+	}
+	for _, name := range []string{page.ScreenshotFilename, page.PDFFilename} {
+		if name != "" {
+			names = append(names, name)
+		}
+	}
+	assets, _ := ioutil.ReadDir(assetsDir(page.ID))
+	for _, asset := range assets {
+		if !asset.IsDir() {
+			names = append(names, page.ID+"_files/"+asset.Name())
+		}
+	}
+	return names
+}
+
+func handleExport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, ErrMethodNotAllowed, "Method not allowed")
+		return
+	}
+	format := r.URL.Query().Get("format")
+	if err := validateExportFormat(format); err != nil {
+		writeError(w, http.StatusBadRequest, ErrUnsupportedContent, err.Error())
+		return
+	}
+
+	filename := fmt.Sprintf("memento-export-%s.%s", time.Now().Format("20060102"), exportExtension(format))
+	switch format {
+	case "zip":
+		w.Header().Set("Content-Type", "application/zip")
+	case "karakeep":
+		w.Header().Set("Content-Type", "application/json")
+	case "warc":
+		w.Header().Set("Content-Type", "application/warc")
+	default:
+		w.Header().Set("Content-Type", "application/gzip")
+	}
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	if err := writeExport(w, format); err != nil {
+		// Headers are already sent, so the client sees a truncated bundle
+		log.Printf("Export failed: %v", err)
+	}
+}
+
+func runExportCommand(args []string) {
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	format := flags.String("format", "tar.gz", "bundle format: tar.gz, zip, karakeep or warc")
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "Usage: daemon export [-format tar.gz|zip|karakeep|warc] <file|->")
+		os.Exit(2)
+	}
+
+	out := os.Stdout
+	if path := flags.Arg(0); path != "-" {
+		f, err := os.Create(path)
+		if err != nil {
+			log.Fatalf("Error creating export file: %v", err)
+		}
+		defer f.Close()
+		out = f
+	}
+	if err := writeExport(out, *format); err != nil {
+		log.Fatalf("Export failed: %v", err)
+	}
+}
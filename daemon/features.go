@@ -0,0 +1,116 @@
+package main
+
+import "fmt"
+
+// feature is a subsystem that config can turn off as a whole: the
+// endpoints it serves, by their patterns, the background work it runs and
+// the kinds of jobs it starts.
+type feature struct {
+	id     string
+	routes []string
+	watch  func()
+	jobs   []string
+}
+
+// features lists the subsystems that can be turned off. Capture, search,
+// pages and the maintenance endpoints are the core and always on.
+var features = []feature{
+	{id: "alerts", routes: []string{"/saved-searches/{id}/alert"}, watch: watchSearchAlerts},
+	{id: "ask", routes: []string{"/ask"}},
+	{id: "annotations", routes: []string{"/pages/{id}/annotations", "/pages/{id}/annotations/{annotation}"}},
+	{id: "autoTags", watch: watchAutoTags},
+	{id: "bookmarks", routes: []string{"/bookmarks", "/bookmarks/{id}/capture"}},
+	{id: "backups", watch: watchBackups},
+	{id: "boilerplate", watch: watchBoilerplate},
+	{id: "changes", routes: []string{"/changes"}, watch: watchChanges},
+	{id: "clicks", routes: []string{"/search/clicks"}, watch: watchClickBoosts},
+	{id: "crawl", routes: []string{"/archive/site", "/archive/site/{id}"}, jobs: []string{jobSite}},
+	{id: "drift", routes: []string{"/drift", "/pages/{id}/drift"}},
+	{id: "duplicates", routes: []string{"/pages/{id}/similar"}},
+	{id: "feed", routes: []string{"/feed"}},
+	{id: "feeds", routes: []string{"/feeds", "/feeds/{id}", "/feeds/{id}/poll"}, watch: watchFeeds},
+	{id: "gitExport", watch: watchGitExport},
+	{id: "highlights", routes: []string{"/highlights", "/pages/{id}/highlights", "/pages/{id}/highlights/{highlight}"}},
+	{id: "history", routes: []string{"/history/searches", "/history/searches/stats"}, watch: watchSearchHistory},
+	{id: "linkCheck", routes: []string{"/deadlinks"}, watch: watchLinkChecks},
+	{id: "opds", routes: []string{"/opds", "/opds/pages/{id}"}},
+	{id: "recapture", routes: []string{"/recapture", "/recapture/queue", "/pages/{id}/recapture"}},
+	{id: "recrawl", routes: []string{"/recrawl"}, watch: watchRecrawl},
+	{id: "related", routes: []string{"/pages/{id}/related"}},
+	{id: "reports", routes: []string{"/reports"}, watch: watchReports},
+	{id: "retention", routes: []string{"/retention"}, watch: watchRetention},
+	{id: "savedSearches", routes: []string{"/saved-searches", "/saved-searches/{id}", "/saved-searches/{id}/results", "/saved-searches/{id}/alert"}},
+	{id: "semantic", routes: []string{"/search/semantic"}, watch: watchSemantic},
+	{id: "summaries", watch: watchSummaries},
+	{id: "trash", routes: []string{"/trash", "/trash/{id}/restore"}, watch: watchTrash},
+	{id: "text", routes: []string{"/{$}", "/text", "/text/open", "/opensearch.xml"}},
+	{id: "viewer", routes: []string{"/pages/{id}/viewer", "/pdfjs/"}},
+	{id: "watch", watch: watchDirs},
+}
+
+// featureEnabled reports whether a feature hasn't been turned off in
+// config.
+func featureEnabled(id string) bool {
+	enabled, ok := config.Features[id]
+	return !ok || enabled
+}
+
+// routeEnabled reports whether the endpoint with a pattern belongs to no
+// feature that is turned off.
+func routeEnabled(pattern string) bool {
+	for _, f := range features {
+		for _, route := range f.routes {
+			if route == pattern && !featureEnabled(f.id) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// jobKindEnabled reports whether jobs of a kind belong to no feature that
+// is turned off.
+func jobKindEnabled(kind string) bool {
+	for _, f := range features {
+		for _, k := range f.jobs {
+			if k == kind && !featureEnabled(f.id) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// startFeatures starts the background work of the features that are on.
+func startFeatures() {
+	for _, f := range features {
+		if f.watch != nil && featureEnabled(f.id) {
+			go f.watch()
+		}
+	}
+}
+
+// enabledFeatures returns the IDs of the features that are on.
+func enabledFeatures() []string {
+	ids := []string{}
+	for _, f := range features {
+		if featureEnabled(f.id) {
+			ids = append(ids, f.id)
+		}
+	}
+	return ids
+}
+
+// validateFeatures checks that config only names known features.
+func validateFeatures(configured map[string]bool) error {
+	for id := range configured {
+		known := false
+		for _, f := range features {
+			known = known || f.id == id
+		}
+		if !known {
+			return fmt.Errorf("features: unknown feature %q", id)
+		}
+	}
+	return nil
+}
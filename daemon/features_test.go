@@ -0,0 +1,43 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestFeatures(t *testing.T) {
+	withPagesDir(t)
+	saved := config.Features
+	config.Features = map[string]bool{"feeds": false, "crawl": false, "opds": true}
+	defer func() { config.Features = saved }()
+
+	router := newRouter()
+	for path, want := range map[string]int{
+		"/api/v1/feeds":        http.StatusNotFound,
+		"/feeds/abc":           http.StatusNotFound,
+		"/api/v1/archive/site": http.StatusNotFound,
+		"/api/v1/opds":         http.StatusOK,
+		"/api/v1/pages":        http.StatusOK,
+	} {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		if rec.Code != want {
+			t.Errorf("GET %s = %d, want %d", path, rec.Code, want)
+		}
+	}
+	if jobKindEnabled(jobSite) || !jobKindEnabled(jobReindex) {
+		t.Error("site jobs should be off and reindexes on")
+	}
+	for _, id := range enabledFeatures() {
+		if id == "feeds" || id == "crawl" {
+			t.Errorf("%s is listed as enabled", id)
+		}
+	}
+	if err := validateFeatures(map[string]bool{"feeds": false}); err != nil {
+		t.Error(err)
+	}
+	if err := validateFeatures(map[string]bool{"capture": false}); err == nil {
+		t.Error("an unknown feature was accepted")
+	}
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// localInstance labels this daemon's own results in federated searches.
+const localInstance = "local"
+
+const peerSearchTimeout = 10 * time.Second
+
+// unreachablePeersHeader lists the peers a federated search couldn't search,
+// whose results are missing.
+const unreachablePeersHeader = "X-Unreachable-Peers"
+
+// Peers are configured by the admin and are often on the local network, so
+// unlike captures they may be on private addresses.
+var peerHTTPClient = &http.Client{Timeout: peerSearchTimeout}
+
+// PeerConfig is another memento instance that federated searches also
+// search, with Token if it requires one.
+type PeerConfig struct {
+	Name  string `json:"name"`
+	URL   string `json:"url"`
+	Token Secret `json:"token"`
+}
+
+func validatePeers(peers []PeerConfig) error {
+	names := map[string]bool{localInstance: true}
+	for i, peer := range peers {
+		if peer.Name == "" || names[peer.Name] {
+			return fmt.Errorf("peers[%d] needs a unique name other than %q", i, localInstance)
+		}
+		names[peer.Name] = true
+		if u, err := url.Parse(peer.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("peers[%d].url must be an absolute http(s) URL", i)
+		}
+	}
+	return nil
+}
+
+// federatedParam reports whether a search asks for peers' results too.
+func federatedParam(r *http.Request) bool {
+	value := r.URL.Query().Get("federated")
+	return value == "1" || value == "true"
+}
+
+// searchPeer runs a search on a peer with the same parameters, and labels
+// its results with the peer's name. Thumbnails are linked on the peer.
+func searchPeer(ctx context.Context, peer PeerConfig, params url.Values, language string) ([]SearchResult, error) {
+	base := strings.TrimRight(peer.URL, "/")
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, base+apiPrefix+"/search?"+params.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	if !peer.Token.IsZero() {
+		token, err := peer.Token.Value()
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	if language != "" {
+		req.Header.Set("Accept-Language", language)
+	}
+	resp, err := peerHTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("searching %s: %s", peer.Name, resp.Status)
+	}
+	var results []SearchResult
+	if err := json.NewDecoder(resp.Body).Decode(&results); err != nil {
+		return nil, fmt.Errorf("searching %s: %v", peer.Name, err)
+	}
+	for i := range results {
+		results[i].Instance = peer.Name
+		if strings.HasPrefix(results[i].Thumbnail, "/") {
+			results[i].Thumbnail = base + results[i].Thumbnail
+		}
+	}
+	return results, nil
+}
+
+// federate adds the results of every peer to the local results of a search,
+// returning the names of the peers that couldn't be searched.
+func federate(ctx context.Context, r *http.Request, local []SearchResult, sortOrder string) ([]SearchResult, []string) {
+	params := r.URL.Query()
+	params.Del("federated")
+	for i := range local {
+		local[i].Instance = localInstance
+	}
+
+	sets := make([][]SearchResult, len(config.Peers)+1)
+	sets[0] = local
+	failed := make([]string, len(config.Peers))
+	var wg sync.WaitGroup
+	for i, peer := range config.Peers {
+		wg.Add(1)
+		go func(i int, peer PeerConfig) {
+			defer wg.Done()
+			results, err := searchPeer(ctx, peer, params, r.Header.Get("Accept-Language"))
+			if err != nil {
+				log.Printf("Error searching peer %s: %v", peer.Name, err)
+				failed[i] = peer.Name
+				return
+			}
+			sets[i+1] = results
+		}(i, peer)
+	}
+	wg.Wait()
+
+	var unreachable []string
+	for _, name := range failed {
+		if name != "" {
+			unreachable = append(unreachable, name)
+		}
+	}
+	return mergeResults(sets, sortOrder), unreachable
+}
+
+// mergeResults combines the results of several instances in the search's
+// order, up to searchResultLimit. Scores from different indexes can't be
+// compared, so results by relevance are taken in turns, each instance's
+// best first.
+func mergeResults(sets [][]SearchResult, sortOrder string) []SearchResult {
+	var merged []SearchResult
+	if sortOrder == "score" {
+		for rank := 0; ; rank++ {
+			more := false
+			for _, set := range sets {
+				if rank < len(set) {
+					merged = append(merged, set[rank])
+					more = true
+				}
+			}
+			if !more {
+				break
+			}
+		}
+	} else {
+		for _, set := range sets {
+			merged = append(merged, set...)
+		}
+		sort.SliceStable(merged, func(i, j int) bool {
+			switch sortOrder {
+			case "time":
+				return merged[i].Time.Before(merged[j].Time)
+			case "-time":
+				return merged[i].Time.After(merged[j].Time)
+			default:
+				return merged[i].Title < merged[j].Title
+			}
+		})
+	}
+	if len(merged) > searchResultLimit {
+		merged = merged[:searchResultLimit]
+	}
+	if merged == nil {
+		merged = []SearchResult{}
+	}
+	return merged
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestValidatePeers(t *testing.T) {
+	tests := []struct {
+		name    string
+		peers   []PeerConfig
+		wantErr bool
+	}{
+		{"none", nil, false},
+		{"valid", []PeerConfig{{Name: "work", URL: "https://memento.example.com"}, {Name: "home", URL: "http://10.0.0.2:8080"}}, false},
+		{"missing name", []PeerConfig{{URL: "https://memento.example.com"}}, true},
+		{"duplicate name", []PeerConfig{{Name: "work", URL: "https://a.example.com"}, {Name: "work", URL: "https://b.example.com"}}, true},
+		{"local name", []PeerConfig{{Name: localInstance, URL: "https://memento.example.com"}}, true},
+		{"relative url", []PeerConfig{{Name: "work", URL: "/api"}}, true},
+		{"other scheme", []PeerConfig{{Name: "work", URL: "ftp://memento.example.com"}}, true},
+	}
+	for _, tt := range tests {
+		if err := validatePeers(tt.peers); (err != nil) != tt.wantErr {
+			t.Errorf("%s: validatePeers() = %v, wantErr %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestMergeResults(t *testing.T) {
+	day := func(d int) time.Time { return time.Date(2024, 3, d, 0, 0, 0, 0, time.UTC) }
+	local := []SearchResult{{Title: "b", Time: day(3), Score: 0.2}, {Title: "d", Time: day(1), Score: 0.1}}
+	peer := []SearchResult{{Title: "a", Time: day(2), Score: 9}, {Title: "c", Time: day(4), Score: 8}, {Title: "e", Time: day(5), Score: 7}}
+	tests := []struct {
+		sort string
+		want []string
+	}{
+		{"score", []string{"b", "a", "d", "c", "e"}},
+		{"time", []string{"d", "a", "b", "c", "e"}},
+		{"-time", []string{"e", "c", "b", "a", "d"}},
+		{"title", []string{"a", "b", "c", "d", "e"}},
+	}
+	for _, tt := range tests {
+		var got []string
+		for _, result := range mergeResults([][]SearchResult{local, peer}, tt.sort) {
+			got = append(got, result.Title)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("mergeResults(%s) = %v, want %v", tt.sort, got, tt.want)
+		}
+	}
+}
+
+func TestFederatedSearch(t *testing.T) {
+	withPagesDir(t)
+	withIndex(t, map[string]PageDocument{"l1": {URL: "https://example.com/local", Title: "Local zeppelin", Content: "zeppelin"}})
+
+	var gotAuth, gotQuery string
+	peer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotAuth, gotQuery = r.Header.Get("Authorization"), r.URL.RawQuery
+		json.NewEncoder(w).Encode([]SearchResult{{ID: "p1", URL: "https://example.com/work", Title: "Work zeppelin", Thumbnail: "/pages/p1/thumbnail"}})
+	}))
+	defer peer.Close()
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "down", http.StatusServiceUnavailable)
+	}))
+	defer down.Close()
+
+	saved := config.Peers
+	defer func() { config.Peers = saved }()
+	config.Peers = []PeerConfig{{Name: "work", URL: peer.URL + "/", Token: NewSecret("s3cret")}, {Name: "old", URL: down.URL}}
+
+	rec := httptest.NewRecorder()
+	newRouter().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/search?q=zeppelin&federated=true", nil))
+	var results []SearchResult
+	if err := json.Unmarshal(rec.Body.Bytes(), &results); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("federated search = %d %s", rec.Code, rec.Body)
+	}
+	instances := make(map[string]string)
+	for _, result := range results {
+		instances[result.URL] = result.Instance
+	}
+	if want := map[string]string{"https://example.com/local": localInstance, "https://example.com/work": "work"}; !reflect.DeepEqual(instances, want) {
+		t.Errorf("result instances = %v, want %v", instances, want)
+	}
+	for _, result := range results {
+		if result.Instance == "work" && result.Thumbnail != peer.URL+"/pages/p1/thumbnail" {
+			t.Errorf("peer thumbnail = %q, want it on the peer", result.Thumbnail)
+		}
+	}
+	if gotAuth != "Bearer s3cret" || gotQuery != "q=zeppelin" {
+		t.Errorf("peer request = %q %q, want the token and the query without federated", gotAuth, gotQuery)
+	}
+	if got := rec.Header().Get(unreachablePeersHeader); got != "old" {
+		t.Errorf("%s = %q, want the failing peer", unreachablePeersHeader, got)
+	}
+
+	// Without federated=true, peers aren't searched
+	gotQuery = ""
+	rec = httptest.NewRecorder()
+	newRouter().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/search?q=zeppelin", nil))
+	if gotQuery != "" || rec.Header().Get(unreachablePeersHeader) != "" {
+		t.Errorf("plain search reached the peers")
+	}
+}
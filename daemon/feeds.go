@@ -0,0 +1,586 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+const feedsFile = "memento_feeds.json"
+
+const (
+	defaultFeedInterval = 60
+	minFeedInterval     = 5
+	feedCheckInterval   = time.Minute
+	// maxFeedBytes bounds the feed documents fetched.
+	maxFeedBytes = 10 << 20
+)
+
+// Feed is an RSS or Atom feed whose new entries are archived as they are
+// published, polled every IntervalMinutes.
+type Feed struct {
+	ID              string   `json:"id"`
+	URL             string   `json:"url"`
+	Title           string   `json:"title"`
+	Tags            []string `json:"tags,omitempty"`
+	IntervalMinutes int      `json:"intervalMinutes"`
+	// Owner is the user who added the feed, whose archive entries go to.
+	Owner   string    `json:"owner,omitempty"`
+	Created time.Time `json:"created"`
+
+	// LastPolled is when the feed was last fetched, and LastError why that
+	// failed, if it did. Archived counts the entries archived so far.
+	LastPolled time.Time `json:"lastPolled"`
+	LastError  string    `json:"lastError,omitempty"`
+	Archived   int       `json:"archived"`
+	// ETag and LastModified validate the last fetch, so unchanged feeds
+	// aren't downloaded again. Seen holds the keys of the entries in the
+	// feed then that were handled.
+	ETag         string   `json:"etag,omitempty"`
+	LastModified string   `json:"lastModified,omitempty"`
+	Seen         []string `json:"seen,omitempty"`
+}
+
+// due reports whether the feed should be polled at now.
+func (f Feed) due(now time.Time) bool {
+	return now.Sub(f.LastPolled) >= time.Duration(f.IntervalMinutes)*time.Minute
+}
+
+// feedEntry is an item of an RSS feed or an entry of an Atom feed. Key
+// identifies it across polls: its GUID or ID, or else its link.
+type feedEntry struct {
+	Key   string
+	URL   string
+	Title string
+}
+
+type rssItem struct {
+	Title string `xml:"title"`
+	Link  string `xml:"link"`
+	GUID  string `xml:"guid"`
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr"`
+}
+
+type atomEntry struct {
+	ID    string     `xml:"id"`
+	Title string     `xml:"title"`
+	Links []atomLink `xml:"link"`
+}
+
+// feedDocument matches RSS 2.0 (<rss><channel>), RSS 1.0 (<rdf:RDF>, with
+// items beside the channel) and Atom (<feed>) documents.
+type feedDocument struct {
+	XMLName xml.Name
+	Title   string `xml:"title"`
+	Channel struct {
+		Title string    `xml:"title"`
+		Items []rssItem `xml:"item"`
+	} `xml:"channel"`
+	Items   []rssItem   `xml:"item"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+// latin1Reader decodes ISO-8859-1, the legacy charset feeds still commonly
+// declare, which encoding/xml can't read itself. Windows-1252 is read as
+// it too, which only gets a few punctuation marks wrong.
+func latin1Reader(charset string, input io.Reader) (io.Reader, error) {
+	switch strings.ToLower(charset) {
+	case "iso-8859-1", "latin1", "us-ascii", "windows-1252":
+	default:
+		return nil, fmt.Errorf("unsupported charset %s", charset)
+	}
+	data, err := ioutil.ReadAll(input)
+	if err != nil {
+		return nil, err
+	}
+	decoded := make([]byte, 0, len(data))
+	for _, b := range data {
+		decoded = utf8.AppendRune(decoded, rune(b))
+	}
+	return bytes.NewReader(decoded), nil
+}
+
+// parseFeed reads a feed's title and entries, resolving their links
+// against the feed's URL. Entries without a web link are left out.
+func parseFeed(data []byte, feedURL string) (string, []feedEntry, error) {
+	var doc feedDocument
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	decoder.CharsetReader = latin1Reader
+	if err := decoder.Decode(&doc); err != nil {
+		return "", nil, fmt.Errorf("not a feed: %v", err)
+	}
+	base, _ := url.Parse(feedURL)
+	resolve := func(link string) string {
+		// An entry without a link would otherwise resolve to the feed itself
+		link = strings.TrimSpace(link)
+		u, err := url.Parse(link)
+		if link == "" || err != nil || base == nil {
+			return ""
+		}
+		return base.ResolveReference(u).String()
+	}
+
+	var title string
+	var entries []feedEntry
+	switch doc.XMLName.Local {
+	case "rss", "RDF":
+		title = doc.Channel.Title
+		for _, item := range append(doc.Channel.Items, doc.Items...) {
+			link := item.Link
+			if link == "" && webURL(strings.TrimSpace(item.GUID)) {
+				link = item.GUID
+			}
+			entries = append(entries, feedEntry{Key: strings.TrimSpace(item.GUID), URL: resolve(link), Title: item.Title})
+		}
+	case "feed":
+		title = doc.Title
+		for _, entry := range doc.Entries {
+			var link string
+			for _, l := range entry.Links {
+				if l.Rel == "" || l.Rel == "alternate" {
+					link = l.Href
+					break
+				}
+			}
+			entries = append(entries, feedEntry{Key: strings.TrimSpace(entry.ID), URL: resolve(link), Title: entry.Title})
+		}
+	default:
+		return "", nil, fmt.Errorf("not a feed: <%s> document", doc.XMLName.Local)
+	}
+
+	kept := entries[:0]
+	for _, entry := range entries {
+		if !webURL(entry.URL) {
+			continue
+		}
+		if entry.Key == "" {
+			entry.Key = entry.URL
+		}
+		entry.Title = strings.TrimSpace(entry.Title)
+		kept = append(kept, entry)
+	}
+	return strings.TrimSpace(title), kept, nil
+}
+
+// fetchFeed downloads a feed, returning nil data when the server says it
+// hasn't changed since the fetch the feed's validators are from.
+func fetchFeed(ctx context.Context, feed Feed) ([]byte, http.Header, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, feed.URL, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	req.Header.Set("User-Agent", "memento/1.0 (+https://github.com/nascarsayan/memento)")
+	req.Header.Set("Accept", "application/rss+xml, application/atom+xml, application/rdf+xml, application/xml, text/xml")
+	if feed.ETag != "" {
+		req.Header.Set("If-None-Match", feed.ETag)
+	}
+	if feed.LastModified != "" {
+		req.Header.Set("If-Modified-Since", feed.LastModified)
+	}
+	resp, err := archiveClient.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotModified {
+		return nil, resp.Header, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, nil, fmt.Errorf("fetching %s: %s", feed.URL, resp.Status)
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxFeedBytes+1))
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(data) > maxFeedBytes {
+		return nil, nil, fmt.Errorf("feed is larger than %d bytes", maxFeedBytes)
+	}
+	return data, resp.Header, nil
+}
+
+// archiveFeedEntry captures an entry through the archiver, tagged with the
+// feed's tags. Entries already archived count as handled.
+func archiveFeedEntry(ctx context.Context, feed Feed, entry feedEntry) (bool, error) {
+	id, err := archiveURL(ctx, entry.URL, feed.Owner, false)
+	if errors.Is(err, errDuplicate) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if config.Capture.Assets {
+		if _, err := captureAssets(ctx, id); err != nil {
+			log.Printf("Error storing assets of %s: %v", entry.URL, err)
+		}
+	}
+	return true, reindexWith(ctx, id, func(metadata *PageMetadata) error {
+		metadata.Source = "feed"
+		metadata.Tags = feed.Tags
+		if metadata.Title == entry.URL && entry.Title != "" {
+			metadata.Title = entry.Title
+		}
+		return nil
+	})
+}
+
+// feedPollMu keeps the watcher and polls requested through the API from
+// archiving the same entries at once.
+var feedPollMu sync.Mutex
+
+// pollFeed fetches a feed and archives the entries it hasn't seen,
+// returning the feed's new state and how many entries were archived.
+// Entries that fail to archive for a reason that may pass are tried again
+// at the next poll.
+func pollFeed(ctx context.Context, feed Feed, now time.Time) (Feed, int) {
+	feedPollMu.Lock()
+	defer feedPollMu.Unlock()
+	feed.LastPolled, feed.LastError = now, ""
+	data, header, err := fetchFeed(ctx, feed)
+	if err != nil {
+		feed.LastError = err.Error()
+		return feed, 0
+	}
+	if data == nil {
+		return feed, 0
+	}
+	title, entries, err := parseFeed(data, feed.URL)
+	if err != nil {
+		feed.LastError = err.Error()
+		return feed, 0
+	}
+	if title != "" {
+		feed.Title = title
+	}
+
+	seen := make(map[string]bool)
+	for _, key := range feed.Seen {
+		seen[key] = true
+	}
+	archived := 0
+	var handled []string
+	for _, entry := range entries {
+		if seen[entry.Key] {
+			handled = append(handled, entry.Key)
+			continue
+		}
+		ok, err := archiveFeedEntry(ctx, feed, entry)
+		if err != nil && !errors.Is(err, errUnsupportedContent) && !errors.Is(err, errCaptureTooLarge) {
+			log.Printf("Error archiving %s from feed %s: %v", entry.URL, feed.URL, err)
+			feed.LastError = fmt.Sprintf("archiving %s: %v", entry.URL, err)
+			continue
+		}
+		handled = append(handled, entry.Key)
+		if ok {
+			archived++
+		}
+	}
+	// Validators are only kept once every entry is handled, so a failed
+	// entry isn't skipped as unchanged next time
+	if feed.LastError == "" {
+		feed.ETag, feed.LastModified = header.Get("ETag"), header.Get("Last-Modified")
+	} else {
+		feed.ETag, feed.LastModified = "", ""
+	}
+	feed.Seen = handled
+	feed.Archived += archived
+	return feed, archived
+}
+
+var (
+	feedsMu    sync.Mutex
+	errFeedDup = errors.New("that feed has already been added")
+)
+
+// readFeeds loads every feed, oldest first. feedsMu must be held.
+func readFeeds() ([]Feed, error) {
+	data, err := ioutil.ReadFile(feedsFile)
+	if os.IsNotExist(err) {
+		return []Feed{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var feeds []Feed
+	if err := json.Unmarshal(data, &feeds); err != nil {
+		return nil, fmt.Errorf("parsing %s: %v", feedsFile, err)
+	}
+	return feeds, nil
+}
+
+// writeFeeds replaces the feeds file atomically. feedsMu must be held.
+func writeFeeds(feeds []Feed) error {
+	data, err := json.MarshalIndent(feeds, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := feedsFile + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, feedsFile)
+}
+
+// findFeed returns the feed with the given ID.
+func findFeed(id string) (Feed, bool, error) {
+	feedsMu.Lock()
+	defer feedsMu.Unlock()
+	feeds, err := readFeeds()
+	if err != nil {
+		return Feed{}, false, err
+	}
+	for _, feed := range feeds {
+		if feed.ID == id {
+			return feed, true, nil
+		}
+	}
+	return Feed{}, false, nil
+}
+
+// saveFeedState records the state of a polled feed, unless it was deleted
+// meanwhile.
+func saveFeedState(polled Feed) error {
+	feedsMu.Lock()
+	defer feedsMu.Unlock()
+	feeds, err := readFeeds()
+	if err != nil {
+		return err
+	}
+	for i, feed := range feeds {
+		if feed.ID == polled.ID {
+			feeds[i].Title, feeds[i].LastPolled, feeds[i].LastError, feeds[i].Archived = polled.Title, polled.LastPolled, polled.LastError, polled.Archived
+			feeds[i].ETag, feeds[i].LastModified, feeds[i].Seen = polled.ETag, polled.LastModified, polled.Seen
+			return writeFeeds(feeds)
+		}
+	}
+	return nil
+}
+
+// pollFeeds polls every feed due at now. The feeds are polled without
+// holding feedsMu, so adding and deleting feeds isn't held up.
+func pollFeeds(ctx context.Context, now time.Time) {
+	feedsMu.Lock()
+	feeds, err := readFeeds()
+	feedsMu.Unlock()
+	if err != nil {
+		log.Printf("Error reading feeds: %v", err)
+		return
+	}
+	for _, feed := range feeds {
+		if !feed.due(now) {
+			continue
+		}
+		polled, archived := pollFeed(ctx, feed, now)
+		if polled.LastError != "" {
+			log.Printf("Error polling feed %s: %s", feed.URL, polled.LastError)
+		} else if archived > 0 {
+			log.Printf("Archived %d new entries of feed %s", archived, feed.URL)
+		}
+		if err := saveFeedState(polled); err != nil {
+			log.Printf("Error saving feed state: %v", err)
+		}
+	}
+}
+
+// watchFeeds polls due feeds every minute.
+func watchFeeds() {
+	for {
+		time.Sleep(feedCheckInterval)
+		pollFeeds(context.Background(), time.Now())
+	}
+}
+
+// userFeed wraps a handler of a /feeds/{id} route so that feeds other
+// users added are reported as not found.
+func userFeed(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if usersEnabled() {
+			if feed, ok, err := findFeed(r.PathValue("id")); err == nil && ok && feed.Owner != requestUser(r) {
+				writeError(w, http.StatusNotFound, ErrNotFound, "Feed not found")
+				return
+			}
+		}
+		next(w, r)
+	}
+}
+
+type feedRequest struct {
+	URL             string   `json:"url"`
+	Tags            []string `json:"tags"`
+	IntervalMinutes int      `json:"intervalMinutes"`
+}
+
+// handleFeeds lists feeds by title, or adds one from {"url": ...} plus
+// optional tags and intervalMinutes. The feed is fetched once to check it
+// is one; never polled, it is due at once, so the watcher archives its
+// entries within a minute.
+func handleFeeds(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		feedsMu.Lock()
+		feeds, err := readFeeds()
+		feedsMu.Unlock()
+		if err != nil {
+			log.Printf("Error reading feeds: %v", err)
+			writeError(w, http.StatusInternalServerError, ErrInternal, "Failed to read feeds")
+			return
+		}
+		visible := []Feed{}
+		for _, feed := range feeds {
+			if !usersEnabled() || feed.Owner == requestUser(r) {
+				visible = append(visible, feed)
+			}
+		}
+		sort.SliceStable(visible, func(i, j int) bool {
+			return strings.ToLower(visible[i].Title) < strings.ToLower(visible[j].Title)
+		})
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(visible)
+		return
+	}
+
+	var request feedRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		writeError(w, http.StatusBadRequest, ErrInvalidRequest, "Invalid request body")
+		return
+	}
+	if !webURL(request.URL) {
+		writeError(w, http.StatusBadRequest, ErrInvalidRequest, "url must be an http(s) URL")
+		return
+	}
+	if request.IntervalMinutes == 0 {
+		request.IntervalMinutes = defaultFeedInterval
+	}
+	if request.IntervalMinutes < minFeedInterval {
+		writeError(w, http.StatusBadRequest, ErrInvalidRequest, fmt.Sprintf("intervalMinutes must be at least %d", minFeedInterval))
+		return
+	}
+	feed := Feed{URL: request.URL, Tags: request.Tags, IntervalMinutes: request.IntervalMinutes, Owner: requestUser(r)}
+	data, _, err := fetchFeed(r.Context(), feed)
+	if err == nil {
+		feed.Title, _, err = parseFeed(data, feed.URL)
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, ErrInvalidRequest, err.Error())
+		return
+	}
+	if feed.Title == "" {
+		feed.Title = feed.URL
+	}
+
+	feedsMu.Lock()
+	feeds, err := readFeeds()
+	if err == nil {
+		for _, existing := range feeds {
+			if existing.Owner == feed.Owner && existing.URL == feed.URL {
+				err = errFeedDup
+			}
+		}
+	}
+	if err == nil {
+		id := make([]byte, 8)
+		rand.Read(id)
+		feed.ID, feed.Created = hex.EncodeToString(id), time.Now()
+		err = writeFeeds(append(feeds, feed))
+	}
+	feedsMu.Unlock()
+	if err == errFeedDup {
+		writeError(w, http.StatusConflict, ErrDuplicate, err.Error())
+		return
+	}
+	if err != nil {
+		log.Printf("Error adding feed: %v", err)
+		writeError(w, http.StatusInternalServerError, ErrInternal, "Failed to add feed")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(feed)
+}
+
+// handleFeed returns or deletes a single feed. Pages already archived from
+// a deleted feed are kept.
+func handleFeed(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if r.Method == http.MethodGet {
+		feed, ok, err := findFeed(id)
+		if err != nil {
+			log.Printf("Error reading feeds: %v", err)
+			writeError(w, http.StatusInternalServerError, ErrInternal, "Failed to read feeds")
+			return
+		}
+		if !ok {
+			writeError(w, http.StatusNotFound, ErrNotFound, "Feed not found")
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(feed)
+		return
+	}
+
+	feedsMu.Lock()
+	feeds, err := readFeeds()
+	found := false
+	if err == nil {
+		for i, feed := range feeds {
+			if feed.ID == id {
+				found = true
+				err = writeFeeds(append(feeds[:i], feeds[i+1:]...))
+				break
+			}
+		}
+	}
+	feedsMu.Unlock()
+	if err != nil {
+		log.Printf("Error deleting feed %s: %v", id, err)
+		writeError(w, http.StatusInternalServerError, ErrInternal, "Failed to delete feed")
+		return
+	}
+	if !found {
+		writeError(w, http.StatusNotFound, ErrNotFound, "Feed not found")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleFeedPoll polls a feed now, responding with its new state and how
+// many entries were archived.
+func handleFeedPoll(w http.ResponseWriter, r *http.Request) {
+	feed, ok, err := findFeed(r.PathValue("id"))
+	if err != nil {
+		log.Printf("Error reading feeds: %v", err)
+		writeError(w, http.StatusInternalServerError, ErrInternal, "Failed to read feeds")
+		return
+	}
+	if !ok {
+		writeError(w, http.StatusNotFound, ErrNotFound, "Feed not found")
+		return
+	}
+	polled, archived := pollFeed(r.Context(), feed, time.Now())
+	if err := saveFeedState(polled); err != nil {
+		log.Printf("Error saving feed state: %v", err)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		Archived int  `json:"archived"`
+		Feed     Feed `json:"feed"`
+	}{archived, polled})
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseFeed(t *testing.T) {
+	tests := []struct {
+		name  string
+		feed  string
+		title string
+		want  []feedEntry
+	}{
+		{
+			"RSS 2.0",
+			`<?xml version="1.0"?><rss version="2.0"><channel><title> Blog </title>
+<item><title>First</title><link>https://example.com/first</link><guid isPermaLink="false">post-1</guid></item>
+<item><title>Relative</title><link>/second</link></item>
+<item><title>Permalink</title><guid>https://example.com/third</guid></item>
+<item><title>No link</title><description>Just text</description></item>
+</channel></rss>`,
+			"Blog",
+			[]feedEntry{
+				{Key: "post-1", URL: "https://example.com/first", Title: "First"},
+				{Key: "https://example.com/second", URL: "https://example.com/second", Title: "Relative"},
+				{Key: "https://example.com/third", URL: "https://example.com/third", Title: "Permalink"},
+			},
+		},
+		{
+			"Atom",
+			`<feed xmlns="http://www.w3.org/2005/Atom"><title>News</title>
+<entry><id>urn:1</id><title>One</title><link rel="self" href="https://example.com/api/1"/><link href="https://example.com/1"/></entry>
+<entry><id>urn:2</id><title>Two</title><link rel="alternate" href="2"/></entry>
+</feed>`,
+			"News",
+			[]feedEntry{
+				{Key: "urn:1", URL: "https://example.com/1", Title: "One"},
+				{Key: "urn:2", URL: "https://example.com/feeds/2", Title: "Two"},
+			},
+		},
+		{
+			"RSS 1.0",
+			`<rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#" xmlns="http://purl.org/rss/1.0/">
+<channel><title>Journal</title></channel>
+<item><title>Paper</title><link>https://example.com/paper</link></item>
+</rdf:RDF>`,
+			"Journal",
+			[]feedEntry{{Key: "https://example.com/paper", URL: "https://example.com/paper", Title: "Paper"}},
+		},
+		{
+			"Latin-1",
+			"<?xml version=\"1.0\" encoding=\"ISO-8859-1\"?><rss><channel><title>Caf\xe9</title></channel></rss>",
+			"Café",
+			nil,
+		},
+	}
+	for _, tt := range tests {
+		title, entries, err := parseFeed([]byte(tt.feed), "https://example.com/feeds/")
+		if err != nil || title != tt.title || !reflect.DeepEqual(entries, tt.want) {
+			t.Errorf("%s: parseFeed() = %q, %+v, %v", tt.name, title, entries, err)
+		}
+	}
+	if _, _, err := parseFeed([]byte("<html><body>Not a feed</body></html>"), "https://example.com/"); err == nil {
+		t.Error("parseFeed() accepted an HTML page")
+	}
+}
+
+func TestFeeds(t *testing.T) {
+	withPagesDir(t)
+	withIndex(t, nil)
+	entries := []string{"one"}
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/feed.xml":
+			w.Header().Set("Content-Type", "application/rss+xml")
+			fmt.Fprint(w, `<rss><channel><title>Blog</title>`)
+			for _, entry := range entries {
+				fmt.Fprintf(w, `<item><title>%[1]s</title><link>%[2]s/posts/%[1]s</link></item>`, entry, server.URL)
+			}
+			fmt.Fprint(w, `</channel></rss>`)
+		case "/posts/one", "/posts/two":
+			w.Header().Set("Content-Type", "text/html")
+			fmt.Fprintf(w, "<title>Post %s</title><p>Body of %s</p>", r.URL.Path, r.URL.Path)
+		default:
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+	saved := archiveClient
+	archiveClient = server.Client()
+	defer func() { archiveClient = saved }()
+
+	request := func(method, path, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(method, path, bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		newRouter().ServeHTTP(rec, req)
+		return rec
+	}
+	rec := request(http.MethodPost, "/api/v1/feeds", `{"url": "`+server.URL+`/feed.xml", "tags": ["blogs"]}`)
+	var feed Feed
+	json.Unmarshal(rec.Body.Bytes(), &feed)
+	if rec.Code != http.StatusCreated || feed.Title != "Blog" || feed.IntervalMinutes != defaultFeedInterval {
+		t.Fatalf("add feed = %d %s", rec.Code, rec.Body)
+	}
+	for body, code := range map[string]int{
+		`{"url": "` + server.URL + `/feed.xml"}`:                       http.StatusConflict,
+		`{"url": "` + server.URL + `/posts/one"}`:                      http.StatusBadRequest,
+		`{"url": "` + server.URL + `/down"}`:                           http.StatusBadRequest,
+		`{"url": "ftp://example.com/feed"}`:                            http.StatusBadRequest,
+		`{"url": "` + server.URL + `/feed.xml", "intervalMinutes": 1}`: http.StatusBadRequest,
+	} {
+		if rec := request(http.MethodPost, "/api/v1/feeds", body); rec.Code != code {
+			t.Errorf("add feed %s = %d, want %d", body, rec.Code, code)
+		}
+	}
+
+	// A new feed is due at once, and its entries are archived
+	pollFeeds(context.Background(), time.Now())
+	feed, _, _ = findFeed(feed.ID)
+	pages, _ := listPages()
+	if feed.Archived != 1 || feed.LastError != "" || len(pages) != 1 {
+		t.Fatalf("after first poll: feed %+v, %d pages", feed, len(pages))
+	}
+	if page := pages[0]; page.URL != server.URL+"/posts/one" || page.Source != "feed" || !reflect.DeepEqual(page.Tags, []string{"blogs"}) {
+		t.Errorf("archived entry = %+v", page.PageMetadata)
+	}
+
+	// Until it's due again, nothing is fetched
+	entries = []string{"two", "one", "three"}
+	pollFeeds(context.Background(), time.Now())
+	if pages, _ := listPages(); len(pages) != 1 {
+		t.Errorf("feed polled before it was due")
+	}
+	rec = request(http.MethodPost, "/api/v1/feeds/"+feed.ID+"/poll", "")
+	var result struct {
+		Archived int  `json:"archived"`
+		Feed     Feed `json:"feed"`
+	}
+	json.Unmarshal(rec.Body.Bytes(), &result)
+	if rec.Code != http.StatusOK || result.Archived != 1 || result.Feed.Archived != 2 || result.Feed.LastError == "" {
+		t.Fatalf("poll = %d %s, want the new entry archived and the failing one reported", rec.Code, rec.Body)
+	}
+	// The failing entry is tried again
+	if want := []string{server.URL + "/posts/two", server.URL + "/posts/one"}; !reflect.DeepEqual(result.Feed.Seen, want) {
+		t.Errorf("seen = %v, want %v", result.Feed.Seen, want)
+	}
+
+	if rec := request(http.MethodDelete, "/api/v1/feeds/"+feed.ID, ""); rec.Code != http.StatusNoContent {
+		t.Errorf("delete = %d", rec.Code)
+	}
+	rec = request(http.MethodGet, "/api/v1/feeds", "")
+	if rec.Body.String() != "[]\n" {
+		t.Errorf("feeds after delete = %s", rec.Body)
+	}
+	if pages, _ := listPages(); len(pages) != 2 {
+		t.Errorf("%d pages left, want the archived entries kept", len(pages))
+	}
+}
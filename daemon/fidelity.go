@@ -0,0 +1,262 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Fidelity check weights, out of 100 when every check applies.
+const (
+	markdownWeight   = 25
+	assetsWeight     = 15
+	originalWeight   = 15
+	extractionWeight = 30
+	liveWeight       = 15
+)
+
+// wellExtractedWords is the word count above which extraction is assumed
+// to have found the page's content.
+const wellExtractedWords = 300
+
+// blockedPageRe matches text that stood in for the content of a page that
+// blocked, challenged or needed JavaScript from the capturer.
+var blockedPageRe = regexp.MustCompile(`(?i)\b(enable javascript|javascript is (disabled|required)|verify(ing)? you are (a )?human|are you a robot|checking your browser|just a moment\.\.\.|access denied|captcha)\b`)
+
+// LiveCheck records whether a page's URL still resolved when last checked.
+type LiveCheck struct {
+	Status    int       `json:"status,omitempty"`
+	Error     string    `json:"error,omitempty"`
+	CheckedAt time.Time `json:"checkedAt"`
+	// DeadSince is the first of the checks in a row that found the URL
+	// gone.
+	DeadSince *time.Time `json:"deadSince,omitempty"`
+}
+
+// alive reports whether the check found the URL serving a page.
+func (c LiveCheck) alive() bool {
+	return c.Error == "" && c.Status >= 200 && c.Status < 400
+}
+
+// gone reports whether the check found the URL no longer there: its host
+// unreachable, or the page not found. Other failures, such as servers
+// refusing bots, may well be temporary.
+func (c LiveCheck) gone() bool {
+	return c.Error != "" || c.Status == http.StatusNotFound || c.Status == http.StatusGone
+}
+
+// Fidelity rates how well a page is preserved, from 0 to 100.
+type Fidelity struct {
+	ID     string          `json:"id"`
+	URL    string          `json:"url"`
+	Title  string          `json:"title"`
+	Score  int             `json:"score"`
+	Checks []FidelityCheck `json:"checks"`
+}
+
+// FidelityCheck is one component of a fidelity score. Score is the
+// fraction of Weight the page earned; a check with no Score, such as an
+// unchecked live URL, doesn't count towards the total.
+type FidelityCheck struct {
+	Name   string   `json:"name"`
+	Weight int      `json:"weight"`
+	Score  *float64 `json:"score"`
+	Detail string   `json:"detail,omitempty"`
+}
+
+func scored(v float64) *float64 {
+	return &v
+}
+
+func passed(ok bool) *float64 {
+	if ok {
+		return scored(1)
+	}
+	return scored(0)
+}
+
+// pageFidelity scores a page from its stored files and its last live check.
+func pageFidelity(page Page) Fidelity {
+	f := Fidelity{ID: page.ID, URL: page.URL, Title: page.Title}
+
+	hasMarkdown := false
+	if page.HasMarkdown {
+		info, err := os.Stat(filepath.Join(pagesDir, page.MDFilename))
+		hasMarkdown = err == nil && info.Size() > 0
+	}
+	f.Checks = append(f.Checks, FidelityCheck{Name: "markdown", Weight: markdownWeight, Score: passed(hasMarkdown)})
+
+	assets, _ := ioutil.ReadDir(assetsDir(page.ID))
+	f.Checks = append(f.Checks, FidelityCheck{Name: "assets", Weight: assetsWeight, Score: passed(len(assets) > 0),
+		Detail: fmt.Sprintf("%d stored", len(assets))})
+
+	original := FidelityCheck{Name: "screenshot", Weight: originalWeight, Score: passed(page.ScreenshotFilename != "")}
+	if page.PDFFilename != "" {
+		original.Score, original.Detail = scored(1), "original PDF stored"
+	}
+	f.Checks = append(f.Checks, original)
+
+	f.Checks = append(f.Checks, extractionCheck(page))
+
+	live := FidelityCheck{Name: "live", Weight: liveWeight, Detail: "not checked"}
+	if c := page.LiveCheck; c != nil {
+		live.Score = passed(c.alive())
+		live.Detail = c.Error
+		if c.Error == "" {
+			live.Detail = fmt.Sprintf("HTTP %d", c.Status)
+		}
+	}
+	f.Checks = append(f.Checks, live)
+
+	var earned, possible float64
+	for _, check := range f.Checks {
+		if check.Score != nil {
+			earned += *check.Score * float64(check.Weight)
+			possible += float64(check.Weight)
+		}
+	}
+	if possible > 0 {
+		f.Score = int(math.Round(100 * earned / possible))
+	}
+	return f
+}
+
+// extractionCheck judges the extracted text of a page: too little of it,
+// or text that reads like a bot challenge, means the capture missed the
+// content.
+func extractionCheck(page Page) FidelityCheck {
+	check := FidelityCheck{Name: "extraction", Weight: extractionWeight}
+	text, err := readPageText(page.PageMetadata)
+	if err != nil {
+		check.Score, check.Detail = scored(0), "no readable content"
+		return check
+	}
+	words := wordCount(text)
+	if words < wellExtractedWords && blockedPageRe.MatchString(text) {
+		check.Score, check.Detail = scored(0), "looks like a blocked or script-only page"
+		return check
+	}
+	check.Score = scored(math.Min(float64(words)/wellExtractedWords, 1))
+	check.Detail = fmt.Sprintf("%d words", words)
+	return check
+}
+
+// checkLive requests a page's URL and records whether it still resolves.
+func checkLive(ctx context.Context, page Page) (LiveCheck, error) {
+	check := LiveCheck{CheckedAt: time.Now()}
+	status, err := urlStatus(ctx, http.MethodHead, page.URL)
+	// Some servers don't answer HEAD
+	if err == nil && (status == http.StatusMethodNotAllowed || status == http.StatusNotImplemented) {
+		status, err = urlStatus(ctx, http.MethodGet, page.URL)
+	}
+	if err != nil {
+		check.Error = err.Error()
+	}
+	check.Status = status
+
+	indexMu.Lock()
+	defer indexMu.Unlock()
+	metadata, err := readMetadata(page.ID)
+	if err != nil {
+		return check, err
+	}
+	if check.gone() {
+		check.DeadSince = &check.CheckedAt
+		if last := metadata.LiveCheck; last != nil && last.gone() && last.DeadSince != nil {
+			check.DeadSince = last.DeadSince
+		}
+	}
+	metadata.LiveCheck = &check
+	return check, writeMetadata(page.ID, metadata)
+}
+
+func urlStatus(ctx context.Context, method, pageURL string) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, method, pageURL, nil)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("User-Agent", "memento/1.0 (+https://github.com/nascarsayan/memento)")
+	resp, err := archiveClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	return resp.StatusCode, nil
+}
+
+// handlePageFidelity returns a page's fidelity score, first checking its
+// live URL when check=1.
+func handlePageFidelity(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, ErrMethodNotAllowed, "Method not allowed")
+		return
+	}
+	page, err := loadPage(r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusNotFound, ErrNotFound, "Page not found")
+		return
+	}
+	if r.URL.Query().Get("check") == "1" {
+		check, err := checkLive(r.Context(), page)
+		if err != nil {
+			log.Printf("Error recording live check of %s: %v", page.ID, err)
+		}
+		page.LiveCheck = &check
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(pageFidelity(page))
+}
+
+// handleFidelity lists the current versions of pages by fidelity score,
+// worst first, limited by the limit query parameter. Pages scoring above max are left out.
+func handleFidelity(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, ErrMethodNotAllowed, "Method not allowed")
+		return
+	}
+	limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+	if err != nil || limit <= 0 {
+		limit = defaultPageListLimit
+	}
+	maxScore := 100
+	if v := r.URL.Query().Get("max"); v != "" {
+		if maxScore, err = strconv.Atoi(v); err != nil {
+			writeError(w, http.StatusBadRequest, ErrInvalidRequest, "max must be a number")
+			return
+		}
+	}
+	pages, err := listPages()
+	if err != nil {
+		log.Printf("Error listing pages: %v", err)
+		writeError(w, http.StatusInternalServerError, ErrInternal, "Failed to list pages")
+		return
+	}
+
+	scores := []Fidelity{}
+	for _, page := range pages {
+		if page.SupersededBy != "" {
+			continue
+		}
+		if f := pageFidelity(page); f.Score <= maxScore {
+			scores = append(scores, f)
+		}
+	}
+	sort.SliceStable(scores, func(i, j int) bool {
+		return scores[i].Score < scores[j].Score
+	})
+	if len(scores) > limit {
+		scores = scores[:limit]
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(scores)
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPageFidelity(t *testing.T) {
+	withPagesDir(t)
+	article := strings.Repeat("word ", wellExtractedWords)
+
+	complete, _ := storePage(PageMetadata{URL: "https://example.com/a", Title: "Complete"}, "<p>"+article+"</p>", "# Complete\n\n"+article)
+	os.MkdirAll(assetsDir(complete), 0755)
+	ioutil.WriteFile(filepath.Join(assetsDir(complete), "style.css"), []byte("p{}"), 0644)
+	attachPageFile(complete, complete+".png", fakePNG, func(m *PageMetadata, name string) { m.ScreenshotFilename = name })
+	short, _ := storePage(PageMetadata{URL: "https://example.com/b", Title: "Short"}, "", strings.Repeat("word ", wellExtractedWords/2))
+	blocked, _ := storePage(PageMetadata{URL: "https://example.com/c", Title: "Blocked"}, "<p>Just a moment... Checking your browser</p>", "")
+	pdf, _ := storePage(PageMetadata{URL: "file:///paper.pdf", Title: "Paper", PDFFilename: "paper.pdf"}, "", "# Paper\n\n"+article)
+
+	tests := []struct {
+		id     string
+		score  int
+		checks map[string]float64
+	}{
+		{complete, 100, map[string]float64{"markdown": 1, "assets": 1, "screenshot": 1, "extraction": 1}},
+		// 25 + 15 of the 85 that apply
+		{short, 47, map[string]float64{"markdown": 1, "assets": 0, "screenshot": 0, "extraction": 0.5}},
+		{blocked, 0, map[string]float64{"markdown": 0, "extraction": 0}},
+		{pdf, 82, map[string]float64{"screenshot": 1, "assets": 0}},
+	}
+	for _, tt := range tests {
+		page, _ := loadPage(tt.id)
+		f := pageFidelity(page)
+		if f.Score != tt.score {
+			t.Errorf("%s: score = %d, want %d (%+v)", page.Title, f.Score, tt.score, f.Checks)
+		}
+		for _, check := range f.Checks {
+			want, ok := tt.checks[check.Name]
+			if !ok {
+				continue
+			}
+			if check.Score == nil || *check.Score != want {
+				t.Errorf("%s: %s check = %v, want %v", page.Title, check.Name, check.Score, want)
+			}
+		}
+	}
+
+	// A dead URL costs the live check's weight
+	page, _ := loadPage(complete)
+	page.LiveCheck = &LiveCheck{Status: http.StatusNotFound}
+	if f := pageFidelity(page); f.Score != 85 {
+		t.Errorf("score with a dead URL = %d, want 85", f.Score)
+	}
+}
+
+func TestCheckLive(t *testing.T) {
+	withPagesDir(t)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/gone":
+			w.WriteHeader(http.StatusGone)
+		case r.URL.Path == "/no-head" && r.Method == http.MethodHead:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	}))
+	defer server.Close()
+	// The test server is on a loopback address the archive client refuses
+	saved := archiveClient
+	archiveClient = server.Client()
+	defer func() { archiveClient = saved }()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/pages/{id}/fidelity", handlePageFidelity)
+	mux.HandleFunc("/fidelity", handleFidelity)
+
+	tests := []struct {
+		path  string
+		alive bool
+	}{
+		{"/ok", true},
+		{"/gone", false},
+		{"/no-head", true},
+	}
+	for _, tt := range tests {
+		id, _ := storePage(PageMetadata{URL: server.URL + tt.path, Title: tt.path}, "<p>text</p>", "")
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/pages/"+id+"/fidelity?check=1", nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: status = %d %s", tt.path, rec.Code, rec.Body)
+		}
+		page, _ := loadPage(id)
+		if page.LiveCheck == nil || page.LiveCheck.alive() != tt.alive {
+			t.Errorf("%s: recorded live check = %+v, want alive %v", tt.path, page.LiveCheck, tt.alive)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/fidelity?limit=2", nil))
+	var scores []Fidelity
+	json.Unmarshal(rec.Body.Bytes(), &scores)
+	if len(scores) != 2 || scores[0].URL != server.URL+"/gone" || scores[0].Score > scores[1].Score {
+		t.Errorf("GET /fidelity = %s, want the dead page first", rec.Body)
+	}
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/fidelity?max=abc", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("GET /fidelity?max=abc = %d, want 400", rec.Code)
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// heuristicFreshness is the share of a page's age, since it was last
+// modified, that it is assumed to stay fresh when its server gives no
+// lifetime, as HTTP caches do.
+const heuristicFreshness = 10
+
+// Freshness records how long, by its server's caching headers, a page
+// stays as captured, and the validators for asking the server whether it
+// changed since.
+type Freshness struct {
+	// StaleAt is when the page may have changed, unset when the server
+	// said nothing about it.
+	StaleAt      *time.Time `json:"staleAt,omitempty"`
+	ETag         string     `json:"etag,omitempty"`
+	LastModified string     `json:"lastModified,omitempty"`
+	// CheckedAt is when the server was last asked, zero until the recrawl
+	// revalidates the page.
+	CheckedAt time.Time `json:"checkedAt"`
+}
+
+// cacheDirectives parses a Cache-Control header into its directives and
+// their values.
+func cacheDirectives(header string) map[string]string {
+	directives := make(map[string]string)
+	for _, part := range strings.Split(header, ",") {
+		name, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+		if name != "" {
+			directives[strings.ToLower(name)] = strings.Trim(value, `"`)
+		}
+	}
+	return directives
+}
+
+// freshnessLifetime returns how long a response stays fresh after it was
+// received, from its Cache-Control, Expires or Last-Modified header, in
+// that order. It returns false when the response has none of them.
+func freshnessLifetime(header http.Header, received time.Time) (time.Duration, bool) {
+	directives := cacheDirectives(header.Get("Cache-Control"))
+	_, noStore := directives["no-store"]
+	_, noCache := directives["no-cache"]
+	if noStore || noCache {
+		return 0, true
+	}
+	date, err := http.ParseTime(header.Get("Date"))
+	if err != nil {
+		date = received
+	}
+	lifetime, known := time.Duration(0), false
+	if maxAge, err := strconv.Atoi(directives["max-age"]); err == nil {
+		lifetime, known = time.Duration(maxAge)*time.Second, true
+	} else if expires := header.Get("Expires"); expires != "" {
+		// An invalid date, such as "0", means already expired
+		if t, err := http.ParseTime(expires); err == nil {
+			lifetime = t.Sub(date)
+		}
+		known = true
+	} else if modified, err := http.ParseTime(header.Get("Last-Modified")); err == nil && modified.Before(date) {
+		lifetime, known = date.Sub(modified)/heuristicFreshness, true
+	}
+	if !known {
+		return 0, false
+	}
+	// Time the response spent in caches on the way counts against it
+	if age, err := strconv.Atoi(header.Get("Age")); err == nil {
+		lifetime -= time.Duration(age) * time.Second
+	}
+	if lifetime < 0 {
+		lifetime = 0
+	}
+	return lifetime, true
+}
+
+// httpFreshness reads the freshness of a response received at the given
+// time.
+func httpFreshness(header http.Header, received time.Time) Freshness {
+	f := Freshness{ETag: header.Get("ETag"), LastModified: header.Get("Last-Modified")}
+	if lifetime, ok := freshnessLifetime(header, received); ok {
+		staleAt := received.Add(lifetime)
+		f.StaleAt = &staleAt
+	}
+	return f
+}
+
+// revalidate asks a page's server, with a conditional HEAD request, whether
+// the page changed since it was captured or last revalidated. It returns
+// the page's freshness as of the response and whether the server said it
+// is unchanged.
+func revalidate(ctx context.Context, page Page) (Freshness, bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, page.URL, nil)
+	if err != nil {
+		return Freshness{}, false, err
+	}
+	req.Header.Set("User-Agent", "memento/1.0 (+https://github.com/nascarsayan/memento)")
+	previous := page.Freshness
+	if previous != nil {
+		if previous.ETag != "" {
+			req.Header.Set("If-None-Match", previous.ETag)
+		}
+		if previous.LastModified != "" {
+			req.Header.Set("If-Modified-Since", previous.LastModified)
+		}
+	}
+	resp, err := archiveClient.Do(req)
+	if err != nil {
+		return Freshness{}, false, err
+	}
+	resp.Body.Close()
+
+	now := time.Now()
+	f := httpFreshness(resp.Header, now)
+	f.CheckedAt = now
+	unchanged := resp.StatusCode == http.StatusNotModified && previous != nil
+	// A 304 needn't repeat the validators
+	if unchanged {
+		if f.ETag == "" {
+			f.ETag = previous.ETag
+		}
+		if f.LastModified == "" {
+			f.LastModified = previous.LastModified
+		}
+	}
+	return f, unchanged, nil
+}
+
+// staleAt is when a page is indexed as having gone stale, if known.
+func staleAt(metadata PageMetadata) *time.Time {
+	if metadata.Freshness == nil {
+		return nil
+	}
+	return metadata.Freshness.StaleAt
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestFreshnessLifetime(t *testing.T) {
+	date := "Mon, 02 Jan 2023 15:00:00 GMT"
+	tests := []struct {
+		header http.Header
+		want   time.Duration
+		known  bool
+	}{
+		{http.Header{}, 0, false},
+		{http.Header{"Cache-Control": {"public, max-age=3600"}}, time.Hour, true},
+		{http.Header{"Cache-Control": {"max-age=3600"}, "Age": {"600"}}, 50 * time.Minute, true},
+		{http.Header{"Cache-Control": {"no-cache"}, "Expires": {"Tue, 03 Jan 2023 15:00:00 GMT"}}, 0, true},
+		// max-age wins over Expires
+		{http.Header{"Cache-Control": {"max-age=60"}, "Date": {date}, "Expires": {"Tue, 03 Jan 2023 15:00:00 GMT"}}, time.Minute, true},
+		{http.Header{"Date": {date}, "Expires": {"Tue, 03 Jan 2023 15:00:00 GMT"}}, 24 * time.Hour, true},
+		{http.Header{"Date": {date}, "Expires": {"0"}}, 0, true},
+		{http.Header{"Date": {date}, "Last-Modified": {"Fri, 23 Dec 2022 15:00:00 GMT"}}, 24 * time.Hour, true},
+	}
+	for _, tt := range tests {
+		got, known := freshnessLifetime(tt.header, time.Now())
+		if got != tt.want || known != tt.known {
+			t.Errorf("freshnessLifetime(%v) = %v, %v, want %v, %v", tt.header, got, known, tt.want, tt.known)
+		}
+	}
+}
+
+func TestRecrawlDue(t *testing.T) {
+	now := time.Now()
+	at := func(hours int) *time.Time {
+		t := now.Add(time.Duration(hours) * time.Hour)
+		return &t
+	}
+	c := RecrawlConfig{IntervalHours: 24, MinIntervalHours: 2, MaxIntervalHours: 24 * 7}
+	tests := []struct {
+		name      string
+		saved     int
+		freshness *Freshness
+		want      bool
+	}{
+		{"no headers, recent", -10, nil, false},
+		{"no headers, a day old", -25, nil, true},
+		{"stale", -10, &Freshness{StaleAt: at(-1)}, true},
+		{"fresh", -30, &Freshness{StaleAt: at(1)}, false},
+		{"stale but just checked", -30, &Freshness{StaleAt: at(-5), CheckedAt: *at(-1)}, false},
+		{"fresh for a year", -24 * 8, &Freshness{StaleAt: at(24 * 300)}, true},
+		{"checked without headers", -48, &Freshness{CheckedAt: *at(-3)}, false},
+	}
+	for _, tt := range tests {
+		page := Page{PageMetadata: PageMetadata{Timestamp: *at(tt.saved), Freshness: tt.freshness}}
+		if got := c.due(page, now); got != tt.want {
+			t.Errorf("%s: due() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestRecrawlRevalidates(t *testing.T) {
+	withPagesDir(t)
+	withIndex(t, nil)
+	fetches := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.Header().Set("Cache-Control", "max-age=7200")
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		if r.Method == http.MethodGet {
+			fetches++
+		}
+		w.Header().Set("Content-Type", "text/html")
+		w.Header().Set("ETag", `"v1"`)
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Write([]byte("<title>Pricing</title><p>Ten dollars a month</p>"))
+	}))
+	defer server.Close()
+	saved := archiveClient
+	archiveClient = server.Client()
+	defer func() { archiveClient = saved }()
+
+	id, err := archiveURL(context.Background(), server.URL+"/pricing", "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reindexWith(context.Background(), id, func(metadata *PageMetadata) error {
+		metadata.Tags = []string{"track"}
+		return nil
+	})
+	page, _ := loadPage(id)
+	if f := page.Freshness; f == nil || f.ETag != `"v1"` || f.StaleAt == nil || f.StaleAt.Sub(page.Timestamp) != time.Minute {
+		t.Fatalf("captured freshness = %+v", page.Freshness)
+	}
+
+	c := RecrawlConfig{Tags: []string{"track"}, IntervalHours: 24, MinIntervalHours: 1, MaxIntervalHours: 24, Threshold: materialDrift}
+	fetches = 0
+	if changed, err := recrawl(context.Background(), c, true); err != nil || changed != 0 {
+		t.Fatalf("recrawl() = %d, %v", changed, err)
+	}
+	page, _ = loadPage(id)
+	if fetches != 0 || page.DriftCheck != nil {
+		t.Errorf("unchanged page was fetched %d times", fetches)
+	}
+	if f := page.Freshness; f == nil || f.ETag != `"v1"` || f.StaleAt == nil || f.StaleAt.Sub(f.CheckedAt) != 2*time.Hour {
+		t.Errorf("revalidated freshness = %+v", page.Freshness)
+	}
+	if c.due(page, time.Now()) {
+		t.Error("page is due again right after it was revalidated")
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// gcGracePeriod spares recently written files, which may belong to a
+// capture whose metadata hasn't been written yet.
+const gcGracePeriod = time.Hour
+
+// gcReport lists what a garbage collection pass removed, or would remove on
+// a dry run.
+type gcReport struct {
+	DryRun         bool     `json:"dryRun"`
+	Removed        []string `json:"removed"`
+	ReclaimedBytes int64    `json:"reclaimedBytes"`
+}
+
+// collectGarbage removes files and directories in the pages directory that
+// no page refers to, such as content left behind by a failed delete or
+// import and asset directories of deleted pages. Metadata files are never
+// removed, and neither is anything sharing a name with one, even when the
+// metadata can't be read.
+func collectGarbage(now time.Time, dryRun bool) (gcReport, error) {
+	report := gcReport{DryRun: dryRun, Removed: []string{}}
+	entries, err := ioutil.ReadDir(pagesDir)
+	if err != nil {
+		return report, err
+	}
+	pages, err := listPages()
+	if err != nil {
+		return report, err
+	}
+
+	ids := make(map[string]bool)
+	referenced := make(map[string]bool)
+	for _, entry := range entries {
+		if !entry.IsDir() && strings.HasSuffix(entry.Name(), ".json") {
+			ids[strings.TrimSuffix(entry.Name(), ".json")] = true
+		}
+	}
+	for _, page := range pages {
+		for _, name := range page.files() {
+			referenced[name] = true
+		}
+	}
+
+	for _, entry := range entries {
+		name := entry.Name()
+		base := strings.TrimSuffix(name, filepath.Ext(name))
+		if entry.IsDir() {
+			base = strings.TrimSuffix(name, "_files")
+		}
+		switch {
+		case !entry.IsDir() && strings.HasSuffix(name, ".json"):
+			continue
+		case referenced[name] || ids[base]:
+			continue
+		case now.Sub(entry.ModTime()) < gcGracePeriod:
+			continue
+		}
+
+		path := filepath.Join(pagesDir, name)
+		size := entry.Size()
+		if entry.IsDir() {
+			if size, err = dirSize(path); err != nil {
+				log.Printf("Error measuring %s: %v", path, err)
+				continue
+			}
+		}
+		if !dryRun {
+			if err := os.RemoveAll(path); err != nil {
+				log.Printf("Error removing %s: %v", path, err)
+				continue
+			}
+		}
+		report.Removed = append(report.Removed, name)
+		report.ReclaimedBytes += size
+	}
+	return report, nil
+}
+
+// handleGC runs a garbage collection pass, or reports what one would remove
+// with ?dryRun=1. It is refused when the delete action is disabled.
+func handleGC(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, ErrMethodNotAllowed, "Method not allowed")
+		return
+	}
+	if !requireAction(w, "delete") {
+		return
+	}
+	dryRun := r.URL.Query().Get("dryRun") == "1" || r.URL.Query().Get("dryRun") == "true"
+	report, err := collectGarbage(time.Now(), dryRun)
+	if err != nil {
+		log.Printf("Garbage collection failed: %v", err)
+		writeError(w, http.StatusInternalServerError, ErrInternal, "Garbage collection failed")
+		return
+	}
+	if !dryRun && len(report.Removed) > 0 {
+		log.Printf("Garbage collection removed %d orphaned files, reclaiming %s", len(report.Removed), formatBytes(report.ReclaimedBytes))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestCollectGarbage(t *testing.T) {
+	withPagesDir(t)
+	id, err := storePage(PageMetadata{URL: "https://example.com", Title: "Kept"}, "<p>kept</p>", "# Kept")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	old := time.Now().Add(-2 * gcGracePeriod)
+	write := func(name, content string, modified time.Time) {
+		path := filepath.Join(pagesDir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		os.Chtimes(path, modified, modified)
+		os.Chtimes(filepath.Dir(path), modified, modified)
+	}
+	write("deleted.html", "12345", old)
+	write("deleted.md", "123", old)
+	write("deleted_files/image.png", "1234567", old)
+	write("page.json.tmp", "{", old)
+	write("capturing.html", "in progress", time.Now())
+	write(id+"_files/image.png", "asset", old)
+	// Unreadable metadata still protects its content
+	write("broken.json", "{", old)
+	write("broken.html", "<p>broken</p>", old)
+	for _, name := range []string{id + ".json", id + ".html", id + ".md"} {
+		os.Chtimes(filepath.Join(pagesDir, name), old, old)
+	}
+
+	want := []string{"deleted.html", "deleted.md", "deleted_files", "page.json.tmp"}
+	dry, err := collectGarbage(time.Now(), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(dry.Removed)
+	if !reflect.DeepEqual(dry.Removed, want) || dry.ReclaimedBytes != 5+3+7+1 {
+		t.Errorf("dry run = %+v, want %v reclaiming 16 bytes", dry, want)
+	}
+	if _, err := os.Stat(filepath.Join(pagesDir, "deleted.html")); err != nil {
+		t.Errorf("dry run removed a file: %v", err)
+	}
+
+	report, err := collectGarbage(time.Now(), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Removed) != len(want) || report.ReclaimedBytes != dry.ReclaimedBytes {
+		t.Errorf("gc = %+v, want the dry run's %+v", report, dry)
+	}
+	entries, _ := ioutil.ReadDir(pagesDir)
+	var left []string
+	for _, entry := range entries {
+		left = append(left, entry.Name())
+	}
+	wantLeft := []string{id + ".html", id + ".json", id + ".md", id + "_files", "broken.html", "broken.json", "capturing.html"}
+	sort.Strings(wantLeft)
+	if !reflect.DeepEqual(left, wantLeft) {
+		t.Errorf("left after gc = %v, want %v", left, wantLeft)
+	}
+
+	if again, _ := collectGarbage(time.Now(), false); len(again.Removed) != 0 {
+		t.Errorf("second gc removed %v", again.Removed)
+	}
+}
+
+func TestHandleGC(t *testing.T) {
+	withPagesDir(t)
+	saved := config.DisabledActions
+	defer func() { config.DisabledActions = saved }()
+
+	tests := []struct {
+		method   string
+		disabled []string
+		status   int
+	}{
+		{http.MethodPost, nil, http.StatusOK},
+		{http.MethodGet, nil, http.StatusMethodNotAllowed},
+		{http.MethodPost, []string{"delete"}, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		config.DisabledActions = tt.disabled
+		rec := httptest.NewRecorder()
+		handleGC(rec, httptest.NewRequest(tt.method, "/gc?dryRun=1", nil))
+		if rec.Code != tt.status {
+			t.Errorf("%s /gc with %v disabled = %d, want %d", tt.method, tt.disabled, rec.Code, tt.status)
+		}
+	}
+}
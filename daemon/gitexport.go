@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// gitExportPagesDir is where pages go in the export repository, leaving its
+// root free for a README or other files of the user's own.
+const gitExportPagesDir = "pages"
+
+var gitCommandTimeout = 2 * time.Minute
+
+// gitChange is a page added, updated or removed by an export.
+type gitChange struct {
+	Status string // A, M or D, as git reports them
+	ID     string
+	Title  string
+	URL    string
+}
+
+// watchGitExport commits markdown captures to the configured repository on
+// its schedule.
+func watchGitExport() {
+	if config.GitExport.Dir == "" {
+		return
+	}
+	for {
+		time.Sleep(time.Duration(config.GitExport.IntervalMinutes) * time.Minute)
+		if _, err := exportToGit(config.GitExport); err != nil {
+			log.Printf("Git export failed: %v", err)
+			publishError("", fmt.Errorf("git export: %v", err))
+		}
+	}
+}
+
+// exportToGit writes the markdown of every page to the repository, one file
+// per page, and commits whatever changed, returning the changes. The
+// repository is created if needed.
+func exportToGit(c GitExportConfig) ([]gitChange, error) {
+	target := filepath.Join(c.Dir, gitExportPagesDir)
+	if err := os.MkdirAll(target, 0755); err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(filepath.Join(c.Dir, ".git")); os.IsNotExist(err) {
+		if _, err := runGit(c.Dir, "init", "-q"); err != nil {
+			return nil, err
+		}
+	}
+
+	pages, err := listPages()
+	if err != nil {
+		return nil, err
+	}
+	byID := make(map[string]Page)
+	for _, page := range pages {
+		if !page.HasMarkdown || page.MDFilename == "" {
+			continue
+		}
+		data, err := readContentFile(filepath.Join(pagesDir, page.MDFilename))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		name := filepath.Join(target, page.ID+".md")
+		if existing, err := ioutil.ReadFile(name); err == nil && bytes.Equal(existing, data) {
+			byID[page.ID] = page
+			continue
+		}
+		if err := ioutil.WriteFile(name, data, 0644); err != nil {
+			return nil, err
+		}
+		byID[page.ID] = page
+	}
+	entries, err := ioutil.ReadDir(target)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		id := strings.TrimSuffix(entry.Name(), ".md")
+		if _, ok := byID[id]; !ok && !entry.IsDir() && strings.HasSuffix(entry.Name(), ".md") {
+			if err := os.Remove(filepath.Join(target, entry.Name())); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	// Let git say what changed, so files written by a run whose commit
+	// failed are committed by the next
+	if _, err := runGit(c.Dir, "add", "-A", "--", gitExportPagesDir); err != nil {
+		return nil, err
+	}
+	out, err := runGit(c.Dir, "diff", "--cached", "--name-status", "--no-renames", "--", gitExportPagesDir)
+	if err != nil {
+		return nil, err
+	}
+	var changes []gitChange
+	for _, line := range strings.Split(strings.TrimSpace(out), "\n") {
+		fields := strings.SplitN(line, "\t", 2)
+		if len(fields) != 2 {
+			continue
+		}
+		id := strings.TrimSuffix(filepath.Base(fields[1]), ".md")
+		change := gitChange{Status: fields[0], ID: id, Title: id}
+		if page, ok := byID[id]; ok {
+			change.Title, change.URL = page.Title, page.URL
+		}
+		changes = append(changes, change)
+	}
+	if len(changes) == 0 {
+		return nil, nil
+	}
+
+	_, err = runGit(c.Dir,
+		"-c", "user.name="+c.AuthorName, "-c", "user.email="+c.AuthorEmail, "-c", "commit.gpgsign=false",
+		"commit", "-q", "-m", gitCommitMessage(changes))
+	if err != nil {
+		return nil, err
+	}
+	if c.Push {
+		if _, err := runGit(c.Dir, "push", "-q"); err != nil {
+			return changes, err
+		}
+	}
+	return changes, nil
+}
+
+// gitCommitMessage summarizes changes in the subject and lists the pages in
+// the body.
+func gitCommitMessage(changes []gitChange) string {
+	sort.Slice(changes, func(i, j int) bool {
+		if changes[i].Status != changes[j].Status {
+			return changes[i].Status < changes[j].Status
+		}
+		return changes[i].ID < changes[j].ID
+	})
+	verbs := map[string]string{"A": "Add", "M": "Update", "D": "Remove"}
+	if len(changes) == 1 {
+		return fmt.Sprintf("%s %q", verbs[changes[0].Status], changes[0].Title)
+	}
+
+	counts := make(map[string]int)
+	for _, change := range changes {
+		counts[change.Status]++
+	}
+	var parts []string
+	for _, status := range []string{"A", "M", "D"} {
+		if counts[status] == 0 {
+			continue
+		}
+		verb := verbs[status]
+		if len(parts) > 0 {
+			verb = strings.ToLower(verb)
+		}
+		noun := "pages"
+		if counts[status] == 1 {
+			noun = "page"
+		}
+		parts = append(parts, fmt.Sprintf("%s %d %s", verb, counts[status], noun))
+	}
+
+	var body strings.Builder
+	body.WriteString(strings.Join(parts, ", "))
+	body.WriteString("\n\n")
+	for _, change := range changes {
+		body.WriteString(verbs[change.Status] + ": " + change.Title)
+		if change.URL != "" {
+			body.WriteString(" <" + change.URL + ">")
+		}
+		body.WriteString("\n")
+	}
+	return body.String()
+}
+
+// runGit runs a git command in dir, returning its output.
+func runGit(dir string, args ...string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), gitCommandTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, "git", append([]string{"-C", dir}, args...)...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("git %s: %v: %s", strings.Join(args, " "), err, strings.TrimSpace(stderr.String()))
+	}
+	return string(out), nil
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestGitCommitMessage(t *testing.T) {
+	tests := []struct {
+		name    string
+		changes []gitChange
+		want    string
+	}{
+		{"one page", []gitChange{{Status: "A", ID: "a", Title: "Go"}}, `Add "Go"`},
+		{"removed page", []gitChange{{Status: "D", ID: "gone", Title: "gone"}}, `Remove "gone"`},
+		{"several", []gitChange{
+			{Status: "M", ID: "c", Title: "C", URL: "https://c.example/"},
+			{Status: "A", ID: "b", Title: "B"},
+			{Status: "A", ID: "a", Title: "A", URL: "https://a.example/"},
+		}, "Add 2 pages, update 1 page\n\nAdd: A <https://a.example/>\nAdd: B\nUpdate: C <https://c.example/>\n"},
+	}
+	for _, tt := range tests {
+		if got := gitCommitMessage(tt.changes); got != tt.want {
+			t.Errorf("%s: gitCommitMessage() = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestExportToGit(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git isn't installed")
+	}
+	withPagesDir(t)
+	c := GitExportConfig{Dir: t.TempDir(), AuthorName: "Memento", AuthorEmail: "memento@localhost"}
+
+	first, _ := storePage(PageMetadata{URL: "https://example.com/a", Title: "First"}, "", "# First\n\nfirst")
+	second, _ := storePage(PageMetadata{URL: "https://example.com/b", Title: "Second"}, "", "# Second\n\nsecond")
+	storePage(PageMetadata{URL: "https://example.com/c", Title: "HTML only"}, "<p>html</p>", "")
+
+	steps := []struct {
+		name    string
+		change  func()
+		subject string
+		files   []string
+	}{
+		{"initial", func() {}, "Add 2 pages", []string{first, second}},
+		{"unchanged", func() {}, "", []string{first, second}},
+		{"updated", func() {
+			ioutil.WriteFile(filepath.Join(pagesDir, second+".md"), []byte("# Second\n\nedited"), 0644)
+		}, `Update "Second"`, []string{first, second}},
+		{"deleted", func() {
+			os.Remove(filepath.Join(pagesDir, first+".json"))
+		}, `Remove "` + first + `"`, []string{second}},
+	}
+	for _, step := range steps {
+		step.change()
+		changes, err := exportToGit(c)
+		if err != nil {
+			t.Fatalf("%s: %v", step.name, err)
+		}
+		if (step.subject == "") != (len(changes) == 0) {
+			t.Errorf("%s: changes = %+v", step.name, changes)
+		}
+		if step.subject != "" {
+			subject, _ := runGit(c.Dir, "log", "-1", "--format=%s")
+			if strings.TrimSpace(subject) != step.subject {
+				t.Errorf("%s: commit subject = %q, want %q", step.name, strings.TrimSpace(subject), step.subject)
+			}
+		}
+		entries, _ := ioutil.ReadDir(filepath.Join(c.Dir, gitExportPagesDir))
+		var files []string
+		for _, entry := range entries {
+			files = append(files, strings.TrimSuffix(entry.Name(), ".md"))
+		}
+		if strings.Join(files, ",") != strings.Join(step.files, ",") {
+			t.Errorf("%s: exported %v, want %v", step.name, files, step.files)
+		}
+	}
+
+	count, _ := runGit(c.Dir, "rev-list", "--count", "HEAD")
+	if strings.TrimSpace(count) != "3" {
+		t.Errorf("repository has %s commits, want 3", strings.TrimSpace(count))
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// groupedSearchDepth is how many of the best results a grouped search
+// sorts into groups, groupHitLimit how many of them each group returns,
+// and searchGroupLimit the most groups returned.
+const (
+	groupedSearchDepth = 200
+	groupHitLimit      = 3
+	searchGroupLimit   = 20
+)
+
+// searchGroupings maps the group_by parameter to what puts a result in a
+// group: the source it was archived from, its URL's domain or its tags.
+var searchGroupings = map[string]func(SearchResult) []string{
+	"collection": collectionOf,
+	"domain":     func(result SearchResult) []string { return []string{domainOf(result.URL)} },
+	"tag":        tagsOf,
+}
+
+// SearchGroup is a bucket of a grouped search's results, with how many of
+// the results considered fell into it and the best of them.
+type SearchGroup struct {
+	Key   string         `json:"key"`
+	Count int            `json:"count"`
+	Hits  []SearchResult `json:"hits"`
+}
+
+// collectionOf is the source a result's page was archived from. Pages
+// saved by the extension or through the API record none.
+func collectionOf(result SearchResult) []string {
+	metadata, err := readMetadata(result.ID)
+	if err != nil || metadata.Source == "" {
+		return []string{"extension"}
+	}
+	return []string{metadata.Source}
+}
+
+// domainOf is a URL's host without a leading "www.", or "" for URLs
+// without one, such as files.
+func domainOf(pageURL string) string {
+	u, err := url.Parse(pageURL)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimPrefix(strings.ToLower(u.Hostname()), "www.")
+}
+
+// tagsOf is the tags of a result's page, putting it in a group for each,
+// or in the "" group when it has none.
+func tagsOf(result SearchResult) []string {
+	metadata, err := readMetadata(result.ID)
+	tags := metadata.allTags()
+	if err != nil || len(tags) == 0 {
+		return []string{""}
+	}
+	return tags
+}
+
+// groupResults sorts results into groups, which are ordered by their best
+// result, keeping the results' order within each.
+func groupResults(results []SearchResult, by string) []SearchGroup {
+	keys := searchGroupings[by]
+	groups := []SearchGroup{}
+	positions := make(map[string]int)
+	for _, result := range results {
+		for _, key := range keys(result) {
+			i, ok := positions[key]
+			if !ok {
+				i = len(groups)
+				positions[key] = i
+				groups = append(groups, SearchGroup{Key: key})
+			}
+			groups[i].Count++
+			if len(groups[i].Hits) < groupHitLimit {
+				groups[i].Hits = append(groups[i].Hits, result)
+			}
+		}
+	}
+	if len(groups) > searchGroupLimit {
+		groups = groups[:searchGroupLimit]
+	}
+	return groups
+}
+
+// writeSearchResults responds with a search's results, or with their
+// groups when the search is grouped.
+func writeSearchResults(w http.ResponseWriter, results []SearchResult, options SearchOptions) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Add("Vary", "Accept-Language")
+	if options.GroupBy != "" {
+		groups := groupResults(results, options.GroupBy)
+		if options.Fields == nil {
+			json.NewEncoder(w).Encode(groups)
+			return
+		}
+		selected := make([]map[string]interface{}, len(groups))
+		for i, group := range groups {
+			selected[i] = map[string]interface{}{"key": group.Key, "count": group.Count, "hits": selectFields(group.Hits, options.Fields)}
+		}
+		json.NewEncoder(w).Encode(selected)
+		return
+	}
+	json.NewEncoder(w).Encode(selectFields(results, options.Fields))
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestGroupedSearch(t *testing.T) {
+	withPagesDir(t)
+	now := time.Now()
+	pages := map[string]PageMetadata{
+		"a": {URL: "https://www.example.com/a", Title: "Alpha", Tags: []string{"coffee", "brewing"}},
+		"b": {URL: "https://example.com/b", Title: "Bravo", Tags: []string{"coffee"}, Source: "feed"},
+		"c": {URL: "https://blog.test/c", Title: "Charlie", Source: "feed"},
+		"d": {URL: "file:///notes/d.html", Title: "Delta", Source: "watch"},
+		"e": {URL: "https://example.com/e", Title: "Echo", Tags: []string{"coffee"}},
+		"f": {URL: "https://example.com/f", Title: "Foxtrot", Tags: []string{"coffee"}},
+	}
+	docs := make(map[string]PageDocument)
+	for id, metadata := range pages {
+		if err := writeMetadata(id, metadata); err != nil {
+			t.Fatal(err)
+		}
+		docs[id] = PageDocument{URL: metadata.URL, Title: metadata.Title, Content: "espresso", Time: now, Tags: metadata.Tags}
+	}
+	withIndex(t, docs)
+
+	search := func(query string) []SearchGroup {
+		rec := httptest.NewRecorder()
+		newRouter().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/search?q=espresso&sort=title&"+query, nil))
+		var groups []SearchGroup
+		if err := json.Unmarshal(rec.Body.Bytes(), &groups); err != nil {
+			t.Fatalf("%s = %d %s", query, rec.Code, rec.Body)
+		}
+		return groups
+	}
+	summarize := func(groups []SearchGroup) map[string]string {
+		got := make(map[string]string)
+		for _, group := range groups {
+			var titles string
+			for _, hit := range group.Hits {
+				titles += hit.Title[:1]
+			}
+			got[group.Key] = fmt.Sprintf("%s/%d", titles, group.Count)
+		}
+		return got
+	}
+
+	tests := []struct {
+		groupBy string
+		want    map[string]string
+	}{
+		// Groups return their best three hits of all they hold
+		{"domain", map[string]string{"example.com": "ABE/4", "blog.test": "C/1", "": "D/1"}},
+		{"tag", map[string]string{"coffee": "ABE/4", "brewing": "A/1", "": "CD/2"}},
+		{"collection", map[string]string{"extension": "AEF/3", "feed": "BC/2", "watch": "D/1"}},
+	}
+	for _, tt := range tests {
+		groups := search("group_by=" + tt.groupBy)
+		if got := summarize(groups); len(got) != len(tt.want) || len(groups) != len(tt.want) {
+			t.Errorf("group_by=%s: got %v, want %v", tt.groupBy, got, tt.want)
+		} else {
+			for key, want := range tt.want {
+				if got[key] != want {
+					t.Errorf("group_by=%s: group %q = %s, want %s", tt.groupBy, key, got[key], want)
+				}
+			}
+		}
+		// Groups are ordered by their best hit
+		if len(groups) > 0 && groups[0].Hits[0].Title != "Alpha" {
+			t.Errorf("group_by=%s: first group is %q", tt.groupBy, groups[0].Key)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	newRouter().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/search?q=espresso&group_by=year", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("group_by=year = %d, want 400", rec.Code)
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/blevesearch/bleve/registry"
+	"github.com/blevesearch/bleve/search/highlight"
+	simpleFragmenter "github.com/blevesearch/bleve/search/highlight/fragmenter/simple"
+	simpleHighlighter "github.com/blevesearch/bleve/search/highlight/highlighter/simple"
+)
+
+// markerHighlighter wraps matches in control characters rather than markup,
+// so that snippets can be rendered in any of the highlight styles below.
+const (
+	markerHighlighter = "memento-markers"
+	matchStart        = "\x02"
+	matchEnd          = "\x03"
+
+	ansiMatchStart = "\x1b[43m"
+	ansiReset      = "\x1b[0m"
+)
+
+// highlightStyles are the accepted values of the search highlight parameter.
+// The default, none, returns plain text snippets.
+var highlightStyles = map[string]bool{
+	"none":    true,
+	"html":    true,
+	"ansi":    true,
+	"markers": true,
+}
+
+// MatchRange is the position of a matched term in a snippet, counted in
+// Unicode code points from the start of the snippet.
+type MatchRange struct {
+	Start int `json:"start"`
+	End   int `json:"end"`
+}
+
+type markerFormatter struct{}
+
+func (markerFormatter) Format(f *highlight.Fragment, locations highlight.TermLocations) string {
+	var out strings.Builder
+	clean := func(b []byte) string {
+		return strings.NewReplacer(matchStart, "", matchEnd, "").Replace(string(b))
+	}
+	curr := f.Start
+	for _, location := range locations {
+		if location == nil || !location.ArrayPositions.Equals(f.ArrayPositions) || location.Start < curr {
+			continue
+		}
+		if location.End > f.End {
+			break
+		}
+		out.WriteString(clean(f.Orig[curr:location.Start]))
+		out.WriteString(matchStart + clean(f.Orig[location.Start:location.End]) + matchEnd)
+		curr = location.End
+	}
+	out.WriteString(clean(f.Orig[curr:f.End]))
+	return out.String()
+}
+
+func init() {
+	registry.RegisterHighlighter(markerHighlighter, func(config map[string]interface{}, cache *registry.Cache) (highlight.Highlighter, error) {
+		fragmenter, err := cache.FragmenterNamed(simpleFragmenter.Name)
+		if err != nil {
+			return nil, fmt.Errorf("error building fragmenter: %v", err)
+		}
+		return simpleHighlighter.NewHighlighter(fragmenter, markerFormatter{}, simpleHighlighter.DefaultSeparator), nil
+	})
+}
+
+// stripControl removes control characters other than newlines and tabs, so
+// that stored pages can't smuggle escape sequences into a terminal.
+func stripControl(text string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '\n' || r == '\t' || !unicode.IsControl(r) {
+			return r
+		}
+		return -1
+	}, text)
+}
+
+// formatSnippet renders a snippet produced by the marker highlighter in the
+// given style. The markers style returns plain text along with the positions
+// of the matches.
+func formatSnippet(snippet, style string) (string, []MatchRange) {
+	var out strings.Builder
+	var matches []MatchRange
+	write := func(text string, match bool) {
+		switch {
+		case style == "html" && match:
+			text = "<mark>" + html.EscapeString(text) + "</mark>"
+		case style == "html":
+			text = html.EscapeString(text)
+		case style == "ansi" && match:
+			text = ansiMatchStart + stripControl(text) + ansiReset
+		case style == "ansi":
+			text = stripControl(text)
+		case style == "markers" && match:
+			start := utf8.RuneCountInString(out.String())
+			matches = append(matches, MatchRange{Start: start, End: start + utf8.RuneCountInString(text)})
+		}
+		out.WriteString(text)
+	}
+
+	for snippet != "" {
+		start := strings.Index(snippet, matchStart)
+		if start < 0 {
+			write(snippet, false)
+			break
+		}
+		write(snippet[:start], false)
+		snippet = snippet[start+len(matchStart):]
+		end := strings.Index(snippet, matchEnd)
+		if end < 0 {
+			end = len(snippet)
+		}
+		write(snippet[:end], true)
+		snippet = strings.TrimPrefix(snippet[end:], matchEnd)
+	}
+	return out.String(), matches
+}
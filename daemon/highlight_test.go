@@ -0,0 +1,31 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFormatSnippet(t *testing.T) {
+	snippet := "a " + matchStart + "go" + matchEnd + " <b>"
+	tests := []struct {
+		style   string
+		snippet string
+		want    string
+		matches []MatchRange
+	}{
+		{"none", snippet, "a go <b>", nil},
+		{"html", snippet, "a <mark>go</mark> &lt;b&gt;", nil},
+		{"ansi", snippet, "a " + ansiMatchStart + "go" + ansiReset + " <b>", nil},
+		{"markers", snippet, "a go <b>", []MatchRange{{Start: 2, End: 4}}},
+		{"markers", "é " + matchStart + "ü" + matchEnd, "é ü", []MatchRange{{Start: 2, End: 3}}},
+		{"markers", "open " + matchStart + "ended", "open ended", []MatchRange{{Start: 5, End: 10}}},
+		{"ansi", "x\x1b[2J\x1b]0;pwned\x07y\n\tz", "x[2J]0;pwnedy\n\tz", nil},
+		{"ansi", matchStart + "\x1b[31mred" + matchEnd + "\u009b1m", ansiMatchStart + "[31mred" + ansiReset + "1m", nil},
+	}
+	for _, tt := range tests {
+		got, matches := formatSnippet(tt.snippet, tt.style)
+		if got != tt.want || !reflect.DeepEqual(matches, tt.matches) {
+			t.Errorf("formatSnippet(%q, %s) = %q, %v; want %q, %v", tt.snippet, tt.style, got, matches, tt.want, tt.matches)
+		}
+	}
+}
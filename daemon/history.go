@@ -0,0 +1,329 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const historyFile = "memento_history.jsonl"
+
+const (
+	// defaultHistoryLimit is how many searches GET /history/searches
+	// returns unless asked for another number, up to maxHistoryLimit.
+	defaultHistoryLimit = 50
+	maxHistoryLimit     = 1000
+	// historyStatsLimit is how many queries each list of the analytics
+	// holds.
+	historyStatsLimit = 20
+	// historyPruneInterval is how often searches older than
+	// search.historyDays are dropped.
+	historyPruneInterval = 24 * time.Hour
+)
+
+// searchRecord is a search run from the API or the text search page.
+type searchRecord struct {
+	Query string    `json:"query"`
+	Time  time.Time `json:"time"`
+	// Hits is how many results the search returned.
+	Hits int `json:"hits"`
+	// Semantic is set for searches by meaning.
+	Semantic bool   `json:"semantic,omitempty"`
+	Owner    string `json:"owner,omitempty"`
+}
+
+// queryStats sums up the searches for one query.
+type queryStats struct {
+	Query string `json:"query"`
+	Count int    `json:"count"`
+	// Hits is how many results the query last returned.
+	Hits int       `json:"hits"`
+	Last time.Time `json:"last"`
+}
+
+// historyStats are the analytics of the searches since Since.
+type historyStats struct {
+	Since       time.Time    `json:"since"`
+	Searches    int          `json:"searches"`
+	Queries     int          `json:"queries"`
+	TopQueries  []queryStats `json:"topQueries"`
+	ZeroResults []queryStats `json:"zeroResults"`
+}
+
+// historyMu guards the history file.
+var historyMu sync.Mutex
+
+// recordSearch appends a search to the history. Searches are only kept
+// while search.recordHistory is on, and never leave this machine.
+func recordSearch(r *http.Request, query string, hits int, semantic bool) error {
+	if !config.Search.RecordHistory {
+		return nil
+	}
+	data, err := json.Marshal(searchRecord{Query: strings.TrimSpace(query), Time: time.Now(), Hits: hits, Semantic: semantic, Owner: requestUser(r)})
+	if err != nil {
+		return err
+	}
+	historyMu.Lock()
+	defer historyMu.Unlock()
+	f, err := os.OpenFile(historyFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// readHistory loads the searches, oldest first, skipping lines that don't
+// parse, such as one cut short by a crash. historyMu must be held.
+func readHistory() ([]searchRecord, error) {
+	data, err := ioutil.ReadFile(historyFile)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var records []searchRecord
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		var record searchRecord
+		if json.Unmarshal(scanner.Bytes(), &record) == nil && record.Query != "" {
+			records = append(records, record)
+		}
+	}
+	return records, scanner.Err()
+}
+
+// writeHistory replaces the history with records. historyMu must be held.
+func writeHistory(records []searchRecord) error {
+	var buf bytes.Buffer
+	for _, record := range records {
+		data, _ := json.Marshal(record)
+		buf.Write(append(data, '\n'))
+	}
+	tmp := historyFile + ".tmp"
+	if err := ioutil.WriteFile(tmp, buf.Bytes(), 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, historyFile)
+}
+
+// userHistory returns the searches of the requesting user, newest first;
+// all of them for admins when users are off.
+func userHistory(r *http.Request) ([]searchRecord, error) {
+	historyMu.Lock()
+	records, err := readHistory()
+	historyMu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	user := requestUser(r)
+	var mine []searchRecord
+	for i := len(records) - 1; i >= 0; i-- {
+		if !usersEnabled() || records[i].Owner == user {
+			mine = append(mine, records[i])
+		}
+	}
+	return mine, nil
+}
+
+// pruneHistory drops the searches older than search.historyDays.
+func pruneHistory(now time.Time) (int, error) {
+	historyMu.Lock()
+	defer historyMu.Unlock()
+	records, err := readHistory()
+	if err != nil || len(records) == 0 {
+		return 0, err
+	}
+	cutoff := now.AddDate(0, 0, -config.Search.HistoryDays)
+	kept := records[:0]
+	for _, record := range records {
+		if record.Time.After(cutoff) {
+			kept = append(kept, record)
+		}
+	}
+	dropped := len(records) - len(kept)
+	if dropped == 0 {
+		return 0, nil
+	}
+	return dropped, writeHistory(kept)
+}
+
+// clearHistory drops the requesting user's searches, or every search when
+// users are off.
+func clearHistory(r *http.Request) error {
+	historyMu.Lock()
+	defer historyMu.Unlock()
+	records, err := readHistory()
+	if err != nil {
+		return err
+	}
+	user := requestUser(r)
+	kept := records[:0]
+	for _, record := range records {
+		if usersEnabled() && record.Owner != user {
+			kept = append(kept, record)
+		}
+	}
+	return writeHistory(kept)
+}
+
+// normalizeQuery folds the queries that differ only in case and spacing
+// together.
+func normalizeQuery(query string) string {
+	return strings.ToLower(strings.Join(strings.Fields(query), " "))
+}
+
+// summarizeHistory counts the searches, newest first, made after since: the
+// queries searched most often, and those that last found nothing, most
+// recent first.
+func summarizeHistory(records []searchRecord, since time.Time) historyStats {
+	stats := historyStats{Since: since, TopQueries: []queryStats{}, ZeroResults: []queryStats{}}
+	byQuery := make(map[string]*queryStats)
+	var order []string
+	for _, record := range records {
+		if !record.Time.After(since) {
+			continue
+		}
+		stats.Searches++
+		key := normalizeQuery(record.Query)
+		q, ok := byQuery[key]
+		if !ok {
+			// The newest search gives the query's spelling and hits
+			q = &queryStats{Query: record.Query, Hits: record.Hits, Last: record.Time}
+			byQuery[key] = q
+			order = append(order, key)
+		}
+		q.Count++
+	}
+	stats.Queries = len(order)
+	for _, key := range order {
+		if q := byQuery[key]; q.Hits == 0 {
+			stats.ZeroResults = append(stats.ZeroResults, *q)
+		}
+	}
+	sort.SliceStable(order, func(i, j int) bool { return byQuery[order[i]].Count > byQuery[order[j]].Count })
+	for _, key := range order {
+		stats.TopQueries = append(stats.TopQueries, *byQuery[key])
+	}
+	if len(stats.TopQueries) > historyStatsLimit {
+		stats.TopQueries = stats.TopQueries[:historyStatsLimit]
+	}
+	if len(stats.ZeroResults) > historyStatsLimit {
+		stats.ZeroResults = stats.ZeroResults[:historyStatsLimit]
+	}
+	return stats
+}
+
+// handleSearchHistory lists the requesting user's searches, newest first,
+// paginated with limit and offset and narrowed to those containing q, or
+// forgets them all on DELETE.
+func handleSearchHistory(w http.ResponseWriter, r *http.Request) {
+	if !config.Search.RecordHistory {
+		writeError(w, http.StatusNotFound, ErrNotFound, "Search history is not enabled")
+		return
+	}
+	if r.Method == http.MethodDelete {
+		if err := clearHistory(r); err != nil {
+			log.Printf("Error clearing search history: %v", err)
+			writeError(w, http.StatusInternalServerError, ErrInternal, "Failed to clear search history")
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	params := r.URL.Query()
+	limit := defaultHistoryLimit
+	if value := params.Get("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 || n > maxHistoryLimit {
+			writeError(w, http.StatusBadRequest, ErrInvalidRequest, "limit must be between 1 and "+strconv.Itoa(maxHistoryLimit))
+			return
+		}
+		limit = n
+	}
+	offset, _ := strconv.Atoi(params.Get("offset"))
+
+	records, err := userHistory(r)
+	if err != nil {
+		log.Printf("Error reading search history: %v", err)
+		writeError(w, http.StatusInternalServerError, ErrInternal, "Failed to read search history")
+		return
+	}
+	if q := normalizeQuery(params.Get("q")); q != "" {
+		matching := records[:0]
+		for _, record := range records {
+			if strings.Contains(normalizeQuery(record.Query), q) {
+				matching = append(matching, record)
+			}
+		}
+		records = matching
+	}
+	total := len(records)
+	if offset < 0 || offset > total {
+		offset = total
+	}
+	records = records[offset:]
+	if len(records) > limit {
+		records = records[:limit]
+	}
+	if records == nil {
+		records = []searchRecord{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"total": total, "searches": records})
+}
+
+// handleSearchHistoryStats returns the analytics of the requesting user's
+// searches over the last days days, 30 by default.
+func handleSearchHistoryStats(w http.ResponseWriter, r *http.Request) {
+	if !config.Search.RecordHistory {
+		writeError(w, http.StatusNotFound, ErrNotFound, "Search history is not enabled")
+		return
+	}
+	days := 30
+	if value := r.URL.Query().Get("days"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 {
+			writeError(w, http.StatusBadRequest, ErrInvalidRequest, "days must be a positive number")
+			return
+		}
+		days = n
+	}
+	records, err := userHistory(r)
+	if err != nil {
+		log.Printf("Error reading search history: %v", err)
+		writeError(w, http.StatusInternalServerError, ErrInternal, "Failed to read search history")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(summarizeHistory(records, time.Now().AddDate(0, 0, -days)))
+}
+
+// watchSearchHistory drops searches older than search.historyDays once a
+// day while the history is kept.
+func watchSearchHistory() {
+	if !config.Search.RecordHistory {
+		return
+	}
+	for {
+		if dropped, err := pruneHistory(time.Now()); err != nil {
+			log.Printf("Error pruning search history: %v", err)
+		} else if dropped > 0 {
+			log.Printf("Dropped %d searches from the history", dropped)
+		}
+		time.Sleep(historyPruneInterval)
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestSearchHistory(t *testing.T) {
+	withPagesDir(t)
+	withIndex(t, map[string]PageDocument{
+		"cats": {URL: "https://cats.example/", Title: "Cats", Content: "Kittens sleep most of the day."},
+	})
+	saved := config.Search
+	defer func() { config.Search = saved }()
+	config.Search.RecordHistory = true
+	config.Search.HistoryDays = 90
+
+	for _, q := range []string{"kittens", "puppies", "Kittens%20", "kittens"} {
+		w := httptest.NewRecorder()
+		handleSearch(w, httptest.NewRequest(http.MethodGet, "/search?q="+q, nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("search %q = %d: %s", q, w.Code, w.Body)
+		}
+	}
+
+	var history struct {
+		Total    int            `json:"total"`
+		Searches []searchRecord `json:"searches"`
+	}
+	w := httptest.NewRecorder()
+	handleSearchHistory(w, httptest.NewRequest(http.MethodGet, "/history/searches?limit=2", nil))
+	json.NewDecoder(w.Body).Decode(&history)
+	if history.Total != 4 || len(history.Searches) != 2 || history.Searches[0].Query != "kittens" || history.Searches[0].Hits != 1 || history.Searches[1].Query != "Kittens" {
+		t.Errorf("history = %+v", history)
+	}
+	w = httptest.NewRecorder()
+	handleSearchHistory(w, httptest.NewRequest(http.MethodGet, "/history/searches?q=PUP", nil))
+	json.NewDecoder(w.Body).Decode(&history)
+	if history.Total != 1 || history.Searches[0].Hits != 0 {
+		t.Errorf("history matching pup = %+v", history)
+	}
+
+	var stats historyStats
+	w = httptest.NewRecorder()
+	handleSearchHistoryStats(w, httptest.NewRequest(http.MethodGet, "/history/searches/stats", nil))
+	json.NewDecoder(w.Body).Decode(&stats)
+	if stats.Searches != 4 || stats.Queries != 2 || len(stats.TopQueries) != 2 || stats.TopQueries[0].Query != "kittens" || stats.TopQueries[0].Count != 3 {
+		t.Errorf("stats = %+v", stats)
+	}
+	if len(stats.ZeroResults) != 1 || stats.ZeroResults[0].Query != "puppies" {
+		t.Errorf("zero-result queries = %+v", stats.ZeroResults)
+	}
+
+	if dropped, err := pruneHistory(time.Now().AddDate(0, 0, 91)); err != nil || dropped != 4 {
+		t.Errorf("pruneHistory = %d, %v", dropped, err)
+	}
+
+	// Nothing is kept unless asked for
+	config.Search.RecordHistory = false
+	handleSearch(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/search?q=kittens", nil))
+	config.Search.RecordHistory = true
+	w = httptest.NewRecorder()
+	handleSearchHistory(w, httptest.NewRequest(http.MethodGet, "/history/searches", nil))
+	json.NewDecoder(w.Body).Decode(&history)
+	if history.Total != 0 {
+		t.Errorf("history recorded while off = %+v", history)
+	}
+}
+
+func TestClearSearchHistory(t *testing.T) {
+	withPagesDir(t)
+	saved := config.Search
+	defer func() { config.Search = saved }()
+	config.Search.RecordHistory = true
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	if err := recordSearch(r, "kittens", 3, false); err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	handleSearchHistory(w, httptest.NewRequest(http.MethodDelete, "/history/searches", nil))
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("status = %d", w.Code)
+	}
+	if records, _ := userHistory(r); len(records) != 0 {
+		t.Errorf("history after clearing = %+v", records)
+	}
+}
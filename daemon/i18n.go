@@ -0,0 +1,338 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const defaultLocale = "en"
+
+// translations holds the bundled display strings per language. Keys ending in
+// ".one" and ".other" are the singular and plural forms of a count.
+var translations = map[string]map[string]string{
+	"en": {
+		"time.just_now":          "just now",
+		"time.minutes.one":       "%d minute ago",
+		"time.minutes.other":     "%d minutes ago",
+		"time.hours.one":         "%d hour ago",
+		"time.hours.other":       "%d hours ago",
+		"time.days.one":          "%d day ago",
+		"time.days.other":        "%d days ago",
+		"time.months.one":        "%d month ago",
+		"time.months.other":      "%d months ago",
+		"time.years.one":         "%d year ago",
+		"time.years.other":       "%d years ago",
+		"opds.title":             "Memento long reads",
+		"opds.summary":           "%d words, saved from %s",
+		"feed.title":             "Memento archive",
+		"feed.title.tag":         "Memento archive: %s",
+		"feed.changes":           "Memento archive changes",
+		"ui.search_label":        "Search your archive",
+		"ui.search_button":       "Search",
+		"ui.results.one":         "%d result",
+		"ui.results.other":       "%d results",
+		"ui.no_results":          "No results found.",
+		"ui.corrected":           "Showing results for %s",
+		"ui.did_you_mean":        "Did you mean:",
+		"ui.search_failed":       "Search failed. Please try again later.",
+		"ui.invalid_query":       "The query could not be understood. Check quotes, brackets and field names.",
+		"ui.saved":               "Saved %s",
+		"ui.reader.theme":        "Theme",
+		"ui.reader.theme.auto":   "System",
+		"ui.reader.theme.light":  "Light",
+		"ui.reader.theme.dark":   "Dark",
+		"ui.reader.theme.sepia":  "Sepia",
+		"ui.reader.size":         "Text size",
+		"ui.reader.size.small":   "Small",
+		"ui.reader.size.medium":  "Medium",
+		"ui.reader.size.large":   "Large",
+		"ui.reader.width":        "Line width",
+		"ui.reader.width.narrow": "Narrow",
+		"ui.reader.width.medium": "Medium",
+		"ui.reader.width.wide":   "Wide",
+		"ui.reader.apply":        "Apply",
+		"ui.reader.original":     "Original page",
+		"ui.viewer.pdf":          "Original PDF",
+		"ui.viewer.page":         "Page %d",
+		"ui.viewer.first":        "Go to the first match",
+		"ui.viewer.nomatch":      "No matches for %s",
+		"action.read":            "Read",
+		"action.share":           "Share",
+		"action.epub":            "Download EPUB",
+		"action.star":            "Star",
+		"action.tag":             "Tag",
+		"action.delete":          "Delete",
+	},
+	"de": {
+		"time.just_now":          "gerade eben",
+		"time.minutes.one":       "vor %d Minute",
+		"time.minutes.other":     "vor %d Minuten",
+		"time.hours.one":         "vor %d Stunde",
+		"time.hours.other":       "vor %d Stunden",
+		"time.days.one":          "vor %d Tag",
+		"time.days.other":        "vor %d Tagen",
+		"time.months.one":        "vor %d Monat",
+		"time.months.other":      "vor %d Monaten",
+		"time.years.one":         "vor %d Jahr",
+		"time.years.other":       "vor %d Jahren",
+		"opds.title":             "Memento Langtexte",
+		"opds.summary":           "%d Wörter, gespeichert von %s",
+		"feed.title":             "Memento-Archiv",
+		"feed.title.tag":         "Memento-Archiv: %s",
+		"feed.changes":           "Änderungen am Memento-Archiv",
+		"ui.search_label":        "Archiv durchsuchen",
+		"ui.search_button":       "Suchen",
+		"ui.results.one":         "%d Ergebnis",
+		"ui.results.other":       "%d Ergebnisse",
+		"ui.no_results":          "Keine Ergebnisse gefunden.",
+		"ui.corrected":           "Ergebnisse für %s",
+		"ui.did_you_mean":        "Meinten Sie:",
+		"ui.search_failed":       "Die Suche ist fehlgeschlagen. Bitte später erneut versuchen.",
+		"ui.invalid_query":       "Die Suchanfrage ist ungültig. Bitte Anführungszeichen, Klammern und Feldnamen prüfen.",
+		"ui.saved":               "Gespeichert %s",
+		"ui.reader.theme":        "Design",
+		"ui.reader.theme.auto":   "System",
+		"ui.reader.theme.light":  "Hell",
+		"ui.reader.theme.dark":   "Dunkel",
+		"ui.reader.theme.sepia":  "Sepia",
+		"ui.reader.size":         "Schriftgröße",
+		"ui.reader.size.small":   "Klein",
+		"ui.reader.size.medium":  "Mittel",
+		"ui.reader.size.large":   "Groß",
+		"ui.reader.width":        "Zeilenbreite",
+		"ui.reader.width.narrow": "Schmal",
+		"ui.reader.width.medium": "Mittel",
+		"ui.reader.width.wide":   "Breit",
+		"ui.reader.apply":        "Übernehmen",
+		"ui.reader.original":     "Originalseite",
+		"ui.viewer.pdf":          "Original-PDF",
+		"ui.viewer.page":         "Seite %d",
+		"ui.viewer.first":        "Zum ersten Treffer",
+		"ui.viewer.nomatch":      "Keine Treffer für %s",
+		"action.read":            "Lesen",
+		"action.share":           "Teilen",
+		"action.epub":            "EPUB herunterladen",
+		"action.star":            "Markieren",
+		"action.tag":             "Verschlagworten",
+		"action.delete":          "Löschen",
+	},
+	"fr": {
+		"time.just_now":          "à l'instant",
+		"time.minutes.one":       "il y a %d minute",
+		"time.minutes.other":     "il y a %d minutes",
+		"time.hours.one":         "il y a %d heure",
+		"time.hours.other":       "il y a %d heures",
+		"time.days.one":          "il y a %d jour",
+		"time.days.other":        "il y a %d jours",
+		"time.months.one":        "il y a %d mois",
+		"time.months.other":      "il y a %d mois",
+		"time.years.one":         "il y a %d an",
+		"time.years.other":       "il y a %d ans",
+		"opds.title":             "Memento lectures longues",
+		"opds.summary":           "%d mots, enregistré depuis %s",
+		"feed.title":             "Archive Memento",
+		"feed.title.tag":         "Archive Memento : %s",
+		"feed.changes":           "Modifications de l'archive Memento",
+		"ui.search_label":        "Rechercher dans vos archives",
+		"ui.search_button":       "Rechercher",
+		"ui.results.one":         "%d résultat",
+		"ui.results.other":       "%d résultats",
+		"ui.no_results":          "Aucun résultat.",
+		"ui.corrected":           "Résultats pour %s",
+		"ui.did_you_mean":        "Vouliez-vous dire :",
+		"ui.search_failed":       "La recherche a échoué. Veuillez réessayer plus tard.",
+		"ui.invalid_query":       "La requête est invalide. Vérifiez les guillemets, les parenthèses et les noms de champs.",
+		"ui.saved":               "Enregistré %s",
+		"ui.reader.theme":        "Thème",
+		"ui.reader.theme.auto":   "Système",
+		"ui.reader.theme.light":  "Clair",
+		"ui.reader.theme.dark":   "Sombre",
+		"ui.reader.theme.sepia":  "Sépia",
+		"ui.reader.size":         "Taille du texte",
+		"ui.reader.size.small":   "Petite",
+		"ui.reader.size.medium":  "Moyenne",
+		"ui.reader.size.large":   "Grande",
+		"ui.reader.width":        "Largeur des lignes",
+		"ui.reader.width.narrow": "Étroite",
+		"ui.reader.width.medium": "Moyenne",
+		"ui.reader.width.wide":   "Large",
+		"ui.reader.apply":        "Appliquer",
+		"ui.reader.original":     "Page d'origine",
+		"ui.viewer.pdf":          "PDF d'origine",
+		"ui.viewer.page":         "Page %d",
+		"ui.viewer.first":        "Aller au premier résultat",
+		"ui.viewer.nomatch":      "Aucun résultat pour %s",
+		"action.read":            "Lire",
+		"action.share":           "Partager",
+		"action.epub":            "Télécharger l'EPUB",
+		"action.star":            "Favori",
+		"action.tag":             "Étiqueter",
+		"action.delete":          "Supprimer",
+	},
+	"es": {
+		"time.just_now":          "ahora mismo",
+		"time.minutes.one":       "hace %d minuto",
+		"time.minutes.other":     "hace %d minutos",
+		"time.hours.one":         "hace %d hora",
+		"time.hours.other":       "hace %d horas",
+		"time.days.one":          "hace %d día",
+		"time.days.other":        "hace %d días",
+		"time.months.one":        "hace %d mes",
+		"time.months.other":      "hace %d meses",
+		"time.years.one":         "hace %d año",
+		"time.years.other":       "hace %d años",
+		"opds.title":             "Memento lecturas largas",
+		"opds.summary":           "%d palabras, guardado desde %s",
+		"feed.title":             "Archivo de Memento",
+		"feed.title.tag":         "Archivo de Memento: %s",
+		"feed.changes":           "Cambios en el archivo de Memento",
+		"ui.search_label":        "Buscar en tu archivo",
+		"ui.search_button":       "Buscar",
+		"ui.results.one":         "%d resultado",
+		"ui.results.other":       "%d resultados",
+		"ui.no_results":          "No se encontraron resultados.",
+		"ui.corrected":           "Mostrando resultados de %s",
+		"ui.did_you_mean":        "Quizás quisiste decir:",
+		"ui.search_failed":       "La búsqueda falló. Inténtalo de nuevo más tarde.",
+		"ui.invalid_query":       "La consulta no es válida. Revisa las comillas, los paréntesis y los nombres de campo.",
+		"ui.saved":               "Guardado %s",
+		"ui.reader.theme":        "Tema",
+		"ui.reader.theme.auto":   "Sistema",
+		"ui.reader.theme.light":  "Claro",
+		"ui.reader.theme.dark":   "Oscuro",
+		"ui.reader.theme.sepia":  "Sepia",
+		"ui.reader.size":         "Tamaño del texto",
+		"ui.reader.size.small":   "Pequeño",
+		"ui.reader.size.medium":  "Mediano",
+		"ui.reader.size.large":   "Grande",
+		"ui.reader.width":        "Ancho de línea",
+		"ui.reader.width.narrow": "Estrecho",
+		"ui.reader.width.medium": "Mediano",
+		"ui.reader.width.wide":   "Ancho",
+		"ui.reader.apply":        "Aplicar",
+		"ui.reader.original":     "Página original",
+		"ui.viewer.pdf":          "PDF original",
+		"ui.viewer.page":         "Página %d",
+		"ui.viewer.first":        "Ir a la primera coincidencia",
+		"ui.viewer.nomatch":      "Sin resultados para %s",
+		"action.read":            "Leer",
+		"action.share":           "Compartir",
+		"action.epub":            "Descargar EPUB",
+		"action.star":            "Destacar",
+		"action.tag":             "Etiquetar",
+		"action.delete":          "Eliminar",
+	},
+}
+
+var dateLayouts = map[string]string{
+	"en": "Jan 2, 2006",
+	"de": "02.01.2006",
+	"fr": "02/01/2006",
+	"es": "02/01/2006",
+}
+
+// Localizer renders display strings for one language.
+type Localizer struct {
+	Lang string
+}
+
+// localizerFor picks the request's language from the lang query parameter or
+// the Accept-Language header, falling back to the configured locale.
+func localizerFor(r *http.Request) Localizer {
+	if lang := r.URL.Query().Get("lang"); translations[lang] != nil {
+		return Localizer{Lang: lang}
+	}
+	if lang := matchAcceptLanguage(r.Header.Get("Accept-Language")); lang != "" {
+		return Localizer{Lang: lang}
+	}
+	if translations[config.Locale] != nil {
+		return Localizer{Lang: config.Locale}
+	}
+	return Localizer{Lang: defaultLocale}
+}
+
+// matchAcceptLanguage returns the supported language with the highest
+// quality in an Accept-Language header, or "" if none is supported.
+func matchAcceptLanguage(header string) string {
+	type candidate struct {
+		lang    string
+		quality float64
+	}
+	var candidates []candidate
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(strings.TrimSpace(part), ";")
+		lang := strings.ToLower(strings.TrimSpace(strings.SplitN(fields[0], "-", 2)[0]))
+		quality := 1.0
+		for _, param := range fields[1:] {
+			if q := strings.TrimPrefix(strings.TrimSpace(param), "q="); q != param {
+				if v, err := strconv.ParseFloat(q, 64); err == nil {
+					quality = v
+				}
+			}
+		}
+		if translations[lang] != nil && quality > 0 {
+			candidates = append(candidates, candidate{lang, quality})
+		}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].quality > candidates[j].quality
+	})
+	if len(candidates) == 0 {
+		return ""
+	}
+	return candidates[0].lang
+}
+
+// T returns the translated string for key, formatted with args. Missing
+// translations fall back to English and then to the key itself.
+func (l Localizer) T(key string, args ...interface{}) string {
+	format, ok := translations[l.Lang][key]
+	if !ok {
+		if format, ok = translations[defaultLocale][key]; !ok {
+			format = key
+		}
+	}
+	if len(args) == 0 {
+		return format
+	}
+	return fmt.Sprintf(format, args...)
+}
+
+func (l Localizer) plural(key string, n int) string {
+	if n == 1 {
+		return l.T(key+".one", n)
+	}
+	return l.T(key+".other", n)
+}
+
+// RelativeTime describes t relative to now, e.g. "3 days ago".
+func (l Localizer) RelativeTime(t, now time.Time) string {
+	d := now.Sub(t)
+	switch {
+	case d < time.Minute:
+		return l.T("time.just_now")
+	case d < time.Hour:
+		return l.plural("time.minutes", int(d/time.Minute))
+	case d < 24*time.Hour:
+		return l.plural("time.hours", int(d/time.Hour))
+	case d < 30*24*time.Hour:
+		return l.plural("time.days", int(d/(24*time.Hour)))
+	case d < 365*24*time.Hour:
+		return l.plural("time.months", int(d/(30*24*time.Hour)))
+	default:
+		return l.plural("time.years", int(d/(365*24*time.Hour)))
+	}
+}
+
+// FormatDate renders a calendar date in the language's conventional layout.
+func (l Localizer) FormatDate(t time.Time) string {
+	layout, ok := dateLayouts[l.Lang]
+	if !ok {
+		layout = dateLayouts[defaultLocale]
+	}
+	return t.Format(layout)
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMatchAcceptLanguage(t *testing.T) {
+	tests := []struct {
+		header string
+		want   string
+	}{
+		{"", ""},
+		{"de", "de"},
+		{"de-CH", "de"},
+		{"FR-fr", "fr"},
+		{"ja, es;q=0.5", "es"},
+		{"en;q=0.3, fr;q=0.9, de;q=0.5", "fr"},
+		{"fr;q=0, en", "en"},
+		{"es;q=0.8, fr;q=0.8", "es"},
+		{"de;q=bogus", "de"},
+		{"*", ""},
+		{"ja, zh", ""},
+		{" , ;q=1, de ; q=0.4", "de"},
+	}
+	for _, tt := range tests {
+		if got := matchAcceptLanguage(tt.header); got != tt.want {
+			t.Errorf("matchAcceptLanguage(%q) = %q, want %q", tt.header, got, tt.want)
+		}
+	}
+}
+
+func TestLocalizerFor(t *testing.T) {
+	saved := config
+	defer func() { config = saved }()
+	config.Locale = "de"
+
+	tests := []struct {
+		url, acceptLanguage, want string
+	}{
+		{"/search?lang=fr", "es", "fr"},
+		{"/search?lang=xx", "es", "es"},
+		{"/search", "ja", "de"},
+		{"/search", "", "de"},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", tt.url, nil)
+		r.Header.Set("Accept-Language", tt.acceptLanguage)
+		if got := localizerFor(r).Lang; got != tt.want {
+			t.Errorf("localizerFor(%s, %q) = %s, want %s", tt.url, tt.acceptLanguage, got, tt.want)
+		}
+	}
+
+	config.Locale = "xx"
+	if got := localizerFor(httptest.NewRequest("GET", "/", nil)).Lang; got != defaultLocale {
+		t.Errorf("unsupported configured locale: got %s, want %s", got, defaultLocale)
+	}
+}
+
+func TestTranslationsComplete(t *testing.T) {
+	for lang, strings := range translations {
+		for key := range translations[defaultLocale] {
+			if _, ok := strings[key]; !ok {
+				t.Errorf("%s is missing %s", lang, key)
+			}
+		}
+		for key := range strings {
+			if _, ok := translations[defaultLocale][key]; !ok {
+				t.Errorf("%s has %s, which %s lacks", lang, key, defaultLocale)
+			}
+		}
+		if _, ok := dateLayouts[lang]; !ok {
+			t.Errorf("%s has no date layout", lang)
+		}
+	}
+}
+
+func TestLocalizer(t *testing.T) {
+	now := time.Date(2024, 5, 10, 12, 0, 0, 0, time.UTC)
+	en, de := Localizer{Lang: "en"}, Localizer{Lang: "de"}
+	if got := en.FormatDate(now); got != "May 10, 2024" {
+		t.Errorf("en date = %q", got)
+	}
+	if got := de.FormatDate(now); got != "10.05.2024" {
+		t.Errorf("de date = %q", got)
+	}
+	if got := (Localizer{Lang: "xx"}).FormatDate(now); got != "May 10, 2024" {
+		t.Errorf("fallback date = %q", got)
+	}
+	if got := en.T("no.such.key"); got != "no.such.key" {
+		t.Errorf("missing key = %q", got)
+	}
+
+	tests := []struct {
+		ago  time.Duration
+		want string
+	}{
+		{30 * time.Second, en.T("time.just_now")},
+		{time.Minute, en.T("time.minutes.one", 1)},
+		{5 * time.Minute, en.T("time.minutes.other", 5)},
+		{3 * time.Hour, en.T("time.hours.other", 3)},
+		{24 * time.Hour, en.T("time.days.one", 1)},
+		{60 * 24 * time.Hour, en.T("time.months.other", 2)},
+		{800 * 24 * time.Hour, en.T("time.years.other", 2)},
+	}
+	for _, tt := range tests {
+		got := en.RelativeTime(now.Add(-tt.ago), now)
+		if got != tt.want || strings.Contains(got, "%!") {
+			t.Errorf("RelativeTime(%v ago) = %q, want %q", tt.ago, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,622 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	// maxImportBytes limits an uploaded import, and maxExtractedBytes what
+	// a bundle may expand to, so a small compressed upload can't fill the
+	// disk.
+	maxImportBytes    = 2 << 30
+	maxExtractedBytes = 8 << 30
+)
+
+var (
+	errUnrecognizedBundle = errors.New("unrecognized bundle format")
+	errBundleTooLarge     = errors.New("bundle expands beyond the maximum import size")
+)
+
+type importSummary struct {
+	Imported   int `json:"imported"`
+	Duplicates int `json:"duplicates"`
+	Renamed    int `json:"renamed"`
+	Failed     int `json:"failed"`
+}
+
+// importPath imports either an export bundle file or a directory holding
+// pages, such as an extracted bundle or another machine's pages directory.
+func importPath(ctx context.Context, path string) (importSummary, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return importSummary{}, err
+	}
+	if info.IsDir() {
+		return importDirectory(ctx, path)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return importSummary{}, err
+	}
+	defer f.Close()
+	return importBundle(ctx, f, info.Size())
+}
+
+// importBundle extracts a tar.gz or zip export bundle to a temporary
+// directory and imports the pages from it. WARC files are imported directly.
+func importBundle(ctx context.Context, r io.ReaderAt, size int64) (importSummary, error) {
+	if isWARC(r, size) {
+		return importWARC(ctx, io.NewSectionReader(r, 0, size))
+	}
+
+	tmpDir, err := ioutil.TempDir("", "memento-import-")
+	if err != nil {
+		return importSummary{}, err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	magic := make([]byte, 2)
+	if _, err := r.ReadAt(magic, 0); err != nil {
+		return importSummary{}, fmt.Errorf("reading bundle: %v", err)
+	}
+	switch {
+	case magic[0] == 0x1f && magic[1] == 0x8b:
+		err = extractTarGz(io.NewSectionReader(r, 0, size), tmpDir)
+	case magic[0] == 'P' && magic[1] == 'K':
+		err = extractZip(r, size, tmpDir)
+	default:
+		err = errUnrecognizedBundle
+	}
+	if err != nil {
+		return importSummary{}, err
+	}
+	return importDirectory(ctx, tmpDir)
+}
+
+// bundlePagePath maps a bundle entry to its destination, ignoring anything
+// outside the pages directory of the bundle and its pages' assets
+// directories.
+func bundlePagePath(dir, name string) (string, bool) {
+	name = filepath.ToSlash(name)
+	if !strings.HasPrefix(name, exportPagesPrefix) {
+		return "", false
+	}
+	base := strings.TrimPrefix(name, exportPagesPrefix)
+	if assetDir, asset, ok := strings.Cut(base, "/"); ok {
+		if !strings.HasSuffix(assetDir, "_files") || assetDir == "_files" || !validAssetName(asset) {
+			return "", false
+		}
+		return filepath.Join(dir, assetDir, asset), true
+	}
+	if !validAssetName(base) {
+		return "", false
+	}
+	return filepath.Join(dir, base), true
+}
+
+func validAssetName(name string) bool {
+	return name != "" && !strings.Contains(name, "/") && name != "." && name != ".."
+}
+
+func extractTarGz(r io.Reader, dir string) error {
+	gz, err := gzip.NewReader(bufio.NewReader(r))
+	if err != nil {
+		return err
+	}
+	defer gz.Close()
+
+	tr := tar.NewReader(gz)
+	remaining := int64(maxExtractedBytes)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		dest, ok := bundlePagePath(dir, header.Name)
+		if !ok {
+			continue
+		}
+		if err := writeFileFrom(dest, tr, &remaining); err != nil {
+			return err
+		}
+	}
+}
+
+func extractZip(r io.ReaderAt, size int64, dir string) error {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return err
+	}
+	remaining := int64(maxExtractedBytes)
+	for _, file := range zr.File {
+		dest, ok := bundlePagePath(dir, file.Name)
+		if !ok || file.FileInfo().IsDir() {
+			continue
+		}
+		rc, err := file.Open()
+		if err != nil {
+			return err
+		}
+		err = writeFileFrom(dest, rc, &remaining)
+		rc.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeFileFrom extracts one bundle entry, counting it against the bytes
+// remaining for the whole bundle. No entry may be larger than a capture.
+func writeFileFrom(path string, r io.Reader, remaining *int64) error {
+	limit := *remaining
+	if limit > maxCaptureBytes {
+		limit = maxCaptureBytes
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	n, err := io.Copy(f, io.LimitReader(r, limit+1))
+	if err != nil {
+		f.Close()
+		return err
+	}
+	if n > limit {
+		f.Close()
+		return errBundleTooLarge
+	}
+	*remaining -= n
+	return f.Close()
+}
+
+// importDirectory copies pages into the pages directory. Pages whose content
+// is already archived are skipped, and pages whose ID is taken by different
+// content are stored under a new ID. Imported pages are left unindexed for
+// the indexer.
+func importDirectory(ctx context.Context, dir string) (importSummary, error) {
+	var summary importSummary
+
+	// Extracted bundles keep their pages in a subdirectory
+	if info, err := os.Stat(filepath.Join(dir, "pages")); err == nil && info.IsDir() {
+		dir = filepath.Join(dir, "pages")
+	}
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return summary, err
+	}
+
+	knownHashes, err := knownContentHashes()
+	if err != nil {
+		return summary, err
+	}
+
+	var ids []string
+	for _, file := range files {
+		if !file.IsDir() && strings.HasSuffix(file.Name(), ".json") && file.Name() != exportManifestName {
+			ids = append(ids, strings.TrimSuffix(file.Name(), ".json"))
+		}
+	}
+	for i, srcID := range ids {
+		if err := ctx.Err(); err != nil {
+			return summary, err
+		}
+		reportProgress(ctx, i, len(ids))
+		renamed, duplicate, err := importPage(dir, srcID, knownHashes)
+		switch {
+		case err != nil:
+			log.Printf("Error importing page %s: %v", srcID, err)
+			summary.Failed++
+		case duplicate:
+			summary.Duplicates++
+		default:
+			summary.Imported++
+			if renamed {
+				summary.Renamed++
+			}
+		}
+	}
+	reportProgress(ctx, len(ids), len(ids))
+	return summary, nil
+}
+
+// linkRecord is a saved link from a service whose exports carry no page
+// content, such as a bookmarking or read-later service.
+type linkRecord struct {
+	URL       string
+	Title     string
+	Text      string
+	Tags      []string
+	Timestamp time.Time
+	Source    string
+}
+
+// markdown renders the record in the same shape as the extension's captures:
+// a title heading and URL line followed by any excerpt or note.
+func (record linkRecord) markdown() string {
+	var markdown strings.Builder
+	fmt.Fprintf(&markdown, "# %s\n\n", record.Title)
+	if record.URL != "" {
+		fmt.Fprintf(&markdown, "URL: %s\n\n", record.URL)
+	}
+	if text := strings.TrimSpace(record.Text); text != "" {
+		fmt.Fprintf(&markdown, "%s\n", text)
+	}
+	return markdown.String()
+}
+
+// importLinkRecords stores link records as markdown pages so their titles,
+// URLs and notes are searchable, skipping records already archived.
+func importLinkRecords(ctx context.Context, records []linkRecord) (importSummary, error) {
+	var summary importSummary
+
+	knownHashes, err := knownContentHashes()
+	if err != nil {
+		return summary, err
+	}
+
+	for i, record := range records {
+		if err := ctx.Err(); err != nil {
+			return summary, err
+		}
+		reportProgress(ctx, i, len(records))
+		if record.Title == "" {
+			record.Title = record.URL
+		}
+		if record.Title == "" {
+			summary.Failed++
+			continue
+		}
+		markdown := record.markdown()
+
+		hash := contentHash([]byte(markdown))
+		if knownHashes[hash] {
+			summary.Duplicates++
+			continue
+		}
+		metadata := PageMetadata{
+			URL:       record.URL,
+			Title:     record.Title,
+			Timestamp: record.Timestamp,
+			Tags:      record.Tags,
+			Source:    record.Source,
+		}
+		if _, err := storePage(metadata, "", markdown); err != nil {
+			log.Printf("Error storing %s link %s: %v", record.Source, record.URL, err)
+			summary.Failed++
+			continue
+		}
+		knownHashes[hash] = true
+		summary.Imported++
+	}
+	reportProgress(ctx, len(records), len(records))
+	return summary, nil
+}
+
+// knownContentHashes collects the content hashes of all stored pages for
+// deduplicating imports.
+func knownContentHashes() (map[string]bool, error) {
+	pages, err := listPages()
+	if err != nil {
+		return nil, err
+	}
+	hashes := make(map[string]bool)
+	for _, page := range pages {
+		if hash, err := pageContentHash(page.PageMetadata); err == nil {
+			hashes[hash] = true
+		}
+	}
+	return hashes, nil
+}
+
+func importPage(dir, srcID string, knownHashes map[string]bool) (renamed, duplicate bool, err error) {
+	data, err := ioutil.ReadFile(filepath.Join(dir, srcID+".json"))
+	if err != nil {
+		return false, false, err
+	}
+	var metadata PageMetadata
+	if err := json.Unmarshal(data, &metadata); err != nil {
+		return false, false, err
+	}
+
+	// Compressed HTML and markdown are decompressed, and stored again as
+	// capture.compression says
+	readContent := func(name string) ([]byte, error) {
+		if name == "" || filepath.Base(name) != name {
+			return nil, nil
+		}
+		content, err := readContentFile(filepath.Join(dir, name))
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return content, err
+	}
+	html, err := readContent(metadata.HTMLFilename)
+	if err != nil {
+		return false, false, err
+	}
+	var markdown []byte
+	if metadata.HasMarkdown {
+		if markdown, err = readContent(metadata.MDFilename); err != nil {
+			return false, false, err
+		}
+	}
+	if html == nil && markdown == nil {
+		return false, false, fmt.Errorf("no content files")
+	}
+
+	if html != nil {
+		html = []byte(storedHTML(string(html)))
+	}
+	hash := contentHash(html)
+	if markdown != nil {
+		hash = contentHash(markdown)
+	}
+	if knownHashes[hash] {
+		return false, true, nil
+	}
+
+	id := srcID
+	if !validPageID.MatchString(id) {
+		id = newPageID(metadata.URL, metadata.Timestamp)
+	} else {
+		id = uniquePageID(id)
+	}
+
+	screenshot, err := readContent(metadata.ScreenshotFilename)
+	if err != nil {
+		return false, false, err
+	}
+	pdf, err := readContent(metadata.PDFFilename)
+	if err != nil {
+		return false, false, err
+	}
+	metadata.HTMLFilename, metadata.MDFilename, metadata.ScreenshotFilename, metadata.PDFFilename = "", "", "", ""
+	// Thumbnails are regenerated on demand
+	metadata.ThumbnailFilename = ""
+	metadata.HasMarkdown = false
+	metadata.ContentEncoding = contentEncoding(contentFileName(id, ".html"))
+	if html != nil {
+		metadata.HTMLFilename = contentFileName(id, ".html")
+		if err := writeContentFile(filepath.Join(pagesDir, metadata.HTMLFilename), html); err != nil {
+			return false, false, err
+		}
+	}
+	if markdown != nil {
+		metadata.MDFilename = contentFileName(id, ".md")
+		metadata.HasMarkdown = true
+		if err := writeContentFile(filepath.Join(pagesDir, metadata.MDFilename), markdown); err != nil {
+			return false, false, err
+		}
+	}
+	if screenshot != nil {
+		metadata.ScreenshotFilename = id + ".png"
+		if err := ioutil.WriteFile(filepath.Join(pagesDir, metadata.ScreenshotFilename), screenshot, 0644); err != nil {
+			return false, false, err
+		}
+	}
+	if pdf != nil {
+		metadata.PDFFilename = id + ".pdf"
+		if err := ioutil.WriteFile(filepath.Join(pagesDir, metadata.PDFFilename), pdf, 0644); err != nil {
+			return false, false, err
+		}
+	}
+	if err := importAssets(filepath.Join(dir, srcID+"_files"), id); err != nil {
+		return false, false, err
+	}
+	metadata.ContentHash = hash
+	metadata.Indexed = false
+	// Owners are token names on the exporting daemon, which mean nothing
+	// here and would count the page against a local token's quota
+	metadata.Owner = ""
+	if err := writeMetadata(id, metadata); err != nil {
+		return false, false, err
+	}
+	publishPageEvent(eventPageCaptured, id, metadata)
+
+	knownHashes[hash] = true
+	return id != srcID, false, nil
+}
+
+// fileImportRequest is an import job's parameters: a bundle or directory on
+// the daemon's machine, or an uploaded bundle, Karakeep export or read-later
+// service export spooled to disk.
+type fileImportRequest struct {
+	Path string `json:"path,omitempty"`
+	// Upload is the spooled request body, removed when the job ends.
+	Upload   string `json:"upload,omitempty"`
+	Service  string `json:"service,omitempty"`
+	Karakeep bool   `json:"karakeep,omitempty"`
+}
+
+// handleImport accepts an export bundle as the request body, a Karakeep JSON
+// export, a read-later service export named by the service query parameter,
+// or a JSON body of the form {"path": "..."} naming a bundle or directory on
+// the daemon's machine. Uploads are spooled to disk and the pages imported
+// and indexed by a job, which is responded with.
+func handleImport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, ErrMethodNotAllowed, "Method not allowed")
+		return
+	}
+	// Imported pages aren't attributed to a token, so they would bypass its
+	// quotas
+	if token := requestToken(r); token != nil && token.Quota.limited() {
+		writeError(w, http.StatusForbidden, ErrActionDisabled, "Imports are not available to tokens with quotas")
+		return
+	}
+
+	service := r.URL.Query().Get("service")
+	if _, ok := readLaterParsers[service]; service != "" && !ok {
+		writeError(w, http.StatusBadRequest, ErrUnsupportedContent, fmt.Sprintf("Unsupported read-later service %q", service))
+		return
+	}
+	// Zip archives need random access, and jobs outlive the request, so
+	// spool the upload to disk first
+	upload, err := spoolUpload(http.MaxBytesReader(w, r.Body, maxImportBytes))
+	if isTooLarge(err) {
+		writeError(w, http.StatusRequestEntityTooLarge, ErrCaptureTooLarge, "Import exceeds the maximum size")
+		return
+	}
+	if err != nil {
+		log.Printf("Error spooling upload: %v", err)
+		writeError(w, http.StatusInternalServerError, ErrInternal, "Import failed")
+		return
+	}
+	request := fileImportRequest{Upload: upload, Service: service}
+	if service == "" && strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		var body struct {
+			Path      string          `json:"path"`
+			Bookmarks json.RawMessage `json:"bookmarks"`
+		}
+		data, err := ioutil.ReadFile(upload)
+		if err == nil {
+			err = json.Unmarshal(data, &body)
+		}
+		switch {
+		case err != nil:
+			os.Remove(upload)
+			writeError(w, http.StatusBadRequest, ErrInvalidRequest, "Invalid request body")
+			return
+		case body.Bookmarks != nil:
+			request.Karakeep = true
+		case body.Path != "":
+			os.Remove(upload)
+			if _, err := os.Stat(body.Path); err != nil {
+				writeError(w, http.StatusBadRequest, ErrInvalidRequest, fmt.Sprintf("Import failed: %v", err))
+				return
+			}
+			request = fileImportRequest{Path: body.Path}
+		default:
+			os.Remove(upload)
+			writeError(w, http.StatusBadRequest, ErrInvalidRequest, "Missing path or bookmarks")
+			return
+		}
+	}
+
+	job, err := startJob(jobImport, requestUser(r), request)
+	if err != nil {
+		os.Remove(request.Upload)
+		log.Printf("Error starting import: %v", err)
+		writeError(w, http.StatusInternalServerError, ErrInternal, "Failed to start import")
+		return
+	}
+	writeJob(w, job)
+}
+
+// spoolUpload copies a request body to a temporary file, returning its path.
+func spoolUpload(body io.Reader) (string, error) {
+	tmp, err := ioutil.TempFile("", "memento-upload-")
+	if err != nil {
+		return "", err
+	}
+	_, err = io.Copy(tmp, body)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	return tmp.Name(), nil
+}
+
+// runImportJob imports the pages a job names and indexes them, returning
+// the import's summary. Jobs without a path or upload pull pages from web
+// archives.
+func runImportJob(ctx context.Context, job *Job) (interface{}, error) {
+	var request fileImportRequest
+	if err := json.Unmarshal(job.Params, &request); err != nil {
+		return nil, err
+	}
+	if request.Path == "" && request.Upload == "" {
+		return runMementoImportJob(ctx, job)
+	}
+	if request.Upload != "" {
+		defer os.Remove(request.Upload)
+	}
+	summary, err := request.run(ctx)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("Imported %d pages (%d duplicates, %d renamed, %d failed)", summary.Imported, summary.Duplicates, summary.Renamed, summary.Failed)
+	indexExistingFiles(ctx)
+	return summary, nil
+}
+
+func (request fileImportRequest) run(ctx context.Context) (importSummary, error) {
+	if request.Path != "" {
+		return importPath(ctx, request.Path)
+	}
+	f, err := os.Open(request.Upload)
+	if err != nil {
+		return importSummary{}, err
+	}
+	defer f.Close()
+	switch {
+	case request.Service != "":
+		return importReadLater(ctx, request.Service, f)
+	case request.Karakeep:
+		var export karakeepExport
+		if err := json.NewDecoder(f).Decode(&export); err != nil {
+			return importSummary{}, err
+		}
+		return importKarakeepBookmarks(ctx, export.Bookmarks)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		return importSummary{}, err
+	}
+	return importBundle(ctx, f, info.Size())
+}
+
+// importAssets copies an imported page's assets directory, if it has one.
+func importAssets(src, id string) error {
+	assets, err := ioutil.ReadDir(src)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(assetsDir(id), 0755); err != nil {
+		return err
+	}
+	for _, asset := range assets {
+		if asset.IsDir() {
+			continue
+		}
+		data, err := ioutil.ReadFile(filepath.Join(src, asset.Name()))
+		if err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(filepath.Join(assetsDir(id), asset.Name()), data, 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
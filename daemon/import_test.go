@@ -0,0 +1,279 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBundleExportImportRoundTrip(t *testing.T) {
+	for _, format := range []string{"tar.gz", "zip"} {
+		withPagesDir(t)
+		a, err := storePage(PageMetadata{URL: "https://example.com/a", Title: "A", Owner: "alex"}, "<p>a</p>", "# A")
+		if err != nil {
+			t.Fatal(err)
+		}
+		os.MkdirAll(assetsDir(a), 0755)
+		ioutil.WriteFile(filepath.Join(assetsDir(a), "logo.png"), fakePNG, 0644)
+		if _, err := storePage(PageMetadata{URL: "https://example.com/b", Title: "B", Tags: []string{"go"}}, "<p>b</p>", ""); err != nil {
+			t.Fatal(err)
+		}
+		var bundle bytes.Buffer
+		if err := writeExport(&bundle, format); err != nil {
+			t.Fatalf("%s: %v", format, err)
+		}
+
+		summary, err := importBundle(context.Background(), bytes.NewReader(bundle.Bytes()), int64(bundle.Len()))
+		if err != nil || summary.Duplicates != 2 || summary.Imported != 0 {
+			t.Errorf("%s: reimport = %+v, %v; want 2 duplicates", format, summary, err)
+		}
+
+		withPagesDir(t)
+		summary, err = importBundle(context.Background(), bytes.NewReader(bundle.Bytes()), int64(bundle.Len()))
+		if err != nil || summary.Imported != 2 {
+			t.Fatalf("%s: import = %+v, %v; want 2 imported", format, summary, err)
+		}
+		pages, err := listPages()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, page := range pages {
+			if page.Owner != "" {
+				t.Errorf("%s: page %s kept owner %q", format, page.URL, page.Owner)
+			}
+			if page.URL == "https://example.com/b" && (len(page.Tags) != 1 || page.Tags[0] != "go") {
+				t.Errorf("%s: tags = %v, want [go]", format, page.Tags)
+			}
+			if page.URL == "https://example.com/a" && !page.HasMarkdown {
+				t.Errorf("%s: markdown was not imported", format)
+			}
+			if page.URL == "https://example.com/a" {
+				if data, err := ioutil.ReadFile(filepath.Join(assetsDir(page.ID), "logo.png")); !bytes.Equal(data, fakePNG) {
+					t.Errorf("%s: asset = %q, %v; want it imported", format, data, err)
+				}
+			}
+		}
+	}
+}
+
+func TestBundleExportImportPageFiles(t *testing.T) {
+	for _, format := range []string{"tar.gz", "zip"} {
+		withPagesDir(t)
+		saved := config.Capture.Compression
+		config.Capture.Compression = encodingGzip
+		gzipped, err := storePage(PageMetadata{URL: "https://example.com/gzipped", Title: "Gzipped"}, "<p>stored gzipped</p>", "")
+		config.Capture.Compression = saved
+		if err != nil {
+			t.Fatal(err)
+		}
+		if page, err := loadPage(gzipped); err != nil || !strings.HasSuffix(page.HTMLFilename, gzipExt) {
+			t.Fatalf("gzipped page = %+v, %v", page, err)
+		}
+		shot, err := storePage(PageMetadata{URL: "https://example.com/shot", Title: "Shot"}, "<p>shot</p>", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := attachPageFile(shot, shot+".png", fakePNG, func(m *PageMetadata, name string) { m.ScreenshotFilename = name }); err != nil {
+			t.Fatal(err)
+		}
+		// Larger than io.Copy's buffer
+		font := bytes.Repeat([]byte("font"), 64<<10)
+		os.MkdirAll(assetsDir(shot), 0755)
+		if err := ioutil.WriteFile(filepath.Join(assetsDir(shot), "font.woff2"), font, 0644); err != nil {
+			t.Fatal(err)
+		}
+		pdf := []byte("%PDF-1.4 quarterly report")
+		paper, err := storePage(PageMetadata{URL: "https://example.com/report.pdf", Title: "Report"}, "", "# Quarterly report")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := attachPageFile(paper, paper+".pdf", pdf, func(m *PageMetadata, name string) { m.PDFFilename = name }); err != nil {
+			t.Fatal(err)
+		}
+
+		var bundle bytes.Buffer
+		if err := writeExport(&bundle, format); err != nil {
+			t.Fatalf("%s: %v", format, err)
+		}
+		withPagesDir(t)
+		summary, err := importBundle(context.Background(), bytes.NewReader(bundle.Bytes()), int64(bundle.Len()))
+		if err != nil || summary.Imported != 3 {
+			t.Fatalf("%s: import = %+v, %v; want 3 imported", format, summary, err)
+		}
+		pages, err := listPages()
+		if err != nil {
+			t.Fatal(err)
+		}
+		byURL := make(map[string]Page)
+		for _, page := range pages {
+			byURL[page.URL] = page
+		}
+
+		page := byURL["https://example.com/gzipped"]
+		if html, err := readContentFile(filepath.Join(pagesDir, page.HTMLFilename)); !strings.Contains(string(html), "stored gzipped") {
+			t.Errorf("%s: gzipped content = %q, %v", format, html, err)
+		}
+		page = byURL["https://example.com/shot"]
+		if data, err := ioutil.ReadFile(filepath.Join(pagesDir, page.ScreenshotFilename)); page.ScreenshotFilename == "" || !bytes.Equal(data, fakePNG) {
+			t.Errorf("%s: screenshot %q = %q, %v", format, page.ScreenshotFilename, data, err)
+		}
+		if data, err := ioutil.ReadFile(filepath.Join(assetsDir(page.ID), "font.woff2")); !bytes.Equal(data, font) {
+			t.Errorf("%s: asset of %d bytes, %v; want %d", format, len(data), err, len(font))
+		}
+		page = byURL["https://example.com/report.pdf"]
+		if data, err := ioutil.ReadFile(filepath.Join(pagesDir, page.PDFFilename)); page.PDFFilename == "" || !bytes.Equal(data, pdf) {
+			t.Errorf("%s: PDF %q = %q, %v", format, page.PDFFilename, data, err)
+		}
+	}
+}
+
+func TestHandleImport(t *testing.T) {
+	withPagesDir(t)
+	withJobs(t)
+	for _, title := range []string{"One", "Two"} {
+		if _, err := storePage(PageMetadata{URL: "https://example.com/" + title, Title: title}, "", "Text of "+title); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var bundle bytes.Buffer
+	if err := writeExport(&bundle, "zip"); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "export.zip")
+	if err := ioutil.WriteFile(path, bundle.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	withPagesDir(t)
+	withIndex(t, nil)
+
+	router := newRouter()
+	post := func(query, contentType string, body []byte) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/import"+query, bytes.NewReader(body))
+		req.Header.Set("Content-Type", contentType)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+	// follow polls the job an import started until it finishes
+	follow := func(rec *httptest.ResponseRecorder) (Job, importSummary) {
+		t.Helper()
+		if rec.Code != http.StatusAccepted {
+			t.Fatalf("POST /import = %d %s", rec.Code, rec.Body)
+		}
+		location := rec.Header().Get("Location")
+		var job Job
+		for deadline := time.Now().Add(5 * time.Second); job.Status == "" || job.Status == jobRunning; time.Sleep(10 * time.Millisecond) {
+			if time.Now().After(deadline) {
+				t.Fatalf("import job still running: %+v", job)
+			}
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, location, nil))
+			json.Unmarshal(rec.Body.Bytes(), &job)
+		}
+		var summary importSummary
+		json.Unmarshal(job.Result, &summary)
+		return job, summary
+	}
+
+	job, summary := follow(post("", "application/zip", bundle.Bytes()))
+	if job.Kind != jobImport || job.Status != jobDone || job.Done != 2 || job.Total != 2 || summary.Imported != 2 {
+		t.Errorf("bundle import job = %+v, summary %+v", job, summary)
+	}
+	pages, err := listPages()
+	if err != nil || len(pages) != 2 || !pages[0].Indexed || !pages[1].Indexed {
+		t.Errorf("imported pages = %+v, %v", pages, err)
+	}
+
+	job, summary = follow(post("", "application/json", []byte(`{"path": "`+path+`"}`)))
+	if job.Status != jobDone || summary.Duplicates != 2 {
+		t.Errorf("path import job = %+v, summary %+v", job, summary)
+	}
+
+	csv := "URL,Title,Selection,Folder,Timestamp\nhttps://go.dev,Go,,Unread,1700000000\n"
+	job, summary = follow(post("?service=instapaper", "text/csv", []byte(csv)))
+	if job.Status != jobDone || job.Done != 1 || job.Total != 1 || summary.Imported != 1 {
+		t.Errorf("read-later import job = %+v, summary %+v", job, summary)
+	}
+
+	if job, _ = follow(post("", "application/octet-stream", []byte("not a bundle"))); job.Status != jobFailed || job.Error != errUnrecognizedBundle.Error() {
+		t.Errorf("import of an unknown format = %+v", job)
+	}
+	if rec := post("", "application/json", []byte(`{"path": "`+filepath.Join(t.TempDir(), "missing")+`"}`)); rec.Code != http.StatusBadRequest {
+		t.Errorf("import of a missing path = %d", rec.Code)
+	}
+	if rec := post("?service=delicious", "text/csv", []byte(csv)); rec.Code != http.StatusBadRequest || decodeError(t, rec).Code != ErrUnsupportedContent {
+		t.Errorf("import from an unknown service = %d", rec.Code)
+	}
+}
+
+func TestImportBundleMalformed(t *testing.T) {
+	withPagesDir(t)
+	tests := []struct {
+		name    string
+		data    []byte
+		wantErr error
+	}{
+		{"empty", nil, nil},
+		{"unknown format", []byte("not a bundle"), errUnrecognizedBundle},
+		{"truncated gzip", []byte{0x1f, 0x8b, 0x08}, nil},
+		{"truncated zip", []byte("PK\x03\x04"), nil},
+	}
+	for _, tt := range tests {
+		_, err := importBundle(context.Background(), bytes.NewReader(tt.data), int64(len(tt.data)))
+		if err == nil {
+			t.Errorf("%s: no error", tt.name)
+		} else if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+			t.Errorf("%s: error = %v, want %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestImportBundleRejectsOversizedEntry(t *testing.T) {
+	withPagesDir(t)
+	var bundle bytes.Buffer
+	gz := gzip.NewWriter(&bundle)
+	tw := tar.NewWriter(gz)
+	size := int64(maxCaptureBytes + 1)
+	tw.WriteHeader(&tar.Header{Name: exportPagesPrefix + "x.html", Mode: 0644, Size: size, ModTime: time.Now(), Typeflag: tar.TypeReg})
+	tw.Write(make([]byte, size))
+	tw.Close()
+	gz.Close()
+
+	if _, err := importBundle(context.Background(), bytes.NewReader(bundle.Bytes()), int64(bundle.Len())); !errors.Is(err, errBundleTooLarge) {
+		t.Errorf("got %v, want errBundleTooLarge", err)
+	}
+}
+
+func TestBundlePagePath(t *testing.T) {
+	tests := []struct {
+		name string
+		ok   bool
+	}{
+		{exportPagesPrefix + "abc.json", true},
+		{exportPagesPrefix, false},
+		{exportPagesPrefix + "../evil.json", false},
+		{exportPagesPrefix + "sub/abc.json", false},
+		{exportPagesPrefix + "abc_files/logo.png", true},
+		{exportPagesPrefix + "abc_files/sub/logo.png", false},
+		{exportPagesPrefix + "abc_files/..", false},
+		{exportPagesPrefix + "_files/logo.png", false},
+		{"manifest.json", false},
+		{"/etc/passwd", false},
+	}
+	for _, tt := range tests {
+		if _, ok := bundlePagePath("/tmp/x", tt.name); ok != tt.ok {
+			t.Errorf("bundlePagePath(%q) ok = %v, want %v", tt.name, ok, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,324 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+const jobsFile = "memento_jobs.json"
+
+const (
+	// Finished jobs are kept for jobRetention, and at most the newest
+	// maxFinishedJobs of them.
+	jobRetention    = 7 * 24 * time.Hour
+	maxFinishedJobs = 200
+	// maxJobRuns bounds how often a job the daemon stopped during is
+	// started again, so that one crashing it doesn't do so forever.
+	maxJobRuns = 3
+	// jobSaveInterval spaces the saves of running jobs' progress.
+	jobSaveInterval = time.Second
+)
+
+// Job kinds.
+const (
+	jobArchive   = "archive"
+	jobImport    = "import"
+	jobReindex   = "reindex"
+	jobReextract = "reextract"
+	jobSite      = "site"
+)
+
+// Job states.
+const (
+	jobRunning   = "running"
+	jobDone      = "done"
+	jobFailed    = "failed"
+	jobCancelled = "cancelled"
+)
+
+// Job is a long-running operation run in the background. Jobs are saved
+// as they run, and those the daemon stopped during are run again from
+// their parameters when it starts.
+type Job struct {
+	ID     string          `json:"id"`
+	Kind   string          `json:"kind"`
+	Params json.RawMessage `json:"params,omitempty"`
+	// Owner is the user who started the job.
+	Owner    string     `json:"owner,omitempty"`
+	Status   string     `json:"status"`
+	Created  time.Time  `json:"created"`
+	Started  time.Time  `json:"started"`
+	Finished *time.Time `json:"finished,omitempty"`
+	// Done counts the units of work, such as pages, done so far of Total,
+	// which is 0 until known.
+	Done  int `json:"done"`
+	Total int `json:"total"`
+	// Runs counts the times the job was started, more than once if the
+	// daemon stopped while it ran.
+	Runs  int    `json:"runs"`
+	Error string `json:"error,omitempty"`
+	// Result is what the job produced, such as an archived page's ID or an
+	// import's summary.
+	Result json.RawMessage `json:"result,omitempty"`
+
+	cancel context.CancelFunc
+}
+
+// jobRunner runs a job of a kind from its parameters, returning its result.
+type jobRunner func(ctx context.Context, job *Job) (interface{}, error)
+
+var jobRunners = map[string]jobRunner{
+	jobArchive:   runArchiveJob,
+	jobImport:    runImportJob,
+	jobReindex:   runReindexJob,
+	jobReextract: runReextractJob,
+	jobSite:      runSiteJob,
+}
+
+var jobs = struct {
+	sync.Mutex
+	byID  map[string]*Job
+	saved time.Time
+}{byID: make(map[string]*Job)}
+
+type jobKey struct{}
+
+// reportProgress records how far the job running with ctx, if any, has got:
+// done of total units, where a total of 0 leaves the total as it was.
+func reportProgress(ctx context.Context, done, total int) {
+	job, ok := ctx.Value(jobKey{}).(*Job)
+	if !ok {
+		return
+	}
+	jobs.Lock()
+	defer jobs.Unlock()
+	job.Done = done
+	if total > 0 {
+		job.Total = total
+	}
+	if time.Since(jobs.saved) >= jobSaveInterval {
+		saveJobs()
+	}
+}
+
+// saveJobs writes the jobs to the jobs file atomically. jobs must be
+// locked.
+func saveJobs() {
+	saved := make([]*Job, 0, len(jobs.byID))
+	for _, job := range jobs.byID {
+		saved = append(saved, job)
+	}
+	sort.Slice(saved, func(i, j int) bool { return saved[i].Created.Before(saved[j].Created) })
+	data, err := json.MarshalIndent(saved, "", "  ")
+	if err == nil {
+		tmp := jobsFile + ".tmp"
+		if err = ioutil.WriteFile(tmp, data, 0644); err == nil {
+			err = os.Rename(tmp, jobsFile)
+		}
+	}
+	if err != nil {
+		log.Printf("Error saving jobs: %v", err)
+	}
+	jobs.saved = time.Now()
+}
+
+// pruneJobs forgets finished jobs past jobRetention or beyond the newest
+// maxFinishedJobs. jobs must be locked.
+func pruneJobs(now time.Time) {
+	var finished []*Job
+	for id, job := range jobs.byID {
+		switch {
+		case job.Finished == nil:
+		case now.Sub(*job.Finished) > jobRetention:
+			delete(jobs.byID, id)
+		default:
+			finished = append(finished, job)
+		}
+	}
+	sort.Slice(finished, func(i, j int) bool { return finished[i].Finished.After(*finished[j].Finished) })
+	for i := maxFinishedJobs; i < len(finished); i++ {
+		delete(jobs.byID, finished[i].ID)
+	}
+}
+
+// startJob saves a job of kind with params and starts running it,
+// returning it as started.
+func startJob(kind, owner string, params interface{}) (Job, error) {
+	var data []byte
+	if params != nil {
+		var err error
+		if data, err = json.Marshal(params); err != nil {
+			return Job{}, err
+		}
+	}
+	id := make([]byte, 8)
+	rand.Read(id)
+	job := &Job{ID: hex.EncodeToString(id), Kind: kind, Params: data, Owner: owner, Created: time.Now()}
+	jobs.Lock()
+	defer jobs.Unlock()
+	pruneJobs(job.Created)
+	jobs.byID[job.ID] = job
+	launchJob(job)
+	saveJobs()
+	return *job, nil
+}
+
+// launchJob marks a job as running and runs it in the background. jobs
+// must be locked.
+func launchJob(job *Job) {
+	ctx, cancel := context.WithCancel(context.WithValue(context.Background(), jobKey{}, job))
+	job.cancel = cancel
+	job.Status, job.Started, job.Finished, job.Error, job.Result = jobRunning, time.Now(), nil, "", nil
+	job.Runs++
+	go runJob(ctx, job)
+}
+
+func runJob(ctx context.Context, job *Job) {
+	defer job.cancel()
+	var result interface{}
+	err := fmt.Errorf("unknown job kind %q", job.Kind)
+	if run := jobRunners[job.Kind]; run != nil {
+		result, err = run(ctx, job)
+	}
+	var data []byte
+	if err == nil && result != nil {
+		data, err = json.Marshal(result)
+	}
+
+	now := time.Now()
+	jobs.Lock()
+	job.Finished, job.Result = &now, data
+	switch {
+	case errors.Is(err, context.Canceled):
+		job.Status = jobCancelled
+	case err != nil:
+		job.Status, job.Error = jobFailed, err.Error()
+	default:
+		job.Status = jobDone
+	}
+	saveJobs()
+	jobs.Unlock()
+	if err != nil && !errors.Is(err, context.Canceled) {
+		log.Printf("Error running %s job %s: %v", job.Kind, job.ID, err)
+		publishError("", fmt.Errorf("%s job %s: %v", job.Kind, job.ID, err))
+	}
+}
+
+// cancelJob cancels a running job, reporting whether it is known.
+func cancelJob(id string) bool {
+	jobs.Lock()
+	defer jobs.Unlock()
+	job, ok := jobs.byID[id]
+	if ok && job.Status == jobRunning {
+		job.cancel()
+	}
+	return ok
+}
+
+// resumeJobs loads the jobs saved by the daemon's last run, running those
+// it stopped during again.
+func resumeJobs() {
+	data, err := ioutil.ReadFile(jobsFile)
+	if os.IsNotExist(err) {
+		return
+	}
+	var saved []*Job
+	if err == nil {
+		err = json.Unmarshal(data, &saved)
+	}
+	if err != nil {
+		log.Printf("Error reading %s: %v", jobsFile, err)
+		return
+	}
+	jobs.Lock()
+	defer jobs.Unlock()
+	now := time.Now()
+	for _, job := range saved {
+		jobs.byID[job.ID] = job
+		if job.Status != jobRunning {
+			continue
+		}
+		if !jobKindEnabled(job.Kind) {
+			job.Status, job.Finished = jobCancelled, &now
+			job.Error = "its feature is turned off"
+			continue
+		}
+		if job.Runs >= maxJobRuns {
+			job.Status, job.Finished = jobFailed, &now
+			job.Error = fmt.Sprintf("interrupted %d times", job.Runs)
+			continue
+		}
+		log.Printf("Resuming %s job %s", job.Kind, job.ID)
+		launchJob(job)
+	}
+	pruneJobs(now)
+	saveJobs()
+}
+
+// jobVisible reports whether a request may see a job: its own, or any for
+// admins.
+func jobVisible(r *http.Request, job *Job) bool {
+	return isAdmin(r) || job.Owner == requestUser(r)
+}
+
+// writeJob responds to a request that started a job with the job, at the
+// URL to follow it by.
+func writeJob(w http.ResponseWriter, job Job) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", "/api/v1/jobs/"+job.ID)
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(job)
+}
+
+// handleJobs lists the recent jobs, newest first, optionally only those of
+// a kind= or status=.
+func handleJobs(w http.ResponseWriter, r *http.Request) {
+	kind, status := r.URL.Query().Get("kind"), r.URL.Query().Get("status")
+	jobs.Lock()
+	visible := []Job{}
+	for _, job := range jobs.byID {
+		if jobVisible(r, job) && (kind == "" || job.Kind == kind) && (status == "" || job.Status == status) {
+			visible = append(visible, *job)
+		}
+	}
+	jobs.Unlock()
+	sort.Slice(visible, func(i, j int) bool { return visible[i].Created.After(visible[j].Created) })
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(visible)
+}
+
+// handleJob returns a job's progress, or cancels it on DELETE. What a
+// cancelled job did is kept.
+func handleJob(w http.ResponseWriter, r *http.Request) {
+	jobs.Lock()
+	job, ok := jobs.byID[r.PathValue("id")]
+	var snapshot Job
+	if ok && jobVisible(r, job) {
+		snapshot = *job
+	} else {
+		ok = false
+	}
+	jobs.Unlock()
+	if !ok {
+		writeError(w, http.StatusNotFound, ErrNotFound, "Job not found")
+		return
+	}
+	if r.Method == http.MethodDelete {
+		cancelJob(snapshot.ID)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(snapshot)
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// withJobs starts the test with no jobs, and waits for those it starts to
+// finish when it ends.
+func withJobs(t *testing.T) {
+	t.Helper()
+	jobs.Lock()
+	jobs.byID = make(map[string]*Job)
+	jobs.Unlock()
+	t.Cleanup(func() {
+		for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+			running := false
+			jobs.Lock()
+			for _, job := range jobs.byID {
+				running = running || job.Status == jobRunning
+			}
+			jobs.Unlock()
+			if !running {
+				return
+			}
+		}
+		t.Error("jobs still running")
+	})
+}
+
+// waitForJob returns a job once it has finished.
+func waitForJob(t *testing.T, id string) Job {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		jobs.Lock()
+		job := *jobs.byID[id]
+		jobs.Unlock()
+		if job.Status != jobRunning || time.Now().After(deadline) {
+			return job
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// withTestJobs adds a job kind that reports its progress, then waits for
+// release and fails if its params say so.
+func withTestJobs(t *testing.T) chan struct{} {
+	release := make(chan struct{})
+	jobRunners["test"] = func(ctx context.Context, job *Job) (interface{}, error) {
+		var params struct{ Fail bool }
+		json.Unmarshal(job.Params, &params)
+		reportProgress(ctx, 1, 2)
+		select {
+		case <-release:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		if params.Fail {
+			return nil, errors.New("out of cheese")
+		}
+		return map[string]int{"answer": 42}, nil
+	}
+	t.Cleanup(func() { delete(jobRunners, "test") })
+	return release
+}
+
+func TestJobs(t *testing.T) {
+	withPagesDir(t)
+	withJobs(t)
+	release := withTestJobs(t)
+	request := func(method, path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		newRouter().ServeHTTP(rec, httptest.NewRequest(method, path, nil))
+		return rec
+	}
+
+	done, _ := startJob("test", "", nil)
+	failed, _ := startJob("test", "", map[string]bool{"fail": true})
+	cancelled, _ := startJob("test", "", nil)
+	if done.Status != jobRunning || done.Runs != 1 {
+		t.Errorf("started job = %+v", done)
+	}
+	var job Job
+	for deadline := time.Now().Add(5 * time.Second); job.Done == 0 && time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		json.Unmarshal(request(http.MethodGet, "/api/v1/jobs/"+done.ID).Body.Bytes(), &job)
+	}
+	if job.Status != jobRunning || job.Done != 1 || job.Total != 2 {
+		t.Errorf("GET running job = %+v", job)
+	}
+
+	if rec := request(http.MethodDelete, "/api/v1/jobs/"+cancelled.ID); rec.Code != http.StatusNoContent {
+		t.Errorf("DELETE job = %d", rec.Code)
+	}
+	if job := waitForJob(t, cancelled.ID); job.Status != jobCancelled {
+		t.Errorf("cancelled job = %+v", job)
+	}
+	close(release)
+	if job := waitForJob(t, done.ID); job.Status != jobDone || string(job.Result) != `{"answer":42}` || job.Finished == nil {
+		t.Errorf("finished job = %+v", job)
+	}
+	if job := waitForJob(t, failed.ID); job.Status != jobFailed || job.Error != "out of cheese" {
+		t.Errorf("failed job = %+v", job)
+	}
+
+	var listed []Job
+	json.Unmarshal(request(http.MethodGet, "/api/v1/jobs").Body.Bytes(), &listed)
+	if len(listed) != 3 || listed[0].ID != cancelled.ID {
+		t.Errorf("GET /jobs = %+v, want the three jobs newest first", listed)
+	}
+	json.Unmarshal(request(http.MethodGet, "/api/v1/jobs?status=failed").Body.Bytes(), &listed)
+	if len(listed) != 1 || listed[0].ID != failed.ID {
+		t.Errorf("GET /jobs?status=failed = %+v", listed)
+	}
+	if rec := request(http.MethodGet, "/api/v1/jobs/unknown"); rec.Code != http.StatusNotFound {
+		t.Errorf("GET unknown job = %d", rec.Code)
+	}
+
+	// Jobs are saved as they finish
+	var saved []Job
+	data, _ := ioutil.ReadFile(jobsFile)
+	if err := json.Unmarshal(data, &saved); err != nil || len(saved) != 3 {
+		t.Errorf("saved jobs = %s", data)
+	}
+}
+
+func TestResumeJobs(t *testing.T) {
+	withPagesDir(t)
+	withJobs(t)
+	release := withTestJobs(t)
+	close(release)
+	now := time.Now()
+	old := now.Add(-8 * 24 * time.Hour)
+	saved, _ := json.Marshal([]Job{
+		{ID: "interrupted", Kind: "test", Status: jobRunning, Created: now, Runs: 1, Done: 1},
+		{ID: "crashing", Kind: "test", Status: jobRunning, Created: now, Runs: maxJobRuns},
+		{ID: "finished", Kind: "test", Status: jobDone, Created: now, Finished: &now},
+		{ID: "expired", Kind: "test", Status: jobDone, Created: old, Finished: &old},
+	})
+	ioutil.WriteFile(jobsFile, saved, 0644)
+
+	resumeJobs()
+	if job := waitForJob(t, "interrupted"); job.Status != jobDone || job.Runs != 2 {
+		t.Errorf("resumed job = %+v", job)
+	}
+	if job := waitForJob(t, "crashing"); job.Status != jobFailed || job.Runs != maxJobRuns {
+		t.Errorf("job interrupted too often = %+v", job)
+	}
+	jobs.Lock()
+	_, finished := jobs.byID["finished"]
+	_, expired := jobs.byID["expired"]
+	jobs.Unlock()
+	if !finished || expired {
+		t.Errorf("finished jobs kept: %v, expired kept: %v", finished, expired)
+	}
+}
+
+func TestReindexJob(t *testing.T) {
+	withPagesDir(t)
+	withIndex(t, nil)
+	withJobs(t)
+	for _, title := range []string{"One", "Two"} {
+		storePage(PageMetadata{URL: "https://example.com/" + title, Title: title}, "", "Text of "+title)
+	}
+
+	rec := httptest.NewRecorder()
+	newRouter().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/v1/reindex", nil))
+	var job Job
+	json.Unmarshal(rec.Body.Bytes(), &job)
+	if rec.Code != http.StatusAccepted || rec.Header().Get("Location") != "/api/v1/jobs/"+job.ID || job.Kind != jobReindex {
+		t.Fatalf("POST /reindex = %d %s", rec.Code, rec.Body)
+	}
+	if job = waitForJob(t, job.ID); job.Status != jobDone || job.Done != 2 || job.Total != 2 {
+		t.Errorf("reindex job = %+v", job)
+	}
+}
+
+func TestArchiveJob(t *testing.T) {
+	withPagesDir(t)
+	withIndex(t, nil)
+	withJobs(t)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte("<title>Later</title><p>Archived in the background</p>"))
+	}))
+	defer server.Close()
+	saved := archiveClient
+	archiveClient = server.Client()
+	defer func() { archiveClient = saved }()
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/archive", strings.NewReader(`{"url": "`+server.URL+`/later", "async": true}`))
+	req.Header.Set("Content-Type", "application/json")
+	newRouter().ServeHTTP(rec, req)
+	var job Job
+	json.Unmarshal(rec.Body.Bytes(), &job)
+	if rec.Code != http.StatusAccepted || job.Kind != jobArchive {
+		t.Fatalf("POST /archive async = %d %s", rec.Code, rec.Body)
+	}
+	job = waitForJob(t, job.ID)
+	var result struct{ ID string }
+	json.Unmarshal(job.Result, &result)
+	if page, err := loadPage(result.ID); job.Status != jobDone || err != nil || page.Title != "Later" || !page.Indexed {
+		t.Errorf("archive job = %+v, page %+v, %v", job, page, err)
+	}
+}
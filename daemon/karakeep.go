@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+// karakeepExport is the JSON export format of Karakeep (formerly Hoarder).
+type karakeepExport struct {
+	Bookmarks []karakeepBookmark `json:"bookmarks"`
+}
+
+type karakeepBookmark struct {
+	CreatedAt int64            `json:"createdAt"`
+	Title     *string          `json:"title"`
+	Tags      []string         `json:"tags"`
+	Content   *karakeepContent `json:"content"`
+	Note      *string          `json:"note"`
+}
+
+type karakeepContent struct {
+	Type string `json:"type"`
+	URL  string `json:"url,omitempty"`
+	Text string `json:"text,omitempty"`
+}
+
+// linkRecordBody returns the excerpt or note of a page stored from a link
+// record, which follows the title heading and URL line of its markdown. It
+// returns false for pages that weren't stored that way.
+func linkRecordBody(page Page) (string, bool) {
+	if !page.HasMarkdown || page.HTMLFilename != "" {
+		return "", false
+	}
+	data, err := readContentFile(contentPath(page.PageMetadata))
+	if err != nil {
+		return "", false
+	}
+	markdown := string(data)
+	heading := "# " + page.Title + "\n\n"
+	if !strings.HasPrefix(markdown, heading) {
+		return "", false
+	}
+	markdown = strings.TrimPrefix(markdown, heading)
+	if page.URL != "" {
+		markdown = strings.TrimPrefix(markdown, "URL: "+page.URL+"\n\n")
+	}
+	return strings.TrimSpace(markdown), true
+}
+
+// writeKarakeepExport writes pages as Karakeep bookmarks. Pages with a URL
+// become link bookmarks, with the text of imported links as their note, and
+// pages without one become text bookmarks carrying their content.
+func writeKarakeepExport(w io.Writer) error {
+	pages, err := listPages()
+	if err != nil {
+		return err
+	}
+	sort.Slice(pages, func(i, j int) bool {
+		return pages[i].Timestamp.Before(pages[j].Timestamp)
+	})
+
+	export := karakeepExport{Bookmarks: []karakeepBookmark{}}
+	for _, page := range pages {
+		title := page.Title
+		bookmark := karakeepBookmark{
+			CreatedAt: page.Timestamp.Unix(),
+			Title:     &title,
+			Tags:      page.Tags,
+		}
+		body, isLinkRecord := linkRecordBody(page)
+		if page.URL != "" {
+			bookmark.Content = &karakeepContent{Type: "link", URL: page.URL}
+			if body != "" {
+				bookmark.Note = &body
+			}
+		} else {
+			if !isLinkRecord {
+				text, err := readPageText(page.PageMetadata)
+				if err != nil {
+					continue
+				}
+				body = text
+			}
+			bookmark.Content = &karakeepContent{Type: "text", Text: body}
+		}
+		if bookmark.Tags == nil {
+			bookmark.Tags = []string{}
+		}
+		export.Bookmarks = append(export.Bookmarks, bookmark)
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(export)
+}
+
+// importKarakeepBookmarks stores Karakeep bookmarks as pages. Karakeep
+// exports carry no page content, so link bookmarks become link records and
+// text bookmarks keep their text.
+func importKarakeepBookmarks(ctx context.Context, bookmarks []karakeepBookmark) (importSummary, error) {
+	var records []linkRecord
+	unsupported := 0
+	for _, bookmark := range bookmarks {
+		// Assets such as images and PDFs are not included in the export
+		if bookmark.Content == nil || (bookmark.Content.Type != "link" && bookmark.Content.Type != "text") {
+			unsupported++
+			continue
+		}
+
+		record := linkRecord{
+			URL:       bookmark.Content.URL,
+			Text:      bookmark.Content.Text,
+			Tags:      bookmark.Tags,
+			Timestamp: time.Unix(bookmark.CreatedAt, 0).UTC(),
+			Source:    "karakeep",
+		}
+		if bookmark.Title != nil {
+			record.Title = *bookmark.Title
+		}
+		if bookmark.Note != nil && *bookmark.Note != "" {
+			record.Text = strings.TrimSpace(record.Text + "\n\n" + *bookmark.Note)
+		}
+		records = append(records, record)
+	}
+
+	summary, err := importLinkRecords(ctx, records)
+	summary.Failed += unsupported
+	return summary, err
+}
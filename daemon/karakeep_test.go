@@ -0,0 +1,72 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func strPtr(s string) *string { return &s }
+
+func TestKarakeepExportImportRoundTrip(t *testing.T) {
+	withPagesDir(t)
+	created := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC).Unix()
+	bookmarks := []karakeepBookmark{
+		{CreatedAt: created, Title: strPtr("Link"), Tags: []string{"go"}, Content: &karakeepContent{Type: "link", URL: "https://example.com/a"}},
+		{CreatedAt: created, Title: strPtr("Noted"), Content: &karakeepContent{Type: "link", URL: "https://example.com/b"}, Note: strPtr("worth a reread")},
+		{CreatedAt: created, Title: strPtr("Thought"), Content: &karakeepContent{Type: "text", Text: "remember the milk"}},
+		{CreatedAt: created, Title: strPtr("Photo"), Content: &karakeepContent{Type: "asset"}},
+	}
+	summary, err := importKarakeepBookmarks(context.Background(), bookmarks)
+	if err != nil || summary.Imported != 3 || summary.Failed != 1 {
+		t.Fatalf("import = %+v, %v; want 3 imported and 1 failed", summary, err)
+	}
+	if _, err := storePage(PageMetadata{Title: "Captured"}, "<p>captured <b>text</b></p>", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	if err := writeKarakeepExport(&out); err != nil {
+		t.Fatal(err)
+	}
+	var export karakeepExport
+	if err := json.Unmarshal(out.Bytes(), &export); err != nil {
+		t.Fatal(err)
+	}
+	byTitle := make(map[string]karakeepBookmark)
+	for _, bookmark := range export.Bookmarks {
+		byTitle[*bookmark.Title] = bookmark
+	}
+
+	tests := []struct {
+		title, kind, url, text, note string
+	}{
+		{"Link", "link", "https://example.com/a", "", ""},
+		{"Noted", "link", "https://example.com/b", "", "worth a reread"},
+		{"Thought", "text", "", "remember the milk", ""},
+		{"Captured", "text", "", "captured text", ""},
+	}
+	for _, tt := range tests {
+		bookmark, ok := byTitle[tt.title]
+		if !ok {
+			t.Errorf("%s: not exported", tt.title)
+			continue
+		}
+		var note string
+		if bookmark.Note != nil {
+			note = *bookmark.Note
+		}
+		if bookmark.Content.Type != tt.kind || bookmark.Content.URL != tt.url || bookmark.Content.Text != tt.text || note != tt.note {
+			t.Errorf("%s: got %+v with note %q", tt.title, *bookmark.Content, note)
+		}
+	}
+
+	// Imported bookmarks come back out unchanged, so importing the export
+	// finds them all; only the captured page is new as a text bookmark
+	summary, err = importKarakeepBookmarks(context.Background(), export.Bookmarks)
+	if err != nil || summary.Imported != 1 || summary.Duplicates != 3 {
+		t.Errorf("reimport = %+v, %v; want 3 duplicates and 1 imported", summary, err)
+	}
+}
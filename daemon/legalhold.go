@@ -0,0 +1,352 @@
+package main
+
+import (
+	"archive/zip"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Legal-hold bundles are zip files of selected pages, exactly as stored,
+// with a manifest of their hashes signed by this archive's key. The
+// signature is over the manifest's bytes and can be checked with standard
+// tools as well as with verify-hold.
+const (
+	signingKeyFile    = "memento_signing_key.pem"
+	holdSignatureName = "manifest.sig"
+	holdPublicKeyName = "signing-key.pem"
+	holdVersion       = 1
+	maxHoldPages      = 1000
+)
+
+type holdManifest struct {
+	Version   int       `json:"version"`
+	CreatedAt time.Time `json:"createdAt"`
+	Note      string    `json:"note,omitempty"`
+	// PublicKey is the base64 Ed25519 key the manifest is signed with.
+	PublicKey string     `json:"publicKey"`
+	Pages     []holdPage `json:"pages"`
+}
+
+type holdPage struct {
+	ID             string            `json:"id"`
+	URL            string            `json:"url"`
+	Title          string            `json:"title"`
+	Timestamp      time.Time         `json:"timestamp"`
+	ContentHash    string            `json:"contentHash,omitempty"`
+	CaptureHeaders map[string]string `json:"captureHeaders,omitempty"`
+	Files          []exportFile      `json:"files"`
+}
+
+// holdRequest is the body of POST /export/hold.
+type holdRequest struct {
+	IDs  []string `json:"ids"`
+	Note string   `json:"note"`
+}
+
+var signingKeyMu sync.Mutex
+
+// signingKey returns the archive's Ed25519 key, generating it on first use.
+func signingKey() (ed25519.PrivateKey, error) {
+	signingKeyMu.Lock()
+	defer signingKeyMu.Unlock()
+	data, err := ioutil.ReadFile(signingKeyFile)
+	if os.IsNotExist(err) {
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		der, err := x509.MarshalPKCS8PrivateKey(key)
+		if err != nil {
+			return nil, err
+		}
+		data = pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+		if err := ioutil.WriteFile(signingKeyFile, data, 0600); err != nil {
+			return nil, err
+		}
+		log.Printf("Generated signing key %s", signingKeyFile)
+		return key, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%s holds no PEM key", signingKeyFile)
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("parsing %s: %v", signingKeyFile, err)
+	}
+	key, ok := parsed.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("%s is not an Ed25519 key", signingKeyFile)
+	}
+	return key, nil
+}
+
+// keyFingerprint identifies a public key by the SHA-256 of its bytes.
+func keyFingerprint(key ed25519.PublicKey) string {
+	return contentHash(key)
+}
+
+// writeHoldBundle writes a signed bundle of the given pages as a zip file.
+func writeHoldBundle(w io.Writer, ids []string, note string) error {
+	key, err := signingKey()
+	if err != nil {
+		return err
+	}
+	var pages []Page
+	for _, id := range ids {
+		page, err := loadPage(id)
+		if err != nil {
+			return fmt.Errorf("page %s: %w", id, err)
+		}
+		pages = append(pages, page)
+	}
+
+	public := key.Public().(ed25519.PublicKey)
+	manifest := holdManifest{
+		Version:   holdVersion,
+		CreatedAt: time.Now().UTC(),
+		Note:      note,
+		PublicKey: base64.StdEncoding.EncodeToString(public),
+	}
+	bundle := &zipBundleWriter{zw: zip.NewWriter(w)}
+	for _, page := range pages {
+		entry := holdPage{
+			ID:             page.ID,
+			URL:            page.URL,
+			Title:          page.Title,
+			Timestamp:      page.Timestamp,
+			ContentHash:    page.ContentHash,
+			CaptureHeaders: page.CaptureHeaders,
+		}
+		for _, name := range exportFileNames(page) {
+			file, ok, err := addPageFile(bundle, name, page.Timestamp)
+			if err != nil {
+				return err
+			}
+			if ok {
+				entry.Files = append(entry.Files, file)
+			}
+		}
+		manifest.Pages = append(manifest.Pages, entry)
+	}
+
+	manifestBytes, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	der, err := x509.MarshalPKIXPublicKey(public)
+	if err != nil {
+		return err
+	}
+	for _, file := range []struct {
+		name string
+		data []byte
+	}{
+		{exportManifestName, manifestBytes},
+		{holdSignatureName, ed25519.Sign(key, manifestBytes)},
+		{holdPublicKeyName, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})},
+	} {
+		if err := addBundleData(bundle, file.name, file.data, manifest.CreatedAt); err != nil {
+			return err
+		}
+	}
+	return bundle.Close()
+}
+
+// verifyHoldBundle checks a bundle's signature and that it holds exactly
+// the files its manifest lists, with their hashes.
+func verifyHoldBundle(path string) (holdManifest, error) {
+	var manifest holdManifest
+	zr, err := zip.OpenReader(path)
+	if err != nil {
+		return manifest, err
+	}
+	defer zr.Close()
+	files := make(map[string]*zip.File)
+	for _, f := range zr.File {
+		files[f.Name] = f
+	}
+	open := func(name string) (io.ReadCloser, error) {
+		f, ok := files[name]
+		if !ok {
+			return nil, fmt.Errorf("bundle has no %s", name)
+		}
+		return f.Open()
+	}
+	// The manifest, signature and key are small
+	read := func(name string) ([]byte, error) {
+		rc, err := open(name)
+		if err != nil {
+			return nil, err
+		}
+		defer rc.Close()
+		return ioutil.ReadAll(io.LimitReader(rc, maxCaptureBytes))
+	}
+
+	manifestBytes, err := read(exportManifestName)
+	if err != nil {
+		return manifest, err
+	}
+	signature, err := read(holdSignatureName)
+	if err != nil {
+		return manifest, err
+	}
+	if err := json.Unmarshal(manifestBytes, &manifest); err != nil {
+		return manifest, fmt.Errorf("parsing %s: %v", exportManifestName, err)
+	}
+	public, err := base64.StdEncoding.DecodeString(manifest.PublicKey)
+	if err != nil || len(public) != ed25519.PublicKeySize {
+		return manifest, errors.New("manifest has no valid public key")
+	}
+	if !ed25519.Verify(public, manifestBytes, signature) {
+		return manifest, errors.New("manifest signature is invalid")
+	}
+	if pemBytes, err := read(holdPublicKeyName); err == nil {
+		block, _ := pem.Decode(pemBytes)
+		if block == nil {
+			return manifest, fmt.Errorf("%s holds no PEM key", holdPublicKeyName)
+		}
+		key, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if k, ok := key.(ed25519.PublicKey); err != nil || !ok || !k.Equal(ed25519.PublicKey(public)) {
+			return manifest, fmt.Errorf("%s doesn't match the manifest's key", holdPublicKeyName)
+		}
+	}
+
+	listed := make(map[string]bool)
+	for _, page := range manifest.Pages {
+		for _, file := range page.Files {
+			name := exportPagesPrefix + file.Name
+			listed[name] = true
+			rc, err := open(name)
+			if err != nil {
+				return manifest, err
+			}
+			hash := sha256.New()
+			size, err := io.Copy(hash, rc)
+			rc.Close()
+			if err != nil {
+				return manifest, fmt.Errorf("reading %s: %v", name, err)
+			}
+			if size != int64(file.Size) || hex.EncodeToString(hash.Sum(nil)) != file.SHA256 {
+				return manifest, fmt.Errorf("%s doesn't match its hash", name)
+			}
+		}
+	}
+	for name := range files {
+		if strings.HasPrefix(name, exportPagesPrefix) && !listed[name] {
+			return manifest, fmt.Errorf("%s isn't listed in the manifest", name)
+		}
+	}
+	return manifest, nil
+}
+
+// handleHoldExport responds to POST /export/hold, given as
+// {"ids": ["..."], "note": "..."}, with a signed bundle of those pages, and
+// to GET with the public key bundles are signed with.
+func handleHoldExport(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		key, err := signingKey()
+		if err != nil {
+			log.Printf("Error loading signing key: %v", err)
+			writeError(w, http.StatusInternalServerError, ErrInternal, "Failed to load signing key")
+			return
+		}
+		public := key.Public().(ed25519.PublicKey)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{
+			"publicKey":   base64.StdEncoding.EncodeToString(public),
+			"fingerprint": keyFingerprint(public),
+		})
+	case http.MethodPost:
+		var request holdRequest
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil || len(request.IDs) == 0 {
+			writeError(w, http.StatusBadRequest, ErrInvalidRequest, "Request body must list the ids of pages to include")
+			return
+		}
+		if len(request.IDs) > maxHoldPages {
+			writeError(w, http.StatusBadRequest, ErrInvalidRequest, fmt.Sprintf("A bundle holds at most %d pages", maxHoldPages))
+			return
+		}
+		for _, id := range request.IDs {
+			if page, err := loadPage(id); err != nil || !pageVisible(r, page.PageMetadata) {
+				writeError(w, http.StatusNotFound, ErrNotFound, "Page not found: "+id)
+				return
+			}
+		}
+		filename := fmt.Sprintf("memento-hold-%s.zip", time.Now().Format("20060102"))
+		w.Header().Set("Content-Type", "application/zip")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+		if err := writeHoldBundle(w, request.IDs, request.Note); err != nil {
+			// Headers are already sent, so the client sees a truncated bundle
+			log.Printf("Legal-hold export failed: %v", err)
+		}
+	default:
+		writeError(w, http.StatusMethodNotAllowed, ErrMethodNotAllowed, "Method not allowed")
+	}
+}
+
+func runHoldCommand(args []string) {
+	flags := flag.NewFlagSet("hold", flag.ExitOnError)
+	note := flags.String("note", "", "why the pages are being preserved")
+	flags.Parse(args)
+	if flags.NArg() < 2 {
+		fmt.Fprintln(os.Stderr, "Usage: daemon hold [-note TEXT] <file> <id...>")
+		os.Exit(2)
+	}
+	f, err := os.Create(flags.Arg(0))
+	if err != nil {
+		log.Fatalf("Error creating bundle: %v", err)
+	}
+	if err := writeHoldBundle(f, flags.Args()[1:], *note); err != nil {
+		f.Close()
+		os.Remove(flags.Arg(0))
+		log.Fatalf("Legal-hold export failed: %v", err)
+	}
+	if err := f.Close(); err != nil {
+		log.Fatalf("Error writing bundle: %v", err)
+	}
+}
+
+func runVerifyHoldCommand(args []string) {
+	if len(args) != 1 {
+		fmt.Fprintln(os.Stderr, "Usage: daemon verify-hold <file>")
+		os.Exit(2)
+	}
+	manifest, err := verifyHoldBundle(args[0])
+	if err != nil {
+		log.Fatalf("Verification failed: %v", err)
+	}
+	public, _ := base64.StdEncoding.DecodeString(manifest.PublicKey)
+	fmt.Printf("Signature valid; signed %s by key %s\n", manifest.CreatedAt.Format(time.RFC3339), keyFingerprint(public))
+	if _, err := os.Stat(signingKeyFile); err == nil {
+		if key, err := signingKey(); err == nil && key.Public().(ed25519.PublicKey).Equal(ed25519.PublicKey(public)) {
+			fmt.Println("The key is this archive's signing key")
+		} else {
+			fmt.Println("The key is not this archive's signing key")
+		}
+	}
+	for _, page := range manifest.Pages {
+		fmt.Printf("%s  %s  %s (%d files)\n", page.Timestamp.Format(time.RFC3339), page.ID, page.URL, len(page.Files))
+	}
+}
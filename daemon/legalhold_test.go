@@ -0,0 +1,151 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// rewriteZip copies a zip file with its entries changed by edit.
+func rewriteZip(t *testing.T, src, dst string, edit func(entries map[string][]byte)) {
+	t.Helper()
+	zr, err := zip.OpenReader(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer zr.Close()
+	entries := make(map[string][]byte)
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		entries[f.Name], _ = ioutil.ReadAll(rc)
+		rc.Close()
+	}
+	edit(entries)
+	var b bytes.Buffer
+	zw := zip.NewWriter(&b)
+	for name, data := range entries {
+		fw, _ := zw.Create(name)
+		fw.Write(data)
+	}
+	zw.Close()
+	if err := ioutil.WriteFile(dst, b.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestHoldBundle(t *testing.T) {
+	withPagesDir(t)
+	withIndex(t, nil)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Header().Set("Last-Modified", "Mon, 01 Jan 2024 00:00:00 GMT")
+		w.Header().Set("Set-Cookie", "session=secret")
+		fmt.Fprint(w, "<html><head><title>Terms</title></head><body><p>Refunds within 30 days.</p></body></html>")
+	}))
+	defer server.Close()
+	saved := archiveClient
+	archiveClient = server.Client()
+	defer func() { archiveClient = saved }()
+
+	fetched, err := archiveURL(context.Background(), server.URL+"/terms", "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	page, _ := loadPage(fetched)
+	if page.CaptureHeaders["Last-Modified"] == "" || page.CaptureHeaders["Set-Cookie"] != "" {
+		t.Errorf("capture headers = %v", page.CaptureHeaders)
+	}
+	other, err := storePage(PageMetadata{URL: "https://example.com/other", Title: "Other"}, "<p>Other</p>", "# Other")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bundle := filepath.Join(t.TempDir(), "hold.zip")
+	f, _ := os.Create(bundle)
+	if err := writeHoldBundle(f, []string{fetched, other}, "dispute 42"); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	manifest, err := verifyHoldBundle(bundle)
+	if err != nil {
+		t.Fatalf("verifyHoldBundle() = %v", err)
+	}
+	if manifest.Note != "dispute 42" || len(manifest.Pages) != 2 || manifest.Pages[0].CaptureHeaders["Last-Modified"] == "" {
+		t.Errorf("manifest = %+v", manifest)
+	}
+	if len(manifest.Pages[1].Files) != 3 {
+		t.Errorf("files of %s = %+v, want metadata, HTML and markdown", other, manifest.Pages[1].Files)
+	}
+
+	tests := []struct {
+		name string
+		edit func(entries map[string][]byte)
+		want string
+	}{
+		{"changed page", func(e map[string][]byte) {
+			e[exportPagesPrefix+page.HTMLFilename] = bytes.Replace(e[exportPagesPrefix+page.HTMLFilename], []byte("30"), []byte("90"), 1)
+		}, "doesn't match its hash"},
+		{"added file", func(e map[string][]byte) { e[exportPagesPrefix+"extra.html"] = []byte("<p>Extra</p>") }, "isn't listed"},
+		{"removed file", func(e map[string][]byte) { delete(e, exportPagesPrefix+other+".json") }, "bundle has no"},
+		{"changed manifest", func(e map[string][]byte) {
+			e[exportManifestName] = bytes.Replace(e[exportManifestName], []byte("dispute 42"), []byte("dispute 43"), 1)
+		}, "signature is invalid"},
+		{"unsigned", func(e map[string][]byte) { delete(e, holdSignatureName) }, "bundle has no"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tampered := filepath.Join(t.TempDir(), "tampered.zip")
+			rewriteZip(t, bundle, tampered, tt.edit)
+			if _, err := verifyHoldBundle(tampered); err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("verifyHoldBundle() = %v, want an error containing %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestHandleHoldExport(t *testing.T) {
+	withPagesDir(t)
+	id, err := storePage(PageMetadata{URL: "https://example.com/a", Title: "A"}, "<p>A</p>", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name   string
+		method string
+		body   string
+		want   int
+	}{
+		{"key", http.MethodGet, "", http.StatusOK},
+		{"bundle", http.MethodPost, `{"ids":["` + id + `"],"note":"n"}`, http.StatusOK},
+		{"no ids", http.MethodPost, `{"ids":[]}`, http.StatusBadRequest},
+		{"unknown page", http.MethodPost, `{"ids":["missing"]}`, http.StatusNotFound},
+		{"delete", http.MethodDelete, "", http.StatusMethodNotAllowed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			handleHoldExport(w, httptest.NewRequest(tt.method, "/export/hold", strings.NewReader(tt.body)))
+			if w.Code != tt.want {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.want, w.Body)
+			}
+			if tt.name == "bundle" && w.Header().Get("Content-Type") != "application/zip" {
+				t.Errorf("Content-Type = %q", w.Header().Get("Content-Type"))
+			}
+		})
+	}
+	// The key made for the first request signs the rest
+	if info, err := os.Stat(signingKeyFile); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("signing key file = %v, %v", info, err)
+	}
+}
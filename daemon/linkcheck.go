@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// LinkCheckConfig checks up to Limit archived URLs every IntervalHours for
+// link rot, those never or least recently checked first. It is off when
+// IntervalHours is 0.
+type LinkCheckConfig struct {
+	IntervalHours int `json:"intervalHours"`
+	Limit         int `json:"limit"`
+}
+
+// DeadLink is a page in the link rot report: its URL was gone when last
+// checked, so it only survives in the archive.
+type DeadLink struct {
+	ID        string    `json:"id"`
+	URL       string    `json:"url"`
+	Title     string    `json:"title"`
+	Timestamp time.Time `json:"timestamp"`
+	LiveCheck
+}
+
+// linkCheckCandidates returns the current versions of the web pages among
+// pages, those never checked first and then the longest unchecked, one per
+// URL.
+func linkCheckCandidates(pages []Page, limit int) []Page {
+	var candidates []Page
+	seen := make(map[string]bool)
+	for _, page := range pages {
+		if page.SupersededBy == "" && webURL(page.URL) && !seen[page.URL] {
+			seen[page.URL] = true
+			candidates = append(candidates, page)
+		}
+	}
+	checkedAt := func(page Page) time.Time {
+		if page.LiveCheck == nil {
+			return time.Time{}
+		}
+		return page.LiveCheck.CheckedAt
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return checkedAt(candidates[i]).Before(checkedAt(candidates[j]))
+	})
+	if limit > 0 && len(candidates) > limit {
+		candidates = candidates[:limit]
+	}
+	return candidates
+}
+
+// checkLinks requests the URLs of pages, returning how many were gone.
+func checkLinks(ctx context.Context, pages []Page) int {
+	dead := 0
+	for _, page := range pages {
+		check, err := checkLive(ctx, page)
+		if err != nil {
+			log.Printf("Error recording link check of %s: %v", page.ID, err)
+		}
+		if check.gone() {
+			dead++
+		}
+	}
+	return dead
+}
+
+// watchLinkChecks checks archived URLs for link rot on the configured
+// schedule.
+func watchLinkChecks() {
+	if config.LinkCheck.IntervalHours == 0 {
+		return
+	}
+	for {
+		time.Sleep(time.Duration(config.LinkCheck.IntervalHours) * time.Hour)
+		pages, err := listPages()
+		if err != nil {
+			log.Printf("Error listing pages: %v", err)
+			continue
+		}
+		candidates := linkCheckCandidates(pages, config.LinkCheck.Limit)
+		dead := checkLinks(context.Background(), candidates)
+		log.Printf("Checked %d links, %d gone", len(candidates), dead)
+	}
+}
+
+// handleDeadLinks lists the pages whose URLs were gone when last checked,
+// those dead longest first. On POST it checks up to limit URLs again in
+// the background, those never or least recently checked first.
+func handleDeadLinks(w http.ResponseWriter, r *http.Request) {
+	limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+	if err != nil || limit <= 0 {
+		limit = defaultPageListLimit
+		if r.Method == http.MethodPost {
+			limit = config.LinkCheck.Limit
+		}
+	}
+	pages, err := listPages()
+	if err != nil {
+		log.Printf("Error listing pages: %v", err)
+		writeError(w, http.StatusInternalServerError, ErrInternal, "Failed to list pages")
+		return
+	}
+	var visible []Page
+	for _, page := range pages {
+		if pageVisible(r, page.PageMetadata) {
+			visible = append(visible, page)
+		}
+	}
+
+	if r.Method == http.MethodPost {
+		candidates := linkCheckCandidates(visible, limit)
+		go func() {
+			dead := checkLinks(context.Background(), candidates)
+			log.Printf("Checked %d links, %d gone", len(candidates), dead)
+		}()
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(map[string]int{"pages": len(candidates)})
+		return
+	}
+
+	dead := []DeadLink{}
+	for _, page := range visible {
+		c := page.LiveCheck
+		if c == nil || !c.gone() || page.SupersededBy != "" {
+			continue
+		}
+		dead = append(dead, DeadLink{ID: page.ID, URL: page.URL, Title: page.Title, Timestamp: page.Timestamp, LiveCheck: *c})
+	}
+	deadSince := func(link DeadLink) time.Time {
+		if link.DeadSince == nil {
+			return link.CheckedAt
+		}
+		return *link.DeadSince
+	}
+	sort.SliceStable(dead, func(i, j int) bool {
+		return deadSince(dead[i]).Before(deadSince(dead[j]))
+	})
+	if len(dead) > limit {
+		dead = dead[:limit]
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(dead)
+}
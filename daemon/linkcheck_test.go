@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestDeadLinks(t *testing.T) {
+	withPagesDir(t)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/gone":
+			http.NotFound(w, r)
+		case "/no-head":
+			if r.Method == http.MethodHead {
+				w.WriteHeader(http.StatusMethodNotAllowed)
+			}
+		case "/blocked":
+			w.WriteHeader(http.StatusForbidden)
+		}
+	}))
+	defer server.Close()
+	saved := archiveClient
+	archiveClient = server.Client()
+	defer func() { archiveClient = saved }()
+
+	for _, path := range []string{"/alive", "/gone", "/no-head", "/blocked"} {
+		if _, err := storePage(PageMetadata{URL: server.URL + path, Title: path}, "<p>"+path+"</p>", ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	storePage(PageMetadata{URL: "file:///notes.pdf", Title: "Notes"}, "", "notes")
+
+	pages, _ := listPages()
+	candidates := linkCheckCandidates(pages, 0)
+	if len(candidates) != 4 {
+		t.Fatalf("%d candidates, want the 4 web pages", len(candidates))
+	}
+	if dead := checkLinks(context.Background(), candidates); dead != 1 {
+		t.Errorf("checkLinks() = %d gone, want 1", dead)
+	}
+
+	report := func() []DeadLink {
+		rec := httptest.NewRecorder()
+		newRouter().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/deadlinks", nil))
+		var dead []DeadLink
+		if err := json.Unmarshal(rec.Body.Bytes(), &dead); err != nil {
+			t.Fatalf("report = %d %s", rec.Code, rec.Body)
+		}
+		return dead
+	}
+	dead := report()
+	if len(dead) != 1 || dead[0].URL != server.URL+"/gone" || dead[0].Status != http.StatusNotFound || dead[0].DeadSince == nil {
+		t.Fatalf("report = %+v, want the missing page", dead)
+	}
+	first := *dead[0].DeadSince
+
+	// Checked again, the page is still dead since the first check
+	pages, _ = listPages()
+	candidates = linkCheckCandidates(pages, 1)
+	if len(candidates) != 1 {
+		t.Fatalf("%d candidates, want the limit of 1", len(candidates))
+	}
+	for _, page := range pages {
+		if page.URL == server.URL+"/gone" {
+			checkLive(context.Background(), page)
+		}
+	}
+	if dead = report(); len(dead) != 1 || !dead[0].DeadSince.Equal(first) || !dead[0].CheckedAt.After(first) {
+		t.Errorf("report after a second check = %+v, want it dead since %v", dead, first)
+	}
+}
@@ -1,17 +1,22 @@
 package main
 
 import (
-	"encoding/json"
+	"context"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"log"
 	"net/http"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/blevesearch/bleve"
+	"github.com/blevesearch/bleve/search"
+	"github.com/blevesearch/bleve/search/query"
 )
 
 const (
@@ -29,13 +34,120 @@ type PageMetadata struct {
 	MDFilename   string    `json:"mdFilename"`
 	HasMarkdown  bool      `json:"hasMarkdown"`
 	Indexed      bool      `json:"indexed"`
+	Tags         []string  `json:"tags,omitempty"`
+	Source       string    `json:"source,omitempty"`
+	Citation     *Citation `json:"citation,omitempty"`
+	WordCount    int       `json:"wordCount,omitempty"`
+	ContentHash  string    `json:"contentHash,omitempty"`
+	// ContentEncoding is "gzip" when the HTML and markdown are stored
+	// compressed.
+	ContentEncoding string `json:"contentEncoding,omitempty"`
+	Owner           string `json:"owner,omitempty"`
+	// CaptureHeaders are the response headers of a page the daemon
+	// fetched itself.
+	CaptureHeaders map[string]string `json:"captureHeaders,omitempty"`
+	// Freshness is what the server's caching headers said about how long
+	// the page stays as captured, as of the capture or the last recrawl.
+	Freshness *Freshness `json:"freshness,omitempty"`
+	// ScreenshotFilename is a full-page PNG taken by the headless browser.
+	ScreenshotFilename string `json:"screenshotFilename,omitempty"`
+	// PDFFilename is the original of a PDF page, whose extracted text is
+	// its markdown.
+	PDFFilename string `json:"pdfFilename,omitempty"`
+	// PDFPages is how many pages the PDF's markdown is split into, 0 for
+	// PDFs stored before pages were extracted.
+	PDFPages int `json:"pdfPages,omitempty"`
+	// ThumbnailFilename caches the preview generated on first request.
+	ThumbnailFilename string `json:"thumbnailFilename,omitempty"`
+	// LiveCheck is the result of the last fidelity or link check of URL.
+	LiveCheck *LiveCheck `json:"liveCheck,omitempty"`
+	// Supersedes is the earlier capture this page was re-captured from,
+	// and SupersededBy the capture that replaced this one.
+	Supersedes   string `json:"supersedes,omitempty"`
+	SupersededBy string `json:"supersededBy,omitempty"`
+	// ExpiresAt is when a capture saved for a while is removed, unless it
+	// has been starred since.
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+	// Starred marks a favorite, and Read a page that has been read, for
+	// using the archive as a read-later queue.
+	Starred bool `json:"starred,omitempty"`
+	Read    bool `json:"read,omitempty"`
+	// Annotations are the user's notes on the page, and Highlights the
+	// passages they highlighted in the browser.
+	Annotations []Annotation    `json:"annotations,omitempty"`
+	Highlights  []TextHighlight `json:"highlights,omitempty"`
+	// DriftCheck is the result of the last comparison of the capture with
+	// the live page.
+	DriftCheck *DriftCheck `json:"driftCheck,omitempty"`
+	// ExtractorVersion is the extractorVersion the page's text and title
+	// were extracted with, and ExtractedAt when they were extracted again
+	// after capture.
+	ExtractorVersion int        `json:"extractorVersion,omitempty"`
+	ExtractedAt      *time.Time `json:"extractedAt,omitempty"`
+	// Provenance is set on pages copied from another web archive.
+	Provenance *Provenance `json:"provenance,omitempty"`
+	// Changes summarizes how a version stored by the recrawl differs from
+	// the one it replaced.
+	Changes *ChangeSummary `json:"changes,omitempty"`
+	// Bookmark marks a URL saved without its content, indexed by its title,
+	// tags and Note until it is captured. Bookmarked is when a capture's
+	// URL was first saved as a bookmark.
+	Bookmark   bool       `json:"bookmark,omitempty"`
+	Note       string     `json:"note,omitempty"`
+	Bookmarked *time.Time `json:"bookmarked,omitempty"`
+	// Summary is two or three sentences on what the page says, written
+	// once it is indexed when summaries are on.
+	Summary string `json:"summary,omitempty"`
+	// AutoTags are the tags the tagger gave the page, apart from Tags given
+	// by hand, and AutoTagged when it looked at the page.
+	AutoTags   []string   `json:"autoTags,omitempty"`
+	AutoTagged *time.Time `json:"autoTagged,omitempty"`
+	// SimHash fingerprints the page's text, for finding near-duplicates
+	// such as syndicated copies of an article.
+	SimHash string `json:"simHash,omitempty"`
+	// Private keeps a sensitive page out of searches that don't ask for
+	// private pages.
+	Private bool `json:"private,omitempty"`
+	// Incognito marks a capture made in a private window, which is deleted
+	// instead of being indexed.
+	Incognito bool `json:"incognito,omitempty"`
+	// Admitted marks a page indexed at least once, which the do-not-index
+	// rules no longer drop when it is indexed again.
+	Admitted bool `json:"admitted,omitempty"`
+}
+
+// Citation holds bibliographic metadata for pages imported from reference
+// managers such as Zotero.
+type Citation struct {
+	ItemType    string   `json:"itemType,omitempty"`
+	Authors     []string `json:"authors,omitempty"`
+	Date        string   `json:"date,omitempty"`
+	Publication string   `json:"publication,omitempty"`
+	DOI         string   `json:"doi,omitempty"`
 }
 
 type SearchResult struct {
-	URL     string  `json:"url"`
-	Title   string  `json:"title"`
-	Snippet string  `json:"snippet"`
-	Score   float64 `json:"score"`
+	ID       string    `json:"id"`
+	URL      string    `json:"url"`
+	Title    string    `json:"title"`
+	Snippet  string    `json:"snippet"`
+	Score    float64   `json:"score"`
+	Time     time.Time `json:"time"`
+	SavedOn  string    `json:"savedOn,omitempty"`
+	SavedAgo string    `json:"savedAgo,omitempty"`
+	// Thumbnail is the URL of the page's preview image.
+	Thumbnail string `json:"thumbnail"`
+	// Highlights locates the matched terms in Snippet when the markers
+	// highlight style is requested.
+	Highlights []MatchRange `json:"highlights,omitempty"`
+	// Instance names the instance a federated search found the page on.
+	Instance string `json:"instance,omitempty"`
+	// PDFPages are the pages of a PDF the matched terms are on, for
+	// opening it at the first.
+	PDFPages []int `json:"pdfPages,omitempty"`
+	// Duplicates are the near-duplicates of the page collapsed into it.
+	Duplicates []string `json:"duplicates,omitempty"`
+	simHash    string
 }
 
 type PageDocument struct {
@@ -43,21 +155,86 @@ type PageDocument struct {
 	Title   string    `json:"title"`
 	Content string    `json:"content"`
 	Time    time.Time `json:"time"`
+	Tags    []string  `json:"tags,omitempty"`
+	// SupersededAt is when a re-capture replaced this version, for
+	// searches of the archive as it was at an earlier time.
+	SupersededAt *time.Time `json:"supersededAt,omitempty"`
+	// OwnerKey finds the pages of a user; see ownerKey.
+	OwnerKey string `json:"ownerKey,omitempty"`
+	Starred  bool   `json:"starred,omitempty"`
+	Read     bool   `json:"read,omitempty"`
+	// WordCount is the length of the page's text, for filtering out short
+	// pages.
+	WordCount int `json:"wordCount,omitempty"`
+	// Notes is the text of the page's annotations, and Highlights the
+	// passages highlighted in it, which count more in searches.
+	Notes      string `json:"notes,omitempty"`
+	Highlights string `json:"highlights,omitempty"`
+	// Drift is how far the live page had moved from the capture when last
+	// checked.
+	Drift float64 `json:"drift,omitempty"`
+	// StaleAt is when the page's server said it may have changed.
+	StaleAt *time.Time `json:"staleAt,omitempty"`
+	// Summary counts more in searches, and stands in for snippets.
+	Summary string `json:"summary,omitempty"`
+	// Tags holds AutoTags too, so that pages are found by either.
+	AutoTags []string `json:"autoTags,omitempty"`
+	SimHash  string   `json:"simHash,omitempty"`
+	Private  bool     `json:"private,omitempty"`
 }
 
-var index bleve.Index
+// errInvalidQuery is returned for queries that don't parse or validate, as
+// opposed to failures of the index itself.
+var errInvalidQuery = errors.New("invalid query")
+
+var (
+	index bleve.Index
+	// indexMu serializes indexing passes between the watcher and endpoints
+	// that trigger indexing directly.
+	indexMu sync.Mutex
+)
 
 func main() {
+	loadConfig()
+
+	// Subcommands operate on the pages directory and exit
+	if len(os.Args) > 1 {
+		runCommand(os.Args[1], os.Args[2:])
+		return
+	}
+
+	setupTracing()
+
 	// Initialize the index
 	setupIndex()
+	resumeJobs()
 
 	// Start the file watcher in a goroutine
 	go watchForNewFiles()
+	go watchSuggestions()
+	startFeatures()
+	startWebhooks()
+
+	if config.Zotero.ConnectorEnabled {
+		go serveZoteroConnector()
+	}
 
 	// Start the HTTP server
-	http.HandleFunc("/search", handleSearch)
+	server := &http.Server{
+		Addr:    fmt.Sprintf(":%d", port),
+		Handler: newRouter(),
+	}
+	if tlsEnabled() {
+		tlsConfig, err := serverTLSConfig()
+		if err != nil {
+			log.Fatalf("Error configuring TLS: %v", err)
+		}
+		server.TLSConfig = tlsConfig
+		log.Printf("Starting HTTPS server on port %d...", port)
+		log.Fatal(server.ListenAndServeTLS(config.TLS.CertFile, config.TLS.KeyFile))
+	}
 	log.Printf("Starting server on port %d...", port)
-	log.Fatal(http.ListenAndServe(fmt.Sprintf(":%d", port), nil))
+	log.Fatal(server.ListenAndServe())
 }
 
 func setupIndex() {
@@ -72,18 +249,36 @@ func setupIndex() {
 		os.MkdirAll(pagesDir, 0755)
 	}
 
+	// An index built with another analyzer is built again, as its terms
+	// wouldn't match those of queries
+	indexPath := filepath.Join(indexDir, "index")
+	rebuild := false
+	if _, err = os.Stat(indexPath); err == nil && !analysisCurrent() {
+		log.Println("Search analysis has changed, rebuilding the index")
+		if err := os.RemoveAll(indexPath); err != nil {
+			log.Fatalf("Error removing index: %v", err)
+		}
+		rebuild = true
+	}
+
 	// Open or create the index
-	if _, err = os.Stat(filepath.Join(indexDir, "index_meta.json")); os.IsNotExist(err) {
+	if _, err = os.Stat(indexPath); os.IsNotExist(err) {
 		// Create a new index
-		mapping := bleve.NewIndexMapping()
-		index, err = bleve.New(filepath.Join(indexDir, "index"), mapping)
+		mapping, err := newIndexMapping()
+		if err != nil {
+			log.Fatalf("Error creating index mapping: %v", err)
+		}
+		index, err = bleve.New(indexPath, mapping)
 		if err != nil {
 			log.Fatalf("Error creating index: %v", err)
 		}
+		if err := writeAnalysis(); err != nil {
+			log.Fatalf("Error writing %s: %v", analysisFile, err)
+		}
 		log.Println("Created new search index")
 	} else {
 		// Open existing index
-		index, err = bleve.Open(filepath.Join(indexDir, "index"))
+		index, err = bleve.Open(indexPath)
 		if err != nil {
 			log.Fatalf("Error opening index: %v", err)
 		}
@@ -91,10 +286,24 @@ func setupIndex() {
 	}
 
 	// Initial indexing of existing files
-	indexExistingFiles()
+	if rebuild {
+		if err := reindexAllPages(context.Background()); err != nil {
+			log.Printf("Error rebuilding index: %v", err)
+		}
+		return
+	}
+	indexExistingFiles(context.Background())
+}
+
+func indexExistingFiles(ctx context.Context) {
+	indexMu.Lock()
+	defer indexMu.Unlock()
+	indexPendingFiles(ctx)
 }
 
-func indexExistingFiles() {
+// indexPendingFiles indexes every page not marked as indexed, tracing each
+// as a child of the span in ctx. indexMu must be held.
+func indexPendingFiles(ctx context.Context) {
 	files, err := ioutil.ReadDir(pagesDir)
 	if err != nil {
 		log.Printf("Error reading pages directory: %v", err)
@@ -104,78 +313,30 @@ func indexExistingFiles() {
 	count := 0
 	for _, file := range files {
 		if !file.IsDir() && strings.HasSuffix(file.Name(), ".json") {
-			metadataPath := filepath.Join(pagesDir, file.Name())
+			docID := strings.TrimSuffix(file.Name(), ".json")
 
 			// Read and parse metadata
-			metadataBytes, err := ioutil.ReadFile(metadataPath)
+			metadata, err := readMetadata(docID)
 			if err != nil {
-				log.Printf("Error reading metadata file %s: %v", metadataPath, err)
-				continue
-			}
-
-			var metadata PageMetadata
-			if err := json.Unmarshal(metadataBytes, &metadata); err != nil {
-				log.Printf("Error parsing metadata file %s: %v", metadataPath, err)
+				log.Printf("Error reading metadata file %s: %v", metadataPath(docID), err)
 				continue
 			}
 
 			if metadata.Indexed {
 				continue // Skip already indexed files
 			}
-
-			// Determine which file to index - prefer markdown if available
-			var contentPath string
-			if metadata.HasMarkdown {
-				contentPath = filepath.Join(pagesDir, metadata.MDFilename)
-				if _, err := os.Stat(contentPath); os.IsNotExist(err) {
-					// Fall back to HTML if MD file doesn't exist
-					contentPath = filepath.Join(pagesDir, metadata.HTMLFilename)
-				}
-			} else {
-				contentPath = filepath.Join(pagesDir, metadata.HTMLFilename)
-			}
-
-			// Check if the content file exists
-			if _, err := os.Stat(contentPath); os.IsNotExist(err) {
-				log.Printf("Content file not found: %s", contentPath)
-				continue
-			}
-
-			// Read content
-			contentBytes, err := ioutil.ReadFile(contentPath)
-			if err != nil {
-				log.Printf("Error reading content file %s: %v", contentPath, err)
-				continue
-			}
-
-			// Index the document
-			doc := PageDocument{
-				URL:     metadata.URL,
-				Title:   metadata.Title,
-				Content: string(contentBytes),
-				Time:    metadata.Timestamp,
-			}
-
-			docID := strings.TrimSuffix(file.Name(), ".json")
-			if err := index.Index(docID, doc); err != nil {
-				log.Printf("Error indexing document %s: %v", docID, err)
-				continue
+			if ctx.Err() != nil {
+				break
 			}
-
-			// Update metadata to mark as indexed
-			metadata.Indexed = true
-			updatedMetadata, err := json.MarshalIndent(metadata, "", "  ")
-			if err != nil {
-				log.Printf("Error marshaling updated metadata: %v", err)
+			if dropUnwantedCapture(docID, metadata) {
 				continue
 			}
-
-			if err := ioutil.WriteFile(metadataPath, updatedMetadata, 0644); err != nil {
-				log.Printf("Error writing updated metadata: %v", err)
+			if err := indexPage(ctx, docID, metadata); err != nil {
 				continue
 			}
 
 			count++
+			reportProgress(ctx, count, 0)
 			if count%indexBatchSize == 0 {
 				log.Printf("Indexed %d documents", count)
 			}
@@ -187,57 +348,515 @@ func indexExistingFiles() {
 	}
 }
 
+// indexPage indexes a page's content and marks it as indexed, logging any
+// failure.
+func indexPage(ctx context.Context, docID string, metadata PageMetadata) error {
+	ctx, span := startSpan(ctx, "index.page")
+	defer span.End()
+	span.SetAttribute("page.id", docID)
+
+	// Determine which file to index - prefer markdown if available
+	contentPath := contentPath(metadata)
+
+	// Check if the content file exists
+	if _, err := os.Stat(contentPath); os.IsNotExist(err) && !metadata.Bookmark {
+		log.Printf("Content file not found: %s", contentPath)
+		span.RecordError(err)
+		return err
+	}
+
+	// Read content
+	_, extractSpan := startSpan(ctx, "index.extract")
+	contentBytes, err := pageContent(metadata)
+	if err != nil {
+		log.Printf("Error reading content file %s: %v", contentPath, err)
+		extractSpan.RecordError(err)
+		extractSpan.End()
+		span.RecordError(err)
+		return err
+	}
+	if usersEnabled() && metadata.Owner == "" {
+		metadata.Owner = config.Users.DefaultOwner
+	}
+	doc := pageDocument(metadata, contentBytes)
+	text := extractText(string(contentBytes), isHTMLFile(contentPath))
+	metadata.WordCount = wordCount(text)
+	doc.WordCount = metadata.WordCount
+	if !metadata.Bookmark {
+		metadata.SimHash = simHash(text)
+		doc.SimHash = metadata.SimHash
+	}
+	// A hash recorded at capture stays, as the stored HTML may since have
+	// been pointed at local assets
+	if metadata.ContentHash == "" && !metadata.Bookmark {
+		metadata.ContentHash = contentHash(contentBytes)
+	}
+	extractSpan.SetAttribute("page.bytes", len(contentBytes))
+	extractSpan.SetAttribute("page.words", metadata.WordCount)
+	extractSpan.End()
+
+	// Index the document
+	_, writeSpan := startSpan(ctx, "index.write")
+	err = index.Index(docID, doc)
+	writeSpan.RecordError(err)
+	writeSpan.End()
+	if err != nil {
+		log.Printf("Error indexing document %s: %v", docID, err)
+		publishError(docID, err)
+		span.RecordError(err)
+		return err
+	}
+
+	// Update metadata to mark as indexed
+	metadata.Indexed = true
+	metadata.Admitted = true
+	if err := writeMetadata(docID, metadata); err != nil {
+		log.Printf("Error writing updated metadata: %v", err)
+		span.RecordError(err)
+		return err
+	}
+	publishPageEvent(eventPageIndexed, docID, metadata)
+	completeRecapture(docID, metadata)
+	return nil
+}
+
+// pageDocument is the search index document of a page with the given
+// content.
+func pageDocument(metadata PageMetadata, content []byte) PageDocument {
+	return PageDocument{
+		URL:   metadata.URL,
+		Title: metadata.Title,
+		// Text the site repeats on every page only gets in the way
+		Content: withoutBoilerplate(metadata.URL, string(content), isHTMLFile(contentPath(metadata))),
+		Time:    metadata.Timestamp,
+		Tags:    metadata.allTags(),
+		// Replaced versions stay searchable as of earlier times
+		SupersededAt: supersededAt(metadata),
+		OwnerKey:     ownerKey(metadata.Owner),
+		Starred:      metadata.Starred,
+		Read:         metadata.Read,
+		WordCount:    metadata.WordCount,
+		Notes:        strings.TrimSpace(metadata.Note + "\n" + annotationText(metadata.Annotations)),
+		Highlights:   highlightText(metadata.Highlights),
+		Drift:        driftValue(metadata.DriftCheck),
+		StaleAt:      staleAt(metadata),
+		Summary:      metadata.Summary,
+		AutoTags:     metadata.AutoTags,
+		Private:      metadata.Private,
+	}
+}
+
 func watchForNewFiles() {
 	for {
-		indexExistingFiles()
+		indexExistingFiles(context.Background())
 		time.Sleep(10 * time.Second)
 	}
 }
 
+// SearchOptions adjusts how a search is run and how its results are
+// presented.
+type SearchOptions struct {
+	// Highlight is the style matches are marked with in snippets.
+	Highlight string
+	// Sort orders the results; see searchSortOrders.
+	Sort string
+	// Fuzziness lets query terms match words up to this many edits away,
+	// and Prefix lets them match the start of longer words. Either treats
+	// the query as plain words rather than query string syntax.
+	Fuzziness int
+	Prefix    bool
+	// Exact matches the query's words and phrases only as written, without
+	// stemming, fuzziness or prefixes. It also treats the query as plain
+	// words.
+	Exact bool
+	// AsOf searches the captures that were current at that time instead of
+	// the latest ones.
+	AsOf time.Time
+	// Owner is the user whose pages are searched when users are enabled.
+	Owner string
+	// Starred and Unread limit results to starred or unread pages,
+	// Changed to pages whose live version has materially changed, and
+	// Stale to pages their server's caching headers say may have changed.
+	Starred bool
+	Unread  bool
+	Changed bool
+	Stale   bool
+	// IncludePrivate finds private pages too; see includePrivate.
+	IncludePrivate bool
+	// GroupBy returns the results in groups; see searchGroupings.
+	GroupBy string
+	// Collapse folds near-duplicates into the highest of them.
+	Collapse bool
+	// SnippetLength is about how many characters of text each result's
+	// snippet shows, defaultSnippetLength when zero.
+	SnippetLength int
+	// Fields are the result fields returned, all of them when nil; see
+	// resultFields.
+	Fields []string
+}
+
+// maxFuzziness is the largest edit distance bleve's fuzzy queries support.
+const maxFuzziness = 2
+
+// searchResultLimit is the most results a search returns.
+const searchResultLimit = 20
+
+// searchSortOrders maps the sort parameter to bleve sort orders. Ties are
+// broken by relevance.
+var searchSortOrders = map[string][]string{
+	"score": {"-_score"},
+	"time":  {"time", "-_score"},
+	"-time": {"-time", "-_score"},
+	"title": {"title", "-_score"},
+}
+
+// validate fills in defaults and rejects unknown option values.
+func (o *SearchOptions) validate() error {
+	if o.Highlight == "" {
+		o.Highlight = "none"
+	}
+	if !highlightStyles[o.Highlight] {
+		return fmt.Errorf("highlight must be one of html, ansi, markers or none")
+	}
+	if o.Sort == "" {
+		o.Sort = "score"
+	}
+	if searchSortOrders[o.Sort] == nil {
+		return fmt.Errorf("sort must be one of score, time, -time or title")
+	}
+	if o.Fuzziness < 0 || o.Fuzziness > maxFuzziness {
+		return fmt.Errorf("fuzziness must be between 0 and %d", maxFuzziness)
+	}
+	if o.GroupBy != "" && searchGroupings[o.GroupBy] == nil {
+		return fmt.Errorf("group_by must be one of collection, domain or tag")
+	}
+	if o.SnippetLength == 0 {
+		o.SnippetLength = defaultSnippetLength
+	}
+	if o.SnippetLength < minSnippetLength || o.SnippetLength > maxSnippetLength {
+		return fmt.Errorf("snippet_len must be between %d and %d", minSnippetLength, maxSnippetLength)
+	}
+	return validateResultFields(o.Fields)
+}
+
+// parseSearchOptions reads the highlight, sort, fuzziness, prefix, exact,
+// as_of, starred, unread, changed, stale, include_private, group_by,
+// collapse, snippet_len and fields query parameters. Fuzziness defaults to
+// the configured value.
+func parseSearchOptions(r *http.Request) (SearchOptions, error) {
+	params := r.URL.Query()
+	options := SearchOptions{
+		Highlight: params.Get("highlight"),
+		Sort:      params.Get("sort"),
+		Fuzziness: config.Search.Fuzziness,
+		Prefix:    params.Get("prefix") == "1" || params.Get("prefix") == "true",
+		Exact:     params.Get("exact") == "1" || params.Get("exact") == "true",
+		Owner:     requestUser(r),
+		Starred:   params.Get("starred") == "1" || params.Get("starred") == "true",
+		Unread:    params.Get("unread") == "1" || params.Get("unread") == "true",
+		Changed:   params.Get("changed") == "1" || params.Get("changed") == "true",
+		Stale:     params.Get("stale") == "1" || params.Get("stale") == "true",
+		GroupBy:   params.Get("group_by"),
+		Collapse:  params.Get("collapse") == "1" || params.Get("collapse") == "true",
+	}
+	if asOf := params.Get("as_of"); asOf != "" {
+		t, err := parseAsOf(asOf)
+		if err != nil {
+			return options, err
+		}
+		options.AsOf = t
+	}
+	if fuzziness := params.Get("fuzziness"); fuzziness != "" {
+		n, err := strconv.Atoi(fuzziness)
+		if err != nil {
+			return options, fmt.Errorf("fuzziness must be a number")
+		}
+		options.Fuzziness = n
+	}
+	if snippetLength := params.Get("snippet_len"); snippetLength != "" {
+		n, err := strconv.Atoi(snippetLength)
+		if err != nil {
+			return options, fmt.Errorf("snippet_len must be a number")
+		}
+		options.SnippetLength = n
+	}
+	fields, err := parseResultFields(params.Get("fields"))
+	if err != nil {
+		return options, err
+	}
+	options.Fields = fields
+	requested := params.Get("include_private") == "1" || params.Get("include_private") == "true"
+	if options.IncludePrivate, err = includePrivate(r, requested); err != nil {
+		return options, err
+	}
+	return options, options.validate()
+}
+
 func handleSearch(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		handleStructuredSearch(w, r)
+		return
+	}
+	ctx, span := startRequestSpan(r, "search")
+	defer span.End()
 	query := r.URL.Query().Get("q")
 	if query == "" {
-		http.Error(w, "Missing query parameter", http.StatusBadRequest)
+		writeError(w, http.StatusBadRequest, ErrInvalidRequest, "Missing query parameter")
+		return
+	}
+	options, err := parseSearchOptions(r)
+	if err != nil {
+		writeSearchOptionsError(w, err)
+		return
+	}
+	federated := federatedParam(r)
+	if federated && !isAdmin(r) {
+		writeError(w, http.StatusForbidden, ErrForbidden, "Only admins can search peer instances")
+		return
+	}
+	// Peers' results can't be looked up to group them
+	if federated && options.GroupBy != "" {
+		writeError(w, http.StatusBadRequest, ErrInvalidRequest, "Federated searches can't be grouped")
 		return
 	}
+	if !allowSearch(w, r) {
+		return
+	}
+
+	results, err := searchPages(ctx, query, options, localizerFor(r))
+	span.RecordError(err)
+	if errors.Is(err, errInvalidQuery) {
+		writeError(w, http.StatusBadRequest, ErrInvalidRequest, err.Error())
+		return
+	}
+	if err != nil {
+		log.Printf("Search error: %v", err)
+		writeError(w, http.StatusServiceUnavailable, ErrIndexUnavailable, "Search failed")
+		return
+	}
+	// A peer may have what this archive lacks, so only local searches are
+	// spelled again
+	if len(results) == 0 && !federated {
+		retried, corrected, suggestions, err := retryMisspelled(ctx, query, options, localizerFor(r))
+		if err != nil {
+			log.Printf("Error correcting the spelling of %q: %v", query, err)
+		} else if corrected != "" {
+			results = retried
+		}
+		setSpellingHeaders(w, corrected, suggestions)
+	}
+	if federated {
+		var unreachable []string
+		results, unreachable = federate(ctx, r, results, options.Sort)
+		if len(unreachable) > 0 {
+			w.Header().Set(unreachablePeersHeader, strings.Join(unreachable, ", "))
+		}
+	}
+	if err := recordSearch(r, query, len(results), false); err != nil {
+		log.Printf("Error recording search: %v", err)
+	}
 
-	// Create a search query
-	searchQuery := bleve.NewQueryStringQuery(query)
-	searchRequest := bleve.NewSearchRequest(searchQuery)
-	searchRequest.Fields = []string{"url", "title", "content", "time"}
-	searchRequest.Highlight = bleve.NewHighlightWithStyle("html")
-	searchRequest.Size = 20
+	writeSearchResults(w, results, options)
+}
+
+// searchPages runs a query string search against the index, or a search
+// for the query's words and phrases when exact, fuzzy or prefix matching is
+// requested.
+func searchPages(ctx context.Context, query string, options SearchOptions, localizer Localizer) ([]SearchResult, error) {
+	return runSearch(ctx, boostSummaries(boostHighlights(textQuery(query, options), query), query), options, localizer)
+}
+
+// textQuery returns the query searchPages runs for text.
+func textQuery(text string, options SearchOptions) query.Query {
+	switch {
+	case options.Exact:
+		return exactQuery(text)
+	case options.Fuzziness > 0 || options.Prefix:
+		return approximateQuery(text, options)
+	}
+	return bleve.NewQueryStringQuery(text)
+}
+
+// splitQuery splits text into its words and the phrases quoted in it. An
+// unclosed quote runs to the end.
+func splitQuery(text string) (words, phrases []string) {
+	for i, part := range strings.Split(text, `"`) {
+		if i%2 == 0 {
+			words = append(words, strings.Fields(part)...)
+		} else if phrase := strings.TrimSpace(part); phrase != "" {
+			phrases = append(phrases, phrase)
+		}
+	}
+	return words, phrases
+}
+
+// approximateQuery matches pages containing every word and quoted phrase of
+// text, where each word may also match fuzzily or as a prefix. Fuzzy and
+// prefix queries aren't analyzed, so the words are lowercased to match the
+// index.
+func approximateQuery(text string, options SearchOptions) query.Query {
+	var words []query.Query
+	terms, phrases := splitQuery(text)
+	for _, phrase := range phrases {
+		words = append(words, bleve.NewMatchPhraseQuery(phrase))
+	}
+	for _, word := range terms {
+		alternatives := []query.Query{bleve.NewMatchQuery(word)}
+		term := strings.ToLower(word)
+		if options.Fuzziness > 0 {
+			fuzzy := bleve.NewFuzzyQuery(term)
+			fuzzy.SetFuzziness(options.Fuzziness)
+			alternatives = append(alternatives, fuzzy)
+		}
+		if options.Prefix {
+			alternatives = append(alternatives, bleve.NewPrefixQuery(term))
+		}
+		words = append(words, bleve.NewDisjunctionQuery(alternatives...))
+	}
+	return bleve.NewConjunctionQuery(words...)
+}
+
+// exactQuery matches pages whose title or content contains every word and
+// quoted phrase of text as written, searching the fields indexed without
+// stemming when it is on. bleve doesn't find the analyzer of those fields
+// by their name, so the queries name it.
+func exactQuery(text string) query.Query {
+	stemmed := config.Search.Analysis.Stemming
+	var words []query.Query
+	terms, phrases := splitQuery(text)
+	for _, phrase := range phrases {
+		var alternatives []query.Query
+		for _, field := range textFields {
+			q := bleve.NewMatchPhraseQuery(phrase)
+			if stemmed {
+				field = exactField(field)
+				q.Analyzer = exactAnalyzerName
+			}
+			q.SetField(field)
+			alternatives = append(alternatives, q)
+		}
+		words = append(words, bleve.NewDisjunctionQuery(alternatives...))
+	}
+	for _, word := range terms {
+		var alternatives []query.Query
+		for _, field := range textFields {
+			q := bleve.NewMatchQuery(word)
+			if stemmed {
+				field = exactField(field)
+				q.Analyzer = exactAnalyzerName
+			}
+			q.SetField(field)
+			alternatives = append(alternatives, q)
+		}
+		words = append(words, bleve.NewDisjunctionQuery(alternatives...))
+	}
+	if !stemmed {
+		return bleve.NewConjunctionQuery(words...)
+	}
+	// The exact fields aren't stored, so snippets mark the content's matches
+	content := bleve.NewMatchQuery(text)
+	content.SetField("content")
+	boolean := bleve.NewBooleanQuery()
+	boolean.AddMust(bleve.NewConjunctionQuery(words...))
+	boolean.AddShould(content)
+	return boolean
+}
+
+// runSearch runs a query against the index and converts the hits into
+// results with display strings in the localizer's language.
+func runSearch(ctx context.Context, searchQuery query.Query, options SearchOptions, localizer Localizer) ([]SearchResult, error) {
+	if v, ok := searchQuery.(query.ValidatableQuery); ok {
+		if err := v.Validate(); err != nil {
+			return nil, fmt.Errorf("%w: %v", errInvalidQuery, err)
+		}
+	}
+	searchRequest := bleve.NewSearchRequest(snapshotQuery(searchQuery, options))
+	searchRequest.Fields = options.storedFields()
+	// Content snippets come from contextSnippet
+	if options.wants("snippet") || options.wants("highlights") {
+		searchRequest.Highlight = bleve.NewHighlightWithStyle(markerHighlighter)
+		searchRequest.Highlight.AddField("summary")
+	}
+	// The terms matched find the pages of PDFs they're on
+	searchRequest.IncludeLocations = true
+	// Grouped searches spread more results over their groups
+	limit := searchResultLimit
+	if options.GroupBy != "" {
+		limit = groupedSearchDepth
+	}
+	searchRequest.Size = limit
+	// Boosts can lift pages from further down
+	rerank := options.Sort == "score" && haveBoosts()
+	if rerank {
+		searchRequest.Size = limit * rerankDepth
+	}
+	searchRequest.SortBy(searchSortOrders[options.Sort])
 
 	// Execute the search
+	_, querySpan := startSpan(ctx, "search.query")
 	searchResults, err := index.Search(searchRequest)
+	querySpan.RecordError(err)
+	if searchResults != nil {
+		querySpan.SetAttribute("search.total_hits", int64(searchResults.Total))
+	}
+	querySpan.End()
 	if err != nil {
-		log.Printf("Search error: %v", err)
-		http.Error(w, "Search failed", http.StatusInternalServerError)
-		return
+		return nil, err
 	}
 
 	// Process results
+	_, enrichSpan := startSpan(ctx, "search.enrich")
+	defer enrichSpan.End()
+	enrichSpan.SetAttribute("search.results", len(searchResults.Hits))
+	now := time.Now()
 	results := []SearchResult{}
 	for _, hit := range searchResults.Hits {
-		snippet := ""
-		if len(hit.Fragments["content"]) > 0 {
-			snippet = strings.Join(hit.Fragments["content"], "... ")
-			// Clean up HTML tags from snippet
-			snippet = strings.ReplaceAll(snippet, "<em>", "")
-			snippet = strings.ReplaceAll(snippet, "</em>", "")
-		}
+		results = append(results, hitResult(hit, options, localizer, now))
+	}
+	if rerank {
+		results = rerankResults(results, limit)
+	}
+	if options.Collapse {
+		results = collapseDuplicates(results)
+	}
+	return results, nil
+}
 
-		result := SearchResult{
-			URL:     hit.Fields["url"].(string),
-			Title:   hit.Fields["title"].(string),
-			Snippet: snippet,
-			Score:   hit.Score,
+// hitFields are the stored fields search hits are turned into results
+// from.
+var hitFields = []string{"url", "title", "content", "time", "summary", "simHash"}
+
+// hitResult converts a search hit into a result, leaving out the snippet
+// and PDF pages unless options asks for them.
+func hitResult(hit *search.DocumentMatch, options SearchOptions, localizer Localizer, now time.Time) SearchResult {
+	result := SearchResult{
+		ID:        hit.ID,
+		URL:       hit.Fields["url"].(string),
+		Title:     hit.Fields["title"].(string),
+		Score:     hit.Score,
+		Thumbnail: thumbnailPath(hit.ID),
+	}
+	if options.wants("snippet") || options.wants("highlights") {
+		content, _ := hit.Fields["content"].(string)
+		result.Snippet, result.Highlights = formatSnippet(contextSnippet(content, hit.Locations["content"], options.SnippetLength), options.Highlight)
+		// A summary says more about the page than fragments of its text
+		if summary, ok := hit.Fields["summary"].(string); ok && summary != "" && config.Summaries.Enabled {
+			if fragments := hit.Fragments["summary"]; len(fragments) > 0 {
+				summary = strings.Join(fragments, "... ")
+			}
+			result.Snippet, result.Highlights = formatSnippet(summary, options.Highlight)
 		}
-		results = append(results, result)
 	}
-
-	// Return results as JSON
-	w.Header().Set("Content-Type", "application/json")
-	w.Header().Set("Access-Control-Allow-Origin", "*")
-	json.NewEncoder(w).Encode(results)
+	result.simHash, _ = hit.Fields["simHash"].(string)
+	if len(hit.Locations["content"]) > 0 && options.wants("pdfPages") {
+		result.PDFPages = pdfHitPages(hit.ID, hit.Locations["content"])
+	}
+	if savedAt, ok := hit.Fields["time"].(string); ok {
+		if t, err := time.Parse(time.RFC3339, savedAt); err == nil {
+			result.Time = t
+			result.SavedOn = localizer.FormatDate(t)
+			result.SavedAgo = localizer.RelativeTime(t, now)
+		}
+	}
+	return result
 }
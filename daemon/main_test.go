@@ -0,0 +1,50 @@
+package main
+
+import (
+	"os"
+	"testing"
+
+	"github.com/blevesearch/bleve"
+)
+
+// withPagesDir runs a test in a fresh working directory with an empty pages
+// directory, restoring the original directory afterwards.
+func withPagesDir(t *testing.T) {
+	t.Helper()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+	if err := os.MkdirAll(pagesDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// withIndex replaces the search index with an in-memory one holding docs,
+// keyed by document ID.
+func withIndex(t *testing.T, docs map[string]PageDocument) {
+	t.Helper()
+	mapping, err := newIndexMapping()
+	if err != nil {
+		t.Fatal(err)
+	}
+	memIndex, err := bleve.NewMemOnly(mapping)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for id, doc := range docs {
+		if err := memIndex.Index(id, doc); err != nil {
+			t.Fatal(err)
+		}
+	}
+	saved := index
+	index = memIndex
+	t.Cleanup(func() {
+		index = saved
+		memIndex.Close()
+	})
+}
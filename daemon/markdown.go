@@ -0,0 +1,141 @@
+package main
+
+import (
+	"html"
+	"html/template"
+	"regexp"
+	"strings"
+)
+
+// The reading view renders the markdown produced by the extension's
+// Turndown conversion, so only the constructs Turndown emits are supported:
+// ATX headings, paragraphs, lists, blockquotes, fenced code, rules, links,
+// images, emphasis and code spans. Raw HTML in the markdown is escaped.
+var (
+	mdHeadingRe    = regexp.MustCompile(`^(#{1,6})\s+(.*?)\s*#*\s*$`)
+	mdRuleRe       = regexp.MustCompile(`^\s*([-*_])(\s*([-*_])){2,}\s*$`)
+	mdBulletRe     = regexp.MustCompile(`^\s*[-*+]\s+(.*)$`)
+	mdOrderedRe    = regexp.MustCompile(`^\s*\d+[.)]\s+(.*)$`)
+	mdCodeSpanRe   = regexp.MustCompile("`+([^`]+)`+")
+	mdImageRe      = regexp.MustCompile(`!\[([^\]]*)\]\(([^)\s]+)(?:\s+&#34;[^)]*&#34;)?\)`)
+	mdLinkRe       = regexp.MustCompile(`\[([^\]]+)\]\(([^)\s]+)(?:\s+&#34;[^)]*&#34;)?\)`)
+	mdStrongRe     = regexp.MustCompile(`(\*\*|__)(\S(?:.*?\S)?)(\*\*|__)`)
+	mdEmphasisRe   = regexp.MustCompile(`(^|[^\w*])[*_](\S(?:.*?\S)?)[*_]($|[^\w*])`)
+	mdSafeSchemeRe = regexp.MustCompile(`^(?i)(https?:|mailto:|/|#|\.)`)
+)
+
+// renderMarkdown converts markdown to HTML for the reading view.
+func renderMarkdown(src string) template.HTML {
+	var out strings.Builder
+	lines := strings.Split(strings.ReplaceAll(src, "\r\n", "\n"), "\n")
+
+	var paragraph []string
+	flushParagraph := func() {
+		if len(paragraph) > 0 {
+			out.WriteString("<p>" + renderInline(strings.Join(paragraph, " ")) + "</p>\n")
+			paragraph = nil
+		}
+	}
+
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		trimmed := strings.TrimSpace(line)
+
+		switch {
+		case trimmed == "":
+			flushParagraph()
+		case strings.HasPrefix(trimmed, "```") || strings.HasPrefix(trimmed, "~~~"):
+			flushParagraph()
+			fence := trimmed[:3]
+			var code []string
+			for i++; i < len(lines) && !strings.HasPrefix(strings.TrimSpace(lines[i]), fence); i++ {
+				code = append(code, lines[i])
+			}
+			out.WriteString("<pre><code>" + html.EscapeString(strings.Join(code, "\n")) + "</code></pre>\n")
+		case mdHeadingRe.MatchString(trimmed):
+			flushParagraph()
+			m := mdHeadingRe.FindStringSubmatch(trimmed)
+			level := string('0' + rune(len(m[1])))
+			out.WriteString("<h" + level + ">" + renderInline(m[2]) + "</h" + level + ">\n")
+		case mdRuleRe.MatchString(trimmed):
+			flushParagraph()
+			out.WriteString("<hr>\n")
+		case strings.HasPrefix(trimmed, ">"):
+			flushParagraph()
+			var quote []string
+			for ; i < len(lines) && strings.HasPrefix(strings.TrimSpace(lines[i]), ">"); i++ {
+				quote = append(quote, strings.TrimPrefix(strings.TrimPrefix(strings.TrimSpace(lines[i]), ">"), " "))
+			}
+			i--
+			out.WriteString("<blockquote>\n" + string(renderMarkdown(strings.Join(quote, "\n"))) + "</blockquote>\n")
+		case mdBulletRe.MatchString(line) || mdOrderedRe.MatchString(line):
+			flushParagraph()
+			itemRe, tag := mdBulletRe, "ul"
+			if !mdBulletRe.MatchString(line) {
+				itemRe, tag = mdOrderedRe, "ol"
+			}
+			out.WriteString("<" + tag + ">\n")
+			for ; i < len(lines); i++ {
+				m := itemRe.FindStringSubmatch(lines[i])
+				if m == nil {
+					break
+				}
+				out.WriteString("<li>" + renderInline(m[1]) + "</li>\n")
+			}
+			i--
+			out.WriteString("</" + tag + ">\n")
+		default:
+			paragraph = append(paragraph, trimmed)
+		}
+	}
+	flushParagraph()
+	return template.HTML(out.String())
+}
+
+// renderInline escapes text and renders inline markdown. Code spans are
+// rendered verbatim; links and images with unsafe schemes are dropped to
+// their text.
+func renderInline(text string) string {
+	var out strings.Builder
+	last := 0
+	for _, loc := range mdCodeSpanRe.FindAllStringSubmatchIndex(text, -1) {
+		out.WriteString(renderInlineText(text[last:loc[0]]))
+		out.WriteString("<code>" + html.EscapeString(text[loc[2]:loc[3]]) + "</code>")
+		last = loc[1]
+	}
+	out.WriteString(renderInlineText(text[last:]))
+	return out.String()
+}
+
+func renderInlineText(text string) string {
+	// Links and images are swapped for placeholders while emphasis is
+	// applied, so underscores in their URLs are left alone
+	var elements []string
+	placeholder := func(element string) string {
+		elements = append(elements, element)
+		return "\x00" + string(rune('0'+len(elements)-1)) + "\x00"
+	}
+
+	text = html.EscapeString(strings.ReplaceAll(text, "\x00", ""))
+	text = mdImageRe.ReplaceAllStringFunc(text, func(match string) string {
+		m := mdImageRe.FindStringSubmatch(match)
+		if !mdSafeSchemeRe.MatchString(m[2]) {
+			return m[1]
+		}
+		return placeholder(`<img src="` + m[2] + `" alt="` + m[1] + `" loading="lazy">`)
+	})
+	text = mdLinkRe.ReplaceAllStringFunc(text, func(match string) string {
+		m := mdLinkRe.FindStringSubmatch(match)
+		if !mdSafeSchemeRe.MatchString(m[2]) {
+			return m[1]
+		}
+		return `<a href="` + placeholder(m[2]) + `" rel="noopener noreferrer">` + m[1] + `</a>`
+	})
+	text = mdStrongRe.ReplaceAllString(text, "<strong>$2</strong>")
+	text = mdEmphasisRe.ReplaceAllString(text, "$1<em>$2</em>$3")
+
+	for i, element := range elements {
+		text = strings.Replace(text, "\x00"+string(rune('0'+i))+"\x00", element, 1)
+	}
+	return text
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRenderMarkdown(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want string
+	}{
+		{"heading", "## Title ##", "<h2>Title</h2>\n"},
+		{"paragraph lines join", "one\ntwo\n\nthree", "<p>one two</p>\n<p>three</p>\n"},
+		{"crlf", "one\r\n\r\ntwo", "<p>one</p>\n<p>two</p>\n"},
+		{"rule", "- - -", "<hr>\n"},
+		{"bullets", "- a\n* b", "<ul>\n<li>a</li>\n<li>b</li>\n</ul>\n"},
+		{"ordered", "1. a\n2) b", "<ol>\n<li>a</li>\n<li>b</li>\n</ol>\n"},
+		{"blockquote", "> quoted\n> more", "<blockquote>\n<p>quoted more</p>\n</blockquote>\n"},
+		{"fenced code", "```go\nx := <y>\n```", "<pre><code>x := &lt;y&gt;</code></pre>\n"},
+		{"unterminated fence", "~~~\ncode", "<pre><code>code</code></pre>\n"},
+		{"raw html escaped", "<script>alert(1)</script>", "<p>&lt;script&gt;alert(1)&lt;/script&gt;</p>\n"},
+		{"emphasis", "**bold** and *it* and __b__", "<p><strong>bold</strong> and <em>it</em> and <strong>b</strong></p>\n"},
+		{"snake case left alone", "call my_var_name now", "<p>call my_var_name now</p>\n"},
+		{"code span", "use `a*b*c` here", "<p>use <code>a*b*c</code> here</p>\n"},
+		{"link", "[Go](https://go.dev/a_b_c)", `<p><a href="https://go.dev/a_b_c" rel="noopener noreferrer">Go</a></p>` + "\n"},
+		{"link title", `[Go](https://go.dev "The site")`, `<p><a href="https://go.dev" rel="noopener noreferrer">Go</a></p>` + "\n"},
+		{"javascript link dropped", "[x](javascript:void)", "<p>x</p>\n"},
+		{"image", "![alt](/img.png)", `<p><img src="/img.png" alt="alt" loading="lazy"></p>` + "\n"},
+		{"data image dropped", "![alt](data:image/png;base64,AAA)", "<p>alt</p>\n"},
+		{"attribute injection", `[x](https://a.dev/"onmouseover=alert(1))`, `<p><a href="https://a.dev/&#34;onmouseover=alert(1" rel="noopener noreferrer">x</a>)</p>` + "\n"},
+		{"nul bytes", "a\x00b", "<p>ab</p>\n"},
+		{"empty", "", ""},
+	}
+	for _, tt := range tests {
+		if got := string(renderMarkdown(tt.src)); got != tt.want {
+			t.Errorf("%s:\n got %q\nwant %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestRenderMarkdownManyLinks(t *testing.T) {
+	// More links than there are digits keeps placeholders distinct
+	var src strings.Builder
+	for i := 0; i < 15; i++ {
+		src.WriteString("[l](https://x.dev/" + string(rune('a'+i)) + ") ")
+	}
+	got := string(renderMarkdown(src.String()))
+	for i := 0; i < 15; i++ {
+		if !strings.Contains(got, `href="https://x.dev/`+string(rune('a'+i))+`"`) {
+			t.Errorf("link %d missing from %q", i, got)
+		}
+	}
+	if strings.Contains(got, "\x00") {
+		t.Errorf("placeholder left in %q", got)
+	}
+}
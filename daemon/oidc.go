@@ -0,0 +1,401 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	sessionCookie    = "memento_session"
+	loginStateCookie = "memento_login"
+	loginStateMaxAge = 10 * time.Minute
+	// maxLoginStates and maxSessions bound the in-memory maps so that
+	// unauthenticated login attempts can't grow them without limit.
+	maxLoginStates = 1000
+	maxSessions    = 1000
+)
+
+// oidcProvider holds the endpoints from the issuer's discovery document.
+type oidcProvider struct {
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	UserinfoEndpoint      string `json:"userinfo_endpoint"`
+	EndSessionEndpoint    string `json:"end_session_endpoint"`
+}
+
+// Session is a signed-in browser user.
+type Session struct {
+	Subject string
+	Email   string
+	Name    string
+	Expires time.Time
+}
+
+// loginState is what a login in progress needs back at the callback. It is
+// keyed by the random state sent to the provider.
+type loginState struct {
+	verifier string
+	next     string
+	expires  time.Time
+}
+
+var (
+	oidcClient = &http.Client{Timeout: 15 * time.Second}
+
+	providerMu sync.Mutex
+	provider   *oidcProvider
+
+	sessionsMu  sync.Mutex
+	sessions    = make(map[string]*Session)
+	loginStates = make(map[string]loginState)
+)
+
+func oidcEnabled() bool {
+	return config.OIDC.Issuer != ""
+}
+
+// discoverProvider fetches and caches the issuer's discovery document.
+func discoverProvider() (*oidcProvider, error) {
+	providerMu.Lock()
+	defer providerMu.Unlock()
+	if provider != nil {
+		return provider, nil
+	}
+
+	discoveryURL := strings.TrimRight(config.OIDC.Issuer, "/") + "/.well-known/openid-configuration"
+	resp, err := oidcClient.Get(discoveryURL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching %s: %s", discoveryURL, resp.Status)
+	}
+	var p oidcProvider
+	if err := json.NewDecoder(resp.Body).Decode(&p); err != nil {
+		return nil, fmt.Errorf("parsing discovery document: %v", err)
+	}
+	if p.AuthorizationEndpoint == "" || p.TokenEndpoint == "" || p.UserinfoEndpoint == "" {
+		return nil, fmt.Errorf("discovery document is missing endpoints")
+	}
+	provider = &p
+	return provider, nil
+}
+
+func randomString() string {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// requestSession returns the session of a signed-in browser, or nil.
+func requestSession(r *http.Request) *Session {
+	cookie, err := r.Cookie(sessionCookie)
+	if err != nil {
+		return nil
+	}
+	sessionsMu.Lock()
+	defer sessionsMu.Unlock()
+	session, ok := sessions[cookie.Value]
+	if !ok {
+		return nil
+	}
+	if time.Now().After(session.Expires) {
+		delete(sessions, cookie.Value)
+		return nil
+	}
+	return session
+}
+
+// wantsHTML reports whether a request comes from a browser navigating to a
+// page, which should be sent to the login page rather than given an error.
+func wantsHTML(r *http.Request) bool {
+	return r.Method == http.MethodGet && strings.Contains(r.Header.Get("Accept"), "text/html")
+}
+
+// localRedirect keeps post-login redirects on this daemon.
+func localRedirect(next string) string {
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") || strings.HasPrefix(next, "/\\") {
+		return "/text"
+	}
+	return next
+}
+
+// handleLogin starts the authorization code flow with PKCE.
+func handleLogin(w http.ResponseWriter, r *http.Request) {
+	if !oidcEnabled() {
+		writeError(w, http.StatusNotFound, ErrNotFound, "Login is not configured")
+		return
+	}
+	p, err := discoverProvider()
+	if err != nil {
+		log.Printf("OIDC discovery failed: %v", err)
+		writeError(w, http.StatusBadGateway, ErrInternal, "Identity provider unavailable")
+		return
+	}
+
+	state, verifier := randomString(), randomString()
+	sessionsMu.Lock()
+	now := time.Now()
+	for key, pending := range loginStates {
+		if now.After(pending.expires) {
+			delete(loginStates, key)
+		}
+	}
+	if len(loginStates) >= maxLoginStates {
+		sessionsMu.Unlock()
+		writeError(w, http.StatusServiceUnavailable, ErrInternal, "Too many logins in progress, please try again later")
+		return
+	}
+	loginStates[state] = loginState{
+		verifier: verifier,
+		next:     localRedirect(r.URL.Query().Get("next")),
+		expires:  now.Add(loginStateMaxAge),
+	}
+	sessionsMu.Unlock()
+
+	// The state is also bound to this browser so a login can't be completed
+	// in another one
+	http.SetCookie(w, &http.Cookie{
+		Name:     loginStateCookie,
+		Value:    state,
+		Path:     "/auth/",
+		MaxAge:   int(loginStateMaxAge.Seconds()),
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	challenge := sha256.Sum256([]byte(verifier))
+	query := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {config.OIDC.ClientID},
+		"redirect_uri":          {config.OIDC.RedirectURL},
+		"scope":                 {strings.Join(config.OIDC.Scopes, " ")},
+		"state":                 {state},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+	}
+	sep := "?"
+	if strings.Contains(p.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	http.Redirect(w, r, p.AuthorizationEndpoint+sep+query.Encode(), http.StatusFound)
+}
+
+// handleLoginCallback exchanges the authorization code for tokens, looks the
+// user up at the userinfo endpoint and starts a session.
+func handleLoginCallback(w http.ResponseWriter, r *http.Request) {
+	if !oidcEnabled() {
+		writeError(w, http.StatusNotFound, ErrNotFound, "Login is not configured")
+		return
+	}
+	query := r.URL.Query()
+	if e := query.Get("error"); e != "" {
+		writeError(w, http.StatusUnauthorized, ErrUnauthorized, fmt.Sprintf("Login failed: %s %s", e, query.Get("error_description")))
+		return
+	}
+
+	state := query.Get("state")
+	cookie, err := r.Cookie(loginStateCookie)
+	if err != nil || cookie.Value != state {
+		writeError(w, http.StatusBadRequest, ErrInvalidRequest, "Login state mismatch")
+		return
+	}
+	sessionsMu.Lock()
+	pending, ok := loginStates[state]
+	delete(loginStates, state)
+	sessionsMu.Unlock()
+	if !ok || time.Now().After(pending.expires) {
+		writeError(w, http.StatusBadRequest, ErrInvalidRequest, "Login expired, please try again")
+		return
+	}
+	http.SetCookie(w, &http.Cookie{Name: loginStateCookie, Path: "/auth/", MaxAge: -1})
+
+	p, err := discoverProvider()
+	if err != nil {
+		log.Printf("OIDC discovery failed: %v", err)
+		writeError(w, http.StatusBadGateway, ErrInternal, "Identity provider unavailable")
+		return
+	}
+	accessToken, err := exchangeCode(p, query.Get("code"), pending.verifier)
+	if err != nil {
+		log.Printf("OIDC code exchange failed: %v", err)
+		writeError(w, http.StatusUnauthorized, ErrUnauthorized, "Login failed")
+		return
+	}
+	session, err := fetchUserinfo(p, accessToken)
+	if err != nil {
+		log.Printf("OIDC userinfo request failed: %v", err)
+		writeError(w, http.StatusUnauthorized, ErrUnauthorized, "Login failed")
+		return
+	}
+	if !emailAllowed(session.Email) {
+		log.Printf("Refused login for %q", session.Email)
+		writeError(w, http.StatusForbidden, ErrForbidden, "This account may not use this archive")
+		return
+	}
+
+	session.Expires = time.Now().Add(time.Duration(config.OIDC.SessionHours) * time.Hour)
+	id := randomString()
+	sessionsMu.Lock()
+	sweepSessions()
+	sessions[id] = session
+	sessionsMu.Unlock()
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    id,
+		Path:     "/",
+		Expires:  session.Expires,
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+	log.Printf("Signed in %s", session.Email)
+	http.Redirect(w, r, pending.next, http.StatusFound)
+}
+
+func exchangeCode(p *oidcProvider, code, verifier string) (string, error) {
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {config.OIDC.RedirectURL},
+		"client_id":     {config.OIDC.ClientID},
+		"code_verifier": {verifier},
+	}
+	req, err := http.NewRequest(http.MethodPost, p.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if !config.OIDC.ClientSecret.IsZero() {
+		secret, err := config.OIDC.ClientSecret.Value()
+		if err != nil {
+			return "", fmt.Errorf("reading client secret: %v", err)
+		}
+		req.SetBasicAuth(url.QueryEscape(config.OIDC.ClientID), url.QueryEscape(secret))
+	}
+	resp, err := oidcClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("token endpoint: %s", resp.Status)
+	}
+	var tokens struct {
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&tokens); err != nil {
+		return "", err
+	}
+	if tokens.AccessToken == "" {
+		return "", fmt.Errorf("token endpoint returned no access token")
+	}
+	return tokens.AccessToken, nil
+}
+
+func fetchUserinfo(p *oidcProvider, accessToken string) (*Session, error) {
+	req, err := http.NewRequest(http.MethodGet, p.UserinfoEndpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	resp, err := oidcClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("userinfo endpoint: %s", resp.Status)
+	}
+	var info struct {
+		Subject       string `json:"sub"`
+		Email         string `json:"email"`
+		EmailVerified *bool  `json:"email_verified"`
+		Name          string `json:"name"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&info); err != nil {
+		return nil, err
+	}
+	if info.Subject == "" {
+		return nil, fmt.Errorf("userinfo response has no subject")
+	}
+	// An unverified address can't be trusted against the allow list
+	if info.EmailVerified != nil && !*info.EmailVerified {
+		info.Email = ""
+	}
+	return &Session{Subject: info.Subject, Email: info.Email, Name: info.Name}, nil
+}
+
+// sweepSessions drops expired sessions and, if there is still no room for
+// another, the one closest to expiring. The caller holds sessionsMu.
+func sweepSessions() {
+	now := time.Now()
+	var soonestID string
+	var soonest time.Time
+	for id, session := range sessions {
+		if now.After(session.Expires) {
+			delete(sessions, id)
+			continue
+		}
+		if soonestID == "" || session.Expires.Before(soonest) {
+			soonestID, soonest = id, session.Expires
+		}
+	}
+	if len(sessions) >= maxSessions {
+		delete(sessions, soonestID)
+	}
+}
+
+// emailAllowed reports whether a verified address is on the allow list,
+// either by itself or through its domain. Nobody is allowed without one.
+func emailAllowed(email string) bool {
+	if email == "" {
+		return false
+	}
+	for _, allowed := range config.OIDC.AllowedEmails {
+		if strings.EqualFold(allowed, email) {
+			return true
+		}
+	}
+	domain := email[strings.LastIndex(email, "@")+1:]
+	for _, allowed := range config.OIDC.AllowedDomains {
+		if strings.EqualFold(strings.TrimPrefix(allowed, "@"), domain) {
+			return true
+		}
+	}
+	return false
+}
+
+// handleLogout ends the session and, if the provider supports it, the
+// provider's session too. It only answers POST requests, so other sites
+// can't log users out with a link or an image.
+func handleLogout(w http.ResponseWriter, r *http.Request) {
+	if cookie, err := r.Cookie(sessionCookie); err == nil {
+		sessionsMu.Lock()
+		delete(sessions, cookie.Value)
+		sessionsMu.Unlock()
+	}
+	http.SetCookie(w, &http.Cookie{Name: sessionCookie, Path: "/", MaxAge: -1})
+
+	if oidcEnabled() {
+		if p, err := discoverProvider(); err == nil && p.EndSessionEndpoint != "" {
+			http.Redirect(w, r, p.EndSessionEndpoint+"?"+url.Values{"client_id": {config.OIDC.ClientID}}.Encode(), http.StatusFound)
+			return
+		}
+	}
+	http.Redirect(w, r, "/text", http.StatusFound)
+}
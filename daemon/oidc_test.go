@@ -0,0 +1,229 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestEmailAllowed(t *testing.T) {
+	saved := config
+	defer func() { config = saved }()
+
+	tests := []struct {
+		name    string
+		emails  []string
+		domains []string
+		email   string
+		want    bool
+	}{
+		{"no allow list", nil, nil, "me@example.com", false},
+		{"listed address", []string{"Me@Example.com"}, nil, "me@example.com", true},
+		{"unlisted address", []string{"me@example.com"}, nil, "you@example.com", false},
+		{"listed domain", nil, []string{"example.com"}, "you@example.com", true},
+		{"domain with at sign", nil, []string{"@example.com"}, "you@EXAMPLE.com", true},
+		{"subdomain", nil, []string{"example.com"}, "you@evil.example.com", false},
+		{"unverified address", []string{"me@example.com"}, []string{"example.com"}, "", false},
+	}
+	for _, tt := range tests {
+		config.OIDC.AllowedEmails = tt.emails
+		config.OIDC.AllowedDomains = tt.domains
+		if got := emailAllowed(tt.email); got != tt.want {
+			t.Errorf("%s: emailAllowed(%q) = %v, want %v", tt.name, tt.email, got, tt.want)
+		}
+	}
+}
+
+// fakeProvider serves the discovery, token and userinfo endpoints of an
+// OpenID Connect provider that signs in email for any code whose verifier
+// matches the challenge sent to the authorization endpoint.
+func fakeProvider(t *testing.T, email string, challenge *string) *httptest.Server {
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/.well-known/openid-configuration":
+			json.NewEncoder(w).Encode(oidcProvider{
+				AuthorizationEndpoint: server.URL + "/authorize",
+				TokenEndpoint:         server.URL + "/token",
+				UserinfoEndpoint:      server.URL + "/userinfo",
+			})
+		case "/token":
+			sum := sha256.Sum256([]byte(r.FormValue("code_verifier")))
+			if r.FormValue("code") != "the-code" || base64.RawURLEncoding.EncodeToString(sum[:]) != *challenge {
+				http.Error(w, "bad code", http.StatusBadRequest)
+				return
+			}
+			json.NewEncoder(w).Encode(map[string]string{"access_token": "access"})
+		case "/userinfo":
+			if r.Header.Get("Authorization") != "Bearer access" {
+				http.Error(w, "bad token", http.StatusUnauthorized)
+				return
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{"sub": "1", "email": email, "email_verified": true})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// withOIDC configures login through the issuer for the duration of a test.
+func withOIDC(t *testing.T, issuer string) {
+	t.Helper()
+	saved := config
+	t.Cleanup(func() {
+		config = saved
+		providerMu.Lock()
+		provider = nil
+		providerMu.Unlock()
+	})
+	config.OIDC = OIDCConfig{
+		Issuer:        issuer,
+		ClientID:      "memento",
+		RedirectURL:   "http://memento.test/auth/callback",
+		Scopes:        []string{"openid", "email"},
+		AllowedEmails: []string{"me@example.com"},
+		SessionHours:  1,
+	}
+}
+
+func responseCookie(rec *httptest.ResponseRecorder, name string) *http.Cookie {
+	for _, cookie := range rec.Result().Cookies() {
+		if cookie.Name == name {
+			return cookie
+		}
+	}
+	return nil
+}
+
+func TestOIDCLogin(t *testing.T) {
+	var challenge string
+	withOIDC(t, fakeProvider(t, "me@example.com", &challenge).URL)
+
+	rec := httptest.NewRecorder()
+	handleLogin(rec, httptest.NewRequest(http.MethodGet, "/auth/login?next=/pages", nil))
+	if rec.Code != http.StatusFound {
+		t.Fatalf("login: status = %d, want 302", rec.Code)
+	}
+	location, err := url.Parse(rec.Header().Get("Location"))
+	if err != nil || !strings.HasSuffix(location.Path, "/authorize") {
+		t.Fatalf("login redirected to %q", rec.Header().Get("Location"))
+	}
+	params := location.Query()
+	if params.Get("code_challenge_method") != "S256" || params.Get("redirect_uri") != config.OIDC.RedirectURL {
+		t.Errorf("authorization request = %v", params)
+	}
+	challenge = params.Get("code_challenge")
+	state := responseCookie(rec, loginStateCookie)
+	if state == nil || state.Value != params.Get("state") || !state.HttpOnly {
+		t.Fatalf("login state cookie = %+v", state)
+	}
+
+	callback := func(withCookie bool) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/auth/callback?code=the-code&state="+url.QueryEscape(state.Value), nil)
+		if withCookie {
+			r.AddCookie(state)
+		}
+		rec := httptest.NewRecorder()
+		handleLoginCallback(rec, r)
+		return rec
+	}
+	// The login must finish in the browser that started it
+	if rec := callback(false); rec.Code != http.StatusBadRequest {
+		t.Errorf("callback without state cookie: status = %d, want 400", rec.Code)
+	}
+	rec = callback(true)
+	if rec.Code != http.StatusFound || rec.Header().Get("Location") != "/pages" {
+		t.Fatalf("callback: status = %d, Location = %q", rec.Code, rec.Header().Get("Location"))
+	}
+	cookie := responseCookie(rec, sessionCookie)
+	if cookie == nil {
+		t.Fatal("no session cookie")
+	}
+	// The state is used up
+	if rec := callback(true); rec.Code != http.StatusBadRequest {
+		t.Errorf("replayed callback: status = %d, want 400", rec.Code)
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/pages", nil)
+	r.AddCookie(cookie)
+	if session := requestSession(r); session == nil || session.Email != "me@example.com" {
+		t.Fatalf("session = %+v", session)
+	}
+	seen := false
+	requireAuth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { seen = true })).ServeHTTP(httptest.NewRecorder(), r)
+	if !seen {
+		t.Error("signed-in request refused")
+	}
+
+	logout := func(method, origin string) int {
+		t.Helper()
+		r := httptest.NewRequest(method, "/auth/logout", nil)
+		r.AddCookie(cookie)
+		if origin != "" {
+			r.Header.Set("Origin", origin)
+		}
+		rec := httptest.NewRecorder()
+		newRouter().ServeHTTP(rec, r)
+		return rec.Code
+	}
+	if code := logout(http.MethodGet, ""); code != http.StatusMethodNotAllowed {
+		t.Errorf("GET logout: status = %d, want 405", code)
+	}
+	if code := logout(http.MethodPost, "https://evil.example"); code != http.StatusForbidden {
+		t.Errorf("logout from another site: status = %d, want 403", code)
+	}
+	if requestSession(r) == nil {
+		t.Fatal("session ended by a refused logout")
+	}
+	if code := logout(http.MethodPost, ""); code != http.StatusFound {
+		t.Errorf("logout: status = %d, want 302", code)
+	}
+	if requestSession(r) != nil {
+		t.Error("session survived logout")
+	}
+}
+
+func TestOIDCLoginRefusesUnlistedEmail(t *testing.T) {
+	var challenge string
+	withOIDC(t, fakeProvider(t, "you@example.com", &challenge).URL)
+
+	rec := httptest.NewRecorder()
+	handleLogin(rec, httptest.NewRequest(http.MethodGet, "/auth/login", nil))
+	location, _ := url.Parse(rec.Header().Get("Location"))
+	challenge = location.Query().Get("code_challenge")
+	state := responseCookie(rec, loginStateCookie)
+
+	r := httptest.NewRequest(http.MethodGet, "/auth/callback?code=the-code&state="+url.QueryEscape(state.Value), nil)
+	r.AddCookie(state)
+	rec = httptest.NewRecorder()
+	handleLoginCallback(rec, r)
+	if rec.Code != http.StatusForbidden || responseCookie(rec, sessionCookie) != nil {
+		t.Errorf("status = %d, want 403 without a session", rec.Code)
+	}
+}
+
+func TestRequireAuthSendsBrowsersToLogin(t *testing.T) {
+	withOIDC(t, "https://login.example.com")
+	handler := requireAuth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	r := httptest.NewRequest(http.MethodGet, "/pages/x/read", nil)
+	r.Header.Set("Accept", "text/html")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, r)
+	if rec.Code != http.StatusFound || !strings.HasPrefix(rec.Header().Get("Location"), "/auth/login?") {
+		t.Errorf("browser: status = %d, Location = %q", rec.Code, rec.Header().Get("Location"))
+	}
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/pages", nil))
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("API client: status = %d, want 401", rec.Code)
+	}
+}
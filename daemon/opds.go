@@ -0,0 +1,276 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"fmt"
+	"html"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	opdsPageSize        = 50
+	opdsAcquisitionType = "application/atom+xml;profile=opds-catalog;kind=acquisition"
+)
+
+type opdsFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	ID      string      `xml:"id"`
+	Title   string      `xml:"title"`
+	Updated string      `xml:"updated"`
+	Links   []opdsLink  `xml:"link"`
+	Entries []opdsEntry `xml:"entry"`
+}
+
+type opdsLink struct {
+	Rel  string `xml:"rel,attr"`
+	Href string `xml:"href,attr"`
+	Type string `xml:"type,attr"`
+}
+
+type opdsEntry struct {
+	ID      string       `xml:"id"`
+	Title   string       `xml:"title"`
+	Updated string       `xml:"updated"`
+	Authors []opdsAuthor `xml:"author,omitempty"`
+	Summary string       `xml:"summary,omitempty"`
+	Links   []opdsLink   `xml:"link"`
+	// Categories are the tags of pages in the archive's own feed.
+	Categories []opdsCategory `xml:"category,omitempty"`
+}
+
+type opdsAuthor struct {
+	Name string `xml:"name"`
+}
+
+type opdsCategory struct {
+	Term string `xml:"term,attr"`
+}
+
+// pageWordCount returns the word count recorded at index time, computing it
+// for pages indexed before word counts were tracked.
+func pageWordCount(page Page) int {
+	if page.WordCount > 0 {
+		return page.WordCount
+	}
+	text, err := readPageText(page.PageMetadata)
+	if err != nil {
+		return 0
+	}
+	return wordCount(text)
+}
+
+func handleOPDSCatalog(w http.ResponseWriter, r *http.Request) {
+	pages, err := listPages()
+	if err != nil {
+		log.Printf("Error listing pages: %v", err)
+		writeError(w, http.StatusInternalServerError, ErrInternal, "Failed to list pages")
+		return
+	}
+
+	// Counting words can mean reading the page, so it is done once here
+	private := privateListed(r)
+	var longReads []Page
+	for _, page := range pages {
+		if (page.Private && !private) || !pageVisible(r, page.PageMetadata) {
+			continue
+		}
+		if page.WordCount = pageWordCount(page); page.WordCount >= config.OPDS.MinWords {
+			longReads = append(longReads, page)
+		}
+	}
+	sort.Slice(longReads, func(i, j int) bool {
+		return longReads[i].Timestamp.After(longReads[j].Timestamp)
+	})
+
+	pageNum, _ := strconv.Atoi(r.URL.Query().Get("page"))
+	if pageNum < 1 {
+		pageNum = 1
+	}
+	start := (pageNum - 1) * opdsPageSize
+	if start > len(longReads) {
+		start = len(longReads)
+	}
+	end := start + opdsPageSize
+	if end > len(longReads) {
+		end = len(longReads)
+	}
+
+	localizer := localizerFor(r)
+	feed := opdsFeed{
+		ID:      "urn:memento:opds:longreads",
+		Title:   localizer.T("opds.title"),
+		Updated: time.Now().UTC().Format(time.RFC3339),
+		Links: []opdsLink{
+			{Rel: "self", Href: fmt.Sprintf("/opds?page=%d", pageNum), Type: opdsAcquisitionType},
+			{Rel: "start", Href: "/opds", Type: opdsAcquisitionType},
+		},
+	}
+	if end < len(longReads) {
+		feed.Links = append(feed.Links, opdsLink{Rel: "next", Href: fmt.Sprintf("/opds?page=%d", pageNum+1), Type: opdsAcquisitionType})
+	}
+
+	for _, page := range longReads[start:end] {
+		entry := opdsEntry{
+			ID:      "urn:memento:page:" + page.ID,
+			Title:   page.Title,
+			Updated: page.Timestamp.UTC().Format(time.RFC3339),
+			Summary: localizer.T("opds.summary", page.WordCount, page.URL),
+			Links: []opdsLink{
+				{Rel: "http://opds-spec.org/acquisition", Href: "/opds/pages/" + page.ID, Type: "application/epub+zip"},
+				{Rel: "alternate", Href: page.URL, Type: "text/html"},
+			},
+		}
+		if page.Citation != nil {
+			for _, author := range page.Citation.Authors {
+				entry.Authors = append(entry.Authors, opdsAuthor{Name: author})
+			}
+		}
+		feed.Entries = append(feed.Entries, entry)
+	}
+
+	w.Header().Set("Content-Type", opdsAcquisitionType)
+	w.Write([]byte(xml.Header))
+	if err := xml.NewEncoder(w).Encode(feed); err != nil {
+		log.Printf("Error encoding OPDS feed: %v", err)
+	}
+}
+
+func handleOPDSEpub(w http.ResponseWriter, r *http.Request) {
+	if !requireAction(w, "epub") {
+		return
+	}
+	page, err := loadPage(r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusNotFound, ErrNotFound, "Page not found")
+		return
+	}
+	text, err := epubText(page)
+	if err != nil {
+		log.Printf("Error reading content of page %s: %v", page.ID, err)
+		writeError(w, http.StatusNotFound, ErrNotFound, "Page content not found")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/epub+zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", page.ID+".epub"))
+	if err := writeEpub(w, page, text); err != nil {
+		log.Printf("Error writing EPUB for page %s: %v", page.ID, err)
+	}
+}
+
+// epubText returns a page's content as plain text. Markdown is rendered
+// first so that its syntax doesn't end up in the book.
+func epubText(page Page) (string, error) {
+	path := contentPath(page.PageMetadata)
+	content, err := readContentFile(path)
+	if err != nil {
+		return "", err
+	}
+	if isHTMLFile(path) {
+		return extractText(string(content), true), nil
+	}
+	markdown := commentRe.ReplaceAllString(string(content), "")
+	return extractText(string(renderMarkdown(markdown)), true), nil
+}
+
+// writeEpub renders a page's extracted text as a minimal single-chapter
+// EPUB 2 book.
+func writeEpub(w io.Writer, page Page, text string) error {
+	zw := zip.NewWriter(w)
+
+	// The mimetype entry must come first and be stored uncompressed
+	mimetype, err := zw.CreateHeader(&zip.FileHeader{Name: "mimetype", Method: zip.Store})
+	if err != nil {
+		return err
+	}
+	if _, err := mimetype.Write([]byte("application/epub+zip")); err != nil {
+		return err
+	}
+
+	title := html.EscapeString(page.Title)
+	identifier := "urn:memento:page:" + page.ID
+	var creators strings.Builder
+	if page.Citation != nil {
+		for _, author := range page.Citation.Authors {
+			fmt.Fprintf(&creators, "    <dc:creator>%s</dc:creator>\n", html.EscapeString(author))
+		}
+	}
+
+	var body strings.Builder
+	for _, paragraph := range strings.Split(text, "\n\n") {
+		paragraph = strings.TrimSpace(paragraph)
+		if paragraph != "" {
+			fmt.Fprintf(&body, "<p>%s</p>\n", strings.ReplaceAll(html.EscapeString(paragraph), "\n", "<br/>"))
+		}
+	}
+
+	files := []struct{ name, content string }{
+		{"META-INF/container.xml", `<?xml version="1.0" encoding="UTF-8"?>
+<container version="1.0" xmlns="urn:oasis:names:tc:opendocument:xmlns:container">
+  <rootfiles>
+    <rootfile full-path="OEBPS/content.opf" media-type="application/oebps-package+xml"/>
+  </rootfiles>
+</container>
+`},
+		{"OEBPS/content.opf", fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
+<package xmlns="http://www.idpf.org/2007/opf" unique-identifier="BookId" version="2.0">
+  <metadata xmlns:dc="http://purl.org/dc/elements/1.1/">
+    <dc:title>%s</dc:title>
+%s    <dc:identifier id="BookId">%s</dc:identifier>
+    <dc:source>%s</dc:source>
+    <dc:date>%s</dc:date>
+    <dc:language>en</dc:language>
+  </metadata>
+  <manifest>
+    <item id="ncx" href="toc.ncx" media-type="application/x-dtbncx+xml"/>
+    <item id="page" href="page.xhtml" media-type="application/xhtml+xml"/>
+  </manifest>
+  <spine toc="ncx">
+    <itemref idref="page"/>
+  </spine>
+</package>
+`, title, creators.String(), identifier, html.EscapeString(page.URL), page.Timestamp.UTC().Format("2006-01-02"))},
+		{"OEBPS/toc.ncx", fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
+<ncx xmlns="http://www.daisy.org/z3986/2005/ncx/" version="2005-1">
+  <head>
+    <meta name="dtb:uid" content="%s"/>
+  </head>
+  <docTitle><text>%s</text></docTitle>
+  <navMap>
+    <navPoint id="page" playOrder="1">
+      <navLabel><text>%s</text></navLabel>
+      <content src="page.xhtml"/>
+    </navPoint>
+  </navMap>
+</ncx>
+`, identifier, title, title)},
+		{"OEBPS/page.xhtml", fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE html PUBLIC "-//W3C//DTD XHTML 1.1//EN" "http://www.w3.org/TR/xhtml11/DTD/xhtml11.dtd">
+<html xmlns="http://www.w3.org/1999/xhtml">
+<head><title>%s</title></head>
+<body>
+<h1>%s</h1>
+<p><a href="%s">%s</a></p>
+%s</body>
+</html>
+`, title, title, html.EscapeString(page.URL), html.EscapeString(page.URL), body.String())},
+	}
+
+	for _, file := range files {
+		fw, err := zw.Create(file.name)
+		if err != nil {
+			return err
+		}
+		if _, err := fw.Write([]byte(file.content)); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestEpubText(t *testing.T) {
+	withPagesDir(t)
+	tests := []struct {
+		name     string
+		html     string
+		markdown string
+		want     string
+		absent   []string
+	}{
+		{
+			name:     "markdown",
+			markdown: "# Title\n\nSome **bold** and [a link](https://example.com).\n\n<!-- memento:attention 0.5 -->\n- item",
+			want:     "Title\n\nSome bold and a link.\n\nitem",
+			absent:   []string{"**", "](", "memento:attention"},
+		},
+		{
+			name: "html",
+			html: "<html><body><script>x()</script><p>One</p><p>Two &amp; three</p></body></html>",
+			want: "One\n\nTwo & three",
+		},
+	}
+	for _, tt := range tests {
+		id, err := storePage(PageMetadata{URL: "https://example.com/" + tt.name, Title: tt.name}, tt.html, tt.markdown)
+		if err != nil {
+			t.Fatal(err)
+		}
+		page, err := loadPage(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := epubText(page)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+		for _, s := range tt.absent {
+			if strings.Contains(got, s) {
+				t.Errorf("%s: text still contains %q", tt.name, s)
+			}
+		}
+	}
+}
+
+func TestPageWordCount(t *testing.T) {
+	withPagesDir(t)
+	if got := pageWordCount(Page{PageMetadata: PageMetadata{WordCount: 42}}); got != 42 {
+		t.Errorf("recorded count: got %d, want 42", got)
+	}
+	if err := os.WriteFile(filepath.Join(pagesDir, "p.html"), []byte("<p>three small words</p>"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if got := pageWordCount(Page{PageMetadata: PageMetadata{HTMLFilename: "p.html"}}); got != 3 {
+		t.Errorf("computed count: got %d, want 3", got)
+	}
+}
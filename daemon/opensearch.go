@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/xml"
+	"log"
+	"net/http"
+)
+
+const (
+	openSearchType        = "application/opensearchdescription+xml"
+	openSearchSuggestType = "application/x-suggestions+json"
+)
+
+// openSearchDescription tells browsers how to search the archive from the
+// address bar.
+type openSearchDescription struct {
+	XMLName       xml.Name        `xml:"http://a9.com/-/spec/opensearch/1.1/ OpenSearchDescription"`
+	ShortName     string          `xml:"ShortName"`
+	Description   string          `xml:"Description"`
+	InputEncoding string          `xml:"InputEncoding"`
+	URLs          []openSearchURL `xml:"Url"`
+}
+
+type openSearchURL struct {
+	Type     string `xml:"type,attr"`
+	Method   string `xml:"method,attr,omitempty"`
+	Rel      string `xml:"rel,attr,omitempty"`
+	Template string `xml:"template,attr"`
+}
+
+// baseURL is the scheme and host the request reached the daemon at, for
+// links that must be absolute.
+func baseURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host
+}
+
+// handleOpenSearch serves the OpenSearch description of the text search
+// page and its suggestions, which browsers offer to add as a search engine.
+func handleOpenSearch(w http.ResponseWriter, r *http.Request) {
+	base := baseURL(r)
+	description := openSearchDescription{
+		ShortName:     "Memento",
+		Description:   "Search your Memento archive",
+		InputEncoding: "UTF-8",
+		URLs: []openSearchURL{
+			{Type: "text/html", Method: "get", Template: base + "/text?q={searchTerms}"},
+			{Type: openSearchSuggestType, Method: "get", Template: base + apiPrefix + "/suggest?format=opensearch&q={searchTerms}"},
+			{Type: openSearchType, Rel: "self", Template: base + "/opensearch.xml"},
+		},
+	}
+	w.Header().Set("Content-Type", openSearchType)
+	w.Write([]byte(xml.Header))
+	if err := xml.NewEncoder(w).Encode(description); err != nil {
+		log.Printf("Error writing OpenSearch description: %v", err)
+	}
+}
+
+// handleHome sends browsers opening the daemon's address, with or without
+// a ?q= query, to the text search page.
+func handleHome(w http.ResponseWriter, r *http.Request) {
+	target := "/text"
+	if r.URL.RawQuery != "" {
+		target += "?" + r.URL.RawQuery
+	}
+	http.Redirect(w, r, target, http.StatusFound)
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestOpenSearch(t *testing.T) {
+	withPagesDir(t)
+	withIndex(t, nil)
+	if _, err := storePage(PageMetadata{URL: "https://go.dev/doc/effective_go", Title: "Effective Go"}, "<p>body</p>", ""); err != nil {
+		t.Fatal(err)
+	}
+	suggestions.stale = true
+	router := newRouter()
+	get := func(path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://archive.local:8080"+path, nil))
+		return rec
+	}
+
+	rec := get("/opensearch.xml")
+	var description openSearchDescription
+	if err := xml.Unmarshal(rec.Body.Bytes(), &description); err != nil || rec.Header().Get("Content-Type") != openSearchType {
+		t.Fatalf("GET /opensearch.xml = %s %s: %v", rec.Header().Get("Content-Type"), rec.Body, err)
+	}
+	templates := make(map[string]string)
+	for _, u := range description.URLs {
+		templates[u.Type] = u.Template
+	}
+	if got, want := templates["text/html"], "http://archive.local:8080/text?q={searchTerms}"; got != want {
+		t.Errorf("search template = %q, want %q", got, want)
+	}
+
+	suggestURL := strings.Replace(templates[openSearchSuggestType], "{searchTerms}", "effec", 1)
+	rec = get(strings.TrimPrefix(suggestURL, "http://archive.local:8080"))
+	var completions []interface{}
+	json.Unmarshal(rec.Body.Bytes(), &completions)
+	want := []interface{}{"effec", []interface{}{"Effective Go"}, []interface{}{"https://go.dev/doc/effective_go"}, []interface{}{"https://go.dev/doc/effective_go"}}
+	if rec.Header().Get("Content-Type") != openSearchSuggestType || !reflect.DeepEqual(completions, want) {
+		t.Errorf("suggestions = %s %s, want %v", rec.Header().Get("Content-Type"), rec.Body, want)
+	}
+
+	rec = get("/?q=effective+go")
+	if rec.Code != http.StatusFound || rec.Header().Get("Location") != "/text?q=effective+go" {
+		t.Errorf("GET /?q= = %d %s, want a redirect to the text search page", rec.Code, rec.Header().Get("Location"))
+	}
+	if rec = get("/text"); !strings.Contains(rec.Body.String(), `rel="search" type="application/opensearchdescription+xml"`) {
+		t.Errorf("text search page doesn't link the OpenSearch description: %s", rec.Body)
+	}
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const defaultPageListLimit = 50
+
+type pageList struct {
+	Total int    `json:"total"`
+	Pages []Page `json:"pages"`
+}
+
+// handlePages lists stored pages, newest first, paginated with the limit and
+// offset query parameters. With starred=1, unread=1 or bookmarks=1 only
+// starred or unread pages or bookmarks are listed.
+func handlePages(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, ErrMethodNotAllowed, "Method not allowed")
+		return
+	}
+	pages, err := listPages()
+	if err != nil {
+		log.Printf("Error listing pages: %v", err)
+		writeError(w, http.StatusInternalServerError, ErrInternal, "Failed to list pages")
+		return
+	}
+	params := r.URL.Query()
+	starred := params.Get("starred") == "1" || params.Get("starred") == "true"
+	unread := params.Get("unread") == "1" || params.Get("unread") == "true"
+	changed := params.Get("changed") == "1" || params.Get("changed") == "true"
+	bookmarks := params.Get("bookmarks") == "1" || params.Get("bookmarks") == "true"
+	listed := pages[:0]
+	for _, page := range pages {
+		if !pageVisible(r, page.PageMetadata) || (starred && !page.Starred) || (unread && page.Read) || (bookmarks && !page.Bookmark) {
+			continue
+		}
+		if !changed || (page.DriftCheck != nil && page.DriftCheck.changed()) {
+			listed = append(listed, page)
+		}
+	}
+	pages = listed
+	sort.Slice(pages, func(i, j int) bool {
+		return pages[i].Timestamp.After(pages[j].Timestamp)
+	})
+
+	limit, err := strconv.Atoi(params.Get("limit"))
+	if err != nil || limit <= 0 {
+		limit = defaultPageListLimit
+	}
+	offset, _ := strconv.Atoi(params.Get("offset"))
+	if offset < 0 || offset > len(pages) {
+		offset = len(pages)
+	}
+	end := offset + limit
+	if end > len(pages) {
+		end = len(pages)
+	}
+
+	list := pageList{Total: len(pages), Pages: pages[offset:end]}
+	if list.Pages == nil {
+		list.Pages = []Page{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
+
+// handlePage returns, updates or deletes a single page.
+func handlePage(w http.ResponseWriter, r *http.Request) {
+	page, err := loadPage(r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusNotFound, ErrNotFound, "Page not found")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(page)
+	case http.MethodDelete:
+		if !requireAction(w, "delete") {
+			return
+		}
+		if err := trashPage(page); err != nil {
+			log.Printf("Error deleting page %s: %v", page.ID, err)
+			writeError(w, http.StatusInternalServerError, ErrInternal, "Failed to delete page")
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case http.MethodPatch:
+		updatePage(w, r, page)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, ErrMethodNotAllowed, "Method not allowed")
+	}
+}
+
+// updatePage changes whether a page is starred, read or private, its note,
+// its tags and when it expires, given as {"starred": true}, {"read": true},
+// {"private": true}, {"note": "..."}, {"tags": [...]}, {"autoTags": [...]}
+// or {"keepDays": 7}, where zero days keeps it for good. Tags given by hand
+// that the tagger also gave are no longer counted as its, and autoTags
+// corrects the tagger's, which it won't change again. Pages whose star,
+// read state, privacy, note or tags changed are indexed again so that
+// searches see it.
+func updatePage(w http.ResponseWriter, r *http.Request, page Page) {
+	var request struct {
+		Starred  *bool     `json:"starred"`
+		Read     *bool     `json:"read"`
+		Private  *bool     `json:"private"`
+		Note     *string   `json:"note"`
+		Tags     *[]string `json:"tags"`
+		AutoTags *[]string `json:"autoTags"`
+		KeepDays *int      `json:"keepDays"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		writeError(w, http.StatusBadRequest, ErrInvalidRequest, "Invalid request body")
+		return
+	}
+	if request.KeepDays != nil && !validKeepDays(*request.KeepDays) {
+		writeError(w, http.StatusBadRequest, ErrInvalidRequest, "keepDays must be between 0 and 3650")
+		return
+	}
+	if request.Note != nil && len(*request.Note) > maxAnnotationLength {
+		writeError(w, http.StatusBadRequest, ErrInvalidRequest, "Note is too long")
+		return
+	}
+
+	indexMu.Lock()
+	metadata, err := readMetadata(page.ID)
+	if err == nil {
+		if request.Starred != nil {
+			metadata.Starred = *request.Starred
+		}
+		if request.Read != nil {
+			metadata.Read = *request.Read
+		}
+		if request.Private != nil {
+			metadata.Private = *request.Private
+		}
+		if request.Note != nil {
+			metadata.Note = strings.TrimSpace(*request.Note)
+		}
+		if request.AutoTags != nil {
+			metadata.AutoTags = splitTags(strings.Join(*request.AutoTags, ","), ",")
+			if metadata.AutoTagged == nil {
+				now := time.Now().UTC()
+				metadata.AutoTagged = &now
+			}
+		}
+		if request.Tags != nil {
+			metadata.Tags = splitTags(strings.Join(*request.Tags, ","), ",")
+		}
+		if request.Tags != nil || request.AutoTags != nil {
+			var autoTags []string
+			for _, tag := range metadata.AutoTags {
+				if !hasTag(metadata.Tags, tag) {
+					autoTags = append(autoTags, tag)
+				}
+			}
+			metadata.AutoTags = autoTags
+		}
+		if request.Starred != nil || request.Read != nil || request.Private != nil || request.Note != nil || request.Tags != nil || request.AutoTags != nil {
+			metadata.Indexed = false
+		}
+		if request.KeepDays != nil {
+			metadata.ExpiresAt = expiryAfter(*request.KeepDays, time.Now())
+		}
+		err = writeMetadata(page.ID, metadata)
+	}
+	indexMu.Unlock()
+	if err != nil {
+		log.Printf("Error updating page %s: %v", page.ID, err)
+		writeError(w, http.StatusInternalServerError, ErrInternal, "Failed to update page")
+		return
+	}
+	if !metadata.Indexed {
+		indexExistingFiles(r.Context())
+		if updated, err := readMetadata(page.ID); err == nil {
+			metadata = updated
+		}
+	}
+	page.PageMetadata = metadata
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(page)
+}
+
+// deletePage removes a page's document from the index and its files from the
+// pages directory.
+func deletePage(page Page) error {
+	if err := removePage(page); err != nil {
+		return err
+	}
+	recordChange(changeDelete, page.ID, page.PageMetadata, nil)
+	publishPageEvent(eventPageDeleted, page.ID, page.PageMetadata)
+	return nil
+}
+
+// removePage deletes a page without recording it in the changelog or
+// telling webhooks.
+func removePage(page Page) error {
+	if err := index.Delete(page.ID); err != nil {
+		return err
+	}
+	for _, name := range page.files() {
+		if err := os.Remove(filepath.Join(pagesDir, name)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	if err := os.RemoveAll(assetsDir(page.ID)); err != nil {
+		return err
+	}
+	return os.Remove(metadataPath(page.ID))
+}
+
+// reindexAllPages marks every page as unindexed and runs an indexing pass,
+// rebuilding every document from its stored files. It holds indexMu
+// throughout so the watcher can't index pages while they are being reset.
+func reindexAllPages(ctx context.Context) error {
+	indexMu.Lock()
+	defer indexMu.Unlock()
+
+	pages, err := listPages()
+	if err != nil {
+		return err
+	}
+	reportProgress(ctx, 0, len(pages))
+	for _, page := range pages {
+		metadata, err := readMetadata(page.ID)
+		if err != nil {
+			continue // deleted since listing
+		}
+		metadata.Indexed = false
+		if err := writeMetadata(page.ID, metadata); err != nil {
+			return err
+		}
+	}
+	indexPendingFiles(ctx)
+	return ctx.Err()
+}
+
+// handleReindex starts a full reindex as a job.
+func handleReindex(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, ErrMethodNotAllowed, "Method not allowed")
+		return
+	}
+	job, err := startJob(jobReindex, requestUser(r), nil)
+	if err != nil {
+		log.Printf("Error starting reindex: %v", err)
+		writeError(w, http.StatusInternalServerError, ErrInternal, "Failed to start reindex")
+		return
+	}
+	writeJob(w, job)
+}
+
+// runReindexJob reindexes every page.
+func runReindexJob(ctx context.Context, job *Job) (interface{}, error) {
+	return nil, reindexAllPages(ctx)
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestHandlePages(t *testing.T) {
+	withPagesDir(t)
+	base := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 5; i++ {
+		metadata := PageMetadata{
+			URL:       fmt.Sprintf("https://example.com/%d", i),
+			Timestamp: base.Add(time.Duration(i) * time.Hour),
+		}
+		if err := writeMetadata(fmt.Sprintf("page_%d", i), metadata); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"", []string{"page_4", "page_3", "page_2", "page_1", "page_0"}},
+		{"?limit=2", []string{"page_4", "page_3"}},
+		{"?limit=2&offset=3", []string{"page_1", "page_0"}},
+		{"?offset=9", nil},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		handlePages(rec, httptest.NewRequest(http.MethodGet, "/pages"+tt.query, nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%q: status = %d, want 200", tt.query, rec.Code)
+		}
+		var list pageList
+		if err := json.Unmarshal(rec.Body.Bytes(), &list); err != nil {
+			t.Fatal(err)
+		}
+		if list.Total != 5 {
+			t.Errorf("%q: total = %d, want 5", tt.query, list.Total)
+		}
+		if list.Pages == nil {
+			t.Errorf("%q: pages encoded as null", tt.query)
+		}
+		if len(list.Pages) != len(tt.want) {
+			t.Errorf("%q: got %d pages, want %v", tt.query, len(list.Pages), tt.want)
+			continue
+		}
+		for i, page := range list.Pages {
+			if page.ID != tt.want[i] {
+				t.Errorf("%q: page %d = %s, want %s", tt.query, i, page.ID, tt.want[i])
+			}
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	handlePages(rec, httptest.NewRequest(http.MethodPost, "/pages", nil))
+	if rec.Code != http.StatusMethodNotAllowed || decodeError(t, rec).Code != ErrMethodNotAllowed {
+		t.Errorf("POST /pages: status = %d", rec.Code)
+	}
+}
+
+func TestHandlePage(t *testing.T) {
+	withPagesDir(t)
+	if err := writeMetadata("page_1", PageMetadata{URL: "https://example.com/", Title: "Example"}); err != nil {
+		t.Fatal(err)
+	}
+
+	request := func(method, id string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, "/pages/"+id, nil)
+		r.SetPathValue("id", id)
+		rec := httptest.NewRecorder()
+		handlePage(rec, r)
+		return rec
+	}
+
+	rec := request(http.MethodGet, "page_1")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", rec.Code)
+	}
+	var page Page
+	if err := json.Unmarshal(rec.Body.Bytes(), &page); err != nil {
+		t.Fatal(err)
+	}
+	if page.ID != "page_1" || page.Title != "Example" {
+		t.Errorf("page = %+v", page)
+	}
+
+	for _, id := range []string{"page_2", "..%2fconfig"} {
+		rec := request(http.MethodGet, id)
+		if rec.Code != http.StatusNotFound || decodeError(t, rec).Code != ErrNotFound {
+			t.Errorf("GET %s: status = %d, want 404", id, rec.Code)
+		}
+	}
+
+	if rec := request(http.MethodPut, "page_1"); rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("PUT: status = %d, want 405", rec.Code)
+	}
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+var (
+	unsafeIDChars = regexp.MustCompile(`[^a-zA-Z0-9]`)
+	validPageID   = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
+)
+
+// Page pairs a page's metadata with its ID, the metadata filename without the
+// .json extension. The ID doubles as the page's document ID in the index.
+type Page struct {
+	ID string `json:"id"`
+	PageMetadata
+}
+
+// newPageID mirrors the extension's generateFilename so that pages stored by
+// the daemon sort and look the same as pages downloaded by the extension.
+func newPageID(url string, t time.Time) string {
+	timestamp := strings.NewReplacer(":", "-", ".", "-").Replace(t.UTC().Format("2006-01-02T15:04:05.000Z"))
+	urlSafe := unsafeIDChars.ReplaceAllString(url, "_")
+	if len(urlSafe) > 100 {
+		urlSafe = urlSafe[:100]
+	}
+	return uniquePageID(fmt.Sprintf("%s_%s", timestamp, urlSafe))
+}
+
+// uniquePageID returns base, or base with a numeric suffix if a page with
+// that ID already exists or is in the trash.
+func uniquePageID(base string) string {
+	id := base
+	for i := 1; ; i++ {
+		if _, err := os.Stat(metadataPath(id)); os.IsNotExist(err) && !trashed(id) {
+			return id
+		}
+		id = fmt.Sprintf("%s_%d", base, i)
+	}
+}
+
+func metadataPath(id string) string {
+	return filepath.Join(pagesDir, id+".json")
+}
+
+// loadPage reads a single page by ID, rejecting IDs that could escape the
+// pages directory.
+func loadPage(id string) (Page, error) {
+	if !validPageID.MatchString(id) {
+		return Page{}, os.ErrNotExist
+	}
+	metadata, err := readMetadata(id)
+	return Page{ID: id, PageMetadata: metadata}, err
+}
+
+// listPages reads the metadata of every stored page, skipping unreadable
+// files.
+func listPages() ([]Page, error) {
+	files, err := ioutil.ReadDir(pagesDir)
+	if err != nil {
+		return nil, err
+	}
+
+	var pages []Page
+	for _, file := range files {
+		if file.IsDir() || !strings.HasSuffix(file.Name(), ".json") {
+			continue
+		}
+		id := strings.TrimSuffix(file.Name(), ".json")
+		metadata, err := readMetadata(id)
+		if err != nil {
+			log.Printf("Error reading metadata file %s: %v", metadataPath(id), err)
+			continue
+		}
+		pages = append(pages, Page{ID: id, PageMetadata: metadata})
+	}
+	return pages, nil
+}
+
+func readMetadata(id string) (PageMetadata, error) {
+	var metadata PageMetadata
+	data, err := ioutil.ReadFile(metadataPath(id))
+	if err != nil {
+		return metadata, err
+	}
+	err = json.Unmarshal(data, &metadata)
+	return metadata, err
+}
+
+// writeMetadata replaces the metadata file atomically so the indexer never
+// observes a partially written file, and records the change in the
+// changelog.
+func writeMetadata(id string, metadata PageMetadata) error {
+	data, err := json.MarshalIndent(metadata, "", "  ")
+	if err != nil {
+		return err
+	}
+	previous, readErr := readMetadata(id)
+	tmp := metadataPath(id) + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, metadataPath(id)); err != nil {
+		return err
+	}
+	if os.IsNotExist(readErr) {
+		recordChange(changeAdd, id, metadata, nil)
+	} else if fields := changedFields(previous, metadata); len(fields) > 0 {
+		recordChange(changeEdit, id, metadata, fields)
+	}
+	return nil
+}
+
+// reindexWith applies change to a page's metadata and indexes the page
+// again, for changes that searches should see.
+func reindexWith(ctx context.Context, id string, change func(*PageMetadata) error) error {
+	indexMu.Lock()
+	metadata, err := readMetadata(id)
+	if err == nil {
+		err = change(&metadata)
+	}
+	if err == nil {
+		metadata.Indexed = false
+		err = writeMetadata(id, metadata)
+	}
+	indexMu.Unlock()
+	if err != nil {
+		return err
+	}
+	indexExistingFiles(ctx)
+	return nil
+}
+
+// pageContent returns the text a page is indexed by: its content file's,
+// or a bookmark's note.
+func pageContent(metadata PageMetadata) ([]byte, error) {
+	if metadata.Bookmark {
+		return []byte(metadata.Note), nil
+	}
+	return readContentFile(contentPath(metadata))
+}
+
+// contentPath returns the file to index or serve for a page, preferring
+// markdown when it is available.
+func contentPath(metadata PageMetadata) string {
+	if metadata.HasMarkdown {
+		path := filepath.Join(pagesDir, metadata.MDFilename)
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+	}
+	return filepath.Join(pagesDir, metadata.HTMLFilename)
+}
+
+// storePage writes a new capture into the pages directory the same way the
+// extension does, leaving it unindexed for the watcher to pick up. The
+// content files are written before the metadata that references them.
+// Pages of URLs on the do-not-index list are refused.
+func storePage(metadata PageMetadata, html, markdown string) (string, error) {
+	if doNotIndex(metadata.URL) {
+		return "", errDoNotIndex
+	}
+	if metadata.Timestamp.IsZero() {
+		metadata.Timestamp = time.Now()
+	}
+	id := newPageID(metadata.URL, metadata.Timestamp)
+	html = storedHTML(html)
+
+	switch {
+	case markdown != "":
+		metadata.ContentHash = contentHash([]byte(markdown))
+	case html != "":
+		metadata.ContentHash = contentHash([]byte(html))
+	}
+
+	metadata.ContentEncoding = contentEncoding(contentFileName(id, ".html"))
+	if html != "" {
+		metadata.HTMLFilename = contentFileName(id, ".html")
+		if err := writeContentFile(filepath.Join(pagesDir, metadata.HTMLFilename), []byte(html)); err != nil {
+			return "", err
+		}
+	}
+	if markdown != "" {
+		metadata.MDFilename = contentFileName(id, ".md")
+		metadata.HasMarkdown = true
+		if err := writeContentFile(filepath.Join(pagesDir, metadata.MDFilename), []byte(markdown)); err != nil {
+			return "", err
+		}
+	}
+	metadata.Indexed = false
+	metadata.ExtractorVersion = extractorVersion
+
+	if err := writeMetadata(id, metadata); err != nil {
+		return "", err
+	}
+	publishPageEvent(eventPageCaptured, id, metadata)
+	return id, nil
+}
+
+// files returns the names of a page's stored files in the pages directory,
+// besides its metadata.
+func (m PageMetadata) files() []string {
+	var names []string
+	for _, name := range []string{m.HTMLFilename, m.MDFilename, m.ScreenshotFilename, m.PDFFilename, m.ThumbnailFilename} {
+		if name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// pageContentHash returns the SHA-256 of a page's preferred content file,
+// using the hash recorded in its metadata when present.
+func pageContentHash(metadata PageMetadata) (string, error) {
+	if metadata.ContentHash != "" {
+		return metadata.ContentHash, nil
+	}
+	data, err := readContentFile(contentPath(metadata))
+	if err != nil {
+		return "", err
+	}
+	return contentHash(data), nil
+}
+
+func contentHash(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,806 @@
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf16"
+
+	"github.com/blevesearch/bleve/analysis"
+	"github.com/blevesearch/bleve/search"
+)
+
+const pdfMediaType = "application/pdf"
+
+// pdfMaxStreamBytes bounds one decompressed stream, so a small file can't
+// expand without limit.
+const pdfMaxStreamBytes = 64 << 20
+
+var (
+	errNotPDF = errors.New("not a PDF file")
+
+	pdfStreamRe      = regexp.MustCompile(`stream\r?\n`)
+	pdfFilterRe      = regexp.MustCompile(`/Filter\s*(\[[^\]]*\]|/[A-Za-z0-9]+)`)
+	pdfNameRe        = regexp.MustCompile(`/([A-Za-z0-9]+)`)
+	pdfTitleRe       = regexp.MustCompile(`/Title\s*([(<])`)
+	cmapCharRe       = regexp.MustCompile(`(?s)beginbfchar(.*?)endbfchar`)
+	cmapRangeRe      = regexp.MustCompile(`(?s)beginbfrange(.*?)endbfrange`)
+	cmapPairRe       = regexp.MustCompile(`<([0-9A-Fa-f]+)>\s*<([0-9A-Fa-f]+)>`)
+	cmapRangeEntryRe = regexp.MustCompile(`<([0-9A-Fa-f]+)>\s*<([0-9A-Fa-f]+)>\s*(<[0-9A-Fa-f]+>|\[[^\]]*\])`)
+	hexStringRe      = regexp.MustCompile(`<([0-9A-Fa-f]+)>`)
+	pdfObjRe         = regexp.MustCompile(`(\d+)\s+\d+\s+obj\b`)
+	pdfObjNumRe      = regexp.MustCompile(`(\d+)\s+\d+\s+$`)
+	pdfRefRe         = regexp.MustCompile(`(\d+)\s+\d+\s+R\b`)
+	pdfTypeRe        = regexp.MustCompile(`/Type\s*/(Pages|Page)\b`)
+	pdfKidsRe        = regexp.MustCompile(`/Kids\s*\[([^\]]*)\]`)
+	pdfContentsRe    = regexp.MustCompile(`/Contents\s*(\[[^\]]*\]|\d+\s+\d+\s+R)`)
+	pdfResourcesRe   = regexp.MustCompile(`/Resources\s*(\d+)\s+\d+\s+R`)
+	pdfXObjectRe     = regexp.MustCompile(`/XObject\s*(<<[^>]*>>|\d+\s+\d+\s+R)`)
+	pdfFirstRe       = regexp.MustCompile(`/First\s+(\d+)`)
+	pdfCountRe       = regexp.MustCompile(`/N\s+(\d+)`)
+)
+
+// pdfPageBreak separates the text of a PDF's pages in its markdown, as
+// pdftotext does.
+const pdfPageBreak = "\f"
+
+// pdfContent is what extractPDF finds in a PDF.
+type pdfContent struct {
+	Title string
+	Text  string
+	// Pages is how many pages Text is split into by pdfPageBreak, 0 when
+	// the page tree couldn't be read.
+	Pages int
+	// Encrypted PDFs are stored without text
+	Encrypted bool
+}
+
+// extractPDF pulls the title and text out of a PDF for indexing. It reads
+// the text operators of uncompressed and Flate-compressed content streams,
+// mapping codes through the fonts' ToUnicode CMaps, which covers what most
+// generators produce. Layout is approximated with line breaks where the
+// text moves to a new line, and pages are separated by pdfPageBreak.
+func extractPDF(data []byte) (pdfContent, error) {
+	head := data
+	if len(head) > 1024 {
+		head = head[:1024]
+	}
+	if !bytes.Contains(head, []byte("%PDF-")) {
+		return pdfContent{}, errNotPDF
+	}
+	var content pdfContent
+	if bytes.Contains(data, []byte("/Encrypt")) {
+		content.Encrypted = true
+		return content, nil
+	}
+
+	streams := pdfStreams(data)
+	cmap := &pdfCMap{codes: make(map[string]string)}
+	for _, stream := range streams {
+		if bytes.Contains(stream.body, []byte("begincmap")) {
+			cmap.parse(string(stream.body))
+		}
+	}
+	isText := func(stream pdfStreamData) bool {
+		return !bytes.Contains(stream.body, []byte("begincmap")) && bytes.Contains(stream.body, []byte("BT"))
+	}
+
+	if pages := pdfPages(pdfObjects(data, streams)); len(pages) > 0 {
+		byNum := make(map[int]pdfStreamData)
+		for _, stream := range streams {
+			byNum[stream.num] = stream
+		}
+		texts := make([]string, len(pages))
+		shown := make(map[int]bool)
+		for i, refs := range pages {
+			for _, ref := range refs {
+				if stream, ok := byNum[ref]; ok && isText(stream) && !shown[ref] {
+					texts[i] += pdfContentText(stream.body, cmap) + "\n\n"
+					shown[ref] = true
+				}
+			}
+		}
+		// Text outside the page tree, such as in annotations, goes last
+		for _, stream := range streams {
+			if isText(stream) && !shown[stream.num] {
+				texts[len(texts)-1] += pdfContentText(stream.body, cmap) + "\n\n"
+			}
+		}
+		for i, text := range texts {
+			texts[i] = strings.TrimSpace(blankLinesRe.ReplaceAllString(text, "\n\n"))
+		}
+		// Empty pages keep their place, so page numbers stay right
+		content.Text = strings.Join(texts, "\n\n"+pdfPageBreak)
+		if strings.TrimSpace(content.Text) == "" {
+			content.Text = ""
+		}
+		content.Pages = len(pages)
+	} else {
+		var text strings.Builder
+		for _, stream := range streams {
+			if isText(stream) {
+				text.WriteString(pdfContentText(stream.body, cmap))
+				text.WriteString("\n\n")
+			}
+		}
+		content.Text = strings.TrimSpace(blankLinesRe.ReplaceAllString(text.String(), "\n\n"))
+	}
+
+	// The document info may be in a compressed object stream
+	content.Title = pdfTitle(data)
+	for _, stream := range streams {
+		if content.Title != "" {
+			break
+		}
+		content.Title = pdfTitle(stream.body)
+	}
+	return content, nil
+}
+
+// pdfStreamData is a decoded stream of a PDF, with the number and
+// dictionary of its object.
+type pdfStreamData struct {
+	num  int
+	dict string
+	body []byte
+}
+
+// pdfStreams returns the decoded contents of a PDF's streams, skipping
+// images and streams in encodings it can't decode.
+func pdfStreams(data []byte) []pdfStreamData {
+	var streams []pdfStreamData
+	for _, loc := range pdfStreamRe.FindAllIndex(data, -1) {
+		if loc[0] >= 3 && string(data[loc[0]-3:loc[0]]) == "end" {
+			continue
+		}
+		end := bytes.Index(data[loc[1]:], []byte("endstream"))
+		if end < 0 {
+			break
+		}
+		body := data[loc[1] : loc[1]+end]
+		var dict string
+		num := 0
+		if start := bytes.LastIndex(data[:loc[0]], []byte("obj")); start >= 0 {
+			dict = string(data[start:loc[0]])
+			if m := pdfObjNumRe.FindSubmatch(data[max(0, start-24):start]); m != nil {
+				num, _ = strconv.Atoi(string(m[1]))
+			}
+		}
+		if strings.Contains(dict, "/Image") || strings.Contains(dict, "/XRef") {
+			continue
+		}
+
+		flate := false
+		if m := pdfFilterRe.FindStringSubmatch(dict); m != nil {
+			supported := true
+			for _, name := range pdfNameRe.FindAllStringSubmatch(m[1], -1) {
+				supported = supported && (name[1] == "FlateDecode" || name[1] == "Fl")
+			}
+			if !supported {
+				continue
+			}
+			flate = true
+		}
+		if flate {
+			r, err := zlib.NewReader(bytes.NewReader(body))
+			if err != nil {
+				continue
+			}
+			// Keep what decodes from a damaged stream
+			body, _ = ioutil.ReadAll(io.LimitReader(r, pdfMaxStreamBytes))
+			r.Close()
+		}
+		streams = append(streams, pdfStreamData{num: num, dict: dict, body: body})
+	}
+	return streams
+}
+
+// pdfObjects returns the dictionaries of a PDF's objects by number,
+// including those packed into object streams.
+func pdfObjects(data []byte, streams []pdfStreamData) map[int]string {
+	objects := make(map[int]string)
+	for _, m := range pdfObjRe.FindAllSubmatchIndex(data, -1) {
+		num, _ := strconv.Atoi(string(data[m[2]:m[3]]))
+		body := data[m[1]:]
+		if end := bytes.Index(body, []byte("endobj")); end >= 0 {
+			body = body[:end]
+		}
+		if i := bytes.Index(body, []byte("stream")); i >= 0 {
+			body = body[:i]
+		}
+		objects[num] = string(body)
+	}
+	for _, stream := range streams {
+		if !strings.Contains(stream.dict, "/ObjStm") {
+			continue
+		}
+		// The stream starts with pairs of object numbers and offsets
+		// from First
+		var first, count int
+		if m := pdfFirstRe.FindStringSubmatch(stream.dict); m != nil {
+			first, _ = strconv.Atoi(m[1])
+		}
+		if m := pdfCountRe.FindStringSubmatch(stream.dict); m != nil {
+			count, _ = strconv.Atoi(m[1])
+		}
+		if first <= 0 || first > len(stream.body) {
+			continue
+		}
+		header := strings.Fields(string(stream.body[:first]))
+		for i := 0; i+1 < len(header) && i/2 < count; i += 2 {
+			num, err := strconv.Atoi(header[i])
+			offset, err2 := strconv.Atoi(header[i+1])
+			if err != nil || err2 != nil || first+offset > len(stream.body) {
+				break
+			}
+			end := len(stream.body)
+			if i+3 < len(header) {
+				if next, err := strconv.Atoi(header[i+3]); err == nil && next >= offset && first+next <= end {
+					end = first + next
+				}
+			}
+			objects[num] = string(stream.body[first+offset : end])
+		}
+	}
+	return objects
+}
+
+// pdfRefs returns the object numbers of the references in s.
+func pdfRefs(s string) []int {
+	var refs []int
+	for _, m := range pdfRefRe.FindAllStringSubmatch(s, -1) {
+		num, _ := strconv.Atoi(m[1])
+		refs = append(refs, num)
+	}
+	return refs
+}
+
+// pdfPages walks a PDF's page tree, returning for each page in order the
+// objects whose text it shows: its content streams and the form XObjects
+// among its resources. It returns nil when there is no page tree.
+func pdfPages(objects map[int]string) [][]int {
+	var pages [][]int
+	visited := make(map[int]bool)
+	var walk func(num int, resources string)
+	walk = func(num int, resources string) {
+		dict, ok := objects[num]
+		if !ok || visited[num] {
+			return
+		}
+		visited[num] = true
+		// Resources are inherited from the tree above unless overridden
+		if strings.Contains(dict, "/Resources") {
+			resources = dict
+			if m := pdfResourcesRe.FindStringSubmatch(dict); m != nil {
+				ref, _ := strconv.Atoi(m[1])
+				resources = objects[ref]
+			}
+		}
+		m := pdfTypeRe.FindStringSubmatch(dict)
+		switch {
+		case m == nil:
+		case m[1] == "Pages":
+			if kids := pdfKidsRe.FindStringSubmatch(dict); kids != nil {
+				for _, kid := range pdfRefs(kids[1]) {
+					walk(kid, resources)
+				}
+			}
+		default:
+			var refs []int
+			if contents := pdfContentsRe.FindStringSubmatch(dict); contents != nil {
+				refs = pdfRefs(contents[1])
+			}
+			if xobjects := pdfXObjectRe.FindStringSubmatch(resources); xobjects != nil {
+				names := xobjects[1]
+				if !strings.HasPrefix(names, "<<") {
+					names = objects[pdfRefs(names)[0]]
+				}
+				refs = append(refs, pdfRefs(names)...)
+			}
+			pages = append(pages, refs)
+		}
+	}
+
+	// Roots are the page tree nodes without a parent
+	var roots []int
+	for num, dict := range objects {
+		if m := pdfTypeRe.FindStringSubmatch(dict); m != nil && m[1] == "Pages" && !strings.Contains(dict, "/Parent") {
+			roots = append(roots, num)
+		}
+	}
+	sort.Ints(roots)
+	for _, root := range roots {
+		walk(root, "")
+	}
+	return pages
+}
+
+// pdfCMap maps character codes to text. The CMaps of all fonts are merged,
+// which is right for the common case of a document whose fonts don't
+// disagree about codes they share.
+type pdfCMap struct {
+	codes map[string]string
+	width int
+}
+
+func (m *pdfCMap) parse(cmap string) {
+	for _, section := range cmapCharRe.FindAllStringSubmatch(cmap, -1) {
+		for _, pair := range cmapPairRe.FindAllStringSubmatch(section[1], -1) {
+			src, _ := hex.DecodeString(pair[1])
+			m.add(src, utf16Hex(pair[2]))
+		}
+	}
+	for _, section := range cmapRangeRe.FindAllStringSubmatch(cmap, -1) {
+		for _, entry := range cmapRangeEntryRe.FindAllStringSubmatch(section[1], -1) {
+			lo, err1 := strconv.ParseUint(entry[1], 16, 32)
+			hi, err2 := strconv.ParseUint(entry[2], 16, 32)
+			if err1 != nil || err2 != nil || hi < lo || hi-lo > 0xFFFF {
+				continue
+			}
+			width := len(entry[1]) / 2
+			code := func(n uint64) []byte {
+				b := make([]byte, width)
+				for i := width - 1; i >= 0; i-- {
+					b[i] = byte(n)
+					n >>= 8
+				}
+				return b
+			}
+			if strings.HasPrefix(entry[3], "[") {
+				for i, dst := range hexStringRe.FindAllStringSubmatch(entry[3], -1) {
+					if lo+uint64(i) <= hi {
+						m.add(code(lo+uint64(i)), utf16Hex(dst[1]))
+					}
+				}
+				continue
+			}
+			units := utf16Units(strings.Trim(entry[3], "<>"))
+			if len(units) == 0 {
+				continue
+			}
+			for n := lo; n <= hi; n++ {
+				dst := append([]uint16(nil), units...)
+				dst[len(dst)-1] += uint16(n - lo)
+				m.add(code(n), string(utf16.Decode(dst)))
+			}
+		}
+	}
+}
+
+func (m *pdfCMap) add(code []byte, text string) {
+	if len(code) == 0 {
+		return
+	}
+	if m.width == 0 {
+		m.width = len(code)
+	}
+	m.codes[string(code)] = text
+}
+
+// decode maps a string's codes through the CMap when every code is in it,
+// and otherwise reads it as single-byte text.
+func (m *pdfCMap) decode(s []byte) string {
+	if m.width > 0 && len(s)%m.width == 0 {
+		var b strings.Builder
+		mapped := true
+		for i := 0; i < len(s) && mapped; i += m.width {
+			var text string
+			text, mapped = m.codes[string(s[i:i+m.width])]
+			b.WriteString(text)
+		}
+		if mapped {
+			return b.String()
+		}
+	}
+	return pdfByteText(s)
+}
+
+// pdfByteText reads single-byte text, which for the printable range is
+// the same in PDFDocEncoding, WinAnsiEncoding and Latin-1.
+func pdfByteText(s []byte) string {
+	runes := make([]rune, 0, len(s))
+	for _, c := range s {
+		if c >= 0x20 {
+			runes = append(runes, rune(c))
+		}
+	}
+	return string(runes)
+}
+
+func utf16Units(hexText string) []uint16 {
+	b, err := hex.DecodeString(hexText)
+	if err != nil {
+		return nil
+	}
+	units := make([]uint16, len(b)/2)
+	for i := range units {
+		units[i] = uint16(b[2*i])<<8 | uint16(b[2*i+1])
+	}
+	return units
+}
+
+func utf16Hex(hexText string) string {
+	return string(utf16.Decode(utf16Units(hexText)))
+}
+
+// pdfTextString decodes a string from the document info, which is UTF-16
+// when it starts with a byte order mark.
+func pdfTextString(s []byte) string {
+	if len(s) >= 2 && s[0] == 0xFE && s[1] == 0xFF {
+		return utf16Hex(hex.EncodeToString(s[2:]))
+	}
+	return pdfByteText(s)
+}
+
+// pdfTitle returns the /Title entry of the document info in data, if any.
+func pdfTitle(data []byte) string {
+	m := pdfTitleRe.FindSubmatchIndex(data)
+	if m == nil {
+		return ""
+	}
+	rest := data[m[2]:]
+	var raw []byte
+	if rest[0] == '(' {
+		raw, _ = pdfLiteral(rest)
+	} else if end := bytes.IndexByte(rest, '>'); end > 0 {
+		raw, _ = hex.DecodeString(string(bytes.Join(bytes.Fields(rest[1:end]), nil)))
+	}
+	return strings.TrimSpace(pdfTextString(raw))
+}
+
+// pdfLiteral reads a literal string starting at b[0] == '(', returning its
+// bytes and the length read.
+func pdfLiteral(b []byte) ([]byte, int) {
+	var out []byte
+	depth := 1
+	i := 1
+	for ; i < len(b) && depth > 0; i++ {
+		c := b[i]
+		switch c {
+		case '\\':
+			i++
+			if i >= len(b) {
+				break
+			}
+			switch e := b[i]; e {
+			case 'n':
+				out = append(out, '\n')
+			case 'r':
+				out = append(out, '\r')
+			case 't':
+				out = append(out, '\t')
+			case 'b', 'f':
+			case '\r':
+				if i+1 < len(b) && b[i+1] == '\n' {
+					i++
+				}
+			case '\n':
+			default:
+				if e >= '0' && e <= '7' {
+					n := 0
+					for j := 0; j < 3 && i < len(b) && b[i] >= '0' && b[i] <= '7'; j++ {
+						n = n*8 + int(b[i]-'0')
+						i++
+					}
+					i--
+					out = append(out, byte(n))
+				} else {
+					out = append(out, e)
+				}
+			}
+		case '(':
+			depth++
+			out = append(out, c)
+		case ')':
+			depth--
+			if depth > 0 {
+				out = append(out, c)
+			}
+		default:
+			out = append(out, c)
+		}
+	}
+	return out, i
+}
+
+// pdfToken is an operand in a content stream: a string, a number or an
+// array of them.
+type pdfToken struct {
+	str    []byte
+	isStr  bool
+	num    float64
+	array  []pdfToken
+	isOpen bool // the start of an array
+}
+
+func isPDFDelimiter(c byte) bool {
+	return strings.IndexByte(" \t\r\n\f\x00()<>[]{}/%", c) >= 0
+}
+
+// pdfContentText returns the text a content stream shows.
+func pdfContentText(content []byte, cmap *pdfCMap) string {
+	var out strings.Builder
+	var operands []pdfToken
+	show := func(t pdfToken) {
+		if t.isStr {
+			out.WriteString(cmap.decode(t.str))
+		}
+	}
+	for i := 0; i < len(content); {
+		c := content[i]
+		switch {
+		case strings.IndexByte(" \t\r\n\f\x00", c) >= 0:
+			i++
+		case c == '%':
+			for i < len(content) && content[i] != '\n' && content[i] != '\r' {
+				i++
+			}
+		case c == '(':
+			s, n := pdfLiteral(content[i:])
+			operands = append(operands, pdfToken{str: s, isStr: true})
+			i += n
+		case c == '<' && i+1 < len(content) && content[i+1] == '<', c == '>' && i+1 < len(content) && content[i+1] == '>':
+			i += 2
+		case c == '<':
+			end := bytes.IndexByte(content[i:], '>')
+			if end < 0 {
+				return out.String()
+			}
+			s, _ := hex.DecodeString(string(bytes.Join(bytes.Fields(content[i+1:i+end]), nil)))
+			operands = append(operands, pdfToken{str: s, isStr: true})
+			i += end + 1
+		case c == '[':
+			operands = append(operands, pdfToken{isOpen: true})
+			i++
+		case c == ']':
+			start := len(operands) - 1
+			for start >= 0 && !operands[start].isOpen {
+				start--
+			}
+			if start < 0 {
+				i++
+				continue
+			}
+			array := append([]pdfToken(nil), operands[start+1:]...)
+			operands = append(operands[:start], pdfToken{array: array})
+			i++
+		case c == '/', c == '{', c == '}', c == ')', c == '>':
+			i++
+			for i < len(content) && !isPDFDelimiter(content[i]) {
+				i++
+			}
+			if c == '/' {
+				operands = append(operands, pdfToken{})
+			}
+		default:
+			start := i
+			for i < len(content) && !isPDFDelimiter(content[i]) {
+				i++
+			}
+			word := string(content[start:i])
+			if n, err := strconv.ParseFloat(word, 64); err == nil {
+				operands = append(operands, pdfToken{num: n})
+				continue
+			}
+			switch word {
+			case "Tj":
+				if len(operands) > 0 {
+					show(operands[len(operands)-1])
+				}
+			case "'", `"`:
+				out.WriteString("\n")
+				if len(operands) > 0 {
+					show(operands[len(operands)-1])
+				}
+			case "TJ":
+				if len(operands) > 0 {
+					for _, t := range operands[len(operands)-1].array {
+						if t.isStr {
+							show(t)
+						} else if t.num < -200 {
+							// A gap wider than a fifth of an em
+							out.WriteString(" ")
+						}
+					}
+				}
+			case "Td", "TD":
+				if len(operands) >= 2 && operands[len(operands)-1].num != 0 {
+					out.WriteString("\n")
+				} else {
+					out.WriteString(" ")
+				}
+			case "T*", "Tm", "ET":
+				out.WriteString("\n")
+			case "ID":
+				// Skip inline image data
+				end := bytes.Index(content[i:], []byte("EI"))
+				if end < 0 {
+					return out.String()
+				}
+				i += end + 2
+			}
+			operands = operands[:0]
+		}
+	}
+
+	lines := strings.Split(out.String(), "\n")
+	for i, line := range lines {
+		lines[i] = strings.Join(strings.Fields(line), " ")
+	}
+	return strings.Join(lines, "\n")
+}
+
+// pdfMarkdown is the markdown stored for a PDF: its title, URL and text.
+func pdfMarkdown(title, pageURL, text string) string {
+	return "# " + title + "\n\nURL: " + pageURL + "\n\n" + text + "\n"
+}
+
+// contentAnalyzer is the analyzer the content field is indexed with, for
+// finding the terms a search matched in text that isn't indexed.
+func contentAnalyzer() *analysis.Analyzer {
+	if index == nil {
+		return nil
+	}
+	return index.Mapping().AnalyzerNamed(index.Mapping().AnalyzerNameForPath("content"))
+}
+
+// pdfPageTexts splits a PDF page's markdown into the text of each PDF page.
+func pdfPageTexts(metadata PageMetadata) ([]string, error) {
+	markdown, err := readContentFile(filepath.Join(pagesDir, metadata.MDFilename))
+	if err != nil {
+		return nil, err
+	}
+	return strings.Split(string(markdown), pdfPageBreak), nil
+}
+
+// pdfHitPages returns the pages of a PDF page's markdown that have any of
+// the terms a search matched in its content, or nil when the page isn't a
+// PDF split into pages.
+func pdfHitPages(id string, terms search.TermLocationMap) []int {
+	metadata, err := readMetadata(id)
+	if err != nil || metadata.PDFPages == 0 || metadata.MDFilename == "" {
+		return nil
+	}
+	texts, err := pdfPageTexts(metadata)
+	if err != nil {
+		log.Printf("Error reading %s for page numbers: %v", id, err)
+		return nil
+	}
+	// Pages are analyzed as the content was indexed, so the terms match
+	analyzer := contentAnalyzer()
+	if analyzer == nil {
+		return nil
+	}
+	var pages []int
+	for i, text := range texts {
+		for _, token := range analyzer.Analyze([]byte(text)) {
+			if _, ok := terms[string(token.Term)]; ok {
+				pages = append(pages, i+1)
+				break
+			}
+		}
+	}
+	return pages
+}
+
+// archivePDF stores a PDF as a page whose markdown is its extracted text,
+// returning the new page ID. Name is the file name of an uploaded PDF.
+func archivePDF(ctx context.Context, pdf []byte, pageURL, name, owner string) (string, error) {
+	_, span := startSpan(ctx, "capture.extract")
+	defer span.End()
+	span.SetAttribute("capture.type", "pdf")
+
+	content, err := extractPDF(pdf)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", errUnsupportedContent, err)
+	}
+	if content.Encrypted {
+		log.Printf("PDF %s is encrypted; storing it without text", pageURL)
+	}
+	title := content.Title
+	if title == "" {
+		title = name
+	}
+	if title == "" {
+		if u, err := url.Parse(pageURL); err == nil && path.Base(u.Path) != "/" && path.Base(u.Path) != "." {
+			title = path.Base(u.Path)
+		} else {
+			title = pageURL
+		}
+	}
+	markdown := pdfMarkdown(title, pageURL, content.Text)
+
+	knownHashes, err := knownContentHashes()
+	if err != nil {
+		return "", err
+	}
+	if knownHashes[contentHash([]byte(markdown))] {
+		span.SetAttribute("capture.duplicate", true)
+		return "", errDuplicate
+	}
+
+	source := "archiver"
+	if name != "" {
+		source = "upload"
+	}
+	metadata := PageMetadata{
+		URL:       pageURL,
+		Title:     title,
+		Timestamp: time.Now(),
+		Source:    source,
+		Owner:     owner,
+		PDFPages:  content.Pages,
+	}
+	id, err := storePage(metadata, "", markdown)
+	if err != nil {
+		span.RecordError(err)
+		return "", err
+	}
+	span.SetAttribute("page.id", id)
+	err = attachPageFile(id, id+".pdf", pdf, func(m *PageMetadata, name string) {
+		m.PDFFilename = name
+	})
+	return id, err
+}
+
+// handlePDFUpload archives a PDF sent as the body of a POST to /archive,
+// named by the filename query parameter.
+func handlePDFUpload(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxCaptureBytes)
+	pdf, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		if isTooLarge(err) {
+			writeError(w, http.StatusRequestEntityTooLarge, ErrCaptureTooLarge, errCaptureTooLarge.Error())
+			return
+		}
+		writeError(w, http.StatusBadRequest, ErrInvalidRequest, "Failed to read request body")
+		return
+	}
+	name := path.Base(r.URL.Query().Get("filename"))
+	if name == "." || name == "/" {
+		name = "document.pdf"
+	}
+	keepDays, ok := keepDaysParam(r)
+	if !ok {
+		writeError(w, http.StatusBadRequest, ErrInvalidRequest, "keepDays must be between 0 and 3650")
+		return
+	}
+
+	if !allowCapture(w, r) {
+		return
+	}
+	ctx, span := startRequestSpan(r, "capture")
+	defer span.End()
+	id, err := archivePDF(ctx, pdf, "file:///"+url.PathEscape(name), name, requestUser(r))
+	if err != nil {
+		span.RecordError(err)
+		log.Printf("Error archiving uploaded PDF %s: %v", name, err)
+		releaseCapture(r)
+		writeArchiveError(w, err)
+		return
+	}
+	if err := setExpiry(id, keepDays); err != nil {
+		log.Printf("Error setting the expiry of %s: %v", id, err)
+	}
+	writeArchived(ctx, w, id)
+}
+
+// handlePagePDF serves the original of a PDF page.
+func handlePagePDF(w http.ResponseWriter, r *http.Request) {
+	servePageFile(w, r, pdfMediaType, func(page Page) string { return page.PDFFilename })
+}
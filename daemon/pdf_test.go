@@ -0,0 +1,238 @@
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// testPDF assembles a PDF from object bodies. The extractor doesn't need a
+// cross-reference table, so there isn't one.
+func testPDF(objects ...string) []byte {
+	var b bytes.Buffer
+	b.WriteString("%PDF-1.4\n")
+	for i, object := range objects {
+		fmt.Fprintf(&b, "%d 0 obj\n%s\nendobj\n", i+1, object)
+	}
+	b.WriteString("trailer\n<< /Root 1 0 R >>\n%%EOF\n")
+	return b.Bytes()
+}
+
+func pdfStream(dict, content string, compress bool) string {
+	if compress {
+		var b bytes.Buffer
+		w := zlib.NewWriter(&b)
+		w.Write([]byte(content))
+		w.Close()
+		content = b.String()
+		dict += " /Filter /FlateDecode"
+	}
+	return fmt.Sprintf("<< %s /Length %d >>\nstream\n%s\nendstream", dict, len(content), content)
+}
+
+func TestExtractPDF(t *testing.T) {
+	cmap := `/CIDInit /ProcSet findresource begin 12 dict begin begincmap
+2 beginbfchar
+<0001> <0048>
+<0004> <D83DDE00>
+endbfchar
+1 beginbfrange
+<0002> <0003> <0069>
+endbfrange
+endcmap`
+
+	tests := []struct {
+		name      string
+		pdf       []byte
+		title     string
+		text      string
+		encrypted bool
+		wantErr   bool
+	}{
+		{"lines", testPDF(pdfStream("", "BT /F1 12 Tf 72 720 Td (Hello World) Tj 0 -14 Td (Second   line) Tj ET", true)),
+			"", "Hello World\nSecond line", false, false},
+		{"kerning", testPDF(pdfStream("", "BT [(Hel) 20 (lo) -300 (there)] TJ ET", true)),
+			"", "Hello there", false, false},
+		{"escapes", testPDF(pdfStream("", `BT (a \(b\) \101\
+c) Tj T* (next) ' ET`, false)),
+			"", "a (b) Ac\n\nnext", false, false},
+		{"to unicode", testPDF(pdfStream("", cmap, true), pdfStream("", "BT <0001 0002> Tj <0003 0004> Tj ET", true)),
+			"", "Hij\U0001F600", false, false},
+		{"unmapped codes", testPDF(pdfStream("", cmap, true), pdfStream("", "BT (Plain) Tj ET", true)),
+			"", "Plain", false, false},
+		{"title", testPDF("<< /Title (A \\(draft\\) paper) /Author (Me) >>", pdfStream("", "BT (Body) Tj ET", true)),
+			"A (draft) paper", "Body", false, false},
+		{"utf-16 title", testPDF("<< /Title <FEFF 0047 006F> >>"),
+			"Go", "", false, false},
+		{"inline image", testPDF(pdfStream("", "BI /W 2 /H 2 ID \x00BT(x)Tj\xff EI BT (After) Tj ET", true)),
+			"", "After", false, false},
+		{"images skipped", testPDF(pdfStream("/Subtype /Image /Filter /DCTDecode", "BT (jpeg) Tj ET", false), pdfStream("", "BT (Text) Tj ET", false)),
+			"", "Text", false, false},
+		{"encrypted", testPDF("<< /Title (Secret) >>", "<< /Encrypt 5 0 R >>"),
+			"", "", true, false},
+		{"not a pdf", []byte("<html>nope</html>"), "", "", false, true},
+	}
+	for _, tt := range tests {
+		got, err := extractPDF(tt.pdf)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: extractPDF() error = %v, wantErr %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if got.Title != tt.title || got.Text != tt.text || got.Encrypted != tt.encrypted {
+			t.Errorf("%s: extractPDF() = %+v, want title %q text %q encrypted %v", tt.name, got, tt.title, tt.text, tt.encrypted)
+		}
+	}
+}
+
+func TestPDFUpload(t *testing.T) {
+	withPagesDir(t)
+	withIndex(t, nil)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/archive", handleArchive)
+	mux.HandleFunc("/pages/{id}/pdf", handlePagePDF)
+	pdf := testPDF("<< /Title (Quarterly report) >>", pdfStream("", "BT (Revenue grew) Tj ET", true))
+
+	tests := []struct {
+		name   string
+		body   []byte
+		status int
+	}{
+		{"upload", pdf, http.StatusCreated},
+		{"duplicate", pdf, http.StatusConflict},
+		{"not a pdf", []byte("hello"), http.StatusUnsupportedMediaType},
+	}
+	var page Page
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodPost, "/archive?filename=report.pdf", bytes.NewReader(tt.body))
+		req.Header.Set("Content-Type", "application/pdf")
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		if rec.Code != tt.status {
+			t.Fatalf("%s: status = %d %s, want %d", tt.name, rec.Code, rec.Body, tt.status)
+		}
+		if tt.status == http.StatusCreated {
+			json.Unmarshal(rec.Body.Bytes(), &page)
+		}
+	}
+
+	if page.Title != "Quarterly report" || page.URL != "file:///report.pdf" || page.PDFFilename != page.ID+".pdf" || page.Source != "upload" {
+		t.Errorf("archived page = %+v", page)
+	}
+	markdown, _ := ioutil.ReadFile(filepath.Join(pagesDir, page.MDFilename))
+	if !strings.Contains(string(markdown), "Revenue grew") {
+		t.Errorf("markdown = %q, want the PDF's text", markdown)
+	}
+	if count, _ := index.DocCount(); count != 1 {
+		t.Errorf("index has %d documents, want the PDF's", count)
+	}
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/pages/"+page.ID+"/pdf", nil))
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "application/pdf" || !bytes.Equal(rec.Body.Bytes(), pdf) {
+		t.Errorf("GET pdf = %d %s", rec.Code, rec.Header().Get("Content-Type"))
+	}
+}
+
+// objectStream packs objects, numbered from num, into an object stream.
+func objectStream(num int, objects ...string) string {
+	var header, body strings.Builder
+	for i, object := range objects {
+		fmt.Fprintf(&header, "%d %d ", num+i, body.Len())
+		body.WriteString(object + "\n")
+	}
+	return pdfStream(fmt.Sprintf("/Type /ObjStm /N %d /First %d", len(objects), header.Len()), header.String()+body.String(), true)
+}
+
+func TestExtractPDFPages(t *testing.T) {
+	tests := []struct {
+		name  string
+		pdf   []byte
+		text  string
+		pages int
+	}{
+		{"page tree", testPDF(
+			"<< /Type /Catalog /Pages 2 0 R >>",
+			"<< /Type /Pages /Kids [3 0 R 4 0 R 5 0 R] /Count 3 >>",
+			"<< /Type /Page /Parent 2 0 R /Contents 7 0 R >>",
+			"<< /Type /Page /Parent 2 0 R >>",
+			"<< /Type /Page /Parent 2 0 R /Contents [6 0 R 8 0 R] /Resources 10 0 R >>",
+			pdfStream("", "BT (Third) Tj ET", true),
+			pdfStream("", "BT (First) Tj ET", true),
+			pdfStream("", "BT (page) Tj ET", false),
+			pdfStream("/Type /XObject /Subtype /Form", "BT (Form) Tj ET", true),
+			"<< /XObject << /Fx 9 0 R >> >>",
+		), "First\n\n\f\n\n\fThird\n\npage\n\nForm", 3},
+		{"object stream", testPDF(
+			"<< /Type /Catalog /Pages 5 0 R >>",
+			objectStream(5,
+				"<< /Type /Pages /Kids [6 0 R 7 0 R] /Count 2 >>",
+				"<< /Type /Page /Parent 5 0 R /Contents 3 0 R >>",
+				"<< /Type /Page /Parent 5 0 R /Contents 4 0 R >>"),
+			pdfStream("", "BT (One) Tj ET", true),
+			pdfStream("", "BT (Two) Tj ET", true),
+		), "One\n\n\fTwo", 2},
+		{"outside the page tree", testPDF(
+			"<< /Type /Pages /Kids [2 0 R] /Count 1 >>",
+			"<< /Type /Page /Parent 1 0 R /Contents 3 0 R >>",
+			pdfStream("", "BT (Body) Tj ET", true),
+			pdfStream("", "BT (Note) Tj ET", true),
+		), "Body\n\nNote", 1},
+		{"no page tree", testPDF(pdfStream("", "BT (Loose) Tj ET", true)), "Loose", 0},
+	}
+	for _, tt := range tests {
+		got, err := extractPDF(tt.pdf)
+		if err != nil || got.Text != tt.text || got.Pages != tt.pages {
+			t.Errorf("%s: extractPDF() = %q, %d pages, %v; want %q, %d pages", tt.name, got.Text, got.Pages, err, tt.text, tt.pages)
+		}
+	}
+}
+
+func TestPDFSearchPages(t *testing.T) {
+	withPagesDir(t)
+	withIndex(t, nil)
+	pdf := testPDF(
+		"<< /Type /Pages /Kids [2 0 R 3 0 R 4 0 R] /Count 3 >>",
+		"<< /Type /Page /Parent 1 0 R /Contents 5 0 R >>",
+		"<< /Type /Page /Parent 1 0 R /Contents 6 0 R >>",
+		"<< /Type /Page /Parent 1 0 R /Contents 7 0 R >>",
+		pdfStream("", "BT (Introduction to turbines) Tj ET", true),
+		pdfStream("", "BT (Blade design) Tj ET", true),
+		pdfStream("", "BT (Turbines at sea) Tj ET", true),
+	)
+	id, err := archivePDF(context.Background(), pdf, "https://example.com/turbines.pdf", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if metadata, _ := readMetadata(id); metadata.PDFPages != 3 {
+		t.Errorf("pdfPages = %d, want 3", metadata.PDFPages)
+	}
+	indexPendingFiles(context.Background())
+
+	tests := []struct {
+		query string
+		pages []int
+	}{
+		{"turbines", []int{1, 3}},
+		{"blade", []int{2}},
+		{"blade sea", []int{2, 3}},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		newRouter().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/search?q="+url.QueryEscape(tt.query), nil))
+		var results []SearchResult
+		json.Unmarshal(rec.Body.Bytes(), &results)
+		if len(results) != 1 || !reflect.DeepEqual(results[0].PDFPages, tt.pages) {
+			t.Errorf("search %q = %s, want pages %v", tt.query, rec.Body, tt.pages)
+		}
+	}
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// pdfViewerTemplate shows a PDF with the terms of a search highlighted,
+// opened at the first page they are on. With PDF.js available it renders
+// the PDF itself, marking the runs of text with a term in them; otherwise
+// it shows the text of each page, which works without JavaScript.
+var pdfViewerTemplate = template.Must(template.New("pdfviewer").Parse(`<!DOCTYPE html>
+<html lang="{{.Lang}}">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<meta name="color-scheme" content="light dark">
+<title>{{.Page.Title}} - Memento</title>
+<style>
+body { margin: 0; font: 16px/1.5 sans-serif; background: Canvas; color: CanvasText; }
+header, main { max-width: 60em; margin: 0 auto; padding: 0 1em; }
+header { padding-top: 1.5em; }
+header p { color: GrayText; font-size: 0.9em; }
+section { margin: 2em 0; }
+section h2 { font-size: 0.9em; color: GrayText; border-bottom: 1px solid GrayText; }
+section div { white-space: pre-wrap; font-family: Georgia, serif; }
+canvas { display: block; max-width: 100%; margin: 1em auto; box-shadow: 0 0 4px GrayText; }
+mark { background: #ffd200; color: #1f1f1f; }
+</style>
+</head>
+<body>
+<header>
+<h1>{{.Page.Title}}</h1>
+<p><a href="{{.PDFURL}}#page={{.First}}">{{.T "ui.viewer.pdf"}}</a> · <a href="{{.Page.URL}}">{{.T "ui.reader.original"}}</a>
+{{- if .Query}} · {{if .Matches}}<a href="#page-{{.First}}">{{.T "ui.viewer.first"}}</a>{{else}}{{.T "ui.viewer.nomatch" .Query}}{{end}}{{end}}</p>
+</header>
+<main>
+{{- if .PDFJS}}
+<script type="module">
+import * as pdfjsLib from "/pdfjs/pdf.mjs";
+pdfjsLib.GlobalWorkerOptions.workerSrc = "/pdfjs/pdf.worker.mjs";
+const terms = {{.Terms}};
+const first = {{.First}};
+const main = document.querySelector("main");
+const pdf = await pdfjsLib.getDocument({{.PDFURL}}).promise;
+for (let n = 1; n <= pdf.numPages; n++) {
+  const page = await pdf.getPage(n);
+  const viewport = page.getViewport({scale: 1.5});
+  const canvas = document.createElement("canvas");
+  canvas.id = "page-" + n;
+  canvas.width = viewport.width;
+  canvas.height = viewport.height;
+  main.append(canvas);
+  const context = canvas.getContext("2d");
+  await page.render({canvasContext: context, viewport}).promise;
+  const text = await page.getTextContent();
+  context.fillStyle = "rgba(255, 210, 0, 0.4)";
+  for (const item of text.items) {
+    const str = (item.str || "").toLowerCase();
+    if (!terms.some(term => str.includes(term))) continue;
+    const [, , c, d, e, f] = pdfjsLib.Util.transform(viewport.transform, item.transform);
+    const height = Math.hypot(c, d);
+    context.fillRect(e, f - height, item.width * viewport.scale, height * 1.2);
+  }
+  if (n === first) canvas.scrollIntoView();
+}
+</script>
+{{- else}}
+{{range .Sections}}<section id="page-{{.Number}}">
+{{if $.Paged}}<h2>{{$.T "ui.viewer.page" .Number}}</h2>
+{{end}}<div>{{.Text}}</div>
+</section>
+{{end}}
+{{- if or .Matches (gt .First 1)}}
+<script>document.getElementById("page-{{.First}}").scrollIntoView();</script>
+{{- end}}
+{{- end}}
+</main>
+</body>
+</html>
+`))
+
+type pdfViewerSection struct {
+	Number int
+	Text   template.HTML
+}
+
+type pdfViewerPage struct {
+	Localizer
+	Page   Page
+	Query  string
+	Terms  []string
+	PDFURL string
+	PDFJS  bool
+	// Paged is set when the text is split into the PDF's pages.
+	Paged    bool
+	Sections []pdfViewerSection
+	Matches  int
+	// First is the page the viewer opens at.
+	First int
+}
+
+// queryTerms analyzes a search's text as the content field is indexed,
+// returning the terms the content must contain to match.
+func queryTerms(q string) []string {
+	analyzer := contentAnalyzer()
+	if analyzer == nil {
+		return nil
+	}
+	seen := make(map[string]bool)
+	terms := []string{}
+	for _, token := range analyzer.Analyze([]byte(q)) {
+		if term := string(token.Term); !seen[term] {
+			seen[term] = true
+			terms = append(terms, term)
+		}
+	}
+	return terms
+}
+
+// markTerms escapes text for HTML, marking the words that analyze to one of
+// terms, and returns how many it marked.
+func markTerms(text string, terms []string) (template.HTML, int) {
+	analyzer := contentAnalyzer()
+	if analyzer == nil || len(terms) == 0 {
+		return template.HTML(html.EscapeString(text)), 0
+	}
+	wanted := make(map[string]bool)
+	for _, term := range terms {
+		wanted[term] = true
+	}
+	var out strings.Builder
+	marked, last := 0, 0
+	for _, token := range analyzer.Analyze([]byte(text)) {
+		if !wanted[string(token.Term)] || token.Start < last {
+			continue
+		}
+		out.WriteString(html.EscapeString(text[last:token.Start]))
+		out.WriteString("<mark>" + html.EscapeString(text[token.Start:token.End]) + "</mark>")
+		last = token.End
+		marked++
+	}
+	out.WriteString(html.EscapeString(text[last:]))
+	return template.HTML(out.String()), marked
+}
+
+// handlePDFViewer shows a stored PDF with the terms of the search in q
+// highlighted, at the page given as page= or else the first they are on.
+func handlePDFViewer(w http.ResponseWriter, r *http.Request) {
+	if !requireAction(w, "read") {
+		return
+	}
+	page, err := loadPage(r.PathValue("id"))
+	if err != nil || page.PDFFilename == "" {
+		writeError(w, http.StatusNotFound, ErrNotFound, "PDF not found")
+		return
+	}
+	var texts []string
+	if page.MDFilename != "" {
+		if texts, err = pdfPageTexts(page.PageMetadata); err != nil {
+			log.Printf("Error reading page %s: %v", page.ID, err)
+			writeError(w, http.StatusInternalServerError, ErrInternal, "Failed to read page")
+			return
+		}
+		// The title and URL heading the markdown aren't the PDF's
+		texts[0] = strings.TrimPrefix(texts[0], strings.TrimSuffix(pdfMarkdown(page.Title, page.URL, ""), "\n"))
+	}
+
+	query := r.URL.Query()
+	view := pdfViewerPage{
+		Localizer: localizerFor(r),
+		Page:      page,
+		Query:     query.Get("q"),
+		Terms:     queryTerms(query.Get("q")),
+		PDFURL:    "pdf",
+		PDFJS:     config.Viewer.PDFJSDir != "",
+		Paged:     page.PDFPages > 0,
+	}
+	// Feed readers and links shared with a token pass it on to the PDF
+	if token := query.Get("token"); token != "" {
+		view.PDFURL += "?token=" + url.QueryEscape(token)
+	}
+	for i, text := range texts {
+		marked, count := markTerms(text, view.Terms)
+		if count > 0 && view.Matches == 0 && view.Paged {
+			view.First = i + 1
+		}
+		view.Matches += count
+		view.Sections = append(view.Sections, pdfViewerSection{Number: i + 1, Text: marked})
+	}
+	if n, err := strconv.Atoi(query.Get("page")); err == nil && n >= 1 && (n <= len(texts) || view.PDFJS) {
+		view.First = n
+	}
+	if view.First == 0 {
+		view.First = 1
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Add("Vary", "Accept-Language")
+	if err := pdfViewerTemplate.Execute(w, view); err != nil {
+		log.Printf("Error rendering PDF viewer: %v", err)
+	}
+}
+
+// pdfjsHandler serves the PDF.js build configured as viewer.pdfjsDir.
+func pdfjsHandler() http.Handler {
+	files := http.StripPrefix("/pdfjs/", http.FileServer(http.Dir(config.Viewer.PDFJSDir)))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Modules are only run when served as JavaScript
+		if strings.HasSuffix(r.URL.Path, ".mjs") {
+			w.Header().Set("Content-Type", "text/javascript; charset=utf-8")
+		}
+		w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", 7*24*3600))
+		files.ServeHTTP(w, r)
+	})
+}
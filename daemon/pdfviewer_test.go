@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestMarkTerms(t *testing.T) {
+	withIndex(t, nil)
+	tests := []struct {
+		text  string
+		query string
+		want  string
+		count int
+	}{
+		{"Wind turbines at sea", "turbines", "Wind <mark>turbines</mark> at sea", 1},
+		{"TURBINES & turbines", "Turbines", "<mark>TURBINES</mark> &amp; <mark>turbines</mark>", 2},
+		{"<b>bold</b> claims", "bold", "&lt;b&gt;<mark>bold</mark>&lt;/b&gt; claims", 1},
+		{"Nothing here", "turbines", "Nothing here", 0},
+		{"Nothing here", "", "Nothing here", 0},
+	}
+	for _, tt := range tests {
+		got, count := markTerms(tt.text, queryTerms(tt.query))
+		if string(got) != tt.want || count != tt.count {
+			t.Errorf("markTerms(%q, %q) = %q, %d; want %q, %d", tt.text, tt.query, got, count, tt.want, tt.count)
+		}
+	}
+}
+
+func TestPDFViewer(t *testing.T) {
+	withPagesDir(t)
+	withIndex(t, nil)
+	pdf := testPDF(
+		"<< /Type /Pages /Kids [2 0 R 3 0 R 4 0 R] /Count 3 >>",
+		"<< /Type /Page /Parent 1 0 R /Contents 5 0 R >>",
+		"<< /Type /Page /Parent 1 0 R /Contents 6 0 R >>",
+		"<< /Type /Page /Parent 1 0 R /Contents 7 0 R >>",
+		pdfStream("", "BT (Introduction) Tj ET", true),
+		pdfStream("", "BT (Blade design) Tj ET", true),
+		pdfStream("", "BT (More on blades and a blade) Tj ET", true),
+	)
+	id, err := archivePDF(context.Background(), pdf, "https://example.com/turbines.pdf", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	get := func(path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		newRouter().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec
+	}
+
+	tests := []struct {
+		path    string
+		want    []string
+		notWant []string
+	}{
+		{"/pages/" + id + "/viewer?q=blade", []string{
+			`<section id="page-1">`, `<h2>Page 3</h2>`, `<mark>Blade</mark> design`, `and a <mark>blade</mark>`,
+			`href="pdf#page=2"`, `getElementById("page-2")`,
+		}, []string{"turbines.pdf\n\nURL", "pdf.mjs"}},
+		{"/pages/" + id + "/viewer?q=blade&page=3&token=secret", []string{`href="pdf?token=secret#page=3"`, `getElementById("page-3")`}, nil},
+		{"/pages/" + id + "/viewer?q=zeppelin", []string{"No matches for zeppelin"}, []string{"<mark>", "scrollIntoView"}},
+		{"/api/v1/pages/" + id + "/viewer", []string{"Introduction"}, []string{"scrollIntoView"}},
+	}
+	for _, tt := range tests {
+		rec := get(tt.path)
+		body := rec.Body.String()
+		if rec.Code != http.StatusOK {
+			t.Errorf("GET %s = %d %s", tt.path, rec.Code, body)
+			continue
+		}
+		for _, want := range tt.want {
+			if !strings.Contains(body, want) {
+				t.Errorf("GET %s: missing %q in\n%s", tt.path, want, body)
+			}
+		}
+		for _, notWant := range tt.notWant {
+			if strings.Contains(body, notWant) {
+				t.Errorf("GET %s: unexpected %q", tt.path, notWant)
+			}
+		}
+	}
+
+	if rec := get("/pages/missing/viewer"); rec.Code != http.StatusNotFound {
+		t.Errorf("viewer of a missing page = %d", rec.Code)
+	}
+	if rec := get("/pdfjs/pdf.mjs"); rec.Code != http.StatusNotFound {
+		t.Errorf("/pdfjs/ without pdfjsDir = %d", rec.Code)
+	}
+
+	// With PDF.js, the PDF itself is rendered
+	dir := t.TempDir()
+	ioutil.WriteFile(filepath.Join(dir, "pdf.mjs"), []byte("export {};"), 0644)
+	config.Viewer.PDFJSDir = dir
+	defer func() { config.Viewer.PDFJSDir = "" }()
+	rec := get("/pages/" + id + "/viewer?q=blade")
+	if body := rec.Body.String(); !strings.Contains(body, `import * as pdfjsLib from "/pdfjs/pdf.mjs"`) ||
+		!strings.Contains(body, `const terms = ["blade"]`) || !regexp.MustCompile(`const first =\s*2\s*;`).MatchString(body) || strings.Contains(body, "<section") {
+		t.Errorf("PDF.js viewer = %s", body)
+	}
+	rec = get("/pdfjs/pdf.mjs")
+	if rec.Code != http.StatusOK || !strings.HasPrefix(rec.Header().Get("Content-Type"), "text/javascript") {
+		t.Errorf("GET /pdfjs/pdf.mjs = %d %s", rec.Code, rec.Header().Get("Content-Type"))
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// privateScope lets a token's searches find private pages without asking
+// for them each time.
+const privateScope = "private"
+
+// tokenScopes are the scopes a token may be given.
+var tokenScopes = map[string]bool{privateScope: true}
+
+// errPrivateSearch is returned for searches asking for private pages
+// without a token or login to vouch for them.
+var errPrivateSearch = errors.New("include_private needs a token or login")
+
+func validateTokenScopes(tokens []TokenConfig) error {
+	for _, token := range tokens {
+		for _, scope := range token.Scopes {
+			if !tokenScopes[scope] {
+				return fmt.Errorf("token %s has unknown scope %q", token.Name, scope)
+			}
+		}
+	}
+	return nil
+}
+
+// hasScope reports whether the token was given scope.
+func (t *TokenConfig) hasScope(scope string) bool {
+	for _, s := range t.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// includePrivate reports whether a search finds private pages: always for
+// tokens with the private scope, and when requested by a request
+// authenticated with a token or login. An open API can't tell who asks, so
+// it never finds them on request.
+func includePrivate(r *http.Request, requested bool) (bool, error) {
+	token := requestToken(r)
+	if token != nil && token.hasScope(privateScope) {
+		return true, nil
+	}
+	if !requested {
+		return false, nil
+	}
+	if token == nil && requestSession(r) == nil {
+		return false, errPrivateSearch
+	}
+	return true, nil
+}
+
+// privateListed reports whether lists of pages the request didn't search
+// for, such as feeds, show private pages: as with searches that don't ask
+// for them, only to tokens with the private scope.
+func privateListed(r *http.Request) bool {
+	listed, _ := includePrivate(r, false)
+	return listed
+}
+
+// writeSearchOptionsError answers a request whose search options were
+// rejected.
+func writeSearchOptionsError(w http.ResponseWriter, err error) {
+	if errors.Is(err, errPrivateSearch) {
+		writeError(w, http.StatusForbidden, ErrForbidden, err.Error())
+		return
+	}
+	writeError(w, http.StatusBadRequest, ErrInvalidRequest, err.Error())
+}
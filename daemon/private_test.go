@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPrivatePagesLeftOutOfSearches(t *testing.T) {
+	now := time.Now()
+	withIndex(t, map[string]PageDocument{
+		"open":   {URL: "https://example.com/open", Content: "tax return", Time: now},
+		"hidden": {URL: "https://example.com/hidden", Content: "tax return", Time: now, Private: true},
+	})
+	if got, want := searchURLs(t, "tax", SearchOptions{}), []string{"https://example.com/open"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("default search = %v, want %v", got, want)
+	}
+	if got := searchURLs(t, "tax", SearchOptions{IncludePrivate: true}); len(got) != 2 {
+		t.Errorf("search including private pages = %v", got)
+	}
+
+	search := func(params string, token *TokenConfig) (int, []SearchResult) {
+		t.Helper()
+		r := httptest.NewRequest(http.MethodGet, "/search?q=tax"+params, nil)
+		if token != nil {
+			r = r.WithContext(context.WithValue(r.Context(), tokenContextKey{}, token))
+		}
+		w := httptest.NewRecorder()
+		handleSearch(w, r)
+		var results []SearchResult
+		json.Unmarshal(w.Body.Bytes(), &results)
+		return w.Code, results
+	}
+	if code, _ := search("&include_private=1", nil); code != http.StatusForbidden {
+		t.Errorf("status without authentication = %d", code)
+	}
+	if code, results := search("&include_private=1", &TokenConfig{Name: "reader"}); code != http.StatusOK || len(results) != 2 {
+		t.Errorf("authenticated search = %d, %d results", code, len(results))
+	}
+	if _, results := search("", &TokenConfig{Name: "reader"}); len(results) != 1 {
+		t.Errorf("token without the scope found %d results", len(results))
+	}
+	if _, results := search("", &TokenConfig{Name: "vault", Scopes: []string{privateScope}}); len(results) != 2 {
+		t.Errorf("token with the private scope found %d results", len(results))
+	}
+}
+
+func TestMarkPagePrivate(t *testing.T) {
+	withPagesDir(t)
+	withIndex(t, nil)
+	id, err := storePage(PageMetadata{URL: "https://example.com/diagnosis", Title: "Diagnosis"}, "", "Lab results")
+	if err != nil {
+		t.Fatal(err)
+	}
+	indexExistingFiles(context.Background())
+
+	r := httptest.NewRequest(http.MethodPatch, "/pages/"+id, strings.NewReader(`{"private": true}`))
+	r.SetPathValue("id", id)
+	w := httptest.NewRecorder()
+	handlePage(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", w.Code, w.Body)
+	}
+	if page, err := loadPage(id); err != nil || !page.Private || !page.Indexed {
+		t.Errorf("page = %+v, %v", page, err)
+	}
+	if got := searchURLs(t, "lab", SearchOptions{}); len(got) != 0 {
+		t.Errorf("private page found: %v", got)
+	}
+
+	if err := validateTokenScopes([]TokenConfig{{Name: "x", Scopes: []string{"admin"}}}); err == nil {
+		t.Error("unknown scope accepted")
+	}
+}
+
+func TestPrivatePagesLeftOutOfLists(t *testing.T) {
+	saved := config
+	defer func() { config = saved }()
+	config.OPDS.MinWords = 1
+	withPagesDir(t)
+	withIndex(t, nil)
+	ids := map[string]string{}
+	for name, page := range map[string]PageMetadata{
+		"open":   {URL: "https://news.example/bike-lanes", Title: "Open copy"},
+		"hidden": {URL: "https://ledger.example/bike-lanes", Title: "Hidden copy", Private: true},
+	} {
+		id, err := storePage(page, "", bikeLanes)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids[name] = id
+	}
+	indexExistingFiles(context.Background())
+
+	get := func(target string, token *TokenConfig) string {
+		t.Helper()
+		r := httptest.NewRequest(http.MethodGet, "/api/v1"+target, nil)
+		if token != nil {
+			r = r.WithContext(context.WithValue(r.Context(), tokenContextKey{}, token))
+		}
+		w := httptest.NewRecorder()
+		newRouter().ServeHTTP(w, r)
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: status = %d: %s", target, w.Code, w.Body)
+		}
+		return w.Body.String()
+	}
+	vault := &TokenConfig{Name: "vault", Scopes: []string{privateScope}}
+	for _, target := range []string{"/feed", "/opds", "/pages/" + ids["open"] + "/similar"} {
+		if body := get(target, nil); strings.Contains(body, "ledger.example") || strings.Contains(body, ids["hidden"]) {
+			t.Errorf("%s lists the private page:\n%s", target, body)
+		}
+		if body := get(target, vault); !strings.Contains(body, ids["hidden"]) {
+			t.Errorf("%s: token with the private scope misses the private page:\n%s", target, body)
+		}
+	}
+}
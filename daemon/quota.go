@@ -0,0 +1,185 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// tokenUsage counts a token's recent activity. Counters live in memory, so a
+// restart resets the day's captures and the search window.
+type tokenUsage struct {
+	day      string
+	captures int
+	searches []time.Time
+}
+
+var (
+	usageMu      sync.Mutex
+	usageByToken = make(map[string]*tokenUsage)
+)
+
+// usageFor returns the token's counters, rolling the capture count over at
+// midnight. usageMu must be held.
+func usageFor(name string) *tokenUsage {
+	u, ok := usageByToken[name]
+	if !ok {
+		u = &tokenUsage{}
+		usageByToken[name] = u
+	}
+	if today := time.Now().Format("2006-01-02"); u.day != today {
+		u.day, u.captures = today, 0
+	}
+	return u
+}
+
+// recentSearches drops searches older than a minute and returns the rest.
+// usageMu must be held.
+func (u *tokenUsage) recentSearches(now time.Time) []time.Time {
+	cutoff := now.Add(-time.Minute)
+	recent := u.searches[:0]
+	for _, t := range u.searches {
+		if t.After(cutoff) {
+			recent = append(recent, t)
+		}
+	}
+	u.searches = recent
+	return recent
+}
+
+// storageUsed sums the size of the content files and stored assets of
+// pages owned by name.
+func storageUsed(name string) (int64, error) {
+	pages, err := listPages()
+	if err != nil {
+		return 0, err
+	}
+	var total int64
+	for _, page := range pages {
+		if page.Owner != name {
+			continue
+		}
+		for _, file := range page.files() {
+			if info, err := os.Stat(filepath.Join(pagesDir, file)); err == nil {
+				total += info.Size()
+			}
+		}
+		assets, err := dirSize(assetsDir(page.ID))
+		if err != nil {
+			return 0, err
+		}
+		total += assets
+	}
+	return total, nil
+}
+
+// allowSearch counts a search against the request's token, writing a
+// QUOTA_EXCEEDED error and returning false if it is over its limit.
+func allowSearch(w http.ResponseWriter, r *http.Request) bool {
+	token := requestToken(r)
+	if token == nil || token.Quota.SearchesPerMinute <= 0 {
+		return true
+	}
+	usageMu.Lock()
+	defer usageMu.Unlock()
+
+	now := time.Now()
+	u := usageFor(token.Name)
+	if recent := u.recentSearches(now); len(recent) >= token.Quota.SearchesPerMinute {
+		retry := recent[0].Add(time.Minute).Sub(now)
+		w.Header().Set("Retry-After", fmt.Sprint(int(retry.Seconds())+1))
+		writeError(w, http.StatusTooManyRequests, ErrQuotaExceeded, fmt.Sprintf("Search quota of %d per minute exceeded", token.Quota.SearchesPerMinute))
+		return false
+	}
+	u.searches = append(u.searches, now)
+	return true
+}
+
+// allowCapture counts a capture against the request's token, writing a
+// QUOTA_EXCEEDED error and returning false if it is over its capture or
+// storage quota. The capture is counted up front so that concurrent
+// requests can't all squeeze under the limit; a capture that then fails is
+// handed back with releaseCapture.
+func allowCapture(w http.ResponseWriter, r *http.Request) bool {
+	token := requestToken(r)
+	if token == nil {
+		return true
+	}
+	if limit := token.Quota.StorageBytes; limit > 0 {
+		used, err := storageUsed(token.Name)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, ErrInternal, "Failed to compute storage usage")
+			return false
+		}
+		if used >= limit {
+			writeError(w, http.StatusInsufficientStorage, ErrQuotaExceeded, fmt.Sprintf("Storage quota of %d bytes exceeded", limit))
+			return false
+		}
+	}
+
+	usageMu.Lock()
+	defer usageMu.Unlock()
+	u := usageFor(token.Name)
+	if limit := token.Quota.CapturesPerDay; limit > 0 && u.captures >= limit {
+		writeError(w, http.StatusTooManyRequests, ErrQuotaExceeded, fmt.Sprintf("Capture quota of %d per day exceeded", limit))
+		return false
+	}
+	u.captures++
+	return true
+}
+
+// releaseCapture gives back a capture counted by allowCapture that didn't
+// happen.
+func releaseCapture(r *http.Request) {
+	if token := requestToken(r); token != nil {
+		usageMu.Lock()
+		if u := usageFor(token.Name); u.captures > 0 {
+			u.captures--
+		}
+		usageMu.Unlock()
+	}
+}
+
+type usageReport struct {
+	Name               string      `json:"name"`
+	CapturesToday      int         `json:"capturesToday"`
+	StorageBytes       int64       `json:"storageBytes"`
+	SearchesLastMinute int         `json:"searchesLastMinute"`
+	Quota              QuotaConfig `json:"quota"`
+}
+
+// handleUsage reports the requesting token's usage against its quotas.
+func handleUsage(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, ErrMethodNotAllowed, "Method not allowed")
+		return
+	}
+	token := requestToken(r)
+	if token == nil {
+		writeError(w, http.StatusNotFound, ErrNotFound, "No API tokens are configured")
+		return
+	}
+
+	storage, err := storageUsed(token.Name)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, ErrInternal, "Failed to compute storage usage")
+		return
+	}
+	usageMu.Lock()
+	u := usageFor(token.Name)
+	report := usageReport{
+		Name:               token.Name,
+		CapturesToday:      u.captures,
+		StorageBytes:       storage,
+		SearchesLastMinute: len(u.recentSearches(time.Now())),
+		Quota:              token.Quota,
+	}
+	usageMu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func tokenRequest(token *TokenConfig) *http.Request {
+	r := httptest.NewRequest(http.MethodPost, "/archive", nil)
+	return r.WithContext(context.WithValue(r.Context(), tokenContextKey{}, token))
+}
+
+func TestAllowCaptureReservesSlots(t *testing.T) {
+	withPagesDir(t)
+	token := &TokenConfig{Name: "quota-test", Quota: QuotaConfig{CapturesPerDay: 3}}
+	defer func() {
+		usageMu.Lock()
+		delete(usageByToken, token.Name)
+		usageMu.Unlock()
+	}()
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	allowed := 0
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if allowCapture(httptest.NewRecorder(), tokenRequest(token)) {
+				mu.Lock()
+				allowed++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if allowed != 3 {
+		t.Fatalf("allowed %d concurrent captures, want 3", allowed)
+	}
+
+	w := httptest.NewRecorder()
+	if allowCapture(w, tokenRequest(token)) || w.Code != http.StatusTooManyRequests {
+		t.Errorf("capture over quota: status %d, want 429", w.Code)
+	}
+	releaseCapture(tokenRequest(token))
+	if !allowCapture(httptest.NewRecorder(), tokenRequest(token)) {
+		t.Error("released slot was not reusable")
+	}
+}
+
+func TestAllowCaptureWithoutToken(t *testing.T) {
+	if !allowCapture(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/archive", nil)) {
+		t.Error("open API refused a capture")
+	}
+}
+
+func TestAllowSearch(t *testing.T) {
+	token := &TokenConfig{Name: "search-test", Quota: QuotaConfig{SearchesPerMinute: 2}}
+	defer func() {
+		usageMu.Lock()
+		delete(usageByToken, token.Name)
+		usageMu.Unlock()
+	}()
+
+	for i := 0; i < 2; i++ {
+		if !allowSearch(httptest.NewRecorder(), tokenRequest(token)) {
+			t.Fatalf("search %d refused", i+1)
+		}
+	}
+	w := httptest.NewRecorder()
+	if allowSearch(w, tokenRequest(token)) {
+		t.Fatal("search over quota allowed")
+	}
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") == "" || decodeError(t, w).Code != ErrQuotaExceeded {
+		t.Errorf("status = %d, Retry-After = %q", w.Code, w.Header().Get("Retry-After"))
+	}
+}
+
+func TestStorageQuota(t *testing.T) {
+	withPagesDir(t)
+	id, err := storePage(PageMetadata{URL: "https://example.com/a", Owner: "alex"}, "<p>alex's page</p>", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := storePage(PageMetadata{URL: "https://example.com/b", Owner: "sam"}, "<p>a page of someone else's</p>", "# B"); err != nil {
+		t.Fatal(err)
+	}
+	page, err := loadPage(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(filepath.Join(pagesDir, page.HTMLFilename))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if used, err := storageUsed("alex"); err != nil || used != info.Size() {
+		t.Errorf("storageUsed = %d, %v; want %d", used, err, info.Size())
+	}
+
+	token := &TokenConfig{Name: "alex", Quota: QuotaConfig{StorageBytes: info.Size() + 1}}
+	defer func() {
+		usageMu.Lock()
+		delete(usageByToken, token.Name)
+		usageMu.Unlock()
+	}()
+	if !allowCapture(httptest.NewRecorder(), tokenRequest(token)) {
+		t.Error("capture under the storage quota refused")
+	}
+	token.Quota.StorageBytes = info.Size()
+	w := httptest.NewRecorder()
+	if allowCapture(w, tokenRequest(token)) || w.Code != http.StatusInsufficientStorage {
+		t.Errorf("capture over the storage quota: status %d, want 507", w.Code)
+	}
+
+	// Stored assets count too
+	if err := os.MkdirAll(assetsDir(id), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(assetsDir(id), "photo.jpg"), make([]byte, 100), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if used, err := storageUsed("alex"); err != nil || used != info.Size()+100 {
+		t.Errorf("storageUsed with assets = %d, %v; want %d", used, err, info.Size()+100)
+	}
+	token.Quota.StorageBytes = info.Size() + 1
+	w = httptest.NewRecorder()
+	if allowCapture(w, tokenRequest(token)) || w.Code != http.StatusInsufficientStorage {
+		t.Errorf("capture over the storage quota with assets: status %d, want 507", w.Code)
+	}
+}
+
+func TestHandleUsage(t *testing.T) {
+	withPagesDir(t)
+	token := &TokenConfig{Name: "usage-test", Quota: QuotaConfig{CapturesPerDay: 5}}
+	defer func() {
+		usageMu.Lock()
+		delete(usageByToken, token.Name)
+		usageMu.Unlock()
+	}()
+	allowCapture(httptest.NewRecorder(), tokenRequest(token))
+
+	r := tokenRequest(token)
+	r.Method = http.MethodGet
+	w := httptest.NewRecorder()
+	handleUsage(w, r)
+	var report usageReport
+	if err := json.Unmarshal(w.Body.Bytes(), &report); err != nil {
+		t.Fatal(err)
+	}
+	if report.Name != token.Name || report.CapturesToday != 1 || report.Quota.CapturesPerDay != 5 {
+		t.Errorf("report = %+v", report)
+	}
+
+	w = httptest.NewRecorder()
+	handleUsage(w, httptest.NewRequest(http.MethodGet, "/usage", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("usage without a token: status = %d, want 404", w.Code)
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// rateLimiter keeps a token bucket per client for one group of endpoints.
+// Buckets live in memory, so a restart lets every client start full.
+type rateLimiter struct {
+	name   string
+	limits func() RateConfig
+
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	swept   time.Time
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+var (
+	searchLimiter = &rateLimiter{name: "search", limits: func() RateConfig { return config.RateLimit.Search }}
+	ingestLimiter = &rateLimiter{name: "ingest", limits: func() RateConfig { return config.RateLimit.Ingest }}
+)
+
+// take spends one of client's tokens, returning how long until one is
+// available when none is left.
+func (l *rateLimiter) take(client string, now time.Time) (bool, time.Duration) {
+	limits := l.limits()
+	if limits.PerMinute <= 0 {
+		return true, 0
+	}
+	burst := float64(limits.burst())
+	perSecond := limits.PerMinute / 60
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.buckets == nil {
+		l.buckets = make(map[string]*bucket)
+	}
+	l.sweep(now, burst/perSecond)
+	b, ok := l.buckets[client]
+	if !ok {
+		b = &bucket{tokens: burst, last: now}
+		l.buckets[client] = b
+	}
+	b.tokens = math.Min(burst, b.tokens+now.Sub(b.last).Seconds()*perSecond)
+	b.last = now
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) / perSecond * float64(time.Second))
+	}
+	b.tokens--
+	return true, 0
+}
+
+// sweep forgets, at most once a minute, the buckets of clients that have
+// been away long enough for them to be full again. l.mu must be held.
+func (l *rateLimiter) sweep(now time.Time, refillSeconds float64) {
+	if now.Sub(l.swept) < time.Minute {
+		return
+	}
+	l.swept = now
+	for client, b := range l.buckets {
+		if now.Sub(b.last).Seconds() >= refillSeconds {
+			delete(l.buckets, client)
+		}
+	}
+}
+
+// burst returns how many requests a client may make at once, by default
+// a minute's worth.
+func (c RateConfig) burst() int {
+	if c.Burst > 0 {
+		return c.Burst
+	}
+	return int(math.Max(1, math.Ceil(c.PerMinute)))
+}
+
+// rateClient identifies who a request counts against: its token or login,
+// or else the address it came from.
+func rateClient(r *http.Request) string {
+	if user := requestUser(r); user != "" {
+		return "user:" + user
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "ip:" + host
+}
+
+// rateLimited answers requests over l's rate with a RATE_LIMITED error and
+// a Retry-After header. With safe set, GET and HEAD requests aren't
+// counted, for endpoints that only ingest on other methods.
+func rateLimited(l *rateLimiter, safe bool, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if safe && safeMethod(r) {
+			next(w, r)
+			return
+		}
+		if ok, wait := l.take(rateClient(r), time.Now()); !ok {
+			retry := int(math.Ceil(wait.Seconds()))
+			w.Header().Set("Retry-After", fmt.Sprint(retry))
+			writeError(w, http.StatusTooManyRequests, ErrRateLimited, fmt.Sprintf("Too many %s requests, retry in %d seconds", l.name, retry))
+			return
+		}
+		next(w, r)
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRateLimiterRefills(t *testing.T) {
+	l := &rateLimiter{name: "test", limits: func() RateConfig { return RateConfig{PerMinute: 60, Burst: 2} }}
+	now := time.Now()
+	for i := 0; i < 2; i++ {
+		if ok, _ := l.take("a", now); !ok {
+			t.Fatalf("request %d within the burst was refused", i+1)
+		}
+	}
+	ok, wait := l.take("a", now)
+	if ok || wait <= 0 || wait > time.Second {
+		t.Fatalf("request over the burst = %v, wait %v", ok, wait)
+	}
+	if ok, _ := l.take("b", now); !ok {
+		t.Error("another client was refused")
+	}
+	if ok, _ := l.take("a", now.Add(time.Second)); !ok {
+		t.Error("request after a refill was refused")
+	}
+	if ok, _ := (&rateLimiter{limits: func() RateConfig { return RateConfig{} }}).take("a", now); !ok {
+		t.Error("limiter without a rate refused a request")
+	}
+}
+
+func TestRateLimited(t *testing.T) {
+	l := &rateLimiter{name: "search", limits: func() RateConfig { return RateConfig{PerMinute: 1} }}
+	handler := rateLimited(l, true, func(w http.ResponseWriter, r *http.Request) {})
+	request := func(method, addr string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		r := httptest.NewRequest(method, "/archive/site", nil)
+		r.RemoteAddr = addr
+		handler(rec, r)
+		return rec
+	}
+
+	if rec := request(http.MethodPost, "192.0.2.1:1234"); rec.Code != http.StatusOK {
+		t.Fatalf("first request = %d", rec.Code)
+	}
+	rec := request(http.MethodPost, "192.0.2.1:5678")
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") == "" {
+		t.Errorf("second request from the same address = %d, Retry-After %q", rec.Code, rec.Header().Get("Retry-After"))
+	}
+	if rec := request(http.MethodGet, "192.0.2.1:1234"); rec.Code != http.StatusOK {
+		t.Errorf("uncounted GET = %d", rec.Code)
+	}
+	if rec := request(http.MethodPost, "192.0.2.2:1234"); rec.Code != http.StatusOK {
+		t.Errorf("request from another address = %d", rec.Code)
+	}
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"html"
+	"html/template"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const readerCookie = "memento_reader"
+
+// readerOptions lists the accepted values of each reading view setting; the
+// first value is the default.
+var readerOptions = map[string][]string{
+	"theme": {"auto", "light", "dark", "sepia"},
+	"size":  {"medium", "small", "large"},
+	"width": {"medium", "narrow", "wide"},
+}
+
+// readerSettings are the typography settings of the reading view. They are
+// stored in a cookie so each browser keeps its own choice.
+type readerSettings struct {
+	Theme string
+	Size  string
+	Width string
+}
+
+func validReaderOption(name, value string) bool {
+	for _, option := range readerOptions[name] {
+		if option == value {
+			return true
+		}
+	}
+	return false
+}
+
+// readerSettingsFor reads the settings from the cookie, overridden by any
+// query parameters, which are then saved back to the cookie.
+func readerSettingsFor(w http.ResponseWriter, r *http.Request) readerSettings {
+	values := map[string]string{}
+	for name, options := range readerOptions {
+		values[name] = options[0]
+	}
+	if cookie, err := r.Cookie(readerCookie); err == nil {
+		for _, pair := range strings.Split(cookie.Value, ".") {
+			if name, value, ok := strings.Cut(pair, "-"); ok && validReaderOption(name, value) {
+				values[name] = value
+			}
+		}
+	}
+
+	changed := false
+	for name := range readerOptions {
+		if value := r.URL.Query().Get(name); validReaderOption(name, value) {
+			values[name] = value
+			changed = true
+		}
+	}
+	settings := readerSettings{Theme: values["theme"], Size: values["size"], Width: values["width"]}
+	if changed {
+		http.SetCookie(w, &http.Cookie{
+			Name:     readerCookie,
+			Value:    "theme-" + settings.Theme + ".size-" + settings.Size + ".width-" + settings.Width,
+			Path:     "/",
+			Expires:  time.Now().AddDate(1, 0, 0),
+			HttpOnly: true,
+			SameSite: http.SameSiteLaxMode,
+		})
+	}
+	return settings
+}
+
+// readerTemplate renders a page for reading. Themes and typography are plain
+// CSS custom properties, so the view works without JavaScript; the auto
+// theme follows the system's dark mode preference.
+var readerTemplate = template.Must(template.New("reader").Parse(`<!DOCTYPE html>
+<html lang="{{.Lang}}" class="theme-{{.Settings.Theme}} size-{{.Settings.Size}} width-{{.Settings.Width}}">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<meta name="color-scheme" content="{{if eq .Settings.Theme "auto"}}light dark{{else if eq .Settings.Theme "dark"}}dark{{else}}light{{end}}">
+<title>{{.Page.Title}} - Memento</title>
+<style>
+:root { --bg: #fdfdfd; --fg: #1f1f1f; --muted: #666; --link: #1a5fb4; --code-bg: #f0f0f0; --font-size: 19px; --width: 38em; }
+@media (prefers-color-scheme: dark) {
+  .theme-auto { --bg: #1b1b1d; --fg: #dcdcdc; --muted: #999; --link: #8cb4ff; --code-bg: #2a2a2e; }
+}
+.theme-dark { --bg: #1b1b1d; --fg: #dcdcdc; --muted: #999; --link: #8cb4ff; --code-bg: #2a2a2e; }
+.theme-sepia { --bg: #f4ecd8; --fg: #433422; --muted: #7a6a53; --link: #8a4b08; --code-bg: #e9dfc7; }
+.size-small { --font-size: 16px; }
+.size-large { --font-size: 23px; }
+.width-narrow { --width: 30em; }
+.width-wide { --width: 50em; }
+body { margin: 0; background: var(--bg); color: var(--fg); font: var(--font-size)/1.6 Georgia, "Iowan Old Style", serif; }
+main, header, form { max-width: var(--width); margin: 0 auto; padding: 0 1em; }
+header { padding-top: 2em; }
+header p { color: var(--muted); font-size: 0.8em; }
+a { color: var(--link); }
+img { max-width: 100%; height: auto; }
+pre, code { background: var(--code-bg); font-size: 0.85em; }
+pre { padding: 0.8em; overflow-x: auto; }
+blockquote { margin-left: 0; padding-left: 1em; border-left: 3px solid var(--muted); color: var(--muted); }
+form { padding-top: 1em; padding-bottom: 1em; font: 14px sans-serif; color: var(--muted); }
+form label { margin-right: 1em; }
+</style>
+</head>
+<body>
+<form method="get">
+<label>{{.T "ui.reader.theme"}}
+<select name="theme">{{range .Options.theme}}<option value="{{.}}"{{if eq . $.Settings.Theme}} selected{{end}}>{{$.T (print "ui.reader.theme." .)}}</option>{{end}}</select></label>
+<label>{{.T "ui.reader.size"}}
+<select name="size">{{range .Options.size}}<option value="{{.}}"{{if eq . $.Settings.Size}} selected{{end}}>{{$.T (print "ui.reader.size." .)}}</option>{{end}}</select></label>
+<label>{{.T "ui.reader.width"}}
+<select name="width">{{range .Options.width}}<option value="{{.}}"{{if eq . $.Settings.Width}} selected{{end}}>{{$.T (print "ui.reader.width." .)}}</option>{{end}}</select></label>
+<button type="submit">{{.T "ui.reader.apply"}}</button>
+</form>
+<header>
+<h1>{{.Page.Title}}</h1>
+<p><a href="{{.Page.URL}}">{{.T "ui.reader.original"}}</a> · {{.T "ui.saved" .SavedAgo}}</p>
+</header>
+<main>
+<article>
+{{.Content}}
+</article>
+</main>
+</body>
+</html>
+`))
+
+type readerPage struct {
+	Localizer
+	Page     Page
+	Settings readerSettings
+	Options  map[string][]string
+	SavedAgo string
+	Content  template.HTML
+}
+
+// readerContent renders a page's markdown, or for pages saved without
+// markdown the text extracted from their HTML with one paragraph per line.
+func readerContent(metadata PageMetadata) (template.HTML, error) {
+	path := contentPath(metadata)
+	content, err := readContentFile(path)
+	if err != nil {
+		return "", err
+	}
+	if !isHTMLFile(path) {
+		return renderMarkdown(commentRe.ReplaceAllString(string(content), "")), nil
+	}
+
+	var out strings.Builder
+	for _, line := range strings.Split(extractText(string(content), true), "\n") {
+		if line != "" {
+			out.WriteString("<p>" + html.EscapeString(line) + "</p>\n")
+		}
+	}
+	return template.HTML(out.String()), nil
+}
+
+// handleReader renders a page in the reading view.
+func handleReader(w http.ResponseWriter, r *http.Request) {
+	if !requireAction(w, "read") {
+		return
+	}
+	page, err := loadPage(r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusNotFound, ErrNotFound, "Page not found")
+		return
+	}
+	content, err := readerContent(page.PageMetadata)
+	if err != nil {
+		log.Printf("Error reading page %s: %v", page.ID, err)
+		writeError(w, http.StatusInternalServerError, ErrInternal, "Failed to read page")
+		return
+	}
+
+	localizer := localizerFor(r)
+	view := readerPage{
+		Localizer: localizer,
+		Page:      page,
+		Settings:  readerSettingsFor(w, r),
+		Options:   readerOptions,
+		SavedAgo:  localizer.RelativeTime(page.Timestamp, time.Now()),
+		Content:   content,
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Add("Vary", "Accept-Language, Cookie")
+	if err := readerTemplate.Execute(w, view); err != nil {
+		log.Printf("Error rendering reading view: %v", err)
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestReaderSettingsFor(t *testing.T) {
+	tests := []struct {
+		name       string
+		target     string
+		cookie     string
+		want       readerSettings
+		wantCookie string
+	}{
+		{"defaults", "/read/x", "", readerSettings{"auto", "medium", "medium"}, ""},
+		{"cookie", "/read/x", "theme-dark.size-large.width-bogus", readerSettings{"dark", "large", "medium"}, ""},
+		{"query", "/read/x?theme=sepia&width=wide&size=huge", "theme-dark.size-large", readerSettings{"sepia", "large", "wide"}, "theme-sepia.size-large.width-wide"},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, tt.target, nil)
+		if tt.cookie != "" {
+			r.AddCookie(&http.Cookie{Name: readerCookie, Value: tt.cookie})
+		}
+		rec := httptest.NewRecorder()
+		if got := readerSettingsFor(rec, r); got != tt.want {
+			t.Errorf("%s: settings = %+v, want %+v", tt.name, got, tt.want)
+		}
+		var saved string
+		for _, cookie := range rec.Result().Cookies() {
+			if cookie.Name == readerCookie {
+				saved = cookie.Value
+			}
+		}
+		if saved != tt.wantCookie {
+			t.Errorf("%s: saved cookie %q, want %q", tt.name, saved, tt.wantCookie)
+		}
+	}
+}
+
+func TestHandleReader(t *testing.T) {
+	withPagesDir(t)
+	markdownID, err := storePage(PageMetadata{URL: "https://example.com/md", Title: "Notes"}, "", "Some **bold** text\n\n<!-- memento:attention 0.5 -->")
+	if err != nil {
+		t.Fatal(err)
+	}
+	htmlID, err := storePage(PageMetadata{URL: "https://example.com/html", Title: "Page"}, "<p>One &lt;b&gt;</p><script>x()</script>", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	read := func(id, query string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/read/"+id+query, nil)
+		r.SetPathValue("id", id)
+		rec := httptest.NewRecorder()
+		handleReader(rec, r)
+		return rec
+	}
+
+	rec := read(markdownID, "?theme=dark")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", rec.Code)
+	}
+	body := rec.Body.String()
+	for _, want := range []string{"<strong>bold</strong>", `class="theme-dark size-medium width-medium"`, "<h1>Notes</h1>"} {
+		if !strings.Contains(body, want) {
+			t.Errorf("markdown page lacks %q", want)
+		}
+	}
+	if strings.Contains(body, "memento:attention") {
+		t.Error("markdown comments rendered")
+	}
+
+	body = read(htmlID, "").Body.String()
+	if !strings.Contains(body, "<p>One &lt;b&gt;</p>") || strings.Contains(body, "x()") {
+		t.Errorf("HTML page content not extracted and escaped:\n%s", body)
+	}
+
+	if rec := read("missing", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("missing page: status = %d, want 404", rec.Code)
+	}
+}
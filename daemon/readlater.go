@@ -0,0 +1,201 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// readLaterParsers maps read-later service names to parsers of their export
+// formats.
+var readLaterParsers = map[string]func([]byte) ([]linkRecord, error){
+	"pocket":     parsePocketExport,
+	"instapaper": parseInstapaperExport,
+	"raindrop":   parseRaindropExport,
+}
+
+func importReadLater(ctx context.Context, service string, r io.Reader) (importSummary, error) {
+	parse, ok := readLaterParsers[service]
+	if !ok {
+		return importSummary{}, fmt.Errorf("unsupported read-later service %q", service)
+	}
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return importSummary{}, err
+	}
+	records, err := parse(data)
+	if err != nil {
+		return importSummary{}, fmt.Errorf("parsing %s export: %v", service, err)
+	}
+	return importLinkRecords(ctx, records)
+}
+
+// csvRows reads a CSV file with a header row into maps keyed by lowercased
+// column name.
+func csvRows(data []byte) ([]map[string]string, error) {
+	reader := csv.NewReader(bytes.NewReader(data))
+	reader.FieldsPerRecord = -1
+	rows, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return nil, nil
+	}
+
+	header := rows[0]
+	for i, name := range header {
+		header[i] = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))
+	}
+	var records []map[string]string
+	for _, row := range rows[1:] {
+		record := make(map[string]string)
+		for i, value := range row {
+			if i < len(header) {
+				record[header[i]] = strings.TrimSpace(value)
+			}
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+func parseUnixTime(value string) time.Time {
+	seconds, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || seconds <= 0 {
+		return time.Time{}
+	}
+	return time.Unix(seconds, 0).UTC()
+}
+
+func splitTags(value, sep string) []string {
+	var tags []string
+	for _, tag := range strings.Split(value, sep) {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+// parsePocketExport reads Pocket's CSV export (title, url, time_added, tags,
+// status) or the JSON list returned by its retrieve API.
+func parsePocketExport(data []byte) ([]linkRecord, error) {
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '{' {
+		return parsePocketJSON(trimmed)
+	}
+
+	rows, err := csvRows(data)
+	if err != nil {
+		return nil, err
+	}
+	var records []linkRecord
+	for _, row := range rows {
+		records = append(records, linkRecord{
+			URL:       row["url"],
+			Title:     row["title"],
+			Tags:      splitTags(row["tags"], "|"),
+			Timestamp: parseUnixTime(row["time_added"]),
+			Source:    "pocket",
+		})
+	}
+	return records, nil
+}
+
+func parsePocketJSON(data []byte) ([]linkRecord, error) {
+	var export struct {
+		List map[string]struct {
+			GivenURL      string                     `json:"given_url"`
+			ResolvedURL   string                     `json:"resolved_url"`
+			GivenTitle    string                     `json:"given_title"`
+			ResolvedTitle string                     `json:"resolved_title"`
+			Excerpt       string                     `json:"excerpt"`
+			TimeAdded     string                     `json:"time_added"`
+			Tags          map[string]json.RawMessage `json:"tags"`
+		} `json:"list"`
+	}
+	if err := json.Unmarshal(data, &export); err != nil {
+		return nil, err
+	}
+
+	var records []linkRecord
+	for _, item := range export.List {
+		record := linkRecord{
+			URL:       item.ResolvedURL,
+			Title:     item.ResolvedTitle,
+			Text:      item.Excerpt,
+			Timestamp: parseUnixTime(item.TimeAdded),
+			Source:    "pocket",
+		}
+		if record.URL == "" {
+			record.URL = item.GivenURL
+		}
+		if record.Title == "" {
+			record.Title = item.GivenTitle
+		}
+		for tag := range item.Tags {
+			record.Tags = append(record.Tags, tag)
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+// parseInstapaperExport reads Instapaper's CSV export (URL, Title, Selection,
+// Folder, Timestamp and, in newer exports, Tags as a JSON array). Folders
+// other than the built-in Unread and Archive become tags.
+func parseInstapaperExport(data []byte) ([]linkRecord, error) {
+	rows, err := csvRows(data)
+	if err != nil {
+		return nil, err
+	}
+	var records []linkRecord
+	for _, row := range rows {
+		record := linkRecord{
+			URL:       row["url"],
+			Title:     row["title"],
+			Text:      row["selection"],
+			Timestamp: parseUnixTime(row["timestamp"]),
+			Source:    "instapaper",
+		}
+		if tags := row["tags"]; tags != "" {
+			json.Unmarshal([]byte(tags), &record.Tags)
+		}
+		if folder := row["folder"]; folder != "" && folder != "Unread" && folder != "Archive" {
+			record.Tags = append(record.Tags, folder)
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+// parseRaindropExport reads a Raindrop.io CSV backup (id, title, note,
+// excerpt, url, folder, tags, created, ...).
+func parseRaindropExport(data []byte) ([]linkRecord, error) {
+	rows, err := csvRows(data)
+	if err != nil {
+		return nil, err
+	}
+	var records []linkRecord
+	for _, row := range rows {
+		record := linkRecord{
+			URL:    row["url"],
+			Title:  row["title"],
+			Text:   strings.TrimSpace(row["excerpt"] + "\n\n" + row["note"]),
+			Tags:   splitTags(row["tags"], ","),
+			Source: "raindrop",
+		}
+		if t, err := time.Parse(time.RFC3339, row["created"]); err == nil {
+			record.Timestamp = t
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestReadLaterParsers(t *testing.T) {
+	added := time.Unix(1700000000, 0).UTC()
+	tests := []struct {
+		name    string
+		service string
+		data    string
+		want    []linkRecord
+		wantErr bool
+	}{
+		{
+			name:    "pocket csv",
+			service: "pocket",
+			data:    "title,url,time_added,tags,status\nGo,https://go.dev,1700000000,lang|tools,unread\n",
+			want:    []linkRecord{{URL: "https://go.dev", Title: "Go", Tags: []string{"lang", "tools"}, Timestamp: added, Source: "pocket"}},
+		},
+		{
+			name:    "pocket csv with BOM and spaces",
+			service: "pocket",
+			data:    "\ufeffTitle , URL ,time_added\n Go , https://go.dev ,not a time\n",
+			want:    []linkRecord{{URL: "https://go.dev", Title: "Go", Source: "pocket"}},
+		},
+		{
+			name:    "pocket json",
+			service: "pocket",
+			data:    `{"list":{"1":{"given_url":"http://go.dev","resolved_url":"https://go.dev","given_title":"","resolved_title":"Go","excerpt":"A language","time_added":"1700000000"}}}`,
+			want:    []linkRecord{{URL: "https://go.dev", Title: "Go", Text: "A language", Timestamp: added, Source: "pocket"}},
+		},
+		{
+			name:    "pocket json falls back to given fields",
+			service: "pocket",
+			data:    ` {"list":{"1":{"given_url":"http://go.dev","given_title":"Given"}}}`,
+			want:    []linkRecord{{URL: "http://go.dev", Title: "Given", Source: "pocket"}},
+		},
+		{
+			name:    "pocket malformed json",
+			service: "pocket",
+			data:    `{"list":`,
+			wantErr: true,
+		},
+		{
+			name:    "instapaper",
+			service: "instapaper",
+			data:    "URL,Title,Selection,Folder,Timestamp,Tags\nhttps://go.dev,Go,quoted,Reading,1700000000,\"[\"\"lang\"\"]\"\nhttps://a.dev,A,,Unread,,\n",
+			want: []linkRecord{
+				{URL: "https://go.dev", Title: "Go", Text: "quoted", Tags: []string{"lang", "Reading"}, Timestamp: added, Source: "instapaper"},
+				{URL: "https://a.dev", Title: "A", Source: "instapaper"},
+			},
+		},
+		{
+			name:    "instapaper short row",
+			service: "instapaper",
+			data:    "URL,Title,Selection,Folder,Timestamp\nhttps://go.dev\n",
+			want:    []linkRecord{{URL: "https://go.dev", Source: "instapaper"}},
+		},
+		{
+			name:    "raindrop",
+			service: "raindrop",
+			data:    "id,title,note,excerpt,url,folder,tags,created\n1,Go,mine,theirs,https://go.dev,Dev,\"lang, tools\",2023-11-14T22:13:20Z\n",
+			want:    []linkRecord{{URL: "https://go.dev", Title: "Go", Text: "theirs\n\nmine", Tags: []string{"lang", "tools"}, Timestamp: added, Source: "raindrop"}},
+		},
+		{
+			name:    "header only",
+			service: "raindrop",
+			data:    "id,title,url\n",
+		},
+		{
+			name:    "empty file",
+			service: "instapaper",
+			data:    "",
+		},
+		{
+			name:    "unterminated quote",
+			service: "raindrop",
+			data:    "id,title\n1,\"Go\n",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		got, err := readLaterParsers[tt.service]([]byte(tt.data))
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: error = %v, want error %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s:\n got %+v\nwant %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestImportLinkRecordsDeduplicates(t *testing.T) {
+	withPagesDir(t)
+	records := []linkRecord{
+		{URL: "https://go.dev", Title: "Go", Source: "pocket"},
+		{URL: "https://go.dev", Title: "Go", Source: "pocket"},
+		{URL: "https://untitled.dev", Source: "pocket"},
+		{Source: "pocket"},
+	}
+	summary, err := importLinkRecords(context.Background(), records)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := importSummary{Imported: 2, Duplicates: 1, Failed: 1}
+	if summary != want {
+		t.Errorf("summary = %+v, want %+v", summary, want)
+	}
+}
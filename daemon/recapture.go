@@ -0,0 +1,438 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const recaptureQueueFile = "memento_recapture_queue.json"
+
+const (
+	// defaultRecaptureBelow is the fidelity score under which pages are
+	// offered for re-capture.
+	defaultRecaptureBelow = 50
+	// recaptureClaimTimeout is how long a page handed to the extension is
+	// left to it before being handed out again.
+	recaptureClaimTimeout = 30 * time.Minute
+)
+
+// Re-capture modes: the daemon fetches the page itself, or the extension
+// opens it in the browser it was first captured in.
+const (
+	recaptureServer    = "server"
+	recaptureExtension = "extension"
+)
+
+// QueuedRecapture is a page waiting for the extension to capture it again.
+type QueuedRecapture struct {
+	PageID    string    `json:"pageId"`
+	URL       string    `json:"url"`
+	Requested time.Time `json:"requested"`
+	// Claimed is when the extension last took the page from the queue.
+	Claimed time.Time `json:"claimed,omitempty"`
+}
+
+var recaptureQueueMu sync.Mutex
+
+// readRecaptureQueue loads the queue. recaptureQueueMu must be held.
+func readRecaptureQueue() ([]QueuedRecapture, error) {
+	data, err := ioutil.ReadFile(recaptureQueueFile)
+	if os.IsNotExist(err) {
+		return []QueuedRecapture{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var queue []QueuedRecapture
+	if err := json.Unmarshal(data, &queue); err != nil {
+		return nil, fmt.Errorf("parsing %s: %v", recaptureQueueFile, err)
+	}
+	return queue, nil
+}
+
+// writeRecaptureQueue replaces the queue file atomically.
+// recaptureQueueMu must be held.
+func writeRecaptureQueue(queue []QueuedRecapture) error {
+	data, err := json.MarshalIndent(queue, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := recaptureQueueFile + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, recaptureQueueFile)
+}
+
+// queueRecaptures adds pages to the extension's queue, skipping those
+// already in it, and returns how many were added.
+func queueRecaptures(pages []Page) (int, error) {
+	recaptureQueueMu.Lock()
+	defer recaptureQueueMu.Unlock()
+	queue, err := readRecaptureQueue()
+	if err != nil {
+		return 0, err
+	}
+	queued := make(map[string]bool)
+	for _, entry := range queue {
+		queued[entry.PageID] = true
+	}
+	added := 0
+	for _, page := range pages {
+		if queued[page.ID] {
+			continue
+		}
+		queue = append(queue, QueuedRecapture{PageID: page.ID, URL: page.URL, Requested: time.Now()})
+		queued[page.ID] = true
+		added++
+	}
+	return added, writeRecaptureQueue(queue)
+}
+
+// completeRecapture links a newly indexed page to the queued page it
+// captures again, if any. indexMu must be held.
+func completeRecapture(id string, metadata PageMetadata) {
+	if metadata.Supersedes != "" {
+		return
+	}
+	recaptureQueueMu.Lock()
+	defer recaptureQueueMu.Unlock()
+	queue, err := readRecaptureQueue()
+	if err != nil || len(queue) == 0 {
+		return
+	}
+	for i, entry := range queue {
+		if entry.URL != metadata.URL || entry.PageID == id || metadata.Timestamp.Before(entry.Requested) {
+			continue
+		}
+		if err := supersedePage(entry.PageID, id); err != nil && !os.IsNotExist(err) {
+			log.Printf("Error replacing %s with %s: %v", entry.PageID, id, err)
+			return
+		}
+		queue = append(queue[:i], queue[i+1:]...)
+		if err := writeRecaptureQueue(queue); err != nil {
+			log.Printf("Error writing %s: %v", recaptureQueueFile, err)
+		}
+		return
+	}
+}
+
+// supersedePage records that newID is a better capture of oldID's page.
+// The old version is kept and indexed again as replaced, so searches find
+// the new one unless they look back to before it. indexMu must be held.
+func supersedePage(oldID, newID string) error {
+	old, err := readMetadata(oldID)
+	if err != nil {
+		return err
+	}
+	current, err := readMetadata(newID)
+	if err != nil {
+		return err
+	}
+	current.Supersedes = oldID
+	// A captured bookmark keeps what it was saved with, and when
+	if old.Bookmark {
+		bookmarked := old.Timestamp
+		if old.Bookmarked != nil {
+			bookmarked = *old.Bookmarked
+		}
+		current.Bookmarked = &bookmarked
+		for _, tag := range old.Tags {
+			if !hasTag(current.Tags, tag) {
+				current.Tags = append(current.Tags, tag)
+			}
+		}
+		current.Note = strings.TrimSpace(old.Note + "\n" + current.Note)
+		current.Starred = current.Starred || old.Starred
+	}
+	if err := writeMetadata(newID, current); err != nil {
+		return err
+	}
+	if old.Bookmark && current.Indexed {
+		content, err := pageContent(current)
+		if err == nil {
+			err = index.Index(newID, pageDocument(current, content))
+		}
+		if err != nil {
+			return err
+		}
+	}
+	old.SupersededBy = newID
+	if err := writeMetadata(oldID, old); err != nil {
+		return err
+	}
+	content, err := pageContent(old)
+	if err != nil {
+		// Without its content the old version can only leave the index
+		log.Printf("Error reading content of %s: %v", oldID, err)
+		return index.Delete(oldID)
+	}
+	return index.Index(oldID, pageDocument(old, content))
+}
+
+// recapturePage fetches a page again through the full pipeline, rendered
+// and with a screenshot when a browser is configured, and stores the
+// result as its new version.
+func recapturePage(ctx context.Context, page Page) (string, error) {
+	ctx, span := startSpan(ctx, "recapture")
+	defer span.End()
+	span.SetAttribute("page.id", page.ID)
+	browser := config.Browser.Path != ""
+	id, err := archiveURL(ctx, page.URL, page.Owner, browser)
+	if err != nil {
+		span.RecordError(err)
+		return "", err
+	}
+	// Like /archive, a capture that is only missing extras is kept
+	if _, err := captureAssets(ctx, id); err != nil {
+		log.Printf("Error storing assets of %s: %v", page.URL, err)
+	}
+	if browser {
+		if err := captureScreenshot(ctx, id, page.URL); err != nil {
+			log.Printf("Error taking screenshot of %s: %v", page.URL, err)
+		}
+	}
+
+	indexMu.Lock()
+	defer indexMu.Unlock()
+	indexPendingFiles(ctx)
+	if err := supersedePage(page.ID, id); err != nil {
+		span.RecordError(err)
+		return "", err
+	}
+	return id, nil
+}
+
+// recaptureCandidates returns the current versions of pages scoring below
+// a fidelity threshold, worst first.
+func recaptureCandidates(below, limit int) ([]Fidelity, error) {
+	pages, err := listPages()
+	if err != nil {
+		return nil, err
+	}
+	candidates := []Fidelity{}
+	for _, page := range pages {
+		if page.SupersededBy != "" || !webURL(page.URL) {
+			continue
+		}
+		if f := pageFidelity(page); f.Score < below {
+			candidates = append(candidates, f)
+		}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].Score < candidates[j].Score
+	})
+	if limit > 0 && len(candidates) > limit {
+		candidates = candidates[:limit]
+	}
+	return candidates, nil
+}
+
+// webURL reports whether a page came from the web, as opposed to an upload.
+func webURL(pageURL string) bool {
+	u, err := url.Parse(pageURL)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https")
+}
+
+type recaptureRequest struct {
+	Mode  string `json:"mode"`
+	Below int    `json:"below"`
+	Limit int    `json:"limit"`
+}
+
+// decodeRecaptureRequest reads an optional re-capture request body,
+// filling in defaults, or writes an INVALID_REQUEST error and returns
+// false.
+func decodeRecaptureRequest(w http.ResponseWriter, r *http.Request) (recaptureRequest, bool) {
+	request := recaptureRequest{Mode: recaptureServer, Below: defaultRecaptureBelow, Limit: defaultPageListLimit}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			writeError(w, http.StatusBadRequest, ErrInvalidRequest, "Invalid request body")
+			return request, false
+		}
+	}
+	if request.Mode != recaptureServer && request.Mode != recaptureExtension {
+		writeError(w, http.StatusBadRequest, ErrInvalidRequest, `mode must be "server" or "extension"`)
+		return request, false
+	}
+	return request, true
+}
+
+// handleRecapture lists the pages below a fidelity threshold, given as
+// below=, on GET, and on POST re-captures them all: in the background on
+// the server, or by queueing them for the extension.
+func handleRecapture(w http.ResponseWriter, r *http.Request) {
+	var request recaptureRequest
+	switch r.Method {
+	case http.MethodGet:
+		request = recaptureRequest{Below: defaultRecaptureBelow, Limit: defaultPageListLimit}
+		if v := r.URL.Query().Get("below"); v != "" {
+			below, err := strconv.Atoi(v)
+			if err != nil {
+				writeError(w, http.StatusBadRequest, ErrInvalidRequest, "below must be a number")
+				return
+			}
+			request.Below = below
+		}
+		if limit, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && limit > 0 {
+			request.Limit = limit
+		}
+	case http.MethodPost:
+		var ok bool
+		if request, ok = decodeRecaptureRequest(w, r); !ok {
+			return
+		}
+		// Like imports, the captures aren't counted against the token
+		if token := requestToken(r); token != nil && token.Quota.limited() {
+			writeError(w, http.StatusForbidden, ErrActionDisabled, "Bulk re-capture is not available to tokens with quotas")
+			return
+		}
+	default:
+		writeError(w, http.StatusMethodNotAllowed, ErrMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	candidates, err := recaptureCandidates(request.Below, request.Limit)
+	if err != nil {
+		log.Printf("Error listing pages: %v", err)
+		writeError(w, http.StatusInternalServerError, ErrInternal, "Failed to list pages")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method == http.MethodGet {
+		json.NewEncoder(w).Encode(candidates)
+		return
+	}
+
+	var pages []Page
+	for _, candidate := range candidates {
+		if page, err := loadPage(candidate.ID); err == nil {
+			pages = append(pages, page)
+		}
+	}
+	count := len(pages)
+	if request.Mode == recaptureExtension {
+		if count, err = queueRecaptures(pages); err != nil {
+			log.Printf("Error queueing re-captures: %v", err)
+			writeError(w, http.StatusInternalServerError, ErrInternal, "Failed to queue re-captures")
+			return
+		}
+	} else {
+		go func() {
+			for _, page := range pages {
+				if _, err := recapturePage(context.Background(), page); err != nil {
+					log.Printf("Error re-capturing %s: %v", page.URL, err)
+					publishError(page.ID, fmt.Errorf("re-capturing %s: %v", page.URL, err))
+				}
+			}
+		}()
+	}
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]int{"pages": count})
+}
+
+// handlePageRecapture re-captures one page, on the server before
+// responding with its new version, or by queueing it for the extension.
+func handlePageRecapture(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, ErrMethodNotAllowed, "Method not allowed")
+		return
+	}
+	page, err := loadPage(r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusNotFound, ErrNotFound, "Page not found")
+		return
+	}
+	request, ok := decodeRecaptureRequest(w, r)
+	if !ok {
+		return
+	}
+	switch {
+	case page.SupersededBy != "":
+		writeError(w, http.StatusConflict, ErrInvalidRequest, "Page has been captured again as "+page.SupersededBy)
+		return
+	case !webURL(page.URL):
+		writeError(w, http.StatusBadRequest, ErrInvalidRequest, "Only pages from the web can be captured again")
+		return
+	}
+
+	if request.Mode == recaptureExtension {
+		if _, err := queueRecaptures([]Page{page}); err != nil {
+			log.Printf("Error queueing re-capture of %s: %v", page.ID, err)
+			writeError(w, http.StatusInternalServerError, ErrInternal, "Failed to queue re-capture")
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+	writeRecapture(w, r, page)
+}
+
+// writeRecapture captures page again on the server, counted against the
+// request's quota, and responds with its new version.
+func writeRecapture(w http.ResponseWriter, r *http.Request, page Page) {
+	if !allowCapture(w, r) {
+		return
+	}
+	id, err := recapturePage(r.Context(), page)
+	if err != nil {
+		log.Printf("Error re-capturing %s: %v", page.URL, err)
+		releaseCapture(r)
+		writeArchiveError(w, err)
+		return
+	}
+	writeArchived(r.Context(), w, id)
+}
+
+// handleRecaptureQueue hands the extension up to limit pages queued for it
+// that it hasn't taken recently, marking them taken.
+func handleRecaptureQueue(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, ErrMethodNotAllowed, "Method not allowed")
+		return
+	}
+	recaptureQueueMu.Lock()
+	defer recaptureQueueMu.Unlock()
+	queue, err := readRecaptureQueue()
+	if err != nil {
+		log.Printf("Error reading %s: %v", recaptureQueueFile, err)
+		writeError(w, http.StatusInternalServerError, ErrInternal, "Failed to read the re-capture queue")
+		return
+	}
+	limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+	if err != nil || limit <= 0 {
+		limit = len(queue)
+	}
+	now := time.Now()
+	due := []QueuedRecapture{}
+	for i := range queue {
+		// Each user's extension captures their own pages again
+		if usersEnabled() {
+			if page, err := loadPage(queue[i].PageID); err != nil || !pageVisible(r, page.PageMetadata) {
+				continue
+			}
+		}
+		if len(due) < limit && now.Sub(queue[i].Claimed) >= recaptureClaimTimeout {
+			queue[i].Claimed = now
+			due = append(due, queue[i])
+		}
+	}
+	if len(due) > 0 {
+		if err := writeRecaptureQueue(queue); err != nil {
+			log.Printf("Error writing %s: %v", recaptureQueueFile, err)
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(due)
+}
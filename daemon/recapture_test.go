@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPageRecapture(t *testing.T) {
+	withPagesDir(t)
+	withIndex(t, nil)
+	article := strings.Repeat("Plenty of words about the topic. ", 100)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprintf(w, "<html><head><title>Article</title></head><body><p>%s</p></body></html>", article)
+	}))
+	defer server.Close()
+	// The test server is on a loopback address the archive client refuses
+	saved := archiveClient
+	archiveClient = server.Client()
+	defer func() { archiveClient = saved }()
+
+	old, _ := storePage(PageMetadata{URL: server.URL + "/post", Title: "Article"}, "<p>Just a moment... checking your browser</p>", "")
+	upload, _ := storePage(PageMetadata{URL: "file:///notes.pdf", Title: "Notes"}, "", "notes")
+	indexExistingFiles(context.Background())
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/recapture", handleRecapture)
+	mux.HandleFunc("/pages/{id}/recapture", handlePageRecapture)
+	get := func(path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec
+	}
+	post := func(path, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, path, strings.NewReader(body)))
+		return rec
+	}
+
+	var candidates []Fidelity
+	json.Unmarshal(get("/recapture?below=30").Body.Bytes(), &candidates)
+	if len(candidates) != 1 || candidates[0].ID != old {
+		t.Fatalf("candidates = %+v, want only the blocked web page", candidates)
+	}
+
+	tests := []struct {
+		name   string
+		id     string
+		body   string
+		status int
+	}{
+		{"bad mode", old, `{"mode": "fax"}`, http.StatusBadRequest},
+		{"upload", upload, "", http.StatusBadRequest},
+		{"missing", "missing", "", http.StatusNotFound},
+		{"server", old, "", http.StatusCreated},
+		{"already replaced", old, "", http.StatusConflict},
+	}
+	var current Page
+	for _, tt := range tests {
+		rec := post("/pages/"+tt.id+"/recapture", tt.body)
+		if rec.Code != tt.status {
+			t.Fatalf("%s: status = %d %s, want %d", tt.name, rec.Code, rec.Body, tt.status)
+		}
+		if rec.Code == http.StatusCreated {
+			json.Unmarshal(rec.Body.Bytes(), &current)
+		}
+	}
+
+	if current.Supersedes != old || !current.Indexed {
+		t.Errorf("new version = %+v, want it indexed and superseding %s", current, old)
+	}
+	previous, _ := loadPage(old)
+	if previous.SupersededBy != current.ID {
+		t.Errorf("old version superseded by %q, want %q", previous.SupersededBy, current.ID)
+	}
+	checkVersions := func(when string) {
+		t.Helper()
+		results, err := searchPages(context.Background(), "moment", SearchOptions{}, Localizer{Lang: defaultLocale})
+		if err != nil || len(results) != 0 {
+			t.Errorf("%s: search finds the old version: %v, %v", when, results, err)
+		}
+		results, err = searchPages(context.Background(), "moment", SearchOptions{AsOf: current.Timestamp.Add(-time.Nanosecond)}, Localizer{Lang: defaultLocale})
+		if err != nil || len(results) != 1 || results[0].ID != old {
+			t.Errorf("%s: search as of before the re-capture = %v, %v, want the old version", when, results, err)
+		}
+	}
+	checkVersions("after re-capture")
+	json.Unmarshal(get("/recapture?below=30").Body.Bytes(), &candidates)
+	if len(candidates) != 0 {
+		t.Errorf("candidates after re-capture = %+v", candidates)
+	}
+
+	// A full reindex keeps the old version as replaced
+	reindexAllPages(context.Background())
+	checkVersions("after reindex")
+}
+
+func TestRecaptureQueue(t *testing.T) {
+	withPagesDir(t)
+	withIndex(t, nil)
+	old, _ := storePage(PageMetadata{URL: "https://example.com/post", Title: "Post", Timestamp: time.Now().Add(-time.Hour)}, "<p>short</p>", "")
+	other, _ := storePage(PageMetadata{URL: "https://example.com/other", Title: "Other", Timestamp: time.Now().Add(-time.Hour)}, "<p>brief</p>", "")
+	indexExistingFiles(context.Background())
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/recapture", handleRecapture)
+	mux.HandleFunc("/recapture/queue", handleRecaptureQueue)
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/recapture", strings.NewReader(`{"mode": "extension", "below": 100}`)))
+	if rec.Code != http.StatusAccepted || !strings.Contains(rec.Body.String(), `"pages":2`) {
+		t.Fatalf("POST /recapture = %d %s", rec.Code, rec.Body)
+	}
+
+	tests := []struct {
+		limit string
+		want  int
+	}{
+		{"1", 1},
+		{"", 1},
+		// Both were handed out recently
+		{"", 0},
+	}
+	for i, tt := range tests {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/recapture/queue?limit="+tt.limit, nil))
+		var queue []QueuedRecapture
+		json.Unmarshal(rec.Body.Bytes(), &queue)
+		if len(queue) != tt.want {
+			t.Errorf("poll %d: got %d pages, want %d", i, len(queue), tt.want)
+		}
+	}
+
+	// The extension's capture arrives and replaces the queued page
+	current, _ := storePage(PageMetadata{URL: "https://example.com/post", Title: "Post"}, "", "# Post\n\nThe whole post.")
+	indexExistingFiles(context.Background())
+	if page, _ := loadPage(current); page.Supersedes != old {
+		t.Errorf("extension capture supersedes %q, want %q", page.Supersedes, old)
+	}
+	recaptureQueueMu.Lock()
+	queue, _ := readRecaptureQueue()
+	recaptureQueueMu.Unlock()
+	if len(queue) != 1 || queue[0].PageID != other {
+		t.Errorf("queue = %+v, want only %s left", queue, other)
+	}
+}
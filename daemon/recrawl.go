@@ -0,0 +1,242 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const eventPageChanged = "page.changed"
+
+// recrawlTick is how often the recrawl looks for pages due a check.
+const recrawlTick = time.Hour
+
+const (
+	// changeSampleLines is how many added and removed lines a change
+	// summary quotes, each cut to changeSampleChars.
+	changeSampleLines = 5
+	changeSampleChars = 200
+)
+
+// RecrawlConfig re-fetches the pages tagged with any of Tags, storing a
+// new version of those whose text changed by at least Threshold, as
+// measured by drift checks. A page is checked once its server's caching
+// headers say it may have changed, but no sooner than MinIntervalHours and
+// no later than MaxIntervalHours after its last check, or every
+// IntervalHours when the server sent no such headers. It is off when Tags
+// is empty.
+type RecrawlConfig struct {
+	Tags             []string `json:"tags"`
+	IntervalHours    int      `json:"intervalHours"`
+	MinIntervalHours int      `json:"minIntervalHours"`
+	MaxIntervalHours int      `json:"maxIntervalHours"`
+	Threshold        float64  `json:"threshold"`
+}
+
+// tracked reports whether a page is one the recrawl follows: the current
+// version of a web page with one of its tags.
+func (c RecrawlConfig) tracked(page Page) bool {
+	if page.SupersededBy != "" || !webURL(page.URL) {
+		return false
+	}
+	for _, tag := range page.Tags {
+		for _, t := range c.Tags {
+			if strings.EqualFold(tag, t) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// due reports whether a tracked page is due a check at now.
+func (c RecrawlConfig) due(page Page, now time.Time) bool {
+	last := page.Timestamp
+	var staleAt *time.Time
+	if f := page.Freshness; f != nil {
+		staleAt = f.StaleAt
+		if f.CheckedAt.After(last) {
+			last = f.CheckedAt
+		}
+	}
+	since := now.Sub(last)
+	switch {
+	case since < time.Duration(c.MinIntervalHours)*time.Hour:
+		return false
+	case since >= time.Duration(c.MaxIntervalHours)*time.Hour:
+		return true
+	case staleAt != nil:
+		return !now.Before(*staleAt)
+	}
+	return since >= time.Duration(c.IntervalHours)*time.Hour
+}
+
+// ChangeSummary describes how a version stored by the recrawl differs from
+// the version before it.
+type ChangeSummary struct {
+	Previous string  `json:"previous"`
+	Drift    float64 `json:"drift"`
+	// Added and Removed count the lines of text only in this version or
+	// only in the previous one, and AddedText and RemovedText quote the
+	// first of them.
+	Added       int      `json:"added"`
+	Removed     int      `json:"removed"`
+	AddedText   []string `json:"addedText,omitempty"`
+	RemovedText []string `json:"removedText,omitempty"`
+}
+
+// summarizeChanges compares the lines of text of two versions, leaving out
+// the site's boilerplate.
+func summarizeChanges(before, after string, boilerplate map[string]bool) ChangeSummary {
+	var summary ChangeSummary
+	old := textBlocks(before, boilerplate)
+	current := textBlocks(after, boilerplate)
+	count := func(text string, others map[string]bool, n *int, sample *[]string) {
+		seen := make(map[string]bool)
+		for _, line := range strings.Split(text, "\n") {
+			hash := blockHash(line)
+			if line == "" || boilerplate[hash] || others[hash] || seen[hash] {
+				continue
+			}
+			seen[hash] = true
+			*n++
+			if len(*sample) < changeSampleLines {
+				*sample = append(*sample, truncateRunes(strings.TrimSpace(line), changeSampleChars))
+			}
+		}
+	}
+	count(after, old, &summary.Added, &summary.AddedText)
+	count(before, current, &summary.Removed, &summary.RemovedText)
+	return summary
+}
+
+// recrawlPage checks a tracked page against its live version and, when it
+// changed by at least the threshold, captures it again as its new version
+// with a summary of the changes. It returns the new version's ID, or ""
+// when the page hasn't changed enough. The server is asked first whether
+// the page changed, which spares fetching pages it says haven't.
+func recrawlPage(ctx context.Context, c RecrawlConfig, page Page) (string, ChangeSummary, error) {
+	freshness, unchanged, err := revalidate(ctx, page)
+	if err != nil {
+		return "", ChangeSummary{}, err
+	}
+	err = reindexWith(ctx, page.ID, func(metadata *PageMetadata) error {
+		metadata.Freshness = &freshness
+		return nil
+	})
+	if err != nil || unchanged {
+		return "", ChangeSummary{}, err
+	}
+	check, err := checkDrift(ctx, page)
+	if err != nil {
+		return "", ChangeSummary{}, err
+	}
+	if check.Error != "" {
+		return "", ChangeSummary{}, errors.New(check.Error)
+	}
+	if check.Drift < c.Threshold {
+		return "", ChangeSummary{}, nil
+	}
+	before, err := readFullText(page.PageMetadata)
+	if err != nil {
+		return "", ChangeSummary{}, err
+	}
+	id, err := recapturePage(ctx, page)
+	if err != nil {
+		return "", ChangeSummary{}, err
+	}
+	current, err := loadPage(id)
+	if err != nil {
+		return "", ChangeSummary{}, err
+	}
+	after, err := readFullText(current.PageMetadata)
+	if err != nil {
+		return "", ChangeSummary{}, err
+	}
+	summary := summarizeChanges(before, after, siteBoilerplate(page.URL))
+	summary.Previous, summary.Drift = page.ID, check.Drift
+	// The new version keeps the tags that have it recrawled
+	err = reindexWith(ctx, id, func(metadata *PageMetadata) error {
+		metadata.Tags = page.Tags
+		metadata.Changes = &summary
+		return nil
+	})
+	return id, summary, err
+}
+
+// recrawl checks the tracked pages due a check, or with all every tracked
+// page, returning how many were stored again because they changed.
+func recrawl(ctx context.Context, c RecrawlConfig, all bool) (int, error) {
+	pages, err := listPages()
+	if err != nil {
+		return 0, err
+	}
+	now := time.Now()
+	changed := 0
+	for _, page := range pages {
+		if !c.tracked(page) || !(all || c.due(page, now)) {
+			continue
+		}
+		id, summary, err := recrawlPage(ctx, c, page)
+		if err != nil {
+			log.Printf("Error recrawling %s: %v", page.URL, err)
+			continue
+		}
+		if id == "" {
+			continue
+		}
+		changed++
+		log.Printf("%s changed by %.2f (%d lines added, %d removed), stored as %s", page.URL, summary.Drift, summary.Added, summary.Removed, id)
+		events.publish(Event{Type: eventPageChanged, PageID: id, URL: page.URL, Title: page.Title,
+			Message: fmt.Sprintf("%d lines added, %d removed since %s", summary.Added, summary.Removed, page.ID)})
+	}
+	return changed, nil
+}
+
+// watchRecrawl recrawls the tracked pages as they fall due.
+func watchRecrawl() {
+	if len(config.Recrawl.Tags) == 0 {
+		return
+	}
+	for {
+		time.Sleep(recrawlTick)
+		if _, err := recrawl(context.Background(), config.Recrawl, false); err != nil {
+			log.Printf("Recrawl failed: %v", err)
+			publishError("", fmt.Errorf("recrawl: %v", err))
+		}
+	}
+}
+
+// handleRecrawl starts a recrawl of the tracked pages in the background,
+// responding with how many there are.
+func handleRecrawl(w http.ResponseWriter, r *http.Request) {
+	if len(config.Recrawl.Tags) == 0 {
+		writeError(w, http.StatusBadRequest, ErrInvalidRequest, "No pages are tracked; set recrawl.tags in the config")
+		return
+	}
+	pages, err := listPages()
+	if err != nil {
+		log.Printf("Error listing pages: %v", err)
+		writeError(w, http.StatusInternalServerError, ErrInternal, "Failed to list pages")
+		return
+	}
+	tracked := 0
+	for _, page := range pages {
+		if config.Recrawl.tracked(page) {
+			tracked++
+		}
+	}
+	go func() {
+		if _, err := recrawl(context.Background(), config.Recrawl, true); err != nil {
+			log.Printf("Recrawl failed: %v", err)
+		}
+	}()
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]int{"pages": tracked})
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestSummarizeChanges(t *testing.T) {
+	before := "Pricing\nTen dollars a month\nContact us\nContact us"
+	after := "Pricing\nTwenty dollars a month\nNow with support\nContact us"
+	got := summarizeChanges(before, after, map[string]bool{blockHash("Contact us"): true})
+	want := ChangeSummary{
+		Added: 2, AddedText: []string{"Twenty dollars a month", "Now with support"},
+		Removed: 1, RemovedText: []string{"Ten dollars a month"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("summarizeChanges() = %+v, want %+v", got, want)
+	}
+}
+
+func TestRecrawl(t *testing.T) {
+	withPagesDir(t)
+	withIndex(t, nil)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		switch r.URL.Path {
+		case "/pricing":
+			w.Write([]byte("<title>Pricing</title><p>Pricing</p><p>Twenty dollars a month</p>"))
+		default:
+			w.Write([]byte("<title>Pricing</title><p>Pricing</p><p>Ten dollars a month</p>"))
+		}
+	}))
+	defer server.Close()
+	saved := archiveClient
+	archiveClient = server.Client()
+	defer func() { archiveClient = saved }()
+
+	store := func(path string, tags []string) string {
+		id, err := storePage(PageMetadata{URL: server.URL + path, Title: path, Tags: tags},
+			"<title>Pricing</title><p>Pricing</p><p>Ten dollars a month</p>", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return id
+	}
+	changedID := store("/pricing", []string{"Track"})
+	store("/same", []string{"track"})
+	store("/untracked", nil)
+	indexExistingFiles(context.Background())
+
+	c := RecrawlConfig{Tags: []string{"track"}, Threshold: materialDrift}
+	changed, err := recrawl(context.Background(), c, true)
+	if err != nil || changed != 1 {
+		t.Fatalf("recrawl() = %d, %v, want the one changed page", changed, err)
+	}
+	old, _ := loadPage(changedID)
+	if old.SupersededBy == "" {
+		t.Fatalf("changed page wasn't replaced: %+v", old.PageMetadata)
+	}
+	current, _ := loadPage(old.SupersededBy)
+	summary := current.Changes
+	if summary == nil || summary.Previous != changedID || summary.Drift < c.Threshold ||
+		!reflect.DeepEqual(summary.AddedText, []string{"Twenty dollars a month"}) || !reflect.DeepEqual(summary.RemovedText, []string{"Ten dollars a month"}) {
+		t.Errorf("change summary = %+v", summary)
+	}
+	if !reflect.DeepEqual(current.Tags, []string{"Track"}) || !c.tracked(current) {
+		t.Errorf("new version tags = %v, want it still tracked", current.Tags)
+	}
+
+	// Unchanged pages are only checked
+	pages, _ := listPages()
+	if len(pages) != 4 {
+		t.Errorf("%d pages stored, want one new version", len(pages))
+	}
+	if changed, _ := recrawl(context.Background(), c, true); changed != 0 {
+		t.Errorf("second recrawl stored %d pages, want none", changed)
+	}
+}
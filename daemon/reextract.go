@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// extractorVersion numbers the extraction of text and titles from stored
+// HTML and PDFs. Bump it when extraction improves, so that reextract can
+// find the pages stored before.
+const extractorVersion = 2
+
+// reextractFilter selects the pages to extract again: those extracted by a
+// version before version, or before the time before, or all of them when
+// neither is set.
+type reextractFilter struct {
+	version int
+	before  time.Time
+}
+
+// parseReextractSince reads the since argument of reextract, an extractor
+// version or a date (2006-01-02) or RFC 3339 time.
+func parseReextractSince(value string) (reextractFilter, error) {
+	if value == "" {
+		return reextractFilter{}, nil
+	}
+	if version, err := strconv.Atoi(value); err == nil && version > 0 {
+		return reextractFilter{version: version}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return reextractFilter{before: t}, nil
+	}
+	if day, err := time.ParseInLocation("2006-01-02", value, time.Local); err == nil {
+		return reextractFilter{before: day}, nil
+	}
+	return reextractFilter{}, fmt.Errorf("since must be an extractor version, a date (2006-01-02) or an RFC 3339 time")
+}
+
+func (f reextractFilter) matches(metadata PageMetadata) bool {
+	switch {
+	case f.version > 0:
+		return metadata.ExtractorVersion < f.version
+	case !f.before.IsZero():
+		extracted := metadata.Timestamp
+		if metadata.ExtractedAt != nil {
+			extracted = *metadata.ExtractedAt
+		}
+		return extracted.Before(f.before)
+	}
+	return true
+}
+
+// reextractPage extracts a page's derived content again from its stored
+// original: the markdown of a PDF from the PDF, and titles that fell back to
+// the URL from the HTML. The text indexed is extracted again when the page
+// is reindexed.
+func reextractPage(id string, metadata *PageMetadata) error {
+	if metadata.PDFFilename != "" {
+		pdf, err := ioutil.ReadFile(filepath.Join(pagesDir, metadata.PDFFilename))
+		if err != nil {
+			return err
+		}
+		content, err := extractPDF(pdf)
+		if err != nil {
+			return err
+		}
+		if content.Title != "" && (metadata.Title == "" || metadata.Title == metadata.URL) {
+			metadata.Title = content.Title
+		}
+		markdown := pdfMarkdown(metadata.Title, metadata.URL, content.Text)
+		metadata.PDFPages = content.Pages
+		if metadata.MDFilename == "" {
+			metadata.MDFilename = contentFileName(id, ".md")
+			metadata.HasMarkdown = true
+		}
+		if err := writeContentFile(filepath.Join(pagesDir, metadata.MDFilename), []byte(markdown)); err != nil {
+			return err
+		}
+		// The markdown of a PDF is derived, so its hash follows it for
+		// duplicates to keep being found
+		metadata.ContentHash = contentHash([]byte(markdown))
+	} else if metadata.HTMLFilename != "" && (metadata.Title == "" || metadata.Title == metadata.URL) {
+		html, err := readContentFile(filepath.Join(pagesDir, metadata.HTMLFilename))
+		if err != nil {
+			return err
+		}
+		if title := extractTitle(string(html)); title != "" {
+			metadata.Title = title
+		}
+	}
+	return nil
+}
+
+// reextractPages extracts the pages selected by filter again, keeping their
+// IDs and capture times, and marks them for reindexing. It returns how many
+// pages were extracted again.
+func reextractPages(ctx context.Context, filter reextractFilter, now time.Time) (int, error) {
+	indexMu.Lock()
+	defer indexMu.Unlock()
+	pages, err := listPages()
+	if err != nil {
+		return 0, err
+	}
+	count := 0
+	for i, page := range pages {
+		if err := ctx.Err(); err != nil {
+			return count, err
+		}
+		reportProgress(ctx, i, len(pages))
+		if !filter.matches(page.PageMetadata) {
+			continue
+		}
+		metadata := page.PageMetadata
+		if err := reextractPage(page.ID, &metadata); err != nil {
+			log.Printf("Error extracting %s again: %v", page.ID, err)
+			continue
+		}
+		metadata.ExtractorVersion = extractorVersion
+		metadata.ExtractedAt = &now
+		// The text may have changed, so it is summarized again
+		metadata.Summary = ""
+		metadata.Indexed = false
+		if err := writeMetadata(page.ID, metadata); err != nil {
+			return count, err
+		}
+		count++
+	}
+	reportProgress(ctx, len(pages), len(pages))
+	return count, nil
+}
+
+// handleReextract starts a job extracting pages again, those extracted
+// before the since query parameter's version or date, or all of them, and
+// reindexing them.
+func handleReextract(w http.ResponseWriter, r *http.Request) {
+	since := r.URL.Query().Get("since")
+	if _, err := parseReextractSince(since); err != nil {
+		writeError(w, http.StatusBadRequest, ErrInvalidRequest, err.Error())
+		return
+	}
+	job, err := startJob(jobReextract, requestUser(r), reextractParams{Since: since})
+	if err != nil {
+		log.Printf("Error starting re-extraction: %v", err)
+		writeError(w, http.StatusInternalServerError, ErrInternal, "Failed to start re-extraction")
+		return
+	}
+	writeJob(w, job)
+}
+
+// reextractParams are the parameters of a reextract job.
+type reextractParams struct {
+	Since string `json:"since,omitempty"`
+}
+
+// runReextractJob extracts the pages a job selects again and reindexes
+// them, returning how many there were.
+func runReextractJob(ctx context.Context, job *Job) (interface{}, error) {
+	var params reextractParams
+	if err := json.Unmarshal(job.Params, &params); err != nil {
+		return nil, err
+	}
+	filter, err := parseReextractSince(params.Since)
+	if err != nil {
+		return nil, err
+	}
+	count, err := reextractPages(ctx, filter, time.Now())
+	if err != nil {
+		return nil, fmt.Errorf("failed after %d pages: %w", count, err)
+	}
+	log.Printf("Extracted %d pages again", count)
+	indexExistingFiles(ctx)
+	return map[string]int{"pages": count}, ctx.Err()
+}
+
+func runReextractCommand(args []string) {
+	flags := flag.NewFlagSet("reextract", flag.ExitOnError)
+	since := flags.String("since", "", "only pages extracted before this extractor version or date")
+	flags.Parse(args)
+	if flags.NArg() != 0 {
+		fmt.Fprintln(os.Stderr, "Usage: daemon reextract [-since VERSION|DATE]")
+		os.Exit(2)
+	}
+	filter, err := parseReextractSince(*since)
+	if err != nil {
+		log.Fatal(err)
+	}
+	count, err := reextractPages(context.Background(), filter, time.Now())
+	if err != nil {
+		log.Fatalf("Re-extraction failed after %d pages: %v", count, err)
+	}
+	log.Printf("Extracted %d pages again; they will be reindexed when the daemon next runs", count)
+}
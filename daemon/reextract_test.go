@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseReextractSince(t *testing.T) {
+	day := time.Date(2024, 3, 1, 0, 0, 0, 0, time.Local)
+	tests := []struct {
+		value   string
+		want    reextractFilter
+		wantErr bool
+	}{
+		{"", reextractFilter{}, false},
+		{"2", reextractFilter{version: 2}, false},
+		{"2024-03-01", reextractFilter{before: day}, false},
+		{"2024-03-01T12:00:00Z", reextractFilter{before: time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)}, false},
+		{"0", reextractFilter{}, true},
+		{"last week", reextractFilter{}, true},
+	}
+	for _, tt := range tests {
+		got, err := parseReextractSince(tt.value)
+		if (err != nil) != tt.wantErr || !got.before.Equal(tt.want.before) || got.version != tt.want.version {
+			t.Errorf("parseReextractSince(%q) = %+v, %v, want %+v", tt.value, got, err, tt.want)
+		}
+	}
+}
+
+func TestReextractPages(t *testing.T) {
+	withPagesDir(t)
+	withIndex(t, nil)
+	pdf := testPDF("<< /Title (Quarterly report) >>", pdfStream("", "BT (Revenue grew) Tj ET", true))
+	pdfID, err := archivePDF(context.Background(), pdf, "https://example.com/report.pdf", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	untitledID, _ := storePage(PageMetadata{URL: "https://example.com/untitled", Title: "https://example.com/untitled"},
+		"<title>Found later</title><p>Body</p>", "")
+	currentID, _ := storePage(PageMetadata{URL: "https://example.com/current", Title: "https://example.com/current"},
+		"<title>Current</title><p>Body</p>", "")
+
+	// Pages stored by an older extractor, one of which lost its PDF's text
+	for _, id := range []string{pdfID, untitledID} {
+		metadata, _ := readMetadata(id)
+		metadata.ExtractorVersion = 0
+		metadata.Indexed = true
+		writeMetadata(id, metadata)
+	}
+	before, _ := readMetadata(pdfID)
+	ioutil.WriteFile(filepath.Join(pagesDir, before.MDFilename), []byte("# Quarterly report\n"), 0644)
+
+	now := time.Now()
+	count, err := reextractPages(context.Background(), reextractFilter{version: extractorVersion}, now)
+	if err != nil || count != 2 {
+		t.Fatalf("reextractPages() = %d, %v, want the two old pages", count, err)
+	}
+
+	after, _ := readMetadata(pdfID)
+	markdown, _ := ioutil.ReadFile(filepath.Join(pagesDir, after.MDFilename))
+	if !strings.Contains(string(markdown), "Revenue grew") {
+		t.Errorf("markdown = %q, want the PDF's text again", markdown)
+	}
+	if !after.Timestamp.Equal(before.Timestamp) || after.Indexed || after.ExtractorVersion != extractorVersion ||
+		after.ExtractedAt == nil || !after.ExtractedAt.Equal(now) || after.ContentHash != contentHash(markdown) {
+		t.Errorf("re-extracted PDF metadata = %+v", after)
+	}
+	if untitled, _ := readMetadata(untitledID); untitled.Title != "Found later" {
+		t.Errorf("title = %q, want it extracted from the HTML", untitled.Title)
+	}
+	if current, _ := readMetadata(currentID); current.Title != current.URL || current.ExtractedAt != nil {
+		t.Errorf("page from the current extractor = %+v, want it left alone", current)
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/blevesearch/bleve"
+	"github.com/blevesearch/bleve/search/query"
+)
+
+const (
+	// relatedTerms is how many of a page's significant words its related
+	// pages are searched by.
+	relatedTerms = 12
+	// defaultRelatedLimit is how many related pages are returned unless
+	// the request asks for another number, up to searchResultLimit.
+	defaultRelatedLimit = 10
+)
+
+// relatedPages returns up to limit other pages about what page is about:
+// those matching the most of its significant words, weighed by how
+// significant each is. Other captures of its URL and near-duplicates of it
+// aren't new reads and are left out.
+func relatedPages(ctx context.Context, page Page, options SearchOptions, localizer Localizer, limit int) ([]SearchResult, error) {
+	related := []SearchResult{}
+	text := page.Note
+	if !page.Bookmark {
+		var err error
+		if text, err = readPageText(page.PageMetadata); err != nil {
+			return nil, err
+		}
+	}
+	terms, err := significantTerms(ctx, page.Title+"\n"+text, 1)
+	if err != nil || len(terms) == 0 {
+		return related, err
+	}
+	if len(terms) > relatedTerms {
+		terms = terms[:relatedTerms]
+	}
+	queries := make([]query.Query, len(terms))
+	for i, term := range terms {
+		q := bleve.NewMatchQuery(term.Term)
+		q.SetBoost(term.Score / terms[0].Score)
+		queries[i] = q
+	}
+	boolean := bleve.NewBooleanQuery()
+	boolean.AddMust(bleve.NewDisjunctionQuery(queries...))
+	boolean.AddMustNot(bleve.NewDocIDQuery([]string{page.ID}))
+
+	results, err := runSearch(ctx, boolean, options, localizer)
+	if err != nil {
+		return nil, err
+	}
+	for _, result := range results {
+		if len(related) == limit {
+			break
+		}
+		if result.URL == page.URL || nearDuplicate(result.simHash, page.SimHash) {
+			continue
+		}
+		related = append(related, result)
+	}
+	return related, nil
+}
+
+// handlePageRelated lists the pages related to a page, for reading next,
+// as search results. limit sets how many, 10 by default.
+func handlePageRelated(w http.ResponseWriter, r *http.Request) {
+	page, err := loadPage(r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusNotFound, ErrNotFound, "Page not found")
+		return
+	}
+	ctx, span := startRequestSpan(r, "related")
+	defer span.End()
+	limit := defaultRelatedLimit
+	if value := r.URL.Query().Get("limit"); value != "" {
+		if limit, err = strconv.Atoi(value); err != nil || limit < 1 || limit > searchResultLimit {
+			writeError(w, http.StatusBadRequest, ErrInvalidRequest, "limit must be between 1 and "+strconv.Itoa(searchResultLimit))
+			return
+		}
+	}
+	if !allowSearch(w, r) {
+		return
+	}
+
+	options := SearchOptions{Highlight: "none", Sort: "score", Owner: requestUser(r)}
+	results, err := relatedPages(ctx, page, options, localizerFor(r), limit)
+	span.RecordError(err)
+	if err != nil {
+		log.Printf("Error finding pages related to %s: %v", page.ID, err)
+		writeError(w, http.StatusServiceUnavailable, ErrIndexUnavailable, "Search failed")
+		return
+	}
+	writeSearchResults(w, results, options)
+}
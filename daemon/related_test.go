@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRelatedPages(t *testing.T) {
+	withPagesDir(t)
+	withIndex(t, nil)
+	ids := map[string]string{}
+	for _, page := range []struct{ name, url, text string }{
+		{"helm", "https://helm.example/", "Helm packages Kubernetes manifests as charts. Charts are templated, versioned and installed into a Kubernetes cluster."},
+		{"helm again", "https://helm.example/", "Helm packages Kubernetes manifests as charts, now with OCI registries."},
+		{"pods", "https://pods.example/", "A Kubernetes pod runs containers together. The cluster schedules pods onto nodes."},
+		{"bread", "https://bread.example/", "Sourdough loaves rise slowly overnight in a cold fridge."},
+	} {
+		id, err := storePage(PageMetadata{URL: page.url, Title: page.name}, "", page.text)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids[page.name] = id
+	}
+	indexExistingFiles(context.Background())
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/pages/{id}/related", handlePageRelated)
+	related := func(id, query string) ([]SearchResult, int) {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/pages/"+id+"/related"+query, nil))
+		var results []SearchResult
+		json.NewDecoder(rec.Body).Decode(&results)
+		return results, rec.Code
+	}
+
+	// Neither the page nor another capture of its URL is related to it
+	results, code := related(ids["helm"], "")
+	if code != http.StatusOK || len(results) != 1 || results[0].ID != ids["pods"] {
+		t.Errorf("related pages = %d %+v", code, results)
+	}
+	if results, _ := related(ids["bread"], ""); len(results) != 0 {
+		t.Errorf("pages related to bread = %+v", results)
+	}
+	if _, code := related(ids["helm"], "?limit=0"); code != http.StatusBadRequest {
+		t.Errorf("status with limit 0 = %d", code)
+	}
+	if _, code := related("missing", ""); code != http.StatusNotFound {
+		t.Errorf("status of a missing page = %d", code)
+	}
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// assetsPrefix is how stored HTML refers to a page's assets, relative to
+// its replay URL /pages/{id}/view.
+const assetsPrefix = "files/"
+
+// assetsDir holds the images, stylesheets and fonts stored with a page.
+func assetsDir(id string) string {
+	return filepath.Join(pagesDir, id+"_files")
+}
+
+// assetPath returns the file an assets-relative reference names, or false
+// if it isn't one or would escape the page's assets directory.
+func assetPath(id, ref string) (string, bool) {
+	if !strings.HasPrefix(ref, assetsPrefix) {
+		return "", false
+	}
+	name := path.Clean("/" + strings.TrimPrefix(ref, assetsPrefix))
+	if name == "/" {
+		return "", false
+	}
+	return filepath.Join(assetsDir(id), filepath.FromSlash(name)), true
+}
+
+// replayPolicy is the Content-Security-Policy for a replayed page. It only
+// lets the page load its own stored assets and inline data, and runs no
+// scripts, so nothing it shows comes from the live web.
+func replayPolicy(r *http.Request, id string) string {
+	assets := baseURL(r) + "/pages/" + id + "/" + assetsPrefix
+	return "default-src 'none'; " +
+		"img-src data: " + assets + "; " +
+		"style-src 'unsafe-inline' data: " + assets + "; " +
+		"font-src data: " + assets + "; " +
+		"media-src data: " + assets + "; " +
+		"base-uri 'none'; form-action 'none'; frame-ancestors 'self'"
+}
+
+// addIntegrity pins the stylesheets and scripts a page loads from its stored
+// assets to their current contents with subresource integrity hashes, so a
+// browser refuses them if they change.
+func addIntegrity(html, id string) string {
+	return htmlTagRe.ReplaceAllStringFunc(html, func(tag string) string {
+		parts := htmlTagRe.FindStringSubmatch(tag)
+		var attr string
+		switch strings.ToLower(parts[1]) {
+		case "link":
+			attr = "href"
+		case "script":
+			attr = "src"
+		default:
+			return tag
+		}
+		attrs := make(map[string]string)
+		for _, a := range htmlAttrRe.FindAllStringSubmatch(parts[2], -1) {
+			attrs[strings.ToLower(a[1])] = strings.Trim(a[2], `"'`)
+		}
+		if attr == "href" && !strings.EqualFold(strings.TrimSpace(attrs["rel"]), "stylesheet") {
+			return tag
+		}
+		file, ok := assetPath(id, attrs[attr])
+		if !ok {
+			return tag
+		}
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return tag
+		}
+		sum := sha256.Sum256(data)
+		// Any integrity the page came with was for the live resource
+		rest := htmlAttrRe.ReplaceAllStringFunc(parts[2], func(a string) string {
+			if strings.EqualFold(htmlAttrRe.FindStringSubmatch(a)[1], "integrity") {
+				return ""
+			}
+			return a
+		})
+		return "<" + parts[1] + rest + ` integrity="sha256-` + base64.StdEncoding.EncodeToString(sum[:]) + `"` + parts[3] + ">"
+	})
+}
+
+// handlePageView replays a page's stored HTML with its stored assets and
+// the user's highlights.
+func handlePageView(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, ErrMethodNotAllowed, "Method not allowed")
+		return
+	}
+	if !requireAction(w, "read") {
+		return
+	}
+	page, err := loadPage(r.PathValue("id"))
+	if err != nil || page.HTMLFilename == "" {
+		writeError(w, http.StatusNotFound, ErrNotFound, "Page not found")
+		return
+	}
+	data, err := readContentFile(filepath.Join(pagesDir, page.HTMLFilename))
+	if err != nil {
+		log.Printf("Error reading page %s: %v", page.ID, err)
+		writeError(w, http.StatusInternalServerError, ErrInternal, "Failed to read page")
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Content-Security-Policy", replayPolicy(r, page.ID))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Referrer-Policy", "no-referrer")
+	w.Write([]byte(addIntegrity(markHighlights(string(data), page.Highlights), page.ID)))
+}
+
+// handlePageAsset serves one of a page's stored assets.
+func handlePageAsset(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		writeError(w, http.StatusMethodNotAllowed, ErrMethodNotAllowed, "Method not allowed")
+		return
+	}
+	if !requireAction(w, "read") {
+		return
+	}
+	page, err := loadPage(r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusNotFound, ErrNotFound, "Page not found")
+		return
+	}
+	name, ok := assetPath(page.ID, assetsPrefix+r.PathValue("path"))
+	if !ok {
+		writeError(w, http.StatusNotFound, ErrNotFound, "Asset not found")
+		return
+	}
+	file, err := os.Open(name)
+	if err != nil {
+		writeError(w, http.StatusNotFound, ErrNotFound, "Asset not found")
+		return
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil || info.IsDir() {
+		writeError(w, http.StatusNotFound, ErrNotFound, "Asset not found")
+		return
+	}
+	// SVGs can carry scripts of their own
+	w.Header().Set("Content-Security-Policy", replayPolicy(r, page.ID))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	http.ServeContent(w, r, info.Name(), info.ModTime(), file)
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestAddIntegrity(t *testing.T) {
+	withPagesDir(t)
+	os.MkdirAll(filepath.Join(assetsDir("p1"), "css"), 0755)
+	ioutil.WriteFile(filepath.Join(assetsDir("p1"), "css", "site.css"), []byte("body{}"), 0644)
+	ioutil.WriteFile(filepath.Join(assetsDir("p1"), "app.js"), []byte("run()"), 0644)
+	sri := func(data string) string {
+		sum := sha256.Sum256([]byte(data))
+		return "sha256-" + base64.StdEncoding.EncodeToString(sum[:])
+	}
+
+	tests := []struct {
+		name string
+		html string
+		want string
+	}{
+		{"stylesheet", `<link rel="stylesheet" href="files/css/site.css">`, `<link rel="stylesheet" href="files/css/site.css" integrity="` + sri("body{}") + `">`},
+		{"script", `<script src="files/app.js"></script>`, `<script src="files/app.js" integrity="` + sri("run()") + `"></script>`},
+		{"self-closing", `<link rel=stylesheet href=files/css/site.css />`, `<link rel=stylesheet href=files/css/site.css integrity="` + sri("body{}") + `"/>`},
+		{"replaces live integrity", `<link rel="stylesheet" integrity="sha384-live" href="files/css/site.css">`, `<link rel="stylesheet" href="files/css/site.css" integrity="` + sri("body{}") + `">`},
+		{"other rel", `<link rel="icon" href="files/css/site.css">`, `<link rel="icon" href="files/css/site.css">`},
+		{"live stylesheet", `<link rel="stylesheet" href="https://cdn.example/s.css">`, `<link rel="stylesheet" href="https://cdn.example/s.css">`},
+		{"missing asset", `<link rel="stylesheet" href="files/gone.css">`, `<link rel="stylesheet" href="files/gone.css">`},
+		{"escaping assets", `<script src="files/../../p1.json"></script>`, `<script src="files/../../p1.json"></script>`},
+	}
+	for _, tt := range tests {
+		if got := addIntegrity(tt.html, "p1"); got != tt.want {
+			t.Errorf("%s: addIntegrity() = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestPageReplay(t *testing.T) {
+	withPagesDir(t)
+	saved := config.Capture.ContentPolicy
+	config.Capture.ContentPolicy = contentPolicyOff
+	defer func() { config.Capture.ContentPolicy = saved }()
+
+	id, err := storePage(PageMetadata{URL: "https://example.com/", Title: "Example"}, `<link rel="stylesheet" href="files/s.css"><img src="files/a.png">`, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.MkdirAll(assetsDir(id), 0755)
+	ioutil.WriteFile(filepath.Join(assetsDir(id), "s.css"), []byte("p{}"), 0644)
+	ioutil.WriteFile(filepath.Join(assetsDir(id), "a.png"), fakePNG, 0644)
+	ioutil.WriteFile(filepath.Join(pagesDir, "secret.txt"), []byte("secret"), 0644)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/pages/{id}/view", handlePageView)
+	mux.HandleFunc("/pages/{id}/files/{path...}", handlePageAsset)
+
+	tests := []struct {
+		path   string
+		status int
+		body   string
+	}{
+		{"/pages/" + id + "/view", http.StatusOK, `integrity="sha256-`},
+		{"/pages/" + id + "/files/s.css", http.StatusOK, "p{}"},
+		{"/pages/" + id + "/files/a.png", http.StatusOK, "PNG"},
+		{"/pages/" + id + "/files/missing.png", http.StatusNotFound, ""},
+		{"/pages/" + id + "/files/..%2F..%2Fsecret.txt", http.StatusNotFound, ""},
+		{"/pages/missing/view", http.StatusNotFound, ""},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://memento.local"+tt.path, nil))
+		if rec.Code != tt.status || !strings.Contains(rec.Body.String(), tt.body) {
+			t.Errorf("%s = %d %q, want %d containing %q", tt.path, rec.Code, rec.Body, tt.status, tt.body)
+		}
+		if tt.status != http.StatusOK {
+			continue
+		}
+		csp := rec.Header().Get("Content-Security-Policy")
+		if !strings.HasPrefix(csp, "default-src 'none'") || !strings.Contains(csp, "img-src data: http://memento.local/pages/"+id+"/files/;") {
+			t.Errorf("%s: Content-Security-Policy = %q", tt.path, csp)
+		}
+	}
+
+	withIndex(t, nil)
+	page, _ := loadPage(id)
+	if err := deletePage(page); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(assetsDir(id)); !os.IsNotExist(err) {
+		t.Errorf("assets left behind after delete: %v", err)
+	}
+}
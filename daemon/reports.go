@@ -0,0 +1,307 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	reportSource = "report"
+	reportURN    = "urn:memento:report:"
+	reportMonth  = "2006-01"
+	// reportListLimit is how many domains, pages and dead links a report
+	// lists.
+	reportListLimit = 10
+	// reportCheckInterval is how often the daemon looks for a month that
+	// has ended without a report.
+	reportCheckInterval = time.Hour
+)
+
+// ReportsConfig stores a report on the archive's hygiene as a page at the
+// start of every month, and mails it to Email when set.
+type ReportsConfig struct {
+	Enabled bool     `json:"enabled"`
+	Email   []string `json:"email"`
+}
+
+// Report summarizes a month of the archive: what was captured, the pages
+// taking up the most space, the links found dead and how storage grows.
+type Report struct {
+	Month        string        `json:"month"`
+	Start        time.Time     `json:"start"`
+	End          time.Time     `json:"end"`
+	Captures     int           `json:"captures"`
+	CaptureBytes int64         `json:"captureBytes"`
+	Domains      []DomainStats `json:"domains"`
+	Biggest      []ReportPage  `json:"biggest"`
+	DeadLinks    []DeadLink    `json:"deadLinks"`
+	Stats        Stats         `json:"stats"`
+}
+
+// ReportPage is a page listed in a report with its size.
+type ReportPage struct {
+	CaptureRef
+	Bytes int64 `json:"bytes"`
+}
+
+// monthStart returns the first moment of t's month.
+func monthStart(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, t.Location())
+}
+
+// buildReport reports on the month starting at start, up to now if the
+// month hasn't ended. Earlier reports aren't counted as captures.
+func buildReport(start, now time.Time) (Report, error) {
+	end := start.AddDate(0, 1, 0)
+	if end.After(now) {
+		end = now
+	}
+	report := Report{Month: start.Format(reportMonth), Start: start, End: end, Domains: []DomainStats{}, Biggest: []ReportPage{}, DeadLinks: []DeadLink{}}
+	pages, err := listPages()
+	if err != nil {
+		return Report{}, err
+	}
+	if report.Stats, err = computeStats(now); err != nil {
+		return Report{}, err
+	}
+
+	domains := make(map[string]*DomainStats)
+	dead := make(map[string]bool)
+	for _, page := range pages {
+		if page.Source == reportSource {
+			continue
+		}
+		size := pageSize(page)
+		ref := CaptureRef{ID: page.ID, URL: page.URL, Title: page.Title, Timestamp: page.Timestamp}
+		report.Biggest = append(report.Biggest, ReportPage{CaptureRef: ref, Bytes: size})
+		if !page.Timestamp.Before(start) && page.Timestamp.Before(end) {
+			report.Captures++
+			report.CaptureBytes += size
+			if u, err := url.Parse(page.URL); err == nil && u.Hostname() != "" {
+				host := strings.ToLower(u.Hostname())
+				if domains[host] == nil {
+					domains[host] = &DomainStats{Domain: host}
+				}
+				domains[host].Pages++
+				domains[host].Bytes += size
+			}
+		}
+		// Links that died during the month, once per URL
+		if c := page.LiveCheck; c != nil && c.gone() && c.DeadSince != nil && page.SupersededBy == "" && !dead[page.URL] &&
+			!c.DeadSince.Before(start) && c.DeadSince.Before(end) {
+			dead[page.URL] = true
+			report.DeadLinks = append(report.DeadLinks, DeadLink{ID: page.ID, URL: page.URL, Title: page.Title, Timestamp: page.Timestamp, LiveCheck: *c})
+		}
+	}
+
+	for _, d := range domains {
+		report.Domains = append(report.Domains, *d)
+	}
+	sort.Slice(report.Domains, func(i, j int) bool {
+		if report.Domains[i].Pages != report.Domains[j].Pages {
+			return report.Domains[i].Pages > report.Domains[j].Pages
+		}
+		return report.Domains[i].Domain < report.Domains[j].Domain
+	})
+	sort.SliceStable(report.Biggest, func(i, j int) bool {
+		return report.Biggest[i].Bytes > report.Biggest[j].Bytes
+	})
+	sort.Slice(report.DeadLinks, func(i, j int) bool {
+		return report.DeadLinks[i].DeadSince.Before(*report.DeadLinks[j].DeadSince)
+	})
+	if len(report.Domains) > reportListLimit {
+		report.Domains = report.Domains[:reportListLimit]
+	}
+	if len(report.Biggest) > reportListLimit {
+		report.Biggest = report.Biggest[:reportListLimit]
+	}
+	return report, nil
+}
+
+// title names the report after its month.
+func (report Report) title() string {
+	return "Archive report for " + report.Start.Format("January 2006")
+}
+
+// markdown renders the report as the page it is stored as and the email it
+// is sent as.
+func (report Report) markdown() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# %s\n\n", report.title())
+	fmt.Fprintf(&b, "From %s to %s.\n\n", report.Start.Format("2006-01-02"), report.End.Format("2006-01-02"))
+
+	fmt.Fprintf(&b, "## Captures\n\n")
+	fmt.Fprintf(&b, "%d pages were captured, taking %s.\n\n", report.Captures, formatBytes(report.CaptureBytes))
+	if len(report.Domains) > 0 {
+		fmt.Fprintf(&b, "Top domains:\n\n")
+		for _, d := range report.Domains {
+			fmt.Fprintf(&b, "- %s: %d pages, %s\n", d.Domain, d.Pages, formatBytes(d.Bytes))
+		}
+		b.WriteString("\n")
+	}
+
+	fmt.Fprintf(&b, "## Biggest pages\n\n")
+	for _, page := range report.Biggest {
+		fmt.Fprintf(&b, "- %s (%s), %s\n", reportTitle(page.Title, page.URL), page.URL, formatBytes(page.Bytes))
+	}
+	if len(report.Biggest) == 0 {
+		b.WriteString("The archive is empty.\n")
+	}
+	b.WriteString("\n")
+
+	fmt.Fprintf(&b, "## Dead links\n\n")
+	for _, link := range report.DeadLinks {
+		fmt.Fprintf(&b, "- %s (%s), gone since %s\n", reportTitle(link.Title, link.URL), link.URL, link.DeadSince.Format("2006-01-02"))
+	}
+	if len(report.DeadLinks) == 0 {
+		b.WriteString("No archived links were found dead.\n")
+	}
+	b.WriteString("\n")
+
+	stats := report.Stats
+	fmt.Fprintf(&b, "## Storage\n\n")
+	fmt.Fprintf(&b, "The archive holds %d pages in %s, with the index. Over the last %d days it grew by %s a day.\n\n",
+		stats.Pages, formatBytes(stats.TotalBytes), stats.GrowthWindowDays, formatBytes(int64(stats.GrowthBytesPerDay)))
+	for _, forecast := range stats.Forecast {
+		fmt.Fprintf(&b, "- In %d months: %s\n", forecast.Months, formatBytes(forecast.TotalBytes))
+	}
+	for _, warning := range stats.Warnings {
+		fmt.Fprintf(&b, "\n%s.\n", warning.Message)
+	}
+	return b.String()
+}
+
+// reportTitle returns a page's title for a report, or its URL without one.
+func reportTitle(title, pageURL string) string {
+	if title = strings.TrimSpace(title); title != "" {
+		return title
+	}
+	return pageURL
+}
+
+// storeReport stores a report as a page and mails it to the configured
+// addresses, returning the page's ID. A failure to mail is logged and
+// published, as the report is stored regardless.
+func storeReport(report Report) (string, error) {
+	metadata := PageMetadata{
+		URL:    reportURN + report.Month,
+		Title:  report.title(),
+		Tags:   []string{reportSource},
+		Source: reportSource,
+	}
+	markdown := report.markdown()
+	id, err := storePage(metadata, "", markdown)
+	if err != nil {
+		return "", err
+	}
+	if len(config.Reports.Email) > 0 {
+		if err := sendMail(config.Reports.Email, "Memento: "+report.title(), markdown); err != nil {
+			log.Printf("Error mailing %s: %v", report.title(), err)
+			publishError(id, fmt.Errorf("mailing %s: %v", report.title(), err))
+		}
+	}
+	return id, nil
+}
+
+// reportExists reports whether a report on month has been stored.
+func reportExists(month string) (bool, error) {
+	pages, err := listPages()
+	if err != nil {
+		return false, err
+	}
+	for _, page := range pages {
+		if page.Source == reportSource && page.URL == reportURN+month {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// runDueReport stores the report on the month before now's, unless it has
+// been stored already.
+func runDueReport(now time.Time) error {
+	start := monthStart(now).AddDate(0, -1, 0)
+	exists, err := reportExists(start.Format(reportMonth))
+	if err != nil || exists {
+		return err
+	}
+	report, err := buildReport(start, now)
+	if err != nil {
+		return err
+	}
+	id, err := storeReport(report)
+	if err == nil {
+		log.Printf("Stored %s as %s", report.title(), id)
+	}
+	return err
+}
+
+// watchReports stores a report on each month once it has ended.
+func watchReports() {
+	if !config.Reports.Enabled {
+		return
+	}
+	for {
+		if err := runDueReport(time.Now()); err != nil {
+			log.Printf("Error storing archive report: %v", err)
+		}
+		time.Sleep(reportCheckInterval)
+	}
+}
+
+// handleReports lists the stored reports, newest first, on GET. On POST it
+// reports on the month given as month=YYYY-MM, by default the current one
+// so far, storing the report as a page and mailing it like the monthly
+// ones.
+func handleReports(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		pages, err := listPages()
+		if err != nil {
+			log.Printf("Error listing pages: %v", err)
+			writeError(w, http.StatusInternalServerError, ErrInternal, "Failed to list pages")
+			return
+		}
+		reports := []CaptureRef{}
+		for _, page := range pages {
+			if page.Source == reportSource {
+				reports = append(reports, CaptureRef{ID: page.ID, URL: page.URL, Title: page.Title, Timestamp: page.Timestamp})
+			}
+		}
+		sort.SliceStable(reports, func(i, j int) bool {
+			return reports[i].Timestamp.After(reports[j].Timestamp)
+		})
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(reports)
+		return
+	}
+
+	now := time.Now()
+	start := monthStart(now)
+	if month := r.URL.Query().Get("month"); month != "" {
+		t, err := time.ParseInLocation(reportMonth, month, now.Location())
+		if err != nil || t.After(now) {
+			writeError(w, http.StatusBadRequest, ErrInvalidRequest, "month must be a month up to this one, as YYYY-MM")
+			return
+		}
+		start = t
+	}
+	report, err := buildReport(start, now)
+	if err != nil {
+		log.Printf("Error building archive report: %v", err)
+		writeError(w, http.StatusInternalServerError, ErrInternal, "Failed to build the report")
+		return
+	}
+	id, err := storeReport(report)
+	if err != nil {
+		log.Printf("Error storing archive report: %v", err)
+		writeError(w, http.StatusInternalServerError, ErrInternal, "Failed to store the report")
+		return
+	}
+	writeArchived(r.Context(), w, id)
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBuildReport(t *testing.T) {
+	withPagesDir(t)
+	start := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	store := func(pageURL string, at time.Time, size int) string {
+		id, err := storePage(PageMetadata{URL: pageURL, Title: pageURL, Timestamp: at}, strings.Repeat("x", size), "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return id
+	}
+	store("https://a.example/1", start.AddDate(0, 0, 3), 100)
+	store("https://a.example/2", start.AddDate(0, 0, 10), 200)
+	store("https://b.example/", start.AddDate(0, 0, 20), 5000)
+	store("https://c.example/", start.AddDate(0, -1, 0), 10000)
+	gone := store("https://d.example/", start.AddDate(-1, 0, 0), 50)
+	deadSince := start.AddDate(0, 0, 15)
+	metadata, _ := readMetadata(gone)
+	metadata.LiveCheck = &LiveCheck{Status: 404, CheckedAt: deadSince, DeadSince: &deadSince}
+	if err := writeMetadata(gone, metadata); err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := buildReport(start, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Month != "2024-05" || report.Captures != 3 || !report.End.Equal(start.AddDate(0, 1, 0)) {
+		t.Fatalf("report = %+v", report)
+	}
+	if len(report.Domains) != 2 || report.Domains[0].Domain != "a.example" || report.Domains[0].Pages != 2 {
+		t.Errorf("domains = %+v", report.Domains)
+	}
+	if len(report.Biggest) != 5 || report.Biggest[0].URL != "https://c.example/" {
+		t.Errorf("biggest = %+v", report.Biggest)
+	}
+	if len(report.DeadLinks) != 1 || report.DeadLinks[0].ID != gone {
+		t.Errorf("dead links = %+v", report.DeadLinks)
+	}
+	markdown := report.markdown()
+	for _, want := range []string{"# Archive report for May 2024", "3 pages were captured", "- a.example: 2 pages", "https://d.example/), gone since 2024-05-16"} {
+		if !strings.Contains(markdown, want) {
+			t.Errorf("markdown lacks %q:\n%s", want, markdown)
+		}
+	}
+
+	// The monthly report is only stored once, and not counted by later ones
+	for i := 0; i < 2; i++ {
+		if err := runDueReport(now); err != nil {
+			t.Fatal(err)
+		}
+	}
+	pages, _ := listPages()
+	reports := 0
+	for _, page := range pages {
+		if page.Source == reportSource {
+			reports++
+			if page.URL != reportURN+"2024-05" || page.Title != "Archive report for May 2024" {
+				t.Errorf("report page = %+v", page.PageMetadata)
+			}
+		}
+	}
+	if reports != 1 {
+		t.Errorf("stored %d reports, want 1", reports)
+	}
+	if later, _ := buildReport(monthStart(time.Now()), time.Now()); later.Captures != 0 {
+		t.Errorf("a later report counted %d captures", later.Captures)
+	}
+}
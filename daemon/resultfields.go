@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// resultFields maps the names the fields parameter takes to the result
+// fields they select, named as in the JSON of SearchResult.
+var resultFields = map[string]func(SearchResult) interface{}{
+	"id":         func(r SearchResult) interface{} { return r.ID },
+	"url":        func(r SearchResult) interface{} { return r.URL },
+	"title":      func(r SearchResult) interface{} { return r.Title },
+	"snippet":    func(r SearchResult) interface{} { return r.Snippet },
+	"score":      func(r SearchResult) interface{} { return r.Score },
+	"time":       func(r SearchResult) interface{} { return r.Time },
+	"savedOn":    func(r SearchResult) interface{} { return r.SavedOn },
+	"savedAgo":   func(r SearchResult) interface{} { return r.SavedAgo },
+	"thumbnail":  func(r SearchResult) interface{} { return r.Thumbnail },
+	"highlights": func(r SearchResult) interface{} { return r.Highlights },
+	"instance":   func(r SearchResult) interface{} { return r.Instance },
+	"pdfPages":   func(r SearchResult) interface{} { return r.PDFPages },
+	"duplicates": func(r SearchResult) interface{} { return r.Duplicates },
+}
+
+// parseResultFields reads a comma-separated list of result fields, nil
+// for all of them when empty.
+func parseResultFields(value string) ([]string, error) {
+	if strings.TrimSpace(value) == "" {
+		return nil, nil
+	}
+	var fields []string
+	for _, field := range strings.Split(value, ",") {
+		if field = strings.TrimSpace(field); field != "" {
+			fields = append(fields, field)
+		}
+	}
+	return fields, validateResultFields(fields)
+}
+
+// validateResultFields rejects fields results don't have.
+func validateResultFields(fields []string) error {
+	for _, field := range fields {
+		if resultFields[field] == nil {
+			names := make([]string, 0, len(resultFields))
+			for name := range resultFields {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			return fmt.Errorf("fields must be among %s", strings.Join(names, ", "))
+		}
+	}
+	return nil
+}
+
+// wants reports whether a search's results are to include field.
+func (o SearchOptions) wants(field string) bool {
+	if o.Fields == nil {
+		return true
+	}
+	for _, f := range o.Fields {
+		if f == field {
+			return true
+		}
+	}
+	return false
+}
+
+// storedFields are the stored fields a search's hits need loading for the
+// result fields it asks for. The text and summary, the largest by far,
+// are only loaded for snippets.
+func (o SearchOptions) storedFields() []string {
+	if o.wants("snippet") || o.wants("highlights") {
+		return hitFields
+	}
+	var fields []string
+	for _, field := range hitFields {
+		if field != "content" && field != "summary" {
+			fields = append(fields, field)
+		}
+	}
+	return fields
+}
+
+// selectFields returns results with only the fields asked for, or as they
+// are when all are.
+func selectFields(results []SearchResult, fields []string) interface{} {
+	if fields == nil {
+		return results
+	}
+	selected := make([]map[string]interface{}, len(results))
+	for i, result := range results {
+		selected[i] = make(map[string]interface{}, len(fields))
+		for _, field := range fields {
+			selected[i][field] = resultFields[field](result)
+		}
+	}
+	return selected
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestResultFields(t *testing.T) {
+	withIndex(t, map[string]PageDocument{
+		"cats": {URL: "https://cats.example/", Title: "Cats", Content: "Kittens sleep most of the day."},
+	})
+	search := func(params string) (*httptest.ResponseRecorder, []byte) {
+		t.Helper()
+		w := httptest.NewRecorder()
+		handleSearch(w, httptest.NewRequest(http.MethodGet, "/search?q=kittens"+params, nil))
+		return w, w.Body.Bytes()
+	}
+
+	_, body := search("&fields=url,+title")
+	var results []map[string]interface{}
+	json.Unmarshal(body, &results)
+	want := []map[string]interface{}{{"url": "https://cats.example/", "title": "Cats"}}
+	if !reflect.DeepEqual(results, want) {
+		t.Errorf("results = %s", body)
+	}
+
+	_, body = search("&fields=url&group_by=domain")
+	var groups []struct {
+		Key  string                   `json:"key"`
+		Hits []map[string]interface{} `json:"hits"`
+	}
+	json.Unmarshal(body, &groups)
+	if len(groups) != 1 || groups[0].Key != "cats.example" || !reflect.DeepEqual(groups[0].Hits, []map[string]interface{}{{"url": "https://cats.example/"}}) {
+		t.Errorf("grouped results = %s", body)
+	}
+
+	if w, _ := search("&fields=url,body"); w.Code != http.StatusBadRequest {
+		t.Errorf("status with an unknown field = %d", w.Code)
+	}
+}
+
+func TestStoredFields(t *testing.T) {
+	for _, tt := range []struct {
+		fields []string
+		want   []string
+	}{
+		{nil, hitFields},
+		{[]string{"snippet"}, hitFields},
+		{[]string{"url", "score"}, []string{"url", "title", "time", "simHash"}},
+	} {
+		if got := (SearchOptions{Fields: tt.fields}).storedFields(); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("storedFields for %v = %v, want %v", tt.fields, got, tt.want)
+		}
+	}
+}
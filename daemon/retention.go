@@ -0,0 +1,199 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// prunedPage is a page removed by retention, or that would be on a dry run,
+// with the rule that removed it: "expired", "age", "domain" or "disk".
+type prunedPage struct {
+	ID        string    `json:"id"`
+	URL       string    `json:"url"`
+	Title     string    `json:"title"`
+	Timestamp time.Time `json:"timestamp"`
+	Bytes     int64     `json:"bytes"`
+	Reason    string    `json:"reason"`
+}
+
+// retentionReport lists what a retention pass removed, or would remove on a
+// dry run.
+type retentionReport struct {
+	DryRun         bool         `json:"dryRun"`
+	Removed        []prunedPage `json:"removed"`
+	ReclaimedBytes int64        `json:"reclaimedBytes"`
+}
+
+// kept reports whether a page is starred or tagged to be kept whatever the
+// limits.
+func (c RetentionConfig) kept(page Page) bool {
+	if page.Starred {
+		return true
+	}
+	for _, tag := range page.Tags {
+		for _, keep := range c.KeepTags {
+			if strings.EqualFold(tag, keep) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// watchRetention prunes pages on the retention schedule, which also removes
+// expired captures when no limit is set. Nothing is removed while the delete
+// action is disabled.
+func watchRetention() {
+	for {
+		time.Sleep(time.Duration(config.Retention.IntervalMinutes) * time.Minute)
+		if !actionEnabled("delete") {
+			continue
+		}
+		report, err := applyRetention(config.Retention, time.Now(), false)
+		if err != nil {
+			log.Printf("Retention failed: %v", err)
+			publishError("", fmt.Errorf("retention: %v", err))
+			continue
+		}
+		if len(report.Removed) > 0 {
+			log.Printf("Retention removed %d pages, reclaiming %s", len(report.Removed), formatBytes(report.ReclaimedBytes))
+		}
+	}
+}
+
+// retentionCandidates returns the pages the limits remove, oldest first
+// within each rule. Expired captures go first, then pages too old, then those beyond the limit
+// for their host, then the oldest of the rest until the others fit in
+// MaxTotalBytes.
+func retentionCandidates(c RetentionConfig, pages []Page, now time.Time) []prunedPage {
+	var removed []prunedPage
+	remove := func(page Page, reason string) {
+		removed = append(removed, prunedPage{ID: page.ID, URL: page.URL, Title: page.Title,
+			Timestamp: page.Timestamp, Bytes: pageSize(page) + assetsSize(page.ID), Reason: reason})
+	}
+
+	// Newest first, so the pages each rule keeps come first
+	sort.SliceStable(pages, func(i, j int) bool {
+		return pages[i].Timestamp.After(pages[j].Timestamp)
+	})
+	var rest []Page
+	for _, page := range pages {
+		switch {
+		case expired(page, now):
+			remove(page, "expired")
+		case c.kept(page):
+			rest = append(rest, page)
+		case c.MaxAgeDays > 0 && now.Sub(page.Timestamp) > time.Duration(c.MaxAgeDays)*24*time.Hour:
+			remove(page, "age")
+		default:
+			rest = append(rest, page)
+		}
+	}
+
+	if c.MaxPagesPerDomain > 0 {
+		perHost := make(map[string]int)
+		var within []Page
+		for _, page := range rest {
+			host := ""
+			if u, err := url.Parse(page.URL); err == nil {
+				host = strings.ToLower(u.Hostname())
+			}
+			if host != "" && !c.kept(page) {
+				if perHost[host]++; perHost[host] > c.MaxPagesPerDomain {
+					remove(page, "domain")
+					continue
+				}
+			}
+			within = append(within, page)
+		}
+		rest = within
+	}
+
+	if c.MaxTotalBytes > 0 {
+		var total int64
+		sizes := make([]int64, len(rest))
+		for i, page := range rest {
+			sizes[i] = pageSize(page) + assetsSize(page.ID)
+			total += sizes[i]
+		}
+		for i := len(rest) - 1; i >= 0 && total > c.MaxTotalBytes; i-- {
+			if c.kept(rest[i]) {
+				continue
+			}
+			remove(rest[i], "disk")
+			total -= sizes[i]
+		}
+	}
+	return removed
+}
+
+// assetsSize is the size of a page's assets directory, if it has one.
+func assetsSize(id string) int64 {
+	size, _ := dirSize(assetsDir(id))
+	return size
+}
+
+// applyRetention removes the pages the limits select, deleting their files
+// and index entries, or only lists them on a dry run. Expired pages go to the
+// trash, as if their owner had deleted them.
+func applyRetention(c RetentionConfig, now time.Time, dryRun bool) (retentionReport, error) {
+	report := retentionReport{DryRun: dryRun, Removed: []prunedPage{}}
+	pages, err := listPages()
+	if err != nil {
+		return report, err
+	}
+	for _, pruned := range retentionCandidates(c, pages, now) {
+		if !dryRun {
+			page, err := loadPage(pruned.ID)
+			if err != nil {
+				continue // deleted since listing
+			}
+			remove := deletePage
+			if pruned.Reason == "expired" {
+				remove = trashPage
+			}
+			if err := remove(page); err != nil {
+				log.Printf("Error pruning page %s: %v", page.ID, err)
+				continue
+			}
+		}
+		report.Removed = append(report.Removed, pruned)
+		report.ReclaimedBytes += pruned.Bytes
+	}
+	return report, nil
+}
+
+// handleRetention reports what the configured retention limits would remove
+// on GET, and removes it on POST, which is refused when the delete action
+// is disabled.
+func handleRetention(w http.ResponseWriter, r *http.Request) {
+	dryRun := true
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		if !requireAction(w, "delete") {
+			return
+		}
+		dryRun = false
+	default:
+		writeError(w, http.StatusMethodNotAllowed, ErrMethodNotAllowed, "Method not allowed")
+		return
+	}
+	report, err := applyRetention(config.Retention, time.Now(), dryRun)
+	if err != nil {
+		log.Printf("Retention failed: %v", err)
+		writeError(w, http.StatusInternalServerError, ErrInternal, "Retention failed")
+		return
+	}
+	if !dryRun && len(report.Removed) > 0 {
+		log.Printf("Retention removed %d pages, reclaiming %s", len(report.Removed), formatBytes(report.ReclaimedBytes))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRetentionCandidates(t *testing.T) {
+	withPagesDir(t)
+	now := time.Now()
+	days := func(n int) time.Time { return now.Add(-time.Duration(n) * 24 * time.Hour) }
+	store := func(url string, captured time.Time, body string, tags ...string) {
+		t.Helper()
+		if _, err := storePage(PageMetadata{URL: url, Title: url, Timestamp: captured, Tags: tags}, "", body); err != nil {
+			t.Fatal(err)
+		}
+	}
+	store("https://a.example/1", days(400), "old")
+	store("https://a.example/2", days(30), "a two")
+	store("https://a.example/3", days(20), "a three")
+	store("https://A.example/4", days(10), "a four")
+	store("https://b.example/1", days(500), "b old but kept", "keep")
+	store("https://b.example/2", days(5), strings.Repeat("b", 1000))
+	store("file:///notes.pdf", days(15), "local")
+	pages, err := listPages()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	size := make(map[string]int64)
+	for _, page := range pages {
+		size[page.URL] = pageSize(page)
+	}
+
+	tests := []struct {
+		name   string
+		config RetentionConfig
+		want   []string // URL and reason
+	}{
+		{"off", RetentionConfig{}, nil},
+		{"age", RetentionConfig{MaxAgeDays: 365, KeepTags: []string{"Keep"}},
+			[]string{"https://a.example/1 age"}},
+		{"domain", RetentionConfig{MaxPagesPerDomain: 2},
+			[]string{"https://a.example/2 domain", "https://a.example/1 domain"}},
+		{"domain keeps tagged", RetentionConfig{MaxPagesPerDomain: 1, KeepTags: []string{"keep"}},
+			[]string{"https://a.example/3 domain", "https://a.example/2 domain", "https://a.example/1 domain"}},
+		// The oldest go until the newest two and the kept page fit
+		{"disk", RetentionConfig{MaxTotalBytes: size["https://b.example/2"] + size["https://A.example/4"] + size["https://b.example/1"], KeepTags: []string{"keep"}},
+			[]string{"https://a.example/1 disk", "https://a.example/2 disk", "https://a.example/3 disk", "file:///notes.pdf disk"}},
+		{"combined", RetentionConfig{MaxAgeDays: 365, MaxPagesPerDomain: 2},
+			[]string{"https://a.example/1 age", "https://b.example/1 age", "https://a.example/2 domain"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, p := range retentionCandidates(tt.config, append([]Page(nil), pages...), now) {
+				got = append(got, p.URL+" "+p.Reason)
+			}
+			if tt.config.MaxTotalBytes == 0 {
+				sort.Strings(got)
+				sort.Strings(tt.want)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("removed %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHandleRetention(t *testing.T) {
+	withPagesDir(t)
+	withIndex(t, nil)
+	saved := config
+	defer func() { config = saved }()
+	config.Retention = RetentionConfig{MaxAgeDays: 30}
+
+	oldID, err := storePage(PageMetadata{URL: "https://example.com/old", Timestamp: time.Now().AddDate(0, 0, -60)}, "", "old")
+	if err != nil {
+		t.Fatal(err)
+	}
+	newID, err := storePage(PageMetadata{URL: "https://example.com/new"}, "", "new")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		method   string
+		disabled bool
+		status   int
+		removed  []string
+		exists   bool
+	}{
+		{"dry run", http.MethodGet, false, http.StatusOK, []string{oldID}, true},
+		{"disabled", http.MethodPost, true, http.StatusForbidden, nil, true},
+		{"prune", http.MethodPost, false, http.StatusOK, []string{oldID}, false},
+		{"nothing left", http.MethodPost, false, http.StatusOK, []string{}, false},
+		{"put", http.MethodPut, false, http.StatusMethodNotAllowed, nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config.DisabledActions = nil
+			if tt.disabled {
+				config.DisabledActions = []string{"delete"}
+			}
+			w := httptest.NewRecorder()
+			handleRetention(w, httptest.NewRequest(tt.method, "/retention", nil))
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+			if tt.removed != nil {
+				var report retentionReport
+				if err := json.NewDecoder(w.Body).Decode(&report); err != nil {
+					t.Fatal(err)
+				}
+				ids := []string{}
+				for _, p := range report.Removed {
+					ids = append(ids, p.ID)
+				}
+				if !reflect.DeepEqual(ids, tt.removed) {
+					t.Errorf("removed %v, want %v", ids, tt.removed)
+				}
+			}
+			if _, err := os.Stat(metadataPath(oldID)); (err == nil) != tt.exists {
+				t.Errorf("old page exists = %v, want %v", err == nil, tt.exists)
+			}
+			if _, err := os.Stat(metadataPath(newID)); err != nil {
+				t.Errorf("new page removed: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// apiPrefix is where the current version of the API is served. Its routes
+// are also served without the prefix for clients written before it.
+const apiPrefix = "/api/v1"
+
+// route is an API endpoint: the methods it answers and, for endpoints that
+// take a request body, the media types they accept.
+type route struct {
+	pattern string
+	methods []string
+	accepts []string
+	handler http.HandlerFunc
+}
+
+// middleware wraps a handler with behaviour shared by several endpoints.
+type middleware func(http.Handler) http.Handler
+
+var jsonBody = []string{"application/json"}
+
+// apiRoutes lists the endpoints of the API, relative to apiPrefix.
+func apiRoutes() []route {
+	get := []string{http.MethodGet}
+	post := []string{http.MethodPost}
+	files := []string{http.MethodGet, http.MethodHead}
+	return []route{
+		{"/search", []string{http.MethodGet, http.MethodPost}, jsonBody, rateLimited(searchLimiter, false, handleSearch)},
+		{"/search/clicks", post, jsonBody, handleSearchClick},
+		{"/search/semantic", get, nil, rateLimited(searchLimiter, false, handleSemanticSearch)},
+		{"/search/{template}", get, nil, rateLimited(searchLimiter, false, handleTemplateSearch)},
+		{"/history/searches", []string{http.MethodGet, http.MethodDelete}, nil, handleSearchHistory},
+		{"/history/searches/stats", get, nil, handleSearchHistoryStats},
+		{"/suggest", get, nil, rateLimited(searchLimiter, false, handleSuggest)},
+		{"/ask", post, jsonBody, rateLimited(searchLimiter, false, handleAsk)},
+		{"/saved-searches", []string{http.MethodGet, http.MethodPost}, jsonBody, handleSavedSearches},
+		{"/saved-searches/{id}", []string{http.MethodGet, http.MethodDelete}, nil, userSavedSearch(handleSavedSearch)},
+		{"/saved-searches/{id}/results", get, nil, rateLimited(searchLimiter, false, userSavedSearch(handleSavedSearchResults))},
+		{"/saved-searches/{id}/alert", []string{http.MethodPut, http.MethodDelete}, jsonBody, userSavedSearch(handleSavedSearchAlert)},
+		{"/feeds", []string{http.MethodGet, http.MethodPost}, jsonBody, handleFeeds},
+		{"/feeds/{id}", []string{http.MethodGet, http.MethodDelete}, nil, userFeed(handleFeed)},
+		{"/feeds/{id}/poll", post, nil, userFeed(handleFeedPoll)},
+		{"/feed", get, nil, handleArchiveFeed},
+		{"/changes", get, nil, handleChanges},
+		{"/opds", get, nil, handleOPDSCatalog},
+		{"/opds/pages/{id}", get, nil, userPage(handleOPDSEpub)},
+		{"/export", get, nil, adminOnly(handleExport)},
+		{"/export/hold", []string{http.MethodGet, http.MethodPost}, jsonBody, handleHoldExport},
+		// Imports take JSON, bookmark files or read-later exports
+		{"/import", post, nil, rateLimited(ingestLimiter, false, adminOnly(handleImport))},
+		{"/import/memento", post, jsonBody, rateLimited(ingestLimiter, false, adminOnly(handleMementoImport))},
+		{"/archive", post, []string{"application/json", pdfMediaType}, rateLimited(ingestLimiter, false, handleArchive)},
+		{"/archive/site", []string{http.MethodGet, http.MethodPost}, jsonBody, rateLimited(ingestLimiter, true, handleSiteCrawls)},
+		{"/archive/site/{id}", []string{http.MethodGet, http.MethodDelete}, nil, handleSiteCrawl},
+		{"/bookmarks", post, jsonBody, rateLimited(ingestLimiter, false, handleBookmarks)},
+		{"/bookmarks/{id}/capture", post, nil, rateLimited(ingestLimiter, false, userPage(handleBookmarkCapture))},
+		{"/pages", get, nil, handlePages},
+		{"/pages/{id}", []string{http.MethodGet, http.MethodPatch, http.MethodDelete}, jsonBody, userPage(handlePage)},
+		{"/pages/{id}/read", get, nil, userPage(handleReader)},
+		{"/pages/{id}/screenshot", files, nil, userPage(handlePageScreenshot)},
+		{"/pages/{id}/pdf", files, nil, userPage(handlePagePDF)},
+		{"/pages/{id}/viewer", get, nil, userPage(handlePDFViewer)},
+		{"/pages/{id}/thumbnail", files, nil, userPage(handlePageThumbnail)},
+		{"/pages/{id}/fidelity", get, nil, userPage(handlePageFidelity)},
+		{"/pages/{id}/drift", get, nil, userPage(handlePageDrift)},
+		{"/pages/{id}/diff", get, nil, userPage(handlePageDiff)},
+		{"/pages/{id}/similar", get, nil, userPage(handlePageSimilar)},
+		{"/pages/{id}/related", get, nil, rateLimited(searchLimiter, false, userPage(handlePageRelated))},
+		{"/pages/{id}/recapture", post, jsonBody, rateLimited(ingestLimiter, false, userPage(handlePageRecapture))},
+		{"/pages/{id}/view", get, nil, userPage(handlePageView)},
+		{"/pages/{id}/files/{path...}", files, nil, userPage(handlePageAsset)},
+		{"/pages/{id}/assets", post, nil, userPage(handlePageAssets)},
+		{"/pages/{id}/singlefile", get, nil, userPage(handlePageSingleFile)},
+		{"/pages/{id}/annotations", []string{http.MethodGet, http.MethodPost}, jsonBody, userPage(handlePageAnnotations)},
+		{"/pages/{id}/annotations/{annotation}", []string{http.MethodDelete}, nil, userPage(handlePageAnnotation)},
+		{"/pages/{id}/highlights", []string{http.MethodGet, http.MethodPost}, jsonBody, userPage(handlePageHighlights)},
+		{"/pages/{id}/highlights/{highlight}", []string{http.MethodDelete}, nil, userPage(handlePageHighlight)},
+		{"/trash", get, nil, handleTrash},
+		{"/trash/{id}/restore", post, nil, userTrashedPage(handleTrashRestore)},
+		{"/highlights", post, jsonBody, rateLimited(ingestLimiter, false, handleHighlights)},
+		{"/reindex", post, nil, adminOnly(handleReindex)},
+		{"/reextract", post, nil, adminOnly(handleReextract)},
+		{"/gc", post, nil, adminOnly(handleGC)},
+		{"/retention", []string{http.MethodGet, http.MethodPost}, nil, adminOnly(handleRetention)},
+		{"/capabilities", get, nil, handleCapabilities},
+		{"/events", get, nil, adminOnly(handleEvents)},
+		{"/usage", get, nil, handleUsage},
+		{"/stats", get, nil, adminOnly(handleStats)},
+		{"/reports", []string{http.MethodGet, http.MethodPost}, nil, adminOnly(handleReports)},
+		{"/fidelity", get, nil, adminOnly(handleFidelity)},
+		{"/drift", []string{http.MethodGet, http.MethodPost}, nil, handleDrift},
+		{"/recrawl", post, nil, adminOnly(handleRecrawl)},
+		{"/deadlinks", []string{http.MethodGet, http.MethodPost}, nil, handleDeadLinks},
+		{"/recapture", []string{http.MethodGet, http.MethodPost}, jsonBody, adminOnly(handleRecapture)},
+		{"/recapture/queue", get, nil, handleRecaptureQueue},
+		{"/jobs", get, nil, handleJobs},
+		{"/jobs/{id}", []string{http.MethodGet, http.MethodDelete}, nil, handleJob},
+	}
+}
+
+// newRouter returns the daemon's HTTP handler: the API under apiPrefix and
+// at its original paths, the browser pages, and a JSON 404 for the rest.
+func newRouter() http.Handler {
+	mux := http.NewServeMux()
+	for _, rt := range apiRoutes() {
+		if !routeEnabled(rt.pattern) {
+			continue
+		}
+		handler := allowMethods(rt.methods, rt.handler)
+		mux.Handle(apiPrefix+rt.pattern, chain(handler, acceptBody(rt.accepts)))
+		mux.Handle(rt.pattern, handler)
+	}
+	// Pages for browsers, which aren't part of the versioned API
+	if routeEnabled("/text") {
+		mux.Handle("/{$}", allowMethods([]string{http.MethodGet, http.MethodHead}, http.HandlerFunc(handleHome)))
+		mux.HandleFunc("/text", rateLimited(searchLimiter, false, handleTextSearch))
+		mux.HandleFunc("/text/open", handleTextOpen)
+		mux.Handle("/opensearch.xml", allowMethods([]string{http.MethodGet, http.MethodHead}, http.HandlerFunc(handleOpenSearch)))
+	}
+	if config.Viewer.PDFJSDir != "" && routeEnabled("/pdfjs/") {
+		mux.Handle("/pdfjs/", allowMethods([]string{http.MethodGet, http.MethodHead}, pdfjsHandler()))
+	}
+	mux.HandleFunc("/auth/login", handleLogin)
+	mux.HandleFunc("/auth/callback", handleLoginCallback)
+	// Logging out changes state, so it is POST-only like the API's
+	// changes, and requireAuth refuses it from other origins
+	mux.Handle("/auth/logout", allowMethods([]string{http.MethodPost}, http.HandlerFunc(handleLogout)))
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, http.StatusNotFound, ErrNotFound, "No such endpoint")
+	})
+	return chain(mux, requireAuth)
+}
+
+// chain wraps h in middlewares, the first of which runs first.
+func chain(h http.Handler, middlewares ...middleware) http.Handler {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		h = middlewares[i](h)
+	}
+	return h
+}
+
+// allowMethods answers requests with other methods than methods with a
+// METHOD_NOT_ALLOWED error.
+func allowMethods(methods []string, next http.Handler) http.Handler {
+	allow := strings.Join(methods, ", ")
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, method := range methods {
+			if r.Method == method {
+				next.ServeHTTP(w, r)
+				return
+			}
+		}
+		w.Header().Set("Allow", allow)
+		writeError(w, http.StatusMethodNotAllowed, ErrMethodNotAllowed, "Method not allowed")
+	})
+}
+
+// acceptBody rejects request bodies that aren't of one of the media types
+// an endpoint accepts, or lets anything through when accepts is empty.
+func acceptBody(accepts []string) middleware {
+	return func(next http.Handler) http.Handler {
+		if len(accepts) == 0 {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.ContentLength == 0 || r.Method == http.MethodGet || r.Method == http.MethodHead || r.Method == http.MethodDelete {
+				next.ServeHTTP(w, r)
+				return
+			}
+			mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+			for _, accepted := range accepts {
+				if mediaType == accepted {
+					next.ServeHTTP(w, r)
+					return
+				}
+			}
+			writeError(w, http.StatusUnsupportedMediaType, ErrUnsupportedContent,
+				"Request body must be "+strings.Join(accepts, " or "))
+		})
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRouter(t *testing.T) {
+	withPagesDir(t)
+	withIndex(t, nil)
+	id, err := storePage(PageMetadata{URL: "https://example.com/", Title: "Example"}, "<p>Example</p>", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	router := newRouter()
+
+	tests := []struct {
+		name        string
+		method      string
+		path        string
+		contentType string
+		body        string
+		status      int
+		code        ErrorCode
+		allow       string
+	}{
+		{name: "versioned", method: "GET", path: "/api/v1/pages/" + id, status: http.StatusOK},
+		{name: "unversioned", method: "GET", path: "/pages/" + id, status: http.StatusOK},
+		{name: "head", method: "HEAD", path: "/api/v1/pages/" + id + "/thumbnail", status: http.StatusOK},
+		{name: "head without get", method: "HEAD", path: "/api/v1/capabilities", status: http.StatusMethodNotAllowed, code: ErrMethodNotAllowed, allow: "GET"},
+		{name: "unknown path", method: "GET", path: "/api/v1/nothing", status: http.StatusNotFound, code: ErrNotFound},
+		{name: "unknown root path", method: "GET", path: "/nothing", status: http.StatusNotFound, code: ErrNotFound},
+		{name: "wrong method", method: "POST", path: "/api/v1/pages", status: http.StatusMethodNotAllowed, code: ErrMethodNotAllowed, allow: "GET"},
+		{name: "wrong method unversioned", method: "PUT", path: "/pages/" + id, status: http.StatusMethodNotAllowed, code: ErrMethodNotAllowed, allow: "GET, PATCH, DELETE"},
+		{name: "json body", method: "PATCH", path: "/api/v1/pages/" + id, contentType: "application/json; charset=utf-8", body: `{"read": true}`, status: http.StatusOK},
+		{name: "form body", method: "PATCH", path: "/api/v1/pages/" + id, contentType: "application/x-www-form-urlencoded", body: `read=true`, status: http.StatusUnsupportedMediaType, code: ErrUnsupportedContent},
+		{name: "missing content type", method: "POST", path: "/api/v1/search", body: `{}`, status: http.StatusUnsupportedMediaType, code: ErrUnsupportedContent},
+		{name: "second accepted type", method: "POST", path: "/api/v1/archive", contentType: "application/json", body: `{}`, status: http.StatusBadRequest, code: ErrInvalidRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			if tt.contentType != "" {
+				req.Header.Set("Content-Type", tt.contentType)
+			}
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.status, rec.Body)
+			}
+			if got := rec.Header().Get("Allow"); got != tt.allow {
+				t.Errorf("Allow = %q, want %q", got, tt.allow)
+			}
+			if tt.code == "" {
+				return
+			}
+			var response errorResponse
+			if err := json.NewDecoder(rec.Body).Decode(&response); err != nil {
+				t.Fatal(err)
+			}
+			if response.Error.Code != tt.code {
+				t.Errorf("code = %q, want %q", response.Error.Code, tt.code)
+			}
+		})
+	}
+}
@@ -0,0 +1,303 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// S3Config reaches an S3-compatible object store, such as AWS S3, MinIO or
+// Backblaze B2, for backups to s3://bucket/prefix. Endpoint is the store's
+// base URL; buckets are addressed by path.
+type S3Config struct {
+	Endpoint        string `json:"endpoint"`
+	Region          string `json:"region"`
+	AccessKeyID     string `json:"accessKeyId"`
+	SecretAccessKey Secret `json:"secretAccessKey"`
+}
+
+// s3Timeout bounds each request to the object store.
+const s3Timeout = 5 * time.Minute
+
+// s3Store is a backup repository under a prefix of an S3 bucket. Requests
+// are signed with AWS Signature Version 4.
+type s3Store struct {
+	client   *http.Client
+	endpoint *url.URL
+	bucket   string
+	prefix   string
+	config   S3Config
+}
+
+func validateS3(c S3Config) error {
+	if c.Endpoint == "" || c.AccessKeyID == "" {
+		return fmt.Errorf("backups to S3 need backup.s3.endpoint and backup.s3.accessKeyId")
+	}
+	if u, err := url.Parse(c.Endpoint); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("backup.s3.endpoint must be an http or https URL")
+	}
+	return nil
+}
+
+func newS3Store(repo string, c S3Config) (*s3Store, error) {
+	u, err := url.Parse(repo)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("invalid S3 repository %q, want s3://bucket/prefix", repo)
+	}
+	if err := validateS3(c); err != nil {
+		return nil, err
+	}
+	endpoint, _ := url.Parse(strings.TrimSuffix(c.Endpoint, "/"))
+	return &s3Store{
+		client:   &http.Client{Timeout: s3Timeout},
+		endpoint: endpoint,
+		bucket:   u.Host,
+		prefix:   strings.Trim(u.Path, "/"),
+		config:   c,
+	}, nil
+}
+
+// key is the object key of a repository file.
+func (s *s3Store) key(name string) string {
+	if s.prefix == "" {
+		return name
+	}
+	return s.prefix + "/" + name
+}
+
+// s3Escape percent-encodes s as signatures expect: everything but
+// unreserved characters, and slashes too unless keepSlash is set.
+func s3Escape(s string, keepSlash bool) string {
+	var b strings.Builder
+	for _, c := range []byte(s) {
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~', c == '/' && keepSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// request sends a signed request for the object key, or for the bucket
+// when key is empty.
+func (s *s3Store) request(method, key string, query url.Values, body []byte, header http.Header) (*http.Response, error) {
+	target := *s.endpoint
+	target.RawPath = target.EscapedPath() + "/" + s3Escape(s.bucket, false)
+	if key != "" {
+		target.RawPath += "/" + s3Escape(key, true)
+	}
+	target.Path, _ = url.PathUnescape(target.RawPath)
+	target.RawQuery = canonicalQuery(query)
+	req, err := http.NewRequest(method, target.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	secret, err := s.config.SecretAccessKey.Value()
+	if err != nil {
+		return nil, fmt.Errorf("reading backup.s3.secretAccessKey: %v", err)
+	}
+	signS3Request(req, body, s.config.AccessKeyID, secret, s.config.Region, time.Now())
+	return s.client.Do(req)
+}
+
+// canonicalQuery encodes query parameters sorted, as signatures expect.
+func canonicalQuery(query url.Values) string {
+	var pairs []string
+	for name, values := range query {
+		for _, value := range values {
+			pairs = append(pairs, s3Escape(name, false)+"="+s3Escape(value, false))
+		}
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, "&")
+}
+
+// signS3Request signs req with AWS Signature Version 4, covering its host
+// and every header set on it.
+func signS3Request(req *http.Request, body []byte, accessKey, secret, region string, now time.Time) {
+	sum := sha256.Sum256(body)
+	payloadHash := hex.EncodeToString(sum[:])
+	amzDate := now.UTC().Format("20060102T150405Z")
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	path := req.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	canonicalRequest := strings.Join([]string{
+		req.Method, path, req.URL.RawQuery, canonicalHeaders.String(), signedHeaders, payloadHash,
+	}, "\n")
+	scope := amzDate[:8] + "/" + region + "/s3/aws4_request"
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := []byte("AWS4" + secret)
+	for _, part := range []string{amzDate[:8], region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+accessKey+"/"+scope+
+		", SignedHeaders="+signedHeaders+", Signature="+signature)
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// s3Error describes a failed response, reporting missing objects as
+// os.ErrNotExist.
+func s3Error(resp *http.Response, method, name string) error {
+	body, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode == http.StatusNotFound {
+		return fmt.Errorf("%s: %w", name, os.ErrNotExist)
+	}
+	var apiErr struct {
+		Code    string `xml:"Code"`
+		Message string `xml:"Message"`
+	}
+	if xml.Unmarshal(body, &apiErr) == nil && apiErr.Code != "" {
+		return fmt.Errorf("S3 %s %s: %s: %s", method, name, apiErr.Code, apiErr.Message)
+	}
+	return fmt.Errorf("S3 %s %s: %s", method, name, resp.Status)
+}
+
+func (s *s3Store) read(name string) ([]byte, error) {
+	resp, err := s.request(http.MethodGet, s.key(name), nil, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, s3Error(resp, http.MethodGet, name)
+	}
+	return ioutil.ReadAll(resp.Body)
+}
+
+func (s *s3Store) put(name string, data []byte, header http.Header) error {
+	resp, err := s.request(http.MethodPut, s.key(name), nil, data, header)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusPreconditionFailed {
+		return fmt.Errorf("%s: %w", name, os.ErrExist)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return s3Error(resp, http.MethodPut, name)
+	}
+	return nil
+}
+
+// Objects are only visible once uploaded whole.
+func (s *s3Store) write(name string, data []byte) error {
+	return s.put(name, data, nil)
+}
+
+// create relies on conditional writes, which refuse to replace an object
+// that exists.
+func (s *s3Store) create(name string, data []byte) error {
+	return s.put(name, data, http.Header{"If-None-Match": {"*"}})
+}
+
+func (s *s3Store) exists(name string) (bool, error) {
+	resp, err := s.request(http.MethodHead, s.key(name), nil, nil, nil)
+	if err != nil {
+		return false, err
+	}
+	resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusNotFound:
+		return false, nil
+	}
+	return false, fmt.Errorf("S3 HEAD %s: %s", name, resp.Status)
+}
+
+func (s *s3Store) remove(name string) error {
+	resp, err := s.request(http.MethodDelete, s.key(name), nil, nil, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK {
+		return s3Error(resp, http.MethodDelete, name)
+	}
+	return nil
+}
+
+// list pages through ListObjectsV2 results.
+func (s *s3Store) list(dir string) ([]backupObject, error) {
+	prefix := s.key(dir) + "/"
+	var objects []backupObject
+	query := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+	for {
+		resp, err := s.request(http.MethodGet, "", query, nil, nil)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusOK {
+			err := s3Error(resp, http.MethodGet, dir)
+			resp.Body.Close()
+			return nil, err
+		}
+		var result struct {
+			Contents []struct {
+				Key  string `xml:"Key"`
+				Size int64  `xml:"Size"`
+			} `xml:"Contents"`
+			IsTruncated           bool   `xml:"IsTruncated"`
+			NextContinuationToken string `xml:"NextContinuationToken"`
+		}
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("listing %s: %v", dir, err)
+		}
+		for _, object := range result.Contents {
+			name := object.Key
+			if s.prefix != "" {
+				name = strings.TrimPrefix(name, s.prefix+"/")
+			}
+			objects = append(objects, backupObject{Name: name, Size: object.Size})
+		}
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			return objects, nil
+		}
+		query.Set("continuation-token", result.NextContinuationToken)
+	}
+}
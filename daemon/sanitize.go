@@ -0,0 +1,249 @@
+package main
+
+import (
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Content policies, from least to most aggressive. Standard removes scripts,
+// event handlers, tracking pixels and known analytics resources; strict also
+// removes every reference to an external resource, so an archived page never
+// makes a request when opened.
+const (
+	contentPolicyOff      = "off"
+	contentPolicyStandard = "standard"
+	contentPolicyStrict   = "strict"
+)
+
+var contentPolicies = map[string]bool{contentPolicyOff: true, contentPolicyStandard: true, contentPolicyStrict: true}
+
+var (
+	scriptBlockRe   = regexp.MustCompile(`(?is)<script\b.*?(</script\s*>|$)|<noscript\b.*?(</noscript\s*>|$)`)
+	embedBlockRe    = regexp.MustCompile(`(?is)<iframe\b.*?</iframe\s*>|<object\b.*?</object\s*>|<applet\b.*?</applet\s*>|<(frame|embed)\b[^>]*>`)
+	styleBlockRe    = regexp.MustCompile(`(?is)(<style\b[^>]*>)(.*?)(</style\s*>)`)
+	htmlTagRe       = regexp.MustCompile(`<([a-zA-Z][a-zA-Z0-9-]*)((?:\s+[^\s"'>/=]+(?:\s*=\s*(?:"[^"]*"|'[^']*'|[^\s"'=<>` + "`" + `]+))?)*)\s*(/?)>`)
+	htmlAttrRe      = regexp.MustCompile(`\s+([^\s"'>/=]+)(?:\s*=\s*("[^"]*"|'[^']*'|[^\s"'=<>` + "`" + `]+))?`)
+	cssExternalRe   = regexp.MustCompile(`(?i)url\(\s*['"]?(?:https?:)?//[^)]*\)`)
+	cssImportRe     = regexp.MustCompile(`(?i)@import\s+(?:url\([^)]*\)|"[^"]*"|'[^']*')[^;]*;?`)
+	resourceHintRel = regexp.MustCompile(`(?i)\b(dns-prefetch|preconnect|prefetch|prerender|preload|modulepreload)\b`)
+)
+
+// trackerHosts are analytics and advertising hosts whose resources are
+// removed under the standard policy, along with their subdomains.
+var trackerHosts = []string{
+	"google-analytics.com", "googletagmanager.com", "googlesyndication.com",
+	"googleadservices.com", "doubleclick.net", "adservice.google.com",
+	"connect.facebook.net", "analytics.twitter.com", "ads-twitter.com",
+	"bat.bing.com", "clarity.ms", "px.ads.linkedin.com", "snap.licdn.com",
+	"scorecardresearch.com", "quantserve.com", "hotjar.com", "segment.com",
+	"segment.io", "mixpanel.com", "heapanalytics.com", "fullstory.com",
+	"mc.yandex.ru", "pixel.wp.com", "stats.wp.com", "nr-data.net",
+	"cloudflareinsights.com", "amazon-adsystem.com", "plausible.io",
+	"chartbeat.com", "parsely.com", "optimizely.com", "criteo.com",
+	"taboola.com", "outbrain.com",
+}
+
+// urlAttributes are the attributes through which a tag loads a resource.
+var urlAttributes = map[string]bool{"src": true, "srcset": true, "href": true, "xlink:href": true, "poster": true, "data": true, "background": true}
+
+// storedHTML applies the configured content policy to HTML about to be
+// stored.
+func storedHTML(html string) string {
+	return sanitizeHTML(html, config.Capture.ContentPolicy)
+}
+
+// sanitizeHTML removes what the policy forbids from a page. Applying it
+// again to its own output changes nothing, so stored pages can be compared
+// by hash with sanitized captures.
+func sanitizeHTML(html, policy string) string {
+	if policy == contentPolicyOff || policy == "" {
+		return html
+	}
+	strict := policy == contentPolicyStrict
+
+	html = scriptBlockRe.ReplaceAllString(html, "")
+	if strict {
+		html = embedBlockRe.ReplaceAllString(html, "")
+		html = styleBlockRe.ReplaceAllStringFunc(html, func(block string) string {
+			parts := styleBlockRe.FindStringSubmatch(block)
+			return parts[1] + stripExternalCSS(parts[2]) + parts[3]
+		})
+	}
+	return htmlTagRe.ReplaceAllStringFunc(html, func(tag string) string {
+		return sanitizeTag(tag, strict)
+	})
+}
+
+// stripExternalCSS removes stylesheet imports and external url() references.
+func stripExternalCSS(css string) string {
+	css = cssImportRe.ReplaceAllString(css, "")
+	return cssExternalRe.ReplaceAllString(css, "none")
+}
+
+// sanitizeTag returns a start tag without event handlers and pings, or an
+// empty string if the whole tag should go.
+func sanitizeTag(tag string, strict bool) string {
+	parts := htmlTagRe.FindStringSubmatch(tag)
+	name := strings.ToLower(parts[1])
+	attrs := make(map[string]string)
+	for _, attr := range htmlAttrRe.FindAllStringSubmatch(parts[2], -1) {
+		attrs[strings.ToLower(attr[1])] = strings.Trim(attr[2], `"'`)
+	}
+
+	switch name {
+	case "img":
+		if trackingPixel(attrs) {
+			return ""
+		}
+	case "link":
+		if resourceHintRel.MatchString(attrs["rel"]) {
+			return ""
+		}
+	case "meta":
+		if strings.EqualFold(attrs["http-equiv"], "refresh") {
+			return ""
+		}
+	case "base":
+		if strict {
+			return ""
+		}
+	}
+	if name != "a" && name != "area" && name != "form" {
+		for attr := range urlAttributes {
+			if value, ok := attrs[attr]; ok && trackerURL(value) {
+				return ""
+			}
+		}
+	}
+
+	changed := false
+	rest := htmlAttrRe.ReplaceAllStringFunc(parts[2], func(attr string) string {
+		m := htmlAttrRe.FindStringSubmatch(attr)
+		key := strings.ToLower(m[1])
+		value := strings.Trim(m[2], `"'`)
+		switch {
+		case strings.HasPrefix(key, "on") || key == "ping":
+		case strict && key == "style" && cssExternalRe.MatchString(value):
+			changed = true
+			return strings.Replace(attr, m[2], cssExternalRe.ReplaceAllString(m[2], "none"), 1)
+		case strict && urlAttributes[key] && name != "a" && name != "area" && externalURL(value):
+		default:
+			return attr
+		}
+		changed = true
+		return ""
+	})
+	if !changed {
+		return tag
+	}
+	return "<" + parts[1] + rest + parts[3] + ">"
+}
+
+// trackingPixel reports whether an image is a 1x1 or hidden beacon.
+func trackingPixel(attrs map[string]string) bool {
+	tiny := func(v string) bool {
+		v = strings.TrimSuffix(strings.TrimSpace(v), "px")
+		return v == "0" || v == "1"
+	}
+	if tiny(attrs["width"]) && tiny(attrs["height"]) {
+		return true
+	}
+	style := strings.ReplaceAll(strings.ToLower(attrs["style"]), " ", "")
+	return strings.Contains(style, "display:none") || strings.Contains(style, "visibility:hidden")
+}
+
+// externalURL reports whether an attribute value loads something from
+// another host. For srcset every candidate is checked.
+func externalURL(value string) bool {
+	for _, candidate := range strings.Split(value, ",") {
+		fields := strings.Fields(candidate)
+		if len(fields) == 0 {
+			continue
+		}
+		v := strings.ToLower(fields[0])
+		if strings.HasPrefix(v, "http://") || strings.HasPrefix(v, "https://") || strings.HasPrefix(v, "//") {
+			return true
+		}
+	}
+	return false
+}
+
+// trackerURL reports whether a URL points at a known tracker or one of the
+// configured blocked hosts.
+func trackerURL(value string) bool {
+	for _, candidate := range strings.Split(value, ",") {
+		fields := strings.Fields(candidate)
+		if len(fields) == 0 {
+			continue
+		}
+		raw := fields[0]
+		if strings.HasPrefix(raw, "//") {
+			raw = "https:" + raw
+		}
+		u, err := url.Parse(raw)
+		if err != nil || u.Host == "" {
+			continue
+		}
+		host := strings.ToLower(u.Hostname())
+		if host == "www.facebook.com" || host == "facebook.com" {
+			if u.Path == "/tr" || strings.HasPrefix(u.Path, "/tr/") {
+				return true
+			}
+			continue
+		}
+		for _, tracker := range append(trackerHosts, config.Capture.BlockedHosts...) {
+			tracker = strings.ToLower(tracker)
+			if host == tracker || strings.HasSuffix(host, "."+tracker) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// sanitizeStoredPages applies the content policy to pages already stored,
+// returning how many changed. Changed pages are reindexed.
+func sanitizeStoredPages() (int, error) {
+	indexMu.Lock()
+	defer indexMu.Unlock()
+	pages, err := listPages()
+	if err != nil {
+		return 0, err
+	}
+	count := 0
+	for _, page := range pages {
+		if page.HTMLFilename == "" {
+			continue
+		}
+		path := filepath.Join(pagesDir, page.HTMLFilename)
+		data, err := readContentFile(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return count, err
+		}
+		sanitized := storedHTML(string(data))
+		if sanitized == string(data) {
+			continue
+		}
+		if err := writeContentFile(path, []byte(sanitized)); err != nil {
+			return count, err
+		}
+
+		metadata := page.PageMetadata
+		metadata.ContentHash = ""
+		if metadata.ContentHash, err = pageContentHash(metadata); err != nil {
+			return count, err
+		}
+		metadata.Indexed = false
+		if err := writeMetadata(page.ID, metadata); err != nil {
+			return count, err
+		}
+		count++
+	}
+	return count, nil
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestSanitizeHTML(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy string
+		html   string
+		want   string
+	}{
+		{"off", contentPolicyOff, `<script>track()</script><p onclick="x()">a</p>`, `<script>track()</script><p onclick="x()">a</p>`},
+		{"scripts", contentPolicyStandard, `<p>a</p><script src="/app.js"></script><SCRIPT>b()</SCRIPT><p>c</p>`, `<p>a</p><p>c</p>`},
+		{"unclosed script", contentPolicyStandard, `<p>a</p><script>b()`, `<p>a</p>`},
+		{"noscript pixels", contentPolicyStandard, `<noscript><img src="https://www.facebook.com/tr?id=1"></noscript><p>a</p>`, `<p>a</p>`},
+		{"event handlers", contentPolicyStandard, `<body onload="t()"><a href="/x" onclick='y()' ping="https://t.example/">x</a></body>`, `<body><a href="/x">x</a></body>`},
+		{"quoted angle bracket", contentPolicyStandard, `<p title="a > b" onmouseover=x()>t</p>`, `<p title="a > b">t</p>`},
+		{"tracking pixel", contentPolicyStandard, `<img src="/b.gif" width="1" height="1"><img src="/photo.jpg" width="100" height="1">`, `<img src="/photo.jpg" width="100" height="1">`},
+		{"hidden image", contentPolicyStandard, `<img src="/b.gif" style="display: none">`, ``},
+		{"tracker host", contentPolicyStandard, `<img src="https://stats.g.doubleclick.net/p.gif" alt=""><iframe src="//www.googletagmanager.com/ns.html"></iframe>`, `</iframe>`},
+		{"facebook pixel only", contentPolicyStandard, `<img src="https://facebook.com/tr/?ev=x"><img src="https://facebook.com/photo.jpg">`, `<img src="https://facebook.com/photo.jpg">`},
+		{"tracker links kept", contentPolicyStandard, `<a href="https://google-analytics.com/">GA</a>`, `<a href="https://google-analytics.com/">GA</a>`},
+		{"resource hints", contentPolicyStandard, `<link rel="dns-prefetch" href="//cdn.example"><link rel="stylesheet" href="/s.css">`, `<link rel="stylesheet" href="/s.css">`},
+		{"meta refresh", contentPolicyStandard, `<meta http-equiv="refresh" content="0;url=https://ad.example/"><meta charset="utf-8">`, `<meta charset="utf-8">`},
+		{"standard keeps external images", contentPolicyStandard, `<img src="https://cdn.example/a.png">`, `<img src="https://cdn.example/a.png">`},
+		{"strict external images", contentPolicyStrict, `<img src="https://cdn.example/a.png" srcset="/a.png 1x, //cdn.example/a2.png 2x" alt="a">`, `<img alt="a">`},
+		{"strict relative images", contentPolicyStrict, `<img src="a.png">`, `<img src="a.png">`},
+		{"strict stylesheets", contentPolicyStrict, `<link rel="stylesheet" href="https://fonts.example/css">`, `<link rel="stylesheet">`},
+		{"strict keeps links", contentPolicyStrict, `<a href="https://example.com/">x</a>`, `<a href="https://example.com/">x</a>`},
+		{"strict embeds", contentPolicyStrict, `<p>a</p><iframe src="/v"><p>no frames</p></iframe><embed src="x.swf"><object data="y"></object>`, `<p>a</p>`},
+		{"strict css", contentPolicyStrict, `<style>@import url("https://f.example/a.css");body{background:url(https://i.example/b.png)}</style>`, `<style>body{background:none}</style>`},
+		{"strict inline css", contentPolicyStrict, `<div style="background-image: url('//i.example/c.png')">x</div>`, `<div style="background-image: none">x</div>`},
+		{"strict base", contentPolicyStrict, `<base href="https://example.com/"><p>a</p>`, `<p>a</p>`},
+	}
+	for _, tt := range tests {
+		got := sanitizeHTML(tt.html, tt.policy)
+		if got != tt.want {
+			t.Errorf("%s: sanitizeHTML() = %q, want %q", tt.name, got, tt.want)
+		}
+		if again := sanitizeHTML(got, tt.policy); again != got {
+			t.Errorf("%s: sanitizing again changed %q to %q", tt.name, got, again)
+		}
+	}
+}
+
+func TestSanitizeBlockedHosts(t *testing.T) {
+	saved := config.Capture.BlockedHosts
+	config.Capture.BlockedHosts = []string{"Metrics.Example.com"}
+	defer func() { config.Capture.BlockedHosts = saved }()
+
+	html := `<img src="https://eu.metrics.example.com/p.png"><img src="https://example.com/a.png">`
+	if got, want := sanitizeHTML(html, contentPolicyStandard), `<img src="https://example.com/a.png">`; got != want {
+		t.Errorf("sanitizeHTML() = %q, want %q", got, want)
+	}
+}
+
+func TestSanitizeStoredPages(t *testing.T) {
+	withPagesDir(t)
+	saved := config.Capture.ContentPolicy
+	defer func() { config.Capture.ContentPolicy = saved }()
+
+	// Pages stored before the policy was turned on
+	config.Capture.ContentPolicy = contentPolicyOff
+	dirty, err := storePage(PageMetadata{Title: "Dirty"}, `<p>a</p><script>track()</script>`, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	clean, err := storePage(PageMetadata{Title: "Clean"}, `<p>b</p>`, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{dirty, clean} {
+		metadata, _ := readMetadata(id)
+		metadata.Indexed = true
+		writeMetadata(id, metadata)
+	}
+
+	config.Capture.ContentPolicy = contentPolicyStandard
+	count, err := sanitizeStoredPages()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 {
+		t.Errorf("sanitized %d pages, want 1", count)
+	}
+	data, _ := ioutil.ReadFile(filepath.Join(pagesDir, dirty+".html"))
+	metadata, _ := readMetadata(dirty)
+	if string(data) != `<p>a</p>` || metadata.Indexed || metadata.ContentHash != contentHash(data) {
+		t.Errorf("sanitized page = %q, metadata %+v", data, metadata)
+	}
+	if metadata, _ := readMetadata(clean); !metadata.Indexed {
+		t.Error("an unchanged page was marked for reindexing")
+	}
+
+	// Captures are now stored sanitized and recognized as duplicates
+	hashes, _ := knownContentHashes()
+	if !hashes[contentHash([]byte(storedHTML(`<p>a</p><script>other()</script>`)))] {
+		t.Error("a sanitized capture of the same page isn't a duplicate")
+	}
+}
@@ -0,0 +1,305 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/blevesearch/bleve"
+)
+
+const savedSearchesFile = "memento_saved_searches.json"
+
+// SavedSearch is a named query kept to be run again later.
+type SavedSearch struct {
+	ID        string `json:"id"`
+	Name      string `json:"name"`
+	Query     string `json:"query"`
+	Sort      string `json:"sort,omitempty"`
+	Highlight string `json:"highlight,omitempty"`
+	Fuzziness int    `json:"fuzziness,omitempty"`
+	Prefix    bool   `json:"prefix,omitempty"`
+	Exact     bool   `json:"exact,omitempty"`
+	// Owner is the user who saved the search, whose pages it searches.
+	Owner   string    `json:"owner,omitempty"`
+	Created time.Time `json:"created"`
+	// Alert, when set, runs the search on a schedule and notifies about
+	// new matches.
+	Alert *SearchAlert `json:"alert,omitempty"`
+}
+
+// options returns the search options the saved search runs with.
+func (s SavedSearch) options() SearchOptions {
+	return SearchOptions{Highlight: s.Highlight, Sort: s.Sort, Fuzziness: s.Fuzziness, Prefix: s.Prefix, Exact: s.Exact, Owner: s.Owner}
+}
+
+var (
+	savedSearchesMu   sync.Mutex
+	errSavedSearchDup = errors.New("a saved search with that name already exists")
+)
+
+// readSavedSearches loads every saved search, oldest first. savedSearchesMu
+// must be held.
+func readSavedSearches() ([]SavedSearch, error) {
+	data, err := ioutil.ReadFile(savedSearchesFile)
+	if os.IsNotExist(err) {
+		return []SavedSearch{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var searches []SavedSearch
+	if err := json.Unmarshal(data, &searches); err != nil {
+		return nil, fmt.Errorf("parsing %s: %v", savedSearchesFile, err)
+	}
+	return searches, nil
+}
+
+// writeSavedSearches replaces the saved searches file atomically.
+// savedSearchesMu must be held.
+func writeSavedSearches(searches []SavedSearch) error {
+	data, err := json.MarshalIndent(searches, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := savedSearchesFile + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, savedSearchesFile)
+}
+
+// findSavedSearch returns the saved search with the given ID.
+func findSavedSearch(id string) (SavedSearch, bool, error) {
+	savedSearchesMu.Lock()
+	defer savedSearchesMu.Unlock()
+	searches, err := readSavedSearches()
+	if err != nil {
+		return SavedSearch{}, false, err
+	}
+	for _, search := range searches {
+		if search.ID == id {
+			return search, true, nil
+		}
+	}
+	return SavedSearch{}, false, nil
+}
+
+// validate checks a new saved search's name, query and options, filling in
+// option defaults.
+func (s *SavedSearch) validate() error {
+	s.Name = strings.TrimSpace(s.Name)
+	if s.Name == "" {
+		return fmt.Errorf("name is required")
+	}
+	if strings.TrimSpace(s.Query) == "" {
+		return fmt.Errorf("query is required")
+	}
+	options := s.options()
+	if err := options.validate(); err != nil {
+		return err
+	}
+	s.Highlight, s.Sort = options.Highlight, options.Sort
+	if s.Alert != nil {
+		if err := s.Alert.validate(); err != nil {
+			return err
+		}
+	}
+	if s.Fuzziness == 0 && !s.Prefix && !s.Exact {
+		if err := bleve.NewQueryStringQuery(s.Query).Validate(); err != nil {
+			return fmt.Errorf("%w: %v", errInvalidQuery, err)
+		}
+	}
+	return nil
+}
+
+// createSavedSearch stores a new saved search under a fresh ID. Each
+// owner's names are unique, ignoring case.
+func createSavedSearch(search SavedSearch) (SavedSearch, error) {
+	savedSearchesMu.Lock()
+	defer savedSearchesMu.Unlock()
+	searches, err := readSavedSearches()
+	if err != nil {
+		return search, err
+	}
+	for _, existing := range searches {
+		if existing.Owner == search.Owner && strings.EqualFold(existing.Name, search.Name) {
+			return search, errSavedSearchDup
+		}
+	}
+	id := make([]byte, 8)
+	rand.Read(id)
+	search.ID = hex.EncodeToString(id)
+	search.Created = time.Now()
+	return search, writeSavedSearches(append(searches, search))
+}
+
+// deleteSavedSearch removes a saved search, reporting whether it existed.
+func deleteSavedSearch(id string) (bool, error) {
+	savedSearchesMu.Lock()
+	defer savedSearchesMu.Unlock()
+	searches, err := readSavedSearches()
+	if err != nil {
+		return false, err
+	}
+	for i, search := range searches {
+		if search.ID == id {
+			return true, writeSavedSearches(append(searches[:i], searches[i+1:]...))
+		}
+	}
+	return false, nil
+}
+
+// handleSavedSearches lists saved searches by name, or saves a new one from
+// {"name": ..., "query": ...} plus optional sort, highlight, fuzziness,
+// prefix and alert.
+func handleSavedSearches(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		savedSearchesMu.Lock()
+		searches, err := readSavedSearches()
+		savedSearchesMu.Unlock()
+		if err != nil {
+			log.Printf("Error reading saved searches: %v", err)
+			writeError(w, http.StatusInternalServerError, ErrInternal, "Failed to read saved searches")
+			return
+		}
+		if usersEnabled() {
+			owned := []SavedSearch{}
+			for _, search := range searches {
+				if search.Owner == requestUser(r) {
+					owned = append(owned, search)
+				}
+			}
+			searches = owned
+		}
+		sort.SliceStable(searches, func(i, j int) bool {
+			return strings.ToLower(searches[i].Name) < strings.ToLower(searches[j].Name)
+		})
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(searches)
+	case http.MethodPost:
+		var search SavedSearch
+		if err := json.NewDecoder(r.Body).Decode(&search); err != nil {
+			writeError(w, http.StatusBadRequest, ErrInvalidRequest, "Invalid request body")
+			return
+		}
+		if err := search.validate(); err != nil {
+			writeError(w, http.StatusBadRequest, ErrInvalidRequest, err.Error())
+			return
+		}
+		search.Owner = requestUser(r)
+		search, err := createSavedSearch(search)
+		if err == errSavedSearchDup {
+			writeError(w, http.StatusConflict, ErrDuplicate, err.Error())
+			return
+		}
+		if err != nil {
+			log.Printf("Error saving search: %v", err)
+			writeError(w, http.StatusInternalServerError, ErrInternal, "Failed to save search")
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(search)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, ErrMethodNotAllowed, "Method not allowed")
+	}
+}
+
+// userSavedSearch wraps a handler of a /saved-searches/{id} route so that
+// searches other users saved are reported as not found.
+func userSavedSearch(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if usersEnabled() {
+			if search, ok, err := findSavedSearch(r.PathValue("id")); err == nil && ok && search.Owner != requestUser(r) {
+				writeError(w, http.StatusNotFound, ErrNotFound, "Saved search not found")
+				return
+			}
+		}
+		next(w, r)
+	}
+}
+
+// handleSavedSearch returns or deletes a single saved search.
+func handleSavedSearch(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	switch r.Method {
+	case http.MethodGet:
+		search, ok, err := findSavedSearch(id)
+		if err != nil {
+			log.Printf("Error reading saved searches: %v", err)
+			writeError(w, http.StatusInternalServerError, ErrInternal, "Failed to read saved searches")
+			return
+		}
+		if !ok {
+			writeError(w, http.StatusNotFound, ErrNotFound, "Saved search not found")
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(search)
+	case http.MethodDelete:
+		ok, err := deleteSavedSearch(id)
+		if err != nil {
+			log.Printf("Error deleting saved search %s: %v", id, err)
+			writeError(w, http.StatusInternalServerError, ErrInternal, "Failed to delete saved search")
+			return
+		}
+		if !ok {
+			writeError(w, http.StatusNotFound, ErrNotFound, "Saved search not found")
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, ErrMethodNotAllowed, "Method not allowed")
+	}
+}
+
+// handleSavedSearchResults runs a saved search, counting it against the
+// search quota like any other.
+func handleSavedSearchResults(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, ErrMethodNotAllowed, "Method not allowed")
+		return
+	}
+	search, ok, err := findSavedSearch(r.PathValue("id"))
+	if err != nil {
+		log.Printf("Error reading saved searches: %v", err)
+		writeError(w, http.StatusInternalServerError, ErrInternal, "Failed to read saved searches")
+		return
+	}
+	if !ok {
+		writeError(w, http.StatusNotFound, ErrNotFound, "Saved search not found")
+		return
+	}
+	if !allowSearch(w, r) {
+		return
+	}
+	ctx, span := startRequestSpan(r, "search")
+	defer span.End()
+
+	results, err := searchPages(ctx, search.Query, search.options(), localizerFor(r))
+	span.RecordError(err)
+	if errors.Is(err, errInvalidQuery) {
+		writeError(w, http.StatusBadRequest, ErrInvalidRequest, err.Error())
+		return
+	}
+	if err != nil {
+		log.Printf("Search error: %v", err)
+		writeError(w, http.StatusServiceUnavailable, ErrIndexUnavailable, "Search failed")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Add("Vary", "Accept-Language")
+	json.NewEncoder(w).Encode(results)
+}
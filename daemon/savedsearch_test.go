@@ -0,0 +1,104 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func savedSearchMux() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/saved-searches", handleSavedSearches)
+	mux.HandleFunc("/saved-searches/{id}", handleSavedSearch)
+	mux.HandleFunc("/saved-searches/{id}/results", handleSavedSearchResults)
+	return mux
+}
+
+func TestSavedSearchValidation(t *testing.T) {
+	withPagesDir(t)
+	mux := savedSearchMux()
+
+	tests := []struct {
+		name   string
+		body   string
+		status int
+	}{
+		{"valid", `{"name": "Go concurrency", "query": "golang concurrency"}`, http.StatusCreated},
+		{"duplicate name", `{"name": "go CONCURRENCY", "query": "goroutines"}`, http.StatusConflict},
+		{"fuzzy", `{"name": "Typos", "query": "kubernets", "fuzziness": 1}`, http.StatusCreated},
+		{"missing name", `{"query": "go"}`, http.StatusBadRequest},
+		{"blank name", `{"name": "  ", "query": "go"}`, http.StatusBadRequest},
+		{"missing query", `{"name": "Empty"}`, http.StatusBadRequest},
+		{"invalid query", `{"name": "Broken", "query": "\"unterminated"}`, http.StatusBadRequest},
+		{"invalid sort", `{"name": "Sorted", "query": "go", "sort": "size"}`, http.StatusBadRequest},
+		{"invalid fuzziness", `{"name": "Fuzzy", "query": "go", "fuzziness": 3}`, http.StatusBadRequest},
+		{"malformed", `{"name":`, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/saved-searches", strings.NewReader(tt.body)))
+		if rec.Code != tt.status {
+			t.Errorf("%s: POST = %d %s, want %d", tt.name, rec.Code, rec.Body, tt.status)
+		}
+	}
+}
+
+func TestSavedSearchLifecycle(t *testing.T) {
+	withPagesDir(t)
+	now := time.Now()
+	withIndex(t, map[string]PageDocument{
+		"go":   {URL: "https://go.dev", Title: "Go", Content: "golang concurrency with goroutines", Time: now},
+		"rust": {URL: "https://rust-lang.org", Title: "Rust", Content: "fearless concurrency", Time: now},
+	})
+	mux := savedSearchMux()
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(method, path, strings.NewReader(body)))
+		return rec
+	}
+
+	rec := do(http.MethodPost, "/saved-searches", `{"name": "Go", "query": "+golang +concurrency"}`)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("POST = %d %s", rec.Code, rec.Body)
+	}
+	var created SavedSearch
+	json.Unmarshal(rec.Body.Bytes(), &created)
+	if created.ID == "" || created.Sort != "score" || created.Created.IsZero() {
+		t.Fatalf("created = %+v", created)
+	}
+	do(http.MethodPost, "/saved-searches", `{"name": "all concurrency", "query": "concurrency"}`)
+
+	var list []SavedSearch
+	json.Unmarshal(do(http.MethodGet, "/saved-searches", "").Body.Bytes(), &list)
+	if len(list) != 2 || list[0].Name != "all concurrency" || list[1].Name != "Go" {
+		t.Errorf("list = %+v, want sorted by name", list)
+	}
+
+	if rec := do(http.MethodGet, "/saved-searches/"+created.ID, ""); rec.Code != http.StatusOK {
+		t.Errorf("GET = %d", rec.Code)
+	}
+	rec = do(http.MethodGet, "/saved-searches/"+created.ID+"/results", "")
+	var results []SearchResult
+	json.Unmarshal(rec.Body.Bytes(), &results)
+	if rec.Code != http.StatusOK || len(results) != 1 || results[0].URL != "https://go.dev" {
+		t.Errorf("results = %d %+v, want the Go page", rec.Code, results)
+	}
+
+	if rec := do(http.MethodDelete, "/saved-searches/"+created.ID, ""); rec.Code != http.StatusNoContent {
+		t.Errorf("DELETE = %d", rec.Code)
+	}
+	for _, path := range []string{"/saved-searches/" + created.ID, "/saved-searches/" + created.ID + "/results"} {
+		if rec := do(http.MethodGet, path, ""); rec.Code != http.StatusNotFound {
+			t.Errorf("GET %s after delete = %d, want 404", path, rec.Code)
+		}
+	}
+	if rec := do(http.MethodDelete, "/saved-searches/"+created.ID, ""); rec.Code != http.StatusNotFound {
+		t.Errorf("second DELETE = %d, want 404", rec.Code)
+	}
+	if rec := do(http.MethodPut, "/saved-searches/"+created.ID, ""); rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("PUT = %d, want 405", rec.Code)
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+)
+
+// captureScreenshot takes a full-page screenshot of a stored page's URL and
+// records it in the page's metadata.
+func captureScreenshot(ctx context.Context, id, pageURL string) error {
+	ctx, span := startSpan(ctx, "capture.screenshot")
+	defer span.End()
+	png, err := takeScreenshot(ctx, pageURL)
+	if err != nil {
+		span.RecordError(err)
+		return err
+	}
+	span.SetAttribute("screenshot.bytes", len(png))
+	return attachPageFile(id, id+".png", png, func(m *PageMetadata, name string) {
+		m.ScreenshotFilename = name
+		// Regenerate the thumbnail from the new screenshot
+		if m.ThumbnailFilename != "" {
+			os.Remove(filepath.Join(pagesDir, m.ThumbnailFilename))
+			m.ThumbnailFilename = ""
+		}
+	})
+}
+
+// attachPageFile stores a file alongside a page and records its name in the
+// page's metadata with set.
+func attachPageFile(id, name string, data []byte, set func(*PageMetadata, string)) error {
+	if err := ioutil.WriteFile(filepath.Join(pagesDir, name), data, 0644); err != nil {
+		return err
+	}
+	// The indexer rewrites metadata too
+	indexMu.Lock()
+	defer indexMu.Unlock()
+	metadata, err := readMetadata(id)
+	if err != nil {
+		return err
+	}
+	set(&metadata, name)
+	return writeMetadata(id, metadata)
+}
+
+// handlePageScreenshot serves a page's screenshot.
+func handlePageScreenshot(w http.ResponseWriter, r *http.Request) {
+	servePageFile(w, r, "image/png", func(page Page) string { return page.ScreenshotFilename })
+}
+
+// servePageFile serves the file of the requested page that name picks, or
+// a NOT_FOUND error if it has none.
+func servePageFile(w http.ResponseWriter, r *http.Request, contentType string, name func(Page) string) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		writeError(w, http.StatusMethodNotAllowed, ErrMethodNotAllowed, "Method not allowed")
+		return
+	}
+	page, err := loadPage(r.PathValue("id"))
+	if err != nil || name(page) == "" {
+		writeError(w, http.StatusNotFound, ErrNotFound, "File not found")
+		return
+	}
+	file, err := os.Open(filepath.Join(pagesDir, name(page)))
+	if err != nil {
+		writeError(w, http.StatusNotFound, ErrNotFound, "File not found")
+		return
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, ErrInternal, "Failed to read file")
+		return
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Cache-Control", "private, max-age=86400")
+	http.ServeContent(w, r, name(page), info.ModTime(), file)
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCaptureScreenshot(t *testing.T) {
+	withPagesDir(t)
+	withFakeBrowser(t)
+	id, err := storePage(PageMetadata{URL: "https://example.com/", Title: "Example"}, "<p>Example</p>", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := attachPageFile(id, id+"_thumb.png", []byte("old thumbnail"), func(m *PageMetadata, name string) { m.ThumbnailFilename = name }); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := captureScreenshot(context.Background(), id, "https://example.com/"); err != nil {
+		t.Fatal(err)
+	}
+	page, err := loadPage(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if page.ScreenshotFilename != id+".png" {
+		t.Errorf("screenshot file = %q", page.ScreenshotFilename)
+	}
+	if data, err := ioutil.ReadFile(filepath.Join(pagesDir, page.ScreenshotFilename)); !bytes.Equal(data, fakePNG) {
+		t.Errorf("screenshot = %q, %v", data, err)
+	}
+	// The thumbnail is made again from the new screenshot
+	if page.ThumbnailFilename != "" {
+		t.Errorf("thumbnail file = %q, want it cleared", page.ThumbnailFilename)
+	}
+	if _, err := os.Stat(filepath.Join(pagesDir, id+"_thumb.png")); !os.IsNotExist(err) {
+		t.Errorf("old thumbnail still stored: %v", err)
+	}
+
+	if err := captureScreenshot(context.Background(), id, "https://fail.example/"); err == nil {
+		t.Error("failed capture reported no error")
+	}
+}
+
+func TestHandlePageScreenshot(t *testing.T) {
+	withPagesDir(t)
+	shot, err := storePage(PageMetadata{URL: "https://example.com/shot"}, "<p>shot</p>", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := attachPageFile(shot, shot+".png", fakePNG, func(m *PageMetadata, name string) { m.ScreenshotFilename = name }); err != nil {
+		t.Fatal(err)
+	}
+	plain, err := storePage(PageMetadata{URL: "https://example.com/plain"}, "<p>plain</p>", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	get := func(method, id string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, "/pages/"+id+"/screenshot", nil)
+		r.SetPathValue("id", id)
+		rec := httptest.NewRecorder()
+		handlePageScreenshot(rec, r)
+		return rec
+	}
+	rec := get(http.MethodGet, shot)
+	if rec.Code != http.StatusOK || !bytes.Equal(rec.Body.Bytes(), fakePNG) {
+		t.Errorf("screenshot = %d %q", rec.Code, rec.Body)
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "image/png" {
+		t.Errorf("Content-Type = %q", ct)
+	}
+	if rec := get(http.MethodGet, plain); rec.Code != http.StatusNotFound || decodeError(t, rec).Code != ErrNotFound {
+		t.Errorf("page without a screenshot = %d", rec.Code)
+	}
+	if rec := get(http.MethodGet, "missing"); rec.Code != http.StatusNotFound {
+		t.Errorf("missing page = %d", rec.Code)
+	}
+	if rec := get(http.MethodPost, shot); rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("POST = %d, want 405", rec.Code)
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+func searchURLs(t *testing.T, q string, options SearchOptions) []string {
+	t.Helper()
+	if err := options.validate(); err != nil {
+		t.Fatal(err)
+	}
+	results, err := searchPages(context.Background(), q, options, Localizer{Lang: defaultLocale})
+	if err != nil {
+		t.Fatalf("search %q: %v", q, err)
+	}
+	var urls []string
+	for _, result := range results {
+		urls = append(urls, result.URL)
+	}
+	sort.Strings(urls)
+	return urls
+}
+
+func TestFuzzyAndPrefixSearch(t *testing.T) {
+	now := time.Now()
+	withIndex(t, map[string]PageDocument{
+		"k8s":  {URL: "https://k8s.io", Title: "Kubernetes", Content: "Kubernetes schedules containers", Time: now},
+		"helm": {URL: "https://helm.sh", Title: "Helm", Content: "Package manager for Kubernetes clusters", Time: now},
+		"go":   {URL: "https://go.dev", Title: "Go", Content: "A programming language", Time: now},
+	})
+
+	tests := []struct {
+		name    string
+		query   string
+		options SearchOptions
+		want    int
+	}{
+		{"exact typo misses", "kubernets", SearchOptions{}, 0},
+		{"fuzzy typo", "kubernets", SearchOptions{Fuzziness: 1}, 2},
+		{"fuzzy needs every word", "kubernets containrs", SearchOptions{Fuzziness: 1}, 1},
+		{"too far for fuzziness 1", "kubernts", SearchOptions{Fuzziness: 1}, 0},
+		{"fuzziness 2", "kubernts", SearchOptions{Fuzziness: 2}, 2},
+		{"prefix", "progr", SearchOptions{Prefix: true}, 1},
+		{"prefix is lowercased", "KUBER", SearchOptions{Prefix: true}, 2},
+		{"no prefix", "progr", SearchOptions{}, 0},
+	}
+	for _, tt := range tests {
+		if got := searchURLs(t, tt.query, tt.options); len(got) != tt.want {
+			t.Errorf("%s: got %v, want %d results", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestPhraseAndExactSearch(t *testing.T) {
+	saved := config.Search.Analysis
+	defer func() { config.Search.Analysis = saved }()
+	config.Search.Analysis = AnalysisConfig{Stemming: true}
+	withIndex(t, map[string]PageDocument{
+		"shoes": {URL: "https://shoes.example", Title: "Shoes", Content: "The best running shoes of the year"},
+		"run":   {URL: "https://run.example", Title: "Runs", Content: "Shoes for a run in the park"},
+	})
+
+	tests := []struct {
+		name    string
+		query   string
+		options SearchOptions
+		want    []string
+	}{
+		{"stemmed", "running", SearchOptions{}, []string{"https://run.example", "https://shoes.example"}},
+		{"phrase", `"running shoes"`, SearchOptions{}, []string{"https://shoes.example"}},
+		{"fuzzy phrase", `"runing shoes" yaer`, SearchOptions{Fuzziness: 1}, nil},
+		{"fuzzy phrase and word", `"running shoes" yeer`, SearchOptions{Fuzziness: 1}, []string{"https://shoes.example"}},
+		{"prefix phrase", `"shoes for" par`, SearchOptions{Prefix: true}, []string{"https://run.example"}},
+		{"exact", "running", SearchOptions{Exact: true}, []string{"https://shoes.example"}},
+		{"exact phrase", `"run shoes"`, SearchOptions{Exact: true}, nil},
+		{"exact title", "runs", SearchOptions{Exact: true}, []string{"https://run.example"}},
+		{"exact ignores fuzziness", "runnin", SearchOptions{Exact: true, Fuzziness: 2}, nil},
+	}
+	for _, tt := range tests {
+		if got := searchURLs(t, tt.query, tt.options); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestSplitQuery(t *testing.T) {
+	words, phrases := splitQuery(`go "state of the art" tools "unclosed quote`)
+	if !reflect.DeepEqual(words, []string{"go", "tools"}) || !reflect.DeepEqual(phrases, []string{"state of the art", "unclosed quote"}) {
+		t.Errorf("splitQuery = %q, %q", words, phrases)
+	}
+}
+
+func TestParseSearchOptions(t *testing.T) {
+	saved := config
+	defer func() { config = saved }()
+	config.Search.Fuzziness = 1
+
+	tests := []struct {
+		query     string
+		fuzziness int
+		prefix    bool
+		wantErr   bool
+	}{
+		{"", 1, false, false},
+		{"fuzziness=0", 0, false, false},
+		{"fuzziness=2&prefix=1", 2, true, false},
+		{"prefix=true", 1, true, false},
+		{"prefix=0", 1, false, false},
+		{"fuzziness=3", 0, false, true},
+		{"fuzziness=-1", 0, false, true},
+		{"fuzziness=lots", 0, false, true},
+		{"sort=size", 0, false, true},
+		{"highlight=bold", 0, false, true},
+		{"group_by=domain", 1, false, false},
+		{"group_by=size", 0, false, true},
+	}
+	for _, tt := range tests {
+		options, err := parseSearchOptions(httptest.NewRequest("GET", "/search?q=x&"+tt.query, nil))
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: error = %v, want error %v", tt.query, err, tt.wantErr)
+			continue
+		}
+		if err == nil && (options.Fuzziness != tt.fuzziness || options.Prefix != tt.prefix) {
+			t.Errorf("%s: got %+v", tt.query, options)
+		}
+	}
+	if options, _ := parseSearchOptions(httptest.NewRequest("GET", "/search?q=x&exact=1", nil)); !options.Exact {
+		t.Errorf("exact=1: got %+v", options)
+	}
+}
+
+func TestReadLaterFilters(t *testing.T) {
+	now := time.Now()
+	withIndex(t, map[string]PageDocument{
+		"new":  {URL: "https://example.com/new", Content: "espresso grind", Time: now},
+		"read": {URL: "https://example.com/read", Content: "espresso beans", Time: now, Read: true},
+		"fav":  {URL: "https://example.com/fav", Content: "espresso ratio", Time: now, Starred: true},
+		"done": {URL: "https://example.com/done", Content: "espresso tamp", Time: now, Starred: true, Read: true},
+	})
+	tests := []struct {
+		name    string
+		options SearchOptions
+		want    []string
+	}{
+		{"all", SearchOptions{}, []string{"https://example.com/done", "https://example.com/fav", "https://example.com/new", "https://example.com/read"}},
+		{"starred", SearchOptions{Starred: true}, []string{"https://example.com/done", "https://example.com/fav"}},
+		{"unread", SearchOptions{Unread: true}, []string{"https://example.com/fav", "https://example.com/new"}},
+		{"starred and unread", SearchOptions{Starred: true, Unread: true}, []string{"https://example.com/fav"}},
+	}
+	for _, tt := range tests {
+		if got := searchURLs(t, "espresso", tt.options); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
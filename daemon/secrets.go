@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	redacted             = "[redacted]"
+	secretCommandTimeout = 30 * time.Second
+)
+
+// Secret is a credential in the config file. It is either the secret itself
+// as a string, or an object naming where to read it from:
+//
+//	{"env": "MEMENTO_OIDC_SECRET"}
+//	{"file": "/run/secrets/oidc"}
+//	{"command": ["pass", "show", "memento/oidc"]}
+//
+// Secrets are only read when first used, and print and marshal as
+// [redacted] so they never end up in logs or API responses.
+type Secret struct {
+	source *secretSource
+}
+
+type secretSource struct {
+	Env     string   `json:"env"`
+	File    string   `json:"file"`
+	Command []string `json:"command"`
+
+	mu     sync.Mutex
+	loaded bool
+	value  string
+}
+
+// NewSecret returns a secret holding value itself.
+func NewSecret(value string) Secret {
+	return Secret{source: &secretSource{loaded: true, value: value}}
+}
+
+func (s *Secret) UnmarshalJSON(data []byte) error {
+	var literal string
+	if err := json.Unmarshal(data, &literal); err == nil {
+		*s = NewSecret(literal)
+		return nil
+	}
+	source := &secretSource{}
+	if err := json.Unmarshal(data, source); err != nil {
+		return fmt.Errorf("secret must be a string or an object with env, file or command: %v", err)
+	}
+	set := 0
+	for _, ok := range []bool{source.Env != "", source.File != "", len(source.Command) > 0} {
+		if ok {
+			set++
+		}
+	}
+	if set != 1 {
+		return fmt.Errorf("secret needs exactly one of env, file or command")
+	}
+	s.source = source
+	return nil
+}
+
+func (s Secret) MarshalJSON() ([]byte, error) {
+	if s.IsZero() {
+		return json.Marshal("")
+	}
+	return json.Marshal(redacted)
+}
+
+func (s Secret) String() string {
+	if s.IsZero() {
+		return ""
+	}
+	return redacted
+}
+
+func (s Secret) GoString() string {
+	return s.String()
+}
+
+// IsZero reports whether no secret is configured.
+func (s Secret) IsZero() bool {
+	src := s.source
+	return src == nil || (src.Env == "" && src.File == "" && len(src.Command) == 0 && src.value == "")
+}
+
+// Value reads the secret, caching it once read. Failures aren't cached, so a
+// password manager that was locked can be retried.
+func (s Secret) Value() (string, error) {
+	if s.source == nil {
+		return "", nil
+	}
+	src := s.source
+	src.mu.Lock()
+	defer src.mu.Unlock()
+	if src.loaded {
+		return src.value, nil
+	}
+
+	var value string
+	switch {
+	case src.Env != "":
+		v, ok := os.LookupEnv(src.Env)
+		if !ok {
+			return "", fmt.Errorf("environment variable %s is not set", src.Env)
+		}
+		value = v
+	case src.File != "":
+		data, err := ioutil.ReadFile(src.File)
+		if err != nil {
+			return "", fmt.Errorf("reading secret file: %v", err)
+		}
+		value = strings.TrimRight(string(data), "\r\n")
+	case len(src.Command) > 0:
+		ctx, cancel := context.WithTimeout(context.Background(), secretCommandTimeout)
+		defer cancel()
+		cmd := exec.CommandContext(ctx, src.Command[0], src.Command[1:]...)
+		var stderr bytes.Buffer
+		cmd.Stderr = &stderr
+		out, err := cmd.Output()
+		if err != nil {
+			return "", fmt.Errorf("running secret command %s: %v: %s", src.Command[0], err, strings.TrimSpace(stderr.String()))
+		}
+		// Password managers print the secret on the first line
+		value = strings.TrimRight(strings.SplitN(string(out), "\n", 2)[0], "\r")
+	}
+	src.value, src.loaded = value, true
+	return value, nil
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSecretSources(t *testing.T) {
+	os.Setenv("MEMENTO_TEST_SECRET", "from-env")
+	defer os.Unsetenv("MEMENTO_TEST_SECRET")
+	file := filepath.Join(t.TempDir(), "secret")
+	if err := os.WriteFile(file, []byte("from-file\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		json      string
+		want      string
+		wantErr   bool
+		wantParse bool
+	}{
+		{"literal", `"plain"`, "plain", false, false},
+		{"empty", `""`, "", false, false},
+		{"env", `{"env": "MEMENTO_TEST_SECRET"}`, "from-env", false, false},
+		{"unset env", `{"env": "MEMENTO_TEST_UNSET"}`, "", true, false},
+		{"file", fmt.Sprintf(`{"file": %q}`, file), "from-file", false, false},
+		{"missing file", `{"file": "/nonexistent/secret"}`, "", true, false},
+		{"command", `{"command": ["printf", "from-command\nsecond line"]}`, "from-command", false, false},
+		{"failing command", `{"command": ["false"]}`, "", true, false},
+		{"two sources", `{"env": "A", "file": "B"}`, "", false, true},
+		{"no source", `{}`, "", false, true},
+		{"wrong type", `42`, "", false, true},
+	}
+	for _, tt := range tests {
+		var secret Secret
+		err := json.Unmarshal([]byte(tt.json), &secret)
+		if (err != nil) != tt.wantParse {
+			t.Errorf("%s: parse error = %v, want error %v", tt.name, err, tt.wantParse)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		got, err := secret.Value()
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("%s: Value() = %q, %v; want %q, error %v", tt.name, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestSecretIsLazyAndCached(t *testing.T) {
+	var secret Secret
+	if err := json.Unmarshal([]byte(`{"env": "MEMENTO_TEST_LAZY"}`), &secret); err != nil {
+		t.Fatal(err)
+	}
+	// Not read at load time, so it can be set afterwards
+	os.Setenv("MEMENTO_TEST_LAZY", "first")
+	if got, _ := secret.Value(); got != "first" {
+		t.Fatalf("got %q, want first", got)
+	}
+	os.Setenv("MEMENTO_TEST_LAZY", "second")
+	defer os.Unsetenv("MEMENTO_TEST_LAZY")
+	if got, _ := secret.Value(); got != "first" {
+		t.Errorf("got %q after change, want cached first", got)
+	}
+}
+
+func TestSecretRedaction(t *testing.T) {
+	var cfg struct {
+		Tokens []TokenConfig `json:"tokens"`
+	}
+	if err := json.Unmarshal([]byte(`{"tokens": [{"name": "alex", "token": "hunter2"}, {"name": "empty"}]}`), &cfg); err != nil {
+		t.Fatal(err)
+	}
+	out, err := json.Marshal(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{string(out), fmt.Sprintf("%v", cfg), fmt.Sprintf("%+v", cfg), fmt.Sprintf("%#v", cfg.Tokens[0].Token)} {
+		if strings.Contains(s, "hunter2") {
+			t.Errorf("secret leaked in %s", s)
+		}
+	}
+	if !strings.Contains(string(out), redacted) {
+		t.Errorf("marshalled config %s doesn't mark the secret", out)
+	}
+	if !cfg.Tokens[1].Token.IsZero() || cfg.Tokens[0].Token.IsZero() {
+		t.Error("IsZero is wrong")
+	}
+}
@@ -0,0 +1,611 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/blevesearch/bleve"
+	"github.com/blevesearch/bleve/search"
+)
+
+const (
+	// embeddingsDir holds the vectors of each page's chunks, one file per
+	// page, beside the index.
+	embeddingsDir = "memento_embeddings"
+
+	defaultEmbeddingURL   = "http://localhost:11434/api/embed"
+	defaultEmbeddingModel = "nomic-embed-text"
+
+	// embedBatchSize is how many chunks are sent to the model at once.
+	embedBatchSize = 16
+	embedTimeout   = 2 * time.Minute
+	// embedSweepInterval is how often pages are looked over for missing
+	// vectors when no page has changed.
+	embedSweepInterval = 10 * time.Minute
+	// semanticCandidates is how many of the most similar pages are looked
+	// up in the index at a time, to drop those the search can't return.
+	semanticCandidates = 100
+)
+
+// SemanticConfig turns on searching pages by meaning. Pages are split into
+// chunks of ChunkWords words, and each chunk is turned into a vector by the
+// embedding model at URL, such as a local Ollama's /api/embed or any
+// endpoint taking OpenAI's /v1/embeddings requests.
+type SemanticConfig struct {
+	Enabled    bool   `json:"enabled"`
+	URL        string `json:"url"`
+	Model      string `json:"model"`
+	APIKey     Secret `json:"apiKey"`
+	ChunkWords int    `json:"chunkWords"`
+	// HybridWeight is the share of a hybrid search's ranking that comes
+	// from meaning rather than keywords, from 0 to 1.
+	HybridWeight float64 `json:"hybridWeight"`
+}
+
+var embeddingClient = &http.Client{Timeout: embedTimeout}
+
+// errModelUnavailable is returned when a model endpoint can't be used.
+var errModelUnavailable = errors.New("model unavailable")
+
+// pageEmbedding is the vectors of a page's chunks, in order. Key tells
+// whether they were computed from the page's current text by the
+// configured model.
+type pageEmbedding struct {
+	Key     string      `json:"key"`
+	Vectors [][]float32 `json:"vectors"`
+}
+
+// embeddings holds every page's vectors in memory for searching.
+var embeddings struct {
+	sync.RWMutex
+	loaded bool
+	byID   map[string]pageEmbedding
+}
+
+// validateSemantic checks the semantic search settings.
+func validateSemantic(c SemanticConfig) error {
+	if c.ChunkWords < 1 {
+		return fmt.Errorf("semantic.chunkWords must be at least 1")
+	}
+	if c.HybridWeight < 0 || c.HybridWeight > 1 {
+		return fmt.Errorf("semantic.hybridWeight must be between 0 and 1")
+	}
+	if c.Enabled && (c.URL == "" || c.Model == "") {
+		return fmt.Errorf("semantic.url and semantic.model are required")
+	}
+	return nil
+}
+
+func embeddingPath(id string) string {
+	return filepath.Join(embeddingsDir, id+".json")
+}
+
+// loadEmbeddings reads the stored vectors into memory, once.
+func loadEmbeddings() error {
+	embeddings.Lock()
+	defer embeddings.Unlock()
+	if embeddings.loaded {
+		return nil
+	}
+	byID := make(map[string]pageEmbedding)
+	files, err := ioutil.ReadDir(embeddingsDir)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for _, file := range files {
+		id := strings.TrimSuffix(file.Name(), ".json")
+		if file.IsDir() || id == file.Name() {
+			continue
+		}
+		data, err := ioutil.ReadFile(embeddingPath(id))
+		if err != nil {
+			return err
+		}
+		var e pageEmbedding
+		if err := json.Unmarshal(data, &e); err != nil {
+			log.Printf("Error reading %s: %v", embeddingPath(id), err)
+			continue
+		}
+		byID[id] = e
+	}
+	embeddings.byID = byID
+	embeddings.loaded = true
+	return nil
+}
+
+// storeEmbedding keeps a page's vectors on disk and in memory.
+func storeEmbedding(id string, e pageEmbedding) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(embeddingsDir, 0755); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(embeddingPath(id), data, 0644); err != nil {
+		return err
+	}
+	embeddings.Lock()
+	embeddings.byID[id] = e
+	embeddings.Unlock()
+	return nil
+}
+
+// dropEmbedding forgets a page's vectors.
+func dropEmbedding(id string) error {
+	embeddings.Lock()
+	delete(embeddings.byID, id)
+	embeddings.Unlock()
+	if err := os.Remove(embeddingPath(id)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// embeddingKey identifies what a page's vectors are computed from: the
+// model, the chunk size, and the page's title and text.
+func embeddingKey(page Page) (string, error) {
+	hash := contentHash([]byte(page.Note))
+	if !page.Bookmark {
+		var err error
+		if hash, err = pageContentHash(page.PageMetadata); err != nil {
+			return "", err
+		}
+	}
+	c := config.Semantic
+	return contentHash([]byte(strings.Join([]string{c.Model, strconv.Itoa(c.ChunkWords), page.Title, hash}, "\n"))), nil
+}
+
+// pageChunks splits a page's title and text into the chunks it is
+// embedded as.
+func pageChunks(page Page) ([]string, error) {
+	text := page.Note
+	if !page.Bookmark {
+		var err error
+		if text, err = readPageText(page.PageMetadata); err != nil {
+			return nil, err
+		}
+	}
+	return chunkText(page.Title+"\n"+text, config.Semantic.ChunkWords), nil
+}
+
+// chunkText splits text into runs of up to size words.
+func chunkText(text string, size int) []string {
+	words := strings.Fields(text)
+	var chunks []string
+	for start := 0; start < len(words); start += size {
+		end := start + size
+		if end > len(words) {
+			end = len(words)
+		}
+		chunks = append(chunks, strings.Join(words[start:end], " "))
+	}
+	return chunks
+}
+
+// embeddingResponse is the answer of either an Ollama or an
+// OpenAI-compatible embedding endpoint.
+type embeddingResponse struct {
+	Embeddings [][]float32 `json:"embeddings"`
+	Data       []struct {
+		Index     int       `json:"index"`
+		Embedding []float32 `json:"embedding"`
+	} `json:"data"`
+}
+
+// embed turns texts into unit vectors with the configured model.
+func embed(ctx context.Context, texts []string) ([][]float32, error) {
+	c := config.Semantic
+	body, err := json.Marshal(map[string]interface{}{"model": c.Model, "input": texts})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.URL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if !c.APIKey.IsZero() {
+		key, err := c.APIKey.Value()
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Authorization", "Bearer "+key)
+	}
+	resp, err := embeddingClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errModelUnavailable, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%w: %s answered %s", errModelUnavailable, c.URL, resp.Status)
+	}
+	var answer embeddingResponse
+	if err := json.NewDecoder(resp.Body).Decode(&answer); err != nil {
+		return nil, fmt.Errorf("%w: %v", errModelUnavailable, err)
+	}
+	vectors := answer.Embeddings
+	if len(answer.Data) > 0 {
+		vectors = make([][]float32, len(answer.Data))
+		for i, d := range answer.Data {
+			if d.Index >= 0 && d.Index < len(vectors) {
+				i = d.Index
+			}
+			vectors[i] = d.Embedding
+		}
+	}
+	if len(vectors) != len(texts) {
+		return nil, fmt.Errorf("%w: %s returned %d vectors for %d texts", errModelUnavailable, c.URL, len(vectors), len(texts))
+	}
+	for _, v := range vectors {
+		normalize(v)
+	}
+	return vectors, nil
+}
+
+// normalize scales v to unit length, so that dot products are cosine
+// similarities.
+func normalize(v []float32) {
+	var sum float64
+	for _, x := range v {
+		sum += float64(x) * float64(x)
+	}
+	if sum == 0 {
+		return
+	}
+	norm := float32(math.Sqrt(sum))
+	for i := range v {
+		v[i] /= norm
+	}
+}
+
+// embedPage computes and stores the vectors of a page's chunks.
+func embedPage(ctx context.Context, page Page, key string) error {
+	chunks, err := pageChunks(page)
+	if err != nil {
+		return err
+	}
+	e := pageEmbedding{Key: key, Vectors: [][]float32{}}
+	for start := 0; start < len(chunks); start += embedBatchSize {
+		end := start + embedBatchSize
+		if end > len(chunks) {
+			end = len(chunks)
+		}
+		vectors, err := embed(ctx, chunks[start:end])
+		if err != nil {
+			return err
+		}
+		e.Vectors = append(e.Vectors, vectors...)
+	}
+	return storeEmbedding(page.ID, e)
+}
+
+// embedPending embeds the current captures whose vectors are missing or
+// out of date, and drops the vectors of pages deleted or replaced since.
+// It stops at the first failure to reach the model.
+func embedPending(ctx context.Context) (int, error) {
+	if err := loadEmbeddings(); err != nil {
+		return 0, err
+	}
+	pages, err := listPages()
+	if err != nil {
+		return 0, err
+	}
+	current := make(map[string]bool)
+	count := 0
+	for _, page := range pages {
+		if page.SupersededBy != "" {
+			continue
+		}
+		current[page.ID] = true
+		key, err := embeddingKey(page)
+		if err != nil {
+			log.Printf("Error reading page %s to embed: %v", page.ID, err)
+			continue
+		}
+		embeddings.RLock()
+		stored, ok := embeddings.byID[page.ID]
+		embeddings.RUnlock()
+		if ok && stored.Key == key {
+			continue
+		}
+		if err := embedPage(ctx, page, key); err != nil {
+			if errors.Is(err, errModelUnavailable) || ctx.Err() != nil {
+				return count, err
+			}
+			log.Printf("Error embedding page %s: %v", page.ID, err)
+			continue
+		}
+		count++
+	}
+
+	embeddings.RLock()
+	var gone []string
+	for id := range embeddings.byID {
+		if !current[id] {
+			gone = append(gone, id)
+		}
+	}
+	embeddings.RUnlock()
+	for _, id := range gone {
+		if err := dropEmbedding(id); err != nil {
+			log.Printf("Error removing the vectors of %s: %v", id, err)
+		}
+	}
+	return count, nil
+}
+
+// watchSemantic keeps the pages' vectors up to date, looking them over
+// whenever a page is indexed or deleted, and every embedSweepInterval.
+func watchSemantic() {
+	if !config.Semantic.Enabled {
+		return
+	}
+	ch := events.subscribe()
+	for {
+		count, err := embedPending(context.Background())
+		if err != nil {
+			log.Printf("Error embedding pages: %v", err)
+		}
+		if count > 0 {
+			log.Printf("Embedded %d pages for semantic search", count)
+		}
+		timeout := time.After(embedSweepInterval)
+	wait:
+		for {
+			select {
+			case event := <-ch:
+				if event.Type == eventPageIndexed || event.Type == eventPageDeleted {
+					break wait
+				}
+			case <-timeout:
+				break wait
+			}
+		}
+	}
+}
+
+// semanticHit is a page with the similarity of its closest chunk to a
+// query.
+type semanticHit struct {
+	ID    string
+	Score float64
+	Chunk int
+}
+
+// similarPages ranks the embedded pages by their closest chunk to vector,
+// most similar first.
+func similarPages(vector []float32) []semanticHit {
+	embeddings.RLock()
+	defer embeddings.RUnlock()
+	hits := make([]semanticHit, 0, len(embeddings.byID))
+	for id, e := range embeddings.byID {
+		best := semanticHit{ID: id, Score: math.Inf(-1)}
+		for i, v := range e.Vectors {
+			if len(v) != len(vector) {
+				continue
+			}
+			var dot float64
+			for j := range v {
+				dot += float64(v[j]) * float64(vector[j])
+			}
+			if dot > best.Score {
+				best.Score, best.Chunk = dot, i
+			}
+		}
+		if !math.IsInf(best.Score, -1) {
+			hits = append(hits, best)
+		}
+	}
+	sort.Slice(hits, func(i, j int) bool {
+		if hits[i].Score != hits[j].Score {
+			return hits[i].Score > hits[j].Score
+		}
+		return hits[i].ID < hits[j].ID
+	})
+	return hits
+}
+
+// semanticSearch finds the pages closest in meaning to text, among those a
+// search with options may return. Each result's snippet is its closest
+// chunk, and its score that chunk's cosine similarity to text.
+func semanticSearch(ctx context.Context, text string, options SearchOptions, localizer Localizer) ([]SearchResult, error) {
+	limit := searchResultLimit
+	if options.GroupBy != "" {
+		limit = groupedSearchDepth
+	}
+	hits, matches, err := nearestPages(ctx, text, options, limit)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	results := []SearchResult{}
+	for i, hit := range hits {
+		result := hitResult(matches[i], options, localizer, now)
+		result.Score = hit.Score
+		if options.wants("snippet") || options.wants("highlights") {
+			result.Snippet, result.Highlights = formatSnippet(contextSnippet(hitChunk(hit), nil, options.SnippetLength), options.Highlight)
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// nearestPages returns up to limit of the pages closest in meaning to text
+// that a search with options may return, with their index documents.
+func nearestPages(ctx context.Context, text string, options SearchOptions, limit int) ([]semanticHit, []*search.DocumentMatch, error) {
+	if err := loadEmbeddings(); err != nil {
+		return nil, nil, err
+	}
+	vectors, err := embed(ctx, []string{text})
+	if err != nil {
+		return nil, nil, err
+	}
+	hits := similarPages(vectors[0])
+
+	var nearest []semanticHit
+	var documents []*search.DocumentMatch
+	for start := 0; start < len(hits) && len(nearest) < limit; start += semanticCandidates {
+		end := start + semanticCandidates
+		if end > len(hits) {
+			end = len(hits)
+		}
+		ids := make([]string, 0, end-start)
+		for _, hit := range hits[start:end] {
+			ids = append(ids, hit.ID)
+		}
+		// The index applies the owner, as_of and other filters
+		request := bleve.NewSearchRequest(snapshotQuery(bleve.NewDocIDQuery(ids), options))
+		request.Fields = options.storedFields()
+		request.Size = len(ids)
+		found, err := index.Search(request)
+		if err != nil {
+			return nil, nil, err
+		}
+		matches := make(map[string]*search.DocumentMatch, len(found.Hits))
+		for _, match := range found.Hits {
+			matches[match.ID] = match
+		}
+		for _, hit := range hits[start:end] {
+			if match, ok := matches[hit.ID]; ok && len(nearest) < limit {
+				nearest = append(nearest, hit)
+				documents = append(documents, match)
+			}
+		}
+	}
+	return nearest, documents, nil
+}
+
+// hitChunk returns the text of a hit's closest chunk.
+func hitChunk(hit semanticHit) string {
+	page, err := loadPage(hit.ID)
+	if err != nil {
+		return ""
+	}
+	chunks, err := pageChunks(page)
+	if err != nil || hit.Chunk >= len(chunks) {
+		return ""
+	}
+	return chunks[hit.Chunk]
+}
+
+// hybridSearch ranks pages by both their keyword relevance and their
+// closeness in meaning to text. Each score is scaled to the best of its
+// kind, and the two are blended by semantic.hybridWeight. Results found by
+// keyword keep their highlighted snippets.
+func hybridSearch(ctx context.Context, text string, options SearchOptions, localizer Localizer) ([]SearchResult, error) {
+	keyword, err := searchPages(ctx, text, options, localizer)
+	if err != nil {
+		return nil, err
+	}
+	semantic, err := semanticSearch(ctx, text, options, localizer)
+	if err != nil {
+		return nil, err
+	}
+	weight := config.Semantic.HybridWeight
+	blended := make(map[string]*SearchResult)
+	var results []*SearchResult
+	add := func(found []SearchResult, weight float64) {
+		best := 0.0
+		for _, result := range found {
+			best = math.Max(best, result.Score)
+		}
+		for i := range found {
+			score := 0.0
+			if best > 0 {
+				score = weight * found[i].Score / best
+			}
+			if result, ok := blended[found[i].ID]; ok {
+				result.Score += score
+				continue
+			}
+			result := found[i]
+			result.Score = score
+			blended[result.ID] = &result
+			results = append(results, &result)
+		}
+	}
+	add(keyword, 1-weight)
+	add(semantic, weight)
+
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].Score > results[j].Score
+	})
+	limit := searchResultLimit
+	if options.GroupBy != "" {
+		limit = groupedSearchDepth
+	}
+	ranked := []SearchResult{}
+	for _, result := range results {
+		if len(ranked) == limit {
+			break
+		}
+		ranked = append(ranked, *result)
+	}
+	return ranked, nil
+}
+
+// handleSemanticSearch finds the pages closest in meaning to q, or with
+// hybrid=1 ranks pages by keywords and meaning together. It takes the
+// filters of /search.
+func handleSemanticSearch(w http.ResponseWriter, r *http.Request) {
+	if !config.Semantic.Enabled {
+		writeError(w, http.StatusNotFound, ErrNotFound, "Semantic search is not enabled")
+		return
+	}
+	ctx, span := startRequestSpan(r, "search.semantic")
+	defer span.End()
+	query := r.URL.Query().Get("q")
+	if query == "" {
+		writeError(w, http.StatusBadRequest, ErrInvalidRequest, "Missing query parameter")
+		return
+	}
+	options, err := parseSearchOptions(r)
+	if err != nil {
+		writeSearchOptionsError(w, err)
+		return
+	}
+	if !allowSearch(w, r) {
+		return
+	}
+
+	hybrid := r.URL.Query().Get("hybrid")
+	var results []SearchResult
+	if hybrid == "1" || hybrid == "true" {
+		results, err = hybridSearch(ctx, query, options, localizerFor(r))
+	} else {
+		results, err = semanticSearch(ctx, query, options, localizerFor(r))
+	}
+	span.RecordError(err)
+	switch {
+	case errors.Is(err, errInvalidQuery):
+		writeError(w, http.StatusBadRequest, ErrInvalidRequest, err.Error())
+		return
+	case errors.Is(err, errModelUnavailable):
+		log.Printf("Semantic search error: %v", err)
+		writeError(w, http.StatusBadGateway, ErrModelUnavailable, "The embedding model could not be reached")
+		return
+	case err != nil:
+		log.Printf("Semantic search error: %v", err)
+		writeError(w, http.StatusServiceUnavailable, ErrIndexUnavailable, "Search failed")
+		return
+	}
+	if err := recordSearch(r, query, len(results), true); err != nil {
+		log.Printf("Error recording search: %v", err)
+	}
+	writeSearchResults(w, results, options)
+}
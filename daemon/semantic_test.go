@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+// topicModel embeds texts by how often they mention cats, dogs and cars, in
+// Ollama's format.
+func topicModel(t *testing.T) *httptest.Server {
+	topics := [][]string{{"cat", "kitten"}, {"dog", "puppy"}, {"car", "engine"}}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var request struct {
+			Model string   `json:"model"`
+			Input []string `json:"input"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.Model != "topics" {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		var response embeddingResponse
+		for _, text := range request.Input {
+			vector := make([]float32, len(topics)+1)
+			vector[len(topics)] = 0.1
+			for _, word := range strings.Fields(strings.ToLower(text)) {
+				for i, topic := range topics {
+					for _, w := range topic {
+						if strings.HasPrefix(word, w) {
+							vector[i]++
+						}
+					}
+				}
+			}
+			response.Embeddings = append(response.Embeddings, vector)
+		}
+		json.NewEncoder(w).Encode(response)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestSemanticSearch(t *testing.T) {
+	withPagesDir(t)
+	saved := config.Semantic
+	defer func() { config.Semantic = saved }()
+	config.Semantic = SemanticConfig{Enabled: true, URL: topicModel(t).URL, Model: "topics", ChunkWords: 5, HybridWeight: 0.5}
+	embeddings.loaded = false
+	defer func() { embeddings.loaded = false }()
+
+	docs := map[string]PageDocument{}
+	ids := map[string]string{}
+	for name, content := range map[string]string{
+		"cats":  "Feeding a kitten. Cats sleep most of the day and a cat purrs.",
+		"dogs":  "Training a puppy takes patience, as any dog owner knows.",
+		"cars":  "Changing the oil keeps a car engine running for longer.",
+		"mixed": "Notes about gardening. Weeds grow back. Water daily. Also my cat.",
+	} {
+		metadata := PageMetadata{URL: "https://" + name + ".example/", Title: name}
+		id, err := storePage(metadata, "", content)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids[name] = id
+		docs[id] = PageDocument{URL: metadata.URL, Title: name, Content: content}
+	}
+	withIndex(t, docs)
+
+	count, err := embedPending(context.Background())
+	if err != nil || count != 4 {
+		t.Fatalf("embedPending = %d, %v, want 4", count, err)
+	}
+	if _, err := os.Stat(embeddingPath(ids["cats"])); err != nil {
+		t.Errorf("vectors not stored: %v", err)
+	}
+	if count, _ := embedPending(context.Background()); count != 0 {
+		t.Errorf("embedded %d unchanged pages again", count)
+	}
+
+	results, err := semanticSearch(context.Background(), "kitten cat", SearchOptions{Highlight: "none"}, Localizer{Lang: defaultLocale})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 4 || results[0].ID != ids["cats"] || results[1].ID != ids["mixed"] {
+		t.Fatalf("results = %+v", results)
+	}
+	// The snippet is the chunk about cats, not the start of the page
+	if !strings.Contains(results[1].Snippet, "my cat") {
+		t.Errorf("snippet = %q", results[1].Snippet)
+	}
+
+	// Hybrid search lifts pages that also match the keywords
+	results, err = hybridSearch(context.Background(), "gardening cat", SearchOptions{Highlight: "none", Sort: "score"}, Localizer{Lang: defaultLocale})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) == 0 || results[0].ID != ids["mixed"] {
+		t.Errorf("hybrid results = %+v", results)
+	}
+
+	// Deleted pages lose their vectors
+	os.Remove(metadataPath(ids["dogs"]))
+	embedPending(context.Background())
+	if _, err := os.Stat(embeddingPath(ids["dogs"])); !os.IsNotExist(err) {
+		t.Errorf("vectors of a deleted page kept: %v", err)
+	}
+
+	config.Semantic.URL = "http://127.0.0.1:1/"
+	if _, err := semanticSearch(context.Background(), "cats", SearchOptions{}, Localizer{Lang: defaultLocale}); err == nil {
+		t.Error("searched without a model")
+	}
+}
+
+func TestChunkText(t *testing.T) {
+	chunks := chunkText("one two three\nfour five", 2)
+	if len(chunks) != 3 || chunks[0] != "one two" || chunks[2] != "five" {
+		t.Errorf("chunks = %q", chunks)
+	}
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"html"
+	"io/ioutil"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+var headStartRe = regexp.MustCompile(`(?i)<head\b[^>]*>`)
+
+// inliner turns the resources of one page into data URIs. Stored assets are
+// read from disk and anything else is fetched live.
+type inliner struct {
+	id      string
+	fetcher *assetCapture
+	// uris caches the data URI of each reference, or "" for those that
+	// couldn't be inlined or are being inlined.
+	uris map[string]string
+}
+
+// singleFileHTML returns a page as one self-contained HTML document, its
+// images, stylesheets and fonts inlined as data URIs and its scripts
+// removed.
+func singleFileHTML(ctx context.Context, page Page) (string, error) {
+	var doc string
+	if page.HTMLFilename != "" {
+		data, err := readContentFile(filepath.Join(pagesDir, page.HTMLFilename))
+		if err != nil {
+			return "", err
+		}
+		doc = sanitizeHTML(string(data), contentPolicyStandard)
+	} else {
+		content, err := readerContent(page.PageMetadata)
+		if err != nil {
+			return "", err
+		}
+		doc = fmt.Sprintf("<!DOCTYPE html>\n<html><head><meta charset=\"utf-8\"><title>%s</title></head>\n<body>\n%s</body></html>\n",
+			html.EscapeString(page.Title), content)
+	}
+
+	base := documentBase(doc, page.URL)
+	in := &inliner{id: page.ID, fetcher: &assetCapture{ctx: ctx, id: page.ID}, uris: make(map[string]string)}
+	doc = rewriteHTMLAssets(doc, in.resolver(base), in.dataURI)
+
+	// Links keep working when the file is opened from disk
+	if webURL(page.URL) && base.String() == page.URL {
+		tag := `<base href="` + html.EscapeString(page.URL) + `">`
+		if loc := headStartRe.FindStringIndex(doc); loc != nil {
+			doc = doc[:loc[1]] + tag + doc[loc[1]:]
+		} else {
+			doc = tag + doc
+		}
+	}
+	return doc, nil
+}
+
+// resolver resolves references to the page's stored assets as they are,
+// and others against base.
+func (in *inliner) resolver(base *url.URL) func(string) string {
+	return func(ref string) string {
+		if _, ok := assetPath(in.id, ref); ok {
+			return ref
+		}
+		return resolveAsset(base, ref)
+	}
+}
+
+// storedResolver resolves the references of a stored stylesheet, which
+// name assets stored next to it.
+func (in *inliner) storedResolver(ref string) string {
+	if file, ok := assetPath(in.id, assetsPrefix+ref); ok {
+		if _, err := os.Stat(file); err == nil {
+			return assetsPrefix + strings.TrimPrefix(ref, "./")
+		}
+	}
+	return ""
+}
+
+// dataURI returns the data URI of a reference resolved by resolver, or ""
+// if it can't be read or fetched.
+func (in *inliner) dataURI(ref, ext string) string {
+	if uri, ok := in.uris[ref]; ok {
+		return uri
+	}
+	// A stylesheet importing itself stops here
+	in.uris[ref] = ""
+
+	var data []byte
+	var contentType string
+	var resolve func(string) string
+	if file, ok := assetPath(in.id, ref); ok {
+		var err error
+		if data, err = ioutil.ReadFile(file); err != nil {
+			return ""
+		}
+		if filepath.Ext(file) != "" {
+			ext = filepath.Ext(file)
+		}
+		resolve = in.storedResolver
+	} else {
+		var err error
+		if data, contentType, err = in.fetcher.download(ref); err != nil {
+			log.Printf("Error fetching %s to inline in %s: %v", ref, in.id, err)
+			return ""
+		}
+		base, _ := url.Parse(ref)
+		resolve = webAssets(base)
+	}
+	if contentType == "" || contentType == "application/octet-stream" {
+		contentType = mime.TypeByExtension(ext)
+	}
+	if contentType == "" {
+		contentType = http.DetectContentType(data)
+	}
+	if mediaType, _, _ := mime.ParseMediaType(contentType); mediaType == "text/css" {
+		contentType = "text/css"
+		data = []byte(rewriteCSSAssets(string(data), resolve, in.dataURI))
+	}
+	uri := "data:" + contentType + ";base64," + base64.StdEncoding.EncodeToString(data)
+	in.uris[ref] = uri
+	return uri
+}
+
+// handlePageSingleFile serves a page as a single HTML file to download.
+func handlePageSingleFile(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, ErrMethodNotAllowed, "Method not allowed")
+		return
+	}
+	if !requireAction(w, "read") {
+		return
+	}
+	page, err := loadPage(r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusNotFound, ErrNotFound, "Page not found")
+		return
+	}
+	doc, err := singleFileHTML(r.Context(), page)
+	if err != nil {
+		log.Printf("Error building single-file page %s: %v", page.ID, err)
+		writeError(w, http.StatusInternalServerError, ErrInternal, "Failed to read page")
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", page.ID+".html"))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Write([]byte(doc))
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestSingleFileHTML(t *testing.T) {
+	withPagesDir(t)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/logo.png", "/bg.png":
+			w.Header().Set("Content-Type", "image/png")
+			w.Write(fakePNG)
+		case "/site.css":
+			w.Header().Set("Content-Type", "text/css")
+			fmt.Fprint(w, `@import "site.css"; body { background: url(bg.png) }`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+	saved := archiveClient
+	archiveClient = server.Client()
+	defer func() { archiveClient = saved }()
+
+	page := `<html><head><title>Post</title><link rel="stylesheet" href="/site.css"></head><body>
+<img src="logo.png"><img src="files/stored.svg"><img src="/missing.png">
+<script>alert(1)</script><a href="/other">Other</a>
+</body></html>`
+	id, err := storePage(PageMetadata{URL: server.URL + "/post", Title: "Post"}, page, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(assetsDir(id), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(assetsDir(id)+"/stored.svg", []byte("<svg/>"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := loadPage(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	doc, err := singleFileHTML(context.Background(), loaded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	png := "data:image/png;base64," + base64.StdEncoding.EncodeToString(fakePNG)
+	css := `body { background: url("` + png + `") }`
+	tests := []struct {
+		name string
+		want string
+		ok   bool
+	}{
+		{"live image", `<img src="` + png + `">`, true},
+		{"stored asset", `<img src="data:image/svg+xml;base64,` + base64.StdEncoding.EncodeToString([]byte("<svg/>")) + `">`, true},
+		{"stylesheet", `href="data:text/css;base64,` + base64.StdEncoding.EncodeToString([]byte(`@import "site.css"; `+css)) + `"`, true},
+		// Left as it was, resolved through the base
+		{"missing image", `<img src="/missing.png">`, true},
+		{"base", `<base href="` + server.URL + `/post">`, true},
+		{"script", "alert(1)", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if strings.Contains(doc, tt.want) != tt.ok {
+				t.Errorf("contains %q = %v, want %v in:\n%s", tt.want, !tt.ok, tt.ok, doc)
+			}
+		})
+	}
+}
+
+func TestHandlePageSingleFile(t *testing.T) {
+	withPagesDir(t)
+	id, err := storePage(PageMetadata{URL: "https://example.com/a", Title: "A"}, "<html><body><p>Hello</p></body></html>", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		method string
+		id     string
+		status int
+	}{
+		{"page", http.MethodGet, id, http.StatusOK},
+		{"missing", http.MethodGet, "missing", http.StatusNotFound},
+		{"post", http.MethodPost, id, http.StatusMethodNotAllowed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "/pages/"+tt.id+"/singlefile", nil)
+			req.SetPathValue("id", tt.id)
+			w := httptest.NewRecorder()
+			handlePageSingleFile(w, req)
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+			if tt.status != http.StatusOK {
+				return
+			}
+			if got := w.Header().Get("Content-Disposition"); got != `attachment; filename="`+id+`.html"` {
+				t.Errorf("Content-Disposition = %q", got)
+			}
+			if !strings.Contains(w.Body.String(), "<p>Hello</p>") {
+				t.Errorf("body = %s", w.Body)
+			}
+		})
+	}
+}
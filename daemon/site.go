@@ -0,0 +1,665 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// The limits of a site crawl: how many pages it archives, how deeply
+	// sitemap indexes nest or links are followed, the pause between
+	// requests to the site, which its robots.txt can lengthen, and how
+	// many pages are fetched at once.
+	defaultSitePages       = 500
+	maxSitePages           = 5000
+	defaultSiteDepth       = 2
+	maxSiteDepth           = 5
+	defaultSiteDelay       = 1
+	maxSiteDelay           = 60
+	defaultSiteConcurrency = 2
+	maxSiteConcurrency     = 8
+	// maxSitemapBytes is the size limit of the sitemap protocol, which
+	// applies after decompression.
+	maxSitemapBytes = 50 << 20
+	// robotsAgent is the name robots.txt rules are looked up under.
+	robotsAgent = "memento"
+)
+
+// Site crawl modes: the pages a site's sitemaps list, or those reached by
+// following links from the page given.
+const (
+	siteCrawlSitemap = "sitemap"
+	siteCrawlLinks   = "links"
+)
+
+// Site crawl states.
+const (
+	siteCrawlRunning   = "running"
+	siteCrawlDone      = "done"
+	siteCrawlFailed    = "failed"
+	siteCrawlCancelled = "cancelled"
+)
+
+// SiteCrawl is the archiving of every page a site's sitemap lists, or of
+// the pages on a site linked from a page. Each crawl runs as a job, which
+// starts it over if the daemon stops during it, but crawls themselves are
+// kept in memory, so their details are forgotten when it restarts.
+type SiteCrawl struct {
+	ID   string `json:"id"`
+	Mode string `json:"mode"`
+	// Site is the domain, sitemap or page URL asked for, and Sitemaps the
+	// sitemaps read.
+	Site     string   `json:"site"`
+	Sitemaps []string `json:"sitemaps,omitempty"`
+	Tags     []string `json:"tags,omitempty"`
+	MaxPages int      `json:"maxPages"`
+	MaxDepth int      `json:"maxDepth"`
+	// Include and Exclude are regular expressions a page's URL must match
+	// one of, if any are given, and none of.
+	Include []string `json:"include,omitempty"`
+	Exclude []string `json:"exclude,omitempty"`
+	// DelaySeconds is the pause between requests, after robots.txt has
+	// had its say, however many of the Concurrency fetches run at once.
+	DelaySeconds float64    `json:"delaySeconds"`
+	Concurrency  int        `json:"concurrency"`
+	Owner        string     `json:"owner,omitempty"`
+	Status       string     `json:"status"`
+	Started      time.Time  `json:"started"`
+	Finished     *time.Time `json:"finished,omitempty"`
+
+	// Found counts the pages listed or linked for the crawl, Blocked those
+	// robots.txt keeps it from, Duplicates those already archived, and
+	// Skipped those that aren't pages, such as images.
+	Found      int `json:"found"`
+	Archived   int `json:"archived"`
+	Duplicates int `json:"duplicates"`
+	Blocked    int `json:"blocked"`
+	Skipped    int `json:"skipped"`
+	Failed     int `json:"failed"`
+	// LastError is why the crawl or its last failed page failed.
+	LastError string `json:"lastError,omitempty"`
+
+	include, exclude []*regexp.Regexp
+	// progress reports the crawl's progress to its job.
+	progress func(done, total int)
+}
+
+var siteCrawls = struct {
+	sync.Mutex
+	byID map[string]*SiteCrawl
+}{byID: make(map[string]*SiteCrawl)}
+
+// update changes a crawl under the lock, so handlers can read it while it
+// runs.
+func (crawl *SiteCrawl) update(change func(*SiteCrawl)) {
+	siteCrawls.Lock()
+	change(crawl)
+	done := crawl.Archived + crawl.Duplicates + crawl.Blocked + crawl.Skipped + crawl.Failed
+	total, progress := crawl.Found, crawl.progress
+	siteCrawls.Unlock()
+	if progress != nil {
+		progress(done, total)
+	}
+}
+
+// robotsRules are the rules of a site's robots.txt that apply to memento.
+type robotsRules struct {
+	allow, disallow []string
+	delay           time.Duration
+	sitemaps        []string
+}
+
+// parseRobots reads a robots.txt, keeping the group for agent, or the one
+// for every agent when there is none for it. Sitemaps are listed whatever
+// the group.
+func parseRobots(data []byte, agent string) robotsRules {
+	var specific, wildcard robotsRules
+	var haveSpecific bool
+	var agents []string
+	inRules := false
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	var sitemaps []string
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		key, value = strings.ToLower(strings.TrimSpace(key)), strings.TrimSpace(value)
+		if key == "sitemap" {
+			sitemaps = append(sitemaps, value)
+			continue
+		}
+		if key == "user-agent" {
+			// Agents listed together share the rules that follow
+			if inRules {
+				agents, inRules = nil, false
+			}
+			agents = append(agents, strings.ToLower(value))
+			continue
+		}
+		inRules = true
+		for _, name := range agents {
+			var rules *robotsRules
+			switch {
+			case name == "*":
+				rules = &wildcard
+			case name == strings.ToLower(agent):
+				rules, haveSpecific = &specific, true
+			default:
+				continue
+			}
+			switch key {
+			case "allow":
+				rules.allow = append(rules.allow, value)
+			case "disallow":
+				if value != "" {
+					rules.disallow = append(rules.disallow, value)
+				}
+			case "crawl-delay":
+				if seconds, err := strconv.ParseFloat(value, 64); err == nil && seconds > 0 {
+					rules.delay = time.Duration(seconds * float64(time.Second))
+				}
+			}
+		}
+	}
+	rules := wildcard
+	if haveSpecific {
+		rules = specific
+	}
+	rules.sitemaps = sitemaps
+	return rules
+}
+
+// robotsMatch reports how long a robots.txt path pattern matching path is,
+// or -1 when it doesn't match. Patterns may use * for any characters and
+// end in $ to match the whole path.
+func robotsMatch(pattern, path string) int {
+	expr := "^" + strings.ReplaceAll(regexp.QuoteMeta(strings.TrimSuffix(pattern, "$")), `\*`, ".*")
+	if strings.HasSuffix(pattern, "$") {
+		expr += "$"
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil || !re.MatchString(path) {
+		return -1
+	}
+	return len(pattern)
+}
+
+// allowed reports whether the rules let a URL be fetched. The longest
+// matching rule wins, and Allow wins a tie.
+func (rules robotsRules) allowed(u *url.URL) bool {
+	path := u.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	if u.RawQuery != "" {
+		path += "?" + u.RawQuery
+	}
+	allow, disallow := -1, -1
+	for _, pattern := range rules.allow {
+		allow = max(allow, robotsMatch(pattern, path))
+	}
+	for _, pattern := range rules.disallow {
+		disallow = max(disallow, robotsMatch(pattern, path))
+	}
+	return disallow < 0 || allow >= disallow
+}
+
+// fetchRobots reads a site's robots.txt. A site without one may be
+// crawled freely, but one that fails to answer may not be crawled at all.
+func fetchRobots(ctx context.Context, site *url.URL) (robotsRules, error) {
+	robotsURL := site.Scheme + "://" + site.Host + "/robots.txt"
+	data, status, err := fetchSiteFile(ctx, robotsURL, "text/plain")
+	switch {
+	case err != nil:
+		return robotsRules{}, err
+	case status >= 500:
+		return robotsRules{}, fmt.Errorf("fetching %s: %d %s", robotsURL, status, http.StatusText(status))
+	case status != http.StatusOK:
+		return robotsRules{}, nil
+	}
+	return parseRobots(data, robotsAgent), nil
+}
+
+// fetchSiteFile downloads a robots.txt or sitemap, returning its status
+// and, when it was found, its body, decompressed if it was gzipped.
+func fetchSiteFile(ctx context.Context, fileURL, accept string) ([]byte, int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fileURL, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	req.Header.Set("User-Agent", "memento/1.0 (+https://github.com/nascarsayan/memento)")
+	req.Header.Set("Accept", accept)
+	resp, err := archiveClient.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, resp.StatusCode, nil
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxSitemapBytes+1))
+	if err == nil && bytes.HasPrefix(data, []byte{0x1f, 0x8b}) {
+		var gz *gzip.Reader
+		if gz, err = gzip.NewReader(bytes.NewReader(data)); err == nil {
+			data, err = ioutil.ReadAll(io.LimitReader(gz, maxSitemapBytes+1))
+		}
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	if len(data) > maxSitemapBytes {
+		return nil, 0, fmt.Errorf("%s is larger than %d bytes", fileURL, maxSitemapBytes)
+	}
+	return data, resp.StatusCode, nil
+}
+
+// parseSitemap reads a sitemap, returning the pages it lists, or for a
+// sitemap index the sitemaps it lists.
+func parseSitemap(data []byte) (pages, sitemaps []string, err error) {
+	var document struct {
+		XMLName  xml.Name
+		URLs     []string `xml:"url>loc"`
+		Sitemaps []string `xml:"sitemap>loc"`
+	}
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	decoder.CharsetReader = latin1Reader
+	if err := decoder.Decode(&document); err != nil {
+		return nil, nil, fmt.Errorf("not a sitemap: %v", err)
+	}
+	switch document.XMLName.Local {
+	case "urlset":
+		for _, loc := range document.URLs {
+			pages = append(pages, strings.TrimSpace(loc))
+		}
+	case "sitemapindex":
+		for _, loc := range document.Sitemaps {
+			sitemaps = append(sitemaps, strings.TrimSpace(loc))
+		}
+	default:
+		return nil, nil, fmt.Errorf("not a sitemap: <%s>", document.XMLName.Local)
+	}
+	return pages, sitemaps, nil
+}
+
+// siteStart reads the site of a crawl request: a sitemap URL, or a domain
+// or page URL to start from. It returns the URL, with https assumed for a
+// domain, and whether it is a sitemap.
+func siteStart(site string) (*url.URL, bool, error) {
+	if !strings.Contains(site, "://") {
+		site = "https://" + site
+	}
+	u, err := url.Parse(site)
+	if err != nil || !webURL(site) || u.Host == "" {
+		return nil, false, errors.New("site must be a domain or an http(s) URL")
+	}
+	u.Fragment = ""
+	return u, strings.HasSuffix(u.Path, ".xml") || strings.HasSuffix(u.Path, ".xml.gz"), nil
+}
+
+// inScope reports whether a crawl may archive a page on its site, by the
+// crawl's include and exclude patterns.
+func (crawl *SiteCrawl) inScope(pageURL string) bool {
+	for _, re := range crawl.exclude {
+		if re.MatchString(pageURL) {
+			return false
+		}
+	}
+	for _, re := range crawl.include {
+		if re.MatchString(pageURL) {
+			return true
+		}
+	}
+	return len(crawl.include) == 0
+}
+
+// listSitePages reads a site's sitemaps, following sitemap indexes down to
+// the crawl's depth, and returns up to the crawl's limit of the pages in its
+// scope they list on the site's host.
+func listSitePages(ctx context.Context, crawl *SiteCrawl, root *url.URL, sitemaps []string, pace *pacer) ([]string, error) {
+	type queued struct {
+		url   string
+		depth int
+	}
+	var queue []queued
+	for _, sitemap := range sitemaps {
+		queue = append(queue, queued{sitemap, 0})
+	}
+	seenSitemaps := make(map[string]bool)
+	seenPages := make(map[string]bool)
+	var pages []string
+	for len(queue) > 0 && len(pages) < crawl.MaxPages {
+		next := queue[0]
+		queue = queue[1:]
+		if seenSitemaps[next.url] {
+			continue
+		}
+		seenSitemaps[next.url] = true
+		if !pace.wait(ctx) {
+			return nil, ctx.Err()
+		}
+		data, status, err := fetchSiteFile(ctx, next.url, "application/xml, text/xml")
+		if err == nil && status != http.StatusOK {
+			err = fmt.Errorf("fetching %s: %d %s", next.url, status, http.StatusText(status))
+		}
+		var listed, nested []string
+		if err == nil {
+			listed, nested, err = parseSitemap(data)
+		}
+		if err != nil {
+			// The sitemap asked for must be read; others may be missing
+			if next.depth == 0 && len(sitemaps) == 1 {
+				return nil, err
+			}
+			log.Printf("Error reading sitemap %s: %v", next.url, err)
+			crawl.update(func(c *SiteCrawl) { c.LastError = err.Error() })
+			continue
+		}
+		crawl.update(func(c *SiteCrawl) { c.Sitemaps = append(c.Sitemaps, next.url) })
+		if next.depth < crawl.MaxDepth {
+			for _, sitemap := range nested {
+				queue = append(queue, queued{sitemap, next.depth + 1})
+			}
+		}
+		for _, page := range listed {
+			// Sitemaps may only list pages on their own site
+			u, err := url.Parse(page)
+			if err != nil || !webURL(page) || !strings.EqualFold(u.Host, root.Host) || seenPages[page] || !crawl.inScope(page) {
+				continue
+			}
+			seenPages[page] = true
+			pages = append(pages, page)
+			if len(pages) == crawl.MaxPages {
+				break
+			}
+		}
+	}
+	return pages, nil
+}
+
+// sleepContext waits for d, returning false if ctx is done first.
+func sleepContext(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// crawlSite archives the pages of a crawl's site, as listed by its sitemaps
+// or linked from its start, within the crawl's limits and scope and the
+// site's robots.txt.
+func crawlSite(ctx context.Context, crawl *SiteCrawl, start *url.URL, isSitemap bool) error {
+	root := &url.URL{Scheme: start.Scheme, Host: start.Host, Path: "/"}
+	err := func() error {
+		robots, err := fetchRobots(ctx, root)
+		if err != nil {
+			return err
+		}
+		delay := time.Duration(crawl.DelaySeconds * float64(time.Second))
+		if robots.delay > delay {
+			delay = min(robots.delay, maxSiteDelay*time.Second)
+			crawl.update(func(c *SiteCrawl) { c.DelaySeconds = delay.Seconds() })
+		}
+		pace := &pacer{delay: delay}
+		if crawl.Mode == siteCrawlLinks {
+			crawl.update(func(c *SiteCrawl) { c.Found = 1 })
+			return crawlPages(ctx, crawl, robots, pace, []string{start.String()}, true)
+		}
+
+		sitemaps := []string{start.String()}
+		if !isSitemap {
+			// Sitemaps robots.txt points to elsewhere aren't followed
+			sitemaps = nil
+			for _, listed := range robots.sitemaps {
+				if u, err := url.Parse(listed); err == nil && strings.EqualFold(u.Host, root.Host) {
+					sitemaps = append(sitemaps, listed)
+				}
+			}
+			if len(sitemaps) == 0 {
+				sitemaps = []string{root.ResolveReference(&url.URL{Path: "/sitemap.xml"}).String()}
+			}
+		}
+		pages, err := listSitePages(ctx, crawl, root, sitemaps, pace)
+		if err != nil {
+			return err
+		}
+		crawl.update(func(c *SiteCrawl) { c.Found = len(pages) })
+		return crawlPages(ctx, crawl, robots, pace, pages, false)
+	}()
+
+	now := time.Now()
+	var finished SiteCrawl
+	crawl.update(func(c *SiteCrawl) {
+		defer func() { finished = *c }()
+		c.Finished = &now
+		switch {
+		case errors.Is(err, context.Canceled):
+			c.Status = siteCrawlCancelled
+		case err != nil:
+			c.Status, c.LastError = siteCrawlFailed, err.Error()
+		default:
+			c.Status = siteCrawlDone
+		}
+	})
+	if err == nil {
+		log.Printf("Crawled %s: archived %d of %d pages", finished.Site, finished.Archived, finished.Found)
+	}
+	return err
+}
+
+// siteRequest starts a crawl through POST /archive/site.
+type siteRequest struct {
+	Site         string   `json:"site"`
+	Mode         string   `json:"mode"`
+	Tags         []string `json:"tags"`
+	MaxPages     int      `json:"maxPages"`
+	MaxDepth     int      `json:"maxDepth"`
+	Include      []string `json:"include"`
+	Exclude      []string `json:"exclude"`
+	DelaySeconds float64  `json:"delaySeconds"`
+	Concurrency  int      `json:"concurrency"`
+}
+
+// compilePatterns compiles a crawl's include or exclude patterns.
+func compilePatterns(name string, patterns []string) ([]*regexp.Regexp, error) {
+	var compiled []*regexp.Regexp
+	for _, pattern := range patterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("%s pattern %q: %v", name, pattern, err)
+		}
+		compiled = append(compiled, re)
+	}
+	return compiled, nil
+}
+
+// handleSiteCrawls lists the crawls on GET, and on POST starts archiving a
+// site in the background, responding with the crawl to follow it by.
+func handleSiteCrawls(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		siteCrawls.Lock()
+		visible := []SiteCrawl{}
+		for _, crawl := range siteCrawls.byID {
+			if !usersEnabled() || crawl.Owner == requestUser(r) {
+				visible = append(visible, *crawl)
+			}
+		}
+		siteCrawls.Unlock()
+		sort.Slice(visible, func(i, j int) bool { return visible[i].Started.After(visible[j].Started) })
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(visible)
+		return
+	}
+
+	request := siteRequest{Mode: siteCrawlSitemap, MaxPages: defaultSitePages, MaxDepth: defaultSiteDepth,
+		DelaySeconds: defaultSiteDelay, Concurrency: defaultSiteConcurrency}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		writeError(w, http.StatusBadRequest, ErrInvalidRequest, "Invalid request body")
+		return
+	}
+	if _, err := newSiteCrawl(request, requestUser(r)); err != nil {
+		writeError(w, http.StatusBadRequest, ErrInvalidRequest, err.Error())
+		return
+	}
+	// Like bulk re-captures, the captures aren't counted against the token
+	if token := requestToken(r); token != nil && token.Quota.limited() {
+		writeError(w, http.StatusForbidden, ErrActionDisabled, "Site archiving is not available to tokens with quotas")
+		return
+	}
+
+	job, err := startJob(jobSite, requestUser(r), request)
+	var crawl *SiteCrawl
+	if err == nil {
+		crawl, err = registerSiteCrawl(&job)
+	}
+	if err != nil {
+		log.Printf("Error starting crawl of %s: %v", request.Site, err)
+		writeError(w, http.StatusInternalServerError, ErrInternal, "Failed to start crawl")
+		return
+	}
+	siteCrawls.Lock()
+	snapshot := *crawl
+	siteCrawls.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", "/api/v1/jobs/"+job.ID)
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(snapshot)
+}
+
+// newSiteCrawl validates a request for a crawl and returns the crawl it
+// asks for, not yet started.
+func newSiteCrawl(request siteRequest, owner string) (*SiteCrawl, error) {
+	_, isSitemap, err := siteStart(request.Site)
+	if err != nil {
+		return nil, err
+	}
+	include, err := compilePatterns("include", request.Include)
+	var exclude []*regexp.Regexp
+	if err == nil {
+		exclude, err = compilePatterns("exclude", request.Exclude)
+	}
+	switch {
+	case err != nil:
+		return nil, err
+	case request.Mode != siteCrawlSitemap && request.Mode != siteCrawlLinks:
+		return nil, errors.New(`mode must be "sitemap" or "links"`)
+	case request.Mode == siteCrawlLinks && isSitemap:
+		return nil, errors.New("links are followed from a page, not a sitemap")
+	case request.MaxPages < 1 || request.MaxPages > maxSitePages:
+		return nil, fmt.Errorf("maxPages must be between 1 and %d", maxSitePages)
+	case request.MaxDepth < 0 || request.MaxDepth > maxSiteDepth:
+		return nil, fmt.Errorf("maxDepth must be between 0 and %d", maxSiteDepth)
+	case request.DelaySeconds < 0 || request.DelaySeconds > maxSiteDelay:
+		return nil, fmt.Errorf("delaySeconds must be between 0 and %d", maxSiteDelay)
+	case request.Concurrency < 1 || request.Concurrency > maxSiteConcurrency:
+		return nil, fmt.Errorf("concurrency must be between 1 and %d", maxSiteConcurrency)
+	}
+	return &SiteCrawl{
+		Mode:         request.Mode,
+		Site:         request.Site,
+		Tags:         request.Tags,
+		MaxPages:     request.MaxPages,
+		MaxDepth:     request.MaxDepth,
+		Include:      request.Include,
+		Exclude:      request.Exclude,
+		DelaySeconds: request.DelaySeconds,
+		Concurrency:  request.Concurrency,
+		Owner:        owner,
+		Status:       siteCrawlRunning,
+		include:      include,
+		exclude:      exclude,
+	}, nil
+}
+
+// registerSiteCrawl returns the crawl a site job runs, creating it from the
+// job's request the first time.
+func registerSiteCrawl(job *Job) (*SiteCrawl, error) {
+	siteCrawls.Lock()
+	defer siteCrawls.Unlock()
+	if crawl, ok := siteCrawls.byID[job.ID]; ok {
+		return crawl, nil
+	}
+	var request siteRequest
+	if err := json.Unmarshal(job.Params, &request); err != nil {
+		return nil, err
+	}
+	crawl, err := newSiteCrawl(request, job.Owner)
+	if err != nil {
+		return nil, err
+	}
+	crawl.ID, crawl.Started = job.ID, job.Started
+	siteCrawls.byID[crawl.ID] = crawl
+	return crawl, nil
+}
+
+// runSiteJob runs a site job's crawl, returning the crawl as it finished.
+func runSiteJob(ctx context.Context, job *Job) (interface{}, error) {
+	crawl, err := registerSiteCrawl(job)
+	if err != nil {
+		return nil, err
+	}
+	crawl.update(func(c *SiteCrawl) {
+		c.progress = func(done, total int) { reportProgress(ctx, done, total) }
+	})
+	start, isSitemap, err := siteStart(crawl.Site)
+	if err != nil {
+		return nil, err
+	}
+	err = crawlSite(ctx, crawl, start, isSitemap)
+	siteCrawls.Lock()
+	finished := *crawl
+	siteCrawls.Unlock()
+	return finished, err
+}
+
+// handleSiteCrawl returns a crawl's progress, or cancels it on DELETE.
+// Pages it has archived are kept.
+func handleSiteCrawl(w http.ResponseWriter, r *http.Request) {
+	siteCrawls.Lock()
+	crawl, ok := siteCrawls.byID[r.PathValue("id")]
+	if ok && usersEnabled() && crawl.Owner != requestUser(r) {
+		ok = false
+	}
+	var snapshot SiteCrawl
+	if ok {
+		snapshot = *crawl
+	}
+	siteCrawls.Unlock()
+	if !ok {
+		writeError(w, http.StatusNotFound, ErrNotFound, "Crawl not found")
+		return
+	}
+	if r.Method == http.MethodDelete {
+		cancelJob(snapshot.ID)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(snapshot)
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseRobots(t *testing.T) {
+	robots := []byte(`# comments are ignored
+User-agent: *
+Disallow: /private/
+Crawl-delay: 5
+
+User-agent: googlebot
+User-agent: memento
+Disallow: /drafts
+Disallow: /*.pdf$
+Allow: /drafts/published
+Disallow:
+
+Sitemap: https://example.com/sitemap.xml
+`)
+	rules := parseRobots(robots, robotsAgent)
+	if rules.delay != 0 || !reflect.DeepEqual(rules.sitemaps, []string{"https://example.com/sitemap.xml"}) {
+		t.Errorf("parseRobots() = %+v, want memento's group and the sitemap", rules)
+	}
+	tests := []struct {
+		path    string
+		allowed bool
+	}{
+		{"/", true},
+		{"/private/notes", true},
+		{"/drafts", false},
+		{"/drafts/2024/post", false},
+		{"/drafts/published/post", true},
+		{"/papers/paper.pdf", false},
+		{"/papers/paper.pdf?download=1", true},
+	}
+	for _, tt := range tests {
+		u, _ := url.Parse("https://example.com" + tt.path)
+		if got := rules.allowed(u); got != tt.allowed {
+			t.Errorf("allowed(%s) = %v, want %v", tt.path, got, tt.allowed)
+		}
+	}
+
+	// Other agents get the group for everyone
+	rules = parseRobots(robots, "otherbot")
+	if u, _ := url.Parse("https://example.com/private/notes"); rules.allowed(u) || rules.delay != 5*time.Second {
+		t.Errorf("parseRobots(otherbot) = %+v, want the * group", rules)
+	}
+}
+
+func TestParseSitemap(t *testing.T) {
+	tests := []struct {
+		name     string
+		sitemap  string
+		pages    []string
+		sitemaps []string
+		wantErr  bool
+	}{
+		{"urlset", `<?xml version="1.0" encoding="UTF-8"?>
+<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+<url><loc> https://example.com/ </loc><lastmod>2024-01-01</lastmod></url>
+<url><loc>https://example.com/about</loc></url>
+</urlset>`, []string{"https://example.com/", "https://example.com/about"}, nil, false},
+		{"index", `<sitemapindex xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+<sitemap><loc>https://example.com/posts.xml</loc></sitemap>
+</sitemapindex>`, nil, []string{"https://example.com/posts.xml"}, false},
+		{"html", `<html><body>Not found</body></html>`, nil, nil, true},
+	}
+	for _, tt := range tests {
+		pages, sitemaps, err := parseSitemap([]byte(tt.sitemap))
+		if (err != nil) != tt.wantErr || !reflect.DeepEqual(pages, tt.pages) || !reflect.DeepEqual(sitemaps, tt.sitemaps) {
+			t.Errorf("%s: parseSitemap() = %v, %v, %v", tt.name, pages, sitemaps, err)
+		}
+	}
+}
+
+func TestSiteStart(t *testing.T) {
+	tests := []struct {
+		site      string
+		start     string
+		isSitemap bool
+		wantErr   bool
+	}{
+		{"example.com", "https://example.com", false, false},
+		{"https://example.com/docs/#intro", "https://example.com/docs/", false, false},
+		{"http://example.com/sitemap_index.xml", "http://example.com/sitemap_index.xml", true, false},
+		{"ftp://example.com/", "", false, true},
+		{"", "", false, true},
+	}
+	for _, tt := range tests {
+		start, isSitemap, err := siteStart(tt.site)
+		if (err != nil) != tt.wantErr || (err == nil && (start.String() != tt.start || isSitemap != tt.isSitemap)) {
+			t.Errorf("siteStart(%q) = %v, %v, %v", tt.site, start, isSitemap, err)
+		}
+	}
+}
+
+func TestSiteCrawl(t *testing.T) {
+	withPagesDir(t)
+	withIndex(t, nil)
+	withJobs(t)
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/robots.txt":
+			fmt.Fprintf(w, "User-agent: *\nDisallow: /private\n\nSitemap: %s/sitemap_index.xml\n", server.URL)
+		case "/sitemap_index.xml":
+			fmt.Fprintf(w, `<sitemapindex><sitemap><loc>%[1]s/posts.xml.gz</loc></sitemap><sitemap><loc>%[1]s/missing.xml</loc></sitemap></sitemapindex>`, server.URL)
+		case "/posts.xml.gz":
+			var b bytes.Buffer
+			gz := gzip.NewWriter(&b)
+			fmt.Fprintf(gz, `<urlset>`)
+			for _, path := range []string{"/one", "/two", "/private/three", "/one", "/gone"} {
+				fmt.Fprintf(gz, `<url><loc>%s%s</loc></url>`, server.URL, path)
+			}
+			fmt.Fprintf(gz, `<url><loc>https://elsewhere.example/page</loc></url></urlset>`)
+			gz.Close()
+			w.Write(b.Bytes())
+		case "/one", "/two", "/private/three":
+			w.Header().Set("Content-Type", "text/html")
+			fmt.Fprintf(w, "<title>Page %s</title><p>Text of %s</p>", r.URL.Path, r.URL.Path)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+	saved := archiveClient
+	archiveClient = server.Client()
+	defer func() { archiveClient = saved }()
+
+	request := func(method, path, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(method, path, bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		newRouter().ServeHTTP(rec, req)
+		return rec
+	}
+	for _, body := range []string{`{"site": "ftp://example.com"}`, `{"site": "example.com", "maxPages": 0}`, `{"site": "example.com", "delaySeconds": 600}`} {
+		if rec := request(http.MethodPost, "/api/v1/archive/site", body); rec.Code != http.StatusBadRequest {
+			t.Errorf("POST %s = %d, want 400", body, rec.Code)
+		}
+	}
+
+	rec := request(http.MethodPost, "/api/v1/archive/site", `{"site": "`+server.URL+`", "tags": ["docs"], "delaySeconds": 0}`)
+	var crawl SiteCrawl
+	json.Unmarshal(rec.Body.Bytes(), &crawl)
+	if rec.Code != http.StatusAccepted || crawl.Status != siteCrawlRunning {
+		t.Fatalf("POST /archive/site = %d %s", rec.Code, rec.Body)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for crawl.Status == siteCrawlRunning && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+		json.Unmarshal(request(http.MethodGet, "/api/v1/archive/site/"+crawl.ID, "").Body.Bytes(), &crawl)
+	}
+	if crawl.Status != siteCrawlDone || crawl.Found != 4 || crawl.Archived != 2 || crawl.Blocked != 1 || crawl.Failed != 1 {
+		t.Fatalf("finished crawl = %+v", crawl)
+	}
+	if want := []string{server.URL + "/sitemap_index.xml", server.URL + "/posts.xml.gz"}; !reflect.DeepEqual(crawl.Sitemaps, want) {
+		t.Errorf("sitemaps read = %v, want %v", crawl.Sitemaps, want)
+	}
+	if job := waitForJob(t, crawl.ID); job.Kind != jobSite || job.Status != jobDone || job.Done != 4 || job.Total != 4 {
+		t.Errorf("crawl's job = %+v", job)
+	}
+	pages, _ := listPages()
+	if len(pages) != 2 || pages[0].Source != "sitemap" || !reflect.DeepEqual(pages[0].Tags, []string{"docs"}) {
+		t.Errorf("archived pages = %+v", pages)
+	}
+
+	if rec := request(http.MethodGet, "/api/v1/archive/site/unknown", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("GET unknown crawl = %d", rec.Code)
+	}
+	var crawls []SiteCrawl
+	json.Unmarshal(request(http.MethodGet, "/api/v1/archive/site", "").Body.Bytes(), &crawls)
+	if len(crawls) != 1 || crawls[0].ID != crawl.ID {
+		t.Errorf("GET /archive/site = %+v", crawls)
+	}
+}
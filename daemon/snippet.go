@@ -0,0 +1,237 @@
+package main
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/blevesearch/bleve/search"
+)
+
+const (
+	// defaultSnippetLength is how many characters of a page's text a result
+	// shows around its best match unless snippet_len asks for another
+	// number, from minSnippetLength to maxSnippetLength.
+	defaultSnippetLength = 200
+	minSnippetLength     = 50
+	maxSnippetLength     = 1000
+	// maxSnippetMatches is how many of a page's matches are weighed to find
+	// where it matches best.
+	maxSnippetMatches = 1000
+)
+
+var (
+	// markdownLinePrefixRe matches the headings, quotes and list markers
+	// that start markdown lines.
+	markdownLinePrefixRe = regexp.MustCompile(`^[ \t]*(?:#{1,6}[ \t]+|>[ \t]?|[-*+][ \t]+|\d+[.)][ \t]+)+`)
+	// markdownInlineRe matches links and images, whose text is kept, and
+	// inline markup and HTML tags, which are dropped.
+	markdownInlineRe = regexp.MustCompile("!?\\[([^\\]]*)\\]\\([^)]*\\)|<[^>]+>|\\*+|`+|~~|__")
+)
+
+// plainMarkdown reduces markdown to its words, with runs of whitespace,
+// line breaks included, as single spaces. offsets holds the byte offset in
+// text each rune of plain came from.
+func plainMarkdown(text string) (plain []rune, offsets []int) {
+	space := true
+	emit := func(s string, at int) {
+		for i, r := range s {
+			if unicode.IsSpace(r) {
+				if !space {
+					plain = append(plain, ' ')
+					offsets = append(offsets, at+i)
+				}
+				space = true
+				continue
+			}
+			plain = append(plain, r)
+			offsets = append(offsets, at+i)
+			space = false
+		}
+	}
+	for start := 0; start < len(text); {
+		end := strings.IndexByte(text[start:], '\n')
+		if end < 0 {
+			end = len(text)
+		} else {
+			end += start
+		}
+		line := start
+		if prefix := markdownLinePrefixRe.FindStringIndex(text[start:end]); prefix != nil {
+			line += prefix[1]
+		}
+		last := line
+		for _, m := range markdownInlineRe.FindAllStringSubmatchIndex(text[line:end], -1) {
+			emit(text[last:line+m[0]], last)
+			if m[2] >= 0 {
+				emit(text[line+m[2]:line+m[3]], line+m[2])
+			}
+			last = line + m[1]
+		}
+		emit(text[last:end], last)
+		emit(" ", end)
+		start = end + 1
+	}
+	if len(plain) > 0 && plain[len(plain)-1] == ' ' {
+		plain, offsets = plain[:len(plain)-1], offsets[:len(offsets)-1]
+	}
+	return plain, offsets
+}
+
+// snippetMatch is a matched term at plain[start:end].
+type snippetMatch struct {
+	start, end int
+	term       string
+}
+
+// plainMatches maps the locations of the terms matched in a field to the
+// runes of its plain text, in order, dropping those in markup plainMarkdown
+// leaves out, such as link addresses, and those overlapping an earlier one.
+func plainMatches(offsets []int, locations search.TermLocationMap) []snippetMatch {
+	var matches []snippetMatch
+	for term, locs := range locations {
+		for _, loc := range locs {
+			start := sort.SearchInts(offsets, int(loc.Start))
+			end := sort.SearchInts(offsets, int(loc.End))
+			if start < len(offsets) && offsets[start] == int(loc.Start) && end > start {
+				matches = append(matches, snippetMatch{start, end, term})
+			}
+		}
+	}
+	sort.Slice(matches, func(i, j int) bool { return matches[i].start < matches[j].start })
+	kept := matches[:0]
+	for _, m := range matches {
+		if len(kept) == 0 || m.start >= kept[len(kept)-1].end {
+			kept = append(kept, m)
+		}
+	}
+	if len(kept) > maxSnippetMatches {
+		kept = kept[:maxSnippetMatches]
+	}
+	return kept
+}
+
+// bestMatches returns the matches in the window of length runes that holds
+// the most different terms, and then the most matches, earliest first.
+func bestMatches(matches []snippetMatch, length int) []snippetMatch {
+	var best []snippetMatch
+	bestTerms := 0
+	for i := range matches {
+		terms := make(map[string]bool)
+		j := i
+		for ; j < len(matches) && matches[j].end-matches[i].start <= length; j++ {
+			terms[matches[j].term] = true
+		}
+		if len(terms) > bestTerms || len(terms) == bestTerms && j-i > len(best) {
+			best, bestTerms = matches[i:j], len(terms)
+		}
+	}
+	if best == nil && len(matches) > 0 {
+		// A single match longer than the snippet
+		best = matches[:1]
+	}
+	return best
+}
+
+// sentenceEnd reports whether a sentence ends just before plain[i].
+func sentenceEnd(plain []rune, i int) bool {
+	return i >= 2 && plain[i-1] == ' ' && strings.ContainsRune(".!?", plain[i-2])
+}
+
+// contextSnippet returns about length characters of a field's text around
+// where it best matches the search, or its start when it doesn't match,
+// with the matches between the highlighter's markers. Markdown is reduced to
+// plain text, and the snippet starts and ends at sentence boundaries where
+// that doesn't leave out a match, otherwise between words with an ellipsis.
+func contextSnippet(text string, locations search.TermLocationMap, length int) string {
+	if length == 0 {
+		length = defaultSnippetLength
+	}
+	plain, offsets := plainMarkdown(text)
+	if len(plain) == 0 {
+		return ""
+	}
+	matches := bestMatches(plainMatches(offsets, locations), length)
+
+	// Center the window on the matches
+	first, last := 0, 0
+	if len(matches) > 0 {
+		first, last = matches[0].start, matches[len(matches)-1].end
+	}
+	start := first - (length-(last-first))/2
+	if start > first {
+		start = first
+	}
+	if start+length > len(plain) {
+		start = len(plain) - length
+	}
+	if start < 0 {
+		start = 0
+	}
+
+	// Start at the first sentence the window holds, or else the first word
+	if start > 0 {
+		for i := start; i <= first; i++ {
+			if sentenceEnd(plain, i) {
+				start = i
+				break
+			}
+		}
+	}
+	leading := start > 0 && !sentenceEnd(plain, start)
+	if leading && plain[start-1] != ' ' {
+		for i := start; i < first; i++ {
+			if plain[i] == ' ' {
+				start = i + 1
+				break
+			}
+		}
+	}
+
+	// End at the last sentence the window holds, or else the last word
+	end := start + length
+	if end > len(plain) {
+		end = len(plain)
+	}
+	if last > end {
+		last = end
+	}
+	trailing := false
+	if end < len(plain) {
+		trailing = true
+		for i := end; i > last; i-- {
+			if sentenceEnd(plain, i) {
+				end, trailing = i-1, false
+				break
+			}
+		}
+		if trailing && plain[end] != ' ' {
+			for i := end - 1; i > last; i-- {
+				if plain[i] == ' ' {
+					end = i
+					break
+				}
+			}
+		}
+	}
+
+	var b strings.Builder
+	if leading {
+		b.WriteString("…")
+	}
+	at := start
+	for _, m := range matches {
+		if m.start < at || m.end > end {
+			continue
+		}
+		b.WriteString(string(plain[at:m.start]))
+		b.WriteString(matchStart + string(plain[m.start:m.end]) + matchEnd)
+		at = m.end
+	}
+	b.WriteString(strings.TrimRight(string(plain[at:end]), " "))
+	if trailing {
+		b.WriteString("…")
+	}
+	return b.String()
+}
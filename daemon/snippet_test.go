@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+
+	"github.com/blevesearch/bleve/search"
+)
+
+// locateWords finds the words in text the way the index locates matched
+// terms.
+func locateWords(text string, words ...string) search.TermLocationMap {
+	locations := make(search.TermLocationMap)
+	for _, word := range words {
+		for _, loc := range regexp.MustCompile(`(?i)\b`+word+`\b`).FindAllStringIndex(text, -1) {
+			locations.AddLocation(word, &search.Location{Start: uint64(loc[0]), End: uint64(loc[1])})
+		}
+	}
+	return locations
+}
+
+const floodStory = "The weather was mild all week and nobody expected a storm. Then the river rose overnight and flooded the lower town. Volunteers stacked sandbags along the bank until dawn. By noon the water had gone down and the cleanup began."
+
+func TestContextSnippet(t *testing.T) {
+	const greek = "alpha beta gamma delta epsilon zeta eta theta iota kappa lambda mu nu xi omicron pi rho sigma tau upsilon phi chi psi omega"
+	markdown := "# Heading\n\nSee the [Kubernetes docs](https://kubernetes.io/docs) for **more**.\n- item one"
+	tests := []struct {
+		name   string
+		text   string
+		words  []string
+		length int
+		want   string
+	}{
+		{"sentence around the match", floodStory, []string{"flooded"}, 100, "Then the river rose overnight and <mark>flooded</mark> the lower town."},
+		{"window with the most terms", floodStory, []string{"river", "dawn"}, 200, "Then the <mark>river</mark> rose overnight and flooded the lower town. Volunteers stacked sandbags along the bank until <mark>dawn</mark>. By noon the water had gone down and the cleanup began."},
+		{"last sentence", floodStory, []string{"cleanup"}, 80, "By noon the water had gone down and the <mark>cleanup</mark> began."},
+		{"start without a match", floodStory, nil, 80, "The weather was mild all week and nobody expected a storm."},
+		{"cut between words", greek, []string{"omicron"}, 50, "…lambda mu nu xi <mark>omicron</mark> pi rho sigma tau upsilon…"},
+		{"start cut between words", greek, nil, 50, "alpha beta gamma delta epsilon zeta eta theta iota…"},
+		{"markdown", markdown, []string{"kubernetes"}, 50, "Heading See the <mark>Kubernetes</mark> docs for more. item one"},
+		{"match in a link address", markdown, []string{"docs"}, 200, "Heading See the Kubernetes <mark>docs</mark> for more. item one"},
+	}
+	for _, tt := range tests {
+		got, _ := formatSnippet(contextSnippet(tt.text, locateWords(tt.text, tt.words...), tt.length), "html")
+		if got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestSnippetLength(t *testing.T) {
+	withIndex(t, map[string]PageDocument{
+		"flood": {URL: "https://flood.example/", Title: "Flood", Content: floodStory},
+	})
+	find := func(query string) ([]SearchResult, int) {
+		t.Helper()
+		w := httptest.NewRecorder()
+		handleSearch(w, httptest.NewRequest(http.MethodGet, "/search?q=sandbags"+query, nil))
+		var results []SearchResult
+		json.NewDecoder(w.Body).Decode(&results)
+		return results, w.Code
+	}
+	want := "Then the river rose overnight and flooded the lower town. Volunteers stacked sandbags along the bank until dawn. By noon the water had gone down and the cleanup began."
+	if results, _ := find(""); len(results) != 1 || results[0].Snippet != want {
+		t.Errorf("default snippet = %+v", results)
+	}
+	if results, _ := find("&snippet_len=60"); len(results) != 1 || results[0].Snippet != "Volunteers stacked sandbags along the bank until dawn." {
+		t.Errorf("short snippet = %+v", results)
+	}
+	if _, code := find("&snippet_len=10"); code != http.StatusBadRequest {
+		t.Errorf("status with snippet_len=10 = %d", code)
+	}
+}
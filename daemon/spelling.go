@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/blevesearch/bleve/search"
+)
+
+const (
+	// correctedQueryHeader names the query a search that found nothing was
+	// run again as, when the results are that query's.
+	correctedQueryHeader = "X-Corrected-Query"
+	// didYouMeanHeader lists, one value each, the other spellings of a
+	// query that found nothing.
+	didYouMeanHeader = "X-Did-You-Mean"
+	// maxSpellingSuggestions is how many spellings of a query are suggested
+	// and tried.
+	maxSpellingSuggestions = 3
+)
+
+// queryWordPattern matches the words of a query string, leaving its
+// operators, quotes and brackets alone.
+var queryWordPattern = regexp.MustCompile(`[\p{L}\p{N}]+`)
+
+// queryOperators are the query string words that aren't searched for.
+var queryOperators = map[string]bool{"AND": true, "OR": true, "NOT": true}
+
+// spellingCandidate is a word of the index a misspelled word may have meant.
+type spellingCandidate struct {
+	term     string
+	distance int
+	// pages is how many pages' fields contain the term.
+	pages uint64
+}
+
+// spellingCandidates returns the words of the index's term dictionary up to
+// two edits from word, one for words of four letters or fewer, closest and
+// then most common first. Like most spell checkers it trusts the first
+// letter, which keeps the dictionary walk short. known is set when the index
+// has word itself, which then needs no correcting.
+func spellingCandidates(word string) (candidates []spellingCandidate, known bool, err error) {
+	max := maxFuzziness
+	if len([]rune(word)) <= 4 {
+		max = 1
+	}
+	first, _ := firstRune(word)
+	byTerm := make(map[string]*spellingCandidate)
+	for _, field := range textFields {
+		if config.Search.Analysis.Stemming {
+			field = exactField(field)
+		}
+		dict, err := index.FieldDictPrefix(field, []byte(string(first)))
+		if err != nil {
+			return nil, false, err
+		}
+		for {
+			entry, err := dict.Next()
+			if err != nil {
+				dict.Close()
+				return nil, false, err
+			}
+			if entry == nil {
+				break
+			}
+			if entry.Term == word {
+				known = true
+				continue
+			}
+			distance, exceeded := search.LevenshteinDistanceMax(word, entry.Term, max)
+			if exceeded || distance > max {
+				continue
+			}
+			if c, ok := byTerm[entry.Term]; ok {
+				c.pages += entry.Count
+			} else {
+				byTerm[entry.Term] = &spellingCandidate{entry.Term, distance, entry.Count}
+			}
+		}
+		if err := dict.Close(); err != nil {
+			return nil, false, err
+		}
+	}
+	if known {
+		return nil, true, nil
+	}
+	for _, c := range byTerm {
+		candidates = append(candidates, *c)
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		a, b := candidates[i], candidates[j]
+		if a.distance != b.distance {
+			return a.distance < b.distance
+		}
+		if a.pages != b.pages {
+			return a.pages > b.pages
+		}
+		return a.term < b.term
+	})
+	return candidates, false, nil
+}
+
+// firstRune returns the first letter of s.
+func firstRune(s string) (rune, bool) {
+	for _, r := range s {
+		return r, true
+	}
+	return 0, false
+}
+
+// misspelledWord is a word of a query the index doesn't have, at text[start:end].
+type misspelledWord struct {
+	start, end int
+	candidates []spellingCandidate
+}
+
+// spellingSuggestions returns other spellings of text, best first, with each
+// of its words the index doesn't have swapped for a word it does. The first
+// takes every word's best candidate; the others each take the next best
+// candidates of one word. Field names and the values given for them,
+// operators, stop words, numbers and words under three letters are left as
+// they are.
+func spellingSuggestions(text string) ([]string, error) {
+	stop := tagStopWords()
+	var misspelled []misspelledWord
+	for _, loc := range queryWordPattern.FindAllStringIndex(text, -1) {
+		word := text[loc[0]:loc[1]]
+		lower := strings.ToLower(word)
+		switch {
+		case loc[1] < len(text) && text[loc[1]] == ':',
+			loc[0] > 0 && text[loc[0]-1] == ':',
+			queryOperators[word], stop[lower],
+			len([]rune(word)) < 3,
+			strings.IndexFunc(word, unicode.IsLetter) < 0:
+			continue
+		}
+		candidates, known, err := spellingCandidates(lower)
+		if err != nil {
+			return nil, err
+		}
+		if !known && len(candidates) > 0 {
+			misspelled = append(misspelled, misspelledWord{loc[0], loc[1], candidates})
+		}
+	}
+	if len(misspelled) == 0 {
+		return nil, nil
+	}
+
+	spell := func(choice map[int]int) string {
+		var b strings.Builder
+		last := 0
+		for i, word := range misspelled {
+			b.WriteString(text[last:word.start])
+			b.WriteString(word.candidates[choice[i]].term)
+			last = word.end
+		}
+		b.WriteString(text[last:])
+		return b.String()
+	}
+	suggestions := []string{spell(nil)}
+	for i, word := range misspelled {
+		for j := 1; j < len(word.candidates) && len(suggestions) < maxSpellingSuggestions; j++ {
+			suggestions = append(suggestions, spell(map[int]int{i: j}))
+		}
+	}
+	return suggestions, nil
+}
+
+// retryMisspelled runs a query that found nothing again with the spellings
+// spellingSuggestions gives, returning the results of the first that finds
+// anything, which spelling that was, and every spelling suggested.
+func retryMisspelled(ctx context.Context, text string, options SearchOptions, localizer Localizer) (results []SearchResult, corrected string, suggestions []string, err error) {
+	suggestions, err = spellingSuggestions(text)
+	if err != nil {
+		return nil, "", nil, err
+	}
+	for _, suggestion := range suggestions {
+		if results, err = searchPages(ctx, suggestion, options, localizer); err != nil {
+			return nil, "", nil, err
+		}
+		if len(results) > 0 {
+			return results, suggestion, suggestions, nil
+		}
+	}
+	return nil, "", suggestions, nil
+}
+
+// setSpellingHeaders tells the client which spelling a search's results are
+// for and which others it may have meant.
+func setSpellingHeaders(w http.ResponseWriter, corrected string, suggestions []string) {
+	if corrected != "" {
+		w.Header().Set(correctedQueryHeader, corrected)
+	}
+	for _, suggestion := range suggestions {
+		if suggestion != corrected {
+			w.Header().Add(didYouMeanHeader, suggestion)
+		}
+	}
+}
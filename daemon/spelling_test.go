@@ -0,0 +1,55 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMisspelledSearch(t *testing.T) {
+	withPagesDir(t)
+	withIndex(t, map[string]PageDocument{
+		"k8s":   {URL: "https://k8s.example/", Title: "Kubernetes", Content: "Kubernetes schedules containers onto nodes."},
+		"bread": {URL: "https://bread.example/", Title: "Sourdough", Content: "Sourdough bread rises slowly; see the notes."},
+	})
+
+	tests := []struct {
+		query       string
+		results     int
+		corrected   string
+		suggestions []string
+	}{
+		{"kubernetes", 1, "", nil},
+		{"kubernets", 1, "kubernetes", nil},
+		{"sourdouhg brad", 1, "sourdough bread", nil},
+		{"nodrs", 1, "nodes", []string{"notes"}},
+		{"title:kubernets", 0, "", nil},
+		{"zzyzx", 0, "", nil},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		handleSearch(w, httptest.NewRequest(http.MethodGet, "/search?q="+strings.ReplaceAll(tt.query, " ", "+"), nil))
+		var results []SearchResult
+		if err := json.NewDecoder(w.Body).Decode(&results); err != nil || results == nil {
+			t.Fatalf("%q: results = %v, %v", tt.query, results, err)
+		}
+		if len(results) != tt.results {
+			t.Errorf("%q: %d results, want %d", tt.query, len(results), tt.results)
+		}
+		if got := w.Header().Get(correctedQueryHeader); got != tt.corrected {
+			t.Errorf("%q: corrected to %q, want %q", tt.query, got, tt.corrected)
+		}
+		if got := w.Header().Values(didYouMeanHeader); !reflect.DeepEqual(got, tt.suggestions) {
+			t.Errorf("%q: suggestions %q, want %q", tt.query, got, tt.suggestions)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	handleTextSearch(w, httptest.NewRequest(http.MethodGet, "/text?q=nodrs", nil))
+	if body := w.Body.String(); !strings.Contains(body, "Showing results for nodes") || !strings.Contains(body, `<a href="/text?lang=en&amp;q=notes">notes</a>`) {
+		t.Errorf("text search page:\n%s", body)
+	}
+}
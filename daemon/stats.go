@@ -0,0 +1,259 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// forecastMonths are the horizons disk usage is projected over.
+var forecastMonths = []int{3, 6, 12}
+
+const daysPerMonth = 30.44
+
+// Stats reports how much disk the archive and index use and how fast they
+// are growing.
+type Stats struct {
+	Pages        int   `json:"pages"`
+	ArchiveBytes int64 `json:"archiveBytes"`
+	IndexBytes   int64 `json:"indexBytes"`
+	TotalBytes   int64 `json:"totalBytes"`
+	// GrowthBytesPerDay is the average daily growth of both over the
+	// growth window.
+	GrowthBytesPerDay float64           `json:"growthBytesPerDay"`
+	GrowthWindowDays  int               `json:"growthWindowDays"`
+	Forecast          []StorageForecast `json:"forecast"`
+	Warnings          []StorageWarning  `json:"warnings,omitempty"`
+	// Domains counts pages by host, most first, up to statsDomainLimit
+	// of the DomainCount hosts archived.
+	Domains     []DomainStats `json:"domains"`
+	DomainCount int           `json:"domainCount"`
+	// CapturesPerDay covers each day of the growth window, oldest first.
+	CapturesPerDay []DailyCaptures `json:"capturesPerDay"`
+	Oldest         *CaptureRef     `json:"oldest,omitempty"`
+	Newest         *CaptureRef     `json:"newest,omitempty"`
+}
+
+// statsDomainLimit is the most hosts Stats lists.
+const statsDomainLimit = 20
+
+// DomainStats is how many pages of a host are archived, and their size.
+type DomainStats struct {
+	Domain string `json:"domain"`
+	Pages  int    `json:"pages"`
+	Bytes  int64  `json:"bytes"`
+}
+
+// DailyCaptures is how many pages were captured on Date, a day in the
+// daemon's time zone.
+type DailyCaptures struct {
+	Date  string `json:"date"`
+	Pages int    `json:"pages"`
+}
+
+// CaptureRef identifies a page in Stats.
+type CaptureRef struct {
+	ID        string    `json:"id"`
+	URL       string    `json:"url"`
+	Title     string    `json:"title"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// StorageForecast is the projected total disk usage after Months.
+type StorageForecast struct {
+	Months     int       `json:"months"`
+	Date       time.Time `json:"date"`
+	TotalBytes int64     `json:"totalBytes"`
+}
+
+// StorageWarning reports a configured threshold that has been reached, or
+// that the forecast reaches within its longest horizon.
+type StorageWarning struct {
+	ThresholdBytes int64     `json:"thresholdBytes"`
+	ReachedBy      time.Time `json:"reachedBy"`
+	Message        string    `json:"message"`
+}
+
+// dirSize sums the sizes of the files under dir.
+func dirSize(dir string) (int64, error) {
+	var total int64
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			total += info.Size()
+		}
+		return nil
+	})
+	return total, err
+}
+
+// pageSize sums the sizes of a page's metadata and content files.
+func pageSize(page Page) int64 {
+	var total int64
+	for _, file := range append([]string{page.ID + ".json"}, page.files()...) {
+		if info, err := os.Stat(filepath.Join(pagesDir, file)); err == nil {
+			total += info.Size()
+		}
+	}
+	return total
+}
+
+// computeStats measures disk usage and projects it from the pages captured
+// over the growth window. The index is assumed to grow in proportion to
+// the archive.
+func computeStats(now time.Time) (Stats, error) {
+	pages, err := listPages()
+	if err != nil {
+		return Stats{}, err
+	}
+	stats := Stats{Pages: len(pages), GrowthWindowDays: config.Stats.GrowthWindowDays}
+	if stats.ArchiveBytes, err = dirSize(pagesDir); err != nil {
+		return Stats{}, err
+	}
+	if stats.IndexBytes, err = dirSize(indexDir); err != nil {
+		return Stats{}, err
+	}
+	stats.TotalBytes = stats.ArchiveBytes + stats.IndexBytes
+
+	windowStart := now.AddDate(0, 0, -stats.GrowthWindowDays)
+	var recentBytes int64
+	oldest := now
+	domains := make(map[string]*DomainStats)
+	perDay := make(map[string]int)
+	for _, page := range pages {
+		size := pageSize(page)
+		if page.Timestamp.Before(oldest) {
+			oldest = page.Timestamp
+		}
+		if page.Timestamp.After(windowStart) {
+			recentBytes += size
+			perDay[page.Timestamp.In(now.Location()).Format("2006-01-02")]++
+		}
+		if stats.Oldest == nil || page.Timestamp.Before(stats.Oldest.Timestamp) {
+			stats.Oldest = &CaptureRef{ID: page.ID, URL: page.URL, Title: page.Title, Timestamp: page.Timestamp}
+		}
+		if stats.Newest == nil || page.Timestamp.After(stats.Newest.Timestamp) {
+			stats.Newest = &CaptureRef{ID: page.ID, URL: page.URL, Title: page.Title, Timestamp: page.Timestamp}
+		}
+		if u, err := url.Parse(page.URL); err == nil && u.Hostname() != "" {
+			host := strings.ToLower(u.Hostname())
+			if domains[host] == nil {
+				domains[host] = &DomainStats{Domain: host}
+			}
+			domains[host].Pages++
+			domains[host].Bytes += size
+		}
+	}
+
+	stats.Domains = []DomainStats{}
+	for _, d := range domains {
+		stats.Domains = append(stats.Domains, *d)
+	}
+	sort.Slice(stats.Domains, func(i, j int) bool {
+		if stats.Domains[i].Pages != stats.Domains[j].Pages {
+			return stats.Domains[i].Pages > stats.Domains[j].Pages
+		}
+		return stats.Domains[i].Domain < stats.Domains[j].Domain
+	})
+	stats.DomainCount = len(stats.Domains)
+	if len(stats.Domains) > statsDomainLimit {
+		stats.Domains = stats.Domains[:statsDomainLimit]
+	}
+	for day := stats.GrowthWindowDays - 1; day >= 0; day-- {
+		date := now.AddDate(0, 0, -day).Format("2006-01-02")
+		stats.CapturesPerDay = append(stats.CapturesPerDay, DailyCaptures{Date: date, Pages: perDay[date]})
+	}
+	// A young archive has only grown for as long as it has existed
+	if oldest.After(windowStart) {
+		windowStart = oldest
+	}
+	days := math.Max(now.Sub(windowStart).Hours()/24, 1)
+	stats.GrowthBytesPerDay = float64(recentBytes) / days
+	if stats.ArchiveBytes > 0 {
+		stats.GrowthBytesPerDay *= float64(stats.TotalBytes) / float64(stats.ArchiveBytes)
+	}
+
+	for _, months := range forecastMonths {
+		days := float64(months) * daysPerMonth
+		stats.Forecast = append(stats.Forecast, StorageForecast{
+			Months:     months,
+			Date:       now.AddDate(0, months, 0),
+			TotalBytes: stats.TotalBytes + int64(stats.GrowthBytesPerDay*days),
+		})
+	}
+
+	horizon := float64(forecastMonths[len(forecastMonths)-1]) * daysPerMonth
+	for _, threshold := range config.Stats.WarnBytes {
+		switch {
+		case stats.TotalBytes >= threshold:
+			stats.Warnings = append(stats.Warnings, StorageWarning{
+				ThresholdBytes: threshold,
+				ReachedBy:      now,
+				Message:        fmt.Sprintf("Disk usage of %s has reached the %s threshold", formatBytes(stats.TotalBytes), formatBytes(threshold)),
+			})
+		case stats.GrowthBytesPerDay > 0:
+			days := float64(threshold-stats.TotalBytes) / stats.GrowthBytesPerDay
+			if days > horizon {
+				continue
+			}
+			reachedBy := now.Add(time.Duration(days * float64(24*time.Hour)))
+			stats.Warnings = append(stats.Warnings, StorageWarning{
+				ThresholdBytes: threshold,
+				ReachedBy:      reachedBy,
+				Message:        fmt.Sprintf("Disk usage is forecast to reach %s by %s", formatBytes(threshold), reachedBy.Format("2006-01-02")),
+			})
+		}
+	}
+	return stats, nil
+}
+
+// formatBytes renders a size with a binary unit, such as "1.5 GiB".
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// handleStats reports the archive's size and makeup, its disk usage
+// forecast and any threshold warnings, which are also logged.
+func handleStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, ErrMethodNotAllowed, "Method not allowed")
+		return
+	}
+	stats, err := computeStats(time.Now())
+	if err != nil {
+		log.Printf("Error computing stats: %v", err)
+		writeError(w, http.StatusInternalServerError, ErrInternal, "Failed to compute stats")
+		return
+	}
+	if len(stats.Warnings) > 0 {
+		messages := make([]string, len(stats.Warnings))
+		for i, warning := range stats.Warnings {
+			messages[i] = warning.Message
+		}
+		log.Printf("Storage warning: %s", strings.Join(messages, "; "))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(stats)
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestComputeStats(t *testing.T) {
+	withPagesDir(t)
+	now := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	var recentBytes int64
+	for _, age := range []int{200, 10, 2} {
+		id, err := storePage(PageMetadata{Title: "page", Timestamp: now.AddDate(0, 0, -age)}, strings.Repeat("x", 1000*age), "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		page, err := loadPage(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if age < 90 {
+			recentBytes += pageSize(page)
+		}
+	}
+
+	stats, err := computeStats(now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Pages != 3 || stats.ArchiveBytes == 0 || stats.IndexBytes != 0 || stats.TotalBytes != stats.ArchiveBytes {
+		t.Fatalf("stats = %+v", stats)
+	}
+	if want := float64(recentBytes) / 90; stats.GrowthBytesPerDay != want {
+		t.Errorf("growth = %v bytes/day, want %v", stats.GrowthBytesPerDay, want)
+	}
+	for i, months := range forecastMonths {
+		forecast := stats.Forecast[i]
+		want := stats.TotalBytes + int64(stats.GrowthBytesPerDay*float64(months)*daysPerMonth)
+		if forecast.Months != months || forecast.TotalBytes != want || !forecast.Date.Equal(now.AddDate(0, months, 0)) {
+			t.Errorf("forecast[%d] = %+v, want %d bytes", i, forecast, want)
+		}
+	}
+
+	month := stats.TotalBytes + int64(stats.GrowthBytesPerDay*30)
+	saved := config.Stats.WarnBytes
+	config.Stats.WarnBytes = []int64{1, month, 1 << 50}
+	defer func() { config.Stats.WarnBytes = saved }()
+	stats, err = computeStats(now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stats.Warnings) != 2 {
+		t.Fatalf("warnings = %+v, want 2", stats.Warnings)
+	}
+	if reached := stats.Warnings[0]; reached.ThresholdBytes != 1 || !reached.ReachedBy.Equal(now) {
+		t.Errorf("reached warning = %+v", reached)
+	}
+	if soon := stats.Warnings[1]; soon.ReachedBy.Sub(now).Round(time.Hour) != 30*24*time.Hour {
+		t.Errorf("forecast warning = %+v, want reached in 30 days", soon)
+	}
+}
+
+func TestComputeStatsYoungArchive(t *testing.T) {
+	withPagesDir(t)
+	now := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	id, err := storePage(PageMetadata{Title: "page", Timestamp: now.AddDate(0, 0, -4)}, "<p>new</p>", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	page, _ := loadPage(id)
+
+	stats, err := computeStats(now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Growth is spread over the four days the archive has existed, not the
+	// whole window
+	if want := float64(pageSize(page)) / 4; stats.GrowthBytesPerDay != want {
+		t.Errorf("growth = %v bytes/day, want %v", stats.GrowthBytesPerDay, want)
+	}
+}
+
+func TestComputeStatsBreakdown(t *testing.T) {
+	withPagesDir(t)
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	captures := []struct {
+		url string
+		age time.Duration
+	}{
+		{"https://b.example/1", 300 * 24 * time.Hour},
+		{"https://a.example/1", 2 * 24 * time.Hour},
+		{"https://A.example/2", 2*24*time.Hour + time.Hour},
+		{"https://b.example/2", time.Hour},
+		{"file:///notes.pdf", 3 * time.Hour},
+	}
+	ids := make(map[string]string)
+	for _, c := range captures {
+		id, err := storePage(PageMetadata{URL: c.url, Title: c.url, Timestamp: now.Add(-c.age)}, "", "# "+c.url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids[c.url] = id
+	}
+
+	stats, err := computeStats(now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.DomainCount != 2 || len(stats.Domains) != 2 {
+		t.Fatalf("domains = %+v", stats.Domains)
+	}
+	for i, want := range []DomainStats{{Domain: "a.example", Pages: 2}, {Domain: "b.example", Pages: 2}} {
+		if got := stats.Domains[i]; got.Domain != want.Domain || got.Pages != want.Pages || got.Bytes == 0 {
+			t.Errorf("domains[%d] = %+v, want %+v", i, got, want)
+		}
+	}
+	if stats.Oldest == nil || stats.Oldest.ID != ids["https://b.example/1"] {
+		t.Errorf("oldest = %+v", stats.Oldest)
+	}
+	if stats.Newest == nil || stats.Newest.ID != ids["https://b.example/2"] {
+		t.Errorf("newest = %+v", stats.Newest)
+	}
+
+	if len(stats.CapturesPerDay) != stats.GrowthWindowDays {
+		t.Fatalf("%d days of captures, want %d", len(stats.CapturesPerDay), stats.GrowthWindowDays)
+	}
+	days := stats.CapturesPerDay[len(stats.CapturesPerDay)-3:]
+	want := []DailyCaptures{{"2024-05-30", 2}, {"2024-05-31", 0}, {"2024-06-01", 2}}
+	for i := range want {
+		if days[i] != want[i] {
+			t.Errorf("captures per day = %+v, want %+v", days, want)
+			break
+		}
+	}
+}
+
+func TestFormatBytes(t *testing.T) {
+	tests := []struct {
+		n    int64
+		want string
+	}{
+		{0, "0 B"},
+		{1023, "1023 B"},
+		{1024, "1.0 KiB"},
+		{1536, "1.5 KiB"},
+		{5 << 30, "5.0 GiB"},
+	}
+	for _, tt := range tests {
+		if got := formatBytes(tt.n); got != tt.want {
+			t.Errorf("formatBytes(%d) = %q, want %q", tt.n, got, tt.want)
+		}
+	}
+}
+
+func TestHandleStatsMethod(t *testing.T) {
+	rec := httptest.NewRecorder()
+	handleStats(rec, httptest.NewRequest(http.MethodPost, "/stats", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("POST /stats = %d, want %d", rec.Code, http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/blevesearch/bleve"
+	"github.com/blevesearch/bleve/search/query"
+)
+
+// searchFields are the document fields a clause may be restricted to.
+var searchFields = map[string]bool{"": true, "url": true, "title": true, "content": true, "notes": true}
+
+// QueryClause matches text in one field, or all fields when Field is empty.
+// Exactly one of Match (any of the words), Phrase (the words in order) or
+// Query (query string syntax) must be set.
+type QueryClause struct {
+	Field  string  `json:"field,omitempty"`
+	Match  string  `json:"match,omitempty"`
+	Phrase string  `json:"phrase,omitempty"`
+	Query  string  `json:"query,omitempty"`
+	Boost  float64 `json:"boost,omitempty"`
+}
+
+// StructuredQuery is the body of POST /search. Clauses combine like a
+// boolean query; Tags, AutoTags, SavedAfter, SavedBefore and MinWords
+// filter without affecting scores. Tags finds pages tagged by hand or by
+// the tagger, and AutoTags only those the tagger tagged.
+type StructuredQuery struct {
+	Must        []QueryClause `json:"must"`
+	Should      []QueryClause `json:"should"`
+	MustNot     []QueryClause `json:"mustNot"`
+	Tags        []string      `json:"tags"`
+	AutoTags    []string      `json:"autoTags"`
+	SavedAfter  *time.Time    `json:"savedAfter"`
+	SavedBefore *time.Time    `json:"savedBefore"`
+	MinWords    int           `json:"minWords"`
+	Sort        string        `json:"sort"`
+	Highlight   string        `json:"highlight"`
+	// AsOf searches the captures that were current at that time.
+	AsOf *time.Time `json:"asOf"`
+	// Starred and Unread only find starred or unread pages, Changed pages
+	// whose live version has materially changed, and Stale pages their
+	// server's caching headers say may have changed.
+	Starred bool `json:"starred"`
+	Unread  bool `json:"unread"`
+	Changed bool `json:"changed"`
+	Stale   bool `json:"stale"`
+	// IncludePrivate finds private pages too, as the include_private
+	// parameter does.
+	IncludePrivate bool `json:"includePrivate"`
+	// GroupBy returns the results in groups, as the group_by parameter
+	// does.
+	GroupBy string `json:"groupBy"`
+	// Collapse folds near-duplicates into the highest of them.
+	Collapse bool `json:"collapse"`
+	// SnippetLength is about how many characters each snippet shows, as
+	// the snippet_len parameter sets.
+	SnippetLength int `json:"snippetLen"`
+	// Fields are the result fields returned, as the fields parameter
+	// selects them.
+	Fields []string `json:"fields"`
+}
+
+func (c QueryClause) query() (query.Query, error) {
+	if !searchFields[c.Field] {
+		return nil, fmt.Errorf("unknown field %q", c.Field)
+	}
+	var q query.BoostableQuery
+	switch {
+	case c.Match != "" && c.Phrase == "" && c.Query == "":
+		match := bleve.NewMatchQuery(c.Match)
+		match.SetField(c.Field)
+		q = match
+	case c.Phrase != "" && c.Match == "" && c.Query == "":
+		phrase := bleve.NewMatchPhraseQuery(c.Phrase)
+		phrase.SetField(c.Field)
+		q = phrase
+	case c.Query != "" && c.Match == "" && c.Phrase == "":
+		if c.Field != "" {
+			return nil, fmt.Errorf("query clauses can't have a field; use field:term syntax instead")
+		}
+		q = bleve.NewQueryStringQuery(c.Query)
+	default:
+		return nil, fmt.Errorf("a clause needs exactly one of match, phrase or query")
+	}
+	if c.Boost != 0 {
+		q.SetBoost(c.Boost)
+	}
+	return q, nil
+}
+
+func clauseQueries(clauses []QueryClause) ([]query.Query, error) {
+	queries := make([]query.Query, 0, len(clauses))
+	for _, clause := range clauses {
+		q, err := clause.query()
+		if err != nil {
+			return nil, err
+		}
+		queries = append(queries, q)
+	}
+	return queries, nil
+}
+
+// bleveQuery translates the structured query to a bleve boolean query.
+func (s StructuredQuery) bleveQuery() (query.Query, error) {
+	must, err := clauseQueries(s.Must)
+	if err != nil {
+		return nil, err
+	}
+	should, err := clauseQueries(s.Should)
+	if err != nil {
+		return nil, err
+	}
+	mustNot, err := clauseQueries(s.MustNot)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, tag := range s.Tags {
+		q := bleve.NewMatchPhraseQuery(tag)
+		q.SetField("tags")
+		must = append(must, q)
+	}
+	for _, tag := range s.AutoTags {
+		q := bleve.NewMatchPhraseQuery(tag)
+		q.SetField("autoTags")
+		must = append(must, q)
+	}
+	if s.SavedAfter != nil || s.SavedBefore != nil {
+		var start, end time.Time
+		if s.SavedAfter != nil {
+			start = *s.SavedAfter
+		}
+		if s.SavedBefore != nil {
+			end = *s.SavedBefore
+		}
+		q := bleve.NewDateRangeQuery(start, end)
+		q.SetField("time")
+		must = append(must, q)
+	}
+	if s.MinWords > 0 {
+		min := float64(s.MinWords)
+		q := bleve.NewNumericRangeQuery(&min, nil)
+		q.SetField("wordCount")
+		must = append(must, q)
+	}
+	// Without positive clauses a boolean query matches nothing, so filters
+	// and exclusions apply to every page
+	if len(must) == 0 && len(should) == 0 {
+		must = append(must, bleve.NewMatchAllQuery())
+	}
+
+	// Empty clause lists are left unset, as an empty disjunction would
+	// match nothing. With must clauses present, should clauses only affect
+	// scoring.
+	boolean := bleve.NewBooleanQuery()
+	if len(must) > 0 {
+		boolean.AddMust(must...)
+	}
+	if len(should) > 0 {
+		boolean.AddShould(should...)
+	}
+	if len(mustNot) > 0 {
+		boolean.AddMustNot(mustNot...)
+	}
+	return boolean, nil
+}
+
+// handleStructuredSearch runs a StructuredQuery posted as JSON.
+func handleStructuredSearch(w http.ResponseWriter, r *http.Request) {
+	ctx, span := startRequestSpan(r, "search")
+	defer span.End()
+	var request StructuredQuery
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		writeError(w, http.StatusBadRequest, ErrInvalidRequest, "Invalid request body")
+		return
+	}
+	options := SearchOptions{Highlight: request.Highlight, Sort: request.Sort, Owner: requestUser(r),
+		Starred: request.Starred, Unread: request.Unread, Changed: request.Changed, Stale: request.Stale, GroupBy: request.GroupBy,
+		Collapse: request.Collapse, SnippetLength: request.SnippetLength, Fields: request.Fields}
+	if request.AsOf != nil {
+		options.AsOf = *request.AsOf
+	}
+	private, err := includePrivate(r, request.IncludePrivate)
+	if err != nil {
+		writeSearchOptionsError(w, err)
+		return
+	}
+	options.IncludePrivate = private
+	if err := options.validate(); err != nil {
+		writeError(w, http.StatusBadRequest, ErrInvalidRequest, err.Error())
+		return
+	}
+	q, err := request.bleveQuery()
+	if err != nil {
+		writeError(w, http.StatusBadRequest, ErrInvalidRequest, err.Error())
+		return
+	}
+	if !allowSearch(w, r) {
+		return
+	}
+
+	results, err := runSearch(ctx, q, options, localizerFor(r))
+	span.RecordError(err)
+	if errors.Is(err, errInvalidQuery) {
+		writeError(w, http.StatusBadRequest, ErrInvalidRequest, err.Error())
+		return
+	}
+	if err != nil {
+		log.Printf("Search error: %v", err)
+		writeError(w, http.StatusServiceUnavailable, ErrIndexUnavailable, "Search failed")
+		return
+	}
+	writeSearchResults(w, results, options)
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/blevesearch/bleve/search/query"
+)
+
+func TestStructuredQueryValidation(t *testing.T) {
+	tests := []struct {
+		name         string
+		query        StructuredQuery
+		wantBuildErr bool
+		wantInvalid  bool
+	}{
+		{"match", StructuredQuery{Must: []QueryClause{{Match: "go"}}}, false, false},
+		{"filters only", StructuredQuery{Tags: []string{"news"}}, false, false},
+		{"unknown field", StructuredQuery{Must: []QueryClause{{Field: "body", Match: "go"}}}, true, false},
+		{"two kinds", StructuredQuery{Must: []QueryClause{{Match: "a", Phrase: "b"}}}, true, false},
+		{"empty clause", StructuredQuery{Should: []QueryClause{{}}}, true, false},
+		{"query with field", StructuredQuery{Must: []QueryClause{{Field: "title", Query: "go"}}}, true, false},
+		{"valid query string", StructuredQuery{Must: []QueryClause{{Query: "title:go +memento"}}}, false, false},
+		{"unbalanced quote", StructuredQuery{Must: []QueryClause{{Query: `"unterminated`}}}, false, true},
+		{"bad query in must not", StructuredQuery{MustNot: []QueryClause{{Query: "title:>"}}}, false, true},
+	}
+	for _, tt := range tests {
+		q, err := tt.query.bleveQuery()
+		if (err != nil) != tt.wantBuildErr {
+			t.Errorf("%s: build error = %v, want error %v", tt.name, err, tt.wantBuildErr)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		var invalid bool
+		if v, ok := q.(query.ValidatableQuery); ok {
+			invalid = v.Validate() != nil
+		}
+		if invalid != tt.wantInvalid {
+			t.Errorf("%s: invalid = %v, want %v", tt.name, invalid, tt.wantInvalid)
+		}
+	}
+}
+
+func TestSearchPagesInvalidQuery(t *testing.T) {
+	// Parse errors are reported before the index is touched
+	for _, q := range []string{`"unterminated`, "a:>", "+", "~"} {
+		if _, err := searchPages(context.Background(), q, SearchOptions{Highlight: "none", Sort: "score"}, Localizer{Lang: defaultLocale}); !errors.Is(err, errInvalidQuery) {
+			t.Errorf("searchPages(%q) error = %v, want errInvalidQuery", q, err)
+		}
+	}
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+const (
+	defaultSuggestLimit = 10
+	maxSuggestLimit     = 50
+)
+
+// Suggestion is a page offered as a completion of a partial query.
+type Suggestion struct {
+	ID    string `json:"id"`
+	Title string `json:"title"`
+	URL   string `json:"url"`
+}
+
+// How well a key matched, best first
+const (
+	suggestTitleStart = iota
+	suggestTitleWord
+	suggestURL
+)
+
+type suggestKey struct {
+	key  string
+	page int
+	kind int
+}
+
+// suggester completes prefixes from a sorted list of lowercased keys: each
+// page's title, the words of its title, and its URL without the scheme.
+// Private pages are never suggested. The list is rebuilt on the next lookup
+// after pages change.
+type suggester struct {
+	mu     sync.Mutex
+	stale  bool
+	pages  []Suggestion
+	times  []time.Time
+	owners []string
+	keys   []suggestKey
+}
+
+var suggestions = &suggester{stale: true}
+
+// watchSuggestions marks the suggestions stale whenever a page is captured,
+// indexed or deleted.
+func watchSuggestions() {
+	ch := events.subscribe()
+	for event := range ch {
+		if strings.HasPrefix(event.Type, "page.") {
+			suggestions.mu.Lock()
+			suggestions.stale = true
+			suggestions.mu.Unlock()
+		}
+	}
+}
+
+// suggestURLKey strips what a user wouldn't type from a URL.
+func suggestURLKey(pageURL string) string {
+	key := strings.ToLower(pageURL)
+	for _, prefix := range []string{"https://", "http://", "www."} {
+		key = strings.TrimPrefix(key, prefix)
+	}
+	return key
+}
+
+// rebuild reloads the pages and their keys. s.mu must be held.
+func (s *suggester) rebuild() error {
+	pages, err := listPages()
+	if err != nil {
+		return err
+	}
+	s.pages, s.times, s.owners, s.keys = nil, nil, nil, nil
+	for _, page := range pages {
+		if page.Private {
+			continue
+		}
+		i := len(s.pages)
+		s.pages = append(s.pages, Suggestion{ID: page.ID, Title: page.Title, URL: page.URL})
+		s.times = append(s.times, page.Timestamp)
+		s.owners = append(s.owners, page.Owner)
+
+		title := strings.ToLower(strings.TrimSpace(page.Title))
+		if title != "" {
+			s.keys = append(s.keys, suggestKey{title, i, suggestTitleStart})
+		}
+		words := strings.FieldsFunc(title, func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+		})
+		for j, word := range words {
+			if j > 0 {
+				s.keys = append(s.keys, suggestKey{word, i, suggestTitleWord})
+			}
+		}
+		if page.URL != "" {
+			s.keys = append(s.keys, suggestKey{suggestURLKey(page.URL), i, suggestURL})
+		}
+	}
+	sort.Slice(s.keys, func(i, j int) bool { return s.keys[i].key < s.keys[j].key })
+	s.stale = false
+	return nil
+}
+
+// suggest returns up to limit pages with a key starting with prefix, best
+// matches first and newest first among equally good ones. When users are
+// enabled only owner's pages are suggested.
+func (s *suggester) suggest(prefix string, limit int, owner string) ([]Suggestion, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stale {
+		if err := s.rebuild(); err != nil {
+			return nil, err
+		}
+	}
+
+	prefix = strings.ToLower(strings.TrimSpace(prefix))
+	best := make(map[int]int)
+	for i := sort.Search(len(s.keys), func(i int) bool { return s.keys[i].key >= prefix }); i < len(s.keys); i++ {
+		key := s.keys[i]
+		if !strings.HasPrefix(key.key, prefix) {
+			break
+		}
+		if usersEnabled() && s.owners[key.page] != owner {
+			continue
+		}
+		if kind, ok := best[key.page]; !ok || key.kind < kind {
+			best[key.page] = key.kind
+		}
+	}
+
+	matches := make([]int, 0, len(best))
+	for page := range best {
+		matches = append(matches, page)
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		a, b := matches[i], matches[j]
+		if best[a] != best[b] {
+			return best[a] < best[b]
+		}
+		return s.times[a].After(s.times[b])
+	})
+	if len(matches) > limit {
+		matches = matches[:limit]
+	}
+	results := make([]Suggestion, len(matches))
+	for i, page := range matches {
+		results[i] = s.pages[page]
+	}
+	return results, nil
+}
+
+// openSearchSuggestions encodes suggestions in the OpenSearch suggestions
+// format browsers' address bars read: the query, then the completions
+// shown, a description of each and the URL each opens.
+func openSearchSuggestions(q string, results []Suggestion) []interface{} {
+	titles, descriptions, urls := []string{}, []string{}, []string{}
+	for _, result := range results {
+		titles = append(titles, result.Title)
+		descriptions = append(descriptions, result.URL)
+		urls = append(urls, result.URL)
+	}
+	return []interface{}{q, titles, descriptions, urls}
+}
+
+// handleSuggest completes a partial query with page titles and URLs, for
+// search-as-you-type in the extension, or in browsers' address bars with
+// format=opensearch.
+func handleSuggest(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, ErrMethodNotAllowed, "Method not allowed")
+		return
+	}
+	q := r.URL.Query().Get("q")
+	format := r.URL.Query().Get("format")
+	if format != "" && format != "opensearch" {
+		writeError(w, http.StatusBadRequest, ErrInvalidRequest, "format must be opensearch or left out")
+		return
+	}
+	if strings.TrimSpace(q) == "" {
+		// Address bars may ask before anything is typed, and expect the
+		// protocol's answer rather than an error
+		if format == "opensearch" {
+			w.Header().Set("Content-Type", openSearchSuggestType)
+			json.NewEncoder(w).Encode(openSearchSuggestions(q, nil))
+			return
+		}
+		writeError(w, http.StatusBadRequest, ErrInvalidRequest, "Missing query parameter")
+		return
+	}
+	limit := defaultSuggestLimit
+	if value := r.URL.Query().Get("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 || n > maxSuggestLimit {
+			writeError(w, http.StatusBadRequest, ErrInvalidRequest, "limit must be between 1 and 50")
+			return
+		}
+		limit = n
+	}
+
+	results, err := suggestions.suggest(q, limit, requestUser(r))
+	if err != nil {
+		log.Printf("Error building suggestions: %v", err)
+		writeError(w, http.StatusInternalServerError, ErrInternal, "Failed to list pages")
+		return
+	}
+	if format == "opensearch" {
+		w.Header().Set("Content-Type", openSearchSuggestType)
+		json.NewEncoder(w).Encode(openSearchSuggestions(q, results))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(results)
+}
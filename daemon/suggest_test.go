@@ -0,0 +1,139 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSuggest(t *testing.T) {
+	withPagesDir(t)
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	pages := []PageMetadata{
+		{URL: "https://go.dev/doc/effective_go", Title: "Effective Go"},
+		{URL: "https://www.example.com/golang", Title: "Learning Go generics"},
+		{URL: "https://example.org/rust", Title: "Go-to Rust patterns"},
+		{URL: "https://gopher.example/", Title: "Unrelated"},
+	}
+	for i, page := range pages {
+		page.Timestamp = base.Add(time.Duration(i) * time.Hour)
+		if _, err := storePage(page, "<p>body</p>", ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	suggestions.stale = true
+
+	tests := []struct {
+		prefix string
+		limit  int
+		want   []string
+	}{
+		// Title starts before title words before URLs, newest first
+		{"go", 10, []string{"Go-to Rust patterns", "Learning Go generics", "Effective Go", "Unrelated"}},
+		{"GO", 1, []string{"Go-to Rust patterns"}},
+		{"gen", 10, []string{"Learning Go generics"}},
+		{"example.com", 10, []string{"Learning Go generics"}},
+		{"  effective ", 10, []string{"Effective Go"}},
+		{"python", 10, nil},
+	}
+	for _, tt := range tests {
+		results, err := suggestions.suggest(tt.prefix, tt.limit, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, result := range results {
+			got = append(got, result.Title)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("suggest(%q) = %q, want %q", tt.prefix, got, tt.want)
+		}
+	}
+
+	// New pages show up once the broker reports them
+	if _, err := storePage(PageMetadata{URL: "https://example.com/python", Title: "Python"}, "<p>body</p>", ""); err != nil {
+		t.Fatal(err)
+	}
+	suggestions.mu.Lock()
+	suggestions.stale = true
+	suggestions.mu.Unlock()
+	if results, _ := suggestions.suggest("py", 10, ""); len(results) != 1 {
+		t.Errorf("suggest(py) after capture = %v, want one page", results)
+	}
+}
+
+func TestHandleSuggest(t *testing.T) {
+	withPagesDir(t)
+	if _, err := storePage(PageMetadata{URL: "https://example.com/a", Title: "Alpha"}, "<p>a</p>", ""); err != nil {
+		t.Fatal(err)
+	}
+	suggestions.stale = true
+
+	tests := []struct {
+		method string
+		query  string
+		status int
+	}{
+		{http.MethodGet, "?q=al", http.StatusOK},
+		{http.MethodGet, "?q=al&limit=50", http.StatusOK},
+		{http.MethodGet, "", http.StatusBadRequest},
+		{http.MethodGet, "?q=%20", http.StatusBadRequest},
+		{http.MethodGet, "?q=al&limit=0", http.StatusBadRequest},
+		{http.MethodGet, "?q=al&limit=51", http.StatusBadRequest},
+		{http.MethodPost, "?q=al", http.StatusMethodNotAllowed},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		handleSuggest(rec, httptest.NewRequest(tt.method, "/suggest"+tt.query, nil))
+		if rec.Code != tt.status {
+			t.Errorf("%s /suggest%s = %d, want %d", tt.method, tt.query, rec.Code, tt.status)
+			continue
+		}
+		if rec.Code == http.StatusOK {
+			var results []Suggestion
+			if err := json.Unmarshal(rec.Body.Bytes(), &results); err != nil {
+				t.Fatal(err)
+			}
+			if len(results) != 1 || results[0].Title != "Alpha" || results[0].URL != "https://example.com/a" {
+				t.Errorf("%s /suggest%s = %+v", tt.method, tt.query, results)
+			}
+		}
+	}
+}
+
+func TestOpenSearchSuggest(t *testing.T) {
+	withPagesDir(t)
+	if _, err := storePage(PageMetadata{URL: "https://example.com/a", Title: "Alpha"}, "<p>a</p>", ""); err != nil {
+		t.Fatal(err)
+	}
+	suggestions.stale = true
+
+	tests := []struct {
+		query  string
+		status int
+		want   string
+	}{
+		{"?format=opensearch&q=al", http.StatusOK, `["al",["Alpha"],["https://example.com/a"],["https://example.com/a"]]`},
+		{"?format=opensearch&q=zz", http.StatusOK, `["zz",[],[],[]]`},
+		{"?format=opensearch&q=", http.StatusOK, `["",[],[],[]]`},
+		{"?format=xml&q=al", http.StatusBadRequest, ""},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		handleSuggest(rec, httptest.NewRequest(http.MethodGet, "/suggest"+tt.query, nil))
+		if rec.Code != tt.status {
+			t.Errorf("/suggest%s = %d, want %d", tt.query, rec.Code, tt.status)
+			continue
+		}
+		if tt.want == "" {
+			continue
+		}
+		if got := strings.TrimSpace(rec.Body.String()); got != tt.want || rec.Header().Get("Content-Type") != openSearchSuggestType {
+			t.Errorf("/suggest%s = %s %s, want %s", tt.query, rec.Header().Get("Content-Type"), got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/blevesearch/bleve"
+	"github.com/blevesearch/bleve/search/query"
+)
+
+const (
+	// summarySentences is how many sentences an extractive summary takes.
+	summarySentences = 3
+	// summaryInputWords is how much of a page the chat model is given to
+	// summarize.
+	summaryInputWords = 1500
+	// summaryBoost is how much more a match in a page's summary counts than
+	// one elsewhere.
+	summaryBoost = 2.0
+	// summarySweepInterval is how often pages are looked over for missing
+	// summaries when none has been indexed.
+	summarySweepInterval = 10 * time.Minute
+)
+
+// SummariesConfig turns on summarizing every captured page in two or three
+// sentences. The summary is picked from the page's own sentences, or
+// written by the chat model set under ask when UseChatModel is set.
+type SummariesConfig struct {
+	Enabled      bool `json:"enabled"`
+	UseChatModel bool `json:"useChatModel"`
+}
+
+// summaryPrompt asks the chat model for a summary.
+const summaryPrompt = `Summarize the web page the user sends in two or three sentences of plain text.
+Say what the page is about and its main points, in the page's language, without an introduction.`
+
+// sentencePattern ends a sentence at a stop followed by a space, or at a
+// line break.
+var sentencePattern = regexp.MustCompile(`[.!?。！？]+["')\]]*\s+|\n+`)
+
+// summarize returns a summary of a page's text, written by the chat model
+// when summaries.useChatModel is set, or else its own most representative
+// sentences.
+func summarize(ctx context.Context, title, text string) (string, error) {
+	if strings.TrimSpace(text) == "" {
+		return "", nil
+	}
+	if !config.Summaries.UseChatModel {
+		return extractiveSummary(text, summarySentences), nil
+	}
+	words := strings.Fields(text)
+	if len(words) > summaryInputWords {
+		words = words[:summaryInputWords]
+	}
+	return chat(ctx, []chatMessage{
+		{Role: "system", Content: summaryPrompt},
+		{Role: "user", Content: title + "\n\n" + strings.Join(words, " ")},
+	})
+}
+
+// extractiveSummary picks up to n sentences of text, in their order, that
+// best represent it: those whose words are most frequent in the whole
+// text. Words of three letters or fewer, mostly stop words, don't count.
+// Text without sentences is cut to its first words.
+func extractiveSummary(text string, n int) string {
+	var sentences []string
+	for _, s := range sentencePattern.Split(text+"\n", -1) {
+		if s = strings.TrimSpace(s); len(strings.Fields(s)) >= 4 {
+			sentences = append(sentences, s)
+		}
+	}
+	if len(sentences) == 0 {
+		words := strings.Fields(text)
+		if len(words) > 40 {
+			return strings.Join(words[:40], " ") + "…"
+		}
+		return strings.Join(words, " ")
+	}
+
+	frequency := make(map[string]int)
+	for _, word := range summaryWords(text) {
+		frequency[word]++
+	}
+	type scored struct {
+		index int
+		score float64
+	}
+	ranked := make([]scored, len(sentences))
+	for i, s := range sentences {
+		words := summaryWords(s)
+		total := 0
+		for _, word := range words {
+			total += frequency[word]
+		}
+		// Long sentences aren't favoured for their length alone
+		ranked[i] = scored{index: i, score: float64(total) / float64(len(words)+5)}
+	}
+	sort.SliceStable(ranked, func(i, j int) bool { return ranked[i].score > ranked[j].score })
+	if len(ranked) > n {
+		ranked = ranked[:n]
+	}
+	sort.Slice(ranked, func(i, j int) bool { return ranked[i].index < ranked[j].index })
+	picked := make([]string, len(ranked))
+	for i, r := range ranked {
+		picked[i] = ensureStop(sentences[r.index])
+	}
+	return strings.Join(picked, " ")
+}
+
+// ensureStop ends a sentence with a full stop unless it has one.
+func ensureStop(sentence string) string {
+	if last, _ := utf8.DecodeLastRuneInString(sentence); !unicode.IsPunct(last) {
+		return sentence + "."
+	}
+	return sentence
+}
+
+// summaryWords returns the lowercased words of text longer than three
+// letters.
+func summaryWords(text string) []string {
+	var words []string
+	for _, word := range strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}) {
+		if len([]rune(word)) > 3 {
+			words = append(words, word)
+		}
+	}
+	return words
+}
+
+// summarizePending summarizes the pages without a summary, indexing each
+// again with it. Bookmarks, which have no text of their own, are left out.
+// It stops at the first failure to reach the chat model.
+func summarizePending(ctx context.Context) (int, error) {
+	pages, err := listPages()
+	if err != nil {
+		return 0, err
+	}
+	count := 0
+	for _, page := range pages {
+		if page.Summary != "" || page.Bookmark || !page.Indexed {
+			continue
+		}
+		text, err := readPageText(page.PageMetadata)
+		if err != nil {
+			log.Printf("Error reading page %s to summarize: %v", page.ID, err)
+			continue
+		}
+		summary, err := summarize(ctx, page.Title, text)
+		if err != nil {
+			if errors.Is(err, errModelUnavailable) || ctx.Err() != nil {
+				return count, err
+			}
+			log.Printf("Error summarizing page %s: %v", page.ID, err)
+			continue
+		}
+		if summary == "" {
+			continue
+		}
+		err = reindexWith(ctx, page.ID, func(m *PageMetadata) error {
+			m.Summary = summary
+			return nil
+		})
+		if err != nil {
+			log.Printf("Error storing the summary of %s: %v", page.ID, err)
+			continue
+		}
+		count++
+	}
+	return count, nil
+}
+
+// watchSummaries summarizes pages once they are indexed, looking them
+// over whenever a page is indexed and every summarySweepInterval.
+func watchSummaries() {
+	if !config.Summaries.Enabled {
+		return
+	}
+	sweepOnIndex(summarySweepInterval, func() {
+		count, err := summarizePending(context.Background())
+		if err != nil {
+			log.Printf("Error summarizing pages: %v", err)
+		}
+		if count > 0 {
+			log.Printf("Summarized %d pages", count)
+		}
+	})
+}
+
+// boostSummaries makes pages whose summary matches text rank higher.
+func boostSummaries(q query.Query, text string) query.Query {
+	summarized := bleve.NewMatchQuery(text)
+	summarized.SetField("summary")
+	summarized.SetBoost(summaryBoost)
+	boolean := bleve.NewBooleanQuery()
+	boolean.AddMust(q)
+	boolean.AddShould(summarized)
+	return boolean
+}
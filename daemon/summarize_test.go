@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestExtractiveSummary(t *testing.T) {
+	text := `Home | About | Contact
+Sourdough bread is leavened by a starter of wild yeast and bacteria.
+The weather was nice that weekend.
+A starter needs flour and water to keep the yeast alive.
+Feed the starter daily, and the bread will rise well.
+Share this post with your friends on social media today.`
+	summary := extractiveSummary(text, 3)
+	want := "Sourdough bread is leavened by a starter of wild yeast and bacteria. A starter needs flour and water to keep the yeast alive. Feed the starter daily, and the bread will rise well."
+	if summary != want {
+		t.Errorf("summary = %q, want %q", summary, want)
+	}
+	if summary := extractiveSummary("Just a heading", 3); summary != "Just a heading" {
+		t.Errorf("summary without sentences = %q", summary)
+	}
+}
+
+func TestSummarizePending(t *testing.T) {
+	withPagesDir(t)
+	withIndex(t, nil)
+	saved := config
+	defer func() { config = saved }()
+	config.Summaries = SummariesConfig{Enabled: true}
+
+	content := "Kubernetes schedules containers across a cluster of machines. It restarts containers that fail. Operators describe the containers they want and the cluster converges on it. Lunch was good."
+	id, err := storePage(PageMetadata{URL: "https://k8s.example/", Title: "Kubernetes"}, "", content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bookmark, err := storePage(PageMetadata{URL: "https://later.example/", Title: "Later", Bookmark: true, Note: "Read this later, when there is time."}, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	indexExistingFiles(context.Background())
+
+	if count, err := summarizePending(context.Background()); err != nil || count != 1 {
+		t.Fatalf("summarizePending = %d, %v", count, err)
+	}
+	page, _ := loadPage(id)
+	if page.Summary == "" || strings.Contains(page.Summary, "Lunch") || !page.Indexed {
+		t.Errorf("page = %+v", page.PageMetadata)
+	}
+	if page, _ := loadPage(bookmark); page.Summary != "" {
+		t.Errorf("bookmark summarized as %q", page.Summary)
+	}
+
+	// The summary is the snippet
+	results, err := searchPages(context.Background(), "restarts", SearchOptions{Sort: "score", Highlight: "none"}, Localizer{Lang: defaultLocale})
+	if err != nil || len(results) != 1 || !strings.Contains(results[0].Snippet, "restarts containers that fail") || strings.Contains(results[0].Snippet, "Lunch") {
+		t.Errorf("results = %+v, %v", results, err)
+	}
+
+	// The chat model writes summaries when asked to
+	model := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{"message": chatMessage{Role: "assistant", Content: " Kubernetes runs containers. "}})
+	}))
+	defer model.Close()
+	config.Ask.URL, config.Ask.Model = model.URL, "test"
+	config.Summaries.UseChatModel = true
+	if summary, err := summarize(context.Background(), "Kubernetes", content); err != nil || summary != "Kubernetes runs containers." {
+		t.Errorf("summarize = %q, %v", summary, err)
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+)
+
+// templateNamePattern is what search template names may look like, being
+// used as a path segment.
+var templateNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+// reservedTemplateNames are the /search/ routes a template can't shadow.
+var reservedTemplateNames = map[string]bool{"clicks": true, "semantic": true}
+
+// validateSearchTemplates checks that every template has a usable name,
+// builds a valid query and sorts by a known order.
+func validateSearchTemplates(templates map[string]StructuredQuery) error {
+	for name, template := range templates {
+		if !templateNamePattern.MatchString(name) || reservedTemplateNames[name] {
+			return fmt.Errorf("search template name %q must be lowercase letters, digits, - and _, and not clicks or semantic", name)
+		}
+		options := SearchOptions{Highlight: template.Highlight, Sort: template.Sort}
+		if err := options.validate(); err != nil {
+			return fmt.Errorf("search template %s: %v", name, err)
+		}
+		if _, err := template.withText("").bleveQuery(); err != nil {
+			return fmt.Errorf("search template %s: %v", name, err)
+		}
+	}
+	return nil
+}
+
+// withText returns the query with text, in query string syntax, added as a
+// clause every result must match. The query itself is left unchanged.
+func (s StructuredQuery) withText(text string) StructuredQuery {
+	s.Must = append([]QueryClause(nil), s.Must...)
+	if text != "" {
+		s.Must = append(s.Must, QueryClause{Query: text})
+	}
+	return s
+}
+
+// handleTemplateSearch runs the search template named in the path,
+// narrowed by the q parameter if given. The sort, highlight, as_of, starred
+// and unread parameters override or add to the template's own.
+func handleTemplateSearch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, ErrMethodNotAllowed, "Method not allowed")
+		return
+	}
+	template, ok := config.Search.Templates[r.PathValue("template")]
+	if !ok {
+		writeError(w, http.StatusNotFound, ErrNotFound, "Search template not found")
+		return
+	}
+	ctx, span := startRequestSpan(r, "search")
+	defer span.End()
+
+	params := r.URL.Query()
+	options, err := parseSearchOptions(r)
+	if err != nil {
+		writeSearchOptionsError(w, err)
+		return
+	}
+	// The template's sort and highlight were validated with the config
+	if params.Get("sort") == "" && template.Sort != "" {
+		options.Sort = template.Sort
+	}
+	if params.Get("highlight") == "" && template.Highlight != "" {
+		options.Highlight = template.Highlight
+	}
+	if template.AsOf != nil && options.AsOf.IsZero() {
+		options.AsOf = *template.AsOf
+	}
+	options.Starred = options.Starred || template.Starred
+	options.Unread = options.Unread || template.Unread
+	options.Changed = options.Changed || template.Changed
+	q, err := template.withText(params.Get("q")).bleveQuery()
+	if err != nil {
+		writeError(w, http.StatusBadRequest, ErrInvalidRequest, err.Error())
+		return
+	}
+	if !allowSearch(w, r) {
+		return
+	}
+
+	results, err := runSearch(ctx, q, options, localizerFor(r))
+	span.RecordError(err)
+	if errors.Is(err, errInvalidQuery) {
+		writeError(w, http.StatusBadRequest, ErrInvalidRequest, err.Error())
+		return
+	}
+	if err != nil {
+		log.Printf("Search error: %v", err)
+		writeError(w, http.StatusServiceUnavailable, ErrIndexUnavailable, "Search failed")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Add("Vary", "Accept-Language")
+	json.NewEncoder(w).Encode(results)
+}
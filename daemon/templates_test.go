@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestValidateSearchTemplates(t *testing.T) {
+	tests := []struct {
+		name      string
+		templates map[string]StructuredQuery
+		wantErr   bool
+	}{
+		{"none", nil, false},
+		{"valid", map[string]StructuredQuery{"papers": {Must: []QueryClause{{Query: "tags:paper"}}, MinWords: 3000, Sort: "-time"}}, false},
+		{"filters only", map[string]StructuredQuery{"long-reads": {MinWords: 2000}}, false},
+		{"bad name", map[string]StructuredQuery{"Long Reads": {}}, true},
+		{"reserved name", map[string]StructuredQuery{"clicks": {}}, true},
+		{"bad sort", map[string]StructuredQuery{"papers": {Sort: "published"}}, true},
+		{"bad clause", map[string]StructuredQuery{"papers": {Must: []QueryClause{{Match: "a", Phrase: "b"}}}}, true},
+	}
+	for _, tt := range tests {
+		if err := validateSearchTemplates(tt.templates); (err != nil) != tt.wantErr {
+			t.Errorf("%s: error = %v, want error %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestTemplateSearch(t *testing.T) {
+	now := time.Now()
+	withIndex(t, map[string]PageDocument{
+		"long":  {URL: "https://example.com/long", Content: "graph neural networks", Time: now.Add(-time.Hour), Tags: []string{"paper"}, WordCount: 5000},
+		"newer": {URL: "https://example.com/newer", Content: "graph databases", Time: now, Tags: []string{"paper"}, WordCount: 4000},
+		"short": {URL: "https://example.com/short", Content: "graph theory note", Time: now, Tags: []string{"paper"}, WordCount: 300},
+		"blog":  {URL: "https://example.com/blog", Content: "graph neural networks explained", Time: now, WordCount: 6000},
+	})
+	saved := config.Search.Templates
+	defer func() { config.Search.Templates = saved }()
+	config.Search.Templates = map[string]StructuredQuery{
+		"papers": {Must: []QueryClause{{Query: "tags:paper"}}, MinWords: 3000, Sort: "-time"},
+	}
+
+	tests := []struct {
+		name     string
+		template string
+		query    string
+		want     int
+		wantURLs []string
+	}{
+		{"template", "papers", "", http.StatusOK, []string{"https://example.com/newer", "https://example.com/long"}},
+		{"narrowed", "papers", "q=neural", http.StatusOK, []string{"https://example.com/long"}},
+		{"sort overridden", "papers", "sort=time", http.StatusOK, []string{"https://example.com/long", "https://example.com/newer"}},
+		{"bad query", "papers", "q=title:%3E", http.StatusBadRequest, nil},
+		{"unknown template", "videos", "", http.StatusNotFound, nil},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/search/"+tt.template+"?"+tt.query, nil)
+		req.SetPathValue("template", tt.template)
+		w := httptest.NewRecorder()
+		handleTemplateSearch(w, req)
+		if w.Code != tt.want {
+			t.Errorf("%s: status = %d, want %d: %s", tt.name, w.Code, tt.want, w.Body)
+			continue
+		}
+		if tt.want != http.StatusOK {
+			continue
+		}
+		var results []SearchResult
+		json.Unmarshal(w.Body.Bytes(), &results)
+		var urls []string
+		for _, result := range results {
+			urls = append(urls, result.URL)
+		}
+		if !reflect.DeepEqual(urls, tt.wantURLs) {
+			t.Errorf("%s: got %v, want %v", tt.name, urls, tt.wantURLs)
+		}
+	}
+	// The template's clauses aren't added to by searches
+	if len(config.Search.Templates["papers"].Must) != 1 {
+		t.Errorf("template changed: %+v", config.Search.Templates["papers"])
+	}
+}
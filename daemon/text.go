@@ -0,0 +1,67 @@
+package main
+
+import (
+	"html"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+var (
+	invisibleBlockRe = regexp.MustCompile(`(?is)<script.*?</script\s*>|<style.*?</style\s*>|<noscript.*?</noscript\s*>`)
+	commentRe        = regexp.MustCompile(`(?s)<!--.*?-->`)
+	blockTagRe       = regexp.MustCompile(`(?i)</?(p|div|br|li|ul|ol|h[1-6]|tr|table|section|article|header|footer|blockquote|pre)\b[^>]*>`)
+	tagRe            = regexp.MustCompile(`(?s)<[^>]*>`)
+	spaceRe          = regexp.MustCompile(`[ \t\r\f\v]+`)
+	blankLinesRe     = regexp.MustCompile(`\n{3,}`)
+)
+
+// extractText reduces stored page content to readable plain text. HTML is
+// stripped of markup, and both HTML and markdown lose the interaction comments
+// the extension embeds.
+func extractText(content string, isHTML bool) string {
+	text := commentRe.ReplaceAllString(content, "")
+	if isHTML {
+		text = invisibleBlockRe.ReplaceAllString(text, "")
+		text = blockTagRe.ReplaceAllString(text, "\n")
+		text = tagRe.ReplaceAllString(text, "")
+		text = html.UnescapeString(text)
+	}
+
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimSpace(spaceRe.ReplaceAllString(line, " "))
+	}
+	text = strings.Join(lines, "\n")
+	return strings.TrimSpace(blankLinesRe.ReplaceAllString(text, "\n\n"))
+}
+
+// readPageText loads the preferred content file of a page as plain text,
+// without its site's boilerplate when boilerplate.stripText is set.
+func readPageText(metadata PageMetadata) (string, error) {
+	text, err := readFullText(metadata)
+	if err != nil || !config.Boilerplate.StripText {
+		return text, err
+	}
+	return stripBlocks(text, siteBoilerplate(metadata.URL)), nil
+}
+
+// readFullText loads the preferred content file of a page as plain text,
+// boilerplate and all.
+func readFullText(metadata PageMetadata) (string, error) {
+	path := contentPath(metadata)
+	content, err := readContentFile(path)
+	if err != nil {
+		return "", err
+	}
+	return extractText(string(content), isHTMLFile(path)), nil
+}
+
+func isHTMLFile(path string) bool {
+	ext := strings.ToLower(filepath.Ext(strings.TrimSuffix(path, gzipExt)))
+	return ext == ".html" || ext == ".htm"
+}
+
+func wordCount(text string) int {
+	return len(strings.Fields(text))
+}
@@ -0,0 +1,361 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"html"
+	"log"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/blevesearch/bleve"
+	"github.com/blevesearch/bleve/search/query"
+)
+
+// highlightBoost is how much more a search's words count when found in a
+// page's highlights than in the rest of it.
+const highlightBoost = 3.0
+
+var errHighlightNotFound = errors.New("highlight not found")
+
+// TextHighlight is a passage of a page the user highlighted in the browser.
+type TextHighlight struct {
+	ID    string `json:"id"`
+	Quote string `json:"quote"`
+	// Selector is a CSS selector of the element the highlight starts in,
+	// and Start and End are character offsets into that element's text, as
+	// the extension found them on the live page.
+	Selector string    `json:"selector,omitempty"`
+	Start    int       `json:"start,omitempty"`
+	End      int       `json:"end,omitempty"`
+	Created  time.Time `json:"created"`
+}
+
+// highlightText joins the quotes of a page's highlights for indexing.
+func highlightText(highlights []TextHighlight) string {
+	quotes := make([]string, len(highlights))
+	for i, highlight := range highlights {
+		quotes[i] = highlight.Quote
+	}
+	return strings.Join(quotes, "\n")
+}
+
+// boostHighlights ranks pages whose highlights match text above those that
+// only match q elsewhere.
+func boostHighlights(q query.Query, text string) query.Query {
+	highlighted := bleve.NewMatchQuery(text)
+	highlighted.SetField("highlights")
+	highlighted.SetBoost(highlightBoost)
+	boolean := bleve.NewBooleanQuery()
+	boolean.AddMust(q)
+	boolean.AddShould(highlighted)
+	return boolean
+}
+
+var (
+	// markupPattern splits HTML into comments and tags, and the text
+	// between them.
+	markupPattern = regexp.MustCompile(`(?s)<!--.*?-->|<[^>]*>`)
+	// rawTextTag opens or closes an element whose text isn't shown.
+	rawTextTag = regexp.MustCompile(`(?i)^<(/?)(script|style|title|textarea|noscript|template)\b`)
+	// blockTag starts or ends a line in rendered text.
+	blockTag = regexp.MustCompile(`(?i)^</?(p|div|br|li|ul|ol|h[1-6]|tr|td|th|table|section|article|blockquote|pre|header|footer|nav|aside|figure|figcaption|dt|dd)\b`)
+)
+
+// textPosition locates a byte of the rendered text in the HTML: the text
+// segment it came from and its offset in that segment's unescaped text.
+// Spaces standing for block boundaries have a segment of -1.
+type textPosition struct {
+	segment int
+	offset  int
+}
+
+// markRange is a part of a text segment to wrap in a mark element.
+type markRange struct {
+	start, end int
+	id         string
+}
+
+// markHighlights wraps the passages of doc quoted by highlights in mark
+// elements, so that they show on the stored copy. Quotes are matched
+// against the page's text with whitespace collapsed, as browsers report
+// selections, and each is marked where it first appears. Highlights whose
+// quote isn't found, or overlaps one marked before, are left out.
+func markHighlights(doc string, highlights []TextHighlight) string {
+	if len(highlights) == 0 {
+		return doc
+	}
+
+	// Split the document into markup and text segments
+	var segments []string
+	var isText []bool
+	last := 0
+	for _, loc := range markupPattern.FindAllStringIndex(doc, -1) {
+		if loc[0] > last {
+			segments = append(segments, doc[last:loc[0]])
+			isText = append(isText, true)
+		}
+		segments = append(segments, doc[loc[0]:loc[1]])
+		isText = append(isText, false)
+		last = loc[1]
+	}
+	if last < len(doc) {
+		segments = append(segments, doc[last:])
+		isText = append(isText, true)
+	}
+
+	// Build the rendered text, with each of its bytes' positions
+	var text strings.Builder
+	var positions []textPosition
+	unescaped := make(map[int]string)
+	spaced := true
+	space := func(segment, offset int) {
+		if !spaced {
+			text.WriteByte(' ')
+			positions = append(positions, textPosition{segment, offset})
+			spaced = true
+		}
+	}
+	hidden := false
+	for i, segment := range segments {
+		if !isText[i] {
+			if m := rawTextTag.FindStringSubmatch(segment); m != nil {
+				hidden = m[1] == ""
+			} else if blockTag.MatchString(segment) {
+				space(-1, 0)
+			}
+			continue
+		}
+		if hidden {
+			continue
+		}
+		plain := html.UnescapeString(segment)
+		unescaped[i] = plain
+		for offset, r := range plain {
+			if unicode.IsSpace(r) {
+				space(i, offset)
+				continue
+			}
+			spaced = false
+			n := text.Len()
+			text.WriteRune(r)
+			for b := n; b < text.Len(); b++ {
+				positions = append(positions, textPosition{i, offset + b - n})
+			}
+		}
+	}
+
+	// Find each quote, and the parts of text segments it covers
+	rendered := text.String()
+	marks := make(map[int][]markRange)
+	covered := make([]bool, len(rendered))
+	for _, highlight := range highlights {
+		quote := strings.Join(strings.Fields(highlight.Quote), " ")
+		if quote == "" {
+			continue
+		}
+		at := strings.Index(rendered, quote)
+		if at < 0 {
+			continue
+		}
+		overlaps := false
+		for b := at; b < at+len(quote); b++ {
+			overlaps = overlaps || covered[b]
+		}
+		if overlaps {
+			continue
+		}
+		ranges := make(map[int]markRange)
+		for b := at; b < at+len(quote); b++ {
+			covered[b] = true
+			pos := positions[b]
+			if pos.segment < 0 {
+				continue
+			}
+			r, ok := ranges[pos.segment]
+			if !ok {
+				r = markRange{start: pos.offset, id: highlight.ID}
+			}
+			r.end = pos.offset + 1
+			ranges[pos.segment] = r
+		}
+		for segment, r := range ranges {
+			marks[segment] = append(marks[segment], r)
+		}
+	}
+
+	var b strings.Builder
+	for i, segment := range segments {
+		ranges, ok := marks[i]
+		if !ok {
+			b.WriteString(segment)
+			continue
+		}
+		sort.Slice(ranges, func(x, y int) bool { return ranges[x].start < ranges[y].start })
+		plain := unescaped[i]
+		at := 0
+		for _, r := range ranges {
+			b.WriteString(html.EscapeString(plain[at:r.start]))
+			b.WriteString(`<mark class="memento-highlight" data-highlight="` + html.EscapeString(r.id) + `">`)
+			b.WriteString(html.EscapeString(plain[r.start:r.end]))
+			b.WriteString(`</mark>`)
+			at = r.end
+		}
+		b.WriteString(html.EscapeString(plain[at:]))
+	}
+	return b.String()
+}
+
+// newHighlight checks a highlight sent by a client and gives it an ID.
+func newHighlight(highlight TextHighlight) (TextHighlight, error) {
+	if strings.TrimSpace(highlight.Quote) == "" {
+		return highlight, errors.New("a highlight needs the quoted text")
+	}
+	if len(highlight.Quote) > maxAnnotationLength || len(highlight.Selector) > maxAnnotationLength {
+		return highlight, errors.New("highlight quote and selector must be at most 10000 bytes")
+	}
+	if highlight.Start < 0 || highlight.End < highlight.Start {
+		return highlight, errors.New("highlight offsets must satisfy 0 <= start <= end")
+	}
+	id := make([]byte, 8)
+	rand.Read(id)
+	highlight.ID = hex.EncodeToString(id)
+	highlight.Created = time.Now()
+	return highlight, nil
+}
+
+// addHighlight stores a highlight on a page and responds with it.
+func addHighlight(w http.ResponseWriter, r *http.Request, page Page, highlight TextHighlight) {
+	highlight, err := newHighlight(highlight)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, ErrInvalidRequest, err.Error())
+		return
+	}
+	err = reindexWith(r.Context(), page.ID, func(metadata *PageMetadata) error {
+		metadata.Highlights = append(metadata.Highlights, highlight)
+		return nil
+	})
+	if err != nil {
+		log.Printf("Error highlighting page %s: %v", page.ID, err)
+		writeError(w, http.StatusInternalServerError, ErrInternal, "Failed to save highlight")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(highlight)
+}
+
+// handlePageHighlights lists a page's highlights on GET, and on POST adds
+// one given as {"quote": "...", "selector": "...", "start": 0, "end": 10}.
+func handlePageHighlights(w http.ResponseWriter, r *http.Request) {
+	page, err := loadPage(r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusNotFound, ErrNotFound, "Page not found")
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		highlights := page.Highlights
+		if highlights == nil {
+			highlights = []TextHighlight{}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(highlights)
+	case http.MethodPost:
+		var highlight TextHighlight
+		if err := json.NewDecoder(r.Body).Decode(&highlight); err != nil {
+			writeError(w, http.StatusBadRequest, ErrInvalidRequest, "Invalid request body")
+			return
+		}
+		addHighlight(w, r, page, highlight)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, ErrMethodNotAllowed, "Method not allowed")
+	}
+}
+
+// handlePageHighlight deletes one of a page's highlights.
+func handlePageHighlight(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		writeError(w, http.StatusMethodNotAllowed, ErrMethodNotAllowed, "Method not allowed")
+		return
+	}
+	page, err := loadPage(r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusNotFound, ErrNotFound, "Page not found")
+		return
+	}
+	highlightID := r.PathValue("highlight")
+	err = reindexWith(r.Context(), page.ID, func(metadata *PageMetadata) error {
+		for i, highlight := range metadata.Highlights {
+			if highlight.ID == highlightID {
+				metadata.Highlights = append(metadata.Highlights[:i], metadata.Highlights[i+1:]...)
+				return nil
+			}
+		}
+		return errHighlightNotFound
+	})
+	if errors.Is(err, errHighlightNotFound) {
+		writeError(w, http.StatusNotFound, ErrNotFound, "Highlight not found")
+		return
+	}
+	if err != nil {
+		log.Printf("Error deleting highlight of page %s: %v", page.ID, err)
+		writeError(w, http.StatusInternalServerError, ErrInternal, "Failed to delete highlight")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// latestCapture returns the current capture of a URL that the request may
+// see, for clients such as the extension that know the URL but not the ID.
+func latestCapture(r *http.Request, pageURL string) (Page, bool, error) {
+	pages, err := listPages()
+	if err != nil {
+		return Page{}, false, err
+	}
+	var latest Page
+	found := false
+	for _, page := range pages {
+		if page.URL != pageURL || page.SupersededBy != "" || !pageVisible(r, page.PageMetadata) {
+			continue
+		}
+		if !found || page.Timestamp.After(latest.Timestamp) {
+			latest, found = page, true
+		}
+	}
+	return latest, found, nil
+}
+
+// handleHighlights adds a highlight to the current capture of a URL, given
+// as {"url": "...", "quote": "...", ...}.
+func handleHighlights(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, ErrMethodNotAllowed, "Method not allowed")
+		return
+	}
+	var request struct {
+		URL string `json:"url"`
+		TextHighlight
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.URL == "" {
+		writeError(w, http.StatusBadRequest, ErrInvalidRequest, "Request body must give the url of a page and the quote")
+		return
+	}
+	page, found, err := latestCapture(r, request.URL)
+	if err != nil {
+		log.Printf("Error listing pages: %v", err)
+		writeError(w, http.StatusInternalServerError, ErrInternal, "Failed to find page")
+		return
+	}
+	if !found {
+		writeError(w, http.StatusNotFound, ErrNotFound, "No capture of this URL")
+		return
+	}
+	addHighlight(w, r, page, request.TextHighlight)
+}
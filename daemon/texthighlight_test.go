@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMarkHighlights(t *testing.T) {
+	tests := []struct {
+		name   string
+		doc    string
+		quotes []string
+		want   string
+	}{
+		{"none", "<p>Hello world</p>", nil, "<p>Hello world</p>"},
+		{"within a text node", "<p>Hello brave world</p>", []string{"brave"},
+			`<p>Hello <mark class="memento-highlight" data-highlight="h0">brave</mark> world</p>`},
+		{"across elements", "<p>Hello <b>brave</b> world</p>", []string{"lo brave wo"},
+			`<p>Hel<mark class="memento-highlight" data-highlight="h0">lo </mark><b><mark class="memento-highlight" data-highlight="h0">brave</mark></b><mark class="memento-highlight" data-highlight="h0"> wo</mark>rld</p>`},
+		{"across blocks", "<p>First line</p>\n  <p>Second line</p>", []string{"line Second"},
+			`<p>First <mark class="memento-highlight" data-highlight="h0">line</mark></p>` + "\n  " + `<p><mark class="memento-highlight" data-highlight="h0">Second</mark> line</p>`},
+		{"collapsed whitespace", "<p>one\n   two</p>", []string{"one two"},
+			`<p><mark class="memento-highlight" data-highlight="h0">one` + "\n   " + `two</mark></p>`},
+		{"entities", "<p>Fish &amp; chips</p>", []string{"Fish & chips"},
+			`<p><mark class="memento-highlight" data-highlight="h0">Fish &amp; chips</mark></p>`},
+		{"first occurrence", "<p>tick tock tick</p>", []string{"tick"},
+			`<p><mark class="memento-highlight" data-highlight="h0">tick</mark> tock tick</p>`},
+		{"not in scripts", "<script>var a = 'secret';</script><p>secret</p>", []string{"secret"},
+			`<script>var a = 'secret';</script><p><mark class="memento-highlight" data-highlight="h0">secret</mark></p>`},
+		{"not found", "<p>Hello</p>", []string{"goodbye"}, "<p>Hello</p>"},
+		{"overlapping", "<p>abc def</p>", []string{"abc", "bc d"},
+			`<p><mark class="memento-highlight" data-highlight="h0">abc</mark> def</p>`},
+		{"two", "<p>abc def</p>", []string{"def", "abc"},
+			`<p><mark class="memento-highlight" data-highlight="h1">abc</mark> <mark class="memento-highlight" data-highlight="h0">def</mark></p>`},
+	}
+	for _, tt := range tests {
+		var highlights []TextHighlight
+		for i, quote := range tt.quotes {
+			highlights = append(highlights, TextHighlight{ID: "h" + string(rune('0'+i)), Quote: quote})
+		}
+		if got := markHighlights(tt.doc, highlights); got != tt.want {
+			t.Errorf("%s:\n got %s\nwant %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestHighlightBoost(t *testing.T) {
+	now := time.Now()
+	withIndex(t, map[string]PageDocument{
+		"plain":       {URL: "https://example.com/plain", Content: "retry flaky tests with backoff", Time: now},
+		"highlighted": {URL: "https://example.com/highlighted", Content: "retry flaky tests with backoff", Time: now, Highlights: "flaky tests"},
+	})
+	results, err := searchPages(context.Background(), "flaky", SearchOptions{Highlight: "none", Sort: "score"}, Localizer{Lang: defaultLocale})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 || results[0].URL != "https://example.com/highlighted" {
+		t.Errorf("results = %+v, want the highlighted page first", results)
+	}
+}
+
+func TestPageHighlights(t *testing.T) {
+	withPagesDir(t)
+	withIndex(t, nil)
+	id, err := storePage(PageMetadata{URL: "https://example.com/essay", Title: "Essay"}, "<p>The key idea is <em>locality</em>.</p>", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/highlights", handleHighlights)
+	mux.HandleFunc("/pages/{id}/highlights", handlePageHighlights)
+	mux.HandleFunc("/pages/{id}/highlights/{highlight}", handlePageHighlight)
+	mux.HandleFunc("/pages/{id}/view", handlePageView)
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(method, path, strings.NewReader(body)))
+		return rec
+	}
+
+	tests := []struct {
+		name string
+		path string
+		body string
+		want int
+	}{
+		{"by URL", "/highlights", `{"url": "https://example.com/essay", "quote": "idea is locality", "selector": "p", "start": 8, "end": 24}`, http.StatusCreated},
+		{"by ID", "/pages/" + id + "/highlights", `{"quote": "The key"}`, http.StatusCreated},
+		{"unknown URL", "/highlights", `{"url": "https://example.com/other", "quote": "x"}`, http.StatusNotFound},
+		{"no URL", "/highlights", `{"quote": "x"}`, http.StatusBadRequest},
+		{"no quote", "/pages/" + id + "/highlights", `{"quote": " "}`, http.StatusBadRequest},
+		{"bad offsets", "/pages/" + id + "/highlights", `{"quote": "key", "start": 5, "end": 2}`, http.StatusBadRequest},
+	}
+	var created []TextHighlight
+	for _, tt := range tests {
+		rec := do(http.MethodPost, tt.path, tt.body)
+		if rec.Code != tt.want {
+			t.Errorf("%s: status = %d, want %d: %s", tt.name, rec.Code, tt.want, rec.Body)
+			continue
+		}
+		if rec.Code == http.StatusCreated {
+			var highlight TextHighlight
+			json.Unmarshal(rec.Body.Bytes(), &highlight)
+			created = append(created, highlight)
+		}
+	}
+	if len(created) != 2 {
+		t.Fatalf("created = %+v", created)
+	}
+
+	view := do(http.MethodGet, "/pages/"+id+"/view", "").Body.String()
+	if !strings.Contains(view, `data-highlight="`+created[0].ID+`">locality</mark>`) || !strings.Contains(view, `">The key</mark>`) {
+		t.Errorf("view doesn't show the highlights: %s", view)
+	}
+	if got := searchURLs(t, "locality", SearchOptions{}); len(got) != 1 {
+		t.Errorf("search = %v", got)
+	}
+
+	if rec := do(http.MethodDelete, "/pages/"+id+"/highlights/"+created[1].ID, ""); rec.Code != http.StatusNoContent {
+		t.Errorf("delete: status = %d", rec.Code)
+	}
+	if rec := do(http.MethodDelete, "/pages/"+id+"/highlights/"+created[1].ID, ""); rec.Code != http.StatusNotFound {
+		t.Errorf("deleting again: status = %d", rec.Code)
+	}
+	var highlights []TextHighlight
+	json.Unmarshal(do(http.MethodGet, "/pages/"+id+"/highlights", "").Body.Bytes(), &highlights)
+	if len(highlights) != 1 || highlights[0].Selector != "p" || highlights[0].End != 24 {
+		t.Errorf("highlights = %+v", highlights)
+	}
+}
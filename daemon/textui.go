@@ -0,0 +1,158 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// textSearchTemplate is a server-rendered search page that works without
+// JavaScript or CSS, for terminal browsers, screen readers and old devices.
+var textSearchTemplate = template.Must(template.New("text").Parse(`<!DOCTYPE html>
+<html lang="{{.Lang}}">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{if .Query}}{{.Query}} - {{end}}Memento</title>
+<link rel="search" type="application/opensearchdescription+xml" title="Memento" href="/opensearch.xml">
+</head>
+<body>
+<header>
+<h1>Memento</h1>
+</header>
+<main>
+<form method="get" action="/text" role="search">
+<label for="q">{{.T "ui.search_label"}}</label>
+<input type="search" id="q" name="q" value="{{.Query}}">
+<input type="hidden" name="lang" value="{{.Lang}}">
+{{if .AsOf}}<input type="hidden" name="as_of" value="{{.AsOf}}">{{end}}
+<button type="submit">{{.T "ui.search_button"}}</button>
+</form>
+{{if .Error}}
+<p role="alert">{{.Error}}</p>
+{{else if .Query}}
+<h2 id="results-heading">{{.ResultCount}}</h2>
+{{if .Corrected}}<p>{{.T "ui.corrected" .Corrected}}</p>{{end}}
+{{if .Suggestions}}<p>{{.T "ui.did_you_mean"}}{{range .Suggestions}} <a href="{{$.SearchLink .}}">{{.}}</a>{{end}}</p>{{end}}
+{{if .Results}}
+<ol aria-labelledby="results-heading">
+{{range $i, $result := .Results}}
+<li>
+<article>
+<h3><a href="{{$.OpenLink $i $result}}">{{if .Title}}{{.Title}}{{else}}{{.URL}}{{end}}</a></h3>
+<p><cite>{{.URL}}</cite></p>
+{{if .Snippet}}<p>{{.Snippet}}</p>{{end}}
+{{if .SavedAgo}}<p><small>{{$.T "ui.saved" .SavedAgo}} (<time datetime="{{.Time.Format "2006-01-02T15:04:05Z07:00"}}">{{.SavedOn}}</time>)</small></p>{{end}}
+</article>
+</li>
+{{end}}
+</ol>
+{{else}}
+<p>{{.T "ui.no_results"}}</p>
+{{end}}
+{{end}}
+</main>
+</body>
+</html>
+`))
+
+type textSearchPage struct {
+	Localizer
+	Query       string
+	AsOf        string
+	Results     []SearchResult
+	ResultCount string
+	// Corrected is the spelling the results are for when the query as
+	// written found nothing, and Suggestions the others it may have meant.
+	Corrected   string
+	Suggestions []string
+	Error       string
+}
+
+// SearchLink is the text search page for another query.
+func (p textSearchPage) SearchLink(query string) string {
+	params := url.Values{"q": {query}, "lang": {p.Lang}}
+	if p.AsOf != "" {
+		params.Set("as_of", p.AsOf)
+	}
+	return "/text?" + params.Encode()
+}
+
+// OpenLink is where a result links, through /text/open when clicks are
+// being learned from so the click is recorded.
+func (p textSearchPage) OpenLink(position int, result SearchResult) string {
+	if !config.Search.LearnFromClicks {
+		return result.URL
+	}
+	params := url.Values{"id": {result.ID}, "q": {p.Query}, "position": {strconv.Itoa(position)}}
+	return "/text/open?" + params.Encode()
+}
+
+func handleTextSearch(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Add("Vary", "Accept-Language")
+
+	localizer := localizerFor(r)
+	page := textSearchPage{
+		Localizer: localizer,
+		Query:     r.URL.Query().Get("q"),
+		AsOf:      r.URL.Query().Get("as_of"),
+	}
+
+	if page.Query != "" {
+		if !allowSearch(w, r) {
+			return
+		}
+		ctx, span := startRequestSpan(r, "search")
+		defer span.End()
+		options := SearchOptions{Highlight: "none", Sort: "score", Fuzziness: config.Search.Fuzziness, Owner: requestUser(r)}
+		var results []SearchResult
+		var err error
+		if page.AsOf != "" {
+			if options.AsOf, err = parseAsOf(page.AsOf); err != nil {
+				err = fmt.Errorf("%w: %v", errInvalidQuery, err)
+			}
+		}
+		if err == nil {
+			results, err = searchPages(ctx, page.Query, options, localizer)
+		}
+		if err == nil && len(results) == 0 {
+			retried, corrected, suggestions, err := retryMisspelled(ctx, page.Query, options, localizer)
+			if err != nil {
+				log.Printf("Error correcting the spelling of %q: %v", page.Query, err)
+			} else if corrected != "" {
+				results = retried
+			}
+			page.Corrected = corrected
+			for _, suggestion := range suggestions {
+				if suggestion != corrected {
+					page.Suggestions = append(page.Suggestions, suggestion)
+				}
+			}
+		}
+		if errors.Is(err, errInvalidQuery) {
+			span.RecordError(err)
+			page.Error = localizer.T("ui.invalid_query")
+			w.WriteHeader(http.StatusBadRequest)
+		} else if err != nil {
+			log.Printf("Search error: %v", err)
+			span.RecordError(err)
+			page.Error = localizer.T("ui.search_failed")
+			w.WriteHeader(http.StatusServiceUnavailable)
+		} else {
+			page.Results = results
+			page.ResultCount = localizer.plural("ui.results", len(results))
+			if err := recordSearch(r, page.Query, len(results), false); err != nil {
+				log.Printf("Error recording search: %v", err)
+			}
+		}
+	}
+
+	if err := textSearchTemplate.Execute(w, page); err != nil {
+		log.Printf("Error rendering text search page: %v", err)
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestTextSearchForm(t *testing.T) {
+	rec := httptest.NewRecorder()
+	handleTextSearch(rec, httptest.NewRequest(http.MethodGet, "/text?lang=de", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", rec.Code)
+	}
+	body := rec.Body.String()
+	if !strings.Contains(body, `<html lang="de">`) || !strings.Contains(body, `name="q"`) {
+		t.Errorf("form page = %s", body)
+	}
+	if strings.Contains(body, `role="alert"`) {
+		t.Errorf("empty query shows an error: %s", body)
+	}
+}
+
+func TestTextSearchInvalidQuery(t *testing.T) {
+	for _, lang := range []string{"en", "fr"} {
+		rec := httptest.NewRecorder()
+		target := "/text?lang=" + lang + "&q=" + url.QueryEscape(`"unterminated`)
+		handleTextSearch(rec, httptest.NewRequest(http.MethodGet, target, nil))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: status = %d, want 400", lang, rec.Code)
+		}
+		want := `<p role="alert">` + (Localizer{Lang: lang}).T("ui.invalid_query") + `</p>`
+		if !strings.Contains(rec.Body.String(), want) {
+			t.Errorf("%s: page lacks %q:\n%s", lang, want, rec.Body)
+		}
+		// The query is echoed back escaped
+		if !strings.Contains(rec.Body.String(), `value="&#34;unterminated"`) {
+			t.Errorf("%s: query not escaped in the form", lang)
+		}
+	}
+}
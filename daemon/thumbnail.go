@@ -0,0 +1,299 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"html"
+	"image"
+	"image/color"
+	_ "image/gif"
+	_ "image/jpeg"
+	"image/png"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	thumbnailWidth  = 320
+	thumbnailHeight = 200
+	// maxThumbnailSourceBytes bounds the og:image downloaded for a
+	// thumbnail.
+	maxThumbnailSourceBytes = 10 << 20
+)
+
+// thumbnailPath is the URL of a page's thumbnail, for search results.
+func thumbnailPath(id string) string {
+	return "/pages/" + id + "/thumbnail"
+}
+
+// handlePageThumbnail serves a small preview of a page, generating it on
+// first request from its screenshot, its og:image or, failing both, its
+// title and text.
+func handlePageThumbnail(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		writeError(w, http.StatusMethodNotAllowed, ErrMethodNotAllowed, "Method not allowed")
+		return
+	}
+	page, err := loadPage(r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusNotFound, ErrNotFound, "Page not found")
+		return
+	}
+	if page.ThumbnailFilename == "" {
+		if page.ThumbnailFilename, err = generateThumbnail(r.Context(), page); err != nil {
+			log.Printf("Error generating thumbnail for %s: %v", page.ID, err)
+			writeError(w, http.StatusInternalServerError, ErrInternal, "Failed to generate thumbnail")
+			return
+		}
+	}
+	contentType := "image/png"
+	if strings.HasSuffix(page.ThumbnailFilename, ".svg") {
+		contentType = "image/svg+xml"
+		// Text thumbnails are SVG, which could otherwise carry script
+		w.Header().Set("Content-Security-Policy", "default-src 'none'; style-src 'unsafe-inline'")
+	}
+	servePageFile(w, r, contentType, func(page Page) string { return page.ThumbnailFilename })
+}
+
+// generateThumbnail makes and stores a page's thumbnail, returning its
+// filename.
+func generateThumbnail(ctx context.Context, page Page) (string, error) {
+	var img image.Image
+	if page.ScreenshotFilename != "" {
+		if f, err := os.Open(filepath.Join(pagesDir, page.ScreenshotFilename)); err == nil {
+			img, _, err = image.Decode(f)
+			f.Close()
+			if err != nil {
+				log.Printf("Error decoding screenshot of %s: %v", page.ID, err)
+			} else {
+				// The top of the page, where its heading is
+				img = cropTo(img, false)
+			}
+		}
+	}
+	if img == nil {
+		if src := pageImageURL(page); src != "" {
+			var err error
+			if img, err = fetchImage(ctx, src); err != nil {
+				log.Printf("Error fetching image %s for %s: %v", src, page.ID, err)
+			} else {
+				img = cropTo(img, true)
+			}
+		}
+	}
+
+	if img == nil {
+		name := page.ID + ".thumb.svg"
+		return name, attachPageFile(page.ID, name, textThumbnail(page), setThumbnail)
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, scaleImage(img, thumbnailWidth, thumbnailHeight)); err != nil {
+		return "", err
+	}
+	name := page.ID + ".thumb.png"
+	return name, attachPageFile(page.ID, name, buf.Bytes(), setThumbnail)
+}
+
+func setThumbnail(m *PageMetadata, name string) {
+	m.ThumbnailFilename = name
+}
+
+// pageImageURL returns the absolute URL of the og:image or twitter:image a
+// page's stored HTML declares, if any.
+func pageImageURL(page Page) string {
+	if page.HTMLFilename == "" {
+		return ""
+	}
+	data, err := readContentFile(filepath.Join(pagesDir, page.HTMLFilename))
+	if err != nil {
+		return ""
+	}
+	var found string
+	for _, tag := range htmlTagRe.FindAllStringSubmatch(string(data), -1) {
+		if !strings.EqualFold(tag[1], "meta") {
+			continue
+		}
+		attrs := make(map[string]string)
+		for _, a := range htmlAttrRe.FindAllStringSubmatch(tag[2], -1) {
+			attrs[strings.ToLower(a[1])] = html.UnescapeString(strings.Trim(a[2], `"'`))
+		}
+		name := strings.ToLower(attrs["property"] + attrs["name"])
+		if (name == "og:image" || name == "og:image:url" || name == "twitter:image") && attrs["content"] != "" {
+			found = attrs["content"]
+			break
+		}
+	}
+	if found == "" {
+		return ""
+	}
+	base, err := url.Parse(page.URL)
+	ref, err2 := url.Parse(found)
+	if err != nil || err2 != nil {
+		return ""
+	}
+	abs := base.ResolveReference(ref)
+	if abs.Scheme != "http" && abs.Scheme != "https" {
+		return ""
+	}
+	return abs.String()
+}
+
+// fetchImage downloads and decodes an image, only from public addresses.
+func fetchImage(ctx context.Context, src string) (image.Image, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, src, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", "memento/1.0 (+https://github.com/nascarsayan/memento)")
+	resp, err := archiveClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching %s: %s", src, resp.Status)
+	}
+	img, _, err := image.Decode(io.LimitReader(resp.Body, maxThumbnailSourceBytes))
+	return img, err
+}
+
+// cropTo crops an image to the thumbnail's aspect ratio, keeping the
+// center or the top.
+func cropTo(img image.Image, center bool) image.Image {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w == 0 || h == 0 {
+		return img
+	}
+	crop := b
+	if w*thumbnailHeight > h*thumbnailWidth {
+		cw := h * thumbnailWidth / thumbnailHeight
+		crop.Min.X += (w - cw) / 2
+		crop.Max.X = crop.Min.X + cw
+	} else {
+		ch := w * thumbnailHeight / thumbnailWidth
+		if center {
+			crop.Min.Y += (h - ch) / 2
+		}
+		crop.Max.Y = crop.Min.Y + ch
+	}
+	return subImage(img, crop)
+}
+
+func subImage(img image.Image, r image.Rectangle) image.Image {
+	if s, ok := img.(interface {
+		SubImage(image.Rectangle) image.Image
+	}); ok {
+		return s.SubImage(r)
+	}
+	return img
+}
+
+// scaleImage resizes an image by averaging the source pixels that fall in
+// each destination pixel, which is plenty for thumbnails.
+func scaleImage(img image.Image, width, height int) *image.RGBA {
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	b := img.Bounds()
+	for y := 0; y < height; y++ {
+		y0 := b.Min.Y + y*b.Dy()/height
+		y1 := b.Min.Y + (y+1)*b.Dy()/height
+		if y1 <= y0 {
+			y1 = y0 + 1
+		}
+		for x := 0; x < width; x++ {
+			x0 := b.Min.X + x*b.Dx()/width
+			x1 := b.Min.X + (x+1)*b.Dx()/width
+			if x1 <= x0 {
+				x1 = x0 + 1
+			}
+			var r, g, bl, a, n uint64
+			for sy := y0; sy < y1 && sy < b.Max.Y; sy++ {
+				for sx := x0; sx < x1 && sx < b.Max.X; sx++ {
+					cr, cg, cb, ca := img.At(sx, sy).RGBA()
+					r, g, bl, a = r+uint64(cr), g+uint64(cg), bl+uint64(cb), a+uint64(ca)
+					n++
+				}
+			}
+			if n == 0 {
+				continue
+			}
+			dst.Set(x, y, color.RGBA64{uint16(r / n), uint16(g / n), uint16(bl / n), uint16(a / n)})
+		}
+	}
+	return dst
+}
+
+// textThumbnail draws a page's title, host and first lines of text as an
+// SVG card.
+func textThumbnail(page Page) []byte {
+	var text string
+	if data, err := readContentFile(contentPath(page.PageMetadata)); err == nil {
+		text = extractText(string(data), isHTMLFile(contentPath(page.PageMetadata)))
+		text = strings.TrimSpace(strings.TrimPrefix(text, page.Title))
+	}
+	host := page.URL
+	if u, err := url.Parse(page.URL); err == nil && u.Host != "" {
+		host = strings.TrimPrefix(u.Host, "www.")
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d">`, thumbnailWidth, thumbnailHeight, thumbnailWidth, thumbnailHeight)
+	b.WriteString(`<style>text{font-family:system-ui,sans-serif}.t{font-size:17px;font-weight:600;fill:#222}.h{font-size:11px;fill:#777}.b{font-size:11px;fill:#555}</style>`)
+	b.WriteString(`<rect width="100%" height="100%" fill="#f6f5f2"/>`)
+	fmt.Fprintf(&b, `<text class="h" x="16" y="26">%s</text>`, html.EscapeString(truncateRunes(host, 48)))
+	y := 52
+	for _, line := range wrapText(page.Title, 30, 3) {
+		fmt.Fprintf(&b, `<text class="t" x="16" y="%d">%s</text>`, y, html.EscapeString(line))
+		y += 22
+	}
+	y += 4
+	for _, line := range wrapText(text, 50, (thumbnailHeight-y)/15) {
+		fmt.Fprintf(&b, `<text class="b" x="16" y="%d">%s</text>`, y, html.EscapeString(line))
+		y += 15
+	}
+	b.WriteString(`</svg>`)
+	return []byte(b.String())
+}
+
+// wrapText breaks text into at most maxLines lines of about width runes,
+// ending the last with an ellipsis if text remains.
+func wrapText(text string, width, maxLines int) []string {
+	var lines []string
+	var line string
+	words := strings.Fields(text)
+	for i, word := range words {
+		word = truncateRunes(word, width)
+		if line != "" && utf8.RuneCountInString(line)+1+utf8.RuneCountInString(word) > width {
+			lines = append(lines, line)
+			line = ""
+			if len(lines) == maxLines {
+				if i < len(words) {
+					lines[maxLines-1] = truncateRunes(lines[maxLines-1], width-1) + "…"
+				}
+				return lines
+			}
+		}
+		if line != "" {
+			line += " "
+		}
+		line += word
+	}
+	if line != "" && len(lines) < maxLines {
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+func truncateRunes(s string, n int) string {
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	return string([]rune(s)[:n-1]) + "…"
+}
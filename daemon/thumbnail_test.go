@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func testPNG(width, height int, c color.Color) []byte {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.Set(x, y, c)
+		}
+	}
+	var buf bytes.Buffer
+	png.Encode(&buf, img)
+	return buf.Bytes()
+}
+
+func TestPageThumbnail(t *testing.T) {
+	withPagesDir(t)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/pages/{id}/thumbnail", handlePageThumbnail)
+
+	shot, _ := storePage(PageMetadata{URL: "https://example.com/shot", Title: "Shot"}, "<p>x</p>", "")
+	attachPageFile(shot, shot+".png", testPNG(1280, 4000, color.RGBA{255, 0, 0, 255}), func(m *PageMetadata, name string) {
+		m.ScreenshotFilename = name
+	})
+	// The og:image is on a loopback address, which the archive client
+	// refuses, so the page falls back to text
+	og, _ := storePage(PageMetadata{URL: "http://127.0.0.1/article", Title: "With image"},
+		`<html><head><meta property="og:image" content="/cover.png"></head><body>Body</body></html>`, "")
+	text, _ := storePage(PageMetadata{URL: "https://www.example.com/notes", Title: "Notes & <thoughts>"},
+		"", "# Notes & <thoughts>\n\nFirst paragraph of the notes.")
+
+	tests := []struct {
+		id          string
+		status      int
+		contentType string
+		contains    string
+	}{
+		{shot, http.StatusOK, "image/png", ""},
+		{og, http.StatusOK, "image/svg+xml", "With image"},
+		{text, http.StatusOK, "image/svg+xml", "Notes &amp; &lt;thoughts&gt;"},
+		{"missing", http.StatusNotFound, "", ""},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/pages/"+tt.id+"/thumbnail", nil))
+		if rec.Code != tt.status {
+			t.Errorf("%s: status = %d, want %d", tt.id, rec.Code, tt.status)
+			continue
+		}
+		if tt.status != http.StatusOK {
+			continue
+		}
+		if got := rec.Header().Get("Content-Type"); got != tt.contentType {
+			t.Errorf("%s: Content-Type = %q, want %q", tt.id, got, tt.contentType)
+		}
+		if !strings.Contains(rec.Body.String(), tt.contains) {
+			t.Errorf("%s: thumbnail = %q, want it to contain %q", tt.id, rec.Body, tt.contains)
+		}
+		if tt.contentType == "image/png" {
+			img, err := png.Decode(rec.Body)
+			if err != nil {
+				t.Fatalf("%s: %v", tt.id, err)
+			}
+			if b := img.Bounds(); b.Dx() != thumbnailWidth || b.Dy() != thumbnailHeight {
+				t.Errorf("%s: thumbnail is %v", tt.id, b)
+			}
+			if r, _, _, _ := img.At(10, 10).RGBA(); r>>8 != 255 {
+				t.Errorf("%s: thumbnail pixel = %v, want the screenshot's", tt.id, img.At(10, 10))
+			}
+		}
+		page, _ := loadPage(tt.id)
+		if page.ThumbnailFilename == "" {
+			t.Errorf("%s: thumbnail wasn't cached", tt.id)
+		}
+	}
+
+	// A new screenshot replaces the cached thumbnail
+	withFakeBrowser(t)
+	page, _ := loadPage(text)
+	cached := filepath.Join(pagesDir, page.ThumbnailFilename)
+	if err := captureScreenshot(context.Background(), text, page.URL); err != nil {
+		t.Fatal(err)
+	}
+	if page, _ := loadPage(text); page.ThumbnailFilename != "" {
+		t.Errorf("thumbnail %q kept after a new screenshot", page.ThumbnailFilename)
+	}
+	if _, err := os.Stat(cached); !os.IsNotExist(err) {
+		t.Errorf("stale thumbnail left behind: %v", err)
+	}
+}
+
+func TestPageImageURL(t *testing.T) {
+	withPagesDir(t)
+	tests := []struct {
+		html string
+		want string
+	}{
+		{`<meta property="og:image" content="/img/cover.jpg">`, "https://example.com/img/cover.jpg"},
+		{`<meta name="twitter:image" content="https://cdn.example.com/a.png?x=1&amp;y=2">`, "https://cdn.example.com/a.png?x=1&y=2"},
+		{`<meta content="//cdn.example.com/b.png" property="og:image:url">`, "https://cdn.example.com/b.png"},
+		{`<meta property="og:image" content="data:image/png;base64,AAAA">`, ""},
+		{`<meta property="og:title" content="Title">`, ""},
+	}
+	for _, tt := range tests {
+		id, _ := storePage(PageMetadata{URL: "https://example.com/post/1", Title: "Post"}, "<html><head>"+tt.html+"</head></html>", "")
+		page, _ := loadPage(id)
+		if got := pageImageURL(page); got != tt.want {
+			t.Errorf("%s: pageImageURL() = %q, want %q", tt.html, got, tt.want)
+		}
+	}
+}
+
+func TestWrapText(t *testing.T) {
+	tests := []struct {
+		text     string
+		width    int
+		maxLines int
+		want     []string
+	}{
+		{"one two three", 20, 2, []string{"one two three"}},
+		{"one two three four", 9, 3, []string{"one two", "three", "four"}},
+		{"one two three four", 9, 2, []string{"one two", "three…"}},
+		{"supercalifragilistic", 6, 2, []string{"super…"}},
+		{"", 10, 2, nil},
+	}
+	for _, tt := range tests {
+		got := wrapText(tt.text, tt.width, tt.maxLines)
+		if strings.Join(got, "|") != strings.Join(tt.want, "|") {
+			t.Errorf("wrapText(%q, %d, %d) = %q, want %q", tt.text, tt.width, tt.maxLines, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,409 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// maxMementoImportURLs limits the URLs of one import from web archives.
+	maxMementoImportURLs = 500
+	// mementoAttempts is how many of a URL's best snapshots are tried
+	// before giving up on it, as archives also hold captured errors.
+	mementoAttempts = 3
+	// maxTimeMapBytes bounds a TimeMap, which lists every snapshot of a
+	// URL and runs to megabytes for popular pages.
+	maxTimeMapBytes = 32 << 20
+)
+
+var errNoMementos = errors.New("no archived snapshots found")
+
+// Provenance records where a page copied from another web archive came
+// from, following the Memento protocol (RFC 7089).
+type Provenance struct {
+	// Archive is the host of the archive the snapshot was copied from.
+	Archive string `json:"archive"`
+	// Memento is the snapshot's URL, and Datetime when the archive
+	// captured it.
+	Memento  string    `json:"memento"`
+	Datetime time.Time `json:"datetime"`
+	// TimeMap is the list of snapshots the memento was chosen from.
+	TimeMap    string    `json:"timeMap"`
+	ImportedAt time.Time `json:"importedAt"`
+}
+
+// memento is a snapshot of a URL listed in a TimeMap.
+type memento struct {
+	URL      string
+	Datetime time.Time
+	TimeMap  string
+}
+
+type linkValue struct {
+	URL    string
+	Params map[string]string
+}
+
+func validateMementoArchives(archives []string) error {
+	for i, archive := range archives {
+		if u, err := url.Parse(archive); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("mementoArchives[%d] must be an absolute http(s) URL", i)
+		}
+	}
+	return nil
+}
+
+// splitOutsideQuotes splits s at each sep that isn't inside double quotes.
+func splitOutsideQuotes(s string, sep byte) []string {
+	var parts []string
+	quoted := false
+	start := 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '"':
+			quoted = !quoted
+		case sep:
+			if !quoted {
+				parts = append(parts, s[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(parts, s[start:])
+}
+
+// parseLinkFormat reads the links of an application/link-format document
+// (RFC 6690), which TimeMaps are written in. Link URLs are enclosed in
+// angle brackets, so they may contain commas and semicolons; parameter
+// values, such as datetimes, may contain commas when quoted.
+func parseLinkFormat(data string) []linkValue {
+	var links []linkValue
+	for {
+		start := strings.IndexByte(data, '<')
+		if start < 0 {
+			return links
+		}
+		end := strings.IndexByte(data[start:], '>')
+		if end < 0 {
+			return links
+		}
+		link := linkValue{URL: data[start+1 : start+end], Params: make(map[string]string)}
+		data = data[start+end+1:]
+
+		// The link's parameters run to the next comma outside quotes
+		quoted := false
+		next := len(data)
+		for i := 0; i < len(data); i++ {
+			if data[i] == '"' {
+				quoted = !quoted
+			} else if data[i] == ',' && !quoted {
+				next = i
+				break
+			}
+		}
+		for _, param := range splitOutsideQuotes(data[:next], ';') {
+			name, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+			if name != "" {
+				link.Params[strings.ToLower(strings.TrimSpace(name))] = strings.Trim(strings.TrimSpace(value), `"`)
+			}
+		}
+		links = append(links, link)
+		data = data[next:]
+	}
+}
+
+// timeMapMementos returns the snapshots listed in a TimeMap, those whose
+// relation includes memento, such as "first memento".
+func timeMapMementos(data, timeMap string) []memento {
+	var mementos []memento
+	for _, link := range parseLinkFormat(data) {
+		isMemento := false
+		for _, rel := range strings.Fields(link.Params["rel"]) {
+			isMemento = isMemento || rel == "memento"
+		}
+		datetime, err := http.ParseTime(link.Params["datetime"])
+		if !isMemento || err != nil {
+			continue
+		}
+		mementos = append(mementos, memento{URL: link.URL, Datetime: datetime, TimeMap: timeMap})
+	}
+	return mementos
+}
+
+// fetchTimeMap lists an archive's snapshots of pageURL from its TimeMap,
+// found at the archive's TimeMap endpoint followed by the URL.
+func fetchTimeMap(ctx context.Context, endpoint, pageURL string) ([]memento, error) {
+	timeMap := endpoint + pageURL
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, timeMap, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", "memento/1.0 (+https://github.com/nascarsayan/memento)")
+	req.Header.Set("Accept", "application/link-format")
+	resp, err := archiveClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching %s: %s", timeMap, resp.Status)
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxTimeMapBytes))
+	if err != nil {
+		return nil, err
+	}
+	return timeMapMementos(string(data), timeMap), nil
+}
+
+// sortMementos orders snapshots best first: the closest to at, or the
+// newest when at is zero.
+func sortMementos(mementos []memento, at time.Time) {
+	if at.IsZero() {
+		sort.SliceStable(mementos, func(i, j int) bool {
+			return mementos[i].Datetime.After(mementos[j].Datetime)
+		})
+		return
+	}
+	distance := func(m memento) time.Duration {
+		if d := m.Datetime.Sub(at); d > 0 {
+			return d
+		}
+		return at.Sub(m.Datetime)
+	}
+	sort.SliceStable(mementos, func(i, j int) bool {
+		return distance(mementos[i]) < distance(mementos[j])
+	})
+}
+
+// waybackMemento matches the timestamp of a memento URL in the style of
+// the Wayback Machine and other pywb archives.
+var waybackMemento = regexp.MustCompile(`(/\d{14})(/https?:)`)
+
+// rawMementoURL asks archives that support it for a snapshot as it was
+// captured, without the archive's banner and rewritten links.
+func rawMementoURL(mementoURL string) string {
+	if loc := waybackMemento.FindStringSubmatchIndex(mementoURL); loc != nil {
+		return mementoURL[:loc[3]] + "id_" + mementoURL[loc[3]:]
+	}
+	return mementoURL
+}
+
+// storeMemento copies a snapshot of pageURL into the archive as a capture
+// of pageURL at the time the archive captured it.
+func storeMemento(ctx context.Context, pageURL string, m memento, knownHashes map[string]bool) (string, error) {
+	body, mediaType, header, err := fetchPage(ctx, rawMementoURL(m.URL))
+	if err != nil {
+		return "", err
+	}
+	u, err := url.Parse(m.URL)
+	if err != nil {
+		return "", err
+	}
+	provenance := &Provenance{Archive: u.Host, Memento: m.URL, Datetime: m.Datetime, TimeMap: m.TimeMap, ImportedAt: time.Now()}
+	if t, err := http.ParseTime(header.Get("Memento-Datetime")); err == nil {
+		provenance.Datetime = t
+	}
+
+	if mediaType == pdfMediaType {
+		id, err := archivePDF(ctx, []byte(body), pageURL, "", "")
+		if err != nil {
+			return "", err
+		}
+		indexMu.Lock()
+		defer indexMu.Unlock()
+		metadata, err := readMetadata(id)
+		if err != nil {
+			return "", err
+		}
+		metadata.Timestamp = provenance.Datetime
+		metadata.Source = "memento"
+		metadata.Provenance = provenance
+		return id, writeMetadata(id, metadata)
+	}
+
+	html := storedHTML(body)
+	hash := contentHash([]byte(html))
+	if knownHashes[hash] {
+		return "", errDuplicate
+	}
+	metadata := PageMetadata{
+		URL:        pageURL,
+		Title:      extractTitle(html),
+		Timestamp:  provenance.Datetime,
+		Source:     "memento",
+		Provenance: provenance,
+	}
+	if metadata.Title == "" {
+		metadata.Title = pageURL
+	}
+	id, err := storePage(metadata, html, "")
+	if err == nil {
+		knownHashes[hash] = true
+	}
+	return id, err
+}
+
+// importMemento copies the best snapshot of pageURL held by the configured
+// Memento archives, trying the next best when one can't be fetched.
+func importMemento(ctx context.Context, pageURL string, at time.Time, knownHashes map[string]bool) (string, error) {
+	var candidates []memento
+	for _, endpoint := range config.MementoArchives {
+		found, err := fetchTimeMap(ctx, endpoint, pageURL)
+		if err != nil {
+			log.Printf("Error listing snapshots of %s: %v", pageURL, err)
+			continue
+		}
+		candidates = append(candidates, found...)
+	}
+	if len(candidates) == 0 {
+		return "", errNoMementos
+	}
+	sortMementos(candidates, at)
+
+	var err error
+	for i, m := range candidates {
+		if i == mementoAttempts {
+			break
+		}
+		var id string
+		id, err = storeMemento(ctx, pageURL, m, knownHashes)
+		if err == nil || errors.Is(err, errDuplicate) {
+			return id, err
+		}
+		log.Printf("Error copying snapshot %s: %v", m.URL, err)
+	}
+	return "", err
+}
+
+// importMementos copies the best snapshot of each URL into the archive.
+func importMementos(ctx context.Context, urls []string, at time.Time) (importSummary, error) {
+	var summary importSummary
+	knownHashes, err := knownContentHashes()
+	if err != nil {
+		return summary, err
+	}
+	for i, pageURL := range urls {
+		if err := ctx.Err(); err != nil {
+			return summary, err
+		}
+		reportProgress(ctx, i, len(urls))
+		_, err := importMemento(ctx, pageURL, at, knownHashes)
+		switch {
+		case err == nil:
+			summary.Imported++
+		case errors.Is(err, errDuplicate):
+			summary.Duplicates++
+		default:
+			log.Printf("Error importing %s from web archives: %v", pageURL, err)
+			summary.Failed++
+		}
+	}
+	reportProgress(ctx, len(urls), len(urls))
+	return summary, nil
+}
+
+// mementoImportRequest imports pages through POST /import/memento.
+type mementoImportRequest struct {
+	URLs     []string `json:"urls"`
+	Datetime string   `json:"datetime"`
+	// Async imports the pages as a job, responding with the job at once.
+	Async bool `json:"async,omitempty"`
+}
+
+// handleMementoImport copies the best snapshots of the URLs in
+// {"urls": [...], "datetime": "..."} from public web archives, those
+// closest to datetime or else the newest, and indexes them before
+// responding, or with "async": true as a job.
+func handleMementoImport(w http.ResponseWriter, r *http.Request) {
+	if token := requestToken(r); token != nil && token.Quota.limited() {
+		writeError(w, http.StatusForbidden, ErrActionDisabled, "Imports are not available to tokens with quotas")
+		return
+	}
+	var request mementoImportRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		writeError(w, http.StatusBadRequest, ErrInvalidRequest, "Invalid request body")
+		return
+	}
+	if len(request.URLs) == 0 || len(request.URLs) > maxMementoImportURLs {
+		writeError(w, http.StatusBadRequest, ErrInvalidRequest, fmt.Sprintf("urls must list 1 to %d URLs", maxMementoImportURLs))
+		return
+	}
+	for _, pageURL := range request.URLs {
+		if u, err := url.Parse(pageURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			writeError(w, http.StatusBadRequest, ErrInvalidRequest, fmt.Sprintf("%q is not an absolute http(s) URL", pageURL))
+			return
+		}
+	}
+	var at time.Time
+	if request.Datetime != "" {
+		var err error
+		if at, err = parseAsOf(request.Datetime); err != nil {
+			writeError(w, http.StatusBadRequest, ErrInvalidRequest, "datetime must be a date (2006-01-02) or an RFC 3339 time")
+			return
+		}
+	}
+	if len(config.MementoArchives) == 0 {
+		writeError(w, http.StatusBadRequest, ErrInvalidRequest, "No web archives are configured in mementoArchives")
+		return
+	}
+	if request.Async {
+		job, err := startJob(jobImport, requestUser(r), request)
+		if err != nil {
+			log.Printf("Error starting import from web archives: %v", err)
+			writeError(w, http.StatusInternalServerError, ErrInternal, "Failed to start import")
+			return
+		}
+		writeJob(w, job)
+		return
+	}
+
+	summary, err := importMementos(r.Context(), request.URLs, at)
+	if err != nil {
+		log.Printf("Import from web archives failed: %v", err)
+		writeError(w, http.StatusInternalServerError, ErrInternal, "Import failed")
+		return
+	}
+	log.Printf("Imported %d pages from web archives (%d duplicates, %d failed)", summary.Imported, summary.Duplicates, summary.Failed)
+	indexExistingFiles(r.Context())
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(summary)
+}
+
+// runMementoImportJob imports the pages a job lists from web archives and
+// indexes them, returning the import's summary. Pages imported before the
+// daemon stopped are counted as duplicates when the job runs again.
+func runMementoImportJob(ctx context.Context, job *Job) (interface{}, error) {
+	var request mementoImportRequest
+	if err := json.Unmarshal(job.Params, &request); err != nil {
+		return nil, err
+	}
+	var at time.Time
+	if request.Datetime != "" {
+		var err error
+		if at, err = parseAsOf(request.Datetime); err != nil {
+			return nil, err
+		}
+	}
+	summary, err := importMementos(ctx, request.URLs, at)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("Imported %d pages from web archives (%d duplicates, %d failed)", summary.Imported, summary.Duplicates, summary.Failed)
+	indexExistingFiles(ctx)
+	return summary, nil
+}
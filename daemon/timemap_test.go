@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestTimeMapMementos(t *testing.T) {
+	timeMap := `<https://example.com/a,b>; rel="original",
+<https://archive.example/timemap/link/https://example.com/a,b>; rel="self"; type="application/link-format",
+<https://archive.example/web/20200102030405/https://example.com/a,b>; rel="first memento"; datetime="Thu, 02 Jan 2020 03:04:05 GMT",
+<https://archive.example/web/20210102030405/https://example.com/a,b>;rel=memento;datetime="Sat, 02 Jan 2021 03:04:05 GMT",
+<https://archive.example/web/broken/>; rel="memento"; datetime="yesterday"`
+	got := timeMapMementos(timeMap, "tm")
+	want := []memento{
+		{URL: "https://archive.example/web/20200102030405/https://example.com/a,b", Datetime: time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC), TimeMap: "tm"},
+		{URL: "https://archive.example/web/20210102030405/https://example.com/a,b", Datetime: time.Date(2021, 1, 2, 3, 4, 5, 0, time.UTC), TimeMap: "tm"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("timeMapMementos() = %+v, want %+v", got, want)
+	}
+}
+
+func TestSortMementos(t *testing.T) {
+	year := func(y int) time.Time { return time.Date(y, 6, 1, 0, 0, 0, 0, time.UTC) }
+	tests := []struct {
+		at   time.Time
+		want []int
+	}{
+		{time.Time{}, []int{2022, 2019, 2015, 2009}},
+		{year(2016), []int{2015, 2019, 2022, 2009}},
+	}
+	for _, tt := range tests {
+		mementos := []memento{{Datetime: year(2009)}, {Datetime: year(2022)}, {Datetime: year(2015)}, {Datetime: year(2019)}}
+		sortMementos(mementos, tt.at)
+		var got []int
+		for _, m := range mementos {
+			got = append(got, m.Datetime.Year())
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("sortMementos(%v) = %v, want %v", tt.at, got, tt.want)
+		}
+	}
+}
+
+func TestRawMementoURL(t *testing.T) {
+	tests := []struct {
+		url  string
+		want string
+	}{
+		{"https://web.archive.org/web/20200102030405/https://example.com/", "https://web.archive.org/web/20200102030405id_/https://example.com/"},
+		{"https://arquivo.pt/wayback/20200102030405/http://example.com/", "https://arquivo.pt/wayback/20200102030405id_/http://example.com/"},
+		{"https://archive.example/snapshots/42", "https://archive.example/snapshots/42"},
+	}
+	for _, tt := range tests {
+		if got := rawMementoURL(tt.url); got != tt.want {
+			t.Errorf("rawMementoURL(%q) = %q, want %q", tt.url, got, tt.want)
+		}
+	}
+}
+
+func TestMementoImport(t *testing.T) {
+	withPagesDir(t)
+	withIndex(t, nil)
+
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/timemap/link/https://example.com/post":
+			fmt.Fprintf(w, `<%[1]s/web/20190101000000/https://example.com/post>; rel="first memento"; datetime="Tue, 01 Jan 2019 00:00:00 GMT",
+<%[1]s/web/20230101000000/https://example.com/post>; rel="last memento"; datetime="Sun, 01 Jan 2023 00:00:00 GMT"`, server.URL)
+		case "/web/20230101000000id_/https://example.com/post":
+			http.Error(w, "archived error", http.StatusBadGateway)
+		case "/web/20190101000000id_/https://example.com/post":
+			w.Header().Set("Content-Type", "text/html")
+			w.Header().Set("Memento-Datetime", "Tue, 01 Jan 2019 00:00:05 GMT")
+			fmt.Fprint(w, "<title>Old post</title><p>As it was</p>")
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+	savedClient, savedArchives := archiveClient, config.MementoArchives
+	archiveClient = server.Client()
+	config.MementoArchives = []string{server.URL + "/timemap/link/"}
+	defer func() { archiveClient, config.MementoArchives = savedClient, savedArchives }()
+
+	post := func(body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/import/memento", bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		newRouter().ServeHTTP(rec, req)
+		return rec
+	}
+	rec := post(`{"urls": ["https://example.com/post", "https://example.com/never-archived"]}`)
+	var summary importSummary
+	json.Unmarshal(rec.Body.Bytes(), &summary)
+	if rec.Code != http.StatusOK || summary != (importSummary{Imported: 1, Failed: 1}) {
+		t.Fatalf("import = %d %s", rec.Code, rec.Body)
+	}
+
+	pages, _ := listPages()
+	if len(pages) != 1 {
+		t.Fatalf("stored %d pages, want 1", len(pages))
+	}
+	page := pages[0].PageMetadata
+	wantTime := time.Date(2019, 1, 1, 0, 0, 5, 0, time.UTC)
+	if page.URL != "https://example.com/post" || page.Title != "Old post" || !page.Timestamp.Equal(wantTime) || page.Source != "memento" {
+		t.Errorf("imported page = %+v", page)
+	}
+	if p := page.Provenance; p == nil || p.Memento != server.URL+"/web/20190101000000/https://example.com/post" ||
+		!p.Datetime.Equal(wantTime) || p.TimeMap != server.URL+"/timemap/link/https://example.com/post" || server.URL != "http://"+p.Archive {
+		t.Errorf("provenance = %+v", page.Provenance)
+	}
+
+	rec = post(`{"urls": ["https://example.com/post"]}`)
+	json.Unmarshal(rec.Body.Bytes(), &summary)
+	if summary != (importSummary{Duplicates: 1}) {
+		t.Errorf("second import = %s, want a duplicate", rec.Body)
+	}
+	for _, body := range []string{`{"urls": []}`, `{"urls": ["example.com"]}`, `{"urls": ["https://example.com/"], "datetime": "soon"}`} {
+		if rec := post(body); rec.Code != http.StatusBadRequest {
+			t.Errorf("import %s = %d, want 400", body, rec.Code)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+func tlsEnabled() bool {
+	return config.TLS.CertFile != "" && config.TLS.KeyFile != ""
+}
+
+func clientCertsEnabled() bool {
+	return tlsEnabled() && config.TLS.ClientCAFile != ""
+}
+
+// serverTLSConfig builds the listener's TLS config, verifying client
+// certificates against the configured CAs when one is set.
+func serverTLSConfig() (*tls.Config, error) {
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if !clientCertsEnabled() {
+		return tlsConfig, nil
+	}
+
+	pem, err := ioutil.ReadFile(config.TLS.ClientCAFile)
+	if err != nil {
+		return nil, fmt.Errorf("reading client CA file: %v", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates found in %s", config.TLS.ClientCAFile)
+	}
+	tlsConfig.ClientCAs = pool
+	tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+	if config.TLS.RequireClientCert {
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return tlsConfig, nil
+}
+
+func certFingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	return hex.EncodeToString(sum[:])
+}
+
+// clientCertUser identifies the user behind a verified client certificate.
+// It returns "" when the request has no verified certificate, and ok false
+// when it has one that no mapping accepts.
+func clientCertUser(r *http.Request) (user string, ok bool) {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+		return "", true
+	}
+	cert := r.TLS.VerifiedChains[0][0]
+	if len(config.TLS.ClientCerts) == 0 {
+		return cert.Subject.CommonName, cert.Subject.CommonName != ""
+	}
+
+	fingerprint := certFingerprint(cert)
+	for _, mapping := range config.TLS.ClientCerts {
+		normalized := strings.ToLower(strings.ReplaceAll(mapping.Fingerprint, ":", ""))
+		if (normalized != "" && normalized == fingerprint) ||
+			(mapping.Fingerprint == "" && mapping.CommonName != "" && mapping.CommonName == cert.Subject.CommonName) {
+			return mapping.User, mapping.User != ""
+		}
+	}
+	return "", false
+}
+
+// userToken returns the token configured for a user so certificate users
+// share its quotas, or a quota-free identity if there is none.
+func userToken(user string) *TokenConfig {
+	for i := range config.Tokens {
+		if config.Tokens[i].Name == user {
+			return &config.Tokens[i]
+		}
+	}
+	return &TokenConfig{Name: user}
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testCert issues a certificate for commonName, signed by parent or, for a
+// nil parent, by itself as a CA.
+func testCert(t *testing.T, commonName string, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage = x509.KeyUsageCertSign
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, key
+}
+
+func certRequest(cert *x509.Certificate) *http.Request {
+	r := httptest.NewRequest(http.MethodGet, "/pages", nil)
+	r.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert}}}
+	return r
+}
+
+func TestClientCertUser(t *testing.T) {
+	saved := config
+	defer func() { config = saved }()
+	ca, caKey := testCert(t, "Memento CA", nil, nil)
+	alex, _ := testCert(t, "alex", ca, caKey)
+	anonymous, _ := testCert(t, "", ca, caKey)
+
+	if user, ok := clientCertUser(httptest.NewRequest(http.MethodGet, "/", nil)); user != "" || !ok {
+		t.Errorf("without certificate: %q, %v", user, ok)
+	}
+	if user, ok := clientCertUser(certRequest(alex)); user != "alex" || !ok {
+		t.Errorf("common name: %q, %v", user, ok)
+	}
+	if _, ok := clientCertUser(certRequest(anonymous)); ok {
+		t.Error("certificate without a common name accepted")
+	}
+
+	// Fingerprints are matched however they are written
+	fingerprint := strings.ToUpper(certFingerprint(alex))
+	var colons []string
+	for i := 0; i < len(fingerprint); i += 2 {
+		colons = append(colons, fingerprint[i:i+2])
+	}
+	config.TLS.ClientCerts = []ClientCertConfig{
+		{Fingerprint: strings.Join(colons, ":"), User: "alex@example.com"},
+		{CommonName: "sam", User: "sam@example.com"},
+	}
+	sam, _ := testCert(t, "sam", ca, caKey)
+	other, _ := testCert(t, "alex", ca, caKey)
+	tests := []struct {
+		cert   *x509.Certificate
+		user   string
+		wantOK bool
+	}{
+		{alex, "alex@example.com", true},
+		{sam, "sam@example.com", true},
+		// Same name as a mapped certificate, but not the same certificate
+		{other, "", false},
+	}
+	for _, tt := range tests {
+		if user, ok := clientCertUser(certRequest(tt.cert)); user != tt.user || ok != tt.wantOK {
+			t.Errorf("%s: got %q, %v; want %q, %v", tt.cert.Subject.CommonName, user, ok, tt.user, tt.wantOK)
+		}
+	}
+}
+
+func TestUserToken(t *testing.T) {
+	withTokens(t, TokenConfig{Name: "alex", Token: NewSecret("secret"), Quota: QuotaConfig{CapturesPerDay: 5}})
+	if token := userToken("alex"); token != &config.Tokens[0] {
+		t.Errorf("userToken(alex) = %+v, want the configured token", token)
+	}
+	if token := userToken("sam"); token.Name != "sam" || !token.Token.IsZero() || token.Quota != (QuotaConfig{}) {
+		t.Errorf("userToken(sam) = %+v", token)
+	}
+}
+
+func TestClientCertAuth(t *testing.T) {
+	saved := config
+	defer func() { config = saved }()
+	ca, caKey := testCert(t, "Memento CA", nil, nil)
+	alex, alexKey := testCert(t, "alex", ca, caKey)
+	dir := t.TempDir()
+	config.TLS = TLSConfig{
+		CertFile:     "unused.pem",
+		KeyFile:      "unused.key",
+		ClientCAFile: filepath.Join(dir, "ca.pem"),
+	}
+
+	if _, err := serverTLSConfig(); err == nil {
+		t.Error("missing CA file accepted")
+	}
+	if err := os.WriteFile(config.TLS.ClientCAFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.Raw}), 0644); err != nil {
+		t.Fatal(err)
+	}
+	tlsConfig, err := serverTLSConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tlsConfig.ClientAuth != tls.VerifyClientCertIfGiven {
+		t.Errorf("ClientAuth = %v, want VerifyClientCertIfGiven", tlsConfig.ClientAuth)
+	}
+
+	var seen string
+	server := httptest.NewUnstartedServer(requireAuth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = requestToken(r).Name
+	})))
+	server.TLS = tlsConfig
+	server.StartTLS()
+	defer server.Close()
+
+	client := server.Client()
+	transport := client.Transport.(*http.Transport)
+	resp, err := client.Get(server.URL + "/pages")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("without certificate: status = %d, want 401", resp.StatusCode)
+	}
+
+	transport.TLSClientConfig.Certificates = []tls.Certificate{{Certificate: [][]byte{alex.Raw}, PrivateKey: alexKey}}
+	transport.CloseIdleConnections()
+	resp, err = client.Get(server.URL + "/pages")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || seen != "alex" {
+		t.Errorf("with certificate: status = %d, user %q", resp.StatusCode, seen)
+	}
+
+	config.TLS.RequireClientCert = true
+	if tlsConfig, err := serverTLSConfig(); err != nil || tlsConfig.ClientAuth != tls.RequireAndVerifyClientCert {
+		t.Errorf("RequireClientCert: %v, %v", tlsConfig, err)
+	}
+}
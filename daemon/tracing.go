@@ -0,0 +1,307 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	spanQueueSize     = 2048
+	spanBatchSize     = 512
+	spanFlushInterval = 5 * time.Second
+)
+
+// OTLP span kinds and status codes
+const (
+	spanKindInternal = 1
+	spanKindServer   = 2
+	spanStatusError  = 2
+)
+
+// Span times one step of a capture or search and is exported to an
+// OpenTelemetry collector over OTLP/HTTP. A nil *Span, as returned when
+// tracing is off, ignores every call.
+type Span struct {
+	traceID  [16]byte
+	spanID   [8]byte
+	parentID [8]byte
+	name     string
+	kind     int
+	start    time.Time
+
+	mu         sync.Mutex
+	attributes map[string]interface{}
+	err        error
+}
+
+type spanContextKey struct{}
+
+// spanExporter batches finished spans and posts them to the collector.
+type spanExporter struct {
+	url     string
+	service string
+	headers map[string]Secret
+	client  *http.Client
+	spans   chan otlpSpan
+}
+
+// tracer is nil unless config.Tracing.Endpoint is set.
+var tracer *spanExporter
+
+// setupTracing starts exporting spans when a collector is configured.
+func setupTracing() {
+	if config.Tracing.Endpoint == "" {
+		return
+	}
+	tracer = newSpanExporter(config.Tracing)
+	go tracer.run()
+	log.Printf("Exporting traces to %s", tracer.url)
+}
+
+func newSpanExporter(c TracingConfig) *spanExporter {
+	url := strings.TrimSuffix(c.Endpoint, "/")
+	if !strings.HasSuffix(url, "/v1/traces") {
+		url += "/v1/traces"
+	}
+	service := c.ServiceName
+	if service == "" {
+		service = "memento"
+	}
+	return &spanExporter{
+		url:     url,
+		service: service,
+		headers: c.Headers,
+		client:  &http.Client{Timeout: 10 * time.Second},
+		spans:   make(chan otlpSpan, spanQueueSize),
+	}
+}
+
+// startSpan starts a span as a child of the one in ctx, or of nothing.
+func startSpan(ctx context.Context, name string) (context.Context, *Span) {
+	if tracer == nil {
+		return ctx, nil
+	}
+	span := &Span{name: name, kind: spanKindInternal, start: time.Now()}
+	if parent, ok := ctx.Value(spanContextKey{}).(*Span); ok && parent != nil {
+		span.traceID, span.parentID = parent.traceID, parent.spanID
+	} else {
+		rand.Read(span.traceID[:])
+	}
+	rand.Read(span.spanID[:])
+	return context.WithValue(ctx, spanContextKey{}, span), span
+}
+
+// startRequestSpan starts the server span for an API request, continuing
+// the caller's trace if it sent a W3C traceparent header.
+func startRequestSpan(r *http.Request, name string) (context.Context, *Span) {
+	ctx := r.Context()
+	if tracer == nil {
+		return ctx, nil
+	}
+	if traceID, parentID, ok := parseTraceparent(r.Header.Get("traceparent")); ok {
+		ctx = context.WithValue(ctx, spanContextKey{}, &Span{traceID: traceID, spanID: parentID})
+	}
+	ctx, span := startSpan(ctx, name)
+	span.kind = spanKindServer
+	span.SetAttribute("http.request.method", r.Method)
+	span.SetAttribute("url.path", r.URL.Path)
+	return ctx, span
+}
+
+// parseTraceparent reads a version 00 traceparent header:
+// 00-<trace ID>-<parent span ID>-<flags>.
+func parseTraceparent(header string) (traceID [16]byte, spanID [8]byte, ok bool) {
+	parts := strings.Split(strings.TrimSpace(header), "-")
+	if len(parts) != 4 || parts[0] != "00" || len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return traceID, spanID, false
+	}
+	if _, err := hex.Decode(traceID[:], []byte(parts[1])); err != nil {
+		return traceID, spanID, false
+	}
+	if _, err := hex.Decode(spanID[:], []byte(parts[2])); err != nil {
+		return traceID, spanID, false
+	}
+	if traceID == [16]byte{} || spanID == [8]byte{} {
+		return traceID, spanID, false
+	}
+	return traceID, spanID, true
+}
+
+// SetAttribute records a string, bool, integer or float value on the span.
+func (s *Span) SetAttribute(key string, value interface{}) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.attributes == nil {
+		s.attributes = make(map[string]interface{})
+	}
+	s.attributes[key] = value
+}
+
+// RecordError marks the span as failed. Nil errors are ignored.
+func (s *Span) RecordError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.mu.Lock()
+	s.err = err
+	s.mu.Unlock()
+}
+
+// End finishes the span and queues it for export, dropping it if the
+// collector has fallen too far behind.
+func (s *Span) End() {
+	if s == nil || tracer == nil {
+		return
+	}
+	select {
+	case tracer.spans <- s.otlp(time.Now()):
+	default:
+	}
+}
+
+// otlp converts the span to its OTLP/JSON form.
+func (s *Span) otlp(end time.Time) otlpSpan {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	span := otlpSpan{
+		TraceID:           hex.EncodeToString(s.traceID[:]),
+		SpanID:            hex.EncodeToString(s.spanID[:]),
+		Name:              s.name,
+		Kind:              s.kind,
+		StartTimeUnixNano: strconv.FormatInt(s.start.UnixNano(), 10),
+		EndTimeUnixNano:   strconv.FormatInt(end.UnixNano(), 10),
+	}
+	if s.parentID != [8]byte{} {
+		span.ParentSpanID = hex.EncodeToString(s.parentID[:])
+	}
+	keys := make([]string, 0, len(s.attributes))
+	for key := range s.attributes {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		span.Attributes = append(span.Attributes, otlpAttribute(key, s.attributes[key]))
+	}
+	if s.err != nil {
+		span.Status = &otlpStatus{Code: spanStatusError, Message: s.err.Error()}
+	}
+	return span
+}
+
+type otlpSpan struct {
+	TraceID           string         `json:"traceId"`
+	SpanID            string         `json:"spanId"`
+	ParentSpanID      string         `json:"parentSpanId,omitempty"`
+	Name              string         `json:"name"`
+	Kind              int            `json:"kind"`
+	StartTimeUnixNano string         `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+	Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+	Status            *otlpStatus    `json:"status,omitempty"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+type otlpKeyValue struct {
+	Key   string                 `json:"key"`
+	Value map[string]interface{} `json:"value"`
+}
+
+// otlpAttribute wraps a value in the OTLP AnyValue field for its type.
+// 64-bit integers are strings in OTLP/JSON.
+func otlpAttribute(key string, value interface{}) otlpKeyValue {
+	switch v := value.(type) {
+	case bool:
+		return otlpKeyValue{key, map[string]interface{}{"boolValue": v}}
+	case int:
+		return otlpKeyValue{key, map[string]interface{}{"intValue": strconv.Itoa(v)}}
+	case int64:
+		return otlpKeyValue{key, map[string]interface{}{"intValue": strconv.FormatInt(v, 10)}}
+	case float64:
+		return otlpKeyValue{key, map[string]interface{}{"doubleValue": v}}
+	default:
+		return otlpKeyValue{key, map[string]interface{}{"stringValue": fmt.Sprint(v)}}
+	}
+}
+
+// run exports spans in batches, whenever a batch fills up or every few
+// seconds otherwise.
+func (e *spanExporter) run() {
+	ticker := time.NewTicker(spanFlushInterval)
+	defer ticker.Stop()
+	var batch []otlpSpan
+	for {
+		select {
+		case span := <-e.spans:
+			batch = append(batch, span)
+			if len(batch) < spanBatchSize {
+				continue
+			}
+		case <-ticker.C:
+			if len(batch) == 0 {
+				continue
+			}
+		}
+		if err := e.export(batch); err != nil {
+			log.Printf("Error exporting %d spans: %v", len(batch), err)
+		}
+		batch = nil
+	}
+}
+
+// export posts spans to the collector as an OTLP/JSON trace request.
+func (e *spanExporter) export(spans []otlpSpan) error {
+	payload := map[string]interface{}{
+		"resourceSpans": []interface{}{map[string]interface{}{
+			"resource": map[string]interface{}{
+				"attributes": []otlpKeyValue{otlpAttribute("service.name", e.service)},
+			},
+			"scopeSpans": []interface{}{map[string]interface{}{
+				"scope": map[string]string{"name": "memento"},
+				"spans": spans,
+			}},
+		}},
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, e.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for name, secret := range e.headers {
+		value, err := secret.Value()
+		if err != nil {
+			return fmt.Errorf("reading header %s: %v", name, err)
+		}
+		req.Header.Set(name, value)
+	}
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("collector returned %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParseTraceparent(t *testing.T) {
+	tests := []struct {
+		header string
+		ok     bool
+	}{
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", true},
+		{" 00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00 ", true},
+		{"", false},
+		{"01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", false},
+		{"00-4bf92f3577b34da6a3ce929d0e0e473-00f067aa0ba902b7-01", false},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b-01", false},
+		{"00-zzf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", false},
+		{"00-00000000000000000000000000000000-00f067aa0ba902b7-01", false},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01", false},
+	}
+	for _, tt := range tests {
+		if _, _, ok := parseTraceparent(tt.header); ok != tt.ok {
+			t.Errorf("parseTraceparent(%q) ok = %v, want %v", tt.header, ok, tt.ok)
+		}
+	}
+}
+
+func TestSpansDisabled(t *testing.T) {
+	ctx, span := startSpan(context.Background(), "off")
+	if span != nil || ctx != context.Background() {
+		t.Fatalf("startSpan without a tracer = %v, want nil", span)
+	}
+	// A nil span ignores everything
+	span.SetAttribute("key", "value")
+	span.RecordError(errors.New("ignored"))
+	span.End()
+}
+
+func TestSpanExport(t *testing.T) {
+	var got struct {
+		ResourceSpans []struct {
+			Resource struct {
+				Attributes []otlpKeyValue `json:"attributes"`
+			} `json:"resource"`
+			ScopeSpans []struct {
+				Spans []otlpSpan `json:"spans"`
+			} `json:"scopeSpans"`
+		} `json:"resourceSpans"`
+	}
+	var path, apiKey string
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path, apiKey = r.URL.Path, r.Header.Get("X-Api-Key")
+		body, _ := ioutil.ReadAll(r.Body)
+		if err := json.Unmarshal(body, &got); err != nil {
+			t.Errorf("collector got invalid JSON: %v", err)
+		}
+	}))
+	defer collector.Close()
+
+	saved := tracer
+	tracer = newSpanExporter(TracingConfig{
+		Endpoint: collector.URL + "/",
+		Headers:  map[string]Secret{"X-Api-Key": NewSecret("key")},
+	})
+	defer func() { tracer = saved }()
+
+	r := httptest.NewRequest(http.MethodGet, "/search?q=go", nil)
+	r.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	ctx, parent := startRequestSpan(r, "search")
+	_, child := startSpan(ctx, "search.query")
+	child.SetAttribute("search.total_hits", int64(3))
+	child.RecordError(errors.New("index closed"))
+	child.End()
+	parent.End()
+
+	spans := []otlpSpan{<-tracer.spans, <-tracer.spans}
+	if err := tracer.export(spans); err != nil {
+		t.Fatal(err)
+	}
+	if path != "/v1/traces" || apiKey != "key" {
+		t.Errorf("export went to %q with key %q", path, apiKey)
+	}
+	if len(got.ResourceSpans) != 1 || len(got.ResourceSpans[0].ScopeSpans) != 1 {
+		t.Fatalf("unexpected payload shape: %+v", got)
+	}
+	if service := got.ResourceSpans[0].Resource.Attributes[0]; service.Key != "service.name" || service.Value["stringValue"] != "memento" {
+		t.Errorf("service attribute = %+v", service)
+	}
+	exported := got.ResourceSpans[0].ScopeSpans[0].Spans
+	if len(exported) != 2 {
+		t.Fatalf("exported %d spans, want 2", len(exported))
+	}
+	query, search := exported[0], exported[1]
+	if search.TraceID != "4bf92f3577b34da6a3ce929d0e0e4736" || search.ParentSpanID != "00f067aa0ba902b7" || search.Kind != spanKindServer {
+		t.Errorf("request span didn't continue the caller's trace: %+v", search)
+	}
+	if query.TraceID != search.TraceID || query.ParentSpanID != search.SpanID || query.Kind != spanKindInternal {
+		t.Errorf("child span isn't linked to its parent: %+v", query)
+	}
+	if query.Status == nil || query.Status.Code != spanStatusError || query.Status.Message != "index closed" {
+		t.Errorf("child status = %+v", query.Status)
+	}
+	if len(query.Attributes) != 1 || query.Attributes[0].Value["intValue"] != "3" {
+		t.Errorf("child attributes = %+v", query.Attributes)
+	}
+}
+
+func TestSpanExportFailure(t *testing.T) {
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer collector.Close()
+
+	exporter := newSpanExporter(TracingConfig{Endpoint: collector.URL + "/v1/traces"})
+	if err := exporter.export([]otlpSpan{{Name: "x"}}); err == nil {
+		t.Error("export to a failing collector succeeded")
+	}
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// trashDir holds deleted pages until they are restored or purged: their
+// files and assets as they were in the pages directory, and their metadata
+// with when they were deleted.
+const trashDir = "memento_trash"
+
+// trashPurgeInterval is how often pages trashed more than trash.days ago
+// are purged.
+const trashPurgeInterval = time.Hour
+
+// TrashConfig keeps deleted pages in the trash for Days before they are
+// deleted for good.
+type TrashConfig struct {
+	Days int `json:"days"`
+}
+
+// TrashedPage is a deleted page, with when it was deleted and when it will
+// be purged.
+type TrashedPage struct {
+	Page
+	DeletedAt time.Time `json:"deletedAt"`
+	PurgeAt   time.Time `json:"purgeAt"`
+}
+
+// errPageExists is returned when restoring a page whose ID has been taken
+// by another page since it was deleted.
+var errPageExists = errors.New("a page with this ID already exists")
+
+// trashEntryPath is where the metadata of a trashed page is kept.
+func trashEntryPath(id string) string {
+	return filepath.Join(trashDir, id+".json")
+}
+
+// trashed reports whether a page with the ID is in the trash.
+func trashed(id string) bool {
+	_, err := os.Stat(trashEntryPath(id))
+	return err == nil
+}
+
+// trashEnabled reports whether deleted pages go to the trash rather than
+// being deleted for good.
+func trashEnabled() bool {
+	return featureEnabled("trash")
+}
+
+// trashPage deletes a page the way users do: its document leaves the index
+// and its files and assets move to the trash, from which it can be restored
+// until it is purged. Without the trash it is deleted for good.
+func trashPage(page Page) error {
+	if !trashEnabled() {
+		return deletePage(page)
+	}
+	if err := os.MkdirAll(trashDir, 0755); err != nil {
+		return err
+	}
+	if err := index.Delete(page.ID); err != nil {
+		return err
+	}
+	if err := movePageFiles(page.PageMetadata, pagesDir, trashDir, page.ID); err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	entry := TrashedPage{Page: page, DeletedAt: now, PurgeAt: now.AddDate(0, 0, config.Trash.Days)}
+	data, err := json.MarshalIndent(entry, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(trashEntryPath(page.ID), data, 0644); err != nil {
+		return err
+	}
+	if err := os.Remove(metadataPath(page.ID)); err != nil {
+		return err
+	}
+	recordChange(changeDelete, page.ID, page.PageMetadata, nil)
+	publishPageEvent(eventPageDeleted, page.ID, page.PageMetadata)
+	return nil
+}
+
+// movePageFiles moves a page's files and assets directory from one
+// directory to another, skipping any that are missing.
+func movePageFiles(metadata PageMetadata, from, to, id string) error {
+	for _, name := range metadata.files() {
+		if err := os.Rename(filepath.Join(from, name), filepath.Join(to, name)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	err := os.Rename(filepath.Join(from, id+"_files"), filepath.Join(to, id+"_files"))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// readTrashedPage reads a trashed page by ID, rejecting IDs that could
+// escape the trash.
+func readTrashedPage(id string) (TrashedPage, error) {
+	var entry TrashedPage
+	if !validPageID.MatchString(id) {
+		return entry, os.ErrNotExist
+	}
+	data, err := ioutil.ReadFile(trashEntryPath(id))
+	if err != nil {
+		return entry, err
+	}
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return entry, err
+	}
+	entry.ID = id
+	entry.PurgeAt = entry.DeletedAt.AddDate(0, 0, config.Trash.Days)
+	return entry, nil
+}
+
+// listTrash returns the pages in the trash, most recently deleted first,
+// skipping unreadable entries.
+func listTrash() ([]TrashedPage, error) {
+	files, err := ioutil.ReadDir(trashDir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var pages []TrashedPage
+	for _, file := range files {
+		if file.IsDir() || !strings.HasSuffix(file.Name(), ".json") {
+			continue
+		}
+		entry, err := readTrashedPage(strings.TrimSuffix(file.Name(), ".json"))
+		if err != nil {
+			log.Printf("Error reading trashed page %s: %v", file.Name(), err)
+			continue
+		}
+		pages = append(pages, entry)
+	}
+	sort.Slice(pages, func(i, j int) bool { return pages[i].DeletedAt.After(pages[j].DeletedAt) })
+	return pages, nil
+}
+
+// restorePage moves a trashed page back into the pages directory to be
+// indexed again. The changelog records it as added.
+func restorePage(entry TrashedPage) (Page, error) {
+	if _, err := os.Stat(metadataPath(entry.ID)); err == nil {
+		return Page{}, errPageExists
+	}
+	if err := movePageFiles(entry.PageMetadata, trashDir, pagesDir, entry.ID); err != nil {
+		return Page{}, err
+	}
+	entry.Indexed = false
+	if err := writeMetadata(entry.ID, entry.PageMetadata); err != nil {
+		return Page{}, err
+	}
+	if err := os.Remove(trashEntryPath(entry.ID)); err != nil {
+		return Page{}, err
+	}
+	return entry.Page, nil
+}
+
+// purgeTrash deletes for good the pages trashed more than trash.days before
+// now, returning how many it deleted.
+func purgeTrash(now time.Time) (int, error) {
+	pages, err := listTrash()
+	if err != nil {
+		return 0, err
+	}
+	purged := 0
+	for _, entry := range pages {
+		if now.Before(entry.PurgeAt) {
+			continue
+		}
+		for _, name := range entry.files() {
+			if err := os.Remove(filepath.Join(trashDir, name)); err != nil && !os.IsNotExist(err) {
+				return purged, err
+			}
+		}
+		if err := os.RemoveAll(filepath.Join(trashDir, entry.ID+"_files")); err != nil {
+			return purged, err
+		}
+		if err := os.Remove(trashEntryPath(entry.ID)); err != nil {
+			return purged, err
+		}
+		purged++
+	}
+	return purged, nil
+}
+
+// watchTrash purges pages that have been in the trash for trash.days.
+func watchTrash() {
+	for {
+		if purged, err := purgeTrash(time.Now()); err != nil {
+			log.Printf("Error purging the trash: %v", err)
+		} else if purged > 0 {
+			log.Printf("Purged %d pages from the trash", purged)
+		}
+		time.Sleep(trashPurgeInterval)
+	}
+}
+
+// handleTrash lists the deleted pages a request may see.
+func handleTrash(w http.ResponseWriter, r *http.Request) {
+	pages, err := listTrash()
+	if err != nil {
+		log.Printf("Error listing the trash: %v", err)
+		writeError(w, http.StatusInternalServerError, ErrInternal, "Failed to list the trash")
+		return
+	}
+	visible := []TrashedPage{}
+	for _, page := range pages {
+		if pageVisible(r, page.PageMetadata) {
+			visible = append(visible, page)
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(visible)
+}
+
+// userTrashedPage wraps a handler of a /trash/{id} route so that pages
+// other users deleted are reported as not found.
+func userTrashedPage(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if usersEnabled() {
+			if entry, err := readTrashedPage(r.PathValue("id")); err == nil && !pageVisible(r, entry.PageMetadata) {
+				writeError(w, http.StatusNotFound, ErrNotFound, "Page not found in the trash")
+				return
+			}
+		}
+		next(w, r)
+	}
+}
+
+// handleTrashRestore restores a deleted page and indexes it again.
+func handleTrashRestore(w http.ResponseWriter, r *http.Request) {
+	entry, err := readTrashedPage(r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusNotFound, ErrNotFound, "Page not found in the trash")
+		return
+	}
+	page, err := restorePage(entry)
+	if errors.Is(err, errPageExists) {
+		writeError(w, http.StatusConflict, ErrDuplicate, err.Error())
+		return
+	}
+	if err != nil {
+		log.Printf("Error restoring page %s: %v", entry.ID, err)
+		writeError(w, http.StatusInternalServerError, ErrInternal, "Failed to restore page")
+		return
+	}
+	indexExistingFiles(r.Context())
+	if restored, err := loadPage(page.ID); err == nil {
+		page = restored
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(page)
+}
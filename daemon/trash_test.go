@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestTrashAndRestore(t *testing.T) {
+	withPagesDir(t)
+	withIndex(t, nil)
+	id, err := storePage(PageMetadata{URL: "https://example.com/recipe", Title: "Recipe"}, "<p>Braised leeks</p>", "Braised leeks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	indexExistingFiles(context.Background())
+	page, _ := loadPage(id)
+
+	router := newRouter()
+	do := func(method, path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(method, path, nil))
+		return rec
+	}
+	if rec := do(http.MethodDelete, "/pages/"+id); rec.Code != http.StatusNoContent {
+		t.Fatalf("DELETE = %d: %s", rec.Code, rec.Body)
+	}
+	if _, err := loadPage(id); err == nil {
+		t.Error("deleted page is still listed")
+	}
+	if got := searchURLs(t, "leeks", SearchOptions{}); len(got) != 0 {
+		t.Errorf("deleted page found: %v", got)
+	}
+	if _, err := os.Stat(filepath.Join(trashDir, page.MDFilename)); err != nil {
+		t.Errorf("markdown not in the trash: %v", err)
+	}
+
+	rec := do(http.MethodGet, "/trash")
+	var trashed []TrashedPage
+	json.Unmarshal(rec.Body.Bytes(), &trashed)
+	if len(trashed) != 1 || trashed[0].ID != id || trashed[0].PurgeAt.Sub(trashed[0].DeletedAt) != 30*24*time.Hour {
+		t.Fatalf("trash = %s", rec.Body)
+	}
+
+	if rec := do(http.MethodPost, "/trash/"+id+"/restore"); rec.Code != http.StatusOK {
+		t.Fatalf("restore = %d: %s", rec.Code, rec.Body)
+	}
+	if restored, err := loadPage(id); err != nil || !restored.Indexed {
+		t.Errorf("restored page = %+v, %v", restored, err)
+	}
+	if got := searchURLs(t, "leeks", SearchOptions{}); len(got) != 1 {
+		t.Errorf("restored page not found: %v", got)
+	}
+	if rec := do(http.MethodPost, "/trash/"+id+"/restore"); rec.Code != http.StatusNotFound {
+		t.Errorf("second restore = %d", rec.Code)
+	}
+}
+
+func TestPurgeTrash(t *testing.T) {
+	withPagesDir(t)
+	withIndex(t, nil)
+	id, err := storePage(PageMetadata{URL: "https://example.com/old"}, "<p>Old</p>", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	page, _ := loadPage(id)
+	if err := trashPage(page); err != nil {
+		t.Fatal(err)
+	}
+
+	if purged, err := purgeTrash(time.Now()); err != nil || purged != 0 {
+		t.Errorf("purge of a fresh trash = %d, %v", purged, err)
+	}
+	if purged, err := purgeTrash(time.Now().AddDate(0, 0, config.Trash.Days+1)); err != nil || purged != 1 {
+		t.Errorf("purge after the trash period = %d, %v", purged, err)
+	}
+	if trashed(id) {
+		t.Error("purged page is still in the trash")
+	}
+	if _, err := os.Stat(filepath.Join(trashDir, page.HTMLFilename)); !os.IsNotExist(err) {
+		t.Errorf("purged HTML still stored: %v", err)
+	}
+}
+
+func TestTrashIsPerUser(t *testing.T) {
+	withPagesDir(t)
+	withIndex(t, nil)
+	withUsers(t, "admin", "alice", "bob")
+	id, err := storePage(PageMetadata{URL: "https://example.com/alice", Title: "Alice's", Owner: "alice"}, "<p>Alice</p>", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	page, _ := loadPage(id)
+	if err := trashPage(page); err != nil {
+		t.Fatal(err)
+	}
+
+	router := newRouter()
+	do := func(user, method, path string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, path, nil)
+		r.Header.Set("Authorization", "Bearer "+user+"-token")
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, r)
+		return rec
+	}
+	var listed []TrashedPage
+	json.Unmarshal(do("bob", http.MethodGet, "/trash").Body.Bytes(), &listed)
+	if len(listed) != 0 {
+		t.Errorf("bob's trash = %+v", listed)
+	}
+	if rec := do("bob", http.MethodPost, "/trash/"+id+"/restore"); rec.Code != http.StatusNotFound {
+		t.Errorf("bob restoring alice's page = %d: %s", rec.Code, rec.Body)
+	}
+	if !trashed(id) {
+		t.Fatal("page left the trash")
+	}
+	if rec := do("alice", http.MethodPost, "/trash/"+id+"/restore"); rec.Code != http.StatusOK {
+		t.Errorf("alice restoring her page = %d: %s", rec.Code, rec.Body)
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+)
+
+// usersEnabled reports whether each user only sees their own pages.
+func usersEnabled() bool {
+	return config.Users.Enabled
+}
+
+// requestUser returns who a request acts for: the name of its token or
+// client certificate user, or the email of its login.
+func requestUser(r *http.Request) string {
+	if token := requestToken(r); token != nil {
+		return token.Name
+	}
+	if session := requestSession(r); session != nil {
+		return session.Email
+	}
+	return ""
+}
+
+// isAdmin reports whether a request may act on the whole archive, as every
+// request may unless users are enabled.
+func isAdmin(r *http.Request) bool {
+	if !usersEnabled() {
+		return true
+	}
+	user := requestUser(r)
+	for _, admin := range config.Users.Admins {
+		if user != "" && strings.EqualFold(admin, user) {
+			return true
+		}
+	}
+	return false
+}
+
+// pageVisible reports whether a request may see a page: its own pages, or
+// any page for admins.
+func pageVisible(r *http.Request, metadata PageMetadata) bool {
+	if isAdmin(r) {
+		return true
+	}
+	return metadata.Owner != "" && metadata.Owner == requestUser(r)
+}
+
+// ownerKey is the index term pages of an owner are found by. Owners are
+// hashed so that names and emails survive the analyzer as a single term.
+func ownerKey(owner string) string {
+	if owner == "" {
+		return ""
+	}
+	return "u" + contentHash([]byte(owner))[:32]
+}
+
+// userPage wraps a handler of a /pages/{id} route so that pages other users
+// own are reported as not found.
+func userPage(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if usersEnabled() {
+			if page, err := loadPage(r.PathValue("id")); err == nil && !pageVisible(r, page.PageMetadata) {
+				writeError(w, http.StatusNotFound, ErrNotFound, "Page not found")
+				return
+			}
+		}
+		next(w, r)
+	}
+}
+
+// adminOnly wraps a handler that acts on the whole archive, such as exports
+// and maintenance, refusing users who aren't admins.
+func adminOnly(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !isAdmin(r) {
+			writeError(w, http.StatusForbidden, ErrForbidden, "Only admins may act on the whole archive")
+			return
+		}
+		next(w, r)
+	}
+}
+
+// assignOwner gives pages to owner, the given ones or, with none given,
+// every page without an owner, returning how many changed. They are
+// indexed again so that searches find them under their new owner.
+func assignOwner(owner string, ids []string) (int, error) {
+	indexMu.Lock()
+	defer indexMu.Unlock()
+	if len(ids) == 0 {
+		pages, err := listPages()
+		if err != nil {
+			return 0, err
+		}
+		for _, page := range pages {
+			if page.Owner == "" {
+				ids = append(ids, page.ID)
+			}
+		}
+	}
+	count := 0
+	for _, id := range ids {
+		page, err := loadPage(id)
+		if err != nil {
+			return count, err
+		}
+		metadata := page.PageMetadata
+		if metadata.Owner == owner {
+			continue
+		}
+		metadata.Owner = owner
+		metadata.Indexed = false
+		if err := writeMetadata(id, metadata); err != nil {
+			return count, err
+		}
+		count++
+	}
+	return count, nil
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// withUsers enables users with a token per name, the first an admin.
+func withUsers(t *testing.T, names ...string) {
+	t.Helper()
+	saved := config
+	t.Cleanup(func() { config = saved })
+	config.Tokens = nil
+	for _, name := range names {
+		config.Tokens = append(config.Tokens, TokenConfig{Name: name, Token: NewSecret(name + "-token")})
+	}
+	config.Users = UsersConfig{Enabled: true, Admins: names[:1]}
+}
+
+func TestSearchOwnPages(t *testing.T) {
+	now := time.Now()
+	withIndex(t, map[string]PageDocument{
+		"a": {URL: "https://a.example", Content: "sourdough starter", Time: now, OwnerKey: ownerKey("alice")},
+		"b": {URL: "https://b.example", Content: "sourdough loaf", Time: now, OwnerKey: ownerKey("bob")},
+		"n": {URL: "https://n.example", Content: "sourdough notes", Time: now},
+	})
+	saved := config.Users
+	defer func() { config.Users = saved }()
+
+	tests := []struct {
+		name    string
+		enabled bool
+		owner   string
+		want    int
+	}{
+		{"users disabled", false, "", 3},
+		{"alice", true, "alice", 1},
+		{"bob", true, "bob", 1},
+		{"nobody", true, "", 0},
+	}
+	for _, tt := range tests {
+		config.Users.Enabled = tt.enabled
+		if got := searchURLs(t, "sourdough", SearchOptions{Owner: tt.owner}); len(got) != tt.want {
+			t.Errorf("%s: got %v, want %d results", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestUserIsolation(t *testing.T) {
+	withPagesDir(t)
+	withIndex(t, nil)
+	withUsers(t, "admin", "alice", "bob")
+	alices, _ := storePage(PageMetadata{URL: "https://example.com/alice", Title: "Alice's", Owner: "alice"}, "<p>Alice</p>", "")
+	bobs, _ := storePage(PageMetadata{URL: "https://example.com/bob", Title: "Bob's", Owner: "bob"}, "<p>Bob</p>", "")
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/pages", handlePages)
+	mux.HandleFunc("/pages/{id}", userPage(handlePage))
+	mux.HandleFunc("/export", adminOnly(handleExport))
+	mux.HandleFunc("/saved-searches", handleSavedSearches)
+	mux.HandleFunc("/saved-searches/{id}", userSavedSearch(handleSavedSearch))
+	handler := requireAuth(mux)
+	do := func(user, method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+user+"-token")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	tests := []struct {
+		name   string
+		user   string
+		method string
+		path   string
+		want   int
+	}{
+		{"own page", "alice", http.MethodGet, "/pages/" + alices, http.StatusOK},
+		{"another's page", "alice", http.MethodGet, "/pages/" + bobs, http.StatusNotFound},
+		{"deleting another's page", "bob", http.MethodDelete, "/pages/" + alices, http.StatusNotFound},
+		{"admin opens any page", "admin", http.MethodGet, "/pages/" + bobs, http.StatusOK},
+		{"export", "alice", http.MethodGet, "/export", http.StatusForbidden},
+		{"admin export", "admin", http.MethodGet, "/export", http.StatusOK},
+	}
+	for _, tt := range tests {
+		if rec := do(tt.user, tt.method, tt.path, ""); rec.Code != tt.want {
+			t.Errorf("%s: status = %d, want %d: %s", tt.name, rec.Code, tt.want, rec.Body)
+		}
+	}
+
+	var list pageList
+	json.Unmarshal(do("bob", http.MethodGet, "/pages", "").Body.Bytes(), &list)
+	if list.Total != 1 || list.Pages[0].ID != bobs {
+		t.Errorf("bob's pages = %+v", list)
+	}
+
+	rec := do("alice", http.MethodPost, "/saved-searches", `{"name": "Bread", "query": "bread"}`)
+	var search SavedSearch
+	json.Unmarshal(rec.Body.Bytes(), &search)
+	if rec.Code != http.StatusCreated || search.Owner != "alice" {
+		t.Fatalf("saving a search = %d %s", rec.Code, rec.Body)
+	}
+	if rec := do("bob", http.MethodPost, "/saved-searches", `{"name": "Bread", "query": "bread"}`); rec.Code != http.StatusCreated {
+		t.Errorf("names are unique per user: status = %d %s", rec.Code, rec.Body)
+	}
+	if rec := do("bob", http.MethodGet, "/saved-searches/"+search.ID, ""); rec.Code != http.StatusNotFound {
+		t.Errorf("bob reading alice's saved search: status = %d", rec.Code)
+	}
+	var searches []SavedSearch
+	json.Unmarshal(do("bob", http.MethodGet, "/saved-searches", "").Body.Bytes(), &searches)
+	if len(searches) != 1 || searches[0].Owner != "bob" {
+		t.Errorf("bob's saved searches = %+v", searches)
+	}
+}
+
+func TestAssignOwner(t *testing.T) {
+	withPagesDir(t)
+	withIndex(t, nil)
+	withUsers(t, "admin", "alice")
+	config.Users.DefaultOwner = "admin"
+	dropped, _ := storePage(PageMetadata{URL: "https://example.com/dropped"}, "<p>Dropped in</p>", "")
+	unowned, _ := storePage(PageMetadata{URL: "https://example.com/unowned"}, "<p>Unowned</p>", "")
+	owned, _ := storePage(PageMetadata{URL: "https://example.com/owned", Owner: "alice"}, "<p>Owned</p>", "")
+
+	if count, err := assignOwner("alice", []string{unowned}); err != nil || count != 1 {
+		t.Fatalf("assignOwner() = %d, %v", count, err)
+	}
+	indexExistingFiles(context.Background())
+	for id, want := range map[string]string{dropped: "admin", unowned: "alice", owned: "alice"} {
+		if page, _ := loadPage(id); page.Owner != want || !page.Indexed {
+			t.Errorf("%s owned by %q (indexed %v), want %q", id, page.Owner, page.Indexed, want)
+		}
+	}
+	if got := searchURLs(t, "unowned OR owned OR dropped", SearchOptions{Owner: "alice"}); len(got) != 2 {
+		t.Errorf("alice's search = %v", got)
+	}
+	if count, err := assignOwner("alice", nil); err != nil || count != 0 {
+		t.Errorf("assignOwner() with every page owned = %d, %v", count, err)
+	}
+}
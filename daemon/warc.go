@@ -0,0 +1,305 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base32"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/textproto"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const warcVersion = "WARC/1.1"
+
+var titleRe = regexp.MustCompile(`(?is)<title[^>]*>(.*?)</title>`)
+
+type warcRecord struct {
+	Header textproto.MIMEHeader
+	Block  []byte
+}
+
+func newWARCRecordID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("<urn:uuid:%x-%x-%x-%x-%x>", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+func warcDigest(data []byte) string {
+	sum := sha1.Sum(data)
+	return "sha1:" + base32.StdEncoding.EncodeToString(sum[:])
+}
+
+// writeWARCRecord writes one record as its own gzip member, the usual layout
+// of .warc.gz files that lets readers seek to individual records.
+func writeWARCRecord(w io.Writer, fields [][2]string, block []byte) error {
+	gz := gzip.NewWriter(w)
+	var header bytes.Buffer
+	header.WriteString(warcVersion + "\r\n")
+	for _, field := range fields {
+		fmt.Fprintf(&header, "%s: %s\r\n", field[0], field[1])
+	}
+	fmt.Fprintf(&header, "WARC-Block-Digest: %s\r\n", warcDigest(block))
+	fmt.Fprintf(&header, "Content-Length: %d\r\n\r\n", len(block))
+
+	if _, err := gz.Write(header.Bytes()); err != nil {
+		return err
+	}
+	if _, err := gz.Write(block); err != nil {
+		return err
+	}
+	if _, err := gz.Write([]byte("\r\n\r\n")); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// writeWARCExport writes stored pages as a gzipped WARC file. Captures have
+// no HTTP response headers, so HTML is stored as resource records and the
+// markdown rendering as conversion records referring to them.
+func writeWARCExport(w io.Writer) error {
+	pages, err := listPages()
+	if err != nil {
+		return err
+	}
+
+	info := []byte("software: memento\r\nformat: WARC File Format 1.1\r\n")
+	if err := writeWARCRecord(w, [][2]string{
+		{"WARC-Type", "warcinfo"},
+		{"WARC-Record-ID", newWARCRecordID()},
+		{"WARC-Date", time.Now().UTC().Format(time.RFC3339)},
+		{"Content-Type", "application/warc-fields"},
+	}, info); err != nil {
+		return err
+	}
+
+	for _, page := range pages {
+		date := page.Timestamp.UTC().Format(time.RFC3339)
+		var resourceID string
+
+		if page.HTMLFilename != "" {
+			html, err := readContentFile(filepath.Join(pagesDir, page.HTMLFilename))
+			if err == nil {
+				resourceID = newWARCRecordID()
+				if err := writeWARCRecord(w, [][2]string{
+					{"WARC-Type", "resource"},
+					{"WARC-Record-ID", resourceID},
+					{"WARC-Date", date},
+					{"WARC-Target-URI", page.URL},
+					{"Content-Type", "text/html"},
+				}, html); err != nil {
+					return err
+				}
+			}
+		}
+
+		if page.HasMarkdown && page.MDFilename != "" {
+			markdown, err := readContentFile(filepath.Join(pagesDir, page.MDFilename))
+			if err != nil {
+				continue
+			}
+			fields := [][2]string{
+				{"WARC-Type", "conversion"},
+				{"WARC-Record-ID", newWARCRecordID()},
+				{"WARC-Date", date},
+				{"WARC-Target-URI", page.URL},
+				{"Content-Type", "text/markdown"},
+			}
+			if resourceID != "" {
+				fields = append(fields, [2]string{"WARC-Refers-To", resourceID})
+			}
+			if err := writeWARCRecord(w, fields, markdown); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// readWARCRecord reads the next record from an uncompressed WARC stream.
+// Blocks larger than a capture may be are skipped, leaving Block empty.
+func readWARCRecord(r *bufio.Reader) (*warcRecord, error) {
+	tp := textproto.NewReader(r)
+	var version string
+	for version == "" {
+		line, err := tp.ReadLine()
+		if err != nil {
+			return nil, err
+		}
+		version = strings.TrimSpace(line)
+	}
+	if !strings.HasPrefix(version, "WARC/") {
+		return nil, fmt.Errorf("invalid WARC record start %q", version)
+	}
+
+	header, err := tp.ReadMIMEHeader()
+	if err != nil {
+		return nil, err
+	}
+	length, err := strconv.ParseInt(header.Get("Content-Length"), 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid WARC Content-Length: %v", err)
+	}
+	if length < 0 {
+		return nil, fmt.Errorf("invalid WARC Content-Length %d", length)
+	}
+	if length > maxCaptureBytes {
+		if _, err := io.CopyN(ioutil.Discard, r, length); err != nil {
+			return nil, err
+		}
+		return &warcRecord{Header: header}, nil
+	}
+	block := make([]byte, length)
+	if _, err := io.ReadFull(r, block); err != nil {
+		return nil, err
+	}
+	return &warcRecord{Header: header, Block: block}, nil
+}
+
+// warcRecordHTML returns the HTML payload of response and resource records.
+func warcRecordHTML(record *warcRecord) (string, bool) {
+	if len(record.Block) == 0 {
+		return "", false
+	}
+	switch record.Header.Get("WARC-Type") {
+	case "resource":
+		if !strings.HasPrefix(record.Header.Get("Content-Type"), "text/html") {
+			return "", false
+		}
+		return string(record.Block), true
+	case "response":
+		if !strings.HasPrefix(record.Header.Get("Content-Type"), "application/http") {
+			return "", false
+		}
+		resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(record.Block)), nil)
+		if err != nil || resp.StatusCode != http.StatusOK {
+			return "", false
+		}
+		defer resp.Body.Close()
+		if !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/html") {
+			return "", false
+		}
+		body := io.Reader(resp.Body)
+		if resp.Header.Get("Content-Encoding") == "gzip" {
+			gz, err := gzip.NewReader(resp.Body)
+			if err != nil {
+				return "", false
+			}
+			defer gz.Close()
+			body = gz
+		}
+		html, err := ioutil.ReadAll(io.LimitReader(body, maxCaptureBytes+1))
+		if err != nil || len(html) > maxCaptureBytes {
+			return "", false
+		}
+		return string(html), true
+	}
+	return "", false
+}
+
+func extractTitle(html string) string {
+	if match := titleRe.FindStringSubmatch(html); match != nil {
+		return strings.TrimSpace(extractText(match[1], true))
+	}
+	return ""
+}
+
+// importWARC stores the HTML pages found in a WARC stream, such as those
+// written by wget --warc-file or ArchiveBox. Gzipped input is detected
+// automatically. Progress counts records, as the total isn't known ahead.
+func importWARC(ctx context.Context, r io.Reader) (importSummary, error) {
+	var summary importSummary
+
+	br := bufio.NewReader(r)
+	if magic, err := br.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return summary, err
+		}
+		defer gz.Close()
+		br = bufio.NewReader(gz)
+	}
+
+	knownHashes, err := knownContentHashes()
+	if err != nil {
+		return summary, err
+	}
+
+	for records := 0; ; records++ {
+		if err := ctx.Err(); err != nil {
+			return summary, err
+		}
+		reportProgress(ctx, records, 0)
+		record, err := readWARCRecord(br)
+		if err == io.EOF {
+			return summary, nil
+		}
+		if err != nil {
+			return summary, err
+		}
+		html, ok := warcRecordHTML(record)
+		if !ok {
+			continue
+		}
+
+		html = storedHTML(html)
+		hash := contentHash([]byte(html))
+		if knownHashes[hash] {
+			summary.Duplicates++
+			continue
+		}
+		metadata := PageMetadata{
+			URL:    strings.Trim(record.Header.Get("WARC-Target-URI"), "<>"),
+			Title:  extractTitle(html),
+			Source: "warc",
+		}
+		if t, err := time.Parse(time.RFC3339, record.Header.Get("WARC-Date")); err == nil {
+			metadata.Timestamp = t
+		}
+		if metadata.Title == "" {
+			metadata.Title = metadata.URL
+		}
+		if _, err := storePage(metadata, html, ""); err != nil {
+			log.Printf("Error storing WARC record for %s: %v", metadata.URL, err)
+			summary.Failed++
+			continue
+		}
+		knownHashes[hash] = true
+		summary.Imported++
+	}
+}
+
+// isWARC reports whether the stream at r is a WARC file, either plain or
+// gzipped.
+func isWARC(r io.ReaderAt, size int64) bool {
+	br := bufio.NewReader(io.NewSectionReader(r, 0, size))
+	magic, err := br.Peek(5)
+	if err != nil {
+		return false
+	}
+	if magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return false
+		}
+		defer gz.Close()
+		magic = make([]byte, 5)
+		if _, err := io.ReadFull(gz, magic); err != nil {
+			return false
+		}
+	}
+	return string(magic) == "WARC/"
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestReadWARCRecord(t *testing.T) {
+	tests := []struct {
+		name      string
+		input     string
+		wantErr   bool
+		wantBlock string
+	}{
+		{
+			name:      "resource",
+			input:     "WARC/1.1\r\nWARC-Type: resource\r\nContent-Length: 5\r\n\r\nhello\r\n\r\n",
+			wantBlock: "hello",
+		},
+		{
+			name:      "leading blank lines",
+			input:     "\r\n\r\nWARC/1.0\r\nContent-Length: 2\r\n\r\nhi",
+			wantBlock: "hi",
+		},
+		{
+			name:    "negative length",
+			input:   "WARC/1.1\r\nContent-Length: -1\r\n\r\n",
+			wantErr: true,
+		},
+		{
+			name:    "missing length",
+			input:   "WARC/1.1\r\nWARC-Type: resource\r\n\r\nhello",
+			wantErr: true,
+		},
+		{
+			name:    "not a WARC record",
+			input:   "HTTP/1.1 200 OK\r\n\r\n",
+			wantErr: true,
+		},
+		{
+			name:    "truncated block",
+			input:   "WARC/1.1\r\nContent-Length: 100\r\n\r\nshort",
+			wantErr: true,
+		},
+		{
+			name:    "oversized block",
+			input:   "WARC/1.1\r\nContent-Length: 99999999999\r\n\r\n",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		record, err := readWARCRecord(bufio.NewReader(strings.NewReader(tt.input)))
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: error = %v, want error %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if err == nil && string(record.Block) != tt.wantBlock {
+			t.Errorf("%s: block = %q, want %q", tt.name, record.Block, tt.wantBlock)
+		}
+	}
+}
+
+func TestReadWARCRecordSkipsOversizedBlock(t *testing.T) {
+	var input bytes.Buffer
+	input.WriteString("WARC/1.1\r\nWARC-Type: resource\r\nContent-Type: text/html\r\n")
+	input.WriteString("Content-Length: 52428801\r\n\r\n")
+	input.Write(make([]byte, maxCaptureBytes+1))
+	input.WriteString("\r\n\r\nWARC/1.1\r\nContent-Length: 2\r\n\r\nok\r\n\r\n")
+
+	r := bufio.NewReader(&input)
+	record, err := readWARCRecord(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := warcRecordHTML(record); ok || len(record.Block) != 0 {
+		t.Errorf("oversized record kept %d bytes", len(record.Block))
+	}
+	next, err := readWARCRecord(r)
+	if err != nil || string(next.Block) != "ok" {
+		t.Errorf("next record = %v, %v", next, err)
+	}
+	if _, err := readWARCRecord(r); err != io.EOF {
+		t.Errorf("got %v, want EOF", err)
+	}
+}
+
+func TestWARCRecordHTML(t *testing.T) {
+	tests := []struct {
+		name   string
+		header map[string]string
+		block  string
+		want   string
+		ok     bool
+	}{
+		{"resource", map[string]string{"WARC-Type": "resource", "Content-Type": "text/html"}, "<p>a</p>", "<p>a</p>", true},
+		{"resource not html", map[string]string{"WARC-Type": "resource", "Content-Type": "image/png"}, "png", "", false},
+		{"response", map[string]string{"WARC-Type": "response", "Content-Type": "application/http; msgtype=response"},
+			"HTTP/1.1 200 OK\r\nContent-Type: text/html\r\nContent-Length: 8\r\n\r\n<p>b</p>", "<p>b</p>", true},
+		{"response not found", map[string]string{"WARC-Type": "response", "Content-Type": "application/http"},
+			"HTTP/1.1 404 Not Found\r\nContent-Type: text/html\r\nContent-Length: 0\r\n\r\n", "", false},
+		{"request", map[string]string{"WARC-Type": "request", "Content-Type": "application/http"}, "GET / HTTP/1.1\r\n\r\n", "", false},
+	}
+	for _, tt := range tests {
+		record := &warcRecord{Header: make(map[string][]string), Block: []byte(tt.block)}
+		for key, value := range tt.header {
+			record.Header.Set(key, value)
+		}
+		got, ok := warcRecordHTML(record)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("%s: got %q, %v; want %q, %v", tt.name, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestWARCExportImportRoundTrip(t *testing.T) {
+	withPagesDir(t)
+	pages := []PageMetadata{
+		{URL: "https://example.com/a", Title: "A"},
+		{URL: "https://example.com/b", Title: "B"},
+	}
+	for i, metadata := range pages {
+		html := "<html><head><title>" + metadata.Title + "</title></head><body>page " + string(rune('0'+i)) + "</body></html>"
+		if _, err := storePage(metadata, html, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var export bytes.Buffer
+	if err := writeWARCExport(&export); err != nil {
+		t.Fatal(err)
+	}
+	if !isWARC(bytes.NewReader(export.Bytes()), int64(export.Len())) {
+		t.Fatal("export is not recognised as WARC")
+	}
+
+	// Importing into the same archive finds every page already there
+	summary, err := importWARC(context.Background(), bytes.NewReader(export.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.Imported != 0 || summary.Duplicates != len(pages) {
+		t.Errorf("reimport summary = %+v, want %d duplicates", summary, len(pages))
+	}
+
+	withPagesDir(t)
+	summary, err = importWARC(context.Background(), bytes.NewReader(export.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.Imported != len(pages) {
+		t.Errorf("import summary = %+v, want %d imported", summary, len(pages))
+	}
+	imported, err := listPages()
+	if err != nil {
+		t.Fatal(err)
+	}
+	urls := make(map[string]string)
+	for _, page := range imported {
+		urls[page.URL] = page.Title
+	}
+	for _, metadata := range pages {
+		if urls[metadata.URL] != metadata.Title {
+			t.Errorf("page %s imported with title %q, want %q", metadata.URL, urls[metadata.URL], metadata.Title)
+		}
+	}
+}
@@ -0,0 +1,338 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"io/ioutil"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const watchStateFile = "memento_watch_state.json"
+
+// watchSettle is how long a file must go unmodified before it is imported,
+// so downloads still being written are left for a later scan.
+var watchSettle = 10 * time.Second
+
+// savedFromRe matches the comment Chrome puts at the top of pages saved
+// with "Save page as", giving the URL they were saved from.
+var savedFromRe = regexp.MustCompile(`<!--\s*saved from url=\(\d+\)(\S+?)\s*-->`)
+
+// watchedFile records a file the watcher has handled by its size and
+// modification time, so it is imported again only if it changes.
+type watchedFile struct {
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"modTime"`
+}
+
+// watchDirs imports what is saved into the configured directories on their
+// schedule.
+func watchDirs() {
+	if len(config.Watch.Dirs) == 0 {
+		return
+	}
+	for {
+		if _, err := scanWatchDirs(context.Background(), config.Watch.Dirs); err != nil {
+			log.Printf("Watching for saved pages failed: %v", err)
+			publishError("", fmt.Errorf("watch: %v", err))
+		}
+		time.Sleep(time.Duration(config.Watch.IntervalSeconds) * time.Second)
+	}
+}
+
+// watchedKind returns how a file in a watched directory is imported: as a
+// saved "html" page, a "pdf", or a "bundle" such as a WARC or an export.
+// Other files are ignored.
+func watchedKind(name string) string {
+	name = strings.ToLower(name)
+	switch {
+	case strings.HasPrefix(name, "."):
+		return ""
+	case strings.HasSuffix(name, ".html"), strings.HasSuffix(name, ".htm"), strings.HasSuffix(name, ".xhtml"):
+		return "html"
+	case strings.HasSuffix(name, ".pdf"):
+		return "pdf"
+	case strings.HasSuffix(name, ".warc"), strings.HasSuffix(name, ".warc.gz"), strings.HasSuffix(name, ".tar.gz"),
+		strings.HasSuffix(name, ".tgz"), strings.HasSuffix(name, ".zip"):
+		return "bundle"
+	}
+	return ""
+}
+
+// scanWatchDirs imports the files in dirs that are new or changed since
+// they were last seen, returning how many pages were stored. A file that
+// fails to import isn't retried until it changes.
+func scanWatchDirs(ctx context.Context, dirs []string) (int, error) {
+	state, err := readWatchState()
+	if err != nil {
+		return 0, err
+	}
+	imported, changed := 0, false
+	for _, dir := range dirs {
+		entries, err := ioutil.ReadDir(dir)
+		if err != nil {
+			log.Printf("Error reading watched directory %s: %v", dir, err)
+			continue
+		}
+		for _, info := range entries {
+			file := filepath.Join(dir, info.Name())
+			kind := watchedKind(info.Name())
+			// Firefox creates an empty file under the final name while
+			// the download goes to a .part file
+			if kind == "" || !info.Mode().IsRegular() || info.Size() == 0 || time.Since(info.ModTime()) < watchSettle {
+				continue
+			}
+			if _, err := os.Stat(file + ".part"); err == nil {
+				continue
+			}
+			if seen, ok := state[file]; ok && seen.Size == info.Size() && seen.ModTime.Equal(info.ModTime()) {
+				continue
+			}
+
+			n, err := importWatchedFile(ctx, file, kind, info.ModTime())
+			switch {
+			case err == nil:
+				log.Printf("Imported %d pages from %s", n, file)
+			case errors.Is(err, errDuplicate), errors.Is(err, errUnrecognizedBundle):
+			default:
+				log.Printf("Error importing %s: %v", file, err)
+				publishError("", fmt.Errorf("importing %s: %v", file, err))
+			}
+			imported += n
+			state[file] = watchedFile{Size: info.Size(), ModTime: info.ModTime()}
+			changed = true
+		}
+	}
+	if changed {
+		if err := writeWatchState(state); err != nil {
+			return imported, err
+		}
+	}
+	return imported, nil
+}
+
+func readWatchState() (map[string]watchedFile, error) {
+	state := make(map[string]watchedFile)
+	data, err := ioutil.ReadFile(watchStateFile)
+	if os.IsNotExist(err) {
+		return state, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("parsing %s: %v", watchStateFile, err)
+	}
+	return state, nil
+}
+
+// writeWatchState replaces the watch state file atomically.
+func writeWatchState(state map[string]watchedFile) error {
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := watchStateFile + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, watchStateFile)
+}
+
+// importWatchedFile imports one file of the given kind, returning how many
+// pages it stored.
+func importWatchedFile(ctx context.Context, file, kind string, saved time.Time) (int, error) {
+	if kind == "bundle" {
+		summary, err := importPath(ctx, file)
+		return summary.Imported, err
+	}
+	info, err := os.Stat(file)
+	if err != nil {
+		return 0, err
+	}
+	if info.Size() > maxCaptureBytes {
+		return 0, errCaptureTooLarge
+	}
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return 0, err
+	}
+	if kind == "pdf" {
+		_, err := archivePDF(ctx, data, fileURL(file), filepath.Base(file), "")
+		if err != nil {
+			return 0, err
+		}
+		return 1, nil
+	}
+
+	doc := string(data)
+	knownHashes, err := knownContentHashes()
+	if err != nil {
+		return 0, err
+	}
+	if knownHashes[contentHash([]byte(storedHTML(doc)))] {
+		return 0, errDuplicate
+	}
+	metadata := PageMetadata{
+		URL:       savedPageURL(doc),
+		Title:     extractTitle(doc),
+		Timestamp: saved,
+		Source:    "watch",
+	}
+	if metadata.URL == "" {
+		metadata.URL = fileURL(file)
+	}
+	if metadata.Title == "" {
+		metadata.Title = strings.TrimSuffix(filepath.Base(file), filepath.Ext(file))
+	}
+	id, err := storePage(metadata, doc, "")
+	if err != nil {
+		return 0, err
+	}
+	// Missing assets don't fail the import, as with captures
+	if _, err := storeSavedAssets(id, file); err != nil {
+		log.Printf("Error storing saved assets of %s: %v", file, err)
+	}
+	if config.Capture.Assets {
+		if _, err := captureAssets(ctx, id); err != nil {
+			log.Printf("Error storing assets of %s: %v", file, err)
+		}
+	}
+	return 1, nil
+}
+
+// savedPageURL returns the URL a saved page came from, as recorded by the
+// browser or declared by the page itself, or "" if it doesn't say.
+func savedPageURL(doc string) string {
+	if m := savedFromRe.FindStringSubmatch(doc); m != nil && webURL(m[1]) {
+		return m[1]
+	}
+	for _, tag := range htmlTagRe.FindAllStringSubmatch(doc, -1) {
+		name := strings.ToLower(tag[1])
+		if name != "link" && name != "meta" {
+			continue
+		}
+		attrs := make(map[string]string)
+		for _, a := range htmlAttrRe.FindAllStringSubmatch(tag[2], -1) {
+			attrs[strings.ToLower(a[1])] = html.UnescapeString(strings.Trim(a[2], `"'`))
+		}
+		var found string
+		if name == "link" && strings.EqualFold(attrs["rel"], "canonical") {
+			found = attrs["href"]
+		} else if name == "meta" && strings.EqualFold(attrs["property"], "og:url") {
+			found = attrs["content"]
+		}
+		if webURL(found) {
+			return found
+		}
+	}
+	return ""
+}
+
+// fileURL is the file: URL of a local file.
+func fileURL(file string) string {
+	if abs, err := filepath.Abs(file); err == nil {
+		file = abs
+	}
+	p := filepath.ToSlash(file)
+	if !strings.HasPrefix(p, "/") {
+		p = "/" + p
+	}
+	return (&url.URL{Scheme: "file", Path: p}).String()
+}
+
+// storeSavedAssets copies the files a page saved by a browser loads from
+// the directory saved next to it, such as "Page_files", into its assets
+// directory and points the stored HTML at them, returning how many were
+// stored.
+func storeSavedAssets(id, htmlPath string) (int, error) {
+	page, err := loadPage(id)
+	if err != nil {
+		return 0, err
+	}
+	storedPath := filepath.Join(pagesDir, page.HTMLFilename)
+	data, err := readContentFile(storedPath)
+	if err != nil {
+		return 0, err
+	}
+	root := filepath.Dir(htmlPath)
+	local := func(dir string) func(string) string {
+		return func(ref string) string { return localAsset(dir, root, ref) }
+	}
+
+	// names maps each local file to its stored name, or "" while it is
+	// being stored or if it couldn't be
+	names := make(map[string]string)
+	var total int64
+	var store func(file, ext string) string
+	store = func(file, ext string) string {
+		if name, ok := names[file]; ok {
+			return name
+		}
+		names[file] = ""
+		if len(names) > maxPageAssets {
+			return ""
+		}
+		data, err := ioutil.ReadFile(file)
+		if err != nil || len(data) > maxAssetBytes || total+int64(len(data)) > maxCaptureBytes {
+			return ""
+		}
+		total += int64(len(data))
+		name := assetName(file, ext)
+		if strings.EqualFold(filepath.Ext(file), ".css") {
+			data = []byte(rewriteCSSAssets(string(data), local(filepath.Dir(file)), store))
+		}
+		if err := os.MkdirAll(assetsDir(id), 0755); err != nil {
+			return ""
+		}
+		if err := ioutil.WriteFile(filepath.Join(assetsDir(id), name), data, 0644); err != nil {
+			return ""
+		}
+		names[file] = name
+		return name
+	}
+
+	rewritten := rewriteHTMLAssets(string(data), local(root), func(file, ext string) string {
+		if name := store(file, ext); name != "" {
+			return assetsPrefix + name
+		}
+		return ""
+	})
+	stored := 0
+	for _, name := range names {
+		if name != "" {
+			stored++
+		}
+	}
+	if stored == 0 {
+		return 0, nil
+	}
+	return stored, writeContentFile(storedPath, []byte(rewritten))
+}
+
+// localAsset returns the file a relative reference in a saved page loads,
+// resolved against dir, if it lies in a directory under root, where the
+// page was saved.
+func localAsset(dir, root, ref string) string {
+	u, err := url.Parse(strings.TrimSpace(ref))
+	if err != nil || u.Scheme != "" || u.Host != "" || u.Path == "" || strings.HasPrefix(u.Path, "/") {
+		return ""
+	}
+	file := filepath.Join(dir, filepath.FromSlash(u.Path))
+	rel, err := filepath.Rel(root, file)
+	if err != nil || strings.HasPrefix(rel, "..") || !strings.ContainsRune(rel, filepath.Separator) {
+		return ""
+	}
+	if info, err := os.Stat(file); err != nil || !info.Mode().IsRegular() {
+		return ""
+	}
+	return file
+}
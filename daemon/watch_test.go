@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestScanWatchDirs(t *testing.T) {
+	withPagesDir(t)
+	saved := watchSettle
+	watchSettle = time.Minute
+	defer func() { watchSettle = saved }()
+
+	dir := t.TempDir()
+	old := time.Now().Add(-time.Hour)
+	write := func(name, content string, modified time.Time) {
+		t.Helper()
+		file := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(file, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(file, modified, modified); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("Article.html", `<!-- saved from url=(0025)https://example.com/post -->
+<html><head><title>Article</title><link rel="stylesheet" href="Article_files/site.css"></head>
+<body><img src="Article_files/photo%20one.png"><img src="Article_files/missing.png"><img src="../outside.png"></body></html>`, old)
+	write("Article_files/site.css", `body { background: url(bg.png) }`, old)
+	write("Article_files/photo one.png", string(fakePNG), old)
+	write("Article_files/bg.png", string(fakePNG), old)
+	write("paper.pdf", string(testPDF(pdfStream("", "BT (Findings) Tj ET", false))), old)
+	write("notes.txt", "not imported", old)
+	write("fresh.html", "<html><body>Still downloading</body></html>", time.Now())
+	write("video.mp4.crdownload", "partial", old)
+	write("other.pdf", "", old)
+	write("other.pdf.part", "partial", old)
+
+	imported, err := scanWatchDirs(context.Background(), []string{dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if imported != 2 {
+		t.Fatalf("imported %d pages, want 2", imported)
+	}
+
+	pages, err := listPages()
+	if err != nil {
+		t.Fatal(err)
+	}
+	byTitle := make(map[string]Page)
+	for _, page := range pages {
+		byTitle[page.Title] = page
+	}
+	article, pdf := byTitle["Article"], byTitle["Findings"]
+	if pdf.ID == "" {
+		pdf = byTitle["paper.pdf"]
+	}
+	if article.URL != "https://example.com/post" || article.Source != "watch" {
+		t.Errorf("article = %+v", article.PageMetadata)
+	}
+	if pdf.PDFFilename == "" || !strings.HasPrefix(pdf.URL, "file:///") {
+		t.Errorf("pdf = %+v", pdf.PageMetadata)
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(pagesDir, article.HTMLFilename))
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored := string(data)
+	assets, _ := ioutil.ReadDir(assetsDir(article.ID))
+	if len(assets) != 3 {
+		t.Errorf("stored %d assets, want 3", len(assets))
+	}
+	tests := []struct {
+		name string
+		want string
+		ok   bool
+	}{
+		{"photo", `src="files/` + assetName(filepath.Join(dir, "Article_files", "photo one.png"), "") + `"`, true},
+		{"stylesheet", `href="files/` + assetName(filepath.Join(dir, "Article_files", "site.css"), ".css") + `"`, true},
+		{"missing", `src="Article_files/missing.png"`, true},
+		{"outside", `src="../outside.png"`, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if strings.Contains(stored, tt.want) != tt.ok {
+				t.Errorf("contains %q = %v, want %v in:\n%s", tt.want, !tt.ok, tt.ok, stored)
+			}
+		})
+	}
+	css, err := ioutil.ReadFile(filepath.Join(assetsDir(article.ID), assetName(filepath.Join(dir, "Article_files", "site.css"), ".css")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `url("` + assetName(filepath.Join(dir, "Article_files", "bg.png"), "") + `")`; !strings.Contains(string(css), want) {
+		t.Errorf("stylesheet = %s, want %s", css, want)
+	}
+
+	// Files seen before are left alone until they change
+	if imported, err := scanWatchDirs(context.Background(), []string{dir}); err != nil || imported != 0 {
+		t.Errorf("second scan imported %d, %v", imported, err)
+	}
+	write("fresh.html", "<html><body>Done downloading</body></html>", old)
+	if imported, err := scanWatchDirs(context.Background(), []string{dir}); err != nil || imported != 1 {
+		t.Errorf("third scan imported %d, %v", imported, err)
+	}
+}
+
+func TestSavedPageURL(t *testing.T) {
+	tests := []struct {
+		name string
+		doc  string
+		want string
+	}{
+		{"saved from", `<!-- saved from url=(0019)https://example.com/ --><html></html>`, "https://example.com/"},
+		{"canonical", `<link rel="canonical" href="https://example.com/a?b=1&amp;c=2">`, "https://example.com/a?b=1&c=2"},
+		{"og:url", `<meta property="og:url" content="https://example.com/og">`, "https://example.com/og"},
+		{"relative canonical", `<link rel="canonical" href="/a">`, ""},
+		{"none", `<html><body>Hi</body></html>`, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := savedPageURL(tt.doc); got != tt.want {
+				t.Errorf("savedPageURL() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
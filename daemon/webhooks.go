@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+const (
+	webhookQueueSize   = 256
+	webhookTimeout     = 10 * time.Second
+	webhookMaxAttempts = 5
+)
+
+// webhookEvents are the events webhooks may subscribe to, and the default
+// subscription.
+var webhookEvents = []string{eventPageIndexed, eventPageDeleted, eventPageChanged, eventError}
+
+// webhookRetryDelay is the wait before the first retry, doubling after each
+// failed attempt.
+var webhookRetryDelay = time.Second
+
+var webhookHTTPClient = &http.Client{Timeout: webhookTimeout}
+
+// webhook delivers events to one configured URL in order, from its own
+// queue so a slow endpoint doesn't hold up the others.
+type webhook struct {
+	config WebhookConfig
+	events map[string]bool
+	queue  chan Event
+}
+
+// validateWebhooks checks the configured webhooks' URLs and events.
+func validateWebhooks(webhooks []WebhookConfig) error {
+	for i, hook := range webhooks {
+		if u, err := url.Parse(hook.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("webhooks[%d].url must be an absolute http(s) URL", i)
+		}
+		for _, event := range hook.Events {
+			known := false
+			for _, e := range webhookEvents {
+				known = known || e == event
+			}
+			if !known {
+				return fmt.Errorf("webhooks[%d] has unknown event %q", i, event)
+			}
+		}
+	}
+	return nil
+}
+
+// startWebhooks delivers broker events to the configured webhooks until the
+// process exits.
+func startWebhooks() {
+	if len(config.Webhooks) == 0 {
+		return
+	}
+	var hooks []*webhook
+	for _, c := range config.Webhooks {
+		hook := &webhook{config: c, events: make(map[string]bool), queue: make(chan Event, webhookQueueSize)}
+		subscribed := c.Events
+		if len(subscribed) == 0 {
+			subscribed = webhookEvents
+		}
+		for _, event := range subscribed {
+			hook.events[event] = true
+		}
+		hooks = append(hooks, hook)
+		go hook.run()
+	}
+
+	ch := events.subscribe()
+	go func() {
+		for event := range ch {
+			for _, hook := range hooks {
+				if !hook.events[event.Type] {
+					continue
+				}
+				select {
+				case hook.queue <- event:
+				default:
+					log.Printf("Webhook queue for %s is full, dropping %s event", hook.config.URL, event.Type)
+				}
+			}
+		}
+	}()
+}
+
+func (h *webhook) run() {
+	for event := range h.queue {
+		if err := h.deliver(event); err != nil {
+			log.Printf("Error delivering %s event to %s: %v", event.Type, h.config.URL, err)
+		}
+	}
+}
+
+// deliver posts an event, retrying with exponential backoff on network
+// errors, server errors and rate limiting.
+func (h *webhook) deliver(event Event) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	id := make([]byte, 8)
+	rand.Read(id)
+	delivery := hex.EncodeToString(id)
+
+	delay := webhookRetryDelay
+	for attempt := 1; ; attempt++ {
+		retry, err := h.post(event.Type, delivery, body)
+		if err == nil {
+			return nil
+		}
+		if !retry || attempt == webhookMaxAttempts {
+			return fmt.Errorf("giving up after %d attempts: %v", attempt, err)
+		}
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+// post makes one delivery attempt, reporting whether a failure is worth
+// retrying.
+func (h *webhook) post(eventType, delivery string, body []byte) (bool, error) {
+	req, err := http.NewRequest(http.MethodPost, h.config.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "memento-webhook/1.0")
+	req.Header.Set("X-Memento-Event", eventType)
+	req.Header.Set("X-Memento-Delivery", delivery)
+	if !h.config.Secret.IsZero() {
+		secret, err := h.config.Secret.Value()
+		if err != nil {
+			return true, fmt.Errorf("reading secret: %v", err)
+		}
+		req.Header.Set("X-Memento-Signature", "sha256="+signWebhook(secret, body))
+	}
+
+	resp, err := webhookHTTPClient.Do(req)
+	if err != nil {
+		return true, err
+	}
+	resp.Body.Close()
+	switch {
+	case resp.StatusCode/100 == 2:
+		return false, nil
+	case resp.StatusCode >= 500, resp.StatusCode == http.StatusTooManyRequests, resp.StatusCode == http.StatusRequestTimeout:
+		return true, fmt.Errorf("endpoint returned %s", resp.Status)
+	default:
+		return false, fmt.Errorf("endpoint returned %s", resp.Status)
+	}
+}
+
+// signWebhook returns the hex HMAC-SHA256 of body, which receivers compare
+// with the X-Memento-Signature header to check a delivery came from here.
+func signWebhook(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestValidateWebhooks(t *testing.T) {
+	tests := []struct {
+		name    string
+		hooks   []WebhookConfig
+		wantErr bool
+	}{
+		{"none", nil, false},
+		{"all events", []WebhookConfig{{URL: "https://n8n.example/webhook/memento"}}, false},
+		{"some events", []WebhookConfig{{URL: "http://localhost:5678/hook", Events: []string{"page.indexed", "error"}}}, false},
+		{"relative url", []WebhookConfig{{URL: "/hook"}}, true},
+		{"unknown scheme", []WebhookConfig{{URL: "ftp://example.com/"}}, true},
+		{"unknown event", []WebhookConfig{{URL: "https://example.com/", Events: []string{"page.captured"}}}, true},
+	}
+	for _, tt := range tests {
+		if err := validateWebhooks(tt.hooks); (err != nil) != tt.wantErr {
+			t.Errorf("%s: validateWebhooks() error = %v, wantErr %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestWebhookDelivery(t *testing.T) {
+	saved := webhookRetryDelay
+	webhookRetryDelay = time.Millisecond
+	defer func() { webhookRetryDelay = saved }()
+
+	tests := []struct {
+		name     string
+		statuses []int
+		attempts int
+		wantErr  bool
+	}{
+		{"success", []int{200}, 1, false},
+		{"retries server errors", []int{503, 500, 204}, 3, false},
+		{"retries rate limiting", []int{429, 200}, 2, false},
+		{"gives up", []int{502, 502, 502, 502, 502, 502}, webhookMaxAttempts, true},
+		{"client errors are final", []int{400, 200}, 1, true},
+	}
+	for _, tt := range tests {
+		attempts := 0
+		var deliveries []string
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, _ := ioutil.ReadAll(r.Body)
+			mac := hmac.New(sha256.New, []byte("s3cret"))
+			mac.Write(body)
+			if got, want := r.Header.Get("X-Memento-Signature"), "sha256="+hex.EncodeToString(mac.Sum(nil)); got != want {
+				t.Errorf("%s: signature = %q, want %q", tt.name, got, want)
+			}
+			var event Event
+			if err := json.Unmarshal(body, &event); err != nil || event.Type != eventPageIndexed || event.PageID != "p1" {
+				t.Errorf("%s: body = %s", tt.name, body)
+			}
+			if r.Header.Get("X-Memento-Event") != eventPageIndexed {
+				t.Errorf("%s: X-Memento-Event = %q", tt.name, r.Header.Get("X-Memento-Event"))
+			}
+			deliveries = append(deliveries, r.Header.Get("X-Memento-Delivery"))
+			w.WriteHeader(tt.statuses[attempts])
+			attempts++
+		}))
+
+		hook := &webhook{config: WebhookConfig{URL: server.URL, Secret: NewSecret("s3cret")}}
+		err := hook.deliver(Event{Type: eventPageIndexed, PageID: "p1"})
+		server.Close()
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: deliver() error = %v, wantErr %v", tt.name, err, tt.wantErr)
+		}
+		if attempts != tt.attempts {
+			t.Errorf("%s: %d attempts, want %d", tt.name, attempts, tt.attempts)
+		}
+		for _, id := range deliveries {
+			if id == "" || id != deliveries[0] {
+				t.Errorf("%s: retries should share one delivery ID, got %v", tt.name, deliveries)
+				break
+			}
+		}
+	}
+}
+
+func TestWebhookUnsigned(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if sig := r.Header.Get("X-Memento-Signature"); sig != "" {
+			t.Errorf("unsigned webhook sent signature %q", sig)
+		}
+	}))
+	defer server.Close()
+
+	hook := &webhook{config: WebhookConfig{URL: server.URL}}
+	if err := hook.deliver(Event{Type: eventError, Message: "boom"}); err != nil {
+		t.Fatal(err)
+	}
+}
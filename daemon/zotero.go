@@ -0,0 +1,447 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// zoteroRDF is the subset of a Zotero RDF export ("Export Collection" with
+// "Export Files" enabled) needed to import snapshots with their citations.
+type zoteroRDF struct {
+	Items []zoteroRDFItem `xml:",any"`
+}
+
+type zoteroRDFItem struct {
+	About         string `xml:"http://www.w3.org/1999/02/22-rdf-syntax-ns# about,attr"`
+	ItemType      string `xml:"itemType"`
+	Title         string `xml:"http://purl.org/dc/elements/1.1/ title"`
+	Date          string `xml:"http://purl.org/dc/elements/1.1/ date"`
+	DateSubmitted string `xml:"dateSubmitted"`
+	Subjects      []struct {
+		Text  string `xml:",chardata"`
+		Value string `xml:"AutomaticTag>value"`
+	} `xml:"subject"`
+	Identifiers []struct {
+		Text string `xml:",chardata"`
+		URI  string `xml:"URI>value"`
+	} `xml:"identifier"`
+	Authors []struct {
+		Surname   string `xml:"surname"`
+		GivenName string `xml:"givenName"`
+	} `xml:"authors>Seq>li>Person"`
+	IsPartOf struct {
+		Containers []struct {
+			Title string `xml:"http://purl.org/dc/elements/1.1/ title"`
+		} `xml:",any"`
+	} `xml:"isPartOf"`
+	Links []struct {
+		Resource string `xml:"http://www.w3.org/1999/02/22-rdf-syntax-ns# resource,attr"`
+	} `xml:"http://purl.org/rss/1.0/modules/link/ link"`
+	Resource struct {
+		Path string `xml:"http://www.w3.org/1999/02/22-rdf-syntax-ns# resource,attr"`
+	} `xml:"http://www.w3.org/1999/02/22-rdf-syntax-ns# resource"`
+	MIMEType string `xml:"http://purl.org/rss/1.0/modules/link/ type"`
+}
+
+func (item zoteroRDFItem) url() string {
+	for _, id := range item.Identifiers {
+		if id.URI != "" {
+			return strings.TrimSpace(id.URI)
+		}
+	}
+	return ""
+}
+
+func (item zoteroRDFItem) citation() *Citation {
+	citation := &Citation{
+		ItemType: item.ItemType,
+		Date:     strings.TrimSpace(item.Date),
+	}
+	for _, author := range item.Authors {
+		citation.Authors = append(citation.Authors, strings.TrimSpace(author.GivenName+" "+author.Surname))
+	}
+	for _, container := range item.IsPartOf.Containers {
+		if container.Title != "" {
+			citation.Publication = strings.TrimSpace(container.Title)
+			break
+		}
+	}
+	for _, id := range item.Identifiers {
+		if text := strings.TrimSpace(id.Text); strings.HasPrefix(text, "DOI ") {
+			citation.DOI = strings.TrimPrefix(text, "DOI ")
+		}
+	}
+	return citation
+}
+
+func (item zoteroRDFItem) tags() []string {
+	var tags []string
+	for _, subject := range item.Subjects {
+		tag := strings.TrimSpace(subject.Text)
+		if subject.Value != "" {
+			tag = strings.TrimSpace(subject.Value)
+		}
+		if tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+// importZoteroExport stores every HTML snapshot attachment of a Zotero RDF
+// export, carrying over the parent item's bibliographic metadata. The path may
+// be the export directory or the .rdf file inside it.
+func importZoteroExport(path string) (int, error) {
+	rdfPath, err := findZoteroRDF(path)
+	if err != nil {
+		return 0, err
+	}
+	baseDir := filepath.Dir(rdfPath)
+
+	data, err := ioutil.ReadFile(rdfPath)
+	if err != nil {
+		return 0, err
+	}
+	var export zoteroRDF
+	if err := xml.Unmarshal(data, &export); err != nil {
+		return 0, fmt.Errorf("parsing %s: %v", rdfPath, err)
+	}
+
+	attachments := make(map[string]zoteroRDFItem)
+	for _, item := range export.Items {
+		if item.ItemType == "attachment" {
+			attachments[item.About] = item
+		}
+	}
+
+	knownHashes, err := knownContentHashes()
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	linked := make(map[string]bool)
+	importSnapshot := func(parent, attachment zoteroRDFItem) {
+		if attachment.MIMEType != "text/html" || attachment.Resource.Path == "" {
+			return
+		}
+		snapshotPath, ok := zoteroSnapshotPath(baseDir, attachment.Resource.Path)
+		if !ok {
+			log.Printf("Skipping Zotero snapshot %s outside the export", attachment.Resource.Path)
+			return
+		}
+		data, err := ioutil.ReadFile(snapshotPath)
+		if err != nil {
+			log.Printf("Error reading Zotero snapshot %s: %v", snapshotPath, err)
+			return
+		}
+		html := storedHTML(string(data))
+		// The same snapshot can be exported from several collections
+		hash := contentHash([]byte(html))
+		if knownHashes[hash] {
+			return
+		}
+
+		metadata := PageMetadata{
+			URL:    parent.url(),
+			Title:  strings.TrimSpace(parent.Title),
+			Tags:   parent.tags(),
+			Source: "zotero",
+		}
+		if metadata.URL == "" {
+			metadata.URL = attachment.url()
+		}
+		if parent.ItemType != "attachment" {
+			metadata.Citation = parent.citation()
+		}
+		if t, err := time.ParseInLocation("2006-01-02 15:04:05", parent.DateSubmitted, time.UTC); err == nil {
+			metadata.Timestamp = t
+		}
+
+		if _, err := storePage(metadata, html, ""); err != nil {
+			log.Printf("Error storing Zotero snapshot %s: %v", snapshotPath, err)
+			return
+		}
+		knownHashes[hash] = true
+		count++
+	}
+
+	for _, item := range export.Items {
+		if item.ItemType == "attachment" {
+			continue
+		}
+		for _, link := range item.Links {
+			if attachment, ok := attachments[link.Resource]; ok {
+				linked[link.Resource] = true
+				importSnapshot(item, attachment)
+			}
+		}
+	}
+	// Standalone snapshots have no parent item to take metadata from
+	for about, attachment := range attachments {
+		if !linked[about] {
+			importSnapshot(attachment, attachment)
+		}
+	}
+
+	return count, nil
+}
+
+// zoteroSnapshotPath maps an attachment's path to its file in the export
+// directory, rejecting absolute paths and those leading out of it.
+func zoteroSnapshotPath(baseDir, resource string) (string, bool) {
+	name := filepath.Clean(filepath.FromSlash(resource))
+	if filepath.IsAbs(name) || name == ".." || strings.HasPrefix(name, ".."+string(filepath.Separator)) {
+		return "", false
+	}
+	return filepath.Join(baseDir, name), true
+}
+
+func findZoteroRDF(path string) (string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	if !info.IsDir() {
+		return path, nil
+	}
+	matches, err := filepath.Glob(filepath.Join(path, "*.rdf"))
+	if err != nil {
+		return "", err
+	}
+	if len(matches) == 0 {
+		return "", fmt.Errorf("no .rdf file found in %s", path)
+	}
+	return matches[0], nil
+}
+
+// The Zotero Connector only talks to a Zotero desktop client on localhost, so
+// the connector endpoints are served on their own listener that mimics the
+// small part of that protocol used for saving web pages.
+
+type zoteroConnectorItem struct {
+	ItemType         string            `json:"itemType"`
+	Title            string            `json:"title"`
+	URL              string            `json:"url"`
+	Date             string            `json:"date"`
+	DOI              string            `json:"DOI"`
+	PublicationTitle string            `json:"publicationTitle"`
+	WebsiteTitle     string            `json:"websiteTitle"`
+	Tags             []json.RawMessage `json:"tags"`
+	Creators         []struct {
+		FirstName string `json:"firstName"`
+		LastName  string `json:"lastName"`
+		Name      string `json:"name"`
+	} `json:"creators"`
+}
+
+func (item zoteroConnectorItem) citation() *Citation {
+	citation := &Citation{
+		ItemType:    item.ItemType,
+		Date:        item.Date,
+		Publication: item.PublicationTitle,
+		DOI:         item.DOI,
+	}
+	if citation.Publication == "" {
+		citation.Publication = item.WebsiteTitle
+	}
+	for _, creator := range item.Creators {
+		name := creator.Name
+		if name == "" {
+			name = strings.TrimSpace(creator.FirstName + " " + creator.LastName)
+		}
+		citation.Authors = append(citation.Authors, name)
+	}
+	return citation
+}
+
+func (item zoteroConnectorItem) tags() []string {
+	var tags []string
+	for _, raw := range item.Tags {
+		// Tags are sent either as plain strings or as {"tag": "..."} objects
+		var tag string
+		if err := json.Unmarshal(raw, &tag); err != nil {
+			var object struct {
+				Tag string `json:"tag"`
+			}
+			json.Unmarshal(raw, &object)
+			tag = object.Tag
+		}
+		if tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+const (
+	// maxZoteroSessions bounds the sessions waiting for their snapshot;
+	// the oldest is dropped to make room for a new one.
+	maxZoteroSessions   = 100
+	maxZoteroItemsBytes = 1 << 20
+)
+
+type zoteroSession struct {
+	items   []zoteroConnectorItem
+	created time.Time
+}
+
+var (
+	zoteroSessionsMu sync.Mutex
+	// zoteroSessions holds items announced via saveItems until the connector
+	// sends the matching snapshot content.
+	zoteroSessions = make(map[string]zoteroSession)
+)
+
+// checkZoteroConnector refuses requests that could come from a web page
+// rather than the Zotero Connector. Pages can't set the connector's API
+// version header or a JSON content type without a CORS preflight, which
+// this listener never answers, and their Origin gives them away.
+func checkZoteroConnector(w http.ResponseWriter, r *http.Request) bool {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, ErrMethodNotAllowed, "Method not allowed")
+		return false
+	}
+	contentType := strings.TrimSpace(strings.Split(r.Header.Get("Content-Type"), ";")[0])
+	if r.Header.Get("X-Zotero-Connector-API-Version") == "" && contentType != "application/json" {
+		writeError(w, http.StatusBadRequest, ErrInvalidRequest, "Not a Zotero Connector request")
+		return false
+	}
+	if !originAllowed(r) {
+		writeError(w, http.StatusForbidden, ErrForbidden, "Origin is not allowed")
+		return false
+	}
+	return true
+}
+
+func serveZoteroConnector() {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/connector/ping", handleZoteroPing)
+	mux.HandleFunc("/connector/saveItems", handleZoteroSaveItems)
+	mux.HandleFunc("/connector/saveSnapshot", handleZoteroSaveSnapshot)
+	mux.HandleFunc("/connector/saveSingleFile", handleZoteroSaveSnapshot)
+
+	addr := fmt.Sprintf("127.0.0.1:%d", config.Zotero.ConnectorPort)
+	log.Printf("Starting Zotero connector endpoint on %s...", addr)
+	if err := http.ListenAndServe(addr, mux); err != nil {
+		log.Printf("Zotero connector endpoint stopped: %v", err)
+	}
+}
+
+func handleZoteroPing(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("X-Zotero-Version", "5.0.0")
+	w.Header().Set("Content-Type", "text/plain")
+	fmt.Fprint(w, "Zotero is running")
+}
+
+func handleZoteroSaveItems(w http.ResponseWriter, r *http.Request) {
+	if !checkZoteroConnector(w, r) {
+		return
+	}
+	var request struct {
+		SessionID string                `json:"sessionID"`
+		Items     []zoteroConnectorItem `json:"items"`
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxZoteroItemsBytes)
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		if isTooLarge(err) {
+			writeError(w, http.StatusRequestEntityTooLarge, ErrCaptureTooLarge, "Items exceed the maximum request size")
+			return
+		}
+		writeError(w, http.StatusBadRequest, ErrInvalidRequest, "Invalid request body")
+		return
+	}
+
+	zoteroSessionsMu.Lock()
+	if _, ok := zoteroSessions[request.SessionID]; !ok && len(zoteroSessions) >= maxZoteroSessions {
+		var oldestID string
+		var oldest time.Time
+		for id, session := range zoteroSessions {
+			if oldest.IsZero() || session.created.Before(oldest) {
+				oldestID, oldest = id, session.created
+			}
+		}
+		delete(zoteroSessions, oldestID)
+	}
+	zoteroSessions[request.SessionID] = zoteroSession{items: request.Items, created: time.Now()}
+	zoteroSessionsMu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{"items": request.Items})
+}
+
+func handleZoteroSaveSnapshot(w http.ResponseWriter, r *http.Request) {
+	if !checkZoteroConnector(w, r) {
+		return
+	}
+	var request struct {
+		SessionID       string `json:"sessionID"`
+		URL             string `json:"url"`
+		Title           string `json:"title"`
+		HTML            string `json:"html"`
+		SnapshotContent string `json:"snapshotContent"`
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxCaptureBytes)
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		if isTooLarge(err) {
+			writeError(w, http.StatusRequestEntityTooLarge, ErrCaptureTooLarge, "Snapshot exceeds the maximum capture size")
+			return
+		}
+		writeError(w, http.StatusBadRequest, ErrInvalidRequest, "Invalid request body")
+		return
+	}
+	html := request.HTML
+	if html == "" {
+		html = request.SnapshotContent
+	}
+	if html == "" {
+		writeError(w, http.StatusBadRequest, ErrInvalidRequest, "Missing snapshot content")
+		return
+	}
+	html = storedHTML(html)
+	if knownHashes, err := knownContentHashes(); err == nil && knownHashes[contentHash([]byte(html))] {
+		writeError(w, http.StatusConflict, ErrDuplicate, "Snapshot is already archived")
+		return
+	}
+
+	zoteroSessionsMu.Lock()
+	items := zoteroSessions[request.SessionID].items
+	delete(zoteroSessions, request.SessionID)
+	zoteroSessionsMu.Unlock()
+
+	metadata := PageMetadata{
+		URL:    request.URL,
+		Title:  request.Title,
+		Source: "zotero",
+	}
+	if len(items) > 0 {
+		item := items[0]
+		if metadata.URL == "" {
+			metadata.URL = item.URL
+		}
+		if item.Title != "" {
+			metadata.Title = item.Title
+		}
+		metadata.Tags = item.tags()
+		metadata.Citation = item.citation()
+	}
+
+	id, err := storePage(metadata, html, "")
+	if err != nil {
+		log.Printf("Error storing Zotero connector snapshot: %v", err)
+		writeError(w, http.StatusInternalServerError, ErrInternal, "Failed to store snapshot")
+		return
+	}
+	log.Printf("Stored Zotero connector snapshot %s", id)
+	w.WriteHeader(http.StatusCreated)
+}
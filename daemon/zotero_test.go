@@ -0,0 +1,253 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const zoteroRDFSample = `<rdf:RDF
+ xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#"
+ xmlns:z="http://www.zotero.org/namespaces/export#"
+ xmlns:dc="http://purl.org/dc/elements/1.1/"
+ xmlns:dcterms="http://purl.org/dc/terms/"
+ xmlns:bib="http://purl.org/net/biblio#"
+ xmlns:foaf="http://xmlns.com/foaf/0.1/"
+ xmlns:link="http://purl.org/rss/1.0/modules/link/">
+    <bib:Article rdf:about="https://example.com/article">
+        <z:itemType>journalArticle</z:itemType>
+        <dcterms:isPartOf>
+            <bib:Journal><dc:title>Journal of Examples</dc:title></bib:Journal>
+        </dcterms:isPartOf>
+        <bib:authors>
+            <rdf:Seq>
+                <rdf:li><foaf:Person><foaf:surname>Lovelace</foaf:surname><foaf:givenName>Ada</foaf:givenName></foaf:Person></rdf:li>
+            </rdf:Seq>
+        </bib:authors>
+        <link:link rdf:resource="#item_2"/>
+        <link:link rdf:resource="#item_3"/>
+        <dc:subject>history</dc:subject>
+        <dc:subject><z:AutomaticTag><rdf:value>computing</rdf:value></z:AutomaticTag></dc:subject>
+        <dc:title>On Engines</dc:title>
+        <dc:date>1843</dc:date>
+        <dc:identifier>DOI 10.1000/engines</dc:identifier>
+        <dc:identifier><dcterms:URI><rdf:value>https://example.com/article</rdf:value></dcterms:URI></dc:identifier>
+        <dcterms:dateSubmitted>2024-01-02 03:04:05</dcterms:dateSubmitted>
+    </bib:Article>
+    <z:Attachment rdf:about="#item_2">
+        <z:itemType>attachment</z:itemType>
+        <rdf:resource rdf:resource="files/2/snapshot.html"/>
+        <link:type>text/html</link:type>
+    </z:Attachment>
+    <z:Attachment rdf:about="#item_3">
+        <z:itemType>attachment</z:itemType>
+        <rdf:resource rdf:resource="files/3/paper.pdf"/>
+        <link:type>application/pdf</link:type>
+    </z:Attachment>
+    <z:Attachment rdf:about="#item_4">
+        <z:itemType>attachment</z:itemType>
+        <dc:title>Standalone</dc:title>
+        <dc:identifier><dcterms:URI><rdf:value>https://example.com/standalone</rdf:value></dcterms:URI></dc:identifier>
+        <rdf:resource rdf:resource="files/4/snapshot.html"/>
+        <link:type>text/html</link:type>
+    </z:Attachment>
+    <z:Attachment rdf:about="#item_5">
+        <z:itemType>attachment</z:itemType>
+        <dc:title>Copy</dc:title>
+        <rdf:resource rdf:resource="files/5/snapshot.html"/>
+        <link:type>text/html</link:type>
+    </z:Attachment>
+</rdf:RDF>
+`
+
+func writeZoteroExport(t *testing.T, rdf string, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "Exported Items.rdf"), []byte(rdf), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		os.MkdirAll(filepath.Dir(path), 0755)
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestImportZoteroExport(t *testing.T) {
+	withPagesDir(t)
+	dir := writeZoteroExport(t, zoteroRDFSample, map[string]string{
+		"files/2/snapshot.html": "<html><body>engines</body></html>",
+		"files/3/paper.pdf":     "%PDF",
+		"files/4/snapshot.html": "<html><body>standalone</body></html>",
+		// The same snapshot exported twice is only imported once
+		"files/5/snapshot.html": "<html><body>engines</body></html>",
+	})
+
+	count, err := importZoteroExport(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 2 {
+		t.Fatalf("imported %d snapshots, want 2", count)
+	}
+	files, err := filepath.Glob(filepath.Join(pagesDir, "*.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	byURL := make(map[string]PageMetadata)
+	for _, file := range files {
+		metadata, err := readMetadata(strings.TrimSuffix(filepath.Base(file), ".json"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		byURL[metadata.URL] = metadata
+	}
+
+	article, ok := byURL["https://example.com/article"]
+	if !ok {
+		t.Fatalf("article not imported: %v", files)
+	}
+	if article.Title != "On Engines" || strings.Join(article.Tags, ",") != "history,computing" {
+		t.Errorf("article title %q, tags %v", article.Title, article.Tags)
+	}
+	if c := article.Citation; c == nil || c.ItemType != "journalArticle" || c.DOI != "10.1000/engines" ||
+		c.Publication != "Journal of Examples" || c.Date != "1843" || strings.Join(c.Authors, ";") != "Ada Lovelace" {
+		t.Errorf("article citation = %+v", article.Citation)
+	}
+	if got := article.Timestamp.UTC().Format("2006-01-02 15:04:05"); got != "2024-01-02 03:04:05" {
+		t.Errorf("article timestamp = %s", got)
+	}
+	if standalone, ok := byURL["https://example.com/standalone"]; !ok || standalone.Citation != nil {
+		t.Errorf("standalone snapshot = %+v, %v", standalone, ok)
+	}
+
+	// Importing again finds every snapshot already archived
+	if count, err := importZoteroExport(dir); err != nil || count != 0 {
+		t.Errorf("reimport = %d, %v; want 0", count, err)
+	}
+}
+
+func TestImportZoteroExportMalformed(t *testing.T) {
+	withPagesDir(t)
+	tests := []struct {
+		name string
+		rdf  string
+	}{
+		{"not xml", "this is not RDF"},
+		{"unclosed element", "<rdf:RDF xmlns:rdf=\"http://www.w3.org/1999/02/22-rdf-syntax-ns#\"><z:Attachment>"},
+	}
+	for _, tt := range tests {
+		if _, err := importZoteroExport(writeZoteroExport(t, tt.rdf, nil)); err == nil {
+			t.Errorf("%s: no error", tt.name)
+		}
+	}
+	if _, err := importZoteroExport(t.TempDir()); err == nil {
+		t.Error("directory without an .rdf file: no error")
+	}
+
+	// Snapshots that are missing from the export are skipped
+	count, err := importZoteroExport(writeZoteroExport(t, zoteroRDFSample, nil))
+	if err != nil || count != 0 {
+		t.Errorf("missing snapshots = %d, %v; want 0", count, err)
+	}
+}
+
+func TestImportZoteroExportStaysInDirectory(t *testing.T) {
+	withPagesDir(t)
+	outside := filepath.Join(t.TempDir(), "secret.html")
+	if err := os.WriteFile(outside, []byte("<p>secret</p>"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	attachment := func(path string) string {
+		return `<z:Attachment rdf:about="` + path + `"><z:itemType>attachment</z:itemType>` +
+			`<rdf:resource rdf:resource="` + path + `"/><link:type>text/html</link:type></z:Attachment>`
+	}
+	rdf := `<rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#" xmlns:z="http://www.zotero.org/namespaces/export#" xmlns:link="http://purl.org/rss/1.0/modules/link/">` +
+		attachment(outside) + attachment("../"+filepath.Base(filepath.Dir(outside))+"/secret.html") + attachment("files/../../secret.html") + `</rdf:RDF>`
+	count, err := importZoteroExport(writeZoteroExport(t, rdf, nil))
+	if err != nil || count != 0 {
+		t.Errorf("imported %d snapshots outside the export, %v", count, err)
+	}
+
+	for resource, want := range map[string]bool{
+		"files/2/snapshot.html":  true,
+		"files/../snapshot.html": true,
+		"../snapshot.html":       false,
+		"/etc/passwd":            false,
+	} {
+		if _, ok := zoteroSnapshotPath("/exports", resource); ok != want {
+			t.Errorf("zoteroSnapshotPath(%q) = %v, want %v", resource, ok, want)
+		}
+	}
+}
+
+func TestZoteroConnectorItem(t *testing.T) {
+	item := zoteroConnectorItem{
+		ItemType:     "webpage",
+		WebsiteTitle: "Example",
+		Tags:         []json.RawMessage{json.RawMessage(`"plain"`), json.RawMessage(`{"tag":"object"}`), json.RawMessage(`{}`)},
+	}
+	item.Creators = append(item.Creators, struct {
+		FirstName string `json:"firstName"`
+		LastName  string `json:"lastName"`
+		Name      string `json:"name"`
+	}{FirstName: "Ada", LastName: "Lovelace"})
+	if tags := strings.Join(item.tags(), ","); tags != "plain,object" {
+		t.Errorf("tags = %s", tags)
+	}
+	if c := item.citation(); c.Publication != "Example" || strings.Join(c.Authors, ";") != "Ada Lovelace" {
+		t.Errorf("citation = %+v", c)
+	}
+}
+
+func TestZoteroConnectorRefusesWebPages(t *testing.T) {
+	withPagesDir(t)
+	tests := []struct {
+		name    string
+		method  string
+		headers map[string]string
+		want    int
+	}{
+		{"form post from a page", http.MethodPost, map[string]string{"Content-Type": "text/plain", "Origin": "https://evil.example"}, http.StatusBadRequest},
+		{"json from a page", http.MethodPost, map[string]string{"Content-Type": "application/json", "Origin": "https://evil.example"}, http.StatusForbidden},
+		{"json from a rebound name", http.MethodPost, map[string]string{"Content-Type": "application/json", "Origin": "http://example.com"}, http.StatusForbidden},
+		{"get", http.MethodGet, map[string]string{"X-Zotero-Connector-API-Version": "3"}, http.StatusMethodNotAllowed},
+		{"connector", http.MethodPost, map[string]string{"Content-Type": "application/json", "X-Zotero-Connector-API-Version": "3"}, http.StatusCreated},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(tt.method, "/connector/saveSnapshot", strings.NewReader(`{"url":"https://example.com/`+tt.name+`","html":"<p>`+tt.name+`</p>"}`))
+		for key, value := range tt.headers {
+			r.Header.Set(key, value)
+		}
+		w := httptest.NewRecorder()
+		handleZoteroSaveSnapshot(w, r)
+		if w.Code != tt.want {
+			t.Errorf("%s: status %d, want %d", tt.name, w.Code, tt.want)
+		}
+	}
+}
+
+func TestZoteroSessionsAreBounded(t *testing.T) {
+	defer func() {
+		zoteroSessionsMu.Lock()
+		zoteroSessions = make(map[string]zoteroSession)
+		zoteroSessionsMu.Unlock()
+	}()
+	for i := 0; i < maxZoteroSessions+10; i++ {
+		r := httptest.NewRequest(http.MethodPost, "/connector/saveItems", strings.NewReader(`{"sessionID":"s`+strings.Repeat("x", i)+`","items":[]}`))
+		r.Header.Set("Content-Type", "application/json")
+		handleZoteroSaveItems(httptest.NewRecorder(), r)
+	}
+	zoteroSessionsMu.Lock()
+	defer zoteroSessionsMu.Unlock()
+	if len(zoteroSessions) > maxZoteroSessions {
+		t.Errorf("%d sessions kept, want at most %d", len(zoteroSessions), maxZoteroSessions)
+	}
+}
@@ -1,5 +1,9 @@
 // Server configurations
 const SERVER_URL = 'http://localhost:8080';
+const API_URL = `${SERVER_URL}/api/v1`;
+// Native messaging host run by `memento host`, for when the daemon can't be
+// reached over HTTP
+const NATIVE_HOST = 'com.memento.host';
 const SAVE_DIR = 'memento_pages';
 const CAPTURE_DELAY_MS = 10000; // 10 seconds
 const INTERACTION_TRACKING_INTERVAL = 500; // Track interactions every 500ms
@@ -10,6 +14,11 @@ const pendingCaptures = new Map();
 // Track page interactions per tab
 const pageInteractions = new Map();
 
+// Tabs opened to capture a page again for the daemon, closed once saved
+const recaptureTabs = new Set();
+const RECAPTURE_POLL_MINUTES = 5;
+const RECAPTURES_PER_POLL = 3;
+
 // Generate a unique filename based on the URL and timestamp
 function generateFilename(url) {
   const timestamp = new Date().toISOString().replace(/[:.]/g, '-');
@@ -42,14 +51,18 @@ chrome.tabs.onUpdated.addListener((tabId, changeInfo, tab) => {
     clearTimeout(pendingCaptures.get(tabId));
   }
   
-  if (changeInfo.status === 'complete' && tab.url && !tab.url.startsWith('chrome://')) {
+  // Pages in private windows are never captured
+  if (changeInfo.status === 'complete' && tab.url && !tab.url.startsWith('chrome://') && !tab.incognito) {
     // Initialize interaction tracking
     initInteractionTracking(tabId);
     
     // Set a new timeout for this tab
-    const timeoutId = setTimeout(() => {
-      captureAndSavePage(tabId);
+    const timeoutId = setTimeout(async () => {
       pendingCaptures.delete(tabId);
+      await captureAndSavePage(tabId);
+      if (recaptureTabs.delete(tabId)) {
+        chrome.tabs.remove(tabId).catch(() => {});
+      }
     }, CAPTURE_DELAY_MS);
     
     pendingCaptures.set(tabId, timeoutId);
@@ -296,7 +309,7 @@ async function captureAndSavePage(tabId) {
   try {
     // Check if the tab is still alive
     const tab = await chrome.tabs.get(tabId);
-    if (!tab || !tab.url || tab.url.startsWith('chrome://')) {
+    if (!tab || !tab.url || tab.url.startsWith('chrome://') || tab.incognito) {
       return;
     }
     
@@ -328,15 +341,241 @@ async function captureAndSavePage(tabId) {
   }
 }
 
+// Headers for daemon requests, carrying the API token saved in the popup
+async function daemonHeaders() {
+  const { apiToken } = await chrome.storage.local.get(['apiToken']);
+  return apiToken ? { Authorization: `Bearer ${apiToken}` } : {};
+}
+
+// Send a request to the daemon's API. When localhost HTTP is blocked, such
+// as by policy, the request goes through the native messaging host instead,
+// and its reply is returned as a Response
+async function daemonFetch(path, options = {}) {
+  try {
+    return await fetch(`${API_URL}${path}`, options);
+  } catch (error) {
+    const auth = (options.headers || {}).Authorization;
+    let reply;
+    try {
+      reply = await chrome.runtime.sendNativeMessage(NATIVE_HOST, {
+        method: options.method || 'GET',
+        path,
+        token: auth ? auth.replace(/^Bearer /, '') : undefined,
+        body: options.body ? JSON.parse(options.body) : undefined
+      });
+    } catch (nativeError) {
+      console.error('Native host unavailable:', nativeError);
+      throw error;
+    }
+    const body = reply.body === undefined ? null : JSON.stringify(reply.body);
+    return new Response(body, { status: reply.status, headers: { 'Content-Type': 'application/json' } });
+  }
+}
+
+// Get a list of results from the daemon
+async function daemonResults(path) {
+  const response = await daemonFetch(path, {
+    headers: await daemonHeaders()
+  });
+  if (!response.ok) {
+    // The daemon reports failures as {"error": {"code": ..., "message": ...}}
+    const body = await response.json().catch(() => null);
+    const error = new Error(describeDaemonError(body, response.status));
+    error.code = body && body.error ? body.error.code : undefined;
+    throw error;
+  }
+  return await response.json();
+}
+
 // Search for content using the daemon
 async function searchContent(query) {
   try {
-    const response = await fetch(`${SERVER_URL}/search?q=${encodeURIComponent(query)}`);
-    if (!response.ok) throw new Error(`HTTP error! Status: ${response.status}`);
-    return await response.json();
+    return await daemonResults(`/search?q=${encodeURIComponent(query)}`);
   } catch (error) {
     console.error('Search error:', error);
-    return { error: error.message, results: [] };
+    throw error;
+  }
+}
+
+// Find the archived pages most like a page, for its related reads
+async function relatedPages(id) {
+  try {
+    return await daemonResults(`/pages/${encodeURIComponent(id)}/related`);
+  } catch (error) {
+    console.error('Related pages error:', error);
+    throw error;
+  }
+}
+
+// Tell the daemon which search result was opened. It only keeps clicks when
+// it is set to learn from them, so failures are just logged
+async function recordSearchClick(id, query, position) {
+  try {
+    const headers = await daemonHeaders();
+    headers['Content-Type'] = 'application/json';
+    await daemonFetch(`/search/clicks`, {
+      method: 'POST',
+      headers,
+      body: JSON.stringify({ id, query, position })
+    });
+  } catch (error) {
+    console.error('Error recording search click:', error);
+  }
+}
+
+// Describe the selection in the page it's made in: the quoted text, a CSS
+// selector of the element it starts in and its offsets in that element's text
+function describeSelection() {
+  const selection = window.getSelection();
+  if (!selection || selection.isCollapsed) {
+    return null;
+  }
+  const range = selection.getRangeAt(0);
+  let element = range.startContainer;
+  if (element.nodeType !== Node.ELEMENT_NODE) {
+    element = element.parentElement;
+  }
+  const path = [];
+  for (let node = element; node && node.nodeType === Node.ELEMENT_NODE; node = node.parentElement) {
+    if (node.id) {
+      path.unshift(`#${CSS.escape(node.id)}`);
+      break;
+    }
+    let index = 1;
+    for (let sibling = node.previousElementSibling; sibling; sibling = sibling.previousElementSibling) {
+      if (sibling.tagName === node.tagName) {
+        index++;
+      }
+    }
+    path.unshift(`${node.tagName.toLowerCase()}:nth-of-type(${index})`);
+  }
+  const before = document.createRange();
+  before.selectNodeContents(element);
+  before.setEnd(range.startContainer, range.startOffset);
+  const quote = selection.toString();
+  const start = before.toString().length;
+  return { quote, selector: path.join(' > '), start, end: start + quote.length };
+}
+
+// Store the selection as a highlight of the page's latest capture
+async function saveHighlight(tab) {
+  try {
+    const [result] = await chrome.scripting.executeScript({
+      target: { tabId: tab.id },
+      func: describeSelection
+    });
+    if (!result || !result.result) {
+      return;
+    }
+    const headers = await daemonHeaders();
+    headers['Content-Type'] = 'application/json';
+    const response = await daemonFetch(`/highlights`, {
+      method: 'POST',
+      headers,
+      body: JSON.stringify({ url: tab.url, ...result.result })
+    });
+    if (!response.ok) {
+      const body = await response.json().catch(() => null);
+      console.error('Error saving highlight:', describeDaemonError(body, response.status));
+    }
+  } catch (error) {
+    console.error('Error saving highlight:', error);
+  }
+}
+
+chrome.runtime.onInstalled.addListener(() => {
+  chrome.contextMenus.create({
+    id: 'highlight',
+    title: 'Highlight in Memento',
+    contexts: ['selection']
+  });
+});
+
+chrome.contextMenus.onClicked.addListener((info, tab) => {
+  if (info.menuItemId === 'highlight' && tab) {
+    saveHighlight(tab);
+  }
+});
+
+// Open the pages the daemon has queued for re-capture in background tabs,
+// a few at a time, so they are captured again like any visited page
+chrome.alarms.create('recapture', { periodInMinutes: RECAPTURE_POLL_MINUTES });
+chrome.alarms.onAlarm.addListener(async (alarm) => {
+  if (alarm.name !== 'recapture') {
+    return;
+  }
+  try {
+    const response = await daemonFetch(`/recapture/queue?limit=${RECAPTURES_PER_POLL}`, {
+      headers: await daemonHeaders()
+    });
+    if (!response.ok) {
+      return;
+    }
+    for (const entry of await response.json()) {
+      const tab = await chrome.tabs.create({ url: entry.url, active: false });
+      recaptureTabs.add(tab.id);
+    }
+  } catch (error) {
+    console.error('Re-capture queue error:', error);
+  }
+});
+
+// Complete titles and URLs of archived pages in the address bar ("mem <query>")
+chrome.omnibox.onInputChanged.addListener(async (text, suggest) => {
+  if (!text.trim()) {
+    return;
+  }
+  try {
+    const response = await daemonFetch(`/suggest?q=${encodeURIComponent(text)}`, {
+      headers: await daemonHeaders()
+    });
+    if (!response.ok) {
+      return;
+    }
+    const pages = await response.json();
+    suggest(pages.filter(page => page.url).map(page => ({
+      content: page.url,
+      description: escapeOmnibox(page.title || page.url)
+    })));
+  } catch (error) {
+    console.error('Suggest error:', error);
+  }
+});
+
+// Open the chosen page, or a full search when the text isn't a suggestion
+chrome.omnibox.onInputEntered.addListener((text, disposition) => {
+  const url = /^https?:\/\//.test(text)
+    ? text
+    : `${SERVER_URL}/text?q=${encodeURIComponent(text)}`;
+  if (disposition === 'currentTab') {
+    chrome.tabs.update({ url });
+  } else {
+    chrome.tabs.create({ url, active: disposition === 'newForegroundTab' });
+  }
+});
+
+// Omnibox descriptions are XML, so markup in titles must be escaped
+function escapeOmnibox(text) {
+  return text.replace(/&/g, '&amp;').replace(/</g, '&lt;').replace(/>/g, '&gt;')
+    .replace(/"/g, '&quot;').replace(/'/g, '&apos;');
+}
+
+// Turn a daemon error code into a message the user can act on
+function describeDaemonError(body, status) {
+  if (!body || !body.error) {
+    return `HTTP error! Status: ${status}`;
+  }
+  switch (body.error.code) {
+    case 'INDEX_UNAVAILABLE':
+      return 'The search index is unavailable. Is the Memento daemon running correctly?';
+    case 'CAPTURE_TOO_LARGE':
+      return 'This page is too large to archive.';
+    case 'UNSUPPORTED_CONTENT':
+      return 'This content type is not supported.';
+    case 'DUPLICATE':
+      return 'This page is already archived.';
+    default:
+      return body.error.message;
   }
 }
 
@@ -348,7 +587,11 @@ chrome.runtime.onMessage.addListener((request, sender, sendResponse) => {
         sendResponse({ success: false, error: 'No active tab found' });
         return;
       }
-      
+      if (tabs[0].incognito) {
+        sendResponse({ success: false, error: 'Pages in private windows are not captured' });
+        return;
+      }
+
       const metadata = await captureAndSavePage(tabs[0].id);
       if (!metadata) {
         sendResponse({ success: false, error: 'Failed to capture page data' });
@@ -377,6 +620,18 @@ chrome.runtime.onMessage.addListener((request, sender, sendResponse) => {
     return true; // Keep the message channel open for async response
   }
   
+  if (request.action === 'related') {
+    relatedPages(request.id)
+      .then(results => sendResponse({ success: true, results }))
+      .catch(error => sendResponse({ success: false, error: error.message }));
+    return true; // Keep the message channel open for async response
+  }
+  
+  if (request.action === 'searchClick') {
+    recordSearchClick(request.id, request.query, request.position);
+    return false;
+  }
+  
   // Handle interaction data updates from the viewport tracker
   if (request.action === 'updateInteractionData' && sender.tab) {
     const tabId = sender.tab.id;
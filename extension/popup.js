@@ -4,11 +4,27 @@ document.addEventListener('DOMContentLoaded', () => {
   const searchInput = document.getElementById('searchInput');
   const searchBtn = document.getElementById('searchBtn');
   const searchResults = document.getElementById('searchResults');
+  const relatedSection = document.getElementById('relatedSection');
+  const relatedResults = document.getElementById('relatedResults');
   const recentCaptures = document.getElementById('recentCaptures');
+  const tokenInput = document.getElementById('tokenInput');
+  const saveTokenBtn = document.getElementById('saveTokenBtn');
+  const tokenStatus = document.getElementById('tokenStatus');
   
   // Load recent captures when popup opens
   loadRecentCaptures();
   
+  // The API token is sent with daemon requests when tokens are configured
+  chrome.storage.local.get(['apiToken'], (result) => {
+    tokenInput.value = result.apiToken || '';
+  });
+  saveTokenBtn.addEventListener('click', () => {
+    chrome.storage.local.set({ apiToken: tokenInput.value.trim() }, () => {
+      tokenStatus.textContent = 'Token saved';
+      tokenStatus.className = 'status success';
+    });
+  });
+  
   // Handle manual page capture
   manualCaptureBtn.addEventListener('click', () => {
     captureStatus.textContent = 'Capturing page...';
@@ -106,6 +122,15 @@ document.addEventListener('DOMContentLoaded', () => {
     if (e.key === 'Enter') performSearch();
   });
   
+  // Replace a list's contents with a line of text, set as text so that
+  // titles and errors can't put markup into the popup
+  function showMessage(container, text, className) {
+    const message = document.createElement('div');
+    if (className) message.className = className;
+    message.textContent = text;
+    container.replaceChildren(message);
+  }
+  
   function performSearch() {
     const query = searchInput.value.trim();
     if (!query) return;
@@ -117,21 +142,21 @@ document.addEventListener('DOMContentLoaded', () => {
       query: query 
     }, (response) => {
       if (response.success && response.results) {
-        displayResults(response.results);
+        displayResults(query, response.results);
       } else {
-        searchResults.innerHTML = `<div class="status error">Error: ${response.error || 'No results found'}</div>`;
+        showMessage(searchResults, `Error: ${response.error || 'No results found'}`, 'status error');
       }
     });
   }
   
-  function displayResults(results) {
+  function displayResults(query, results) {
     if (results.length === 0) {
       searchResults.innerHTML = '<div>No results found.</div>';
       return;
     }
     
     searchResults.innerHTML = '';
-    results.forEach(result => {
+    results.forEach((result, position) => {
       const resultItem = document.createElement('div');
       resultItem.className = 'result-item';
       
@@ -139,6 +164,7 @@ document.addEventListener('DOMContentLoaded', () => {
       title.className = 'result-title';
       title.textContent = result.title;
       title.addEventListener('click', () => {
+        chrome.runtime.sendMessage({ action: 'searchClick', id: result.id, query, position });
         chrome.tabs.create({ url: result.url });
       });
       
@@ -150,10 +176,54 @@ document.addEventListener('DOMContentLoaded', () => {
       snippet.className = 'result-snippet';
       snippet.textContent = result.snippet;
       
+      const related = document.createElement('div');
+      related.className = 'result-related';
+      related.textContent = 'Related reads';
+      related.addEventListener('click', () => showRelated(result));
+      
       resultItem.appendChild(title);
       resultItem.appendChild(url);
       resultItem.appendChild(snippet);
+      resultItem.appendChild(related);
       searchResults.appendChild(resultItem);
     });
   }
+  
+  // List the archived pages most like a result, to read next
+  function showRelated(result) {
+    relatedSection.hidden = false;
+    relatedResults.innerHTML = '<div>Finding related pages...</div>';
+    
+    chrome.runtime.sendMessage({ action: 'related', id: result.id }, (response) => {
+      if (!response.success) {
+        showMessage(relatedResults, `Error: ${response.error || 'Unknown error'}`, 'status error');
+        return;
+      }
+      if (response.results.length === 0) {
+        showMessage(relatedResults, `Nothing else like ${result.title} yet.`);
+        return;
+      }
+      
+      relatedResults.innerHTML = '';
+      response.results.forEach(page => {
+        const relatedItem = document.createElement('div');
+        relatedItem.className = 'result-item';
+        
+        const title = document.createElement('div');
+        title.className = 'result-title';
+        title.textContent = page.title;
+        title.addEventListener('click', () => {
+          chrome.tabs.create({ url: page.url });
+        });
+        
+        const url = document.createElement('div');
+        url.className = 'result-url';
+        url.textContent = page.url;
+        
+        relatedItem.appendChild(title);
+        relatedItem.appendChild(url);
+        relatedResults.appendChild(relatedItem);
+      });
+    });
+  }
 });